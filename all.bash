@@ -1,16 +1,16 @@
 #!/bin/sh
 set -eu
 
-for d in fuse fuse/nodefs fuse/pathfs fuse/test zipfs unionfs \
+for d in fuse fuse/nodefs fuse/pathfs fuse/posixtest fuse/protocol fuse/test zipfs unionfs joinfs metricsfs autofs \
     example/hello example/loopback example/zipfs \
     example/multizip example/unionfs example/memfs \
-    example/autounionfs example/statfs ; \
+    example/autounionfs example/statfs example/gofuse-mount ; \
 do
   go build -o /dev/null github.com/hanwen/go-fuse/${d}
 done
 
 
-for d in fuse zipfs unionfs fuse/test
+for d in fuse fuse/protocol zipfs unionfs joinfs metricsfs autofs fuse/test
 do
     (
         cd $d
@@ -20,6 +20,14 @@ do
           export GOOS
           go test -c -i github.com/hanwen/go-fuse/$d
         done
+        unset GOOS
+
+        # The wire structs are overlaid on kernel memory, so check
+        # 32-bit and big-endian ABIs too.
+        for GOARCH in 386 arm ppc64 s390x ; do
+          GOARCH=$GOARCH go test -c -o /dev/null github.com/hanwen/go-fuse/$d
+        done
+        GOARCH=386 go test github.com/hanwen/go-fuse/$d
 
         echo "go test github.com/hanwen/go-fuse/$d"
         go test github.com/hanwen/go-fuse/$d
@@ -29,10 +37,10 @@ do
 done
 
 for target in "clean" "install" ; do
-  for d in fuse fuse/nodefs fuse/pathfs fuse/test zipfs unionfs \
+  for d in fuse fuse/nodefs fuse/pathfs fuse/posixtest fuse/protocol fuse/test zipfs unionfs joinfs metricsfs autofs \
     example/hello example/loopback example/zipfs \
     example/multizip example/unionfs example/memfs \
-    example/autounionfs example/statfs ; \
+    example/autounionfs example/statfs example/gofuse-mount ; \
   do
     if test "${target}" = "install" && test "${d}" = "fuse/test"; then
       continue
@@ -0,0 +1,252 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package autofs provides a directory whose entries are file systems
+// that are only instantiated when they are first looked up, and are
+// unmounted again once they have been idle for a while. This allows
+// exposing hundreds of remote sources without keeping a connection
+// to each of them.
+package autofs
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// ConnectFunc instantiates a backend, returning the root of its
+// file system. It is called on first access to the entry, and again
+// on the first access after the backend was unmounted. Resources
+// held by the backend should be released in the root's OnUnmount
+// method.
+type ConnectFunc func() (nodefs.Node, error)
+
+type AutoFsOptions struct {
+	// Options for the mounted backends. If nil, the options of
+	// the AutoFs mount are used.
+	MountOptions *nodefs.Options
+
+	// Backends are unmounted once the kernel has sent no
+	// requests for them for this long, as long as they have no
+	// open files. If zero, backends stay mounted once connected.
+	IdleTimeout time.Duration
+
+	Debug bool
+}
+
+// AutoFs is a directory of lazily mounted backends.
+type AutoFs struct {
+	nodefs.Node
+	options AutoFsOptions
+
+	mu       sync.Mutex
+	conn     *nodefs.FileSystemConnector
+	backends map[string]*backend
+	done     chan struct{}
+}
+
+type backend struct {
+	connect ConnectFunc
+
+	// Serializes connecting, so concurrent lookups only connect
+	// once.
+	connectMu sync.Mutex
+
+	// Protected by AutoFs.mu. lastUsed is the time of the last
+	// Lookup, or failed unmount; requests inside the backend are
+	// tracked by the connector.
+	mounted  bool
+	lastUsed time.Time
+}
+
+// NewAutoFs returns a root node listing the given backends.
+func NewAutoFs(backends map[string]ConnectFunc, options *AutoFsOptions) *AutoFs {
+	fs := &AutoFs{
+		Node:     nodefs.NewDefaultNode(),
+		backends: make(map[string]*backend),
+	}
+	if options != nil {
+		fs.options = *options
+	}
+	for k, v := range backends {
+		fs.backends[k] = &backend{connect: v}
+	}
+	return fs
+}
+
+func (fs *AutoFs) String() string {
+	return "autofs"
+}
+
+// AddBackend adds an entry to the directory. It returns false if the
+// name is already taken.
+func (fs *AutoFs) AddBackend(name string, connect ConnectFunc) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.backends[name] != nil {
+		return false
+	}
+	fs.backends[name] = &backend{connect: connect}
+	return true
+}
+
+// Mounted returns the names of the backends that are currently
+// connected.
+func (fs *AutoFs) Mounted() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var names []string
+	for k, b := range fs.backends {
+		if b.mounted {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (fs *AutoFs) OnMount(conn *nodefs.FileSystemConnector) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.conn = conn
+	if fs.options.IdleTimeout > 0 {
+		fs.done = make(chan struct{})
+		go fs.expireLoop(fs.done)
+	}
+}
+
+func (fs *AutoFs) OnUnmount() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.done != nil {
+		close(fs.done)
+		fs.done = nil
+	}
+}
+
+func (fs *AutoFs) Deletable() bool {
+	return false
+}
+
+func (fs *AutoFs) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func (fs *AutoFs) OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	stream := make([]fuse.DirEntry, 0, len(fs.backends))
+	for k := range fs.backends {
+		stream = append(stream, fuse.DirEntry{
+			Name: k,
+			Mode: fuse.S_IFDIR,
+		})
+	}
+	return stream, fuse.OK
+}
+
+// Lookup connects the backend on first access. Mounted backends are
+// found by the connector without calling Lookup.
+func (fs *AutoFs) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+	fs.mu.Lock()
+	b := fs.backends[name]
+	conn := fs.conn
+	fs.mu.Unlock()
+	if b == nil {
+		return nil, fuse.ENOENT
+	}
+
+	b.connectMu.Lock()
+	defer b.connectMu.Unlock()
+
+	ch := fs.Inode().GetChild(name)
+	if ch == nil {
+		root, err := b.connect()
+		if err != nil {
+			log.Printf("autofs: connect %q: %v", name, err)
+			return nil, fuse.EIO
+		}
+		if code := conn.Mount(fs.Inode(), name, root, fs.options.MountOptions); !code.Ok() {
+			root.OnUnmount()
+			return nil, code
+		}
+		if fs.options.Debug {
+			log.Printf("autofs: mounted %q", name)
+		}
+		ch = fs.Inode().GetChild(name)
+	}
+
+	fs.mu.Lock()
+	b.mounted = true
+	b.lastUsed = time.Now()
+	fs.mu.Unlock()
+
+	return ch, ch.Node().GetAttr(out, nil, context)
+}
+
+func (fs *AutoFs) expireLoop(done chan struct{}) {
+	ticker := time.NewTicker(fs.options.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			fs.expire(now)
+		}
+	}
+}
+
+// expire unmounts backends that were not used since IdleTimeout
+// before now. A backend that cannot be unmounted because it has open
+// files counts as used.
+func (fs *AutoFs) expire(now time.Time) {
+	fs.mu.Lock()
+	var idle []string
+	for k, b := range fs.backends {
+		if !b.mounted {
+			continue
+		}
+		last := b.lastUsed
+		if ch := fs.Inode().GetChild(k); ch != nil {
+			if t := fs.conn.LastUsed(ch); t.After(last) {
+				last = t
+			}
+		}
+		if now.Sub(last) >= fs.options.IdleTimeout {
+			idle = append(idle, k)
+		}
+	}
+	fs.mu.Unlock()
+
+	for _, name := range idle {
+		fs.mu.Lock()
+		b := fs.backends[name]
+		fs.mu.Unlock()
+
+		b.connectMu.Lock()
+		code := fuse.OK
+		if ch := fs.Inode().GetChild(name); ch != nil {
+			code = fs.conn.Unmount(ch)
+		}
+
+		fs.mu.Lock()
+		if code.Ok() {
+			b.mounted = false
+		} else {
+			b.lastUsed = now
+		}
+		fs.mu.Unlock()
+		b.connectMu.Unlock()
+
+		if fs.options.Debug {
+			log.Printf("autofs: unmount %q: %v", name, code)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package autofs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+type dirNode struct {
+	nodefs.Node
+}
+
+func (n *dirNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func TestAutoFsExpire(t *testing.T) {
+	timeout := time.Hour
+	fs := NewAutoFs(map[string]ConnectFunc{
+		"a": func() (nodefs.Node, error) {
+			return &dirNode{nodefs.NewDefaultNode()}, nil
+		},
+	}, &AutoFsOptions{IdleTimeout: timeout})
+
+	conn := nodefs.NewFileSystemConnector(fs, nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	entry, code := k.Lookup(fuse.FUSE_ROOT_ID, "a")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+
+	// Pretend the lookup was long ago; the backend is still in
+	// use, as requests go to it directly.
+	fs.mu.Lock()
+	fs.backends["a"].lastUsed = time.Now().Add(-2 * timeout)
+	fs.mu.Unlock()
+	if _, code := k.GetAttr(entry.NodeId); !code.Ok() {
+		t.Fatalf("GetAttr: %v", code)
+	}
+	fs.expire(time.Now())
+	if got := fs.Mounted(); len(got) != 1 {
+		t.Fatalf("active backend was unmounted, mounted: %v", got)
+	}
+
+	// The kernel drops the node, and the backend goes idle.
+	k.Forget(entry.NodeId, 1)
+	fs.expire(time.Now().Add(2 * timeout))
+	if got := fs.Mounted(); len(got) != 0 {
+		t.Fatalf("idle backend stayed mounted: %v", got)
+	}
+
+	// It is connected again on the next access.
+	if _, code := k.Lookup(fuse.FUSE_ROOT_ID, "a"); !code.Ok() {
+		t.Fatalf("Lookup after expiry: %v", code)
+	}
+	if got := fs.Mounted(); len(got) != 1 {
+		t.Errorf("got mounted %v after lookup", got)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package autofs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+func TestAutoFsLazyConnect(t *testing.T) {
+	connects := map[string]int{}
+	backend := func(name string) ConnectFunc {
+		return func() (nodefs.Node, error) {
+			connects[name]++
+			return nodefs.NewDefaultNode(), nil
+		}
+	}
+	fs := NewAutoFs(map[string]ConnectFunc{
+		"a": backend("a"),
+		"b": backend("b"),
+		"broken": func() (nodefs.Node, error) {
+			return nil, fmt.Errorf("unreachable")
+		},
+	}, nil)
+
+	conn := nodefs.NewFileSystemConnector(fs, nil)
+	fs.OnMount(conn)
+
+	stream, code := fs.OpenDir(nil)
+	if !code.Ok() || len(stream) != 3 {
+		t.Fatalf("OpenDir: %v %v", stream, code)
+	}
+	if len(connects) != 0 {
+		t.Errorf("OpenDir connected backends: %v", connects)
+	}
+
+	var a fuse.Attr
+	for i := 0; i < 2; i++ {
+		ch, code := fs.Lookup(&a, "a", nil)
+		if !code.Ok() {
+			t.Fatalf("Lookup: %v", code)
+		}
+		if ch != fs.Inode().GetChild("a") || !ch.IsDir() {
+			t.Errorf("Lookup returned %v", ch)
+		}
+	}
+	if connects["a"] != 1 || connects["b"] != 0 {
+		t.Errorf("got connects %v, want a once", connects)
+	}
+	if got := fs.Mounted(); len(got) != 1 || got[0] != "a" {
+		t.Errorf("Mounted: got %v", got)
+	}
+
+	if _, code := fs.Lookup(&a, "broken", nil); code != fuse.EIO {
+		t.Errorf("Lookup broken: got %v, want EIO", code)
+	}
+	if _, code := fs.Lookup(&a, "nonexistent", nil); code != fuse.ENOENT {
+		t.Errorf("Lookup nonexistent: got %v, want ENOENT", code)
+	}
+
+	if fs.AddBackend("a", backend("a")) {
+		t.Errorf("AddBackend accepted duplicate name")
+	}
+}
@@ -11,43 +11,147 @@ import (
 
 type latencyMapEntry struct {
 	count int
-	dur   time.Duration
+
+	// The number of timed requests, and the sum of their
+	// latencies.
+	samples int
+	dur     time.Duration
+
+	// Payload bytes; see fuse.ByteCounter.
+	bytes int64
 }
 
 type LatencyMap struct {
 	sync.Mutex
 	stats map[string]*latencyMapEntry
+	rate  int
 }
 
 func NewLatencyMap() *LatencyMap {
-	m := &LatencyMap{}
+	return NewSampledLatencyMap(1)
+}
+
+// NewSampledLatencyMap returns a LatencyMap that asks the server to
+// time only 1 in rate requests. All requests are counted, and Get
+// extrapolates the total latency from the samples.
+func NewSampledLatencyMap(rate int) *LatencyMap {
+	if rate < 1 {
+		rate = 1
+	}
+	m := &LatencyMap{rate: rate}
 	m.stats = make(map[string]*latencyMapEntry)
 	return m
 }
 
+// SampleRate implements fuse.SamplingLatencyMap.
+func (m *LatencyMap) SampleRate() int {
+	return m.rate
+}
+
+// Get returns the number of requests, and their total latency.
 func (m *LatencyMap) Get(name string) (count int, dt time.Duration) {
 	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
 	l := m.stats[name]
-	m.Mutex.Unlock()
 	if l == nil {
 		return 0, 0
 	}
+	return l.count, l.total()
+}
 
-	return l.count, l.dur
+// total extrapolates the latency of all requests from the samples.
+func (l *latencyMapEntry) total() time.Duration {
+	if l.samples == 0 || l.samples == l.count {
+		return l.dur
+	}
+	return time.Duration(float64(l.dur) * float64(l.count) / float64(l.samples))
 }
 
-func (m *LatencyMap) Add(name string, dt time.Duration) {
+// Latency is the number of requests for an operation, and their
+// total latency, as returned by Get, and the payload bytes they
+// carried.
+type Latency struct {
+	Count int
+	Total time.Duration
+	Bytes int64
+}
+
+// LatencySnapshot is a copy of a LatencyMap at one point in time.
+type LatencySnapshot struct {
+	Time time.Time
+	Ops  map[string]Latency
+}
+
+// Snapshot returns a copy of the map, which later requests do not
+// change.
+func (m *LatencyMap) Snapshot() LatencySnapshot {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	return m.snapshot()
+}
+
+// Reset clears the map, and returns its contents from just before.
+func (m *LatencyMap) Reset() LatencySnapshot {
 	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	s := m.snapshot()
+	m.stats = make(map[string]*latencyMapEntry)
+	return s
+}
+
+func (m *LatencyMap) snapshot() LatencySnapshot {
+	s := LatencySnapshot{
+		Time: time.Now(),
+		Ops:  make(map[string]Latency, len(m.stats)),
+	}
+	for k, l := range m.stats {
+		s.Ops[k] = Latency{l.count, l.total(), l.bytes}
+	}
+	return s
+}
+
+func (m *LatencyMap) entry(name string) *latencyMapEntry {
 	e := m.stats[name]
 	if e == nil {
 		e = new(latencyMapEntry)
 		m.stats[name] = e
 	}
+	return e
+}
+
+func (m *LatencyMap) Add(name string, dt time.Duration) {
+	m.Mutex.Lock()
+	e := m.entry(name)
 	e.count++
+	e.samples++
 	e.dur += dt
 	m.Mutex.Unlock()
 }
 
+// Count records a request that was not timed.
+func (m *LatencyMap) Count(name string) {
+	m.Mutex.Lock()
+	m.entry(name).count++
+	m.Mutex.Unlock()
+}
+
+// AddBytes implements fuse.ByteCounter.
+func (m *LatencyMap) AddBytes(name string, n int64) {
+	m.Mutex.Lock()
+	m.entry(name).bytes += n
+	m.Mutex.Unlock()
+}
+
+// Bytes returns the payload bytes of the requests for name.
+func (m *LatencyMap) Bytes(name string) int64 {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	if l := m.stats[name]; l != nil {
+		return l.bytes
+	}
+	return 0
+}
+
 func (m *LatencyMap) Counts() map[string]int {
 	r := make(map[string]int)
 	m.Mutex.Lock()
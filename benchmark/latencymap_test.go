@@ -18,3 +18,55 @@ func TestLatencyMap(t *testing.T) {
 		t.Errorf("got %v, %d, want 2, 150ms", c, d)
 	}
 }
+
+func TestSampledLatencyMap(t *testing.T) {
+	m := NewSampledLatencyMap(10)
+	if m.SampleRate() != 10 {
+		t.Errorf("SampleRate: got %d", m.SampleRate())
+	}
+	for i := 0; i < 9; i++ {
+		m.Count("foo")
+	}
+	m.Add("foo", 100*time.Millisecond)
+	m.Add("foo", 300*time.Millisecond)
+	c, d := m.Get("foo")
+	// 11 requests with a sampled mean of 200ms.
+	if c != 11 || d != 2200*time.Millisecond {
+		t.Errorf("got %v, %v, want 11, 2.2s", c, d)
+	}
+	if got := m.Counts()["foo"]; got != 11 {
+		t.Errorf("Counts: got %d, want 11", got)
+	}
+}
+
+func TestLatencyMapSnapshot(t *testing.T) {
+	m := NewLatencyMap()
+	m.Add("foo", 100*time.Millisecond)
+	s := m.Snapshot()
+	m.Add("foo", 100*time.Millisecond)
+	if got := s.Ops["foo"]; got != (Latency{Count: 1, Total: 100 * time.Millisecond}) {
+		t.Errorf("Snapshot: got %v", got)
+	}
+
+	s = m.Reset()
+	if got := s.Ops["foo"]; got != (Latency{Count: 2, Total: 200 * time.Millisecond}) {
+		t.Errorf("Reset: got %v", got)
+	}
+	if c, _ := m.Get("foo"); c != 0 || len(m.Snapshot().Ops) != 0 {
+		t.Errorf("after Reset: got count %d", c)
+	}
+}
+
+func TestLatencyMapBytes(t *testing.T) {
+	m := NewLatencyMap()
+	m.AddBytes("READ", 4096)
+	m.Add("READ", time.Millisecond)
+	m.AddBytes("READ", 100)
+	m.Add("READ", time.Millisecond)
+	if got := m.Bytes("READ"); got != 4196 {
+		t.Errorf("Bytes: got %d, want 4196", got)
+	}
+	if got := m.Snapshot().Ops["READ"]; got != (Latency{Count: 2, Total: 2 * time.Millisecond, Bytes: 4196}) {
+		t.Errorf("Snapshot: got %v", got)
+	}
+}
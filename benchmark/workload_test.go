@@ -0,0 +1,272 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package benchmark
+
+// End-to-end workloads through a mounted file system. Each benchmark
+// runs on a MemNodeFs and on a loopback file system, and reports the
+// number of FUSE operations per iteration for each opcode, eg.
+// "LOOKUP/op", next to the wall time. Run with -test.v for the
+// latency of each opcode.
+//
+// The tree is synthetic, with a shape loosely modeled on a kernel
+// source tree. To untar a real tree instead, point $GOFUSE_BENCH_TAR
+// at an uncompressed tarball, eg. linux-4.9.tar.
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+var (
+	treeOnce sync.Once
+	treeTar  []byte
+	treeErr  error
+)
+
+// sourceTree returns a tarball to extract. It is generated once
+// per process, and is the same on each run. If reading it failed,
+// each benchmark that asks for it fails.
+func sourceTree(b *testing.B) []byte {
+	treeOnce.Do(func() {
+		if name := os.Getenv("GOFUSE_BENCH_TAR"); name != "" {
+			treeTar, treeErr = ioutil.ReadFile(name)
+			return
+		}
+		treeTar = syntheticTree()
+	})
+	if treeErr != nil {
+		b.Fatalf("ReadFile: %v", treeErr)
+	}
+	return treeTar
+}
+
+// syntheticTree generates ~2000 small source files in a few levels
+// of directories.
+func syntheticTree() []byte {
+	rnd := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+
+	line := []byte("static int frobnicate(struct device *dev) { return 0; }\n")
+	for _, top := range []string{"arch", "drivers", "fs", "include", "kernel", "mm", "net"} {
+		for i := 0; i < 6; i++ {
+			for j := 0; j < 5; j++ {
+				dir := fmt.Sprintf("%s/sub%d/dir%d", top, i, j)
+				w.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755})
+				for k := 0; k < 10; k++ {
+					content := bytes.Repeat(line, 1+rnd.Intn(200))
+					if rnd.Intn(20) == 0 {
+						content = append(content, "/* needle */\n"...)
+					}
+					w.WriteHeader(&tar.Header{
+						Name:     fmt.Sprintf("%s/file%d.c", dir, k),
+						Typeflag: tar.TypeReg,
+						Mode:     0644,
+						Size:     int64(len(content)),
+					})
+					w.Write(content)
+				}
+			}
+		}
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func untar(data []byte, dest string) error {
+	r := tar.NewReader(bytes.NewReader(data))
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := filepath.Join(dest, h.Name)
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			// Tarballs don't always list directories.
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, r); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(h.Linkname, p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// workloadFS describes a file system to run workloads against.
+type workloadFS struct {
+	name  string
+	mount func(b *testing.B) (mnt string, server *fuse.Server)
+}
+
+var workloadFSes = []workloadFS{
+	{"MemNodeFs", func(b *testing.B) (string, *fuse.Server) {
+		back := testutil.TempDir()
+		b.Cleanup(func() { os.RemoveAll(back) })
+		conn := nodefs.NewFileSystemConnector(nodefs.NewMemNodeFSRoot(back+"/"), nil)
+		return testutil.Mount(b, conn.RawFS(), nil)
+	}},
+	{"Loopback", func(b *testing.B) (string, *fuse.Server) {
+		back := testutil.TempDir()
+		b.Cleanup(func() { os.RemoveAll(back) })
+		nfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(back), nil)
+		conn := nodefs.NewFileSystemConnector(nfs.Root(), nil)
+		return testutil.Mount(b, conn.RawFS(), nil)
+	}},
+}
+
+// runWorkload mounts each file system, calls setup, and then times
+// b.N runs of op, recording the FUSE operations they cause.
+func runWorkload(b *testing.B, setup func(b *testing.B, mnt string), op func(b *testing.B, mnt string, i int)) {
+	for _, wfs := range workloadFSes {
+		b.Run(wfs.name, func(b *testing.B) {
+			mnt, server := wfs.mount(b)
+			if setup != nil {
+				setup(b, mnt)
+			}
+
+			lmap := NewLatencyMap()
+			server.RecordLatencies(lmap)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				op(b, mnt, i)
+			}
+			b.StopTimer()
+			server.RecordLatencies(nil)
+			reportLatencies(b, lmap)
+		})
+	}
+}
+
+// reportLatencies reports the opcode counts per iteration as
+// benchmark metrics, and logs their latencies.
+func reportLatencies(b *testing.B, lmap *LatencyMap) {
+	counts := lmap.Counts()
+	var names []string
+	for n := range counts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		count, dt := lmap.Get(n)
+		b.ReportMetric(float64(count)/float64(b.N), n+"/op")
+		b.Logf("%-12s n=%-8d %v/call", n, count, dt/time.Duration(count))
+	}
+}
+
+func BenchmarkUntar(b *testing.B) {
+	data := sourceTree(b)
+	b.SetBytes(int64(len(data)))
+	runWorkload(b, nil, func(b *testing.B, mnt string, i int) {
+		if err := untar(data, filepath.Join(mnt, fmt.Sprintf("tree%d", i))); err != nil {
+			b.Fatalf("untar: %v", err)
+		}
+	})
+}
+
+func populate(b *testing.B, mnt string) {
+	if err := untar(sourceTree(b), mnt); err != nil {
+		b.Fatalf("untar: %v", err)
+	}
+}
+
+// BenchmarkGrep reads all files, as "grep -r" would.
+func BenchmarkGrep(b *testing.B) {
+	needle := []byte("needle")
+	runWorkload(b, populate, func(b *testing.B, mnt string, i int) {
+		matches := 0
+		err := filepath.Walk(mnt, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.Mode().IsRegular() {
+				return err
+			}
+			content, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if bytes.Contains(content, needle) {
+				matches++
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatalf("Walk: %v", err)
+		}
+		if matches == 0 {
+			b.Fatalf("no matches")
+		}
+	})
+}
+
+// BenchmarkParallelStat stats every file from GOMAXPROCS goroutines.
+func BenchmarkParallelStat(b *testing.B) {
+	var files []string
+	setup := func(b *testing.B, mnt string) {
+		populate(b, mnt)
+		files = nil
+		filepath.Walk(mnt, func(p string, fi os.FileInfo, err error) error {
+			files = append(files, p)
+			return err
+		})
+	}
+
+	runWorkload(b, setup, func(b *testing.B, mnt string, i int) {
+		threads := runtime.GOMAXPROCS(0)
+		var wg sync.WaitGroup
+		errs := make(chan error, threads)
+		for t := 0; t < threads; t++ {
+			wg.Add(1)
+			go func(t int) {
+				defer wg.Done()
+				for j := t; j < len(files); j += threads {
+					if _, err := os.Lstat(files[j]); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(t)
+		}
+		wg.Wait()
+		close(errs)
+		if err := <-errs; err != nil {
+			b.Fatalf("Lstat: %v", err)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// gofuse-mount mounts one of the file systems that come with go-fuse.
+//
+// Usage:
+//
+//	gofuse-mount [flags] TYPE:SOURCE MOUNTPOINT [-o OPTION[,OPTION...]]
+//
+// where TYPE:SOURCE is one of
+//
+//	loopback:DIR         mirror DIR
+//	zip:ARCHIVE          serve a .zip, .tar, .tar.gz or .tar.bz2 file
+//	memfs:PREFIX         keep files in memory, backed by files named PREFIX*
+//	union:RW:RO[:RO...]  union of a writable directory over read-only ones
+//	join:DIR:DIR[:DIR...] pool directories, creating files where there is most space
+//
+// The -o options follow the conventions of mount(8), so the command
+// can be used as a mount helper from /etc/fstab:
+//
+//	loopback:/srv/data  /mnt/data  fuse.gofuse-mount  allow_other,max_write=131072  0  0
+//
+// for which mount.fuse runs
+//
+//	gofuse-mount loopback:/srv/data /mnt/data -o rw,allow_other,max_write=131072
+//
+// Each option is also available as a flag, eg. -max_write=131072; run
+// with -h for the list. Other options are passed to fusermount, except
+// those that only mean something to mount(8), such as noauto and
+// _netdev.
+//
+// The command returns once the file system is mounted, leaving a
+// background process to serve it. With -f, it serves the file system
+// itself, and returns when it is unmounted.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/joinfs"
+	"github.com/hanwen/go-fuse/unionfs"
+	"github.com/hanwen/go-fuse/zipfs"
+)
+
+// daemonEnv is set for the background process. Its status pipe is
+// file descriptor 3.
+const daemonEnv = "GOFUSE_MOUNT_DAEMON"
+
+func usage(fs *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] TYPE:SOURCE MOUNTPOINT [-o OPTIONS]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "types: loopback:DIR, zip:ARCHIVE, memfs:PREFIX, union:RW:RO[:RO...], join:DIR:DIR[:DIR...]\n\nflags:\n")
+	fs.PrintDefaults()
+}
+
+func main() {
+	c := &config{
+		// Compatible with libfuse defaults.
+		node: nodefs.Options{
+			EntryTimeout: time.Second,
+			AttrTimeout:  time.Second,
+		},
+	}
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.Usage = func() { usage(fs) }
+	c.register(fs)
+
+	// mount.fuse puts the options after the arguments.
+	var args []string
+	rest := os.Args[1:]
+	for {
+		fs.Parse(rest)
+		rest = fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		args = append(args, rest[0])
+		rest = rest[1:]
+	}
+	if len(args) != 2 {
+		usage(fs)
+		os.Exit(2)
+	}
+
+	if !c.foreground && os.Getenv(daemonEnv) == "" {
+		if err := daemonize(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var status *os.File
+	if os.Getenv(daemonEnv) != "" {
+		status = os.NewFile(3, "status")
+	}
+	if err := serve(c, args[0], args[1], status); err != nil {
+		if status != nil {
+			fmt.Fprintf(status, "%v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// daemonize starts this command again in the background, and waits
+// for it to report that the file system is mounted.
+func daemonize() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	msg, _ := io.ReadAll(r)
+	if s := string(msg); s != "ok\n" {
+		if s == "" {
+			s = fmt.Sprintf("%s: %v", exe, cmd.Wait())
+		}
+		return fmt.Errorf("%s", strings.TrimSpace(s))
+	}
+	return cmd.Process.Release()
+}
+
+// newRoot returns the root node for a TYPE:SOURCE argument.
+func newRoot(source string) (nodefs.Node, error) {
+	typ, arg, _ := strings.Cut(source, ":")
+	if arg == "" {
+		return nil, fmt.Errorf("%q: want TYPE:SOURCE", source)
+	}
+	switch typ {
+	case "loopback":
+		fs := pathfs.NewLoopbackFileSystem(arg)
+		return pathfs.NewPathNodeFs(fs, &pathfs.PathNodeFsOptions{ClientInodes: true}).Root(), nil
+	case "zip":
+		return zipfs.NewArchiveFileSystem(arg)
+	case "memfs":
+		return nodefs.NewMemNodeFSRoot(arg), nil
+	case "union":
+		roots := strings.Split(arg, ":")
+		if len(roots) < 2 {
+			return nil, fmt.Errorf("%q: want union:RW:RO[:RO...]", source)
+		}
+		fs, err := unionfs.NewUnionFsFromRoots(roots, &unionfs.UnionFsOptions{
+			DeletionCacheTTL: 5 * time.Second,
+			BranchCacheTTL:   5 * time.Second,
+			DeletionDirName:  "GOUNIONFS_DELETIONS",
+		}, true)
+		if err != nil {
+			return nil, err
+		}
+		return pathfs.NewPathNodeFs(fs, &pathfs.PathNodeFsOptions{ClientInodes: true}).Root(), nil
+	case "join":
+		dirs := strings.Split(arg, ":")
+		if len(dirs) < 2 {
+			return nil, fmt.Errorf("%q: want join:DIR:DIR[:DIR...]", source)
+		}
+		var branches []pathfs.FileSystem
+		for _, d := range dirs {
+			branches = append(branches, pathfs.NewLoopbackFileSystem(d))
+		}
+		fs := joinfs.NewJoinFs(branches, nil)
+		return pathfs.NewPathNodeFs(fs, &pathfs.PathNodeFsOptions{ClientInodes: true}).Root(), nil
+	}
+	return nil, fmt.Errorf("unknown file system type %q", typ)
+}
+
+// serve mounts the file system and serves it until it is unmounted.
+// Once mounted, it writes "ok" to status, if given, and closes it.
+func serve(c *config, source, mountPoint string, status *os.File) error {
+	root, err := newRoot(source)
+	if err != nil {
+		return err
+	}
+	if c.mount.FsName == "" {
+		c.mount.FsName = source
+	}
+	if c.mount.Name == "" {
+		c.mount.Name, _, _ = strings.Cut(source, ":")
+	}
+
+	conn := nodefs.NewFileSystemConnector(root, &c.node)
+	server, err := fuse.NewServer(conn.RawFS(), mountPoint, &c.mount)
+	if err != nil {
+		return fmt.Errorf("mount %s: %v", mountPoint, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.Serve()
+		close(done)
+	}()
+	if err := server.WaitMount(); err != nil {
+		server.Unmount()
+		return fmt.Errorf("mount %s: %v", mountPoint, err)
+	}
+	if status != nil {
+		fmt.Fprintf(status, "ok\n")
+		status.Close()
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-sigs:
+			if err := server.Unmount(); err != nil {
+				fmt.Fprintf(os.Stderr, "unmount %s: %v\n", mountPoint, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// config collects the settings from flags and -o options.
+type config struct {
+	mount      fuse.MountOptions
+	node       nodefs.Options
+	foreground bool
+}
+
+// option is a setting that can be given both as -o NAME[=VALUE] and
+// as a flag -NAME[=VALUE].
+type option struct {
+	name string
+	help string
+
+	// isBool options take no value in -o lists.
+	isBool bool
+	set    func(c *config, val string) error
+}
+
+func boolOption(name, help string, set func(c *config)) option {
+	return option{name, help, true, func(c *config, val string) error {
+		set(c)
+		return nil
+	}}
+}
+
+func intOption(name, help string, field func(c *config) *int) option {
+	return option{name, help, false, func(c *config, val string) error {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func stringOption(name, help string, field func(c *config) *string) option {
+	return option{name, help, false, func(c *config, val string) error {
+		*field(c) = val
+		return nil
+	}}
+}
+
+// timeoutOption takes seconds, as libfuse does.
+func timeoutOption(name, help string, field func(c *config) *time.Duration) option {
+	return option{name, help, false, func(c *config, val string) error {
+		secs, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = time.Duration(secs * float64(time.Second))
+		return nil
+	}}
+}
+
+func qos(c *config) *fuse.QoS {
+	if c.mount.QoS == nil {
+		c.mount.QoS = &fuse.QoS{}
+	}
+	return c.mount.QoS
+}
+
+// options covers the MountOptions fields that can be expressed on a
+// command line, and the nodefs timeouts.
+var options = []option{
+	boolOption("allow_other", "allow access by other users", func(c *config) { c.mount.AllowOther = true }),
+	boolOption("debug", "print debugging messages", func(c *config) {
+		c.mount.Debug = true
+		c.node.Debug = true
+	}),
+	intOption("max_background", "number of outstanding async requests", func(c *config) *int { return &c.mount.MaxBackground }),
+	intOption("max_readers", "number of goroutines reading requests", func(c *config) *int { return &c.mount.MaxReaders }),
+	intOption("max_inflight_bytes", "stop reading requests while WRITEs hold this many bytes", func(c *config) *int { return &c.mount.MaxInflightBytes }),
+	intOption("max_write", "maximum WRITE size", func(c *config) *int { return &c.mount.MaxWrite }),
+	intOption("max_readahead", "bytes to read ahead; above the kernel default needs root", func(c *config) *int { return &c.mount.MaxReadAhead }),
+	boolOption("ignore_security_labels", "answer security xattr requests with NO_DATA", func(c *config) { c.mount.IgnoreSecurityLabels = true }),
+	boolOption("remember_inodes", "never forget inodes", func(c *config) { c.mount.RememberInodes = true }),
+	boolOption("create_mount_point", "create the mount point if needed", func(c *config) { c.mount.CreateMountPoint = true }),
+	stringOption("fsname", "first column in df; default SOURCE", func(c *config) *string { return &c.mount.FsName }),
+	stringOption("subtype", "file system type shown as fuse.SUBTYPE; default TYPE", func(c *config) *string { return &c.mount.Name }),
+	boolOption("single_threaded", "handle one request at a time", func(c *config) { c.mount.SingleThreaded = true }),
+	boolOption("serialize_nodes", "handle requests for the same node one at a time", func(c *config) { c.mount.SerializeNodes = true }),
+	intOption("qos_interactive", "maximum concurrent interactive requests", func(c *config) *int { return &qos(c).MaxInteractive }),
+	intOption("qos_bulk", "maximum concurrent bulk requests", func(c *config) *int { return &qos(c).MaxBulk }),
+	intOption("qos_background", "maximum concurrent background requests", func(c *config) *int { return &qos(c).MaxBackground }),
+	boolOption("disable_xattrs", "make the kernel skip extended attributes", func(c *config) { c.mount.DisableXAttrs = true }),
+	boolOption("enable_locks", "forward file locks to the file system", func(c *config) { c.mount.EnableLocks = true }),
+	boolOption("track_requests", "keep track of requests being served", func(c *config) { c.mount.TrackRequests = true }),
+	timeoutOption("entry_timeout", "seconds to cache names", func(c *config) *time.Duration { return &c.node.EntryTimeout }),
+	timeoutOption("attr_timeout", "seconds to cache attributes", func(c *config) *time.Duration { return &c.node.AttrTimeout }),
+	timeoutOption("negative_timeout", "seconds to cache failed lookups", func(c *config) *time.Duration { return &c.node.NegativeTimeout }),
+}
+
+// isMountOnly says whether o only means something to mount(8) and
+// should not reach fusermount.
+func isMountOnly(o string) bool {
+	switch o {
+	case "defaults", "auto", "noauto", "user", "nouser", "users", "owner", "group", "_netdev", "nofail":
+		return true
+	}
+	return strings.HasPrefix(o, "x-") || strings.HasPrefix(o, "comment=")
+}
+
+// parseOptions applies a comma separated -o list. Options not in the
+// table are passed to fusermount.
+func (c *config) parseOptions(list string) error {
+	for _, o := range strings.Split(list, ",") {
+		if o == "" || isMountOnly(o) {
+			continue
+		}
+		name, val, hasVal := strings.Cut(o, "=")
+		var opt *option
+		for i := range options {
+			if options[i].name == name {
+				opt = &options[i]
+			}
+		}
+		if opt == nil {
+			c.mount.Options = append(c.mount.Options, o)
+			continue
+		}
+		if opt.isBool == hasVal {
+			return fmt.Errorf("option %q: bad value", o)
+		}
+		if err := opt.set(c, val); err != nil {
+			return fmt.Errorf("option %q: %v", o, err)
+		}
+	}
+	return nil
+}
+
+// register adds a flag for each option, and -o and -f.
+func (c *config) register(fs *flag.FlagSet) {
+	for _, o := range options {
+		o := o
+		if o.isBool {
+			fs.BoolFunc(o.name, o.help, func(val string) error {
+				b, err := strconv.ParseBool(val)
+				if err != nil || !b {
+					return err
+				}
+				return o.set(c, "")
+			})
+		} else {
+			fs.Func(o.name, o.help, func(val string) error { return o.set(c, val) })
+		}
+	}
+	fs.Func("o", "comma separated mount options", c.parseOptions)
+	fs.BoolVar(&c.foreground, "f", false, "stay in the foreground")
+}
@@ -8,6 +8,11 @@
 
 package fuse
 
+import (
+	"log"
+	"time"
+)
+
 // Types for users to implement.
 
 // The result of Read is an array of bytes, but for performance
@@ -31,7 +36,13 @@ type ReadResult interface {
 type MountOptions struct {
 	AllowOther bool
 
-	// Options are passed as -o string to fusermount.
+	// Options are passed as -o string to fusermount, verbatim,
+	// so kernel and fusermount options that have no field here,
+	// eg. "default_permissions", "max_read=131072" or
+	// "context=system_u:object_r:tmp_t:s0", can be used as they
+	// are. Each entry is one option, and must not contain ','.
+	// An entry for fsname or subtype takes precedence over
+	// FsName and Name.
 	Options []string
 
 	// Default is _DEFAULT_BACKGROUND_TASKS, 12.  This numbers
@@ -39,12 +50,28 @@ type MountOptions struct {
 	// async I/O.  Concurrency for synchronous I/O is not limited.
 	MaxBackground int
 
+	// MaxReaders is the number of goroutines that read requests
+	// from the kernel in parallel. Each one dispatches the
+	// request it read, and another reader takes over. If 0,
+	// defaults to 3. It can be changed on a running server with
+	// Server.SetMaxReaders.
+	MaxReaders int
+
+	// If set, stop reading requests from the kernel while the
+	// WRITE requests being handled hold this many bytes or more.
+	// The kernel then queues further requests, so a slow file
+	// system does not make the daemon buffer ever more data.
+	MaxInflightBytes int
+
 	// Write size to use.  If 0, use default. This number is
 	// capped at the kernel maximum.
 	MaxWrite int
 
-	// Max read ahead to use.  If 0, use default. This number is
-	// capped at the kernel maximum.
+	// Max read ahead to use.  If 0, use default. INIT can only
+	// lower the read ahead the kernel proposes (typically 128k); on
+	// Linux, a larger value is set through the read_ahead_kb of the
+	// mount's backing device after mounting, which requires root.
+	// ConnectionInfo reports the value in effect.
 	MaxReadAhead int
 
 	// If IgnoreSecurityLabels is set, all security related xattr
@@ -54,13 +81,28 @@ type MountOptions struct {
 	// interested in security labels.
 	IgnoreSecurityLabels bool // ignoring labels should be provided as a fusermount mount option.
 
-	// If given, use this buffer pool instead of the global one.
+	// If given, use this buffer pool instead of the global one,
+	// eg. for buffers from an arena or in huge pages, or to
+	// instrument allocations. The server allocates the buffers
+	// for requests, of MaxWrite plus a page, and for replies
+	// from it, and frees each once it is done with it. It must
+	// be safe for concurrent use.
 	Buffers BufferPool
 
+	// If set, the buffers from Buffers are charged to this
+	// limit, as account "buffers". They cannot be done without,
+	// so they are charged even beyond the ceiling, but they make
+	// caches charged to the same limit evict.
+	Memory *MemoryLimit
+
 	// If RememberInodes is set, we will never forget inodes.
 	// This may be useful for NFS.
 	RememberInodes bool
 
+	// If set, create the mount point and its parents if they do
+	// not exist.
+	CreateMountPoint bool
+
 	// Values shown in "df -T" and friends
 	// First column, "Filesystem"
 	FsName string
@@ -71,16 +113,158 @@ type MountOptions struct {
 	// If set, wrap the file system in a single-threaded locking wrapper.
 	SingleThreaded bool
 
+	// If set, requests for the same node are handled one at a
+	// time, in the order they were read, while requests for
+	// different nodes still run in parallel. RENAME and LINK
+	// count for both nodes involved; SETLKW, which may wait for
+	// other requests, and FORGET are exempt. Requests are read in
+	// parallel unless MaxReaders is 1, so for strict arrival
+	// order, set that too.
+	SerializeNodes bool
+
+	// If set, FORGET and BATCH_FORGET do not run alongside
+	// LOOKUP, CREATE, MKDIR, MKNOD, SYMLINK, LINK and READDIRPLUS,
+	// which may return the node being forgotten: each waits for
+	// those of the other kind read before it. Without this, a
+	// file system that does not update lookup counts atomically
+	// may drop a node that a concurrent LOOKUP is about to hand
+	// out. Entry requests still run in parallel with each other,
+	// as do forgets. As for SerializeNodes, the order is that of
+	// reading, which is strict only with MaxReaders 1.
+	//
+	// The order is by kind, not by node, as the node that an
+	// entry request returns is only known once it is done. A
+	// FORGET therefore waits for every entry request read before
+	// it, whatever node they are for, and holds up every entry
+	// request read after it: one slow LOOKUP delays all FORGETs,
+	// and through them all later LOOKUPs, until it returns. Only
+	// set this for file systems that need it, and whose entry
+	// requests do not block for long.
+	OrderForgets bool
+
+	// If positive, replies are written by a goroutine of their
+	// own, from a queue of this many replies, so methods return
+	// without waiting for the kernel to take the reply, and a slow
+	// /dev/fuse does not hold up the backend. Methods wait when
+	// the queue is full. Replies are written in the order they
+	// are queued, so with SerializeNodes, replies for a node are
+	// written in the order its requests were read. Notifications
+	// are written right away, as before.
+	ReplyQueue int
+
+	// If set, limit the number of concurrent requests per class
+	// of request.
+	QoS *QoS
+
+	// If set, limit the rate of requests, globally and per uid.
+	RateLimit *RateLimit
+
+	// If set, make the mount read-only once the file system
+	// fails too many changes.
+	ErrorLockdown *ErrorLockdown
+
 	// If set, return ENOSYS for Getxattr calls, so the kernel does not issue any
 	// Xattr operations at all.
 	DisableXAttrs bool
 
+	// Operations that fail without reaching the file system,
+	// keyed by name as in the debug output, eg. "MKNOD" or
+	// "SETXATTR", with the status to return. OK stands for
+	// ENOSYS, after which the kernel stops sending most
+	// operations, which keeps minimal file systems quiet. INIT,
+	// DESTROY, FORGET, BATCH_FORGET and INTERRUPT cannot be
+	// disabled.
+	DisabledOps map[string]Status
+
 	// If set, print debugging information.
 	Debug bool
 
+	// Logger receives debug output and errors from the server. If
+	// nil, the standard logger of the log package is used.
+	Logger *log.Logger
+
+	// If set, probe the file system before mounting, and fail if
+	// it does not respond.
+	HealthCheck *HealthCheck
+
+	// If set, debug output names the process that sent each
+	// request, and interceptors can find it through
+	// Intercepted.Process.
+	ProcessResolver *ProcessResolver
+
+	// If set, record the latency of each request from the start;
+	// see Server.RecordLatencies. A SamplingLatencyMap only gets
+	// the latency of some requests. A ByteCounter also gets the
+	// payload size of each request.
+	Latencies LatencyMap
+
 	// If set, ask kernel to forward file locks to FUSE. If using,
 	// you must implement the GetLk/SetLk/SetLkw methods.
 	EnableLocks bool
+
+	// If set, read and process requests strictly in order on the
+	// goroutine that calls Serve. This makes tests reproducible,
+	// but a file system operation that waits for another request
+	// will deadlock, so it is not meant for real mounts.
+	Deterministic bool
+
+	// Interceptors see each request before it is dispatched, and
+	// each reply before it is written; see Interceptor.
+	Interceptors []Interceptor
+
+	// Clock supplies the time for latency measurements and
+	// timestamps set by the library. If nil, the system clock is
+	// used. Tests can substitute a fake clock, which may also
+	// implement Timer.
+	Clock Clock
+
+	// If set, keep track of the requests being dispatched, so
+	// file system methods can find out which request they serve
+	// through Server.RequestInfo. This costs a map update per
+	// request.
+	TrackRequests bool
+
+	// If set, handle INTERRUPT requests, so file system methods
+	// can stop waiting when the calling process gets a signal;
+	// see Server.Interrupted. This costs two map updates per
+	// request. Interrupts cannot be served while a method blocks
+	// with Deterministic set.
+	EnableInterrupts bool
+
+	// If set, negotiate CAP_NO_OPEN_SUPPORT and
+	// CAP_NO_OPENDIR_SUPPORT, so Open and OpenDir can return
+	// NO_OPEN. As NO_OPEN is ENOSYS on the wire, only set this if
+	// the file system implements both, or the kernel takes an
+	// unimplemented OpenDir for a file system without handles.
+	EnableNoOpen bool
+
+	// If set, the server unmounts the file system once it has
+	// received no requests for this long, so on-demand mounts go
+	// away when they are not used. The kernel then forgets the
+	// root, which runs the OnUnmount of nodefs and pathfs file
+	// systems, and Serve returns. If unmounting fails, typically
+	// with EBUSY because files are open, the server tries again
+	// after another idle period. Servers without a mount point
+	// ignore it.
+	IdleTimeout time.Duration
+}
+
+// Clock tells the time.
+type Clock interface {
+	Now() time.Time
+}
+
+// A Clock that implements Timer also decides when the waits of the
+// library end, so a fake clock can make them pass without waiting in
+// real time.
+type Timer interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
 }
 
 // RawFileSystem is an interface close to the FUSE wire protocol.
@@ -136,7 +320,17 @@ type RawFileSystem interface {
 
 	// File handling.
 	Create(input *CreateIn, name string, out *CreateOut) (code Status)
+
+	// Open returns NO_OPEN if the file system needs no file
+	// handles; likewise for OpenDir. See
+	// MountOptions.EnableNoOpen.
 	Open(input *OpenIn, out *OpenOut) (status Status)
+
+	// Read reads input.Size bytes at input.Offset. buf has
+	// exactly input.Size bytes, taken from MountOptions.Buffers
+	// and reused for later requests; fill it in place and return
+	// ReadResultData(buf[:n]), or use ReadAt, rather than
+	// allocating. Data past input.Size is not sent.
 	Read(input *ReadIn, buf []byte) (ReadResult, Status)
 
 	// File locking
@@ -150,6 +344,11 @@ type RawFileSystem interface {
 	Fsync(input *FsyncIn) (code Status)
 	Fallocate(input *FallocateIn) (code Status)
 
+	// Bmap maps a file block to a device block. The kernel only
+	// sends it for fuseblk mounts; it is used for swap files and
+	// the FIBMAP ioctl.
+	Bmap(input *BmapIn, out *BmapOut) (code Status)
+
 	// Directory handling
 	OpenDir(input *OpenIn, out *OpenOut) (status Status)
 	ReadDir(input *ReadIn, out *DirEntryList) Status
@@ -165,3 +364,11 @@ type RawFileSystem interface {
 	// talk back to the kernel (through notify methods).
 	Init(*Server)
 }
+
+// ExitChecker is implemented by file systems that can verify their
+// state once the kernel has hung up, eg. that no file handles were
+// leaked. When Serve returns, the server calls CheckExit and logs
+// the error through MountOptions.Logger.
+type ExitChecker interface {
+	CheckExit() error
+}
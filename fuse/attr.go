@@ -5,6 +5,7 @@
 package fuse
 
 import (
+	"math"
 	"os"
 	"syscall"
 	"time"
@@ -57,6 +58,27 @@ func (a *Attr) ModTime() time.Time {
 	return time.Unix(int64(a.Mtime), int64(a.Mtimensec))
 }
 
+// setInt stores v in an integer field whose width varies between
+// platforms, such as Stat_t.Nlink.
+func setInt[T ~int32 | ~int64 | ~uint16 | ~uint32 | ~uint64](dst *T, v uint64) {
+	*dst = T(v)
+}
+
+// setTimespec stores a time in ts. On 32-bit platforms, where
+// Timespec.Sec is an int32, seconds outside its range are clamped
+// rather than wrapped around.
+func setTimespec(ts *syscall.Timespec, sec uint64, nsec uint32) {
+	setInt(&ts.Sec, sec)
+	if s := int64(sec); int64(ts.Sec) != s {
+		lim := int64(math.MaxInt32)
+		if s < 0 {
+			lim = math.MinInt32
+		}
+		setInt(&ts.Sec, uint64(lim))
+	}
+	setInt(&ts.Nsec, uint64(nsec))
+}
+
 func ToStatT(f os.FileInfo) *syscall.Stat_t {
 	s, _ := f.Sys().(*syscall.Stat_t)
 	if s != nil {
@@ -18,9 +18,34 @@ func (a *Attr) FromStat(s *syscall.Stat_t) {
 	a.Mtimensec = uint32(s.Mtimespec.Nsec)
 	a.Ctime = uint64(s.Ctimespec.Sec)
 	a.Ctimensec = uint32(s.Ctimespec.Nsec)
+	a.Crtime_ = uint64(s.Birthtimespec.Sec)
+	a.Crtimensec_ = uint32(s.Birthtimespec.Nsec)
 	a.Mode = uint32(s.Mode)
 	a.Nlink = uint32(s.Nlink)
 	a.Uid = uint32(s.Uid)
 	a.Gid = uint32(s.Gid)
 	a.Rdev = uint32(s.Rdev)
+	a.Flags_ = s.Flags
+}
+
+// ToStat fills in the fields of s that Attr carries. It is the
+// inverse of FromStat.
+func (a *Attr) ToStat(s *syscall.Stat_t) {
+	s.Ino = a.Ino
+	s.Size = int64(a.Size)
+	s.Blocks = int64(a.Blocks)
+	setTimespec(&s.Atimespec, a.Atime, a.Atimensec)
+	setTimespec(&s.Mtimespec, a.Mtime, a.Mtimensec)
+	setTimespec(&s.Ctimespec, a.Ctime, a.Ctimensec)
+	setTimespec(&s.Birthtimespec, a.Crtime_, a.Crtimensec_)
+	s.Mode = uint16(a.Mode)
+	s.Nlink = uint16(a.Nlink)
+	s.Uid = a.Uid
+	s.Gid = a.Gid
+	s.Rdev = int32(a.Rdev)
+	s.Flags = a.Flags_
+}
+
+// Attr has no Blksize on Darwin.
+func (a *Attr) setBlksize(blksize uint32) {
 }
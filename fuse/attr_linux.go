@@ -5,6 +5,7 @@
 package fuse
 
 import (
+	"math"
 	"syscall"
 )
 
@@ -19,9 +20,53 @@ func (a *Attr) FromStat(s *syscall.Stat_t) {
 	a.Ctime = uint64(s.Ctim.Sec)
 	a.Ctimensec = uint32(s.Ctim.Nsec)
 	a.Mode = s.Mode
-	a.Nlink = uint32(s.Nlink)
+	a.Nlink = clampUint32(uint64(s.Nlink))
 	a.Uid = uint32(s.Uid)
 	a.Gid = uint32(s.Gid)
-	a.Rdev = uint32(s.Rdev)
+	a.Rdev = encodeDev(uint64(s.Rdev))
 	a.Blksize = uint32(s.Blksize)
 }
+
+// ToStat fills in the fields of s that Attr carries. It is the
+// inverse of FromStat.
+func (a *Attr) ToStat(s *syscall.Stat_t) {
+	setInt(&s.Ino, a.Ino)
+	setInt(&s.Size, a.Size)
+	setInt(&s.Blocks, a.Blocks)
+	setTimespec(&s.Atim, a.Atime, a.Atimensec)
+	setTimespec(&s.Mtim, a.Mtime, a.Mtimensec)
+	setTimespec(&s.Ctim, a.Ctime, a.Ctimensec)
+	s.Mode = a.Mode
+	setInt(&s.Nlink, uint64(a.Nlink))
+	s.Uid = a.Uid
+	s.Gid = a.Gid
+	setInt(&s.Rdev, decodeDev(a.Rdev))
+	setInt(&s.Blksize, uint64(a.Blksize))
+}
+
+func clampUint32(v uint64) uint32 {
+	if v > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(v)
+}
+
+// encodeDev converts a dev_t as used by glibc into the 32-bit
+// encoding of the kernel's struct fuse_attr, which has 12 bits of
+// major and 20 bits of minor number.
+func encodeDev(dev uint64) uint32 {
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	return uint32(minor&0xff | (major&0xfff)<<8 | (minor&0xfff00)<<12)
+}
+
+// decodeDev is the inverse of encodeDev.
+func decodeDev(rdev uint32) uint64 {
+	major := uint64(rdev>>8) & 0xfff
+	minor := uint64(rdev&0xff) | uint64(rdev>>12)&0xfff00
+	return minor&0xff | major<<8 | (minor&^0xff)<<12
+}
+
+func (a *Attr) setBlksize(blksize uint32) {
+	a.Blksize = blksize
+}
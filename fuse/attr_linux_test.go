@@ -0,0 +1,48 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestStatRoundTrip(t *testing.T) {
+	var st syscall.Stat_t
+	if err := syscall.Lstat("/dev/null", &st); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+
+	var a Attr
+	a.FromStat(&st)
+	if a.Rdev != 1<<8|3 {
+		t.Errorf("/dev/null: got rdev 0x%x, want 1:3", a.Rdev)
+	}
+
+	var back syscall.Stat_t
+	a.ToStat(&back)
+	if back.Ino != st.Ino || back.Mode != st.Mode || back.Rdev != st.Rdev ||
+		back.Nlink != st.Nlink || back.Blocks != st.Blocks ||
+		back.Atim != st.Atim || back.Mtim != st.Mtim || back.Ctim != st.Ctim {
+		t.Errorf("round trip: got %+v, want %+v", back, st)
+	}
+}
+
+func TestDevEncoding(t *testing.T) {
+	// glibc's makedev(major, minor).
+	makedev := func(major, minor uint64) uint64 {
+		return minor&0xff | (major&0xfff)<<8 | (minor&^0xff)<<12 | (major&^0xfff)<<32
+	}
+	for _, d := range [][2]uint64{{0, 0}, {1, 3}, {8, 17}, {259, 70000}, {4095, 1<<20 - 1}} {
+		dev := makedev(d[0], d[1])
+		rdev := encodeDev(dev)
+		if want := uint32(d[1]&0xff | d[0]<<8 | (d[1]&^0xff)<<12); rdev != want {
+			t.Errorf("encodeDev(%d:%d): got 0x%x, want 0x%x", d[0], d[1], rdev, want)
+		}
+		if got := decodeDev(rdev); got != dev {
+			t.Errorf("decodeDev(0x%x): got 0x%x, want 0x%x", rdev, got, dev)
+		}
+	}
+}
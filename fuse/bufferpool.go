@@ -5,8 +5,10 @@
 package fuse
 
 import (
+	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 var paranoia bool
@@ -32,6 +34,10 @@ func NewGcBufferPool() BufferPool {
 	return &gcBufferPool{}
 }
 
+func (p *gcBufferPool) String() string {
+	return "gcBufferPool"
+}
+
 func (p *gcBufferPool) AllocBuffer(size uint32) []byte {
 	return make([]byte, size)
 }
@@ -40,6 +46,10 @@ func (p *gcBufferPool) FreeBuffer(slice []byte) {
 }
 
 type bufferPoolImpl struct {
+	// Number of AllocBuffer and FreeBuffer calls.
+	allocs atomic.Int64
+	frees  atomic.Int64
+
 	lock sync.Mutex
 
 	// For each page size multiple a list of slice pointers.
@@ -58,6 +68,26 @@ func NewBufferPool() BufferPool {
 
 var pageSize = os.Getpagesize()
 
+// BufferPoolStats counts the buffers handed out by a BufferPool.
+type BufferPoolStats struct {
+	Allocs int64
+	Frees  int64
+}
+
+// Stats returns the number of AllocBuffer and FreeBuffer calls.
+func (p *bufferPoolImpl) Stats() BufferPoolStats {
+	return BufferPoolStats{
+		Allocs: p.allocs.Load(),
+		Frees:  p.frees.Load(),
+	}
+}
+
+// String reports how many buffers were handed out and taken back.
+func (p *bufferPoolImpl) String() string {
+	return fmt.Sprintf("bufferPool(allocs %d, frees %d)",
+		p.allocs.Load(), p.frees.Load())
+}
+
 func (p *bufferPoolImpl) getPool(pageCount int) *sync.Pool {
 	p.lock.Lock()
 	for len(p.buffersBySize) < pageCount+1 {
@@ -84,6 +114,7 @@ func (p *bufferPoolImpl) AllocBuffer(size uint32) []byte {
 	}
 	pages := sz / pageSize
 
+	p.allocs.Add(1)
 	b := p.getPool(pages).Get().([]byte)
 	return b[:size]
 }
@@ -98,5 +129,6 @@ func (p *bufferPoolImpl) FreeBuffer(slice []byte) {
 	pages := cap(slice) / pageSize
 	slice = slice[:cap(slice)]
 
+	p.frees.Add(1)
 	p.getPool(pages).Put(slice)
 }
@@ -0,0 +1,76 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// countingPool tracks the buffers that are out.
+type countingPool struct {
+	mu     sync.Mutex
+	allocs int
+	out    map[*byte]bool
+}
+
+func (p *countingPool) AllocBuffer(size uint32) []byte {
+	b := make([]byte, size)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.allocs++
+	p.out[&b[0]] = true
+	return b
+}
+
+func (p *countingPool) FreeBuffer(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.out, &b[:1][0])
+}
+
+type bigReadFS struct {
+	fuse.RawFileSystem
+}
+
+func (fs *bigReadFS) Read(in *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
+	return fuse.ReadResultData(buf), fuse.OK
+}
+
+func TestBufferPoolInjection(t *testing.T) {
+	pool := &countingPool{out: map[*byte]bool{}}
+	k, err := fakekernel.New(&bigReadFS{fuse.NewDefaultRawFileSystem()}, &fuse.MountOptions{
+		Buffers: pool,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		k.GetAttr(fuse.FUSE_ROOT_ID)
+		if _, code := k.Read(fuse.FUSE_ROOT_ID, 1, 0, 64<<10); !code.Ok() {
+			t.Fatalf("Read: %v", code)
+		}
+		if _, code := k.Write(fuse.FUSE_ROOT_ID, 1, 0, make([]byte, 32<<10)); code != fuse.ENOSYS {
+			t.Fatalf("Write: %v", code)
+		}
+	}
+	k.Close()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	// Each request needs a buffer to be read into.
+	if pool.allocs < 30 {
+		t.Errorf("got %d allocations, want at least 30", pool.allocs)
+	}
+	if len(pool.out) != 0 {
+		t.Errorf("%d buffers not freed", len(pool.out))
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+// ByteCounter is a LatencyMap that also wants the payload size of
+// each request: the data written for WRITE, and the data returned
+// for READ, READDIR, GETXATTR and the like. Unlike latencies, bytes
+// are passed for every request, whatever the SampleRate.
+type ByteCounter interface {
+	AddBytes(name string, n int64)
+}
+
+// ByteCounts is the data moved through a mount by READ and WRITE
+// requests that succeeded.
+type ByteCounts struct {
+	Read    uint64
+	Written uint64
+}
+
+// ByteCounts returns the number of bytes read and written through
+// the mount so far.
+func (ms *Server) ByteCounts() ByteCounts {
+	return ByteCounts{
+		Read:    ms.bytesRead.Load(),
+		Written: ms.bytesWritten.Load(),
+	}
+}
+
+// payloadSize returns the number of data bytes carried by req.
+func (r *request) payloadSize() int64 {
+	if !r.status.Ok() || r.inHeader == nil {
+		return 0
+	}
+	if r.inHeader.Opcode == _OP_WRITE && r.inData != nil {
+		return int64((*WriteIn)(r.inData).Size)
+	}
+	return int64(r.flatDataSize())
+}
+
+// countBytes adds the payload of req to the mount's counters and
+// the latency map.
+func (ms *Server) countBytes(req *request, l LatencyMap) {
+	n := req.payloadSize()
+	if n == 0 {
+		return
+	}
+	switch req.inHeader.Opcode {
+	case _OP_READ:
+		ms.bytesRead.Add(uint64(n))
+	case _OP_WRITE:
+		ms.bytesWritten.Add(uint64(n))
+	}
+	if c, ok := l.(ByteCounter); ok {
+		c.AddBytes(operationName(req.inHeader.Opcode), n)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+type byteCountFS struct {
+	fuse.RawFileSystem
+}
+
+func (fs *byteCountFS) Read(in *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
+	return fuse.ReadResultData(make([]byte, in.Size/2)), fuse.OK
+}
+
+func (fs *byteCountFS) Write(in *fuse.WriteIn, data []byte) (uint32, fuse.Status) {
+	return uint32(len(data)), fuse.OK
+}
+
+type byteMap struct {
+	mu    sync.Mutex
+	bytes map[string]int64
+}
+
+func (m *byteMap) Add(name string, dt time.Duration) {}
+
+func (m *byteMap) AddBytes(name string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes[name] += n
+}
+
+func TestByteCounts(t *testing.T) {
+	m := &byteMap{bytes: map[string]int64{}}
+	k, err := fakekernel.New(&byteCountFS{fuse.NewDefaultRawFileSystem()},
+		&fuse.MountOptions{Deterministic: true, Latencies: m})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, code := k.Read(fuse.FUSE_ROOT_ID, 1, 0, 100); !code.Ok() {
+			t.Fatalf("Read: %v", code)
+		}
+	}
+	if _, code := k.Write(fuse.FUSE_ROOT_ID, 1, 0, make([]byte, 70)); !code.Ok() {
+		t.Fatalf("Write: %v", code)
+	}
+	// Failed requests carry no payload.
+	k.Readlink(fuse.FUSE_ROOT_ID)
+	server := k.Server()
+	k.Close()
+
+	want := fuse.ByteCounts{Read: 150, Written: 70}
+	if got := server.ByteCounts(); got != want {
+		t.Errorf("ByteCounts: got %+v, want %+v", got, want)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.bytes["READ"] != 150 || m.bytes["WRITE"] != 70 || len(m.bytes) != 2 {
+		t.Errorf("got %v, want READ 150, WRITE 70", m.bytes)
+	}
+}
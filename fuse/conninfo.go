@@ -0,0 +1,60 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+)
+
+// ConnectionInfo describes the connection to the kernel, as
+// negotiated by the INIT request.
+type ConnectionInfo struct {
+	// The protocol version of the kernel.
+	KernelMajor uint32
+	KernelMinor uint32
+
+	// The protocol version in use, the lower of the kernel's and
+	// ours.
+	Major uint32
+	Minor uint32
+
+	// KernelFlags are the CAP_* flags the kernel offered, Flags
+	// those that are in use.
+	KernelFlags uint32
+	Flags       uint32
+
+	// The largest write the kernel sends, and how far it reads
+	// ahead.
+	MaxWrite     uint32
+	MaxReadAhead uint32
+
+	// The number of background requests (eg. read ahead) the
+	// kernel may have outstanding, and the number from which it
+	// considers the connection congested.
+	MaxBackground       uint16
+	CongestionThreshold uint16
+}
+
+// Has reports whether capability flag is in use.
+func (c *ConnectionInfo) Has(flag uint32) bool {
+	return c.Flags&flag != 0
+}
+
+func (c *ConnectionInfo) String() string {
+	return fmt.Sprintf("kernel %d.%d, using %d.%d, flags %s (offered %s), max write %d, read ahead %d, background %d, congestion %d",
+		c.KernelMajor, c.KernelMinor, c.Major, c.Minor,
+		FlagString(initFlagNames, int64(c.Flags), "0"),
+		FlagString(initFlagNames, int64(c.KernelFlags), "0"),
+		c.MaxWrite, c.MaxReadAhead, c.MaxBackground, c.CongestionThreshold)
+}
+
+// ConnectionInfo returns the connection parameters. It returns false
+// if the kernel has not sent INIT yet, which happens once mounting
+// has completed.
+func (ms *Server) ConnectionInfo() (ConnectionInfo, bool) {
+	ms.reqMu.Lock()
+	defer ms.reqMu.Unlock()
+	return ms.connInfo, ms.connInfo.Major != 0
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/protocol"
+)
+
+func TestConnectionInfo(t *testing.T) {
+	k, err := fakekernel.New(fuse.NewDefaultRawFileSystem(), &fuse.MountOptions{
+		MaxWrite:      64 << 10,
+		MaxBackground: 16,
+		EnableNoOpen:  true,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	info, ok := k.Server().ConnectionInfo()
+	if !ok {
+		t.Fatal("ConnectionInfo: not initialized")
+	}
+	if info.KernelMajor != protocol.KERNEL_VERSION || info.KernelMinor != protocol.MAXIMUM_MINOR_VERSION {
+		t.Errorf("got kernel %d.%d", info.KernelMajor, info.KernelMinor)
+	}
+	if info.Major != info.KernelMajor || info.Minor > info.KernelMinor {
+		t.Errorf("got version %d.%d", info.Major, info.Minor)
+	}
+	if info.MaxWrite != 64<<10 || info.MaxBackground != 16 || info.CongestionThreshold != 12 {
+		t.Errorf("got %+v", info)
+	}
+	if info.KernelFlags&fuse.CAP_NO_OPEN_SUPPORT == 0 || !info.Has(fuse.CAP_NO_OPEN_SUPPORT) {
+		t.Errorf("NO_OPEN_SUPPORT missing: %v", &info)
+	}
+	if info.Flags&^info.KernelFlags != 0 {
+		t.Errorf("flags %x not offered by the kernel (%x)", info.Flags, info.KernelFlags)
+	}
+	if s := info.String(); !strings.Contains(s, "NO_OPEN_SUPPORT") {
+		t.Errorf("String: got %q", s)
+	}
+}
+
+func TestConnectionInfoReadAhead(t *testing.T) {
+	for _, tc := range []struct{ opt, want uint32 }{
+		{0, 128 << 10},
+		{32 << 10, 32 << 10},
+		// Raising it takes a mount; INIT caps it at the
+		// kernel's proposal.
+		{1 << 20, 128 << 10},
+	} {
+		k, err := fakekernel.New(fuse.NewDefaultRawFileSystem(), &fuse.MountOptions{
+			MaxReadAhead: int(tc.opt),
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		info, _ := k.Server().ConnectionInfo()
+		k.Close()
+		if info.MaxReadAhead != tc.want {
+			t.Errorf("MaxReadAhead %d: got %d, want %d", tc.opt, info.MaxReadAhead, tc.want)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+// Darwin has no O_NOATIME.
+const syscall_O_NOATIME = 0
+
+// OSX has F_NOCACHE instead of O_DIRECT.
+const syscall_O_DIRECT = 0
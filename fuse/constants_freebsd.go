@@ -7,3 +7,4 @@ package fuse
 // arbitrary values
 const syscall_O_LARGEFILE = 1 << 29
 const syscall_O_NOATIME = 1 << 30
+const syscall_O_DIRECT = 0x10000
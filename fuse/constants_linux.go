@@ -10,3 +10,4 @@ import (
 
 const syscall_O_LARGEFILE = syscall.O_LARGEFILE
 const syscall_O_NOATIME = syscall.O_NOATIME
+const syscall_O_DIRECT = syscall.O_DIRECT
@@ -0,0 +1,47 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"io"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// TestSetDebugServing toggles debug output while requests are
+// served; run with -race.
+func TestSetDebugServing(t *testing.T) {
+	fs := &statFS{RawFileSystem: fuse.NewDefaultRawFileSystem()}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{
+		Logger: log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			k.Server().SetDebug(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, code := k.GetAttr(fuse.FUSE_ROOT_ID); !code.Ok() {
+			t.Fatalf("GetAttr: %v", code)
+		}
+	}
+	wg.Wait()
+	k.Server().SetDebug(true)
+	if !k.Server().Debug() {
+		t.Errorf("Debug() = false after SetDebug(true)")
+	}
+}
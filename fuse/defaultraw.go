@@ -162,3 +162,7 @@ func (fs *defaultRawFileSystem) FsyncDir(input *FsyncIn) (code Status) {
 func (fs *defaultRawFileSystem) Fallocate(in *FallocateIn) (code Status) {
 	return ENOSYS
 }
+
+func (fs *defaultRawFileSystem) Bmap(in *BmapIn, out *BmapOut) (code Status) {
+	return ENOSYS
+}
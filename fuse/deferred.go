@@ -0,0 +1,167 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DEFERRED is returned by a file system method that called
+// Server.Defer, to say that the reply comes later, through the
+// Completion.
+const DEFERRED = Status(-7)
+
+// Completion finishes a request whose reply was deferred with
+// Server.Defer. Exactly one of its methods should be called, once;
+// later calls are ignored.
+type Completion struct {
+	ms *Server
+
+	mu sync.Mutex
+	// req is set once the method has returned.
+	req     *request
+	replied bool
+	code    Status
+	data    []byte
+	hasData bool
+}
+
+// Defer lets the file system method serving the request of ctx
+// return DEFERRED, and reply later, from any goroutine, through the
+// returned Completion. Event-driven backends can so have many
+// requests outstanding without parking a goroutine for each.
+//
+// Until the reply, the request keeps the memory it was given: out
+// structs and read buffers passed to the method may be filled in
+// after it returned, before calling Reply. The request does not count
+// for MountOptions.QoS, SerializeNodes and OrderForgets after the
+// method returns, and cannot be interrupted.
+//
+// As for Server.RequestInfo, ctx must be the Context passed to the
+// method. FORGET, INIT, DESTROY and INTERRUPT cannot be deferred;
+// they are finished when the method returns.
+func (ms *Server) Defer(ctx *Context) *Completion {
+	c := &Completion{ms: ms}
+	ms.deferrals.add(ctx, c)
+	return c
+}
+
+// Reply sends the reply, with the out struct passed to the method,
+// if code is OK.
+func (c *Completion) Reply(code Status) {
+	c.reply(nil, false, code)
+}
+
+// ReplyData sends data as the reply to READ, READLINK, GETXATTR or
+// LISTXATTR, if code is OK. It may point into the buffer passed to
+// Read.
+func (c *Completion) ReplyData(data []byte, code Status) {
+	c.reply(data, true, code)
+}
+
+func (c *Completion) reply(data []byte, hasData bool, code Status) {
+	c.mu.Lock()
+	if c.replied {
+		c.mu.Unlock()
+		return
+	}
+	c.replied = true
+	c.code, c.data, c.hasData = code, data, hasData
+	req := c.req
+	c.mu.Unlock()
+
+	if req != nil {
+		c.apply(req)
+		c.ms.noteLockdown(req)
+		c.ms.finish(req)
+	}
+}
+
+// apply puts the reply into req.
+func (c *Completion) apply(req *request) {
+	req.status = c.code
+	if c.hasData {
+		req.flatData = c.data
+	}
+}
+
+// bind hands req to c, once the server is done with it. It returns
+// false if the reply is in already, and put into req.
+func (c *Completion) bind(req *request) bool {
+	c.mu.Lock()
+	if !c.replied {
+		c.req = req
+		c.mu.Unlock()
+		return true
+	}
+	c.mu.Unlock()
+	c.apply(req)
+	c.ms.noteLockdown(req)
+	return false
+}
+
+// deferralTable holds the Completions from Defer until the method
+// returns, by the address of the Context of their request.
+type deferralTable struct {
+	// count is the size of m, so requests can skip the map
+	// when it is empty.
+	count atomic.Int32
+
+	mu sync.Mutex
+	m  map[uintptr]*Completion
+}
+
+func (t *deferralTable) add(ctx *Context, c *Completion) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.m == nil {
+		t.m = map[uintptr]*Completion{}
+	}
+	t.m[contextKey(ctx)] = c
+	t.count.Store(int32(len(t.m)))
+}
+
+func (t *deferralTable) take(ctx *Context) *Completion {
+	if t.count.Load() == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := contextKey(ctx)
+	c := t.m[key]
+	if c != nil {
+		delete(t.m, key)
+		t.count.Store(int32(len(t.m)))
+	}
+	return c
+}
+
+// takeDeferral sets req.completion if the method serving req
+// deferred its reply, and returns whether it did.
+func (ms *Server) takeDeferral(req *request) bool {
+	c := ms.deferrals.take(&req.inHeader.Context)
+	if c != nil {
+		switch req.inHeader.Opcode {
+		case _OP_FORGET, _OP_BATCH_FORGET, _OP_INIT, _OP_DESTROY, _OP_INTERRUPT:
+			ms.opts.Logger.Printf("%s i%d: cannot be deferred",
+				operationName(req.inHeader.Opcode), req.inHeader.NodeId)
+			c.mu.Lock()
+			c.replied = true
+			c.mu.Unlock()
+			c = nil
+		}
+	}
+	if c == nil {
+		if req.status == DEFERRED {
+			ms.opts.Logger.Printf("%s i%d: DEFERRED without Server.Defer",
+				operationName(req.inHeader.Opcode), req.inHeader.NodeId)
+			req.status = EIO
+		}
+		return false
+	}
+	req.completion = c
+	return true
+}
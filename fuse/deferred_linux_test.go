@@ -0,0 +1,85 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// asyncFS replies to GETATTR and READ from a goroutine of its own,
+// after the method returned, and to READLINK before returning.
+type asyncFS struct {
+	fuse.RawFileSystem
+	server *fuse.Server
+	queue  chan func()
+}
+
+func (fs *asyncFS) Init(s *fuse.Server) {
+	fs.server = s
+}
+
+func (fs *asyncFS) loop() {
+	for f := range fs.queue {
+		f()
+	}
+}
+
+func (fs *asyncFS) GetAttr(in *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	c := fs.server.Defer(&in.Context)
+	fs.queue <- func() {
+		out.Mode = fuse.S_IFDIR | 0755
+		out.Ino = in.NodeId
+		c.Reply(fuse.OK)
+	}
+	return fuse.DEFERRED
+}
+
+func (fs *asyncFS) Read(in *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
+	c := fs.server.Defer(&in.Context)
+	fs.queue <- func() {
+		n := copy(buf, "async data")
+		c.ReplyData(buf[:n], fuse.OK)
+	}
+	return nil, fuse.DEFERRED
+}
+
+func (fs *asyncFS) Readlink(header *fuse.InHeader) ([]byte, fuse.Status) {
+	c := fs.server.Defer(&header.Context)
+	c.ReplyData([]byte("target"), fuse.OK)
+	return nil, fuse.DEFERRED
+}
+
+func (fs *asyncFS) Open(in *fuse.OpenIn, out *fuse.OpenOut) fuse.Status {
+	return fuse.OK
+}
+
+func TestDeferredReply(t *testing.T) {
+	fs := &asyncFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		queue:         make(chan func(), 10),
+	}
+	go fs.loop()
+	defer close(fs.queue)
+
+	k, err := fakekernel.New(fs, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	a, code := k.GetAttr(fuse.FUSE_ROOT_ID)
+	if !code.Ok() || a.Mode != fuse.S_IFDIR|0755 || a.Ino != fuse.FUSE_ROOT_ID {
+		t.Errorf("GetAttr: got %v, %v", a, code)
+	}
+	if target, code := k.Readlink(fuse.FUSE_ROOT_ID); !code.Ok() || target != "target" {
+		t.Errorf("Readlink: got %q, %v", target, code)
+	}
+	if data, code := k.Read(fuse.FUSE_ROOT_ID, 0, 0, 100); !code.Ok() || string(data) != "async data" {
+		t.Errorf("Read: got %q, %v", data, code)
+	}
+}
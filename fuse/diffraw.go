@@ -0,0 +1,914 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Divergence describes an operation on which the file system under
+// test disagreed with the reference.
+type Divergence struct {
+	// Operation, eg. "GetAttr".
+	Op string
+
+	// Path the operation was applied to. For raw file systems,
+	// this is the node ID of the reference, followed by the name
+	// for operations on directory entries, eg. "7/file.txt".
+	Name string
+
+	// Results of the reference and the file system under test.
+	Want string
+	Got  string
+}
+
+func (d *Divergence) String() string {
+	return fmt.Sprintf("%s(%q): got %s, want %s", d.Op, d.Name, d.Got, d.Want)
+}
+
+// DiffOptions configures NewDiffRawFileSystem and
+// pathfs.NewDiffFileSystem.
+type DiffOptions struct {
+	// Report is called for each divergence. It may be called
+	// concurrently. If nil, divergences are logged.
+	Report func(*Divergence)
+
+	// If set, compare owner and timestamps too. Two
+	// implementations rarely agree on these.
+	CompareOwner bool
+	CompareTimes bool
+}
+
+// FormatAttr formats the attributes that the options say should
+// agree, or the status if it is not OK.
+func (o *DiffOptions) FormatAttr(a *Attr, code Status) string {
+	if !code.Ok() {
+		return code.String()
+	}
+	s := fmt.Sprintf("mode %o", a.Mode)
+	if !a.IsDir() {
+		// Directory sizes and link counts depend on the
+		// implementation.
+		s += fmt.Sprintf(" size %d nlink %d", a.Size, a.Nlink)
+	}
+	if o.CompareOwner {
+		s += fmt.Sprintf(" owner %d:%d", a.Uid, a.Gid)
+	}
+	if o.CompareTimes {
+		s += fmt.Sprintf(" mtime %v", a.ModTime())
+	}
+	return s
+}
+
+// NewDiffRawFileSystem returns a RawFileSystem that applies each
+// request to both reference and test, and reports where their
+// results differ. It is the counterpart of pathfs.NewDiffFileSystem
+// for the node API: to check a port from pathfs to nodefs, pass the
+// RawFS() of both connectors.
+//
+// Node IDs and file handles of the two file systems differ, so the
+// kernel's IDs, which are those of the reference, are translated to
+// the ones test returned for the same entry. Lookup counts are
+// tracked, so test sees a FORGET for each of its own lookups.
+// Requests on a node that test failed to look up are not passed to
+// test; the failed lookup itself was reported.
+//
+// Statuses, attributes, file data, directory listings (except "."
+// and ".."), symlinks and extended attributes are compared. The
+// results of the reference are returned. Locks only go to the
+// reference, and test is not given the Server in Init, so it cannot
+// send notifications with its own node IDs.
+//
+// Both file systems should start out with the same content.
+func NewDiffRawFileSystem(reference, test RawFileSystem, opts *DiffOptions) RawFileSystem {
+	fs := &diffRawFileSystem{
+		ref:  reference,
+		test: test,
+		nodes: map[uint64]*diffNode{
+			FUSE_ROOT_ID: {id: FUSE_ROOT_ID},
+		},
+		files: map[uint64]uint64{},
+		dirs:  map[uint64]*diffDir{},
+	}
+	if opts != nil {
+		fs.opts = *opts
+	}
+	if fs.opts.Report == nil {
+		fs.opts.Report = func(d *Divergence) {
+			log.Printf("divergence: %v", d)
+		}
+	}
+	return fs
+}
+
+type diffRawFileSystem struct {
+	ref  RawFileSystem
+	test RawFileSystem
+	opts DiffOptions
+
+	mu sync.Mutex
+
+	// nodes is keyed by the node ID of the reference.
+	nodes map[uint64]*diffNode
+
+	// files and dirs are keyed by the file handle of the
+	// reference.
+	files map[uint64]uint64
+	dirs  map[uint64]*diffDir
+}
+
+type diffNode struct {
+	// Node ID in test, or 0 if test did not find the entry.
+	id uint64
+
+	refLookups  uint64
+	testLookups uint64
+}
+
+type diffDir struct {
+	fh uint64
+
+	// Listings by name, holding the dirent type.
+	ref       map[string]uint32
+	test      map[string]uint32
+	testCode  Status
+	refDone   bool
+	testValid bool
+
+	// READDIRPLUS entries of test that the kernel has not seen
+	// yet. Test holds a lookup for each of them.
+	pending map[string]EntryOut
+}
+
+func (fs *diffRawFileSystem) report(op, name string, want, got string) {
+	if want != got {
+		fs.opts.Report(&Divergence{Op: op, Name: name, Want: want, Got: got})
+	}
+}
+
+func (fs *diffRawFileSystem) status(op, name string, want, got Status) {
+	fs.report(op, name, want.String(), got.String())
+}
+
+func nodeName(nodeid uint64, name string) string {
+	if name == "" {
+		return fmt.Sprint(nodeid)
+	}
+	return fmt.Sprintf("%d/%s", nodeid, name)
+}
+
+// testNode translates a node ID of the reference.
+func (fs *diffRawFileSystem) testNode(nodeid uint64) (uint64, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := fs.nodes[nodeid]
+	if n == nil || n.id == 0 {
+		return 0, false
+	}
+	return n.id, true
+}
+
+// testHeader returns a copy of header for test.
+func (fs *diffRawFileSystem) testHeader(header *InHeader) (InHeader, bool) {
+	h := *header
+	id, ok := fs.testNode(header.NodeId)
+	h.NodeId = id
+	return h, ok
+}
+
+func (fs *diffRawFileSystem) testFile(fh uint64) (uint64, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	id, ok := fs.files[fh]
+	return id, ok
+}
+
+func (fs *diffRawFileSystem) testDir(fh uint64) *diffDir {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.dirs[fh]
+}
+
+// entry compares the results of an operation returning a new entry,
+// and records the node IDs.
+func (fs *diffRawFileSystem) entry(op, name string, code Status, out *EntryOut, testCode Status, testOut *EntryOut) {
+	fs.report(op, name, fs.opts.FormatAttr(&out.Attr, code), fs.opts.FormatAttr(&testOut.Attr, testCode))
+	fs.addEntry(code, out, testCode, testOut)
+}
+
+func (fs *diffRawFileSystem) addEntry(code Status, out *EntryOut, testCode Status, testOut *EntryOut) {
+	testFound := testCode.Ok() && testOut.NodeId != 0
+	if !code.Ok() || out.NodeId == 0 {
+		if testFound {
+			// The kernel will never forget this one.
+			fs.test.Forget(testOut.NodeId, 1)
+		}
+		return
+	}
+
+	fs.mu.Lock()
+	n := fs.nodes[out.NodeId]
+	if n == nil {
+		n = &diffNode{}
+		fs.nodes[out.NodeId] = n
+	}
+	n.refLookups++
+	extra := false
+	if testFound {
+		if n.id == 0 {
+			n.id = testOut.NodeId
+		}
+		if n.id == testOut.NodeId {
+			n.testLookups++
+		} else {
+			// The reference considers two entries the same
+			// node, but test doesn't. Requests go to the
+			// first node that test returned.
+			extra = true
+		}
+	}
+	fs.mu.Unlock()
+
+	if extra {
+		fs.test.Forget(testOut.NodeId, 1)
+	}
+}
+
+func (fs *diffRawFileSystem) String() string {
+	return fmt.Sprintf("DiffRawFileSystem(%v, %v)", fs.ref, fs.test)
+}
+
+func (fs *diffRawFileSystem) SetDebug(debug bool) {
+	fs.ref.SetDebug(debug)
+	fs.test.SetDebug(debug)
+}
+
+func (fs *diffRawFileSystem) Init(server *Server) {
+	fs.ref.Init(server)
+}
+
+func (fs *diffRawFileSystem) Lookup(header *InHeader, name string, out *EntryOut) Status {
+	code := fs.ref.Lookup(header, name, out)
+	h, ok := fs.testHeader(header)
+	if !ok {
+		return code
+	}
+	var testOut EntryOut
+	testCode := fs.test.Lookup(&h, name, &testOut)
+	fs.entry("Lookup", nodeName(header.NodeId, name), code, out, testCode, &testOut)
+	return code
+}
+
+func (fs *diffRawFileSystem) Forget(nodeid, nlookup uint64) {
+	fs.ref.Forget(nodeid, nlookup)
+	if nodeid == FUSE_ROOT_ID {
+		return
+	}
+
+	fs.mu.Lock()
+	n := fs.nodes[nodeid]
+	if n == nil {
+		fs.mu.Unlock()
+		return
+	}
+	if nlookup > n.refLookups {
+		nlookup = n.refLookups
+	}
+	n.refLookups -= nlookup
+	forget := nlookup
+	if forget > n.testLookups || n.refLookups == 0 {
+		forget = n.testLookups
+	}
+	n.testLookups -= forget
+	if n.refLookups == 0 {
+		delete(fs.nodes, nodeid)
+	}
+	id := n.id
+	fs.mu.Unlock()
+
+	if forget > 0 {
+		fs.test.Forget(id, forget)
+	}
+}
+
+func (fs *diffRawFileSystem) GetAttr(input *GetAttrIn, out *AttrOut) Status {
+	code := fs.ref.GetAttr(input, out)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	if input.Flags()&FUSE_GETATTR_FH != 0 {
+		fh, ok := fs.testFile(input.Fh())
+		if !ok {
+			return code
+		}
+		in.setFh(fh)
+	}
+	var testOut AttrOut
+	testCode := fs.test.GetAttr(&in, &testOut)
+	fs.report("GetAttr", nodeName(input.NodeId, ""),
+		fs.opts.FormatAttr(&out.Attr, code), fs.opts.FormatAttr(&testOut.Attr, testCode))
+	return code
+}
+
+func (fs *diffRawFileSystem) SetAttr(input *SetAttrIn, out *AttrOut) Status {
+	code := fs.ref.SetAttr(input, out)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	if input.Valid&FATTR_FH != 0 {
+		if in.Fh, ok = fs.testFile(input.Fh); !ok {
+			return code
+		}
+	}
+	var testOut AttrOut
+	testCode := fs.test.SetAttr(&in, &testOut)
+	fs.report("SetAttr", nodeName(input.NodeId, ""),
+		fs.opts.FormatAttr(&out.Attr, code), fs.opts.FormatAttr(&testOut.Attr, testCode))
+	return code
+}
+
+func (fs *diffRawFileSystem) Mknod(input *MknodIn, name string, out *EntryOut) Status {
+	code := fs.ref.Mknod(input, name, out)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	var testOut EntryOut
+	testCode := fs.test.Mknod(&in, name, &testOut)
+	fs.entry("Mknod", nodeName(input.NodeId, name), code, out, testCode, &testOut)
+	return code
+}
+
+func (fs *diffRawFileSystem) Mkdir(input *MkdirIn, name string, out *EntryOut) Status {
+	code := fs.ref.Mkdir(input, name, out)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	var testOut EntryOut
+	testCode := fs.test.Mkdir(&in, name, &testOut)
+	fs.entry("Mkdir", nodeName(input.NodeId, name), code, out, testCode, &testOut)
+	return code
+}
+
+func (fs *diffRawFileSystem) Unlink(header *InHeader, name string) Status {
+	code := fs.ref.Unlink(header, name)
+	if h, ok := fs.testHeader(header); ok {
+		fs.status("Unlink", nodeName(header.NodeId, name), code, fs.test.Unlink(&h, name))
+	}
+	return code
+}
+
+func (fs *diffRawFileSystem) Rmdir(header *InHeader, name string) Status {
+	code := fs.ref.Rmdir(header, name)
+	if h, ok := fs.testHeader(header); ok {
+		fs.status("Rmdir", nodeName(header.NodeId, name), code, fs.test.Rmdir(&h, name))
+	}
+	return code
+}
+
+func (fs *diffRawFileSystem) Rename(input *RenameIn, oldName string, newName string) Status {
+	code := fs.ref.Rename(input, oldName, newName)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	if in.Newdir, ok = fs.testNode(input.Newdir); !ok {
+		return code
+	}
+	fs.status("Rename", nodeName(input.NodeId, oldName), code, fs.test.Rename(&in, oldName, newName))
+	return code
+}
+
+func (fs *diffRawFileSystem) Link(input *LinkIn, filename string, out *EntryOut) Status {
+	code := fs.ref.Link(input, filename, out)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	if in.Oldnodeid, ok = fs.testNode(input.Oldnodeid); !ok {
+		return code
+	}
+	var testOut EntryOut
+	testCode := fs.test.Link(&in, filename, &testOut)
+	fs.entry("Link", nodeName(input.NodeId, filename), code, out, testCode, &testOut)
+	return code
+}
+
+func (fs *diffRawFileSystem) Symlink(header *InHeader, pointedTo string, linkName string, out *EntryOut) Status {
+	code := fs.ref.Symlink(header, pointedTo, linkName, out)
+	h, ok := fs.testHeader(header)
+	if !ok {
+		return code
+	}
+	var testOut EntryOut
+	testCode := fs.test.Symlink(&h, pointedTo, linkName, &testOut)
+	fs.entry("Symlink", nodeName(header.NodeId, linkName), code, out, testCode, &testOut)
+	return code
+}
+
+// dataString formats the result of an operation returning bytes.
+func dataString(data []byte, code Status) string {
+	if !code.Ok() {
+		return code.String()
+	}
+	return fmt.Sprintf("%q", data)
+}
+
+func (fs *diffRawFileSystem) Readlink(header *InHeader) ([]byte, Status) {
+	out, code := fs.ref.Readlink(header)
+	if h, ok := fs.testHeader(header); ok {
+		testOut, testCode := fs.test.Readlink(&h)
+		fs.report("Readlink", nodeName(header.NodeId, ""), dataString(out, code), dataString(testOut, testCode))
+	}
+	return out, code
+}
+
+func (fs *diffRawFileSystem) Access(input *AccessIn) Status {
+	code := fs.ref.Access(input)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); ok {
+		fs.status("Access", nodeName(input.NodeId, ""), code, fs.test.Access(&in))
+	}
+	return code
+}
+
+func (fs *diffRawFileSystem) GetXAttrSize(header *InHeader, attr string) (int, Status) {
+	sz, code := fs.ref.GetXAttrSize(header, attr)
+	if h, ok := fs.testHeader(header); ok {
+		testSz, testCode := fs.test.GetXAttrSize(&h, attr)
+		want, got := code.String(), testCode.String()
+		if code.Ok() {
+			want = fmt.Sprint(sz)
+		}
+		if testCode.Ok() {
+			got = fmt.Sprint(testSz)
+		}
+		fs.report("GetXAttrSize", nodeName(header.NodeId, attr), want, got)
+	}
+	return sz, code
+}
+
+func (fs *diffRawFileSystem) GetXAttrData(header *InHeader, attr string) ([]byte, Status) {
+	data, code := fs.ref.GetXAttrData(header, attr)
+	if h, ok := fs.testHeader(header); ok {
+		testData, testCode := fs.test.GetXAttrData(&h, attr)
+		fs.report("GetXAttrData", nodeName(header.NodeId, attr), dataString(data, code), dataString(testData, testCode))
+	}
+	return data, code
+}
+
+// xattrNames formats a list of attribute names, which the two file
+// systems may return in a different order.
+func xattrNames(data []byte, code Status) string {
+	if !code.Ok() {
+		return code.String()
+	}
+	var names []string
+	for _, n := range bytes.Split(data, []byte{0}) {
+		if len(n) > 0 {
+			names = append(names, string(n))
+		}
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("%q", names)
+}
+
+func (fs *diffRawFileSystem) ListXAttr(header *InHeader) ([]byte, Status) {
+	data, code := fs.ref.ListXAttr(header)
+	if h, ok := fs.testHeader(header); ok {
+		testData, testCode := fs.test.ListXAttr(&h)
+		fs.report("ListXAttr", nodeName(header.NodeId, ""), xattrNames(data, code), xattrNames(testData, testCode))
+	}
+	return data, code
+}
+
+func (fs *diffRawFileSystem) SetXAttr(input *SetXAttrIn, attr string, data []byte) Status {
+	code := fs.ref.SetXAttr(input, attr, data)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); ok {
+		fs.status("SetXAttr", nodeName(input.NodeId, attr), code, fs.test.SetXAttr(&in, attr, data))
+	}
+	return code
+}
+
+func (fs *diffRawFileSystem) RemoveXAttr(header *InHeader, attr string) Status {
+	code := fs.ref.RemoveXAttr(header, attr)
+	if h, ok := fs.testHeader(header); ok {
+		fs.status("RemoveXAttr", nodeName(header.NodeId, attr), code, fs.test.RemoveXAttr(&h, attr))
+	}
+	return code
+}
+
+// addFile records the file handles of an opened file. If only test
+// opened it, test's handle is released.
+func (fs *diffRawFileSystem) addFile(code Status, out *OpenOut, testCode Status, testOut *OpenOut, header *InHeader) {
+	if !code.Ok() {
+		if testCode.Ok() {
+			fs.test.Release(&ReleaseIn{InHeader: *header, Fh: testOut.Fh})
+		}
+		return
+	}
+	if !testCode.Ok() {
+		return
+	}
+	fs.mu.Lock()
+	fs.files[out.Fh] = testOut.Fh
+	fs.mu.Unlock()
+}
+
+func (fs *diffRawFileSystem) Create(input *CreateIn, name string, out *CreateOut) Status {
+	code := fs.ref.Create(input, name, out)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	var testOut CreateOut
+	testCode := fs.test.Create(&in, name, &testOut)
+	fs.entry("Create", nodeName(input.NodeId, name), code, &out.EntryOut, testCode, &testOut.EntryOut)
+
+	h := in.InHeader
+	h.NodeId = testOut.NodeId
+	fs.addFile(code, &out.OpenOut, testCode, &testOut.OpenOut, &h)
+	return code
+}
+
+func (fs *diffRawFileSystem) Open(input *OpenIn, out *OpenOut) Status {
+	code := fs.ref.Open(input, out)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	var testOut OpenOut
+	testCode := fs.test.Open(&in, &testOut)
+	fs.status("Open", nodeName(input.NodeId, ""), code, testCode)
+	fs.addFile(code, out, testCode, &testOut, &in.InHeader)
+	return code
+}
+
+func (fs *diffRawFileSystem) Read(input *ReadIn, buf []byte) (ReadResult, Status) {
+	res, code := fs.ref.Read(input, buf)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return res, code
+	}
+	if in.Fh, ok = fs.testFile(input.Fh); !ok {
+		return res, code
+	}
+
+	var data []byte
+	if code.Ok() {
+		data, code = res.Bytes(buf)
+		res.Done()
+		res = ReadResultData(data)
+	}
+
+	testRes, testCode := fs.test.Read(&in, make([]byte, len(buf)))
+	var testData []byte
+	if testCode.Ok() {
+		testData, testCode = testRes.Bytes(make([]byte, len(buf)))
+		testRes.Done()
+	}
+	fs.report("Read", nodeName(input.NodeId, ""), dataString(data, code), dataString(testData, testCode))
+	return res, code
+}
+
+func (fs *diffRawFileSystem) GetLk(input *LkIn, out *LkOut) Status {
+	return fs.ref.GetLk(input, out)
+}
+
+func (fs *diffRawFileSystem) SetLk(input *LkIn) Status {
+	return fs.ref.SetLk(input)
+}
+
+func (fs *diffRawFileSystem) SetLkw(input *LkIn) Status {
+	return fs.ref.SetLkw(input)
+}
+
+func (fs *diffRawFileSystem) Release(input *ReleaseIn) {
+	fs.ref.Release(input)
+
+	fs.mu.Lock()
+	fh, ok := fs.files[input.Fh]
+	delete(fs.files, input.Fh)
+	fs.mu.Unlock()
+
+	in := *input
+	var nodeOK bool
+	if in.InHeader, nodeOK = fs.testHeader(&input.InHeader); ok && nodeOK {
+		in.Fh = fh
+		fs.test.Release(&in)
+	}
+}
+
+func (fs *diffRawFileSystem) Write(input *WriteIn, data []byte) (uint32, Status) {
+	written, code := fs.ref.Write(input, data)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return written, code
+	}
+	if in.Fh, ok = fs.testFile(input.Fh); !ok {
+		return written, code
+	}
+	testWritten, testCode := fs.test.Write(&in, data)
+	want, got := code.String(), testCode.String()
+	if code.Ok() {
+		want = fmt.Sprint(written)
+	}
+	if testCode.Ok() {
+		got = fmt.Sprint(testWritten)
+	}
+	fs.report("Write", nodeName(input.NodeId, ""), want, got)
+	return written, code
+}
+
+func (fs *diffRawFileSystem) Flush(input *FlushIn) Status {
+	code := fs.ref.Flush(input)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	if in.Fh, ok = fs.testFile(input.Fh); ok {
+		fs.status("Flush", nodeName(input.NodeId, ""), code, fs.test.Flush(&in))
+	}
+	return code
+}
+
+func (fs *diffRawFileSystem) Fsync(input *FsyncIn) Status {
+	code := fs.ref.Fsync(input)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	if in.Fh, ok = fs.testFile(input.Fh); ok {
+		fs.status("Fsync", nodeName(input.NodeId, ""), code, fs.test.Fsync(&in))
+	}
+	return code
+}
+
+func (fs *diffRawFileSystem) Fallocate(input *FallocateIn) Status {
+	code := fs.ref.Fallocate(input)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	if in.Fh, ok = fs.testFile(input.Fh); ok {
+		fs.status("Fallocate", nodeName(input.NodeId, ""), code, fs.test.Fallocate(&in))
+	}
+	return code
+}
+
+func (fs *diffRawFileSystem) Bmap(input *BmapIn, out *BmapOut) Status {
+	code := fs.ref.Bmap(input, out)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	var testOut BmapOut
+	testCode := fs.test.Bmap(&in, &testOut)
+	fs.report("Bmap", nodeName(input.NodeId, ""), bmapString(out, code), bmapString(&testOut, testCode))
+	return code
+}
+
+func bmapString(out *BmapOut, code Status) string {
+	if !code.Ok() {
+		return code.String()
+	}
+	return out.String()
+}
+
+func (fs *diffRawFileSystem) OpenDir(input *OpenIn, out *OpenOut) Status {
+	code := fs.ref.OpenDir(input, out)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return code
+	}
+	var testOut OpenOut
+	testCode := fs.test.OpenDir(&in, &testOut)
+	fs.status("OpenDir", nodeName(input.NodeId, ""), code, testCode)
+	if !code.Ok() {
+		if testCode.Ok() {
+			fs.test.ReleaseDir(&ReleaseIn{InHeader: in.InHeader, Fh: testOut.Fh})
+		}
+		return code
+	}
+	if testCode.Ok() {
+		fs.mu.Lock()
+		fs.dirs[out.Fh] = &diffDir{fh: testOut.Fh}
+		fs.mu.Unlock()
+	}
+	return code
+}
+
+// readTestDir reads the complete listing of test. The kernel reads
+// the reference in chunks, at offsets that only the reference
+// understands, so the listings are compared once the reference
+// reaches the end.
+func (fs *diffRawFileSystem) readTestDir(input *ReadIn, d *diffDir, plus bool) {
+	fs.forgetPending(d)
+	d.ref = map[string]uint32{}
+	d.test = map[string]uint32{}
+	d.refDone = false
+	d.testValid = false
+
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); !ok {
+		return
+	}
+	in.Fh = d.fh
+	in.Offset = 0
+	size := in.Size
+	if size < 4096 {
+		size = 4096
+	}
+	for {
+		out := NewDirEntryList(make([]byte, size), in.Offset)
+		var code Status
+		if plus {
+			code = fs.test.ReadDirPlus(&in, out)
+		} else {
+			code = fs.test.ReadDir(&in, out)
+		}
+		d.testCode = code
+		if !code.Ok() {
+			break
+		}
+		n := 0
+		parseDirents(out.bytes(), plus, func(name string, dirent *_Dirent, entry *EntryOut) {
+			n++
+			if name == "." || name == ".." {
+				return
+			}
+			d.test[name] = dirent.Typ
+			if entry != nil && entry.NodeId != 0 {
+				if d.pending == nil {
+					d.pending = map[string]EntryOut{}
+				}
+				d.pending[name] = *entry
+			}
+		})
+		if n == 0 {
+			break
+		}
+		in.Offset = out.offset
+	}
+	d.testValid = true
+}
+
+// forgetPending drops the lookups that test took in READDIRPLUS for
+// entries the kernel never saw.
+func (fs *diffRawFileSystem) forgetPending(d *diffDir) {
+	for _, e := range d.pending {
+		fs.test.Forget(e.NodeId, 1)
+	}
+	d.pending = nil
+}
+
+// dirString formats a listing. Entry types are only shown if known,
+// since not every file system fills them in.
+func dirString(entries map[string]uint32, other map[string]uint32, code Status) string {
+	if !code.Ok() {
+		return code.String()
+	}
+	var names []string
+	for n, typ := range entries {
+		if typ != 0 && other[n] != 0 {
+			n = fmt.Sprintf("%s(%o)", n, typ)
+		}
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+func (fs *diffRawFileSystem) readDir(op string, input *ReadIn, out *DirEntryList, plus bool) Status {
+	start := len(out.buf)
+	var code Status
+	if plus {
+		code = fs.ref.ReadDirPlus(input, out)
+	} else {
+		code = fs.ref.ReadDir(input, out)
+	}
+
+	d := fs.testDir(input.Fh)
+	if d == nil {
+		return code
+	}
+
+	// The kernel serializes requests on a directory handle.
+	if input.Offset == 0 {
+		fs.readTestDir(input, d, plus)
+	}
+	if !d.testValid || d.refDone {
+		return code
+	}
+
+	name := nodeName(input.NodeId, "")
+	if !code.Ok() {
+		fs.status(op, name, code, d.testCode)
+		d.refDone = true
+		return code
+	}
+
+	n := 0
+	parseDirents(out.buf[start:], plus, func(entryName string, dirent *_Dirent, entry *EntryOut) {
+		n++
+		if entryName == "." || entryName == ".." {
+			return
+		}
+		d.ref[entryName] = dirent.Typ
+		if entry == nil || entry.NodeId == 0 {
+			return
+		}
+		testEntry, ok := d.pending[entryName]
+		delete(d.pending, entryName)
+		testCode := OK
+		if !ok {
+			testCode = ENOENT
+		}
+		fs.addEntry(OK, entry, testCode, &testEntry)
+	})
+	if n == 0 {
+		d.refDone = true
+		fs.report(op, name, dirString(d.ref, d.test, OK), dirString(d.test, d.ref, d.testCode))
+	}
+	return code
+}
+
+func (fs *diffRawFileSystem) ReadDir(input *ReadIn, out *DirEntryList) Status {
+	return fs.readDir("ReadDir", input, out, false)
+}
+
+func (fs *diffRawFileSystem) ReadDirPlus(input *ReadIn, out *DirEntryList) Status {
+	return fs.readDir("ReadDirPlus", input, out, true)
+}
+
+func (fs *diffRawFileSystem) ReleaseDir(input *ReleaseIn) {
+	fs.ref.ReleaseDir(input)
+
+	fs.mu.Lock()
+	d := fs.dirs[input.Fh]
+	delete(fs.dirs, input.Fh)
+	fs.mu.Unlock()
+	if d == nil {
+		return
+	}
+
+	fs.forgetPending(d)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); ok {
+		in.Fh = d.fh
+		fs.test.ReleaseDir(&in)
+	}
+}
+
+func (fs *diffRawFileSystem) FsyncDir(input *FsyncIn) Status {
+	code := fs.ref.FsyncDir(input)
+	d := fs.testDir(input.Fh)
+	in := *input
+	var ok bool
+	if in.InHeader, ok = fs.testHeader(&input.InHeader); ok && d != nil {
+		in.Fh = d.fh
+		fs.status("FsyncDir", nodeName(input.NodeId, ""), code, fs.test.FsyncDir(&in))
+	}
+	return code
+}
+
+func (fs *diffRawFileSystem) StatFs(header *InHeader, out *StatfsOut) Status {
+	code := fs.ref.StatFs(header, out)
+	if h, ok := fs.testHeader(header); ok {
+		// The numbers depend on the backing store, so only
+		// compare whether it worked.
+		var testOut StatfsOut
+		fs.status("StatFs", nodeName(header.NodeId, ""), code, fs.test.StatFs(&h, &testOut))
+	}
+	return code
+}
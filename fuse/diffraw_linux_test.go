@@ -0,0 +1,125 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// TestDiffRawFileSystem compares a pathfs loopback with a nodefs
+// memory file system, whose node IDs and file handles differ.
+func TestDiffRawFileSystem(t *testing.T) {
+	refDir := testutil.TempDir()
+	defer os.RemoveAll(refDir)
+	memDir := testutil.TempDir()
+	defer os.RemoveAll(memDir)
+
+	refConn := nodefs.NewFileSystemConnector(
+		pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(refDir), nil).Root(), nil)
+	testConn := nodefs.NewFileSystemConnector(
+		nodefs.NewMemNodeFSRoot(memDir+"/"), nil)
+
+	var mu sync.Mutex
+	var divergences []*fuse.Divergence
+	fs := fuse.NewDiffRawFileSystem(refConn.RawFS(), testConn.RawFS(), &fuse.DiffOptions{
+		Report: func(d *fuse.Divergence) {
+			mu.Lock()
+			defer mu.Unlock()
+			divergences = append(divergences, d)
+		},
+	})
+	k, err := fakekernel.New(fs, &fuse.MountOptions{Debug: testutil.VerboseTest()})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	check := func(what string, wantDiverge bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if got := len(divergences) > 0; got != wantDiverge {
+			t.Errorf("%s: got divergences %v, want any: %v", what, divergences, wantDiverge)
+		}
+		divergences = nil
+	}
+
+	dir, code := k.Mkdir(fuse.FUSE_ROOT_ID, "dir", 0755)
+	if !code.Ok() {
+		t.Fatalf("Mkdir: %v", code)
+	}
+	created, code := k.Create(dir.NodeId, "file", syscall.O_RDWR, 0644)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	if n, code := k.Write(created.NodeId, created.Fh, 0, []byte("hello")); !code.Ok() || n != 5 {
+		t.Fatalf("Write: %d %v", n, code)
+	}
+	if data, code := k.Read(created.NodeId, created.Fh, 0, 100); !code.Ok() || string(data) != "hello" {
+		t.Errorf("Read: %q %v", data, code)
+	}
+	if code := k.Flush(created.NodeId, created.Fh); !code.Ok() {
+		t.Errorf("Flush: %v", code)
+	}
+	if code := k.Release(created.NodeId, created.Fh); !code.Ok() {
+		t.Errorf("Release: %v", code)
+	}
+	if _, code := k.GetAttr(created.NodeId); !code.Ok() {
+		t.Errorf("GetAttr: %v", code)
+	}
+	if code := k.Rename(dir.NodeId, "file", fuse.FUSE_ROOT_ID, "moved"); !code.Ok() {
+		t.Errorf("Rename: %v", code)
+	}
+	k.Forget(created.NodeId, 1)
+
+	readDir := func(node uint64) {
+		open, code := k.OpenDir(node)
+		if !code.Ok() {
+			t.Fatalf("OpenDir: %v", code)
+		}
+		var off uint64
+		for {
+			ents, code := k.ReadDir(node, open.Fh, off, 4096)
+			if !code.Ok() {
+				t.Fatalf("ReadDir: %v", code)
+			}
+			if len(ents) == 0 {
+				break
+			}
+			off = ents[len(ents)-1].Off
+		}
+		k.ReleaseDir(node, open.Fh)
+	}
+	readDir(fuse.FUSE_ROOT_ID)
+	check("identical trees", false)
+
+	// Only the reference sees this file.
+	if err := ioutil.WriteFile(filepath.Join(refDir, "extra"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	readDir(fuse.FUSE_ROOT_ID)
+	check("ReadDir with extra file", true)
+
+	extra, code := k.Lookup(fuse.FUSE_ROOT_ID, "extra")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	check("Lookup of extra file", true)
+
+	// The test file system has no node for it, so this is not
+	// compared.
+	k.GetAttr(extra.NodeId)
+	check("GetAttr of extra file", false)
+}
@@ -8,6 +8,7 @@ package fuse
 
 import (
 	"fmt"
+	"syscall"
 	"unsafe"
 )
 
@@ -19,7 +20,10 @@ const direntSize = int(unsafe.Sizeof(_Dirent{}))
 // directory contents in.
 type DirEntry struct {
 	// Mode is the file's mode. Only the high bits (eg. S_IFDIR)
-	// are considered.
+	// are considered; they are sent to the kernel as the d_type
+	// of the entry, so tools listing the directory can tell
+	// directories from files without stat'ing each entry. If
+	// they are unset, the type is reported as unknown.
 	Mode uint32
 
 	// Name is the basename of the file in the directory.
@@ -27,6 +31,27 @@ type DirEntry struct {
 
 	// Ino is the inode number.
 	Ino uint64
+
+	// Off is the offset of the entry in the directory stream: the
+	// kernel passes it back to read on after this entry, and
+	// telldir(3) returns it. It should stay valid while other
+	// entries are added and removed. If zero, AddDirEntry uses
+	// the offset of the previous entry plus one. nodefs sets it
+	// for the entries returned from Node.OpenDir.
+	Off uint64
+}
+
+// DirentType returns the file type in mode as a DT_* value, as stored
+// in the d_type field of directory entries. It is DT_UNKNOWN (0) if
+// mode has no file type bits.
+func DirentType(mode uint32) uint32 {
+	return (mode & syscall.S_IFMT) >> 12
+}
+
+// DirentMode returns the S_IFMT bits of a mode for a DT_* value. It
+// is 0 for DT_UNKNOWN.
+func DirentMode(typ uint32) uint32 {
+	return (typ << 12) & syscall.S_IFMT
 }
 
 func (d DirEntry) String() string {
@@ -54,12 +79,19 @@ func NewDirEntryList(data []byte, off uint64) *DirEntryList {
 // AddDirEntry tries to add an entry, and reports whether it
 // succeeded.
 func (l *DirEntryList) AddDirEntry(e DirEntry) (bool, uint64) {
-	return l.Add(0, e.Name, e.Ino, e.Mode)
+	return l.add(0, e.Name, e.Ino, e.Mode, e.Off)
 }
 
 // Add adds a direntry to the DirEntryList, returning whether it
 // succeeded.
 func (l *DirEntryList) Add(prefix int, name string, inode uint64, mode uint32) (bool, uint64) {
+	return l.add(prefix, name, inode, mode, 0)
+}
+
+func (l *DirEntryList) add(prefix int, name string, inode uint64, mode uint32, off uint64) (bool, uint64) {
+	if off == 0 {
+		off = l.offset + 1
+	}
 	if inode == 0 {
 		inode = FUSE_UNKNOWN_INO
 	}
@@ -74,10 +106,10 @@ func (l *DirEntryList) Add(prefix int, name string, inode uint64, mode uint32) (
 	l.buf = l.buf[:newLen]
 	oldLen += prefix
 	dirent := (*_Dirent)(unsafe.Pointer(&l.buf[oldLen]))
-	dirent.Off = l.offset + 1
+	dirent.Off = off
 	dirent.Ino = inode
 	dirent.NameLen = uint32(len(name))
-	dirent.Typ = (mode & 0170000) >> 12
+	dirent.Typ = DirentType(mode)
 	oldLen += direntSize
 	copy(l.buf[oldLen:], name)
 	oldLen += len(name)
@@ -95,8 +127,8 @@ func (l *DirEntryList) Add(prefix int, name string, inode uint64, mode uint32) (
 // pointer.
 func (l *DirEntryList) AddDirLookupEntry(e DirEntry) (*EntryOut, uint64) {
 	lastStart := len(l.buf)
-	ok, off := l.Add(int(unsafe.Sizeof(EntryOut{})), e.Name,
-		e.Ino, e.Mode)
+	ok, off := l.add(int(unsafe.Sizeof(EntryOut{})), e.Name,
+		e.Ino, e.Mode, e.Off)
 	if !ok {
 		return nil, off
 	}
@@ -106,3 +138,32 @@ func (l *DirEntryList) AddDirLookupEntry(e DirEntry) (*EntryOut, uint64) {
 func (l *DirEntryList) bytes() []byte {
 	return l.buf
 }
+
+// parseDirents calls fn for each entry serialized in buf by Add. If
+// plus is set, the entries carry the EntryOut of READDIRPLUS, which
+// is passed as entry.
+func parseDirents(buf []byte, plus bool, fn func(name string, dirent *_Dirent, entry *EntryOut)) {
+	prefix := 0
+	if plus {
+		prefix = int(unsafe.Sizeof(EntryOut{}))
+	}
+	for len(buf) >= prefix+direntSize {
+		var entry *EntryOut
+		if plus {
+			entry = (*EntryOut)(unsafe.Pointer(&buf[0]))
+		}
+		dirent := (*_Dirent)(unsafe.Pointer(&buf[prefix]))
+		nameStart := prefix + direntSize
+		nameEnd := nameStart + int(dirent.NameLen)
+		if nameEnd > len(buf) {
+			return
+		}
+		fn(string(buf[nameStart:nameEnd]), dirent, entry)
+
+		next := (nameEnd + 7) &^ 7
+		if next > len(buf) {
+			return
+		}
+		buf = buf[next:]
+	}
+}
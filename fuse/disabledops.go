@@ -0,0 +1,41 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+)
+
+// opcodeByName returns the opcode of the operation named as in debug
+// output, eg. "MKNOD", if the server handles it.
+func opcodeByName(name string) (int32, bool) {
+	for op, h := range operationHandlers {
+		if h.Func != nil && h.Name == name {
+			return int32(op), true
+		}
+	}
+	return 0, false
+}
+
+// newDisabledOps checks MountOptions.DisabledOps, and returns it by
+// opcode.
+func newDisabledOps(ops map[string]Status) (map[int32]Status, error) {
+	r := map[int32]Status{}
+	for name, code := range ops {
+		op, ok := opcodeByName(name)
+		if !ok {
+			return nil, fmt.Errorf("DisabledOps: unknown operation %q", name)
+		}
+		switch op {
+		case _OP_INIT, _OP_DESTROY, _OP_FORGET, _OP_BATCH_FORGET, _OP_INTERRUPT:
+			return nil, fmt.Errorf("DisabledOps: cannot disable %s", name)
+		}
+		if code.Ok() {
+			code = ENOSYS
+		}
+		r[op] = code
+	}
+	return r, nil
+}
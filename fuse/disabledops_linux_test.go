@@ -0,0 +1,62 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// statFS answers STATFS and GETATTR.
+type statFS struct {
+	fuse.RawFileSystem
+	calls int
+}
+
+func (fs *statFS) StatFs(input *fuse.InHeader, out *fuse.StatfsOut) fuse.Status {
+	fs.calls++
+	return fuse.OK
+}
+
+func (fs *statFS) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	fs.calls++
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func TestDisabledOps(t *testing.T) {
+	fs := &statFS{RawFileSystem: fuse.NewDefaultRawFileSystem()}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{
+		DisabledOps: map[string]fuse.Status{
+			"STATFS":  fuse.OK,
+			"GETATTR": fuse.EPERM,
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	if _, code := k.StatFs(fuse.FUSE_ROOT_ID); code != fuse.ENOSYS {
+		t.Errorf("StatFs: got %v, want ENOSYS", code)
+	}
+	if _, code := k.GetAttr(fuse.FUSE_ROOT_ID); code != fuse.EPERM {
+		t.Errorf("GetAttr: got %v, want EPERM", code)
+	}
+	if fs.calls != 0 {
+		t.Errorf("file system got %d calls", fs.calls)
+	}
+
+	for _, name := range []string{"NOSUCHOP", "FORGET"} {
+		_, err := fakekernel.New(fs, &fuse.MountOptions{
+			DisabledOps: map[string]fuse.Status{name: fuse.OK},
+		})
+		if err == nil {
+			t.Errorf("DisabledOps %s: no error", name)
+		}
+	}
+}
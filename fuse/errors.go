@@ -0,0 +1,119 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Error is an error status, with a message that says what went
+// wrong. Only Status goes to the kernel; see Fail for getting the
+// message into the log.
+type Error struct {
+	Status Status
+	Msg    string
+}
+
+// Errorf returns an Error with status code, and a message formatted
+// as for fmt.Sprintf.
+func Errorf(code Status, format string, args ...interface{}) *Error {
+	return &Error{Status: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%v)", e.Msg, e.Status)
+}
+
+// Fail returns the status for err, as ToStatus does, and has the
+// server log err along with the request it fails, once the file
+// system method returns. This lets a method return a precise errno
+// and still leave a useful log line:
+//
+//	if err != nil {
+//		return fuse.Fail(context, fuse.Errorf(fuse.EIO, "fetch %q: %v", key, err))
+//	}
+//
+// As for Server.RequestInfo, ctx must be the Context passed to the
+// method; for copies, once the method has returned, and if ctx is
+// nil, err is logged right away, with the standard logger.
+func Fail(ctx *Context, err error) Status {
+	code := ToStatus(err)
+	if code.Ok() {
+		return code
+	}
+	if ctx == nil || !dispatching.fail(ctx, err.Error()) {
+		log.Print(err)
+	}
+	return code
+}
+
+// dispatchTable holds the requests whose file system method runs, by
+// the address of their Context, so Fail can leave its message on the
+// request. The headers of live requests do not overlap, also across
+// servers, so the addresses are unique. They are spread over shards,
+// as the ids in pendingReplies are.
+type dispatchTable struct {
+	shards [dispatchShards]dispatchShard
+}
+
+const dispatchShards = 32
+
+type dispatchShard struct {
+	mu   sync.Mutex
+	reqs map[uintptr]*request
+}
+
+var dispatching dispatchTable
+
+func (t *dispatchTable) shard(key uintptr) *dispatchShard {
+	// Headers are at least 8-byte aligned.
+	return &t.shards[(key>>3)%dispatchShards]
+}
+
+// add registers req while its method runs.
+func (t *dispatchTable) add(req *request) {
+	key := contextKey(&req.inHeader.Context)
+	sh := t.shard(key)
+	sh.mu.Lock()
+	if sh.reqs == nil {
+		sh.reqs = map[uintptr]*request{}
+	}
+	sh.reqs[key] = req
+	sh.mu.Unlock()
+}
+
+// remove undoes add. Messages from Fail come in before it returns.
+func (t *dispatchTable) remove(req *request) {
+	key := contextKey(&req.inHeader.Context)
+	sh := t.shard(key)
+	sh.mu.Lock()
+	delete(sh.reqs, key)
+	sh.mu.Unlock()
+}
+
+// fail stores msg on the request of ctx, and reports whether there
+// is one.
+func (t *dispatchTable) fail(ctx *Context, msg string) bool {
+	key := contextKey(ctx)
+	sh := t.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	req := sh.reqs[key]
+	if req == nil {
+		return false
+	}
+	req.failure = msg
+	return true
+}
+
+// logFailure logs the message that the file system left for req
+// with Fail.
+func (ms *Server) logFailure(req *request) {
+	if req.failure != "" {
+		ms.opts.Logger.Printf("%s i%d: %s", operationName(req.inHeader.Opcode), req.inHeader.NodeId, req.failure)
+	}
+}
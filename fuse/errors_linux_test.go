@@ -0,0 +1,93 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+type failFS struct {
+	fuse.RawFileSystem
+}
+
+func (fs *failFS) GetAttr(in *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	return fuse.Fail(&in.Context, fuse.Errorf(fuse.Status(syscall.ESTALE), "backend lost node %d", in.NodeId))
+}
+
+func TestFail(t *testing.T) {
+	var buf bytes.Buffer
+	k, err := fakekernel.New(&failFS{fuse.NewDefaultRawFileSystem()}, &fuse.MountOptions{
+		Deterministic: true,
+		Logger:        log.New(&buf, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	_, code := k.GetAttr(fuse.FUSE_ROOT_ID)
+	k.Close()
+
+	if code != fuse.Status(syscall.ESTALE) {
+		t.Errorf("got %v, want ESTALE", code)
+	}
+	want := "GETATTR i1: backend lost node 1"
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Errorf("log %q does not contain %q", got, want)
+	}
+}
+
+// copyFailFS calls Fail with a copy of the Context.
+type copyFailFS struct {
+	fuse.RawFileSystem
+}
+
+func (fs *copyFailFS) GetAttr(in *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	ctx := in.Context
+	return fuse.Fail(&ctx, fuse.Errorf(fuse.EIO, "copied context"))
+}
+
+func TestFailCopiedContext(t *testing.T) {
+	var std bytes.Buffer
+	log.SetOutput(&std)
+	defer log.SetOutput(os.Stderr)
+
+	var buf bytes.Buffer
+	k, err := fakekernel.New(&copyFailFS{fuse.NewDefaultRawFileSystem()}, &fuse.MountOptions{
+		Deterministic: true,
+		Logger:        log.New(&buf, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, code := k.GetAttr(fuse.FUSE_ROOT_ID); code != fuse.EIO {
+			t.Errorf("got %v, want EIO", code)
+		}
+	}
+	k.Close()
+
+	if got := strings.Count(std.String(), "copied context"); got != 3 {
+		t.Errorf("standard log has %d messages, want 3: %q", got, std.String())
+	}
+	if strings.Contains(buf.String(), "copied context") {
+		t.Errorf("server log %q has the message of a copy", buf.String())
+	}
+}
+
+func TestErrorToStatus(t *testing.T) {
+	if got := fuse.ToStatus(fuse.Errorf(fuse.EACCES, "no")); got != fuse.EACCES {
+		t.Errorf("ToStatus: got %v, want EACCES", got)
+	}
+	if got := fuse.Fail(nil, nil); got != fuse.OK {
+		t.Errorf("Fail(nil): got %v", got)
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+// Internals for the tests in package fuse_test.
+
+// InflightWaiters returns the number of readers that wait for the
+// in-flight bytes to drop below MountOptions.MaxInflightBytes.
+func InflightWaiters(ms *Server) int {
+	ms.reqMu.Lock()
+	defer ms.reqMu.Unlock()
+	return ms.inflightWaiters
+}
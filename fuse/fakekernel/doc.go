@@ -0,0 +1,21 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fakekernel emulates the kernel side of the FUSE protocol
+// in memory, so file systems can be unit tested without root,
+// fusermount or an actual mount.
+//
+// A Kernel connects to a fuse.Server over a socket pair. Its methods
+// encode a request, wait for the server's reply and decode it, eg.
+//
+//	k, err := fakekernel.New(nodefs.NewFileSystemConnector(root, nil).RawFS(), nil)
+//	defer k.Close()
+//	entry, code := k.Lookup(fuse.FUSE_ROOT_ID, "file.txt")
+//	open, code := k.Open(entry.NodeId, syscall.O_RDONLY)
+//	data, code := k.Read(entry.NodeId, open.Fh, 0, 4096)
+//
+// Unlike the real kernel, it does not cache anything, and it does not
+// issue requests on its own; in particular, it never sends FORGET
+// unless asked to.
+package fakekernel
@@ -0,0 +1,548 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakekernel
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/protocol"
+)
+
+const (
+	kernelMajor = protocol.KERNEL_VERSION
+	kernelMinor = protocol.MAXIMUM_MINOR_VERSION
+
+	// Large enough for the biggest reply: a read of
+	// MAX_KERNEL_WRITE bytes plus header.
+	bufSize = fuse.MAX_KERNEL_WRITE + 4096
+)
+
+// Notification is an unsolicited message from the server, such as
+// the result of Server.InodeNotify.
+type Notification struct {
+	// Code is one of the fuse.NOTIFY_* constants.
+	Code int32
+	Data []byte
+}
+
+type reply struct {
+	status fuse.Status
+	data   []byte
+}
+
+// Kernel is the kernel side of a FUSE connection. Its methods may be
+// called concurrently.
+type Kernel struct {
+	// Caller is put in the header of each request. It defaults to
+	// the current process.
+	Caller fuse.Context
+
+	fd     int
+	server *fuse.Server
+	init   fuse.InitOut
+
+	mu            sync.Mutex
+	unique        uint64
+	pending       map[uint64]chan reply
+	notifications []Notification
+
+	// Set once the connection is gone.
+	closed bool
+
+	// Set once OPEN (resp. OPENDIR) returned NO_OPEN, if the
+	// server agreed to it in INIT; like the kernel, we then no
+	// longer send OPEN, nor RELEASE for the handle 0 that skipped
+	// opens return.
+	noOpen    bool
+	noOpenDir bool
+
+	closeOnce sync.Once
+	served    chan struct{}
+	reader    chan struct{}
+}
+
+// New starts a server for fs, and connects to it. opts are passed to
+// the server as is.
+func New(fs fuse.RawFileSystem, opts *fuse.MountOptions) (*Kernel, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Kernel{
+		Caller: fuse.Context{
+			Owner: fuse.Owner{
+				Uid: uint32(os.Getuid()),
+				Gid: uint32(os.Getgid()),
+			},
+			Pid: uint32(os.Getpid()),
+		},
+		fd:      fds[0],
+		pending: make(map[uint64]chan reply),
+		served:  make(chan struct{}),
+		reader:  make(chan struct{}),
+	}
+
+	// The server reads INIT synchronously on construction, so
+	// it must be queued up front.
+	in := fuse.InitIn{
+		Major:        kernelMajor,
+		Minor:        kernelMinor,
+		MaxReadAhead: 128 * 1024,
+		Flags: fuse.CAP_ASYNC_READ | fuse.CAP_BIG_WRITES | fuse.CAP_FILE_OPS | fuse.CAP_AUTO_INVAL_DATA |
+			fuse.CAP_NO_OPEN_SUPPORT | fuse.CAP_NO_OPENDIR_SUPPORT,
+	}
+	initReply := make(chan reply, 1)
+	k.unique++
+	k.pending[k.unique] = initReply
+	if err := k.send(protocol.OP_INIT, 0, k.unique, unsafe.Pointer(&in), unsafe.Sizeof(in), nil); err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return nil, err
+	}
+
+	server, err := fuse.NewServerFd(fs, fds[1], opts)
+	if err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return nil, err
+	}
+	k.server = server
+
+	go k.readLoop()
+	go func() {
+		server.Serve()
+		close(k.served)
+	}()
+
+	r := <-initReply
+	if code := decode(r.data, r.status, unsafe.Pointer(&k.init), unsafe.Sizeof(k.init)); !code.Ok() {
+		k.Close()
+		return nil, fmt.Errorf("init: %v", code)
+	}
+	return k, nil
+}
+
+// Server returns the server under test.
+func (k *Kernel) Server() *fuse.Server {
+	return k.server
+}
+
+// InitOut returns the server's reply to INIT.
+func (k *Kernel) InitOut() fuse.InitOut {
+	return k.init
+}
+
+// Close hangs up the connection, which makes the server exit, and
+// waits for it to do so. Outstanding calls return ENODEV.
+func (k *Kernel) Close() error {
+	var err error
+	k.closeOnce.Do(func() {
+		err = syscall.Shutdown(k.fd, syscall.SHUT_RDWR)
+		<-k.reader
+		<-k.served
+		syscall.Close(k.fd)
+	})
+	return err
+}
+
+// Notifications returns the notifications received since the
+// previous call.
+func (k *Kernel) Notifications() []Notification {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	n := k.notifications
+	k.notifications = nil
+	return n
+}
+
+func (k *Kernel) newUnique() uint64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.unique++
+	return k.unique
+}
+
+func decodeReply(msg []byte) (status fuse.Status, data []byte, unique uint64) {
+	hSize := int(unsafe.Sizeof(fuse.OutHeader{}))
+	if len(msg) < hSize {
+		return fuse.EIO, nil, 0
+	}
+	h := (*fuse.OutHeader)(unsafe.Pointer(&msg[0]))
+	if int(h.Length) != len(msg) {
+		return fuse.EIO, nil, h.Unique
+	}
+	return fuse.Status(-h.Status), msg[hSize:], h.Unique
+}
+
+func (k *Kernel) readLoop() {
+	defer close(k.reader)
+	for {
+		buf := make([]byte, bufSize)
+		n, err := syscall.Read(k.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n == 0 {
+			break
+		}
+
+		status, data, unique := decodeReply(buf[:n])
+		k.mu.Lock()
+		if unique == 0 {
+			// Notifications carry the notify code in
+			// the status field.
+			k.notifications = append(k.notifications, Notification{
+				Code: int32(status),
+				Data: data,
+			})
+		} else if ch := k.pending[unique]; ch != nil {
+			delete(k.pending, unique)
+			ch <- reply{status, data}
+		}
+		k.mu.Unlock()
+	}
+
+	k.mu.Lock()
+	for u, ch := range k.pending {
+		delete(k.pending, u)
+		ch <- reply{status: fuse.ENODEV}
+	}
+	k.closed = true
+	k.mu.Unlock()
+}
+
+// send writes a request. in points to a struct starting with a
+// fuse.InHeader, or is nil if the request has only a header. The
+// header is filled in here.
+func (k *Kernel) send(opcode int32, nodeID uint64, unique uint64, in unsafe.Pointer, inSize uintptr, payload []byte) error {
+	var h fuse.InHeader
+	if in == nil {
+		in = unsafe.Pointer(&h)
+		inSize = unsafe.Sizeof(h)
+	}
+	header := (*fuse.InHeader)(in)
+	*header = fuse.InHeader{
+		Length:  uint32(inSize) + uint32(len(payload)),
+		Opcode:  opcode,
+		Unique:  unique,
+		NodeId:  nodeID,
+		Context: k.Caller,
+	}
+
+	msg := make([]byte, 0, int(header.Length))
+	msg = append(msg, (*[1 << 16]byte)(in)[:inSize]...)
+	msg = append(msg, payload...)
+	_, err := syscall.Write(k.fd, msg)
+	return err
+}
+
+// Call issues a request and waits for the reply. in points to a
+// struct starting with a fuse.InHeader (eg. fuse.ReadIn), or is nil
+// for requests that only have a header. payload follows the struct,
+// and holds NUL-terminated names or write data. It is the building
+// block for the typed methods, and can be used for opcodes those do
+// not cover.
+func (k *Kernel) Call(opcode int32, nodeID uint64, in unsafe.Pointer, inSize uintptr, payload []byte) ([]byte, fuse.Status) {
+	ch := make(chan reply, 1)
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return nil, fuse.ENODEV
+	}
+	k.unique++
+	unique := k.unique
+	k.pending[unique] = ch
+	k.mu.Unlock()
+
+	if err := k.send(opcode, nodeID, unique, in, inSize, payload); err != nil {
+		k.mu.Lock()
+		delete(k.pending, unique)
+		k.mu.Unlock()
+		return nil, fuse.ToStatus(err)
+	}
+
+	r := <-ch
+	return r.data, r.status
+}
+
+func names(ns ...string) []byte {
+	var b []byte
+	for _, n := range ns {
+		b = append(b, n...)
+		b = append(b, 0)
+	}
+	return b
+}
+
+// decode copies a reply into the struct pointed to by out.
+func decode(data []byte, code fuse.Status, out unsafe.Pointer, size uintptr) fuse.Status {
+	if !code.Ok() {
+		return code
+	}
+	if uintptr(len(data)) < size {
+		return fuse.EIO
+	}
+	copy((*[1 << 16]byte)(out)[:size], data)
+	return fuse.OK
+}
+
+func (k *Kernel) entryCall(opcode int32, nodeID uint64, in unsafe.Pointer, inSize uintptr, payload []byte) (*fuse.EntryOut, fuse.Status) {
+	data, code := k.Call(opcode, nodeID, in, inSize, payload)
+	out := &fuse.EntryOut{}
+	if code = decode(data, code, unsafe.Pointer(out), unsafe.Sizeof(*out)); !code.Ok() {
+		return nil, code
+	}
+	return out, fuse.OK
+}
+
+// Lookup looks up name in the directory parent. Each successful
+// lookup should be balanced by a Forget.
+func (k *Kernel) Lookup(parent uint64, name string) (*fuse.EntryOut, fuse.Status) {
+	return k.entryCall(protocol.OP_LOOKUP, parent, nil, 0, names(name))
+}
+
+// Forget drops nlookup references to node. The server does not
+// reply to FORGET, so this does not wait for it to be processed.
+func (k *Kernel) Forget(node, nlookup uint64) fuse.Status {
+	in := fuse.ForgetIn{Nlookup: nlookup}
+	return fuse.ToStatus(k.send(protocol.OP_FORGET, node, k.newUnique(), unsafe.Pointer(&in), unsafe.Sizeof(in), nil))
+}
+
+// Interrupt asks the server to interrupt the request unique. The
+// server does not reply to INTERRUPT unless it fails, and such
+// replies are dropped, so this does not wait either.
+func (k *Kernel) Interrupt(unique uint64) fuse.Status {
+	in := fuse.InterruptIn{Unique: unique}
+	return fuse.ToStatus(k.send(protocol.OP_INTERRUPT, 0, k.newUnique(), unsafe.Pointer(&in), unsafe.Sizeof(in), nil))
+}
+
+func (k *Kernel) GetAttr(node uint64) (*fuse.AttrOut, fuse.Status) {
+	in := fuse.GetAttrIn{}
+	data, code := k.Call(protocol.OP_GETATTR, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+	out := &fuse.AttrOut{}
+	if code = decode(data, code, unsafe.Pointer(out), unsafe.Sizeof(*out)); !code.Ok() {
+		return nil, code
+	}
+	return out, fuse.OK
+}
+
+// SetAttr changes the attributes selected by in.Valid.
+func (k *Kernel) SetAttr(node uint64, in *fuse.SetAttrIn) (*fuse.AttrOut, fuse.Status) {
+	data, code := k.Call(protocol.OP_SETATTR, node, unsafe.Pointer(in), unsafe.Sizeof(*in), nil)
+	out := &fuse.AttrOut{}
+	if code = decode(data, code, unsafe.Pointer(out), unsafe.Sizeof(*out)); !code.Ok() {
+		return nil, code
+	}
+	return out, fuse.OK
+}
+
+func (k *Kernel) Readlink(node uint64) (string, fuse.Status) {
+	data, code := k.Call(protocol.OP_READLINK, node, nil, 0, nil)
+	return string(data), code
+}
+
+func (k *Kernel) Symlink(parent uint64, name, target string) (*fuse.EntryOut, fuse.Status) {
+	return k.entryCall(protocol.OP_SYMLINK, parent, nil, 0, names(name, target))
+}
+
+func (k *Kernel) Mkdir(parent uint64, name string, mode uint32) (*fuse.EntryOut, fuse.Status) {
+	in := fuse.MkdirIn{Mode: mode}
+	return k.entryCall(protocol.OP_MKDIR, parent, unsafe.Pointer(&in), unsafe.Sizeof(in), names(name))
+}
+
+func (k *Kernel) Unlink(parent uint64, name string) fuse.Status {
+	_, code := k.Call(protocol.OP_UNLINK, parent, nil, 0, names(name))
+	return code
+}
+
+func (k *Kernel) Rmdir(parent uint64, name string) fuse.Status {
+	_, code := k.Call(protocol.OP_RMDIR, parent, nil, 0, names(name))
+	return code
+}
+
+func (k *Kernel) Rename(parent uint64, name string, newParent uint64, newName string) fuse.Status {
+	in := fuse.RenameIn{Newdir: newParent}
+	_, code := k.Call(protocol.OP_RENAME, parent, unsafe.Pointer(&in), unsafe.Sizeof(in), names(name, newName))
+	return code
+}
+
+// Link creates newName in newParent as a hard link to node.
+func (k *Kernel) Link(node uint64, newParent uint64, newName string) (*fuse.EntryOut, fuse.Status) {
+	in := fuse.LinkIn{Oldnodeid: node}
+	return k.entryCall(protocol.OP_LINK, newParent, unsafe.Pointer(&in), unsafe.Sizeof(in), names(newName))
+}
+
+// skipOpen returns the flag that records whether opcode, OPEN or
+// OPENDIR, is skipped.
+func (k *Kernel) skipOpen(opcode int32) *bool {
+	if opcode == protocol.OP_OPENDIR {
+		return &k.noOpenDir
+	}
+	return &k.noOpen
+}
+
+func (k *Kernel) openCall(opcode int32, node uint64, flags uint32) (*fuse.OpenOut, fuse.Status) {
+	out := &fuse.OpenOut{}
+	k.mu.Lock()
+	skip := *k.skipOpen(opcode)
+	k.mu.Unlock()
+	if skip {
+		return out, fuse.OK
+	}
+
+	in := fuse.OpenIn{Flags: flags}
+	data, code := k.Call(opcode, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+	noOpenCap := uint32(fuse.CAP_NO_OPEN_SUPPORT)
+	if opcode == protocol.OP_OPENDIR {
+		noOpenCap = fuse.CAP_NO_OPENDIR_SUPPORT
+	}
+	if code == fuse.NO_OPEN && k.InitOut().Flags&noOpenCap != 0 {
+		k.mu.Lock()
+		*k.skipOpen(opcode) = true
+		k.mu.Unlock()
+		return out, fuse.OK
+	}
+	if code = decode(data, code, unsafe.Pointer(out), unsafe.Sizeof(*out)); !code.Ok() {
+		return nil, code
+	}
+	return out, fuse.OK
+}
+
+func (k *Kernel) Open(node uint64, flags uint32) (*fuse.OpenOut, fuse.Status) {
+	return k.openCall(protocol.OP_OPEN, node, flags)
+}
+
+func (k *Kernel) Create(parent uint64, name string, flags uint32, mode uint32) (*fuse.CreateOut, fuse.Status) {
+	in := fuse.CreateIn{Flags: flags, Mode: mode}
+	data, code := k.Call(protocol.OP_CREATE, parent, unsafe.Pointer(&in), unsafe.Sizeof(in), names(name))
+	out := &fuse.CreateOut{}
+	if code = decode(data, code, unsafe.Pointer(out), unsafe.Sizeof(*out)); !code.Ok() {
+		return nil, code
+	}
+	return out, fuse.OK
+}
+
+func (k *Kernel) Read(node uint64, fh uint64, off uint64, size uint32) ([]byte, fuse.Status) {
+	in := fuse.ReadIn{Fh: fh, Offset: off, Size: size}
+	return k.Call(protocol.OP_READ, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+}
+
+func (k *Kernel) Write(node uint64, fh uint64, off uint64, data []byte) (uint32, fuse.Status) {
+	in := fuse.WriteIn{Fh: fh, Offset: off, Size: uint32(len(data))}
+	reply, code := k.Call(protocol.OP_WRITE, node, unsafe.Pointer(&in), unsafe.Sizeof(in), data)
+	out := fuse.WriteOut{}
+	if code = decode(reply, code, unsafe.Pointer(&out), unsafe.Sizeof(out)); !code.Ok() {
+		return 0, code
+	}
+	return out.Size, fuse.OK
+}
+
+func (k *Kernel) Flush(node uint64, fh uint64) fuse.Status {
+	return k.FlushOwner(node, fh, 0)
+}
+
+// FlushOwner sends FLUSH as for a close() by the process with the
+// given lock owner.
+func (k *Kernel) FlushOwner(node uint64, fh uint64, owner uint64) fuse.Status {
+	in := fuse.FlushIn{Fh: fh, LockOwner: owner}
+	_, code := k.Call(protocol.OP_FLUSH, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+	return code
+}
+
+// GetLk sends GETLK, and returns the conflicting lock.
+func (k *Kernel) GetLk(node uint64, fh uint64, owner uint64, lk fuse.FileLock) (fuse.FileLock, fuse.Status) {
+	in := fuse.LkIn{Fh: fh, Owner: owner, Lk: lk}
+	reply, code := k.Call(protocol.OP_GETLK, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+	out := fuse.LkOut{}
+	code = decode(reply, code, unsafe.Pointer(&out), unsafe.Sizeof(out))
+	return out.Lk, code
+}
+
+// SetLk sends SETLK, or SETLKW if wait is set.
+func (k *Kernel) SetLk(node uint64, fh uint64, owner uint64, lk fuse.FileLock, wait bool) fuse.Status {
+	op := protocol.OP_SETLK
+	if wait {
+		op = protocol.OP_SETLKW
+	}
+	in := fuse.LkIn{Fh: fh, Owner: owner, Lk: lk}
+	_, code := k.Call(op, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+	return code
+}
+
+func (k *Kernel) Fsync(node uint64, fh uint64, flags uint32) fuse.Status {
+	in := fuse.FsyncIn{Fh: fh, FsyncFlags: flags}
+	_, code := k.Call(protocol.OP_FSYNC, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+	return code
+}
+
+func (k *Kernel) Release(node uint64, fh uint64) fuse.Status {
+	return k.releaseCall(protocol.OP_RELEASE, protocol.OP_OPEN, node, fuse.ReleaseIn{Fh: fh})
+}
+
+// ReleaseFlags sends RELEASE with release flags (fuse.RELEASE_FLUSH,
+// fuse.RELEASE_FLOCK_UNLOCK) for the given lock owner.
+func (k *Kernel) ReleaseFlags(node uint64, fh uint64, owner uint64, flags uint32) fuse.Status {
+	in := fuse.ReleaseIn{Fh: fh, ReleaseFlags: flags, LockOwner: owner}
+	return k.releaseCall(protocol.OP_RELEASE, protocol.OP_OPEN, node, in)
+}
+
+func (k *Kernel) releaseCall(opcode, openOpcode int32, node uint64, in fuse.ReleaseIn) fuse.Status {
+	k.mu.Lock()
+	skip := *k.skipOpen(openOpcode) && in.Fh == 0
+	k.mu.Unlock()
+	if skip {
+		return fuse.OK
+	}
+	_, code := k.Call(opcode, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+	return code
+}
+
+func (k *Kernel) OpenDir(node uint64) (*fuse.OpenOut, fuse.Status) {
+	return k.openCall(protocol.OP_OPENDIR, node, 0)
+}
+
+// ReadDir reads directory entries starting at offset off, as
+// returned in Dirent.Off of the previous entry.
+func (k *Kernel) ReadDir(node uint64, fh uint64, off uint64, size uint32) ([]protocol.Dirent, fuse.Status) {
+	in := fuse.ReadIn{Fh: fh, Offset: off, Size: size}
+	data, code := k.Call(protocol.OP_READDIR, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+	if !code.Ok() {
+		return nil, code
+	}
+	result, err := protocol.UnmarshalDirents(data)
+	if err != nil {
+		return nil, fuse.EIO
+	}
+	return result, fuse.OK
+}
+
+func (k *Kernel) ReleaseDir(node uint64, fh uint64) fuse.Status {
+	return k.releaseCall(protocol.OP_RELEASEDIR, protocol.OP_OPENDIR, node, fuse.ReleaseIn{Fh: fh})
+}
+
+func (k *Kernel) Bmap(node uint64, block uint64, blocksize uint32) (uint64, fuse.Status) {
+	in := fuse.BmapIn{Block: block, Blocksize: blocksize}
+	data, code := k.Call(protocol.OP_BMAP, node, unsafe.Pointer(&in), unsafe.Sizeof(in), nil)
+	out := fuse.BmapOut{}
+	if code = decode(data, code, unsafe.Pointer(&out), unsafe.Sizeof(out)); !code.Ok() {
+		return 0, code
+	}
+	return out.Block, fuse.OK
+}
+
+func (k *Kernel) StatFs(node uint64) (*fuse.StatfsOut, fuse.Status) {
+	data, code := k.Call(protocol.OP_STATFS, node, nil, 0, nil)
+	out := &fuse.StatfsOut{}
+	if code = decode(data, code, unsafe.Pointer(out), unsafe.Sizeof(*out)); !code.Ok() {
+		return nil, code
+	}
+	return out, fuse.OK
+}
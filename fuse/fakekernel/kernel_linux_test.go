@@ -0,0 +1,221 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakekernel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func setupLoopback(t *testing.T) (dir string, k *Kernel, clean func()) {
+	dir = testutil.TempDir()
+	nfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(dir), nil)
+	conn := nodefs.NewFileSystemConnector(nfs.Root(), nil)
+	k, err := New(conn.RawFS(), &fuse.MountOptions{Debug: testutil.VerboseTest()})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("New: %v", err)
+	}
+	return dir, k, func() {
+		if err := k.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	dir, k, clean := setupLoopback(t)
+	defer clean()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if entry.Size != 5 || entry.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		t.Errorf("Lookup: got %v", entry)
+	}
+	if _, code := k.Lookup(fuse.FUSE_ROOT_ID, "nonexistent"); code != fuse.ENOENT {
+		t.Errorf("Lookup nonexistent: got %v, want ENOENT", code)
+	}
+
+	open, code := k.Open(entry.NodeId, syscall.O_RDWR)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	if n, code := k.Write(entry.NodeId, open.Fh, 5, []byte(" world")); !code.Ok() || n != 6 {
+		t.Fatalf("Write: %d %v", n, code)
+	}
+	data, code := k.Read(entry.NodeId, open.Fh, 0, 100)
+	if !code.Ok() || string(data) != "hello world" {
+		t.Errorf("Read: got %q %v", data, code)
+	}
+	if code := k.Release(entry.NodeId, open.Fh); !code.Ok() {
+		t.Errorf("Release: %v", code)
+	}
+
+	attr, code := k.GetAttr(entry.NodeId)
+	if !code.Ok() || attr.Size != 11 {
+		t.Errorf("GetAttr: %v %v", attr, code)
+	}
+	if code := k.Forget(entry.NodeId, 1); !code.Ok() {
+		t.Errorf("Forget: %v", code)
+	}
+}
+
+func TestDirectoryOps(t *testing.T) {
+	dir, k, clean := setupLoopback(t)
+	defer clean()
+
+	sub, code := k.Mkdir(fuse.FUSE_ROOT_ID, "sub", 0755)
+	if !code.Ok() {
+		t.Fatalf("Mkdir: %v", code)
+	}
+	created, code := k.Create(sub.NodeId, "a", syscall.O_WRONLY, 0644)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	k.Release(created.NodeId, created.Fh)
+	if _, code := k.Symlink(sub.NodeId, "link", "a"); !code.Ok() {
+		t.Fatalf("Symlink: %v", code)
+	}
+	if code := k.Rename(sub.NodeId, "a", fuse.FUSE_ROOT_ID, "b"); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "b")); err != nil {
+		t.Errorf("Lstat after rename: %v", err)
+	}
+
+	open, code := k.OpenDir(sub.NodeId)
+	if !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+	entries, code := k.ReadDir(sub.NodeId, open.Fh, 0, 4096)
+	if !code.Ok() {
+		t.Fatalf("ReadDir: %v", code)
+	}
+	var found bool
+	for _, e := range entries {
+		if e.Name == "link" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("ReadDir: link missing from %v", entries)
+	}
+	k.ReleaseDir(sub.NodeId, open.Fh)
+
+	link, code := k.Lookup(sub.NodeId, "link")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if target, code := k.Readlink(link.NodeId); !code.Ok() || target != "a" {
+		t.Errorf("Readlink: %q %v", target, code)
+	}
+	if code := k.Unlink(sub.NodeId, "link"); !code.Ok() {
+		t.Errorf("Unlink: %v", code)
+	}
+	if code := k.Rmdir(fuse.FUSE_ROOT_ID, "sub"); !code.Ok() {
+		t.Errorf("Rmdir: %v", code)
+	}
+}
+
+func TestNotifyAndClose(t *testing.T) {
+	_, k, clean := setupLoopback(t)
+	defer clean()
+
+	if code := k.Server().InodeNotify(fuse.FUSE_ROOT_ID, 0, 0); !code.Ok() {
+		t.Fatalf("InodeNotify: %v", code)
+	}
+	// A round trip ensures the notification was read.
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+	if n := k.Notifications(); len(n) != 1 || n[0].Code != fuse.NOTIFY_INVAL_INODE {
+		t.Errorf("got notifications %v", n)
+	}
+
+	k.Close()
+	if _, code := k.GetAttr(fuse.FUSE_ROOT_ID); code != fuse.ENODEV {
+		t.Errorf("GetAttr after Close: got %v, want ENODEV", code)
+	}
+}
+
+type latencies map[string]time.Duration
+
+func (l latencies) Add(name string, dt time.Duration) {
+	l[name] += dt
+}
+
+// orderFS records the order of GETATTR and FORGET, and takes 5ms
+// for each GETATTR.
+type orderFS struct {
+	fuse.RawFileSystem
+	clock *Clock
+	order []uint64
+}
+
+func (fs *orderFS) Forget(nodeID, nlookup uint64) {
+	fs.order = append(fs.order, nodeID)
+}
+
+func (fs *orderFS) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	fs.order = append(fs.order, input.NodeId)
+	fs.clock.Advance(5 * time.Millisecond)
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func TestDeterministic(t *testing.T) {
+	clock := NewClock(time.Unix(1500000000, 0))
+	fs := &orderFS{RawFileSystem: fuse.NewDefaultRawFileSystem(), clock: clock}
+	k, err := New(fs, &fuse.MountOptions{
+		Deterministic: true,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	lat := latencies{}
+	k.Server().RecordLatencies(lat)
+
+	// FORGET is not waited for, so without deterministic mode
+	// these could be processed in any order.
+	var want []uint64
+	for i := uint64(2); i < 50; i++ {
+		k.Forget(i, 1)
+		want = append(want, i)
+	}
+	if _, code := k.GetAttr(fuse.FUSE_ROOT_ID); !code.Ok() {
+		t.Fatalf("GetAttr: %v", code)
+	}
+	want = append(want, fuse.FUSE_ROOT_ID)
+
+	// Wait for the server to exit, so its effects are visible.
+	k.Close()
+	if fmt.Sprint(fs.order) != fmt.Sprint(want) {
+		t.Errorf("got order %v, want %v", fs.order, want)
+	}
+	if got := lat["GETATTR"]; got != 5*time.Millisecond {
+		t.Errorf("GETATTR latency: got %v, want 5ms", got)
+	}
+	if got := clock.Now(); !got.Equal(time.Unix(1500000000, 0).Add(5 * time.Millisecond)) {
+		t.Errorf("clock: got %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// forgetOrder keeps FORGET from running alongside requests that
+// return a node with a lookup count, in either direction, in the
+// order they were read. See MountOptions.OrderForgets.
+//
+// The node an entry reply returns is not known until the file system
+// is done with the request, so requests are ordered by class rather
+// than by node: runs of consecutive entry requests, and of forgets,
+// form batches that run in parallel, each after the one before it is
+// done. This blocks head of line: a slow request holds up all
+// requests of the other class read after it, and all later batches.
+type forgetOrder struct {
+	mu   sync.Mutex
+	tail *forgetBatch
+}
+
+// forgetBatch is a run of requests of the same class.
+type forgetBatch struct {
+	forget bool
+	prev   *forgetBatch
+
+	// Requests enqueued and not released yet.
+	n int
+	// sealed is set once a batch of the other class follows.
+	sealed bool
+	// Closed when the batch is sealed and all its requests are
+	// done.
+	done chan struct{}
+}
+
+func newForgetOrder() *forgetOrder {
+	return &forgetOrder{}
+}
+
+// forgetClass says whether the request with input in is ordered,
+// and whether it is a forget.
+func forgetClass(in []byte) (ordered, forget bool) {
+	if len(in) < int(unsafe.Sizeof(InHeader{})) {
+		return false, false
+	}
+	switch (*InHeader)(unsafe.Pointer(&in[0])).Opcode {
+	case _OP_FORGET, _OP_BATCH_FORGET:
+		return true, true
+	case _OP_LOOKUP, _OP_CREATE, _OP_MKDIR, _OP_MKNOD, _OP_SYMLINK,
+		_OP_LINK, _OP_READDIRPLUS:
+		return true, false
+	}
+	return false, false
+}
+
+// enqueue puts the request with input in into the current batch, or
+// starts a new one. Calls must be made in the order requests were
+// read.
+func (o *forgetOrder) enqueue(in []byte) *forgetBatch {
+	ordered, forget := forgetClass(in)
+	if !ordered {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b := o.tail
+	if b == nil || b.forget != forget {
+		if b != nil {
+			b.sealed = true
+			if b.n == 0 {
+				close(b.done)
+			}
+		}
+		b = &forgetBatch{forget: forget, prev: b, done: make(chan struct{})}
+		o.tail = b
+	}
+	b.n++
+	return b
+}
+
+// acquire waits until the batch before b is done.
+func (o *forgetOrder) acquire(b *forgetBatch) {
+	o.mu.Lock()
+	prev := b.prev
+	o.mu.Unlock()
+	if prev != nil {
+		<-prev.done
+	}
+}
+
+// release marks a request of b as done.
+func (o *forgetOrder) release(b *forgetBatch) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// The batch before is done; drop it, so done batches do not
+	// pile up.
+	b.prev = nil
+	b.n--
+	if b.n > 0 {
+		return
+	}
+	if b.sealed {
+		close(b.done)
+	} else if o.tail == b {
+		o.tail = nil
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// slowLookupFS holds LOOKUP until unblock is closed, and reports
+// FORGET on forgotten.
+type slowLookupFS struct {
+	fuse.RawFileSystem
+	entered   chan struct{}
+	unblock   chan struct{}
+	forgotten chan struct{}
+}
+
+func (fs *slowLookupFS) Lookup(header *fuse.InHeader, name string, out *fuse.EntryOut) fuse.Status {
+	close(fs.entered)
+	<-fs.unblock
+	out.NodeId = 2
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func (fs *slowLookupFS) Forget(nodeid, nlookup uint64) {
+	close(fs.forgotten)
+}
+
+func TestOrderForgets(t *testing.T) {
+	fs := &slowLookupFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		entered:       make(chan struct{}),
+		unblock:       make(chan struct{}),
+		forgotten:     make(chan struct{}),
+	}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{OrderForgets: true, MaxReaders: 1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+	var once sync.Once
+	release := func() { once.Do(func() { close(fs.unblock) }) }
+	// On failure, let LOOKUP finish so Close does not hang.
+	defer release()
+
+	looked := make(chan fuse.Status, 1)
+	go func() {
+		_, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+		looked <- code
+	}()
+	<-fs.entered
+	if code := k.Forget(2, 1); !code.Ok() {
+		t.Fatalf("Forget: %v", code)
+	}
+
+	select {
+	case <-fs.forgotten:
+		t.Fatalf("FORGET ran alongside LOOKUP")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	if code := <-looked; !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	select {
+	case <-fs.forgotten:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("FORGET did not run")
+	}
+}
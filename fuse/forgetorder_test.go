@@ -0,0 +1,64 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"testing"
+	"time"
+)
+
+// acquired reports whether acquire(b) returns within a moment.
+func acquired(o *forgetOrder, b *forgetBatch) bool {
+	done := make(chan struct{})
+	go func() {
+		o.acquire(b)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(10 * time.Millisecond):
+		return false
+	}
+}
+
+func TestForgetOrder(t *testing.T) {
+	o := newForgetOrder()
+	l1 := o.enqueue(serialInput(_OP_LOOKUP, 1))
+	l2 := o.enqueue(serialInput(_OP_MKDIR, 1))
+	f1 := o.enqueue(serialInput(_OP_FORGET, 5))
+	f2 := o.enqueue(serialInput(_OP_BATCH_FORGET, 0))
+	l3 := o.enqueue(serialInput(_OP_LOOKUP, 1))
+	if o.enqueue(serialInput(_OP_GETATTR, 5)) != nil {
+		t.Errorf("GETATTR was ordered")
+	}
+
+	if !acquired(o, l1) || !acquired(o, l2) {
+		t.Fatalf("entry requests wait for each other")
+	}
+	if acquired(o, f1) {
+		t.Fatalf("FORGET runs alongside earlier LOOKUP")
+	}
+	o.release(l1)
+	if acquired(o, f2) {
+		t.Fatalf("FORGET runs alongside earlier MKDIR")
+	}
+	o.release(l2)
+	if !acquired(o, f1) || !acquired(o, f2) {
+		t.Fatalf("forgets wait after entry requests are done")
+	}
+	if acquired(o, l3) {
+		t.Fatalf("LOOKUP runs alongside earlier FORGET")
+	}
+	o.release(f1)
+	o.release(f2)
+	if !acquired(o, l3) {
+		t.Fatalf("LOOKUP waits after forgets are done")
+	}
+	o.release(l3)
+	if o.tail != nil {
+		t.Errorf("batches not cleaned up")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// gate is a test fixture for file system methods: it counts the
+// calls running at once, and the maximum, and if it was created
+// blocking, holds them until opened.
+type gate struct {
+	block chan struct{}
+
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func newGate(blocking bool) *gate {
+	g := &gate{}
+	if blocking {
+		g.block = make(chan struct{})
+	}
+	return g
+}
+
+// enter is called at the start of a method. It blocks until the
+// gate is opened.
+func (g *gate) enter() {
+	g.mu.Lock()
+	g.running++
+	if g.running > g.max {
+		g.max = g.running
+	}
+	g.mu.Unlock()
+
+	if g.block != nil {
+		<-g.block
+	}
+}
+
+// leave is called at the end of a method.
+func (g *gate) leave() {
+	g.mu.Lock()
+	g.running--
+	g.mu.Unlock()
+}
+
+// open lets blocked and future calls through.
+func (g *gate) open() {
+	close(g.block)
+}
+
+// Running returns the number of calls between enter and leave.
+func (g *gate) Running() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.running
+}
+
+// Max returns the most calls that were running at once.
+func (g *gate) Max() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.max
+}
+
+// waitRunning waits until n calls are running, and fails the test
+// if that does not happen in time.
+func (g *gate) waitRunning(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); g.Running() != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d calls running, want %d", g.Running(), n)
+		}
+	}
+}
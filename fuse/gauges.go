@@ -0,0 +1,60 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+// Gauges shows how busy a server is at one point in time. A server
+// that is saturated has Handlers near the limits set by QoS or the
+// backend, and requests piling up in Queued.
+type Gauges struct {
+	// Requests is the number of requests read from the kernel
+	// since the server started.
+	Requests uint64
+
+	// InFlight is the number of requests read from the kernel
+	// and not answered yet, including those waiting for their
+	// turn under SerializeNodes or QoS.
+	InFlight int
+
+	// Handlers is the number of goroutines running file system
+	// methods.
+	Handlers int
+
+	// Readers is the number of goroutines reading from the
+	// kernel, which are idle if there is nothing to read.
+	Readers int
+
+	// Queued is the number of requests the kernel has for the
+	// server that it has not read yet, or -1 if the kernel does
+	// not tell. On Linux, it comes from the connection's
+	// "waiting" file in /sys/fs/fuse/connections, which is only
+	// readable by root, and only once WaitMount has returned.
+	Queued int
+}
+
+// Gauges returns the current load of the server. The fields are read
+// one by one, so they need not add up exactly while requests come
+// in.
+func (ms *Server) Gauges() Gauges {
+	ms.reqMu.Lock()
+	readers := ms.reqReaders
+	ms.reqMu.Unlock()
+
+	g := Gauges{
+		Requests: ms.requestsRead.Load(),
+		InFlight: int(ms.requestsLive.Load()),
+		Handlers: int(ms.handlers.Load()),
+		Readers:  readers,
+		Queued:   -1,
+	}
+	if n, ok := ms.kernelWaiting(); ok {
+		// The kernel counts requests being served as waiting
+		// too.
+		g.Queued = n - g.InFlight
+		if g.Queued < 0 {
+			g.Queued = 0
+		}
+	}
+	return g
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+type blockingFS struct {
+	fuse.RawFileSystem
+	entered, release chan struct{}
+}
+
+func (fs *blockingFS) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	fs.entered <- struct{}{}
+	<-fs.release
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func TestGauges(t *testing.T) {
+	fs := &blockingFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		entered:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+	server := k.Server()
+	before := server.Gauges()
+
+	done := make(chan struct{})
+	go func() {
+		k.GetAttr(fuse.FUSE_ROOT_ID)
+		close(done)
+	}()
+	<-fs.entered
+
+	g := server.Gauges()
+	if g.InFlight != 1 || g.Handlers != 1 {
+		t.Errorf("while serving: got %+v, want 1 in flight and 1 handler", g)
+	}
+	if g.Requests != before.Requests+1 {
+		t.Errorf("Requests: got %d, want %d", g.Requests, before.Requests+1)
+	}
+	if g.Queued != -1 {
+		t.Errorf("Queued: got %d, want -1 without a mount", g.Queued)
+	}
+	close(fs.release)
+	<-done
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HandleTable hands out file handles, ie. the Fh field of OpenOut
+// and CreateOut, for Go objects. A handle holds a slot number in its
+// lower 32 bits and the generation of the slot in its upper 32 bits.
+// Slots are recycled, but since the generation goes up on each reuse,
+// a stale handle does not resolve to the new object.
+//
+// Handle 0 is never handed out, so it can mean "no handle".
+//
+// This structure is thread-safe.
+type HandleTable struct {
+	mu    sync.Mutex
+	slots []handleSlot
+	free  []uint32
+	used  int
+}
+
+type handleSlot struct {
+	obj        interface{}
+	generation uint32
+}
+
+// NewHandleTable returns an empty HandleTable.
+func NewHandleTable() *HandleTable {
+	return &HandleTable{
+		// Slot 0 stays empty.
+		slots: make([]handleSlot, 1),
+	}
+}
+
+// Add stores obj, which must not be nil, and returns its handle.
+func (t *HandleTable) Add(obj interface{}) uint64 {
+	if obj == nil {
+		panic("HandleTable.Add: nil object")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var idx uint32
+	if n := len(t.free); n > 0 {
+		idx = t.free[n-1]
+		t.free = t.free[:n-1]
+	} else {
+		idx = uint32(len(t.slots))
+		t.slots = append(t.slots, handleSlot{})
+	}
+	s := &t.slots[idx]
+	s.generation++
+	s.obj = obj
+	t.used++
+	return uint64(s.generation)<<32 | uint64(idx)
+}
+
+// slot returns the slot for fh, or nil if fh is not current.
+func (t *HandleTable) slot(fh uint64) *handleSlot {
+	idx := uint32(fh)
+	if idx == 0 || int(idx) >= len(t.slots) {
+		return nil
+	}
+	s := &t.slots[idx]
+	if s.obj == nil || s.generation != uint32(fh>>32) {
+		return nil
+	}
+	return s
+}
+
+// Get returns the object for fh.
+func (t *HandleTable) Get(fh uint64) (obj interface{}, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s := t.slot(fh); s != nil {
+		return s.obj, true
+	}
+	return nil, false
+}
+
+// Remove drops fh from the table, and returns the object it held.
+func (t *HandleTable) Remove(fh uint64) (obj interface{}, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.slot(fh)
+	if s == nil {
+		return nil, false
+	}
+	obj = s.obj
+	s.obj = nil
+	t.free = append(t.free, uint32(fh))
+	t.used--
+	return obj, true
+}
+
+// Len returns the number of handles in use.
+func (t *HandleTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.used
+}
+
+// Handles returns the handles in use, with their objects.
+func (t *HandleTable) Handles() map[uint64]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := make(map[uint64]interface{}, t.used)
+	for idx, s := range t.slots {
+		if s.obj != nil {
+			result[uint64(s.generation)<<32|uint64(idx)] = s.obj
+		}
+	}
+	return result
+}
+
+// Check reports the handles that are still in use, eg. after
+// unmounting, when the kernel should have released all of them.
+func (t *HandleTable) Check() error {
+	handles := t.Handles()
+	if len(handles) == 0 {
+		return nil
+	}
+	var fhs []uint64
+	for fh := range handles {
+		fhs = append(fhs, fh)
+	}
+	sort.Slice(fhs, func(i, j int) bool { return uint32(fhs[i]) < uint32(fhs[j]) })
+
+	var leaks []string
+	for _, fh := range fhs {
+		leaks = append(leaks, fmt.Sprintf("fh 0x%x: %v", fh, handles[fh]))
+	}
+	return fmt.Errorf("%d handles not released: %s", len(leaks), strings.Join(leaks, "; "))
+}
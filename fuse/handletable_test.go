@@ -0,0 +1,80 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHandleTable(t *testing.T) {
+	tab := NewHandleTable()
+	a := tab.Add("a")
+	b := tab.Add("b")
+	if a == 0 || b == 0 || a == b {
+		t.Fatalf("got handles %x, %x", a, b)
+	}
+	if obj, ok := tab.Get(a); !ok || obj != "a" {
+		t.Errorf("Get(a): got %v, %v", obj, ok)
+	}
+	if _, ok := tab.Get(0); ok {
+		t.Errorf("Get(0) succeeded")
+	}
+
+	if obj, ok := tab.Remove(a); !ok || obj != "a" {
+		t.Errorf("Remove(a): got %v, %v", obj, ok)
+	}
+	if _, ok := tab.Remove(a); ok {
+		t.Errorf("second Remove(a) succeeded")
+	}
+
+	// The slot of a is reused, but a stays dead.
+	c := tab.Add("c")
+	if uint32(c) != uint32(a) {
+		t.Errorf("slot not reused: %x, %x", a, c)
+	}
+	if _, ok := tab.Get(a); ok {
+		t.Errorf("stale handle %x resolves", a)
+	}
+	if obj, ok := tab.Get(c); !ok || obj != "c" {
+		t.Errorf("Get(c): got %v, %v", obj, ok)
+	}
+
+	if n := tab.Len(); n != 2 {
+		t.Errorf("Len: got %d, want 2", n)
+	}
+	err := tab.Check()
+	if err == nil || !strings.Contains(err.Error(), ": b") || !strings.Contains(err.Error(), ": c") {
+		t.Errorf("Check: got %v, want b and c reported", err)
+	}
+	tab.Remove(b)
+	tab.Remove(c)
+	if err := tab.Check(); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+}
+
+func TestHandleTableConcurrent(t *testing.T) {
+	tab := NewHandleTable()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				fh := tab.Add(i)
+				if obj, ok := tab.Remove(fh); !ok || obj != i {
+					t.Errorf("Remove(%x): got %v, %v, want %d", fh, obj, ok, i)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := tab.Len(); n != 0 {
+		t.Errorf("Len: got %d, want 0", n)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"time"
+)
+
+// HealthCheck configures a probe of the file system that NewServer
+// runs before mounting, so a daemon whose backend is unreachable
+// fails with an error instead of exposing a mount that hangs on
+// first access. The probe runs before the file system's Init.
+type HealthCheck struct {
+	// Probe checks the file system. If nil, DefaultProbe is used.
+	Probe func(fs RawFileSystem) error
+
+	// Attempts is the number of probes before giving up. 0 means
+	// 1.
+	Attempts int
+
+	// Backoff is the wait after the first failed probe. It
+	// doubles after each further failure.
+	Backoff time.Duration
+
+	// Timeout bounds each probe. A probe that takes longer counts
+	// as failed; it is left running. 0 means no limit.
+	Timeout time.Duration
+}
+
+// DefaultProbe asks the root node for file system statistics and its
+// attributes. File systems that do not implement StatFs pass the
+// first part.
+func DefaultProbe(fs RawFileSystem) error {
+	header := InHeader{NodeId: FUSE_ROOT_ID}
+	var st StatfsOut
+	if code := fs.StatFs(&header, &st); !code.Ok() && code != ENOSYS {
+		return fmt.Errorf("statfs: %v", code)
+	}
+	var out AttrOut
+	if code := fs.GetAttr(&GetAttrIn{InHeader: header}, &out); !code.Ok() {
+		return fmt.Errorf("getattr: %v", code)
+	}
+	return nil
+}
+
+// run probes fs until it passes or the attempts are used up.
+func (h *HealthCheck) run(fs RawFileSystem) error {
+	probe := h.Probe
+	if probe == nil {
+		probe = DefaultProbe
+	}
+	attempts := h.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	wait := h.Backoff
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		if err = h.probeOnce(probe, fs); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("health check failed after %d attempts: %v", attempts, err)
+}
+
+func (h *HealthCheck) probeOnce(probe func(RawFileSystem) error, fs RawFileSystem) error {
+	if h.Timeout <= 0 {
+		return probe(fs)
+	}
+	done := make(chan error, 1)
+	go func() { done <- probe(fs) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(h.Timeout):
+		return fmt.Errorf("timed out after %v", h.Timeout)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyFS fails GetAttr until it has been called failures times.
+type flakyFS struct {
+	RawFileSystem
+	calls    int
+	failures int
+}
+
+func (fs *flakyFS) GetAttr(input *GetAttrIn, out *AttrOut) Status {
+	fs.calls++
+	if fs.calls <= fs.failures {
+		return EIO
+	}
+	out.Mode = S_IFDIR | 0755
+	return OK
+}
+
+func TestHealthCheckRetries(t *testing.T) {
+	fs := &flakyFS{RawFileSystem: NewDefaultRawFileSystem(), failures: 2}
+	h := &HealthCheck{Attempts: 3, Backoff: time.Millisecond}
+	if err := h.run(fs); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if fs.calls != 3 {
+		t.Errorf("got %d probes, want 3", fs.calls)
+	}
+
+	fs = &flakyFS{RawFileSystem: NewDefaultRawFileSystem(), failures: 5}
+	err := h.run(fs)
+	if err == nil || !strings.Contains(err.Error(), "getattr") {
+		t.Errorf("run: got %v, want getattr error", err)
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	h := &HealthCheck{
+		Probe:   func(RawFileSystem) error { <-block; return nil },
+		Timeout: 10 * time.Millisecond,
+	}
+	if err := h.run(NewDefaultRawFileSystem()); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("run: got %v, want timeout", err)
+	}
+}
+
+func TestNewServerHealthCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestNewServerHealthCheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dir)
+
+	// The default raw file system has no root, so the probe
+	// fails, and nothing is mounted.
+	_, err = NewServer(NewDefaultRawFileSystem(), dir, &MountOptions{HealthCheck: &HealthCheck{}})
+	if err == nil || !strings.Contains(err.Error(), "health check") {
+		t.Errorf("NewServer: got %v, want health check error", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// mountedFS is a file system that requests are dispatched to, with
+// the number of requests it is still handling.
+type mountedFS struct {
+	fs RawFileSystem
+
+	// active counts the requests being handled by fs.
+	active atomic.Int64
+
+	// retired is set once requests go to another file system;
+	// drained is closed once it is set and active is 0.
+	retired   atomic.Bool
+	drainOnce sync.Once
+	drained   chan struct{}
+}
+
+func newMountedFS(fs RawFileSystem) *mountedFS {
+	return &mountedFS{fs: fs, drained: make(chan struct{})}
+}
+
+// checkDrained closes drained if m is done.
+func (m *mountedFS) checkDrained() {
+	if m.retired.Load() && m.active.Load() == 0 {
+		m.drainOnce.Do(func() { close(m.drained) })
+	}
+}
+
+// acquireFS returns the current file system, and counts a request
+// against it until releaseFS.
+func (ms *Server) acquireFS() *mountedFS {
+	for {
+		m := ms.mounted.Load()
+		m.active.Add(1)
+		if ms.mounted.Load() == m {
+			return m
+		}
+		// Replaced in between; ReplaceFileSystem may not have
+		// seen the count.
+		ms.releaseFS(m)
+	}
+}
+
+func (ms *Server) releaseFS(m *mountedFS) {
+	if m.active.Add(-1) == 0 {
+		m.checkDrained()
+	}
+}
+
+// initFileSystem calls Init on the current file system, once the
+// kernel is connected.
+func (ms *Server) initFileSystem() {
+	ms.swapMu.Lock()
+	defer ms.swapMu.Unlock()
+	ms.fsInitialized = true
+	ms.currentFS().Init(ms)
+}
+
+func (ms *Server) currentFS() RawFileSystem {
+	return ms.mounted.Load().fs
+}
+
+// FileSystem returns the file system that requests are dispatched
+// to.
+func (ms *Server) FileSystem() RawFileSystem {
+	fs := ms.currentFS()
+	if l, ok := fs.(*lockingRawFileSystem); ok && ms.opts.SingleThreaded {
+		return l.RawFS
+	}
+	return fs
+}
+
+// ReplaceFileSystem switches the mount over to fs, eg. to reload
+// configuration or upgrade file system logic without unmounting.
+// New requests go to fs, which is first initialized with Init if the
+// server is already serving. Requests that were already dispatched
+// finish on the old file system; ReplaceFileSystem waits for them,
+// and then returns the old file system, so the caller can clean it
+// up. With MountOptions.SingleThreaded, the old and the new file
+// system share one lock, so they never run at the same time.
+//
+// The kernel keeps the node IDs and file handles it got from the old
+// file system, so fs must accept them, eg. by sharing the node
+// table. It must not be called while handling a request of this
+// server, as it would wait for itself.
+func (ms *Server) ReplaceFileSystem(fs RawFileSystem) RawFileSystem {
+	ms.swapMu.Lock()
+	defer ms.swapMu.Unlock()
+
+	if ms.opts.SingleThreaded {
+		old := ms.currentFS().(*lockingRawFileSystem)
+		fs = &lockingRawFileSystem{RawFS: fs, lock: old.lock}
+	}
+	if ms.fsInitialized {
+		fs.Init(ms)
+	}
+
+	old := ms.mounted.Swap(newMountedFS(fs))
+	old.retired.Store(true)
+	old.checkDrained()
+	<-old.drained
+
+	if l, ok := old.fs.(*lockingRawFileSystem); ok && ms.opts.SingleThreaded {
+		return l.RawFS
+	}
+	return old.fs
+}
@@ -0,0 +1,117 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// swapFS answers GetAttr with its own size. If gate is set, GetAttr
+// waits in it.
+type swapFS struct {
+	fuse.RawFileSystem
+	size   uint64
+	gate   *gate
+	server *fuse.Server
+}
+
+func (fs *swapFS) Init(s *fuse.Server) {
+	fs.server = s
+}
+
+func (fs *swapFS) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	if fs.gate != nil {
+		fs.gate.enter()
+		defer fs.gate.leave()
+	}
+	out.Mode = fuse.S_IFDIR | 0755
+	out.Size = fs.size
+	return fuse.OK
+}
+
+func testReplaceFileSystem(t *testing.T, opts *fuse.MountOptions) {
+	oldFS := &swapFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		size:          1,
+		gate:          newGate(true),
+	}
+	k, err := fakekernel.New(oldFS, opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	done := make(chan uint64)
+	go func() {
+		out, code := k.GetAttr(fuse.FUSE_ROOT_ID)
+		if !code.Ok() {
+			t.Errorf("GetAttr: %v", code)
+		}
+		done <- out.Size
+	}()
+	oldFS.gate.waitRunning(t, 1)
+
+	newFS := &swapFS{RawFileSystem: fuse.NewDefaultRawFileSystem(), size: 2}
+	replaced := make(chan fuse.RawFileSystem)
+	go func() {
+		replaced <- k.Server().ReplaceFileSystem(newFS)
+	}()
+
+	if opts.SingleThreaded {
+		// Init of the new file system waits for the lock
+		// held by the old one.
+		oldFS.gate.open()
+	}
+	for deadline := time.Now().Add(5 * time.Second); k.Server().FileSystem() != newFS; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("file system was not switched")
+		}
+	}
+	if newFS.server != k.Server() {
+		t.Errorf("new file system was not initialized")
+	}
+
+	if !opts.SingleThreaded {
+		// New requests go to the new file system, while the
+		// old one still has a request.
+		out, code := k.GetAttr(fuse.FUSE_ROOT_ID)
+		if !code.Ok() {
+			t.Fatalf("GetAttr: %v", code)
+		}
+		if out.Size != 2 {
+			t.Errorf("got size %d, want 2 from the new file system", out.Size)
+		}
+		select {
+		case <-replaced:
+			t.Fatalf("ReplaceFileSystem returned before draining")
+		default:
+		}
+		oldFS.gate.open()
+	}
+	if size := <-done; size != 1 {
+		t.Errorf("in-flight request got size %d, want 1 from the old file system", size)
+	}
+	if got := <-replaced; got != oldFS {
+		t.Errorf("ReplaceFileSystem returned %v, want the old file system", got)
+	}
+	if out, code := k.GetAttr(fuse.FUSE_ROOT_ID); !code.Ok() || out.Size != 2 {
+		t.Errorf("GetAttr after replacing: %v, size %d", code, out.Size)
+	}
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestReplaceFileSystem(t *testing.T) {
+	testReplaceFileSystem(t, &fuse.MountOptions{})
+}
+
+// With SingleThreaded, the new file system waits for the old one.
+func TestReplaceFileSystemSingleThreaded(t *testing.T) {
+	testReplaceFileSystem(t, &fuse.MountOptions{SingleThreaded: true})
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import "time"
+
+// IdleTime returns how long ago the server finished its last
+// request, or 0 if it is serving one. FORGETs do not count, as the
+// kernel sends them when it evicts its caches, not when the file
+// system is used.
+func (ms *Server) IdleTime() time.Duration {
+	if ms.requestsLive.Load() > 0 {
+		return 0
+	}
+	d := ms.opts.Clock.Now().Sub(time.Unix(0, ms.lastActive.Load()))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// touch records that req is done, for IdleTime.
+func (ms *Server) touch(req *request) {
+	if h := req.inHeader; h != nil && (h.Opcode == _OP_FORGET || h.Opcode == _OP_BATCH_FORGET) {
+		return
+	}
+	ms.lastActive.Store(ms.opts.Clock.Now().UnixNano())
+}
+
+// watchIdle calls unmount once the server has been idle for timeout,
+// and again after each further idle period while unmount fails. It
+// returns when unmount succeeds or done is closed.
+func (ms *Server) watchIdle(timeout time.Duration, unmount func() error, done <-chan struct{}) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+		idle := ms.IdleTime()
+		if idle < timeout {
+			timer.Reset(timeout - idle)
+			continue
+		}
+		err := unmount()
+		if err == nil {
+			return
+		}
+		ms.opts.Logger.Printf("idle unmount: %v", err)
+		timer.Reset(timeout)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"io"
+	"log"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestIdleTime(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	ms, err := newServer(NewDefaultRawFileSystem(), &MountOptions{Clock: clock})
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	clock.now = clock.now.Add(time.Minute)
+	if got := ms.IdleTime(); got != time.Minute {
+		t.Errorf("after start: got %v, want 1m", got)
+	}
+
+	ms.requestsLive.Add(1)
+	if got := ms.IdleTime(); got != 0 {
+		t.Errorf("during request: got %v, want 0", got)
+	}
+	ms.touch(&request{inHeader: &InHeader{Opcode: _OP_FORGET}})
+	ms.requestsLive.Add(-1)
+	if got := ms.IdleTime(); got != time.Minute {
+		t.Errorf("after FORGET: got %v, want 1m", got)
+	}
+
+	ms.touch(&request{inHeader: &InHeader{Opcode: _OP_GETATTR}})
+	clock.now = clock.now.Add(time.Second)
+	if got := ms.IdleTime(); got != time.Second {
+		t.Errorf("after GETATTR: got %v, want 1s", got)
+	}
+}
+
+func TestWatchIdle(t *testing.T) {
+	ms, err := newServer(NewDefaultRawFileSystem(), &MountOptions{
+		Logger: log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	const timeout = 20 * time.Millisecond
+	start := time.Now()
+	calls := 0
+	unmount := func() error {
+		calls++
+		if calls == 1 {
+			return syscall.EBUSY
+		}
+		return nil
+	}
+	ms.watchIdle(timeout, unmount, nil)
+	if calls != 2 {
+		t.Errorf("got %d unmount calls, want 2", calls)
+	}
+	if d := time.Since(start); d < 2*timeout {
+		t.Errorf("unmounted after %v, want at least %v", d, 2*timeout)
+	}
+
+	// Activity postpones the unmount, and closing done stops the
+	// watch.
+	done := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		ms.watchIdle(timeout, func() error {
+			t.Errorf("unmount while active")
+			return nil
+		}, done)
+		close(returned)
+	}()
+	ms.requestsLive.Add(1)
+	time.Sleep(3 * timeout)
+	close(done)
+	<-returned
+	ms.requestsLive.Add(-1)
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"unsafe"
+)
+
+// inflightSize returns how many bytes of the request input count
+// against MountOptions.MaxInflightBytes.
+func inflightSize(in []byte) int64 {
+	if len(in) < int(unsafe.Sizeof(InHeader{})) {
+		return 0
+	}
+	if (*InHeader)(unsafe.Pointer(&in[0])).Opcode != _OP_WRITE {
+		return 0
+	}
+	return int64(len(in))
+}
+
+// waitInflight blocks while the in-flight bytes are over the
+// ceiling. Called with reqMu held.
+func (ms *Server) waitInflight() {
+	for ms.maxInflight > 0 && ms.inflightBytes >= ms.maxInflight {
+		ms.inflightWaiters++
+		ms.inflightCond.Wait()
+		ms.inflightWaiters--
+	}
+}
+
+// addInflight accounts for the input of req. Called with reqMu held.
+func (ms *Server) addInflight(req *request) {
+	if ms.maxInflight <= 0 {
+		return
+	}
+	req.inflight = inflightSize(req.inputBuf)
+	if req.inflight > 0 {
+		req.inflight += int64(req.payloadLen())
+	}
+	ms.inflightBytes += req.inflight
+}
+
+// releaseInflight drops the bytes of a finished request, and wakes
+// up readers waiting for room.
+func (ms *Server) releaseInflight(req *request) {
+	if req.inflight == 0 {
+		return
+	}
+	ms.reqMu.Lock()
+	ms.inflightBytes -= req.inflight
+	ms.inflightCond.Broadcast()
+	ms.reqMu.Unlock()
+	req.inflight = 0
+}
+
+// InflightBytes returns the number of bytes held in write requests
+// that are being handled. It is only counted if
+// MountOptions.MaxInflightBytes is set.
+func (ms *Server) InflightBytes() int64 {
+	ms.reqMu.Lock()
+	defer ms.reqMu.Unlock()
+	return ms.inflightBytes
+}
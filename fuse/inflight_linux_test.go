@@ -0,0 +1,76 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// blockingWriteFS holds WRITE in a gate.
+type blockingWriteFS struct {
+	fuse.RawFileSystem
+	gate *gate
+}
+
+func (fs *blockingWriteFS) Write(input *fuse.WriteIn, data []byte) (uint32, fuse.Status) {
+	fs.gate.enter()
+	defer fs.gate.leave()
+	return uint32(len(data)), fuse.OK
+}
+
+func TestMaxInflightBytes(t *testing.T) {
+	fs := &blockingWriteFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		gate:          newGate(true),
+	}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{MaxInflightBytes: 1000, MaxReaders: 4})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, code := k.Write(fuse.FUSE_ROOT_ID, 0, 0, make([]byte, 600)); !code.Ok() {
+				t.Errorf("Write: %v", code)
+			}
+		}()
+	}
+
+	// Two writes fill the budget, after which the server stops
+	// reading: a reader waits for room instead of picking up the
+	// third write.
+	fs.gate.waitRunning(t, 2)
+	for deadline := time.Now().Add(5 * time.Second); fuse.InflightWaiters(k.Server()) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no reader waits for in-flight bytes")
+		}
+	}
+	if got := k.Server().InflightBytes(); got < 1000 {
+		t.Errorf("got %d bytes in flight, want at least 1000", got)
+	}
+	if got := fs.gate.Running(); got != 2 {
+		t.Errorf("got %d writes running while over the ceiling, want 2", got)
+	}
+
+	fs.gate.open()
+	wg.Wait()
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := fs.gate.Max(); got != 2 {
+		t.Errorf("got %d concurrent writes, want 2", got)
+	}
+	if got := k.Server().InflightBytes(); got != 0 {
+		t.Errorf("got %d bytes in flight after finishing, want 0", got)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import "context"
+
+// Intercepted is a decoded request, as seen by an Interceptor.
+type Intercepted struct {
+	Header *InHeader
+
+	// In points to the input struct, eg. *OpenIn, or is nil if
+	// the opcode has none. It may be modified.
+	In interface{}
+
+	// Names holds the file name arguments, if any.
+	Names []string
+
+	// Out points to the output struct, eg. *EntryOut, or is nil
+	// if the opcode has none.
+	Out interface{}
+
+	// Status of the reply.
+	Status Status
+
+	// Data is the unstructured part of the reply, eg. for READ
+	// or READLINK. It is nil for reads served from a file
+	// descriptor.
+	Data []byte
+
+	processes *ProcessResolver
+	req       *request
+}
+
+// Context returns the Go context that the interceptors have attached
+// to the request, or context.Background().
+func (r *Intercepted) Context() context.Context {
+	if r.req.ctx == nil {
+		return context.Background()
+	}
+	return r.req.ctx
+}
+
+// SetContext attaches ctx to the request, eg. to carry a trace ID
+// from an interceptor to the backend RPCs that the file system makes
+// for the request. File system methods find it with
+// Server.RequestContext.
+func (r *Intercepted) SetContext(ctx context.Context) {
+	r.req.ctx = ctx
+}
+
+// OpName returns the name of the opcode, eg. "LOOKUP".
+func (r *Intercepted) OpName() string {
+	return operationName(r.Header.Opcode)
+}
+
+// Process returns the process that sent the request. Its name is
+// only filled in if MountOptions.ProcessResolver is set.
+func (r *Intercepted) Process() ProcessInfo {
+	if r.processes == nil || r.Header.Pid == 0 {
+		return ProcessInfo{Pid: r.Header.Pid}
+	}
+	return r.processes.Lookup(r.Header.Pid)
+}
+
+// Interceptor sees requests before they are dispatched, and their
+// replies before they are written. Interceptors are set through
+// MountOptions.Interceptors; they run in order before dispatch, and
+// in reverse order afterwards. They are called concurrently for
+// different requests.
+type Interceptor interface {
+	// Before is called before the file system sees the request.
+	// Returning false short-circuits it: the file system and the
+	// remaining interceptors are skipped, and the reply is made
+	// from r.Status, r.Out and r.Data.
+	Before(r *Intercepted) bool
+
+	// After is called with the reply, and may change it. It is
+	// called for every interceptor whose Before was called.
+	After(r *Intercepted)
+}
+
+// intercept runs the request through the interceptors, calling the
+// handler unless one of them handles the request itself.
+func (ms *Server) intercept(req *request) {
+	ir := &Intercepted{
+		Header: req.inHeader,
+		Names:  req.filenames,
+		Status: OK,
+
+		processes: ms.opts.ProcessResolver,
+		req:       req,
+	}
+	if req.handler.DecodeIn != nil {
+		ir.In = req.handler.DecodeIn(req.inData)
+	}
+	if req.handler.DecodeOut != nil && req.handler.OutputSize > 0 {
+		ir.Out = req.handler.DecodeOut(req.outData())
+	}
+
+	chain := ms.opts.Interceptors
+	handled := false
+	n := 0
+	for n < len(chain) {
+		n++
+		if !chain[n-1].Before(ir) {
+			handled = true
+			break
+		}
+	}
+
+	if !handled {
+		req.handler.Func(ms, req)
+		ir.Status = req.status
+		ir.Data = req.flatData
+	}
+	for i := n - 1; i >= 0; i-- {
+		chain[i].After(ir)
+	}
+
+	req.status = ir.Status
+	if req.fdData == nil {
+		req.flatData = ir.Data
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// recorder logs the order of interceptor calls.
+type recorder struct {
+	name string
+	mu   *sync.Mutex
+	log  *[]string
+}
+
+func (r *recorder) Before(ir *fuse.Intercepted) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*r.log = append(*r.log, r.name+" before "+ir.OpName())
+	return true
+}
+
+func (r *recorder) After(ir *fuse.Intercepted) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*r.log = append(*r.log, fmt.Sprintf("%s after %s %v", r.name, ir.OpName(), ir.Status))
+}
+
+// guard refuses UNLINK, answers READLINK itself, and fakes the size
+// in LOOKUP replies.
+type guard struct{}
+
+func (guard) Before(ir *fuse.Intercepted) bool {
+	switch ir.OpName() {
+	case "UNLINK":
+		ir.Status = fuse.EACCES
+		return false
+	case "READLINK":
+		ir.Data = []byte("cached")
+		return false
+	}
+	return true
+}
+
+func (guard) After(ir *fuse.Intercepted) {
+	if out, ok := ir.Out.(*fuse.EntryOut); ok && ir.Status.Ok() {
+		out.Size = 42
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var log []string
+	nfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(dir), nil)
+	conn := nodefs.NewFileSystemConnector(nfs.Root(), nil)
+	k, err := fakekernel.New(conn.RawFS(), &fuse.MountOptions{
+		Interceptors: []fuse.Interceptor{
+			&recorder{"outer", &mu, &log},
+			guard{},
+			&recorder{"inner", &mu, &log},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	entry, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if entry.Size != 42 {
+		t.Errorf("Lookup: got size %d, want 42 from the interceptor", entry.Size)
+	}
+	if code := k.Unlink(fuse.FUSE_ROOT_ID, "file"); code != fuse.EACCES {
+		t.Errorf("Unlink: got %v, want EACCES", code)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "file")); err != nil {
+		t.Errorf("file was removed: %v", err)
+	}
+	if target, code := k.Readlink(entry.NodeId); !code.Ok() || target != "cached" {
+		t.Errorf("Readlink: got %q, %v, want the cached reply", target, code)
+	}
+
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	want := []string{
+		"outer before INIT",
+		"inner before INIT",
+		"inner after INIT OK",
+		"outer after INIT OK",
+		"outer before LOOKUP",
+		"inner before LOOKUP",
+		"inner after LOOKUP OK",
+		"outer after LOOKUP OK",
+		"outer before UNLINK",
+		"outer after UNLINK 13=permission denied",
+		"outer before READLINK",
+		"outer after READLINK OK",
+	}
+	if strings.Join(log, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got calls\n%s\nwant\n%s", strings.Join(log, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+type traceKey struct{}
+
+// tracer attaches the Unique of each request as trace ID.
+type tracer struct{}
+
+func (tracer) Before(ir *fuse.Intercepted) bool {
+	ir.SetContext(context.WithValue(ir.Context(), traceKey{}, ir.Header.Unique))
+	return true
+}
+
+func (tracer) After(ir *fuse.Intercepted) {}
+
+type traceFS struct {
+	fuse.RawFileSystem
+	server *fuse.Server
+	traces chan interface{}
+}
+
+func (fs *traceFS) GetAttr(in *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	fs.traces <- fs.server.RequestContext(&in.Context).Value(traceKey{})
+	return fuse.OK
+}
+
+func TestInterceptorContext(t *testing.T) {
+	fs := &traceFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		traces:        make(chan interface{}, 1),
+	}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{
+		TrackRequests: true,
+		Interceptors:  []fuse.Interceptor{tracer{}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+	fs.server = k.Server()
+
+	for i := 0; i < 2; i++ {
+		if _, code := k.GetAttr(fuse.FUSE_ROOT_ID); !code.Ok() {
+			t.Fatalf("GetAttr: %v", code)
+		}
+		if id, ok := (<-fs.traces).(uint64); !ok || id == 0 {
+			t.Errorf("got trace ID %v", id)
+		}
+	}
+	if got := fs.server.RequestContext(nil); got != context.Background() {
+		t.Errorf("RequestContext(nil): got %v", got)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import "sync"
+
+// The kernel sends INTERRUPT when the process that waits for a
+// request gets a signal. A file system method that may block for a
+// long time, eg. on a lock or on a backend, should then either finish
+// quickly, or give up and return EINTR. Either way, the request must
+// be answered: the kernel keeps waiting for the reply, and the
+// library sends it when the method returns, as always. If the reply
+// is EINTR, the kernel restarts the system call, or fails it with
+// EINTR, depending on the signal handler of the process.
+//
+// Methods find out about interrupts with Server.Interrupted, or wait
+// with Server.Wait and Server.WaitCond. This needs
+// MountOptions.EnableInterrupts. Without it, the server answers
+// INTERRUPT with ENOSYS, after which the kernel stops sending them.
+
+// interruptTable tracks the requests being dispatched, by their
+// Unique for INTERRUPT, and by the address of their Context for the
+// methods.
+type interruptTable struct {
+	mu    sync.Mutex
+	byID  map[uint64]*interruptState
+	byCtx map[uintptr]*interruptState
+}
+
+type interruptState struct {
+	interrupted bool
+
+	// ch is closed on interrupt, once some method asked for it.
+	ch chan struct{}
+}
+
+func newInterruptTable() *interruptTable {
+	return &interruptTable{
+		byID:  map[uint64]*interruptState{},
+		byCtx: map[uintptr]*interruptState{},
+	}
+}
+
+func (t *interruptTable) add(req *request) {
+	s := &interruptState{}
+	t.mu.Lock()
+	t.byID[req.inHeader.Unique] = s
+	t.byCtx[contextKey(&req.inHeader.Context)] = s
+	t.mu.Unlock()
+}
+
+func (t *interruptTable) remove(req *request) {
+	t.mu.Lock()
+	delete(t.byID, req.inHeader.Unique)
+	delete(t.byCtx, contextKey(&req.inHeader.Context))
+	t.mu.Unlock()
+}
+
+// interrupt marks the request unique as interrupted. It returns
+// false if the request is not being dispatched.
+func (t *interruptTable) interrupt(unique uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.byID[unique]
+	if s == nil {
+		return false
+	}
+	if !s.interrupted {
+		s.interrupted = true
+		if s.ch != nil {
+			close(s.ch)
+		}
+	}
+	return true
+}
+
+func doInterrupt(server *Server, req *request) {
+	if server.interrupts == nil {
+		req.status = ENOSYS
+		return
+	}
+	in := (*InterruptIn)(req.inData)
+	if !server.interrupts.interrupt(in.Unique) {
+		// The request may not be dispatched yet, having been
+		// read by another reader. EAGAIN makes the kernel
+		// send the INTERRUPT again, unless the request has
+		// been answered by then.
+		req.status = EAGAIN
+	}
+}
+
+// Interrupted returns a channel that is closed when the kernel
+// interrupts the request that ctx belongs to. As for RequestInfo, ctx
+// must be the Context passed to the file system method. The channel
+// is nil, and so never ready, if ctx is not part of a request being
+// dispatched, or if MountOptions.EnableInterrupts is not set.
+func (ms *Server) Interrupted(ctx *Context) <-chan struct{} {
+	t := ms.interrupts
+	if t == nil || ctx == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.byCtx[contextKey(ctx)]
+	if s == nil {
+		return nil
+	}
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+		if s.interrupted {
+			close(s.ch)
+		}
+	}
+	return s.ch
+}
+
+// Wait waits until ch is closed, and returns OK, or until the
+// request of ctx is interrupted, and returns EINTR.
+func (ms *Server) Wait(ctx *Context, ch <-chan struct{}) Status {
+	select {
+	case <-ch:
+		return OK
+	case <-ms.Interrupted(ctx):
+		return EINTR
+	}
+}
+
+// WaitCond waits on c until done returns true, and returns OK, or
+// until the request of ctx is interrupted, and returns EINTR. As for
+// c.Wait, the caller must hold c.L, and done is called with it held.
+func (ms *Server) WaitCond(ctx *Context, c *sync.Cond, done func() bool) Status {
+	intr := ms.Interrupted(ctx)
+	if intr != nil {
+		// Wake up the waiter on interrupt.
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-intr:
+				c.L.Lock()
+				c.Broadcast()
+				c.L.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	for !done() {
+		select {
+		case <-intr:
+			return EINTR
+		default:
+		}
+		c.Wait()
+	}
+	return OK
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// interruptFS blocks GETATTR until interrupted, and READLINK on a
+// condition that never comes true.
+type interruptFS struct {
+	fuse.RawFileSystem
+	server  *fuse.Server
+	started chan uint64
+
+	mu   sync.Mutex
+	cond *sync.Cond
+}
+
+func (fs *interruptFS) begin(ctx *fuse.Context) {
+	info, _ := fs.server.RequestInfo(ctx)
+	fs.started <- info.Unique
+}
+
+func (fs *interruptFS) GetAttr(in *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	fs.begin(&in.Context)
+	return fs.server.Wait(&in.Context, nil)
+}
+
+func (fs *interruptFS) Readlink(header *fuse.InHeader) ([]byte, fuse.Status) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.begin(&header.Context)
+	return nil, fs.server.WaitCond(&header.Context, fs.cond, func() bool { return false })
+}
+
+func TestInterrupt(t *testing.T) {
+	fs := &interruptFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		started:       make(chan uint64, 1),
+	}
+	fs.cond = sync.NewCond(&fs.mu)
+	k, err := fakekernel.New(fs, &fuse.MountOptions{
+		EnableInterrupts: true,
+		TrackRequests:    true,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+	fs.server = k.Server()
+
+	for _, op := range []struct {
+		name string
+		call func() fuse.Status
+	}{
+		{"GetAttr", func() fuse.Status {
+			_, code := k.GetAttr(fuse.FUSE_ROOT_ID)
+			return code
+		}},
+		{"Readlink", func() fuse.Status {
+			_, code := k.Readlink(fuse.FUSE_ROOT_ID)
+			return code
+		}},
+	} {
+		done := make(chan fuse.Status, 1)
+		go func() { done <- op.call() }()
+		unique := <-fs.started
+		if code := k.Interrupt(unique); !code.Ok() {
+			t.Fatalf("Interrupt: %v", code)
+		}
+		if code := <-done; code != fuse.EINTR {
+			t.Errorf("%s: got %v, want EINTR", op.name, code)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The structs are overlaid on the kernel's messages, which have the
+// same layout on all architectures: fields are explicitly padded to
+// their natural alignment. The Go structs must not rely on implicit
+// padding either, as that differs between eg. amd64 and 386, where
+// uint64 is only 4-byte aligned.
+func TestWireLayout(t *testing.T) {
+	const in = 40 // struct fuse_in_header
+	for _, c := range []struct {
+		v    interface{}
+		size uintptr
+	}{
+		{InHeader{}, 40},
+		{OutHeader{}, 16},
+		{Attr{}, 88},
+		{EntryOut{}, 128},
+		{AttrOut{}, 104},
+		{OpenOut{}, 16},
+		{CreateOut{}, 144},
+		{InitIn{}, in + 16},
+		{InitOut{}, 64},
+		{ForgetIn{}, in + 8},
+		{_ForgetOne{}, 16},
+		{_BatchForgetIn{}, in + 8},
+		{MkdirIn{}, in + 8},
+		{RenameIn{}, in + 8},
+		{Rename2In{}, in + 16},
+		{LinkIn{}, in + 8},
+		{SetAttrIn{}, in + 88},
+		{GetAttrIn{}, in + 16},
+		{ReleaseIn{}, in + 24},
+		{OpenIn{}, in + 8},
+		{CreateIn{}, in + 16},
+		{MknodIn{}, in + 16},
+		{ReadIn{}, in + 40},
+		{WriteIn{}, in + 40},
+		{WriteOut{}, 8},
+		{SetXAttrIn{}, in + 8},
+		{GetXAttrIn{}, in + 8},
+		{GetXAttrOut{}, 8},
+		{FileLock{}, 24},
+		{LkIn{}, in + 48},
+		{LkOut{}, 24},
+		{AccessIn{}, in + 8},
+		{FsyncIn{}, in + 16},
+		{FlushIn{}, in + 24},
+		{InterruptIn{}, in + 8},
+		{StatfsOut{}, 80},
+		{_Dirent{}, 24},
+		{FallocateIn{}, in + 32},
+		{NotifyInvalInodeOut{}, 24},
+		{NotifyInvalEntryOut{}, 16},
+		{NotifyInvalDeleteOut{}, 24},
+		{BmapIn{}, in + 16},
+		{BmapOut{}, 8},
+	} {
+		typ := reflect.TypeOf(c.v)
+		if got := typ.Size(); got != c.size {
+			t.Errorf("%s: size %d, want %d", typ, got, c.size)
+		}
+		checkPacked(t, typ.String(), typ)
+	}
+}
+
+// checkPacked fails if typ has padding between or after its fields.
+func checkPacked(t *testing.T, path string, typ reflect.Type) {
+	if typ.Kind() != reflect.Struct {
+		return
+	}
+	var off uintptr
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Offset != off {
+			t.Errorf("%s.%s: offset %d, want %d", path, f.Name, f.Offset, off)
+		}
+		checkPacked(t, path+"."+f.Name, f.Type)
+		off = f.Offset + f.Type.Size()
+	}
+	if off != typ.Size() {
+		t.Errorf("%s: %d bytes of tail padding", path, typ.Size()-off)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sync"
+	"time"
+)
+
+// ErrorLockdown makes a mount read-only once the file system fails
+// too many changes, like ext4 with errors=remount-ro: rather than
+// have programs carry on writing to a backend that loses data, all
+// further changes fail with EROFS, until Server.SetReadOnly(false)
+// is called, eg. after repairs.
+type ErrorLockdown struct {
+	// Lock down after this many failures, default 1.
+	Threshold int
+
+	// If set, only failures this recent count.
+	Window time.Duration
+
+	// IsFailure reports whether a status returned for a change
+	// counts. If nil, EIO does.
+	IsFailure func(Status) bool
+
+	// If set, called once when the mount is locked down.
+	OnLockdown func()
+}
+
+// lockdown counts failures for ErrorLockdown.
+type lockdown struct {
+	opts  ErrorLockdown
+	clock Clock
+
+	mu sync.Mutex
+	// Times of the failures counted, oldest first.
+	failures []time.Time
+}
+
+func newLockdown(opts *ErrorLockdown, clock Clock) *lockdown {
+	l := &lockdown{opts: *opts, clock: clock}
+	if l.opts.Threshold < 1 {
+		l.opts.Threshold = 1
+	}
+	if l.opts.IsFailure == nil {
+		l.opts.IsFailure = func(code Status) bool { return code == EIO }
+	}
+	return l
+}
+
+// note counts the result of a change, and returns true if it trips
+// the lockdown.
+func (l *lockdown) note(code Status) bool {
+	if !l.opts.IsFailure(code) {
+		return false
+	}
+	now := l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w := l.opts.Window; w > 0 {
+		i := 0
+		for i < len(l.failures) && now.Sub(l.failures[i]) > w {
+			i++
+		}
+		l.failures = l.failures[i:]
+	}
+	l.failures = append(l.failures, now)
+	if len(l.failures) < l.opts.Threshold {
+		return false
+	}
+	l.failures = nil
+	return true
+}
+
+func (l *lockdown) reset() {
+	l.mu.Lock()
+	l.failures = nil
+	l.mu.Unlock()
+}
+
+// isChange reports whether req changes the file system, and so is
+// refused on a read-only mount.
+func isChange(req *request) bool {
+	switch req.inHeader.Opcode {
+	case _OP_SETATTR, _OP_SYMLINK, _OP_MKNOD, _OP_MKDIR, _OP_UNLINK,
+		_OP_RMDIR, _OP_RENAME, _OP_FUSE_RENAME2, _OP_LINK, _OP_WRITE,
+		_OP_SETXATTR, _OP_REMOVEXATTR, _OP_CREATE, _OP_FALLOCATE:
+		return true
+	case _OP_OPEN:
+		return (*OpenIn)(req.inData).Flags&O_ANYWRITE != 0
+	case _OP_ACCESS:
+		return (*AccessIn)(req.inData).Mask&W_OK != 0
+	}
+	return false
+}
+
+// countsForLockdown reports whether a failure of req may be a lost
+// change. Failing FSYNC and FLUSH report earlier writes that did not
+// make it.
+func countsForLockdown(req *request) bool {
+	switch req.inHeader.Opcode {
+	case _OP_FSYNC, _OP_FSYNCDIR, _OP_FLUSH:
+		return true
+	case _OP_OPEN, _OP_ACCESS:
+		return false
+	}
+	return isChange(req)
+}
+
+// ReadOnly reports whether changes are refused with EROFS, because
+// of MountOptions.ErrorLockdown or SetReadOnly.
+func (ms *Server) ReadOnly() bool {
+	return ms.readOnly.Load()
+}
+
+// SetReadOnly makes the mount refuse changes with EROFS, or lifts
+// that, and forgets the failures counted for ErrorLockdown. Opening
+// for writing fails too; files that were open for writing before
+// see EROFS from their writes.
+func (ms *Server) SetReadOnly(ro bool) {
+	ms.readOnly.Store(ro)
+	if ms.lockdown != nil {
+		ms.lockdown.reset()
+	}
+}
+
+// noteLockdown counts the result of req for ErrorLockdown.
+func (ms *Server) noteLockdown(req *request) {
+	if ms.lockdown == nil || !countsForLockdown(req) || !ms.lockdown.note(req.status) {
+		return
+	}
+	if ms.readOnly.Swap(true) {
+		return
+	}
+	ms.opts.Logger.Printf("%s i%d failed with %v: locking the mount down to read-only",
+		operationName(req.inHeader.Opcode), req.inHeader.NodeId, req.status)
+	if f := ms.lockdown.opts.OnLockdown; f != nil {
+		f()
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// eioFS fails Mkdir with EIO, and lets everything be opened.
+type eioFS struct {
+	fuse.RawFileSystem
+	mkdirs int
+}
+
+func (fs *eioFS) Mkdir(input *fuse.MkdirIn, name string, out *fuse.EntryOut) fuse.Status {
+	fs.mkdirs++
+	return fuse.EIO
+}
+
+func (fs *eioFS) Open(input *fuse.OpenIn, out *fuse.OpenOut) fuse.Status {
+	return fuse.OK
+}
+
+func TestErrorLockdown(t *testing.T) {
+	fs := &eioFS{RawFileSystem: fuse.NewDefaultRawFileSystem()}
+	locked := 0
+	k, err := fakekernel.New(fs, &fuse.MountOptions{
+		ErrorLockdown: &fuse.ErrorLockdown{
+			Threshold:  2,
+			OnLockdown: func() { locked++ },
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, code := k.Mkdir(fuse.FUSE_ROOT_ID, "dir", 0755); code != fuse.EIO {
+			t.Fatalf("Mkdir %d: got %v, want EIO", i, code)
+		}
+	}
+	if !k.Server().ReadOnly() || locked != 1 {
+		t.Fatalf("not locked down after 2 failures: %v, %d", k.Server().ReadOnly(), locked)
+	}
+
+	if _, code := k.Mkdir(fuse.FUSE_ROOT_ID, "dir", 0755); code != fuse.EROFS {
+		t.Errorf("Mkdir when locked down: got %v, want EROFS", code)
+	}
+	if fs.mkdirs != 2 {
+		t.Errorf("file system got %d Mkdir calls, want 2", fs.mkdirs)
+	}
+	if _, code := k.Open(fuse.FUSE_ROOT_ID, uint32(os.O_RDWR)); code != fuse.EROFS {
+		t.Errorf("Open for writing: got %v, want EROFS", code)
+	}
+	if _, code := k.Open(fuse.FUSE_ROOT_ID, uint32(os.O_RDONLY)); !code.Ok() {
+		t.Errorf("Open for reading: %v", code)
+	}
+
+	k.Server().SetReadOnly(false)
+	if _, code := k.Mkdir(fuse.FUSE_ROOT_ID, "dir", 0755); code != fuse.EIO {
+		t.Errorf("Mkdir after lifting: got %v, want EIO", code)
+	}
+	if k.Server().ReadOnly() {
+		t.Error("locked down again after 1 failure")
+	}
+}
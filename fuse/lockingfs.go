@@ -14,13 +14,17 @@ import (
 
 type lockingRawFileSystem struct {
 	RawFS RawFileSystem
-	lock  sync.Mutex
+
+	// lock is shared with the file systems that replace this
+	// one; see Server.ReplaceFileSystem.
+	lock *sync.Mutex
 }
 
 // Returns a Wrap
 func NewLockingRawFileSystem(fs RawFileSystem) RawFileSystem {
 	return &lockingRawFileSystem{
 		RawFS: fs,
+		lock:  &sync.Mutex{},
 	}
 }
 
@@ -219,6 +223,11 @@ func (fs *lockingRawFileSystem) Fallocate(in *FallocateIn) (code Status) {
 	return fs.RawFS.Fallocate(in)
 }
 
+func (fs *lockingRawFileSystem) Bmap(in *BmapIn, out *BmapOut) (code Status) {
+	defer fs.locked()()
+	return fs.RawFS.Bmap(in, out)
+}
+
 func (fs *lockingRawFileSystem) String() string {
 	defer fs.locked()()
 	return fmt.Sprintf("Locked(%s)", fs.RawFS.String())
@@ -0,0 +1,138 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sync"
+	"syscall"
+)
+
+// LockTable keeps the POSIX record locks of one file, for file systems
+// that have no lock manager of their own. Ranges are inclusive, as in
+// FileLock; the kernel uses 0x7fffffffffffffff as End for locks that
+// extend to the end of the file. The zero value is an empty table.
+//
+// Locks of different owners conflict if they overlap and one is a
+// write lock. Deadlocks between waiting owners are not detected.
+type LockTable struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	locks []heldLock
+}
+
+type heldLock struct {
+	owner uint64
+	lk    FileLock
+}
+
+func overlaps(a, b *FileLock) bool {
+	return a.Start <= b.End && b.Start <= a.End
+}
+
+// conflict returns a lock that keeps owner from taking lk, or nil.
+func (t *LockTable) conflict(owner uint64, lk *FileLock) *FileLock {
+	for i := range t.locks {
+		h := &t.locks[i]
+		if h.owner != owner && overlaps(&h.lk, lk) &&
+			(h.lk.Typ == syscall.F_WRLCK || lk.Typ == syscall.F_WRLCK) {
+			return &h.lk
+		}
+	}
+	return nil
+}
+
+// GetLk sets out to a lock that conflicts with lk, or to lk with type
+// F_UNLCK if there is none, like F_GETLK.
+func (t *LockTable) GetLk(owner uint64, lk *FileLock, out *FileLock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c := t.conflict(owner, lk); c != nil {
+		*out = *c
+		return
+	}
+	*out = *lk
+	out.Typ = syscall.F_UNLCK
+}
+
+// SetLk takes or, for F_UNLCK, releases the range in lk for owner,
+// like F_SETLK. A lock the owner holds on the range is replaced. It
+// returns EAGAIN if another owner holds a conflicting lock.
+func (t *LockTable) SetLk(owner uint64, lk *FileLock) Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if lk.Typ != syscall.F_UNLCK && t.conflict(owner, lk) != nil {
+		return EAGAIN
+	}
+	t.set(owner, lk)
+	return OK
+}
+
+// SetLkw is like SetLk, but waits for conflicting locks to be
+// released, like F_SETLKW.
+func (t *LockTable) SetLkw(owner uint64, lk *FileLock) Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if lk.Typ != syscall.F_UNLCK {
+		for t.conflict(owner, lk) != nil {
+			if t.cond == nil {
+				t.cond = sync.NewCond(&t.mu)
+			}
+			t.cond.Wait()
+		}
+	}
+	t.set(owner, lk)
+	return OK
+}
+
+// set replaces owner's locks on the range of lk. The caller holds
+// t.mu.
+func (t *LockTable) set(owner uint64, lk *FileLock) {
+	var kept []heldLock
+	for _, h := range t.locks {
+		if h.owner != owner || !overlaps(&h.lk, lk) {
+			kept = append(kept, h)
+			continue
+		}
+		// Keep the parts outside lk.
+		if h.lk.Start < lk.Start {
+			before := h
+			before.lk.End = lk.Start - 1
+			kept = append(kept, before)
+		}
+		if h.lk.End > lk.End {
+			after := h
+			after.lk.Start = lk.End + 1
+			kept = append(kept, after)
+		}
+	}
+	if lk.Typ != syscall.F_UNLCK {
+		kept = append(kept, heldLock{owner, *lk})
+	}
+	t.locks = kept
+	t.wake()
+}
+
+// ReleaseOwner releases all locks of owner. The kernel expects this
+// when a process closes any file descriptor for the file, which it
+// signals with the lock owner of FLUSH.
+func (t *LockTable) ReleaseOwner(owner uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kept := t.locks[:0]
+	for _, h := range t.locks {
+		if h.owner != owner {
+			kept = append(kept, h)
+		}
+	}
+	t.locks = kept
+	t.wake()
+}
+
+// wake lets waiters in SetLkw check again. The caller holds t.mu.
+func (t *LockTable) wake() {
+	if t.cond != nil {
+		t.cond.Broadcast()
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLockTable(t *testing.T) {
+	var tab LockTable
+	const a, b = 1, 2
+	wr := func(start, end uint64) *FileLock {
+		return &FileLock{Start: start, End: end, Typ: syscall.F_WRLCK}
+	}
+	rd := func(start, end uint64) *FileLock {
+		return &FileLock{Start: start, End: end, Typ: syscall.F_RDLCK}
+	}
+	unlock := func(start, end uint64) *FileLock {
+		return &FileLock{Start: start, End: end, Typ: syscall.F_UNLCK}
+	}
+
+	if code := tab.SetLk(a, wr(0, 99)); !code.Ok() {
+		t.Fatalf("SetLk: %v", code)
+	}
+	if code := tab.SetLk(b, rd(50, 60)); code != EAGAIN {
+		t.Errorf("conflicting SetLk: got %v, want EAGAIN", code)
+	}
+	if code := tab.SetLk(b, wr(100, 200)); !code.Ok() {
+		t.Errorf("adjacent SetLk: %v", code)
+	}
+	var out FileLock
+	tab.GetLk(b, rd(10, 10), &out)
+	if out != *wr(0, 99) {
+		t.Errorf("GetLk: got %v, want a's lock", &out)
+	}
+	tab.GetLk(a, rd(10, 10), &out)
+	if out.Typ != syscall.F_UNLCK {
+		t.Errorf("GetLk own range: got %v, want unlocked", &out)
+	}
+
+	// Unlocking the middle splits the lock.
+	tab.SetLk(a, unlock(40, 59))
+	if code := tab.SetLk(b, rd(40, 59)); !code.Ok() {
+		t.Errorf("SetLk in hole: %v", code)
+	}
+	for _, off := range []uint64{39, 60} {
+		if code := tab.SetLk(b, rd(off, off)); code != EAGAIN {
+			t.Errorf("SetLk at %d: got %v, want EAGAIN", off, code)
+		}
+	}
+
+	// Downgrading to a read lock lets readers in.
+	tab.SetLk(a, rd(0, 39))
+	if code := tab.SetLk(b, rd(0, 39)); !code.Ok() {
+		t.Errorf("shared read lock: %v", code)
+	}
+
+	// SetLkw waits until the owner releases its locks.
+	done := make(chan Status)
+	go func() { done <- tab.SetLkw(b, wr(60, 99)) }()
+	select {
+	case code := <-done:
+		t.Fatalf("SetLkw returned %v while a holds the range", code)
+	case <-time.After(10 * time.Millisecond):
+	}
+	tab.ReleaseOwner(a)
+	if code := <-done; !code.Ok() {
+		t.Errorf("SetLkw: %v", code)
+	}
+	tab.GetLk(a, wr(0, 1000), &out)
+	if out.Typ == syscall.F_UNLCK {
+		t.Errorf("GetLk: b's locks are gone")
+	}
+}
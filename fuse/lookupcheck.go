@@ -0,0 +1,223 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LookupCounter is implemented by file systems that keep their own
+// lookup count for each node, such as the nodefs bridge. The
+// LookupChecker compares them against the kernel's view.
+type LookupCounter interface {
+	// LookupCounts returns the lookup count of each node, except
+	// the root.
+	LookupCounts() map[uint64]uint64
+}
+
+// LookupChecker is a RawFileSystem wrapper for debugging, which
+// tracks how many lookups the kernel holds on each node. Each reply
+// carrying an entry (LOOKUP, CREATE, MKDIR, READDIRPLUS, etc.) adds
+// one, and FORGET drops them again. Forgetting more than was handed
+// out is an underflow; it is logged as it happens, and only the
+// balance is passed on.
+//
+// Call Check for the full report. The server also checks when Serve
+// returns, and logs problems through MountOptions.Logger.
+type LookupChecker struct {
+	RawFileSystem
+
+	server *Server
+
+	mu         sync.Mutex
+	counts     map[uint64]uint64
+	underflows []string
+}
+
+// NewLookupChecker wraps fs in a LookupChecker.
+func NewLookupChecker(fs RawFileSystem) *LookupChecker {
+	return &LookupChecker{
+		RawFileSystem: fs,
+		counts:        make(map[uint64]uint64),
+	}
+}
+
+func (c *LookupChecker) String() string {
+	return fmt.Sprintf("LookupChecker(%v)", c.RawFileSystem)
+}
+
+func (c *LookupChecker) Init(server *Server) {
+	c.server = server
+	c.RawFileSystem.Init(server)
+}
+
+func (c *LookupChecker) logf(format string, args ...interface{}) {
+	if c.server != nil {
+		c.server.opts.Logger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (c *LookupChecker) add(code Status, out *EntryOut) {
+	if !code.Ok() || out.NodeId == 0 || out.NodeId == FUSE_ROOT_ID {
+		return
+	}
+	c.mu.Lock()
+	c.counts[out.NodeId]++
+	c.mu.Unlock()
+}
+
+// Check reports nodes whose lookup counts do not balance. If the
+// wrapped file system implements LookupCounter, its counts must
+// match the kernel's, which catches both nodes it leaks and nodes
+// it drops while the kernel still uses them. Otherwise, every node
+// the kernel has not forgotten is reported. As the kernel does not
+// forget nodes when unmounting, this is only useful if all nodes
+// were evicted before, eg. when driving the server directly from a
+// test.
+func (c *LookupChecker) Check() error {
+	return c.check(false)
+}
+
+// CheckExit implements ExitChecker. The kernel does not forget the
+// nodes it still caches when it hangs up, so unless the wrapped file
+// system implements LookupCounter, only underflows are reported.
+// Errors of a wrapped ExitChecker are included.
+func (c *LookupChecker) CheckExit() error {
+	err := c.check(true)
+	if inner, ok := c.RawFileSystem.(ExitChecker); ok {
+		if innerErr := inner.CheckExit(); innerErr != nil {
+			if err == nil {
+				return innerErr
+			}
+			err = fmt.Errorf("%v; %v", err, innerErr)
+		}
+	}
+	return err
+}
+
+func (c *LookupChecker) check(exiting bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	problems := append([]string{}, c.underflows...)
+
+	fsCounts := map[uint64]uint64{}
+	counter, hasCounts := c.RawFileSystem.(LookupCounter)
+	if hasCounts {
+		fsCounts = counter.LookupCounts()
+	}
+
+	var ids []uint64
+	for id := range c.counts {
+		ids = append(ids, id)
+	}
+	for id := range fsCounts {
+		if _, ok := c.counts[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		kernel := c.counts[id]
+		if !hasCounts {
+			if exiting {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("node %d: %d lookups never forgotten", id, kernel))
+		} else if fs := fsCounts[id]; fs != kernel {
+			problems = append(problems, fmt.Sprintf("node %d: kernel holds %d lookups, file system %d", id, kernel, fs))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("lookup imbalance: %s", strings.Join(problems, "; "))
+}
+
+func (c *LookupChecker) Forget(nodeID, nlookup uint64) {
+	c.mu.Lock()
+	have := c.counts[nodeID]
+	if nodeID != FUSE_ROOT_ID {
+		if nlookup > have {
+			msg := fmt.Sprintf("node %d: forget %d, but only %d lookups", nodeID, nlookup, have)
+			c.logf("LookupChecker: %s", msg)
+			c.underflows = append(c.underflows, msg)
+			delete(c.counts, nodeID)
+
+			// Don't pass on the excess, as the file
+			// system may not survive it.
+			nlookup = have
+		} else if nlookup == have {
+			delete(c.counts, nodeID)
+		} else {
+			c.counts[nodeID] = have - nlookup
+		}
+	}
+	c.mu.Unlock()
+
+	if nlookup > 0 {
+		c.RawFileSystem.Forget(nodeID, nlookup)
+	}
+}
+
+func (c *LookupChecker) Lookup(header *InHeader, name string, out *EntryOut) Status {
+	code := c.RawFileSystem.Lookup(header, name, out)
+	c.add(code, out)
+	return code
+}
+
+func (c *LookupChecker) Mknod(input *MknodIn, name string, out *EntryOut) Status {
+	code := c.RawFileSystem.Mknod(input, name, out)
+	c.add(code, out)
+	return code
+}
+
+func (c *LookupChecker) Mkdir(input *MkdirIn, name string, out *EntryOut) Status {
+	code := c.RawFileSystem.Mkdir(input, name, out)
+	c.add(code, out)
+	return code
+}
+
+func (c *LookupChecker) Link(input *LinkIn, filename string, out *EntryOut) Status {
+	code := c.RawFileSystem.Link(input, filename, out)
+	c.add(code, out)
+	return code
+}
+
+func (c *LookupChecker) Symlink(header *InHeader, pointedTo string, linkName string, out *EntryOut) Status {
+	code := c.RawFileSystem.Symlink(header, pointedTo, linkName, out)
+	c.add(code, out)
+	return code
+}
+
+func (c *LookupChecker) Create(input *CreateIn, name string, out *CreateOut) Status {
+	code := c.RawFileSystem.Create(input, name, out)
+	c.add(code, &out.EntryOut)
+	return code
+}
+
+func (c *LookupChecker) ReadDirPlus(input *ReadIn, out *DirEntryList) Status {
+	start := len(out.buf)
+	code := c.RawFileSystem.ReadDirPlus(input, out)
+	if !code.Ok() {
+		return code
+	}
+
+	parseDirents(out.buf[start:], true, func(name string, _ *_Dirent, entry *EntryOut) {
+		// The kernel does not take a reference for . and ..
+		if name != "." && name != ".." {
+			c.add(OK, entry)
+		}
+	})
+	return code
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"strings"
+	"testing"
+)
+
+type entryFS struct {
+	RawFileSystem
+}
+
+func (fs *entryFS) Lookup(header *InHeader, name string, out *EntryOut) Status {
+	if name == "missing" {
+		return ENOENT
+	}
+	out.NodeId = uint64(len(name)) + 10
+	return OK
+}
+
+func (fs *entryFS) ReadDirPlus(input *ReadIn, out *DirEntryList) Status {
+	for _, n := range []string{".", "..", "a", "bb"} {
+		e, _ := out.AddDirLookupEntry(DirEntry{Name: n, Mode: S_IFREG})
+		if n[0] != '.' {
+			e.NodeId = uint64(len(n)) + 10
+		}
+	}
+	return OK
+}
+
+func TestLookupChecker(t *testing.T) {
+	c := NewLookupChecker(&entryFS{NewDefaultRawFileSystem()})
+	var out EntryOut
+	c.Lookup(&InHeader{}, "a", &out)
+	c.Lookup(&InHeader{}, "a", &out)
+	c.Lookup(&InHeader{}, "missing", &out)
+	c.ReadDirPlus(&ReadIn{}, NewDirEntryList(make([]byte, 4096), 0))
+
+	// a has 3 lookups, bb has 1.
+	c.Forget(11, 3)
+	c.Forget(12, 1)
+	if err := c.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	c.Lookup(&InHeader{}, "a", &out)
+	err := c.Check()
+	if err == nil || !strings.Contains(err.Error(), "node 11") {
+		t.Errorf("Check: got %v, want leak of node 11", err)
+	}
+
+	c.Forget(12, 1)
+	err = c.Check()
+	if err == nil || !strings.Contains(err.Error(), "node 12: forget 1, but only 0") {
+		t.Errorf("Check: got %v, want underflow of node 12", err)
+	}
+}
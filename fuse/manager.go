@@ -0,0 +1,213 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ErrManagerShutdown is returned by Manager.Mount after Shutdown.
+var ErrManagerShutdown = errors.New("fuse: manager is shut down")
+
+// Manager runs many mounts in one process, eg. for a daemon that
+// exports a home directory per user. Mounts are added and removed
+// at runtime; they share a buffer pool and latency map, and can be
+// unmounted together with Shutdown.
+//
+// This structure is thread-safe.
+type Manager struct {
+	opts MountOptions
+
+	mu       sync.Mutex
+	mounts   map[string]*managedMount
+	shutdown bool
+}
+
+type managedMount struct {
+	// nil while mounting.
+	server *Server
+
+	// closed when Serve returns.
+	done chan struct{}
+}
+
+// ManagerStats summarizes the mounts of a Manager.
+type ManagerStats struct {
+	Mounts int
+
+	// Usage of the shared buffer pool, if it keeps statistics,
+	// like the one from NewBufferPool.
+	Buffers BufferPoolStats
+}
+
+// NewManager returns a Manager that mounts with the given options.
+// If opts.Buffers is nil, the mounts share a new NewBufferPool.
+func NewManager(opts *MountOptions) *Manager {
+	m := &Manager{
+		mounts: map[string]*managedMount{},
+	}
+	if opts != nil {
+		m.opts = *opts
+	}
+	if m.opts.Buffers == nil {
+		m.opts.Buffers = NewBufferPool()
+	}
+	return m
+}
+
+// Mount mounts fs on mountPoint, serves it in the background, and
+// waits until the mount is ready.
+// options are applied on top of the Manager's MountOptions, except
+// that the buffer pool is always the shared one.
+func (m *Manager) Mount(mountPoint string, fs RawFileSystem, options ...Option) (*Server, error) {
+	key := filepath.Clean(mountPoint)
+
+	m.mu.Lock()
+	if m.shutdown {
+		m.mu.Unlock()
+		return nil, ErrManagerShutdown
+	}
+	if _, ok := m.mounts[key]; ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("fuse: %q is already mounted", key)
+	}
+	// Reserve the mount point while mounting, which may be slow.
+	mm := &managedMount{done: make(chan struct{})}
+	m.mounts[key] = mm
+	m.mu.Unlock()
+
+	o := m.opts
+	for _, opt := range options {
+		opt(&o)
+	}
+	o.Buffers = m.opts.Buffers
+
+	ms, err := NewServer(fs, mountPoint, &o)
+	if err != nil {
+		m.mu.Lock()
+		delete(m.mounts, key)
+		m.mu.Unlock()
+		close(mm.done)
+		return nil, err
+	}
+
+	m.mu.Lock()
+	mm.server = ms
+	shutdown := m.shutdown
+	m.mu.Unlock()
+
+	go func() {
+		ms.Serve()
+
+		m.mu.Lock()
+		if m.mounts[key] == mm {
+			delete(m.mounts, key)
+		}
+		m.mu.Unlock()
+		close(mm.done)
+	}()
+
+	if err := ms.WaitMount(); err != nil {
+		m.unmount(mm)
+		return nil, err
+	}
+	if shutdown {
+		// Shutdown did not see this mount.
+		if err := m.unmount(mm); err != nil {
+			return nil, err
+		}
+		return nil, ErrManagerShutdown
+	}
+	return ms, nil
+}
+
+// Server returns the server for mountPoint, or nil if it is not
+// mounted.
+func (m *Manager) Server(mountPoint string) *Server {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mm := m.mounts[filepath.Clean(mountPoint)]; mm != nil {
+		return mm.server
+	}
+	return nil
+}
+
+// MountPoints returns the mount points, sorted.
+func (m *Manager) MountPoints() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var r []string
+	for k, mm := range m.mounts {
+		if mm.server != nil {
+			r = append(r, k)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Unmount unmounts mountPoint, and waits until its server has
+// stopped.
+func (m *Manager) Unmount(mountPoint string) error {
+	m.mu.Lock()
+	mm := m.mounts[filepath.Clean(mountPoint)]
+	m.mu.Unlock()
+	if mm == nil || mm.server == nil {
+		return fmt.Errorf("fuse: %q is not mounted", mountPoint)
+	}
+	return m.unmount(mm)
+}
+
+func (m *Manager) unmount(mm *managedMount) error {
+	if err := mm.server.Unmount(); err != nil {
+		return err
+	}
+	<-mm.done
+	return nil
+}
+
+// Stats returns a summary of the mounts.
+func (m *Manager) Stats() ManagerStats {
+	var s ManagerStats
+	s.Mounts = len(m.MountPoints())
+	if p, ok := m.opts.Buffers.(interface{ Stats() BufferPoolStats }); ok {
+		s.Buffers = p.Stats()
+	}
+	return s
+}
+
+// Shutdown unmounts all mounts in parallel, and waits for their
+// servers to stop. Afterwards, Mount fails with ErrManagerShutdown,
+// also for mounts that were in progress.
+// The errors of failed unmounts are joined; those mounts stay up.
+func (m *Manager) Shutdown() error {
+	m.mu.Lock()
+	m.shutdown = true
+	var mounts []*managedMount
+	for _, mm := range m.mounts {
+		if mm.server != nil {
+			mounts = append(mounts, mm)
+		}
+	}
+	m.mu.Unlock()
+
+	errs := make([]error, len(mounts))
+	var wg sync.WaitGroup
+	for i, mm := range mounts {
+		wg.Add(1)
+		go func(i int, mm *managedMount) {
+			defer wg.Done()
+			if err := m.unmount(mm); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", mm.server.mountPoint, err)
+			}
+		}(i, mm)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
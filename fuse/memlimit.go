@@ -0,0 +1,225 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryLimit accounts the memory held by caches and buffers of the
+// daemon against a common ceiling, so its size stays predictable
+// under load. Each user registers a MemoryAccount, and charges it for
+// the memory it holds. When a charge does not fit, the other accounts
+// are asked to evict, largest first.
+//
+// Set MountOptions.Memory to charge the request and reply buffers of
+// a Server; nodefs.PrefetchOptions and nodefs.WriteBufferOptions
+// have a field for it too. A MemoryLimit may be shared by several
+// mounts.
+type MemoryLimit struct {
+	max int64
+
+	mu       sync.Mutex
+	used     int64
+	accounts map[*MemoryAccount]struct{}
+	evicted  int64
+	refused  int64
+}
+
+// MemoryAccount is the share of one user of a MemoryLimit.
+type MemoryAccount struct {
+	limit *MemoryLimit
+	name  string
+	evict func(want int64) int64
+
+	// Guarded by limit.mu.
+	used int64
+}
+
+// MemoryStats is a snapshot of a MemoryLimit.
+type MemoryStats struct {
+	Max  int64
+	Used int64
+
+	// Bytes in use per account name.
+	Accounts map[string]int64
+
+	// Bytes freed by eviction, and the number of charges that did
+	// not fit even after evicting.
+	Evicted int64
+	Refused int64
+}
+
+// NewMemoryLimit returns a MemoryLimit of max bytes.
+func NewMemoryLimit(max int64) *MemoryLimit {
+	return &MemoryLimit{
+		max:      max,
+		accounts: map[*MemoryAccount]struct{}{},
+	}
+}
+
+// Register adds an account. If evict is not nil, it is called to free
+// memory of the account for the charges of others: it should drop
+// about want bytes, Release them and return how many it dropped. It
+// runs on the goroutine of the charge, which may hold locks of its
+// own, so evict must not block on locks that are held while charging;
+// use TryLock and return 0 if the data is busy.
+func (l *MemoryLimit) Register(name string, evict func(want int64) int64) *MemoryAccount {
+	a := &MemoryAccount{
+		limit: l,
+		name:  name,
+		evict: evict,
+	}
+	l.mu.Lock()
+	l.accounts[a] = struct{}{}
+	l.mu.Unlock()
+	return a
+}
+
+// Stats returns the current usage.
+func (l *MemoryLimit) Stats() MemoryStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := MemoryStats{
+		Max:      l.max,
+		Used:     l.used,
+		Accounts: map[string]int64{},
+		Evicted:  l.evicted,
+		Refused:  l.refused,
+	}
+	for a := range l.accounts {
+		s.Accounts[a.name] += a.used
+	}
+	return s
+}
+
+func (l *MemoryLimit) String() string {
+	s := l.Stats()
+	var names []string
+	for n := range s.Accounts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, n := range names {
+		parts = append(parts, fmt.Sprintf("%s=%d", n, s.Accounts[n]))
+	}
+	return fmt.Sprintf("memory(%d/%d %s)", s.Used, s.Max, strings.Join(parts, " "))
+}
+
+// victims returns the accounts other than a that can evict, largest
+// first. The caller holds l.mu.
+func (l *MemoryLimit) victims(a *MemoryAccount) []*MemoryAccount {
+	var r []*MemoryAccount
+	for v := range l.accounts {
+		if v != a && v.evict != nil && v.used > 0 {
+			r = append(r, v)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].used > r[j].used })
+	return r
+}
+
+// charge adds n bytes to a, evicting from others if needed. If force
+// is not set, the charge is refused if it does not fit.
+func (a *MemoryAccount) charge(n int64, force bool) bool {
+	l := a.limit
+	l.mu.Lock()
+	if l.used+n > l.max {
+		victims := l.victims(a)
+		l.mu.Unlock()
+		for _, v := range victims {
+			l.mu.Lock()
+			want := l.used + n - l.max
+			l.mu.Unlock()
+			if want <= 0 {
+				break
+			}
+			freed := v.evict(want)
+			l.mu.Lock()
+			l.evicted += freed
+			l.mu.Unlock()
+		}
+		l.mu.Lock()
+	}
+	ok := l.used+n <= l.max
+	if ok || force {
+		l.used += n
+		a.used += n
+	} else {
+		l.refused++
+	}
+	l.mu.Unlock()
+	return ok || force
+}
+
+// TryCharge charges n bytes to the account, if they fit under the
+// ceiling after evicting from other accounts. Otherwise it returns
+// false, and the caller should do without the memory, eg. by not
+// caching.
+func (a *MemoryAccount) TryCharge(n int64) bool {
+	return a.charge(n, false)
+}
+
+// Charge charges n bytes to the account, even if they do not fit
+// after evicting. It is for memory that cannot be done without, like
+// the buffer for a request.
+func (a *MemoryAccount) Charge(n int64) {
+	a.charge(n, true)
+}
+
+// Release returns n bytes charged earlier.
+func (a *MemoryAccount) Release(n int64) {
+	l := a.limit
+	l.mu.Lock()
+	l.used -= n
+	a.used -= n
+	l.mu.Unlock()
+}
+
+// Used returns the bytes charged to the account.
+func (a *MemoryAccount) Used() int64 {
+	a.limit.mu.Lock()
+	defer a.limit.mu.Unlock()
+	return a.used
+}
+
+// Close releases what is left on the account, and removes it.
+func (a *MemoryAccount) Close() {
+	l := a.limit
+	l.mu.Lock()
+	l.used -= a.used
+	a.used = 0
+	delete(l.accounts, a)
+	l.mu.Unlock()
+}
+
+// accountedBufferPool charges the buffers handed out by a BufferPool
+// to a MemoryAccount.
+type accountedBufferPool struct {
+	BufferPool
+	account *MemoryAccount
+}
+
+func (p *accountedBufferPool) AllocBuffer(size uint32) []byte {
+	b := p.BufferPool.AllocBuffer(size)
+	p.account.Charge(int64(cap(b)))
+	return b
+}
+
+func (p *accountedBufferPool) FreeBuffer(slice []byte) {
+	if slice == nil {
+		return
+	}
+	p.account.Release(int64(cap(slice)))
+	p.BufferPool.FreeBuffer(slice)
+}
+
+func (p *accountedBufferPool) String() string {
+	return fmt.Sprintf("%v, %v", p.BufferPool, p.account.limit)
+}
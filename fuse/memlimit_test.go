@@ -0,0 +1,95 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"testing"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	l := NewMemoryLimit(100)
+
+	var big, small *MemoryAccount
+	var evicted []string
+	big = l.Register("big", func(want int64) int64 {
+		evicted = append(evicted, "big")
+		n := min(want, big.Used())
+		big.Release(n)
+		return n
+	})
+	small = l.Register("small", func(want int64) int64 {
+		evicted = append(evicted, "small")
+		n := small.Used()
+		small.Release(n)
+		return n
+	})
+	pinned := l.Register("pinned", nil)
+
+	big.Charge(50)
+	small.Charge(20)
+	pinned.Charge(20)
+
+	// Fits without evicting.
+	if !pinned.TryCharge(10) {
+		t.Fatal("TryCharge(10) refused")
+	}
+	if len(evicted) != 0 {
+		t.Errorf("evicted %v", evicted)
+	}
+
+	// Takes 30 from the largest account only.
+	if !pinned.TryCharge(30) {
+		t.Fatal("TryCharge(30) refused")
+	}
+	if len(evicted) != 1 || evicted[0] != "big" {
+		t.Errorf("evicted %v, want [big]", evicted)
+	}
+	s := l.Stats()
+	if s.Used != 100 || s.Accounts["big"] != 20 || s.Evicted != 30 {
+		t.Errorf("got %+v", s)
+	}
+
+	// Does not fit even after evicting everything else.
+	evicted = nil
+	if pinned.TryCharge(50) {
+		t.Fatal("TryCharge(50) accepted")
+	}
+	if len(evicted) != 2 {
+		t.Errorf("evicted %v, want both", evicted)
+	}
+	s = l.Stats()
+	if s.Used != 60 || s.Refused != 1 {
+		t.Errorf("got %+v", s)
+	}
+
+	// Charge goes beyond the ceiling.
+	pinned.Charge(50)
+	if s := l.Stats(); s.Used != 110 {
+		t.Errorf("got %+v", s)
+	}
+	pinned.Close()
+	if s := l.Stats(); s.Used != 0 || len(s.Accounts) != 2 {
+		t.Errorf("after Close: %+v", s)
+	}
+}
+
+func TestMemoryLimitBuffers(t *testing.T) {
+	l := NewMemoryLimit(1 << 20)
+	ms, err := newServer(NewDefaultRawFileSystem(), &MountOptions{
+		Memory:  l,
+		Buffers: NewBufferPool(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := ms.opts.Buffers.AllocBuffer(100)
+	if got := l.Stats().Accounts["buffers"]; got != int64(cap(b)) {
+		t.Errorf("charged %d, want %d", got, cap(b))
+	}
+	ms.opts.Buffers.FreeBuffer(b)
+	if got := l.Stats().Used; got != 0 {
+		t.Errorf("after free: %d", got)
+	}
+}
@@ -26,6 +26,7 @@ func (code Status) String() string {
 			"NOTIFY_INVAL_STORE",
 			"NOTIFY_INVAL_RETRIEVE",
 			"NOTIFY_INVAL_DELETE",
+			"DEFERRED",
 		}[-code]
 	}
 	return fmt.Sprintf("%d=%v", int(code), syscall.Errno(code))
@@ -52,6 +53,8 @@ func ToStatus(err error) Status {
 	}
 
 	switch t := err.(type) {
+	case *Error:
+		return t.Status
 	case syscall.Errno:
 		return Status(t)
 	case *os.SyscallError:
@@ -81,20 +84,23 @@ func CurrentOwner() *Owner {
 	}
 }
 
-const _UTIME_OMIT = ((1 << 30) - 2)
+// Special values for the nanoseconds of a timestamp passed to
+// utimensat(2), which set the time to the current time, or leave it
+// unchanged.
+const (
+	UTIME_NOW  = ((1 << 30) - 1)
+	UTIME_OMIT = ((1 << 30) - 2)
+)
 
 // UtimeToTimespec converts a "Time" pointer as passed to Utimens to a
 // "Timespec" that can be passed to the utimensat syscall.
 // A nil pointer is converted to the special UTIME_OMIT value.
 func UtimeToTimespec(t *time.Time) (ts syscall.Timespec) {
 	if t == nil {
-		ts.Nsec = _UTIME_OMIT
+		ts.Nsec = UTIME_OMIT
 	} else {
-		ts = syscall.NsecToTimespec(t.UnixNano())
-		// Go bug https://github.com/golang/go/issues/12777
-		if ts.Nsec < 0 {
-			ts.Nsec = 0
-		}
+		// Not t.UnixNano(), which overflows outside 1678-2262.
+		setTimespec(&ts, uint64(t.Unix()), uint32(t.Nanosecond()))
 	}
 	return ts
 }
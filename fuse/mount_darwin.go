@@ -95,3 +95,13 @@ func mount(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, e
 func unmount(dir string) error {
 	return syscall.Unmount(dir, 0)
 }
+
+// unmountLazy detaches the mount at dir, even if it is busy or its
+// connection is gone.
+func unmountLazy(dir string) error {
+	return syscall.Unmount(dir, mntForce)
+}
+
+// mntForce is MNT_FORCE from <sys/mount.h>, which package syscall
+// lacks.
+const mntForce = 0x80000
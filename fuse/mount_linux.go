@@ -95,6 +95,22 @@ func unmount(mountPoint string) (err error) {
 	return err
 }
 
+// unmountLazy detaches the mount at mountPoint, even if it is busy
+// or its connection is gone.
+func unmountLazy(mountPoint string) error {
+	bin, err := fusermountBinary()
+	if err != nil {
+		return err
+	}
+	errBuf := bytes.Buffer{}
+	cmd := exec.Command(bin, "-u", "-z", mountPoint)
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s (code %v)", strings.TrimSpace(errBuf.String()), err)
+	}
+	return nil
+}
+
 func getConnection(local *os.File) (int, error) {
 	var data [4]byte
 	control := make([]byte, 4*256)
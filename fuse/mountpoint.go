@@ -0,0 +1,59 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Errors for unusable mount points. NewServer wraps them in an
+// *os.PathError.
+var (
+	ErrMountPointNotDir     = errors.New("mount point is not a directory")
+	ErrMountPointMounted    = errors.New("mount point is already a FUSE mount")
+	ErrMountPointDisconnect = errors.New("mount point is a FUSE mount whose server is gone; unmount it first")
+)
+
+// checkMountPoint returns the absolute path of mountPoint with
+// symlinks resolved, after checking that it is a directory that
+// does not have a FUSE file system mounted yet. If create is set, a
+// missing directory is created, along with its parents.
+func checkMountPoint(mountPoint string, create bool) (string, error) {
+	mountPoint, err := filepath.Abs(mountPoint)
+	if err != nil {
+		return "", err
+	}
+	if create {
+		if err := os.MkdirAll(mountPoint, 0755); err != nil {
+			return "", err
+		}
+	}
+
+	resolved, err := filepath.EvalSymlinks(mountPoint)
+	if err == nil {
+		mountPoint = resolved
+	}
+
+	fi, err := os.Stat(mountPoint)
+	if errors.Is(err, syscall.ENOTCONN) {
+		return "", &os.PathError{Op: "mount", Path: mountPoint, Err: ErrMountPointDisconnect}
+	}
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", &os.PathError{Op: "mount", Path: mountPoint, Err: ErrMountPointNotDir}
+	}
+
+	if mounted, err := isFuseMount(mountPoint); err != nil {
+		return "", err
+	} else if mounted {
+		return "", &os.PathError{Op: "mount", Path: mountPoint, Err: ErrMountPointMounted}
+	}
+	return mountPoint, nil
+}
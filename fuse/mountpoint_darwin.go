@@ -0,0 +1,35 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"strings"
+	"syscall"
+)
+
+// isFuseMount reports whether dir is the root of a mounted FUSE file
+// system.
+func isFuseMount(dir string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false, err
+	}
+	if cString(st.Mntonname[:]) != dir {
+		return false, nil
+	}
+	fsType := cString(st.Fstypename[:])
+	return strings.HasPrefix(fsType, "osxfuse") || strings.HasPrefix(fsType, "macfuse"), nil
+}
+
+func cString(b []int8) string {
+	var s []byte
+	for _, c := range b {
+		if c == 0 {
+			break
+		}
+		s = append(s, byte(c))
+	}
+	return string(s)
+}
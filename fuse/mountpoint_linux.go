@@ -0,0 +1,82 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// isFuseMount reports whether dir is the root of a mounted FUSE file
+// system, according to /proc/self/mountinfo. A directory inside a
+// FUSE file system is fine as a mount point.
+func isFuseMount(dir string) (bool, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if os.IsNotExist(err) {
+		// No procfs; let fusermount find out.
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if mountPoint, fsType, ok := parseMountInfo(scanner.Text()); ok &&
+			mountPoint == dir && isFuseType(fsType) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// isFuseType reports whether fsType, as listed in mountinfo, is a
+// FUSE mount. Block device based mounts (eg. ntfs-3g) are "fuseblk".
+func isFuseType(fsType string) bool {
+	return fsType == "fuse" || fsType == "fuseblk" ||
+		strings.HasPrefix(fsType, "fuse.") || strings.HasPrefix(fsType, "fuseblk.")
+}
+
+// parseMountInfo returns the mount point and file system type from a
+// line of /proc/self/mountinfo, eg.
+//
+//	36 35 98:0 / /mnt/dir rw,noatime master:1 - fuse.sshfs host:/ rw
+func parseMountInfo(line string) (mountPoint, fsType string, ok bool) {
+	fields := strings.Fields(line)
+	sep := -1
+	for i, f := range fields {
+		if f == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 5 || sep+1 >= len(fields) {
+		return "", "", false
+	}
+	return unescapeMountInfo(fields[4]), fields[sep+1], true
+}
+
+// unescapeMountInfo undoes the octal escapes (eg. \040 for space) of
+// paths in mountinfo.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			b = append(b, (s[i+1]-'0')<<6|(s[i+2]-'0')<<3|(s[i+3]-'0'))
+			i += 3
+			continue
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
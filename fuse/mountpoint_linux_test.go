@@ -0,0 +1,70 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckMountPoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCheckMountPoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, nil, 0644)
+	if _, err := checkMountPoint(file, false); !errors.Is(err, ErrMountPointNotDir) {
+		t.Errorf("file: got %v, want ErrMountPointNotDir", err)
+	}
+
+	missing := filepath.Join(dir, "a/b")
+	if _, err := checkMountPoint(missing, false); !os.IsNotExist(err) {
+		t.Errorf("missing: got %v, want ENOENT", err)
+	}
+	if got, err := checkMountPoint(missing, true); err != nil || got != missing {
+		t.Errorf("create: got %q, %v", got, err)
+	}
+
+	link := filepath.Join(dir, "link")
+	os.Symlink("a/b", link)
+	if got, err := checkMountPoint(link, false); err != nil || got != missing {
+		t.Errorf("symlink: got %q, %v, want %q", got, err, missing)
+	}
+}
+
+func TestParseMountInfo(t *testing.T) {
+	mnt, typ, ok := parseMountInfo(`36 35 0:42 / /mnt/my\040dir rw,nosuid shared:1 master:2 - fuse.sshfs host:/ rw,user_id=0`)
+	if !ok || mnt != "/mnt/my dir" || typ != "fuse.sshfs" {
+		t.Errorf("got %q, %q, %v", mnt, typ, ok)
+	}
+	if _, _, ok := parseMountInfo("garbage"); ok {
+		t.Errorf("parsed garbage")
+	}
+	if mounted, err := isFuseMount("/"); err != nil || mounted {
+		t.Errorf("isFuseMount(/): got %v, %v", mounted, err)
+	}
+}
+
+func TestIsFuseType(t *testing.T) {
+	for typ, want := range map[string]bool{
+		"fuse":         true,
+		"fuse.sshfs":   true,
+		"fuseblk":      true,
+		"fuseblk.ntfs": true,
+		"fusectl":      false,
+		"ext4":         false,
+	} {
+		if got := isFuseType(typ); got != want {
+			t.Errorf("isFuseType(%q): got %v, want %v", typ, got, want)
+		}
+	}
+}
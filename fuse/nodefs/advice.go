@@ -0,0 +1,58 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import "sync"
+
+// adviceThreshold is the number of reads in a row that must follow
+// a pattern before it is advised.
+const adviceThreshold = 4
+
+// adviceWindow is how many read sizes away from the end of the last
+// read a read may start and still count as sequential.
+const adviceWindow = 4
+
+// accessPattern tracks whether the reads on a handle are sequential.
+type accessPattern struct {
+	mu     sync.Mutex
+	next   int64
+	seq    int
+	random int
+	advice Advice
+}
+
+// read records a read of size bytes at off. It returns the advice for
+// the file, and whether it changed.
+func (p *accessPattern) read(off int64, size int) (Advice, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Read ahead is sent as concurrent reads, which may arrive a
+	// little out of order.
+	window := int64(adviceWindow * size)
+	if d := off - p.next; d >= -window && d <= window {
+		p.seq++
+		p.random = 0
+	} else {
+		p.random++
+		p.seq = 0
+	}
+	if end := off + int64(size); end > p.next || p.seq == 0 {
+		p.next = end
+	}
+
+	advice := p.advice
+	switch {
+	case p.seq >= adviceThreshold:
+		advice = AdviceSequential
+	case p.random >= adviceThreshold:
+		advice = AdviceRandom
+	}
+	if advice == p.advice {
+		return advice, false
+	}
+	p.advice = advice
+	return advice, true
+}
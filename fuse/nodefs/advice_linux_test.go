@@ -0,0 +1,110 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+type adviceFile struct {
+	File
+	mu     sync.Mutex
+	advice []Advice
+}
+
+func (f *adviceFile) Advise(off, length int64, advice Advice) fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advice = append(f.advice, advice)
+	return fuse.OK
+}
+
+func (f *adviceFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	return fuse.ReadResultData(nil), fuse.OK
+}
+
+type adviceNode struct {
+	Node
+	file *adviceFile
+}
+
+func (n *adviceNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return n.file, fuse.OK
+}
+
+func TestReadAdvice(t *testing.T) {
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	file := &adviceFile{File: NewDefaultFile()}
+	root.Inode().NewChild("file", false, &adviceNode{NewDefaultNode(), file})
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	open, code := k.Open(out.NodeId, 0)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+
+	read := func(offs ...uint64) {
+		for _, off := range offs {
+			if _, code := k.Read(out.NodeId, open.Fh, off, 4096); !code.Ok() {
+				t.Fatalf("Read(%d): %v", off, code)
+			}
+		}
+	}
+
+	// A scan, with read ahead arriving slightly out of order.
+	read(0, 8192, 4096, 12288, 16384, 20480)
+	want := []Advice{AdviceSequential}
+	if !reflect.DeepEqual(file.advice, want) {
+		t.Errorf("after scan: got %v, want %v", file.advice, want)
+	}
+
+	read(1<<20, 40960, 1<<30, 123<<12, 7<<20)
+	want = append(want, AdviceRandom)
+	if !reflect.DeepEqual(file.advice, want) {
+		t.Errorf("after seeks: got %v, want %v", file.advice, want)
+	}
+	k.Release(out.NodeId, open.Fh)
+}
+
+func TestLoopbackFileAdvise(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	osf, err := os.Create(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewLoopbackFile(osf)
+	defer f.Release()
+
+	a, ok := f.(Adviser)
+	if !ok {
+		t.Fatalf("loopbackFile does not implement Adviser")
+	}
+	for _, advice := range []Advice{AdviceSequential, AdviceRandom, AdviceNormal} {
+		if code := a.Advise(0, 0, advice); !code.Ok() {
+			t.Errorf("Advise(%d): %v", advice, code)
+		}
+	}
+	if code := a.Advise(0, 0, Advice(100)); code != fuse.EINVAL {
+		t.Errorf("Advise(100): got %v, want EINVAL", code)
+	}
+}
@@ -85,13 +85,19 @@ type Node interface {
 	// 'existing'.
 	Link(name string, existing Node, context *fuse.Context) (newNode *Inode, code fuse.Status)
 
-	// Create should return an open file, and the Inode for that file.
+	// Create should return an open file, and the Inode for that
+	// file. flags is the open(2) flags word, and has O_EXCL if the
+	// caller asked for exclusive creation; see fuse.OpenFlags.
 	Create(name string, flags uint32, mode uint32, context *fuse.Context) (file File, child *Inode, code fuse.Status)
 
 	// Open opens a file, and returns a File which is associated
 	// with a file handle. It is OK to return (nil, OK) here. In
 	// that case, the Node should implement Read or Write
-	// directly.
+	// directly. Returning fuse.NO_OPEN additionally asks the kernel
+	// to stop sending opens for this mount, if
+	// fuse.MountOptions.EnableNoOpen is set. flags is the open(2)
+	// flags word, with O_APPEND, O_NOATIME, O_SYNC and so on; see
+	// fuse.OpenFlags.
 	Open(flags uint32, context *fuse.Context) (file File, code fuse.Status)
 	OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status)
 	Read(file File, dest []byte, off int64, context *fuse.Context) (fuse.ReadResult, fuse.Status)
@@ -105,7 +111,9 @@ type Node interface {
 
 	// File locking
 	//
-	// GetLk returns existing lock information for file.
+	// GetLk returns existing lock information for file. If the
+	// lock calls return ENOSYS, the locks are kept in a table
+	// per Inode, and released on close.
 	GetLk(file File, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock, context *fuse.Context) (code fuse.Status)
 
 	// Sets or clears the lock described by lk on file.
@@ -122,6 +130,11 @@ type Node interface {
 	Utimens(file File, atime *time.Time, mtime *time.Time, context *fuse.Context) (code fuse.Status)
 	Fallocate(file File, off uint64, size uint64, mode uint32, context *fuse.Context) (code fuse.Status)
 
+	// Bmap returns the device block holding the given file
+	// block, both in units of blocksize. Only used on fuseblk
+	// mounts.
+	Bmap(block uint64, blocksize uint32, context *fuse.Context) (deviceBlock uint64, code fuse.Status)
+
 	StatFs() *fuse.StatfsOut
 }
 
@@ -139,6 +152,9 @@ type File interface {
 	// the inner file here.
 	InnerFile() File
 
+	// Read reads len(dest) bytes at off, which is what the kernel
+	// asked for. dest comes from the server's buffer pool and is
+	// reused; fill it in place, eg. with fuse.ReadAt.
 	Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status)
 	Write(data []byte, off int64) (written uint32, code fuse.Status)
 
@@ -157,6 +173,10 @@ type File interface {
 	// the call. Any cleanup that requires specific synchronization or
 	// could fail with I/O errors should happen in Flush instead.
 	Release()
+
+	// Fsync flushes the file to stable storage. If flags has
+	// fuse.FSYNC_FDATASYNC, only the data and the metadata needed
+	// to read it must be flushed, like fdatasync(2).
 	Fsync(flags int) (code fuse.Status)
 
 	// The methods below may be called on closed files, due to
@@ -169,6 +189,114 @@ type File interface {
 	Allocate(off uint64, size uint64, mode uint32) (code fuse.Status)
 }
 
+// LockOwnerFlusher is implemented by Files that want to know which
+// process closes them. The kernel sends FLUSH on each close() of a
+// file descriptor, with the lock owner of the closing process; a File
+// that keeps POSIX locks itself should release those of the owner.
+// If a File implements it, FlushLockOwner is called instead of Flush.
+type LockOwnerFlusher interface {
+	FlushLockOwner(owner uint64) fuse.Status
+}
+
+// ReadInfo describes a READ request beyond its offset and size.
+type ReadInfo struct {
+	// OpenFlags are the flags the file handle was opened with.
+	OpenFlags fuse.OpenFlags
+
+	// Speculative is set for reads that nobody is waiting for
+	// yet, as far as can be told: the protocol does not say
+	// whether a page cache READ is readahead, so a read counts
+	// as speculative if it arrives while an earlier read on the
+	// same handle is still being served. A reader blocked on a
+	// page waits for one read at a time, while the kernel sends
+	// readahead windows as a batch of concurrent reads (with
+	// fuse.CAP_ASYNC_READ). Direct I/O reads are never
+	// speculative. A backend that is saturated can serve
+	// speculative reads last, or fail them with EAGAIN, which
+	// makes the kernel drop the readahead.
+	Speculative bool
+}
+
+// InfoReader is implemented by Files that want to know more about
+// the reads they serve. If a File implements it, ReadWithInfo is
+// called instead of the Node's Read and the File's Read.
+type InfoReader interface {
+	ReadWithInfo(dest []byte, off int64, info ReadInfo) (fuse.ReadResult, fuse.Status)
+}
+
+// Advice is a hint about how a file will be accessed, as for
+// posix_fadvise(2).
+type Advice int
+
+const (
+	AdviceNormal Advice = iota
+	AdviceSequential
+	AdviceRandom
+	AdviceWillNeed
+	AdviceDontNeed
+	AdviceNoReuse
+)
+
+// Adviser is implemented by Files that can use hints about the
+// coming reads, eg. to tune the read ahead of a backing file. FUSE
+// has no fadvise request, so the hints are derived from the READs on
+// each handle: a handle read in order gets AdviceSequential, and one
+// read all over the place gets AdviceRandom. Handles with direct I/O
+// get no hints. The range is the whole file, so off and length are
+// zero.
+type Adviser interface {
+	Advise(off, length int64, advice Advice) fuse.Status
+}
+
+// FlagReleaser is implemented by Files that want the flags of the
+// RELEASE request (fuse.RELEASE_FLUSH, fuse.RELEASE_FLOCK_UNLOCK). If
+// a File implements it, ReleaseFlags is called instead of Release.
+// When RELEASE_FLUSH is set, the File has already been flushed.
+type FlagReleaser interface {
+	ReleaseFlags(flags uint32)
+}
+
+// StatsReleaser is implemented by Files that want a summary of the
+// I/O on their handle, eg. to log transfers per file. ReleaseStats is
+// called just before Release or ReleaseFlags.
+type StatsReleaser interface {
+	ReleaseStats(stats IOStats)
+}
+
+// LastCloser is implemented by Nodes that want to know when the last
+// file handle opened on them is released, eg. to commit data or to
+// drop a handle on the backing store. Directory handles are not
+// counted. LastClose is called after the File's Release; a concurrent
+// Open may already have started a new round of handles by then.
+type LastCloser interface {
+	LastClose()
+}
+
+// DirStream lists a directory one entry at a time. See DirStreamer.
+type DirStream interface {
+	// HasNext reports whether there are more entries.
+	HasNext() bool
+
+	// Next returns the next entry. Its Off field is ignored.
+	Next() (fuse.DirEntry, fuse.Status)
+
+	// Close is called when the directory handle is released, or
+	// the directory is rewound.
+	Close()
+}
+
+// DirStreamer is implemented by Nodes of directories too large to
+// list in one go. Instead of calling OpenDir, the connector opens a
+// DirStream for each directory handle, and reads from it only as
+// many entries as fit in each READDIR reply; the next READDIR picks
+// up where the last one stopped. Seeking back, including rewinddir,
+// reopens the stream. Offsets count the entries of the stream, so
+// unlike with OpenDir they shift if the directory changes between
+// rewinds.
+type DirStreamer interface {
+	OpenDirStream(context *fuse.Context) (DirStream, fuse.Status)
+}
+
 // Wrap a File return in this to set FUSE flags.  Also used internally
 // to store open file data.
 type WithFlags struct {
@@ -197,6 +325,32 @@ type Options struct {
 	// uid/gid.
 	*fuse.Owner
 
+	// If set, replace the permission bits of all directories
+	// with DirMode, and of all other files except symlinks with
+	// FileMode. All of 07777 is replaced, so setuid, setgid and
+	// sticky bits that are not in the forced mode are cleared.
+	// Ownership is forced with Owner above.
+	DirMode  uint32
+	FileMode uint32
+
+	// DirMask and FileMask clear permission bits of directories
+	// and other files except symlinks, like the dmask and fmask
+	// options of vfat; set both for a umask. They apply after
+	// DirMode and FileMode, so eg. a FileMask of 07777 forces a
+	// mode of 0.
+	DirMask  uint32
+	FileMask uint32
+
+	// If set, override the attributes of the root directory,
+	// which some programs check before using a mountpoint.
+	// RootMode replaces the permission bits and RootOwner the
+	// owner, after DirMode and Owner above. RootAttr is called
+	// last, and may change anything, eg. the timestamps. For a
+	// submount, these apply to the directory it is mounted on.
+	RootMode  uint32
+	RootOwner *fuse.Owner
+	RootAttr  func(out *fuse.Attr)
+
 	// This option exists for compatibility and is ignored.
 	PortableInodes bool
 
@@ -207,4 +361,19 @@ type Options struct {
 	// children. This allows the filesystem to update its inode
 	// hierarchy in response to kernel calls.
 	LookupKnownChildren bool
+
+	// If set, keep nodes that the kernel forgot for this long
+	// before dropping them and calling OnForget. A lookup in the
+	// meantime finds the node as it was. This avoids rebuilding
+	// expensive node state when the kernel evicts and looks up
+	// the same entries over and over, eg. under memory pressure.
+	// Directories that were kept for their children get a grace
+	// period of their own once the last child is dropped. The
+	// time is that of fuse.MountOptions.Clock.
+	ForgetGrace time.Duration
+
+	// If set, answer STATFS from the previous reply for this long.
+	// Some desktop environments poll statfs continuously; leave
+	// it zero if the free space of the backend changes quickly.
+	StatfsTimeout time.Duration
 }
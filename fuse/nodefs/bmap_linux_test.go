@@ -0,0 +1,51 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// extentNode stores its blocks contiguously, from start on.
+type extentNode struct {
+	Node
+	start uint64
+}
+
+func (n *extentNode) Bmap(block uint64, blocksize uint32, context *fuse.Context) (uint64, fuse.Status) {
+	if blocksize != 4096 {
+		return 0, fuse.EINVAL
+	}
+	return n.start + block, fuse.OK
+}
+
+func TestBmap(t *testing.T) {
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	root.Inode().NewChild("file", false, &extentNode{NewDefaultNode(), 100})
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if got, code := k.Bmap(out.NodeId, 3, 4096); !code.Ok() || got != 103 {
+		t.Errorf("Bmap: got %d, %v, want 103", got, code)
+	}
+	if _, code := k.Bmap(out.NodeId, 3, 512); code != fuse.EINVAL {
+		t.Errorf("Bmap with blocksize 512: got %v, want EINVAL", code)
+	}
+	if _, code := k.Bmap(fuse.FUSE_ROOT_ID, 0, 4096); code != fuse.ENOSYS {
+		t.Errorf("Bmap on default node: got %v, want ENOSYS", code)
+	}
+}
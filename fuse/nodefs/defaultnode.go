@@ -169,6 +169,10 @@ func (n *defaultNode) Fallocate(file File, off uint64, size uint64, mode uint32,
 	return fuse.ENOSYS
 }
 
+func (n *defaultNode) Bmap(block uint64, blocksize uint32, context *fuse.Context) (uint64, fuse.Status) {
+	return 0, fuse.ENOSYS
+}
+
 func (n *defaultNode) Read(file File, dest []byte, off int64, context *fuse.Context) (fuse.ReadResult, fuse.Status) {
 	if file != nil {
 		return file.Read(dest, off)
@@ -6,100 +6,158 @@ package nodefs
 
 import (
 	"log"
+	"sort"
 	"sync"
 
 	"github.com/hanwen/go-fuse/fuse"
 )
 
+// connectorDir is an open directory. Its offsets are cookies handed
+// out per name: an entry keeps its offset when the directory is read
+// again, and new entries get higher offsets than all earlier ones.
+// An offset from telldir(3) therefore stays valid while other
+// entries are created and removed, and reading on from it neither
+// skips nor repeats the entries that were there all along.
 type connectorDir struct {
-	node  Node
+	inode *Inode
 	rawFS fuse.RawFileSystem
 
-	// Protect stream and lastOffset.  These are written in case
-	// there is a seek on the directory.
-	mu     sync.Mutex
+	// Protect stream, cookies and lastOffset.  These are written
+	// in case there is a seek on the directory.
+	mu sync.Mutex
+
+	// stream is sorted by Off.
 	stream []fuse.DirEntry
 
+	// cookies has the offset given out for each name seen, and
+	// lastCookie the highest one.
+	cookies    map[string]uint64
+	lastCookie uint64
+
 	// lastOffset stores the last offset for a readdir. This lets
 	// readdir pick up changes to the directory made after opening
 	// it.
 	lastOffset uint64
+
+	// streamed is set instead of stream if the Node implements
+	// DirStreamer.
+	streamed *dirStream
 }
 
-func (d *connectorDir) ReadDir(input *fuse.ReadIn, out *fuse.DirEntryList) (code fuse.Status) {
+// open lists the directory, or opens its DirStream.
+func (d *connectorDir) open(context *fuse.Context) fuse.Status {
+	if s, ok := d.inode.Node().(DirStreamer); ok {
+		d.streamed = &dirStream{inode: d.inode, node: s}
+		return d.streamed.open(context)
+	}
+	return d.load(context)
+}
+
+// release closes the DirStream, if any.
+func (d *connectorDir) release() {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	if d.streamed != nil {
+		d.streamed.close()
+	}
+}
+
+// load reads the directory listing, and assigns offsets. The caller
+// holds d.mu, unless d is new.
+func (d *connectorDir) load(context *fuse.Context) fuse.Status {
+	stream, code := d.inode.Node().OpenDir(context)
+	if !code.Ok() {
+		return code
+	}
+	stream = append(stream, d.inode.getMountDirEntries()...)
+	stream = append(stream,
+		fuse.DirEntry{Mode: fuse.S_IFDIR, Name: "."},
+		fuse.DirEntry{Mode: fuse.S_IFDIR, Name: ".."})
 
-	if d.stream == nil {
-		return fuse.OK
+	if d.cookies == nil {
+		d.cookies = make(map[string]uint64, len(stream))
 	}
+	for i := range stream {
+		e := &stream[i]
+		c, ok := d.cookies[e.Name]
+		if !ok {
+			d.lastCookie++
+			c = d.lastCookie
+			d.cookies[e.Name] = c
+		}
+		e.Off = c
+	}
+	sort.SliceStable(stream, func(i, j int) bool { return stream[i].Off < stream[j].Off })
+	d.stream = stream
+	return fuse.OK
+}
+
+// seek returns the entries after offset off, re-reading the directory
+// first if it is rewound.
+func (d *connectorDir) seek(input *fuse.ReadIn) ([]fuse.DirEntry, fuse.Status) {
 	// rewinddir() should be as if reopening directory.
-	// TODO - test this.
 	if d.lastOffset > 0 && input.Offset == 0 {
-		d.stream, code = d.node.OpenDir((*fuse.Context)(&input.Context))
-		if !code.Ok() {
-			return code
+		if code := d.load((*fuse.Context)(&input.Context)); !code.Ok() {
+			return nil, code
 		}
 	}
+	i := sort.Search(len(d.stream), func(i int) bool { return d.stream[i].Off > input.Offset })
+	return d.stream[i:], fuse.OK
+}
 
-	if input.Offset > uint64(len(d.stream)) {
-		// This shouldn't happen, but let's not crash.
-		return fuse.EINVAL
+// each calls fn for the entries after input.Offset, until fn reports
+// that the reply is full. The caller holds d.mu.
+func (d *connectorDir) each(input *fuse.ReadIn, fn func(fuse.DirEntry) bool) fuse.Status {
+	if d.streamed != nil {
+		return d.streamed.each(input, fn)
+	}
+	todo, code := d.seek(input)
+	if !code.Ok() {
+		return code
 	}
-
-	todo := d.stream[input.Offset:]
 	for _, e := range todo {
 		if e.Name == "" {
-			log.Printf("got empty directory entry, mode %o.", e.Mode)
+			logEmptyName(e)
 			continue
 		}
-		ok, off := out.AddDirEntry(e)
-		d.lastOffset = off
-		if !ok {
+		if !fn(e) {
 			break
 		}
 	}
 	return fuse.OK
 }
 
-func (d *connectorDir) ReadDirPlus(input *fuse.ReadIn, out *fuse.DirEntryList) (code fuse.Status) {
+func logEmptyName(e fuse.DirEntry) {
+	log.Printf("got empty directory entry, mode %o.", e.Mode)
+}
+
+func (d *connectorDir) ReadDir(input *fuse.ReadIn, out *fuse.DirEntryList) (code fuse.Status) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if d.stream == nil {
-		return fuse.OK
-	}
-
-	// rewinddir() should be as if reopening directory.
-	if d.lastOffset > 0 && input.Offset == 0 {
-		d.stream, code = d.node.OpenDir((*fuse.Context)(&input.Context))
-		if !code.Ok() {
-			return code
-		}
-	}
+	return d.each(input, func(e fuse.DirEntry) bool {
+		ok, off := out.AddDirEntry(e)
+		d.lastOffset = off
+		return ok
+	})
+}
 
-	if input.Offset > uint64(len(d.stream)) {
-		// This shouldn't happen, but let's not crash.
-		return fuse.EINVAL
-	}
-	todo := d.stream[input.Offset:]
-	for _, e := range todo {
-		if e.Name == "" {
-			log.Printf("got empty directory entry, mode %o.", e.Mode)
-			continue
-		}
+func (d *connectorDir) ReadDirPlus(input *fuse.ReadIn, out *fuse.DirEntryList) (code fuse.Status) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
+	return d.each(input, func(e fuse.DirEntry) bool {
 		// we have to be sure entry will fit if we try to add
 		// it, or we'll mess up the lookup counts.
 		entryDest, off := out.AddDirLookupEntry(e)
 		if entryDest == nil {
-			break
+			return false
 		}
 		entryDest.Ino = uint64(fuse.FUSE_UNKNOWN_INO)
 
 		// No need to fill attributes for . and ..
 		if e.Name == "." || e.Name == ".." {
-			continue
+			return true
 		}
 
 		// Clear entryDest before use it, some fields can be corrupted if does not set all fields in rawFS.Lookup
@@ -107,9 +165,8 @@ func (d *connectorDir) ReadDirPlus(input *fuse.ReadIn, out *fuse.DirEntryList) (
 
 		d.rawFS.Lookup(&input.InHeader, e.Name, entryDest)
 		d.lastOffset = off
-	}
-	return fuse.OK
-
+		return true
+	})
 }
 
 type rawDir interface {
@@ -0,0 +1,127 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// directAlign is the alignment of offsets, sizes and buffers for
+// files opened with O_DIRECT. Devices may get by with 512, but 4096
+// works for all of them.
+const directAlign = 4096
+
+// openFlags says whether fd was opened with O_DIRECT and O_APPEND.
+func openFlags(fd uintptr) (direct, appending bool) {
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+	if errno != 0 {
+		return false, false
+	}
+	return fuse.OpenFlags(flags).Direct(), flags&syscall.O_APPEND != 0
+}
+
+// alignedBuffers holds buffers for O_DIRECT I/O, whose memory starts
+// on a directAlign boundary.
+var alignedBuffers sync.Pool
+
+// getAligned returns an aligned buffer of size bytes, which must be a
+// multiple of directAlign.
+func getAligned(size int) []byte {
+	if p, ok := alignedBuffers.Get().(*[]byte); ok && cap(*p) >= size {
+		return (*p)[:size]
+	}
+	b := make([]byte, size+directAlign)
+	skip := int(-uintptr(unsafe.Pointer(&b[0])) & (directAlign - 1))
+	return b[skip : skip+size : skip+size]
+}
+
+func putAligned(b []byte) {
+	alignedBuffers.Put(&b)
+}
+
+func alignDown(n int64) int64 {
+	return n &^ (directAlign - 1)
+}
+
+func alignUp(n int64) int64 {
+	return alignDown(n + directAlign - 1)
+}
+
+// directRead reads len(buf) bytes at off from an O_DIRECT fd, through
+// an aligned buffer covering whole blocks.
+func directRead(fd int, buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	start := alignDown(off)
+	end := alignUp(off + int64(len(buf)))
+	tmp := getAligned(int(end - start))
+	defer putAligned(tmp)
+
+	n, err := syscall.Pread(fd, tmp, start)
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	skip := int(off - start)
+	if n <= skip {
+		return fuse.ReadResultData(nil), fuse.OK
+	}
+	m := copy(buf, tmp[skip:n])
+	return fuse.ReadResultData(buf[:m]), fuse.OK
+}
+
+// directWrite writes data at off to an O_DIRECT fd. Partial blocks
+// are read first, and the file is cut back if the write of the last
+// block went beyond the old end of file. Appends ignore off, so they
+// are only copied to an aligned buffer.
+func directWrite(fd int, data []byte, off int64, appending bool) (uint32, fuse.Status) {
+	if appending {
+		tmp := getAligned(int(alignUp(int64(len(data)))))
+		defer putAligned(tmp)
+		n, err := syscall.Pwrite(fd, tmp[:copy(tmp, data)], off)
+		if err != nil {
+			return 0, fuse.ToStatus(err)
+		}
+		return uint32(n), fuse.OK
+	}
+
+	start := alignDown(off)
+	end := alignUp(off + int64(len(data)))
+	tmp := getAligned(int(end - start))
+	defer putAligned(tmp)
+
+	var size int64 = -1
+	if start != off || end != off+int64(len(data)) {
+		var st syscall.Stat_t
+		if err := syscall.Fstat(fd, &st); err != nil {
+			return 0, fuse.ToStatus(err)
+		}
+		size = st.Size
+		// Read the whole range; the kernel fills what lies
+		// beyond the end of the file with nothing, so clear it.
+		n, err := syscall.Pread(fd, tmp, start)
+		if err != nil {
+			return 0, fuse.ToStatus(err)
+		}
+		for i := range tmp[n:] {
+			tmp[n+i] = 0
+		}
+	}
+	copy(tmp[off-start:], data)
+
+	if _, err := syscall.Pwrite(fd, tmp, start); err != nil {
+		return 0, fuse.ToStatus(err)
+	}
+	if newSize := off + int64(len(data)); size >= 0 && end > size && newSize < end {
+		if newSize < size {
+			newSize = size
+		}
+		if err := syscall.Ftruncate(fd, newSize); err != nil {
+			return 0, fuse.ToStatus(err)
+		}
+	}
+	return uint32(len(data)), fuse.OK
+}
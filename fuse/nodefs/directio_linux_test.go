@@ -0,0 +1,71 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestLoopbackFileDirect(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "file")
+
+	want := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := os.WriteFile(name, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+	osf, err := os.OpenFile(name, os.O_RDWR|syscall.O_DIRECT, 0)
+	if err != nil {
+		t.Skipf("O_DIRECT not supported here: %v", err)
+	}
+	f := NewLoopbackFile(osf)
+	defer f.Release()
+	if !f.(*loopbackFile).direct {
+		t.Fatalf("O_DIRECT not detected")
+	}
+
+	// Unaligned offset, size and buffer.
+	buf := make([]byte, 1001)
+	res, code := f.Read(buf[1:], 4090)
+	if !code.Ok() {
+		t.Fatalf("Read: %v", code)
+	}
+	got, _ := res.Bytes(buf[1:])
+	if !bytes.Equal(got, want[4090:5090]) {
+		t.Errorf("Read: got %q, want %q", got, want[4090:5090])
+	}
+
+	// Reads past the end are short.
+	res, code = f.Read(buf, 9500)
+	if got, _ := res.Bytes(buf); !code.Ok() || len(got) != 500 {
+		t.Errorf("Read at end: got %d bytes, %v, want 500", len(got), code)
+	}
+
+	// Unaligned writes keep the data around them.
+	if n, code := f.Write([]byte("hello"), 4094); !code.Ok() || n != 5 {
+		t.Fatalf("Write: got %d, %v", n, code)
+	}
+	copy(want[4094:], "hello")
+	// Extending writes do not leave a partial block of zeros.
+	if _, code := f.Write([]byte("tail"), 10000); !code.Ok() {
+		t.Fatalf("Write at end: %v", code)
+	}
+	want = append(want, "tail"...)
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, want) {
+		t.Errorf("content differs: got %d bytes, want %d", len(content), len(want))
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// dirStream is the state of a connectorDir whose Node implements
+// DirStreamer. It is guarded by connectorDir.mu.
+type dirStream struct {
+	inode  *Inode
+	node   DirStreamer
+	stream DirStream
+
+	// queue has the entries taken from the stream that were not
+	// delivered yet; the first one may have been refused by a full
+	// reply.
+	queue []fuse.DirEntry
+
+	// taken is the offset of the last entry put in the queue, and
+	// delivered that of the last one handed out.
+	taken, delivered uint64
+
+	// eof is set once the stream is exhausted, and the mount
+	// points, "." and ".." have been queued.
+	eof bool
+}
+
+// open starts listing the directory from the beginning.
+func (s *dirStream) open(context *fuse.Context) fuse.Status {
+	s.close()
+	stream, code := s.node.OpenDirStream(context)
+	if !code.Ok() {
+		return code
+	}
+	s.stream = stream
+	return fuse.OK
+}
+
+func (s *dirStream) close() {
+	if s.stream != nil {
+		s.stream.Close()
+		s.stream = nil
+	}
+	s.queue = nil
+	s.taken = 0
+	s.delivered = 0
+	s.eof = false
+}
+
+func (s *dirStream) push(e fuse.DirEntry) {
+	s.taken++
+	e.Off = s.taken
+	s.queue = append(s.queue, e)
+}
+
+// next returns the next entry to deliver, reading from the stream if
+// needed. It returns false at the end of the directory.
+func (s *dirStream) next() (fuse.DirEntry, bool, fuse.Status) {
+	if len(s.queue) == 0 && !s.eof {
+		if s.stream.HasNext() {
+			e, code := s.stream.Next()
+			if !code.Ok() {
+				return fuse.DirEntry{}, false, code
+			}
+			s.push(e)
+		} else {
+			s.eof = true
+			for _, e := range s.inode.getMountDirEntries() {
+				s.push(e)
+			}
+			s.push(fuse.DirEntry{Mode: fuse.S_IFDIR, Name: "."})
+			s.push(fuse.DirEntry{Mode: fuse.S_IFDIR, Name: ".."})
+		}
+	}
+	if len(s.queue) == 0 {
+		return fuse.DirEntry{}, false, fuse.OK
+	}
+	return s.queue[0], true, fuse.OK
+}
+
+// take marks the entry returned by next as delivered.
+func (s *dirStream) take() {
+	s.delivered = s.queue[0].Off
+	s.queue = s.queue[1:]
+}
+
+// seek positions the stream after offset off. Seeking back reopens
+// the stream; seeking forward skips entries.
+func (s *dirStream) seek(off uint64, context *fuse.Context) fuse.Status {
+	if s.stream == nil || off < s.delivered {
+		if code := s.open(context); !code.Ok() {
+			return code
+		}
+	}
+	for s.delivered < off {
+		_, ok, code := s.next()
+		if !code.Ok() {
+			return code
+		}
+		if !ok {
+			break
+		}
+		s.take()
+	}
+	return fuse.OK
+}
+
+// each calls fn for the entries after input.Offset, until fn reports
+// that the reply is full. The refused entry comes first in the next
+// call.
+func (s *dirStream) each(input *fuse.ReadIn, fn func(fuse.DirEntry) bool) fuse.Status {
+	if code := s.seek(input.Offset, (*fuse.Context)(&input.Context)); !code.Ok() {
+		return code
+	}
+	for {
+		e, ok, code := s.next()
+		if !code.Ok() {
+			// The entries added so far are not sent, so
+			// the kernel asks for them again, from an
+			// earlier offset, which reopens the stream.
+			return code
+		}
+		if !ok {
+			return fuse.OK
+		}
+		if e.Name == "" {
+			logEmptyName(e)
+			s.take()
+			continue
+		}
+		if !fn(e) {
+			return fuse.OK
+		}
+		s.take()
+	}
+}
@@ -30,7 +30,7 @@ func newNodeReadNode(noOpen, dir bool, d []byte) *nodeReadNode {
 
 func (n *nodeReadNode) Open(flags uint32, context *fuse.Context) (file File, code fuse.Status) {
 	if n.noOpen {
-		return nil, fuse.ENOSYS
+		return nil, fuse.NO_OPEN
 	}
 	return nil, fuse.OK
 }
@@ -67,9 +67,13 @@ func TestNoOpen(t *testing.T) {
 	root := newNodeReadNode(true, true, nil)
 	root.noOpen = true
 
-	s, _, err := MountRoot(dir, root, &Options{Debug: testutil.VerboseTest()})
+	conn := NewFileSystemConnector(root, nil)
+	s, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		Debug:        testutil.VerboseTest(),
+		EnableNoOpen: true,
+	})
 	if err != nil {
-		t.Fatalf("MountRoot: %v", err)
+		t.Fatalf("NewServer: %v", err)
 	}
 	defer s.Unmount()
 	go s.Serve()
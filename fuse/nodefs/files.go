@@ -95,13 +95,28 @@ func (f *devNullFile) Truncate(size uint64) (code fuse.Status) {
 ////////////////
 
 // LoopbackFile delegates all operations back to an underlying os.File.
+// If f was opened with O_DIRECT, reads and writes go through aligned
+// buffers covering whole blocks, as the kernel requires, so callers
+// need not align their requests.
 func NewLoopbackFile(f *os.File) File {
-	return &loopbackFile{File: f}
+	if f == nil {
+		return &loopbackFile{File: f}
+	}
+	lf := &loopbackFile{File: f}
+	lf.direct, lf.appending = openFlags(f.Fd())
+	return lf
 }
 
 type loopbackFile struct {
 	File *os.File
 
+	// Set if File bypasses the page cache, and if it appends.
+	direct    bool
+	appending bool
+
+	// Coalesces concurrent writes; appends are not reordered.
+	writes writeBatcher
+
 	// os.File is not threadsafe. Although fd themselves are
 	// constant during the lifetime of an open file, the OS may
 	// reuse the fd number after it is closed. When open races
@@ -123,6 +138,10 @@ func (f *loopbackFile) String() string {
 
 func (f *loopbackFile) Read(buf []byte, off int64) (res fuse.ReadResult, code fuse.Status) {
 	f.lock.Lock()
+	if f.direct {
+		defer f.lock.Unlock()
+		return directRead(int(f.File.Fd()), buf, off)
+	}
 	// This is not racy by virtue of the kernel properly
 	// synchronizing the open/write/close.
 	r := fuse.ReadResultFd(f.File.Fd(), off, len(buf))
@@ -131,10 +150,34 @@ func (f *loopbackFile) Read(buf []byte, off int64) (res fuse.ReadResult, code fu
 }
 
 func (f *loopbackFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	var n int
+	var err error
+	switch {
+	case f.direct:
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return directWrite(int(f.File.Fd()), data, off, f.appending)
+	case f.appending:
+		f.lock.Lock()
+		// Not File.WriteAt, which refuses files opened with O_APPEND.
+		n, err = syscall.Pwrite(int(f.File.Fd()), data, off)
+		f.lock.Unlock()
+	default:
+		n, err = f.writes.write(data, off, f.pwritev)
+	}
+	if err != nil {
+		return 0, fuse.ToStatus(err)
+	}
+	return uint32(n), fuse.OK
+}
+
+func (f *loopbackFile) pwritev(bufs [][]byte, off int64) (int, error) {
 	f.lock.Lock()
-	n, err := f.File.WriteAt(data, off)
-	f.lock.Unlock()
-	return uint32(n), fuse.ToStatus(err)
+	defer f.lock.Unlock()
+	if len(bufs) == 1 {
+		return syscall.Pwrite(int(f.File.Fd()), bufs[0], off)
+	}
+	return pwritev(int(f.File.Fd()), bufs, off)
 }
 
 func (f *loopbackFile) Release() {
@@ -161,7 +204,12 @@ func (f *loopbackFile) Flush() fuse.Status {
 
 func (f *loopbackFile) Fsync(flags int) (code fuse.Status) {
 	f.lock.Lock()
-	r := fuse.ToStatus(syscall.Fsync(int(f.File.Fd())))
+	var r fuse.Status
+	if flags&fuse.FSYNC_FDATASYNC != 0 {
+		r = fuse.ToStatus(fdatasync(int(f.File.Fd())))
+	} else {
+		r = fuse.ToStatus(syscall.Fsync(int(f.File.Fd())))
+	}
 	f.lock.Unlock()
 
 	return r
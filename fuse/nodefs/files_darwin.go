@@ -95,3 +95,27 @@ func (f *loopbackFile) Utimens(a *time.Time, m *time.Time) fuse.Status {
 	f.lock.Unlock()
 	return fuse.ToStatus(err)
 }
+
+// Advise is not supported: OSX has no posix_fadvise.
+func (f *loopbackFile) Advise(off, length int64, advice Advice) fuse.Status {
+	return fuse.ENOSYS
+}
+
+// pwritev writes bufs to fd at off. OSX has no pwritev before 11, so
+// this takes a system call per buffer.
+func pwritev(fd int, bufs [][]byte, off int64) (int, error) {
+	total := 0
+	for _, b := range bufs {
+		n, err := syscall.Pwrite(fd, b, off+int64(total))
+		total += n
+		if err != nil || n < len(b) {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// fdatasync falls back to fsync, as OSX has no fdatasync.
+func fdatasync(fd int) error {
+	return syscall.Fsync(fd)
+}
@@ -9,8 +9,30 @@ import (
 	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
 )
 
+var fadvice = map[Advice]int{
+	AdviceNormal:     unix.FADV_NORMAL,
+	AdviceSequential: unix.FADV_SEQUENTIAL,
+	AdviceRandom:     unix.FADV_RANDOM,
+	AdviceWillNeed:   unix.FADV_WILLNEED,
+	AdviceDontNeed:   unix.FADV_DONTNEED,
+	AdviceNoReuse:    unix.FADV_NOREUSE,
+}
+
+// Advise passes the hint to the backing file with posix_fadvise.
+func (f *loopbackFile) Advise(off, length int64, advice Advice) fuse.Status {
+	a, ok := fadvice[advice]
+	if !ok {
+		return fuse.EINVAL
+	}
+	f.lock.Lock()
+	err := unix.Fadvise(int(f.File.Fd()), off, length, a)
+	f.lock.Unlock()
+	return fuse.ToStatus(err)
+}
+
 func (f *loopbackFile) Allocate(off uint64, sz uint64, mode uint32) fuse.Status {
 	f.lock.Lock()
 	err := syscall.Fallocate(int(f.File.Fd()), mode, int64(off), int64(sz))
@@ -31,3 +53,7 @@ func (f *loopbackFile) Utimens(a *time.Time, m *time.Time) fuse.Status {
 	f.lock.Unlock()
 	return fuse.ToStatus(err)
 }
+
+func fdatasync(fd int) error {
+	return syscall.Fdatasync(fd)
+}
@@ -0,0 +1,236 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// buildingNode makes a child for each Lookup, as a file system with
+// expensive node state would.
+type buildingNode struct {
+	Node
+	built     int
+	forgotten atomic.Int32
+}
+
+func (n *buildingNode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*Inode, fuse.Status) {
+	if ch := n.Inode().GetChild(name); ch != nil {
+		return ch, fuse.OK
+	}
+	n.built++
+	return n.Inode().NewChild(name, false, &forgetNode{NewDefaultNode(), n}), fuse.OK
+}
+
+type forgetNode struct {
+	Node
+	parent *buildingNode
+}
+
+func (n *forgetNode) OnForget() {
+	n.parent.forgotten.Add(1)
+}
+
+func TestForgetGrace(t *testing.T) {
+	root := &buildingNode{Node: NewDefaultNode()}
+	opts := NewOptions()
+	opts.ForgetGrace = 50 * time.Millisecond
+	opts.LookupKnownChildren = true
+	conn := NewFileSystemConnector(root, opts)
+
+	// Deterministic processes requests in order, so a FORGET has
+	// been handled once the reply to the next request arrives.
+	k, err := fakekernel.New(conn.RawFS(), &fuse.MountOptions{Deterministic: true})
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	lookup := func() uint64 {
+		t.Helper()
+		out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+		if !code.Ok() {
+			t.Fatalf("Lookup: %v", code)
+		}
+		return out.NodeId
+	}
+
+	// Forgetting and looking up again within the grace period
+	// keeps the node.
+	id := lookup()
+	k.Forget(id, 1)
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+	if root.Inode().GetChild("file") == nil {
+		t.Fatalf("node dropped before the grace period")
+	}
+	id = lookup()
+	if root.built != 1 {
+		t.Errorf("built %d nodes, want 1", root.built)
+	}
+
+	// Past the grace period, it is dropped.
+	k.Forget(id, 1)
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+	deadline := time.Now().Add(time.Second)
+	for root.forgotten.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := root.forgotten.Load(); n != 1 {
+		t.Fatalf("OnForget called %d times, want 1", n)
+	}
+	if root.Inode().GetChild("file") != nil {
+		t.Errorf("node still in the tree")
+	}
+	lookup()
+	if root.built != 2 {
+		t.Errorf("built %d nodes, want 2", root.built)
+	}
+}
+
+// stepClock is a fuse.Timer whose time only moves in step.
+type stepClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []stepWaiter
+}
+
+type stepWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *stepClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := stepWaiter{c.now.Add(d), make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return w.ch
+}
+
+func (c *stepClock) step(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiting = append(waiting, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = waiting
+}
+
+// dirNode makes a directory for each Lookup.
+type dirNode struct {
+	Node
+	forgotten *atomic.Int32
+}
+
+func (n *dirNode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*Inode, fuse.Status) {
+	if ch := n.Inode().GetChild(name); ch != nil {
+		return ch, fuse.OK
+	}
+	return n.Inode().NewChild(name, true, &dirNode{NewDefaultNode(), n.forgotten}), fuse.OK
+}
+
+func (n *dirNode) OnForget() {
+	n.forgotten.Add(1)
+}
+
+func TestForgetGraceParents(t *testing.T) {
+	var forgotten atomic.Int32
+	root := &dirNode{NewDefaultNode(), &forgotten}
+	opts := NewOptions()
+	opts.ForgetGrace = time.Hour
+	conn := NewFileSystemConnector(root, opts)
+
+	clock := &stepClock{now: time.Unix(1e9, 0)}
+	k, err := fakekernel.New(conn.RawFS(), &fuse.MountOptions{Deterministic: true, Clock: clock})
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	dir, code := k.Lookup(fuse.FUSE_ROOT_ID, "dir")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	file, code := k.Lookup(dir.NodeId, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	// The directory is kept for its child, and goes once the
+	// child does.
+	k.Forget(dir.NodeId, 1)
+	k.Forget(file.NodeId, 1)
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for forgotten.Load() < 2 && time.Now().Before(deadline) {
+		clock.step(opts.ForgetGrace)
+		time.Sleep(time.Millisecond)
+	}
+	if n := forgotten.Load(); n != 2 {
+		t.Fatalf("OnForget called %d times, want 2", n)
+	}
+	if root.Inode().GetChild("dir") != nil {
+		t.Errorf("directory still in the tree")
+	}
+}
+
+func TestForgetGraceUnmount(t *testing.T) {
+	root := &buildingNode{Node: NewDefaultNode()}
+	opts := NewOptions()
+	opts.ForgetGrace = time.Hour
+	conn := NewFileSystemConnector(root, opts)
+
+	k, err := fakekernel.New(conn.RawFS(), &fuse.MountOptions{Deterministic: true})
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	k.Forget(out.NodeId, 1)
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+
+	m := conn.rootNode.mount
+	sweeping := func() bool {
+		m.forgetMu.Lock()
+		defer m.forgetMu.Unlock()
+		return m.sweeping
+	}
+	if !sweeping() {
+		t.Fatalf("no sweeper after FORGET")
+	}
+
+	// Forgetting the root is how the kernel unmounts.
+	k.Forget(fuse.FUSE_ROOT_ID, 1)
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for sweeping() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sweeping() {
+		t.Errorf("sweeper still running after unmount")
+	}
+}
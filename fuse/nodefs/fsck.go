@@ -0,0 +1,195 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Fsck checks the consistency of the inode tree of all mounts, for
+// debugging. It reports
+//
+//   - children whose parent links do not point back at the
+//     directory, and the other way around;
+//   - nodes in the wrong mount;
+//   - nodes in the NodeId table without lookups;
+//   - file handles that are not attached to a known node, or are
+//     open on a node that the kernel has forgotten;
+//   - files linked into more directories than their link count.
+//
+// The tree is locked while it is walked, but the Nodes are asked for
+// their attributes afterwards, so Fsck can run on a live mount,
+// though concurrent changes may then show up as problems. It also
+// runs when the server exits, if Options.Debug is set.
+func (c *FileSystemConnector) Fsck() error {
+	ck := fsck{
+		conn:  c,
+		seen:  map[*Inode]string{},
+		files: map[*openedFile]bool{},
+		ids:   map[*handled]uint64{},
+	}
+	for id, count := range c.inodeMap.LookupCounts() {
+		if int64(count) <= 0 {
+			ck.report("i%d: lookup count %d", id, int64(count))
+		}
+		if h := c.inodeMap.Decode(id); h != nil {
+			ck.ids[h] = id
+		}
+	}
+
+	root := c.rootNode
+	root.mountPoint.treeLock.RLock()
+	ck.walk(root, "")
+	root.mountPoint.treeLock.RUnlock()
+
+	// Nodes that are registered but not in the tree, eg. unlinked
+	// files, may still have handles open.
+	for h, id := range ck.ids {
+		n := (*Inode)(unsafe.Pointer(h))
+		if _, ok := ck.seen[n]; !ok {
+			ck.checkFiles(n, fmt.Sprintf("unlinked node i%d", id))
+		}
+	}
+	for _, o := range ck.orphans {
+		ck.report("%q: parent %q has no child %q for it", ck.seen[o.node], ck.seen[o.parent], o.name)
+	}
+	ck.checkHandles()
+	ck.checkLinks()
+
+	if len(ck.problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d problems: %s", len(ck.problems), strings.Join(ck.problems, "; "))
+}
+
+type fsck struct {
+	conn     *FileSystemConnector
+	problems []string
+
+	// The nodes of the tree, by path.
+	seen map[*Inode]string
+
+	// The open files attached to nodes.
+	files map[*openedFile]bool
+
+	// NodeIds of the registered nodes.
+	ids map[*handled]uint64
+
+	// Mounts seen, for their handle tables.
+	mounts []*fileSystemMount
+
+	// Parent links without a matching child. They are reported
+	// once all paths are known.
+	orphans []orphanLink
+}
+
+type orphanLink struct {
+	node *Inode
+	parentData
+}
+
+func (ck *fsck) report(format string, args ...interface{}) {
+	ck.problems = append(ck.problems, fmt.Sprintf(format, args...))
+}
+
+// walk checks n and the nodes below it. The caller holds the
+// treeLock of n's mount.
+func (ck *fsck) walk(n *Inode, path string) {
+	if _, ok := ck.seen[n]; ok {
+		return
+	}
+	ck.seen[n] = "/" + path
+	if n.mountPoint != nil {
+		ck.mounts = append(ck.mounts, n.mountPoint)
+		if n.mountPoint.mountInode != n {
+			ck.report("%q: mount point of another node", "/"+path)
+		}
+	}
+
+	ck.checkFiles(n, fmt.Sprintf("%q", "/"+path))
+
+	for pd := range n.parents {
+		if pd.parent.children[pd.name] != n {
+			ck.orphans = append(ck.orphans, orphanLink{n, pd})
+		}
+	}
+	for name, ch := range n.children {
+		chPath := filepath.Join(path, name)
+		if ch == nil {
+			ck.report("%q: nil child", "/"+chPath)
+			continue
+		}
+		if _, ok := ch.parents[parentData{n, name}]; !ok {
+			ck.report("%q: does not list %q as parent", "/"+chPath, "/"+path)
+		}
+		m := ch.mountPoint
+		if m == nil && ch.mount != n.mount {
+			ck.report("%q: in another mount than its parent", "/"+chPath)
+		}
+		if m != nil {
+			m.treeLock.RLock()
+		}
+		ck.walk(ch, chPath)
+		if m != nil {
+			m.treeLock.RUnlock()
+		}
+	}
+}
+
+// checkFiles records the open files of n, and reports them if the
+// kernel has forgotten n.
+func (ck *fsck) checkFiles(n *Inode, desc string) {
+	n.openFilesMutex.Lock()
+	defer n.openFilesMutex.Unlock()
+	for _, f := range n.openFiles {
+		ck.files[f] = true
+	}
+	if _, ok := ck.ids[&n.handled]; !ok && len(n.openFiles) > 0 && n != ck.conn.rootNode {
+		ck.report("%s: %d handles open on forgotten node", desc, len(n.openFiles))
+	}
+}
+
+// checkHandles reports handles that no node knows of.
+func (ck *fsck) checkHandles() {
+	for _, m := range ck.mounts {
+		for fh, obj := range m.openFiles.Handles() {
+			if f, ok := obj.(*openedFile); !ok || !ck.files[f] {
+				ck.report("fh 0x%x in mount %q: not attached to a node", fh, ck.seen[m.mountInode])
+			}
+		}
+	}
+}
+
+// checkLinks compares the number of directories that files are in
+// with their link count.
+func (ck *fsck) checkLinks() {
+	for n, path := range ck.seen {
+		if n.IsDir() {
+			continue
+		}
+		m := n.mount
+		if m == nil {
+			continue
+		}
+		m.treeLock.RLock()
+		links := len(n.parents)
+		m.treeLock.RUnlock()
+		if links < 2 {
+			continue
+		}
+		var attr fuse.Attr
+		if code := n.Node().GetAttr(&attr, nil, nil); !code.Ok() {
+			continue
+		}
+		if attr.Nlink > 0 && uint32(links) > attr.Nlink {
+			ck.report("%q: in %d directories, but has %d links", path, links, attr.Nlink)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+type linkNode struct {
+	Node
+	nlink uint32
+}
+
+func (n *linkNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Nlink = n.nlink
+	return fuse.OK
+}
+
+func (n *linkNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return NewDefaultFile(), fuse.OK
+}
+
+func TestFsck(t *testing.T) {
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	dir := root.Inode().NewChild("dir", true, NewDefaultNode())
+	file := &linkNode{NewDefaultNode(), 2}
+	root.Inode().NewChild("file", false, file)
+	dir.AddChild("link", file.Inode())
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	open, code := k.Open(out.NodeId, 0)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	defer k.Release(out.NodeId, open.Fh)
+
+	if err := conn.Fsck(); err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+
+	// Break the tree in a few ways.
+	file.nlink = 1
+	m := root.Inode().mountPoint
+	m.treeLock.Lock()
+	delete(dir.children, "link")
+	m.treeLock.Unlock()
+	fh := m.openFiles.Add(&openedFile{})
+	defer m.openFiles.Remove(fh)
+
+	err = conn.Fsck()
+	if err == nil {
+		t.Fatalf("Fsck found no problems")
+	}
+	for _, want := range []string{
+		`parent "/dir" has no child "link"`,
+		`in 2 directories, but has 1 links`,
+		`not attached to a node`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%v: missing %q", err, want)
+		}
+	}
+}
@@ -93,7 +93,7 @@ func (c *FileSystemConnector) verify() {
 // childLookup fills entry information for a newly created child inode
 func (c *rawBridge) childLookup(out *fuse.EntryOut, n *Inode, context *fuse.Context) {
 	n.Node().GetAttr((*fuse.Attr)(&out.Attr), nil, context)
-	n.mount.fillEntry(out)
+	n.mount.fillEntry(out, n)
 	out.NodeId, out.Generation = c.fsConn().lookupUpdate(n)
 	if out.Ino == 0 {
 		out.Ino = out.NodeId
@@ -106,16 +106,20 @@ func (c *rawBridge) childLookup(out *fuse.EntryOut, n *Inode, context *fuse.Cont
 }
 
 func (c *rawBridge) toInode(nodeid uint64) *Inode {
-	if nodeid == fuse.FUSE_ROOT_ID {
-		return c.rootNode
+	i := c.rootNode
+	if nodeid != fuse.FUSE_ROOT_ID {
+		i = (*Inode)(unsafe.Pointer(c.inodeMap.Decode(nodeid)))
+	}
+	if i != nil && i.mount != nil {
+		i.mount.touch()
 	}
-	i := (*Inode)(unsafe.Pointer(c.inodeMap.Decode(nodeid)))
 	return i
 }
 
 // Must run outside treeLock.  Returns the nodeId and generation.
 func (c *FileSystemConnector) lookupUpdate(node *Inode) (id, generation uint64) {
 	id, generation = c.inodeMap.Register(&node.handled)
+	node.kept.Store(false)
 	c.verify()
 	return
 }
@@ -125,6 +129,7 @@ func (c *FileSystemConnector) lookupUpdate(node *Inode) (id, generation uint64)
 func (c *FileSystemConnector) forgetUpdate(nodeID uint64, forgetCount int) {
 	if nodeID == fuse.FUSE_ROOT_ID {
 		c.rootNode.Node().OnUnmount()
+		c.rootNode.mount.stopForgets()
 
 		// We never got a lookup for root, so don't try to
 		// forget root.
@@ -138,31 +143,47 @@ func (c *FileSystemConnector) forgetUpdate(nodeID uint64, forgetCount int) {
 	defer node.mount.treeLock.Unlock()
 
 	if forgotten, _ := c.inodeMap.Forget(nodeID, forgetCount); forgotten {
-		if len(node.children) > 0 || !node.Node().Deletable() ||
-			node == c.rootNode || node.mountPoint != nil {
+		if !c.droppable(node) {
 			// We cannot forget a directory that still has children as these
 			// would become unreachable.
+			node.kept.Store(true)
 			return
 		}
-		// We have to remove ourself from all parents.
-		// Create a copy of node.parents so we can safely iterate over it
-		// while modifying the original.
-		parents := make(map[parentData]struct{}, len(node.parents))
-		for k, v := range node.parents {
-			parents[k] = v
-		}
-
-		for p := range parents {
-			// This also modifies node.parents
-			p.parent.rmChild(p.name)
+		if node.mount.options.ForgetGrace > 0 {
+			node.mount.queueForget(node)
+		} else {
+			c.dropNode(node)
 		}
-
-		node.fsInode.OnForget()
 	}
 	// TODO - try to drop children even forget was not successful.
 	c.verify()
 }
 
+// droppable says whether a node that the kernel forgot can be removed
+// from the tree. Must run with treeLock held.
+func (c *FileSystemConnector) droppable(node *Inode) bool {
+	return len(node.children) == 0 && node.Node().Deletable() &&
+		node != c.rootNode && node.mountPoint == nil && node.pins == 0
+}
+
+// dropNode removes node from the tree. Must run with treeLock held.
+func (c *FileSystemConnector) dropNode(node *Inode) {
+	// We have to remove ourself from all parents.
+	// Create a copy of node.parents so we can safely iterate over it
+	// while modifying the original.
+	parents := make(map[parentData]struct{}, len(node.parents))
+	for k, v := range node.parents {
+		parents[k] = v
+	}
+
+	for p := range parents {
+		// This also modifies node.parents
+		p.parent.rmChild(p.name)
+	}
+
+	node.fsInode.OnForget()
+}
+
 // InodeCount returns the number of inodes registered with the kernel.
 func (c *FileSystemConnector) InodeHandleCount() int {
 	return c.inodeMap.Count()
@@ -239,6 +260,22 @@ func (c *FileSystemConnector) mountRoot(opts *Options) {
 	c.verify()
 }
 
+// LastUsed returns when the kernel last sent a request for a node of
+// the file system mounted at node, or the zero time if there was
+// none, or node is not a mount point. Requests for nodes of file
+// systems mounted below it do not count.
+func (c *FileSystemConnector) LastUsed(node *Inode) time.Time {
+	m := node.mountPoint
+	if m == nil {
+		return time.Time{}
+	}
+	ns := m.lastUsed.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
 // Mount() generates a synthetic directory node, and mounts the file
 // system there.  If opts is nil, the mount options of the root file
 // system are inherited.  The encompassing filesystem should pretend
@@ -301,7 +338,10 @@ func (c *FileSystemConnector) Unmount(node *Inode) fuse.Status {
 
 	mount := node.mountPoint
 	name := node.mountPoint.mountName()
-	if mount.openFiles.Count() > 0 {
+	if err := mount.openFiles.Check(); err != nil {
+		if c.debug {
+			log.Printf("Unmount %q: %v", name, err)
+		}
 		return fuse.EBUSY
 	}
 
@@ -320,6 +360,7 @@ func (c *FileSystemConnector) Unmount(node *Inode) fuse.Status {
 
 	delete(parentNode.children, name)
 	node.Node().OnUnmount()
+	mount.stopForgets()
 
 	parentId := c.inodeMap.Handle(&parentNode.handled)
 	if parentNode == c.rootNode {
@@ -7,18 +7,34 @@ package nodefs
 import (
 	"log"
 	"sync"
-	"unsafe"
+	"sync/atomic"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 )
 
 // openedFile stores either an open dir or an open file.
 type openedFile struct {
-	handled
-
 	WithFlags
 
 	dir *connectorDir
+
+	// reads counts the READs being served.
+	reads atomic.Int32
+
+	// The access pattern, for Files that implement Adviser.
+	pattern accessPattern
+
+	// The I/O on the handle, for Files that implement StatsReleaser.
+	io ioCounters
+
+	// Who opened the handle, and when; see OpenHandles.
+	fh     uint64
+	caller fuse.Context
+	opened time.Time
+
+	// Set by Revoke.
+	revoked atomic.Bool
 }
 
 type fileSystemMount struct {
@@ -40,11 +56,157 @@ type fileSystemMount struct {
 	treeLock sync.RWMutex
 
 	// Manage filehandles of open files.
-	openFiles handleMap
+	openFiles *fuse.HandleTable
 
 	Debug bool
 
 	connector *FileSystemConnector
+
+	// lastUsed is the time of the last request for a node in this
+	// mount, in nanoseconds since the epoch.
+	lastUsed atomic.Int64
+
+	// The last STATFS reply, for Options.StatfsTimeout.
+	statfsMu      sync.Mutex
+	statfs        fuse.StatfsOut
+	statfsExpires time.Time
+
+	// Nodes the kernel forgot, in the order of their
+	// forgetDeadline, and whether a goroutine sweeps them.
+	// unmounted is closed once the mount goes away, to stop the
+	// sweeper.
+	forgetMu  sync.Mutex
+	forgotten []forgottenInode
+	sweeping  bool
+	unmounted chan struct{}
+}
+
+type forgottenInode struct {
+	node     *Inode
+	deadline time.Time
+}
+
+// cachedStatFs returns the file system statistics through node,
+// reusing the previous answer for Options.StatfsTimeout.
+func (m *fileSystemMount) cachedStatFs(node *Inode, out *fuse.StatfsOut) fuse.Status {
+	ttl := m.options.StatfsTimeout
+	if ttl <= 0 {
+		return statFs(node, out)
+	}
+
+	m.statfsMu.Lock()
+	defer m.statfsMu.Unlock()
+	now := time.Now()
+	if now.Before(m.statfsExpires) {
+		*out = m.statfs
+		return fuse.OK
+	}
+	code := statFs(node, out)
+	if code.Ok() {
+		m.statfs = *out
+		m.statfsExpires = now.Add(ttl)
+	}
+	return code
+}
+
+func statFs(node *Inode, out *fuse.StatfsOut) fuse.Status {
+	s := node.Node().StatFs()
+	if s == nil {
+		return fuse.ENOSYS
+	}
+	*out = *s
+	return fuse.OK
+}
+
+// queueForget schedules node to be dropped after ForgetGrace. Must run
+// with treeLock held.
+func (m *fileSystemMount) queueForget(node *Inode) {
+	node.forgetDeadline = m.now().Add(m.options.ForgetGrace)
+
+	m.forgetMu.Lock()
+	defer m.forgetMu.Unlock()
+	if m.isUnmounted() {
+		return
+	}
+	m.forgotten = append(m.forgotten, forgottenInode{node, node.forgetDeadline})
+	if !m.sweeping {
+		m.sweeping = true
+		go m.sweepForgotten()
+	}
+}
+
+// stopForgets drops the queue of forgotten nodes, and stops the
+// sweeper. Called when the mount goes away.
+func (m *fileSystemMount) stopForgets() {
+	m.forgetMu.Lock()
+	defer m.forgetMu.Unlock()
+	if !m.isUnmounted() {
+		close(m.unmounted)
+	}
+	m.forgotten = nil
+}
+
+// isUnmounted says whether stopForgets ran. Called with forgetMu held.
+func (m *fileSystemMount) isUnmounted() bool {
+	select {
+	case <-m.unmounted:
+		return true
+	default:
+		return false
+	}
+}
+
+// now returns the time according to the server's clock.
+func (m *fileSystemMount) now() time.Time {
+	return (*rawBridge)(m.connector).now()
+}
+
+// after waits for d on the server's clock.
+func (m *fileSystemMount) after(d time.Duration) <-chan time.Time {
+	return (*rawBridge)(m.connector).after(d)
+}
+
+// sweepForgotten drops queued nodes as their grace period ends, unless
+// the kernel looked them up again, along with the parents that were
+// only kept for them. It returns once the queue is empty, or the mount
+// goes away.
+func (m *fileSystemMount) sweepForgotten() {
+	c := m.connector
+	for {
+		m.forgetMu.Lock()
+		if len(m.forgotten) == 0 || m.isUnmounted() {
+			m.forgotten = nil
+			m.sweeping = false
+			m.forgetMu.Unlock()
+			return
+		}
+		f := m.forgotten[0]
+		if d := f.deadline.Sub(m.now()); d > 0 {
+			m.forgetMu.Unlock()
+			select {
+			case <-m.after(d):
+			case <-m.unmounted:
+			}
+			continue
+		}
+		m.forgotten[0] = forgottenInode{}
+		m.forgotten = m.forgotten[1:]
+		m.forgetMu.Unlock()
+
+		m.treeLock.Lock()
+		// A node that was looked up and forgotten again is
+		// queued again, with a later deadline.
+		if f.node.forgetDeadline.Equal(f.deadline) && c.inodeMap.Handle(&f.node.handled) == 0 && c.droppable(f.node) {
+			f.node.forgetDeadline = time.Time{}
+			c.dropWithParents(f.node)
+		}
+		m.treeLock.Unlock()
+	}
+}
+
+// touch records a request for a node in the mount.
+func (m *fileSystemMount) touch() {
+	m.lastUsed.Store(time.Now().UnixNano())
 }
 
 // Must called with lock for parent held.
@@ -61,20 +223,51 @@ func (m *fileSystemMount) setOwner(attr *fuse.Attr) {
 	if m.options.Owner != nil {
 		attr.Owner = *(*fuse.Owner)(m.options.Owner)
 	}
+	if attr.IsSymlink() {
+		return
+	}
+	perms, mask := m.options.FileMode, m.options.FileMask
+	if attr.IsDir() {
+		perms, mask = m.options.DirMode, m.options.DirMask
+	}
+	if perms != 0 {
+		attr.Mode = attr.Mode&^07777 | perms&07777
+	}
+	attr.Mode &^= mask & 07777
 }
 
-func (m *fileSystemMount) fillEntry(out *fuse.EntryOut) {
+// setRootAttr applies the Root* options to the attributes of the
+// root of the mount.
+func (m *fileSystemMount) setRootAttr(attr *fuse.Attr) {
+	if m.options.RootOwner != nil {
+		attr.Owner = *m.options.RootOwner
+	}
+	if perms := m.options.RootMode; perms != 0 {
+		attr.Mode = attr.Mode&^07777 | perms&07777
+	}
+	if f := m.options.RootAttr; f != nil {
+		f(attr)
+	}
+}
+
+func (m *fileSystemMount) fillEntry(out *fuse.EntryOut, n *Inode) {
 	splitDuration(m.options.EntryTimeout, &out.EntryValid, &out.EntryValidNsec)
 	splitDuration(m.options.AttrTimeout, &out.AttrValid, &out.AttrValidNsec)
 	m.setOwner(&out.Attr)
+	if n == m.mountInode {
+		m.setRootAttr(&out.Attr)
+	}
 	if out.Mode&fuse.S_IFDIR == 0 && out.Nlink == 0 {
 		out.Nlink = 1
 	}
 }
 
-func (m *fileSystemMount) fillAttr(out *fuse.AttrOut, nodeId uint64) {
+func (m *fileSystemMount) fillAttr(out *fuse.AttrOut, n *Inode, nodeId uint64) {
 	splitDuration(m.options.AttrTimeout, &out.AttrValid, &out.AttrValidNsec)
 	m.setOwner(&out.Attr)
+	if n == m.mountInode {
+		m.setRootAttr(&out.Attr)
+	}
 	if out.Ino == 0 {
 		out.Ino = nodeId
 	}
@@ -82,8 +275,8 @@ func (m *fileSystemMount) fillAttr(out *fuse.AttrOut, nodeId uint64) {
 
 func (m *fileSystemMount) getOpenedFile(h uint64) *openedFile {
 	var b *openedFile
-	if h != 0 {
-		b = (*openedFile)(unsafe.Pointer(m.openFiles.Decode(h)))
+	if obj, ok := m.openFiles.Get(h); ok {
+		b = obj.(*openedFile)
 	}
 
 	if b != nil && m.connector.debug && b.WithFlags.Description != "" {
@@ -92,9 +285,15 @@ func (m *fileSystemMount) getOpenedFile(h uint64) *openedFile {
 	return b
 }
 
-func (m *fileSystemMount) unregisterFileHandle(handle uint64, node *Inode) *openedFile {
-	_, obj := m.openFiles.Forget(handle, 1)
-	opened := (*openedFile)(unsafe.Pointer(obj))
+// unregisterFileHandle drops handle, and returns the file it held,
+// or nil if the handle is stale. last is set if no other file (as
+// opposed to directory) handles for node remain.
+func (m *fileSystemMount) unregisterFileHandle(handle uint64, node *Inode) (opened *openedFile, last bool) {
+	obj, ok := m.openFiles.Remove(handle)
+	if !ok {
+		return nil, false
+	}
+	opened = obj.(*openedFile)
 	node.openFilesMutex.Lock()
 	idx := -1
 	for i, v := range node.openFiles {
@@ -111,15 +310,24 @@ func (m *fileSystemMount) unregisterFileHandle(handle uint64, node *Inode) *open
 		node.openFiles[idx] = node.openFiles[l-1]
 	}
 	node.openFiles = node.openFiles[:l-1]
+	last = opened.dir == nil
+	for _, f := range node.openFiles {
+		if f.dir == nil {
+			last = false
+			break
+		}
+	}
 	node.openFilesMutex.Unlock()
 
-	return opened
+	return opened, last
 }
 
-func (m *fileSystemMount) registerFileHandle(node *Inode, dir *connectorDir, f File, flags uint32) (uint64, *openedFile) {
+func (m *fileSystemMount) registerFileHandle(node *Inode, dir *connectorDir, f File, flags uint32, context *fuse.Context) (uint64, *openedFile) {
 	node.openFilesMutex.Lock()
 	b := &openedFile{
-		dir: dir,
+		dir:    dir,
+		caller: *context,
+		opened: time.Now(),
 		WithFlags: WithFlags{
 			File:      f,
 			OpenFlags: flags,
@@ -142,7 +350,8 @@ func (m *fileSystemMount) registerFileHandle(node *Inode, dir *connectorDir, f F
 		b.WithFlags.File.SetInode(node)
 	}
 	node.openFiles = append(node.openFiles, b)
-	handle, _ := m.openFiles.Register(&b.handled)
+	handle := m.openFiles.Add(b)
+	b.fh = handle
 	node.openFilesMutex.Unlock()
 	return handle, b
 }
@@ -11,6 +11,7 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -29,6 +30,9 @@ func (c *rawBridge) Fsync(input *fuse.FsyncIn) fuse.Status {
 	opened := node.mount.getOpenedFile(input.Fh)
 
 	if opened != nil {
+		if opened.revoked.Load() {
+			return fuse.EBADF
+		}
 		return opened.WithFlags.File.Fsync(int(input.FsyncFlags))
 	}
 
@@ -112,7 +116,7 @@ func (c *rawBridge) Lookup(header *fuse.InHeader, name string, out *fuse.EntryOu
 		log.Println("Lookup returned fuse.OK with nil child", name)
 	}
 
-	child.mount.fillEntry(out)
+	child.mount.fillEntry(out, child)
 	out.NodeId, out.Generation = c.fsConn().lookupUpdate(child)
 	if out.Ino == 0 {
 		out.Ino = out.NodeId
@@ -121,6 +125,63 @@ func (c *rawBridge) Lookup(header *fuse.InHeader, name string, out *fuse.EntryOu
 	return fuse.OK
 }
 
+// now returns the time according to the server's clock.
+func (c *rawBridge) now() time.Time {
+	if c.server != nil {
+		return c.server.Clock().Now()
+	}
+	return time.Now()
+}
+
+// after waits for d on the server's clock.
+func (c *rawBridge) after(d time.Duration) <-chan time.Time {
+	if c.server != nil {
+		return c.server.After(d)
+	}
+	return time.After(d)
+}
+
+// LookupCounts implements fuse.LookupCounter.
+func (c *rawBridge) LookupCounts() map[uint64]uint64 {
+	counts := c.inodeMap.LookupCounts()
+	// The root is registered without a LOOKUP.
+	delete(counts, c.rootNode.handled.handle)
+	return counts
+}
+
+// CheckExit implements fuse.ExitChecker. The kernel releases all
+// files before it hangs up, so handles that are still open in any
+// of the mounts were leaked.
+func (c *rawBridge) CheckExit() error {
+	var problems []string
+	seen := map[*Inode]bool{}
+	var walk func(n *Inode, path string)
+	walk = func(n *Inode, path string) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		if n.mountPoint != nil {
+			if err := n.mountPoint.openFiles.Check(); err != nil {
+				problems = append(problems, fmt.Sprintf("mount %q: %v", "/"+path, err))
+			}
+		}
+		for name, ch := range n.Children() {
+			walk(ch, filepath.Join(path, name))
+		}
+	}
+	walk(c.rootNode, "")
+	if c.debug {
+		if err := c.fsConn().Fsck(); err != nil {
+			problems = append(problems, fmt.Sprintf("fsck: %v", err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(problems, "; "))
+}
+
 func (c *rawBridge) Forget(nodeID, nlookup uint64) {
 	c.fsConn().forgetUpdate(nodeID, int(nlookup))
 }
@@ -130,7 +191,7 @@ func (c *rawBridge) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) (code fuse
 
 	var f File
 	if input.Flags()&fuse.FUSE_GETATTR_FH != 0 {
-		if opened := node.mount.getOpenedFile(input.Fh()); opened != nil {
+		if opened := node.mount.getOpenedFile(input.Fh()); opened != nil && !opened.revoked.Load() {
 			f = opened.WithFlags.File
 		}
 	}
@@ -147,25 +208,20 @@ func (c *rawBridge) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) (code fuse
 		out.Nlink = 1
 	}
 
-	node.mount.fillAttr(out, input.NodeId)
+	node.mount.fillAttr(out, node, input.NodeId)
 	return fuse.OK
 }
 
 func (c *rawBridge) OpenDir(input *fuse.OpenIn, out *fuse.OpenOut) (code fuse.Status) {
 	node := c.toInode(input.NodeId)
-	stream, err := node.fsInode.OpenDir(&input.Context)
-	if err != fuse.OK {
-		return err
-	}
-	stream = append(stream, node.getMountDirEntries()...)
 	de := &connectorDir{
-		node: node.Node(),
-		stream: append(stream,
-			fuse.DirEntry{Mode: fuse.S_IFDIR, Name: "."},
-			fuse.DirEntry{Mode: fuse.S_IFDIR, Name: ".."}),
+		inode: node,
 		rawFS: c,
 	}
-	h, opened := node.mount.registerFileHandle(node, de, nil, input.Flags)
+	if code := de.open(&input.Context); !code.Ok() {
+		return code
+	}
+	h, opened := node.mount.registerFileHandle(node, de, nil, input.Flags, &input.Context)
 	out.OpenFlags = opened.FuseFlags
 	out.Fh = h
 	return fuse.OK
@@ -174,12 +230,19 @@ func (c *rawBridge) OpenDir(input *fuse.OpenIn, out *fuse.OpenOut) (code fuse.St
 func (c *rawBridge) ReadDir(input *fuse.ReadIn, out *fuse.DirEntryList) fuse.Status {
 	node := c.toInode(input.NodeId)
 	opened := node.mount.getOpenedFile(input.Fh)
+	if opened == nil || opened.dir == nil || opened.revoked.Load() {
+		// Eg. handle 0, if OpenDir returned NO_OPEN.
+		return fuse.EBADF
+	}
 	return opened.dir.ReadDir(input, out)
 }
 
 func (c *rawBridge) ReadDirPlus(input *fuse.ReadIn, out *fuse.DirEntryList) fuse.Status {
 	node := c.toInode(input.NodeId)
 	opened := node.mount.getOpenedFile(input.Fh)
+	if opened == nil || opened.dir == nil || opened.revoked.Load() {
+		return fuse.EBADF
+	}
 	return opened.dir.ReadDirPlus(input, out)
 }
 
@@ -189,7 +252,7 @@ func (c *rawBridge) Open(input *fuse.OpenIn, out *fuse.OpenOut) (status fuse.Sta
 	if !code.Ok() || f == nil {
 		return code
 	}
-	h, opened := node.mount.registerFileHandle(node, nil, f, input.Flags)
+	h, opened := node.mount.registerFileHandle(node, nil, f, input.Flags, &input.Context)
 	out.OpenFlags = opened.FuseFlags
 	out.Fh = h
 	return fuse.OK
@@ -199,55 +262,17 @@ func (c *rawBridge) SetAttr(input *fuse.SetAttrIn, out *fuse.AttrOut) (code fuse
 	node := c.toInode(input.NodeId)
 
 	var f File
-	if input.Valid&fuse.FATTR_FH != 0 {
-		opened := node.mount.getOpenedFile(input.Fh)
-		f = opened.WithFlags.File
-	}
-
-	if code.Ok() && input.Valid&fuse.FATTR_MODE != 0 {
-		permissions := uint32(07777) & input.Mode
-		code = node.fsInode.Chmod(f, permissions, &input.Context)
-	}
-	if code.Ok() && (input.Valid&(fuse.FATTR_UID|fuse.FATTR_GID) != 0) {
-		var uid uint32 = ^uint32(0) // means "do not change" in chown(2)
-		var gid uint32 = ^uint32(0)
-		if input.Valid&fuse.FATTR_UID != 0 {
-			uid = input.Uid
-		}
-		if input.Valid&fuse.FATTR_GID != 0 {
-			gid = input.Gid
-		}
-		code = node.fsInode.Chown(f, uid, gid, &input.Context)
-	}
-	if code.Ok() && input.Valid&fuse.FATTR_SIZE != 0 {
-		code = node.fsInode.Truncate(f, input.Size, &input.Context)
-	}
-	if code.Ok() && (input.Valid&(fuse.FATTR_ATIME|fuse.FATTR_MTIME|fuse.FATTR_ATIME_NOW|fuse.FATTR_MTIME_NOW) != 0) {
-		now := time.Now()
-		var atime *time.Time
-		var mtime *time.Time
-
-		if input.Valid&fuse.FATTR_ATIME != 0 {
-			if input.Valid&fuse.FATTR_ATIME_NOW != 0 {
-				atime = &now
-			} else {
-				t := time.Unix(int64(input.Atime), int64(input.Atimensec))
-				atime = &t
-			}
+	if fh, ok := input.GetFh(); ok {
+		opened := node.mount.getOpenedFile(fh)
+		if opened != nil && opened.revoked.Load() {
+			return fuse.EBADF
 		}
-
-		if input.Valid&fuse.FATTR_MTIME != 0 {
-			if input.Valid&fuse.FATTR_MTIME_NOW != 0 {
-				mtime = &now
-			} else {
-				t := time.Unix(int64(input.Mtime), int64(input.Mtimensec))
-				mtime = &t
-			}
+		if opened != nil {
+			f = opened.WithFlags.File
 		}
-
-		code = node.fsInode.Utimens(f, atime, mtime, &input.Context)
 	}
 
+	code = input.Apply(&setAttrTarget{node.fsInode, f, &input.Context}, c.now())
 	if !code.Ok() {
 		return code
 	}
@@ -257,18 +282,50 @@ func (c *rawBridge) SetAttr(input *fuse.SetAttrIn, out *fuse.AttrOut) (code fuse
 	attr := (*fuse.Attr)(&out.Attr)
 	code = node.fsInode.GetAttr(attr, nil, &input.Context)
 	if code.Ok() {
-		node.mount.fillAttr(out, input.NodeId)
+		node.mount.fillAttr(out, node, input.NodeId)
 	}
 	return code
 }
 
+// setAttrTarget directs the parts of a SETATTR request to a Node.
+type setAttrTarget struct {
+	node    Node
+	file    File
+	context *fuse.Context
+}
+
+func (t *setAttrTarget) Chmod(perms uint32) fuse.Status {
+	return t.node.Chmod(t.file, perms, t.context)
+}
+
+func (t *setAttrTarget) Chown(uid uint32, gid uint32) fuse.Status {
+	return t.node.Chown(t.file, uid, gid, t.context)
+}
+
+func (t *setAttrTarget) Truncate(size uint64) fuse.Status {
+	return t.node.Truncate(t.file, size, t.context)
+}
+
+func (t *setAttrTarget) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
+	return t.node.Utimens(t.file, atime, mtime, t.context)
+}
+
 func (c *rawBridge) Fallocate(input *fuse.FallocateIn) (code fuse.Status) {
 	n := c.toInode(input.NodeId)
 	opened := n.mount.getOpenedFile(input.Fh)
+	if opened != nil && opened.revoked.Load() {
+		return fuse.EBADF
+	}
 
 	return n.fsInode.Fallocate(opened, input.Offset, input.Length, input.Mode, &input.Context)
 }
 
+func (c *rawBridge) Bmap(input *fuse.BmapIn, out *fuse.BmapOut) (code fuse.Status) {
+	n := c.toInode(input.NodeId)
+	out.Block, code = n.fsInode.Bmap(input.Block, input.Blocksize, &input.Context)
+	return code
+}
+
 func (c *rawBridge) Readlink(header *fuse.InHeader) (out []byte, code fuse.Status) {
 	n := c.toInode(header.NodeId)
 	return n.fsInode.Readlink(&header.Context)
@@ -366,7 +423,7 @@ func (c *rawBridge) Create(input *fuse.CreateIn, name string, out *fuse.CreateOu
 	}
 
 	c.childLookup(&out.EntryOut, child, &input.Context)
-	handle, opened := parent.mount.registerFileHandle(child, nil, f, input.Flags)
+	handle, opened := parent.mount.registerFileHandle(child, nil, f, input.Flags, &input.Context)
 
 	out.OpenOut.OpenFlags = opened.FuseFlags
 	out.OpenOut.Fh = handle
@@ -376,15 +433,41 @@ func (c *rawBridge) Create(input *fuse.CreateIn, name string, out *fuse.CreateOu
 func (c *rawBridge) Release(input *fuse.ReleaseIn) {
 	if input.Fh != 0 {
 		node := c.toInode(input.NodeId)
-		opened := node.mount.unregisterFileHandle(input.Fh, node)
-		opened.WithFlags.File.Release()
+		if input.ReleaseFlags&fuse.RELEASE_FLUSH != 0 {
+			// The kernel folds the FLUSH of the last close()
+			// into the RELEASE.
+			c.flush(node, node.mount.getOpenedFile(input.Fh), input.LockOwner)
+		}
+		opened, last := node.mount.unregisterFileHandle(input.Fh, node)
+		if opened != nil {
+			if f, ok := opened.WithFlags.File.(StatsReleaser); ok {
+				f.ReleaseStats(opened.io.stats())
+			}
+			if f, ok := opened.WithFlags.File.(FlagReleaser); ok {
+				f.ReleaseFlags(input.ReleaseFlags)
+			} else {
+				opened.WithFlags.File.Release()
+			}
+		}
+		if input.ReleaseFlags&fuse.RELEASE_FLOCK_UNLOCK != 0 {
+			if t := node.locks.Load(); t != nil {
+				t.ReleaseOwner(input.LockOwner)
+			}
+		}
+		if last {
+			if lc, ok := node.Node().(LastCloser); ok {
+				lc.LastClose()
+			}
+		}
 	}
 }
 
 func (c *rawBridge) ReleaseDir(input *fuse.ReleaseIn) {
 	if input.Fh != 0 {
 		node := c.toInode(input.NodeId)
-		node.mount.unregisterFileHandle(input.Fh, node)
+		if opened, _ := node.mount.unregisterFileHandle(input.Fh, node); opened != nil && opened.dir != nil {
+			opened.dir.release()
+		}
 	}
 }
 
@@ -434,19 +517,42 @@ func (c *rawBridge) Write(input *fuse.WriteIn, data []byte) (written uint32, cod
 
 	var f File
 	if opened != nil {
+		if opened.revoked.Load() {
+			return 0, fuse.EBADF
+		}
 		f = opened.WithFlags.File
+		defer func() { opened.io.write(written, code) }()
 	}
 
 	return node.Node().Write(f, data, int64(input.Offset), &input.Context)
 }
 
-func (c *rawBridge) Read(input *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
+func (c *rawBridge) Read(input *fuse.ReadIn, buf []byte) (res fuse.ReadResult, code fuse.Status) {
 	node := c.toInode(input.NodeId)
 	opened := node.mount.getOpenedFile(input.Fh)
 
 	var f File
 	if opened != nil {
+		if opened.revoked.Load() {
+			return nil, fuse.EBADF
+		}
 		f = opened.WithFlags.File
+		defer func() { opened.io.read(res, code) }()
+		n := opened.reads.Add(1)
+		defer opened.reads.Add(-1)
+		flags := fuse.OpenFlags(opened.OpenFlags)
+		direct := flags.Direct() || opened.FuseFlags&fuse.FOPEN_DIRECT_IO != 0
+		if a, ok := f.(Adviser); ok && !direct {
+			if advice, changed := opened.pattern.read(int64(input.Offset), len(buf)); changed {
+				a.Advise(0, 0, advice)
+			}
+		}
+		if r, ok := f.(InfoReader); ok {
+			return r.ReadWithInfo(buf, int64(input.Offset), ReadInfo{
+				OpenFlags:   flags,
+				Speculative: n > 1 && !direct,
+			})
+		}
 	}
 
 	return node.Node().Read(f, buf, int64(input.Offset), &input.Context)
@@ -455,40 +561,71 @@ func (c *rawBridge) Read(input *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.
 func (c *rawBridge) GetLk(input *fuse.LkIn, out *fuse.LkOut) (code fuse.Status) {
 	n := c.toInode(input.NodeId)
 	opened := n.mount.getOpenedFile(input.Fh)
+	if opened != nil && opened.revoked.Load() {
+		return fuse.EBADF
+	}
 
-	return n.fsInode.GetLk(opened, input.Owner, &input.Lk, input.LkFlags, &out.Lk, &input.Context)
+	code = n.fsInode.GetLk(opened, input.Owner, &input.Lk, input.LkFlags, &out.Lk, &input.Context)
+	if code == fuse.ENOSYS {
+		n.lockTable().GetLk(input.Owner, &input.Lk, &out.Lk)
+		code = fuse.OK
+	}
+	return code
 }
 
 func (c *rawBridge) SetLk(input *fuse.LkIn) (code fuse.Status) {
 	n := c.toInode(input.NodeId)
 	opened := n.mount.getOpenedFile(input.Fh)
+	if opened != nil && opened.revoked.Load() {
+		return fuse.EBADF
+	}
 
-	return n.fsInode.SetLk(opened, input.Owner, &input.Lk, input.LkFlags, &input.Context)
+	code = n.fsInode.SetLk(opened, input.Owner, &input.Lk, input.LkFlags, &input.Context)
+	if code == fuse.ENOSYS {
+		code = n.lockTable().SetLk(input.Owner, &input.Lk)
+	}
+	return code
 }
 
 func (c *rawBridge) SetLkw(input *fuse.LkIn) (code fuse.Status) {
 	n := c.toInode(input.NodeId)
 	opened := n.mount.getOpenedFile(input.Fh)
+	if opened != nil && opened.revoked.Load() {
+		return fuse.EBADF
+	}
 
-	return n.fsInode.SetLkw(opened, input.Owner, &input.Lk, input.LkFlags, &input.Context)
+	code = n.fsInode.SetLkw(opened, input.Owner, &input.Lk, input.LkFlags, &input.Context)
+	if code == fuse.ENOSYS {
+		code = n.lockTable().SetLkw(input.Owner, &input.Lk)
+	}
+	return code
 }
 
 func (c *rawBridge) StatFs(header *fuse.InHeader, out *fuse.StatfsOut) fuse.Status {
 	node := c.toInode(header.NodeId)
-	s := node.Node().StatFs()
-	if s == nil {
-		return fuse.ENOSYS
-	}
-	*out = *s
-	return fuse.OK
+	return node.mount.cachedStatFs(node, out)
 }
 
 func (c *rawBridge) Flush(input *fuse.FlushIn) fuse.Status {
 	node := c.toInode(input.NodeId)
-	opened := node.mount.getOpenedFile(input.Fh)
-
-	if opened != nil {
-		return opened.WithFlags.File.Flush()
+	return c.flush(node, node.mount.getOpenedFile(input.Fh), input.LockOwner)
+}
+
+func (c *rawBridge) flush(node *Inode, opened *openedFile, owner uint64) fuse.Status {
+	code := fuse.OK
+	if opened != nil && opened.revoked.Load() {
+		code = fuse.EBADF
+	} else if opened != nil {
+		if f, ok := opened.WithFlags.File.(LockOwnerFlusher); ok {
+			code = f.FlushLockOwner(owner)
+		} else {
+			code = opened.WithFlags.File.Flush()
+		}
 	}
-	return fuse.OK
+	// close() releases the POSIX locks of the process, whichever
+	// file descriptor they were taken through.
+	if t := node.locks.Load(); t != nil {
+		t.ReleaseOwner(owner)
+	}
+	return code
 }
@@ -0,0 +1,81 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// fsyncFile records the flags of its Fsync calls.
+type fsyncFile struct {
+	File
+	flags *[]int
+}
+
+func (f *fsyncFile) Fsync(flags int) fuse.Status {
+	*f.flags = append(*f.flags, flags)
+	return f.File.Fsync(flags)
+}
+
+type fsyncNode struct {
+	Node
+	path  string
+	flags []int
+}
+
+func (n *fsyncNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	f, err := os.OpenFile(n.path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	return &fsyncFile{NewLoopbackFile(f), &n.flags}, fuse.OK
+}
+
+func TestFsyncDataSync(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "file")
+	if err := os.WriteFile(name, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	node := &fsyncNode{Node: NewDefaultNode(), path: name}
+	root.Inode().NewChild("file", false, node)
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	open, code := k.Open(out.NodeId, uint32(os.O_RDWR))
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	defer k.Release(out.NodeId, open.Fh)
+
+	if code := k.Fsync(out.NodeId, open.Fh, 0); !code.Ok() {
+		t.Errorf("Fsync: %v", code)
+	}
+	if code := k.Fsync(out.NodeId, open.Fh, fuse.FSYNC_FDATASYNC); !code.Ok() {
+		t.Errorf("Fsync(FDATASYNC): %v", code)
+	}
+	if want := []int{0, fuse.FSYNC_FDATASYNC}; !reflect.DeepEqual(node.flags, want) {
+		t.Errorf("got flags %v, want %v", node.flags, want)
+	}
+}
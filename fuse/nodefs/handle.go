@@ -32,6 +32,9 @@ type handleMap interface {
 	Handle(obj *handled) uint64
 	// Has checks if NodeId is stored.
 	Has(uint64) bool
+	// LookupCounts returns the reference count of each stored
+	// object by NodeId.
+	LookupCounts() map[uint64]uint64
 }
 
 type handled struct {
@@ -149,3 +152,15 @@ func (m *portableHandleMap) Has(h uint64) bool {
 	m.RUnlock()
 	return ok
 }
+
+func (m *portableHandleMap) LookupCounts() map[uint64]uint64 {
+	m.RLock()
+	defer m.RUnlock()
+	result := make(map[uint64]uint64, m.used)
+	for h, obj := range m.handles {
+		if obj != nil {
+			result[uint64(h)] = uint64(obj.count)
+		}
+	}
+	return result
+}
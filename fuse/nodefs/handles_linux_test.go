@@ -0,0 +1,54 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestLeakedHandlesOnExit(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	conn := NewFileSystemConnector(NewMemNodeFSRoot(dir+"/"), nil)
+
+	var logged bytes.Buffer
+	k, err := fakekernel.New(conn.RawFS(), &fuse.MountOptions{
+		Logger: log.New(&logged, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+
+	released, code := k.Create(fuse.FUSE_ROOT_ID, "released", syscall.O_RDWR, 0644)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	if code := k.Release(released.NodeId, released.Fh); !code.Ok() {
+		t.Errorf("Release: %v", code)
+	}
+	// A stale handle must not crash the server.
+	if code := k.Release(released.NodeId, released.Fh); !code.Ok() {
+		t.Errorf("Release of stale handle: %v", code)
+	}
+
+	if _, code := k.Create(fuse.FUSE_ROOT_ID, "leaked", syscall.O_RDWR, 0644); !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := logged.String(); !strings.Contains(got, "1 handles not released") {
+		t.Errorf("got log %q, want the leaked handle reported", got)
+	}
+}
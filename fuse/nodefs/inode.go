@@ -7,6 +7,8 @@ package nodefs
 import (
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 )
@@ -43,6 +45,25 @@ type Inode struct {
 	// Non-nil if this inode is a mountpoint, ie. the Root of a
 	// NodeFileSystem.
 	mountPoint *fileSystemMount
+
+	// POSIX locks, for Nodes that do not implement locking;
+	// created on first use.
+	locks atomic.Pointer[fuse.LockTable]
+
+	// When the node may be dropped, if the kernel forgot it while
+	// Options.ForgetGrace is set. Protected by treeLock.
+	forgetDeadline time.Time
+
+	// Number of Pin calls without Unpin. Protected by treeLock.
+	pins int
+
+	// Set if the kernel forgot the node, but it was kept in the
+	// tree, eg. because it was pinned. Cleared on lookup.
+	kept atomic.Bool
+
+	// Change counters kept by a Journal, and protected by its
+	// mutex.
+	versions Versions
 }
 
 func newInode(isDir bool, fsNode Node) *Inode {
@@ -56,6 +77,15 @@ func newInode(isDir bool, fsNode Node) *Inode {
 	return me
 }
 
+// lockTable returns the lock table of n, creating it if needed.
+func (n *Inode) lockTable() *fuse.LockTable {
+	if t := n.locks.Load(); t != nil {
+		return t
+	}
+	n.locks.CompareAndSwap(nil, &fuse.LockTable{})
+	return n.locks.Load()
+}
+
 // public methods.
 
 // Returns any open file, preferably a r/w one.
@@ -98,6 +128,47 @@ func (n *Inode) Parent() (parent *Inode, name string) {
 	return nil, ""
 }
 
+// Path returns the names from the root of the mount of n down to n,
+// joined by '/'; the root itself has path "". The walk up the tree
+// happens under a single lock, so a concurrent rename yields either
+// the old or the new path, never a mix. For hard-linked nodes, one of
+// the paths is returned. If n is no longer connected to the root, eg.
+// because it was unlinked while open, ok is false.
+func (n *Inode) Path() (path string, ok bool) {
+	if n.mountPoint != nil {
+		return "", true
+	}
+	n.mount.treeLock.RLock()
+	defer n.mount.treeLock.RUnlock()
+
+	var segments []string
+	size := 0
+	walkUp := n
+	for walkUp.mountPoint == nil {
+		var parent *Inode
+		var name string
+		for k := range walkUp.parents {
+			parent, name = k.parent, k.name
+			break
+		}
+		if parent == nil {
+			return "", false
+		}
+		segments = append(segments, name)
+		size += len(name) + 1
+		walkUp = parent
+	}
+
+	b := make([]byte, 0, size)
+	for i := len(segments) - 1; i >= 0; i-- {
+		b = append(b, segments[i]...)
+		if i > 0 {
+			b = append(b, '/')
+		}
+	}
+	return string(b), true
+}
+
 // FsChildren returns all the children from the same filesystem.  It
 // will skip mountpoints.
 func (n *Inode) FsChildren() (out map[string]*Inode) {
@@ -220,9 +291,10 @@ func (n *Inode) rmChild(name string) *Inode {
 // Can only be called on untouched root inodes.
 func (n *Inode) mountFs(opts *Options) {
 	n.mountPoint = &fileSystemMount{
-		openFiles:  newPortableHandleMap(),
+		openFiles:  fuse.NewHandleTable(),
 		mountInode: n,
 		options:    opts,
+		unmounted:  make(chan struct{}),
 	}
 	n.mount = n.mountPoint
 }
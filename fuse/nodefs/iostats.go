@@ -0,0 +1,53 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"sync/atomic"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// IOStats sums up the I/O on a file handle.
+type IOStats struct {
+	// The number of READ and WRITE requests that succeeded.
+	Reads  uint64
+	Writes uint64
+
+	// The bytes returned by reads, and accepted by writes.
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// ioCounters collects the IOStats of an open file.
+type ioCounters struct {
+	reads, writes           atomic.Uint64
+	bytesRead, bytesWritten atomic.Uint64
+}
+
+func (c *ioCounters) read(res fuse.ReadResult, code fuse.Status) {
+	if !code.Ok() || res == nil {
+		return
+	}
+	c.reads.Add(1)
+	c.bytesRead.Add(uint64(res.Size()))
+}
+
+func (c *ioCounters) write(n uint32, code fuse.Status) {
+	if !code.Ok() {
+		return
+	}
+	c.writes.Add(1)
+	c.bytesWritten.Add(uint64(n))
+}
+
+func (c *ioCounters) stats() IOStats {
+	return IOStats{
+		Reads:        c.reads.Load(),
+		Writes:       c.writes.Load(),
+		BytesRead:    c.bytesRead.Load(),
+		BytesWritten: c.bytesWritten.Load(),
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+type statsFile struct {
+	File
+	stats chan IOStats
+}
+
+func (f *statsFile) ReleaseStats(stats IOStats) {
+	f.stats <- stats
+}
+
+type statsNode struct {
+	Node
+	file *statsFile
+}
+
+func (n *statsNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return n.file, fuse.OK
+}
+
+func TestReleaseStats(t *testing.T) {
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	file := &statsFile{
+		File:  NewDataFile(make([]byte, 100)),
+		stats: make(chan IOStats, 1),
+	}
+	root.Inode().NewChild("file", false, &statsNode{NewDefaultNode(), file})
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	open, code := k.Open(out.NodeId, 0)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	for _, off := range []uint64{0, 60, 100} {
+		if _, code := k.Read(out.NodeId, open.Fh, off, 50); !code.Ok() {
+			t.Fatalf("Read(%d): %v", off, code)
+		}
+	}
+	// The data file is read-only, so writes fail, and are not
+	// counted.
+	if _, code := k.Write(out.NodeId, open.Fh, 0, []byte("hello")); code.Ok() {
+		t.Fatalf("Write succeeded")
+	}
+	k.Release(out.NodeId, open.Fh)
+
+	want := IOStats{Reads: 3, BytesRead: 90}
+	if got := <-file.stats; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
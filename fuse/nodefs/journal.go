@@ -0,0 +1,106 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Versions count the changes to the attributes and to the data of a
+// node. A data change is an attribute change too, as it moves mtime
+// and often the size.
+type Versions struct {
+	Attr uint64
+	Data uint64
+}
+
+// A Journal keeps Versions for nodes, so a file system that caches
+// attributes or content can tell whether its copy is still current,
+// and so the kernel is only told about the kind of change that
+// happened: a change to the attributes does not drop the kernel's
+// page cache.
+//
+// Nodes start at version zero. Versions are kept in the Inode, so
+// they go away with it.
+type Journal struct {
+	conn *FileSystemConnector
+	mu   sync.Mutex
+}
+
+// NewJournal returns a journal that sends invalidations through
+// conn. If conn is nil, versions are only recorded.
+func NewJournal(conn *FileSystemConnector) *Journal {
+	return &Journal{conn: conn}
+}
+
+// Versions returns the current versions of n.
+func (j *Journal) Versions(n *Inode) Versions {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return n.versions
+}
+
+// Current says whether a copy of n taken at seen is still up to
+// date. A cache of attributes alone should compare the Attr field of
+// Versions instead.
+func (j *Journal) Current(n *Inode, seen Versions) bool {
+	return j.Versions(n) == seen
+}
+
+// AttrChanged records a change to the attributes of n, and makes
+// the kernel fetch them again.
+func (j *Journal) AttrChanged(n *Inode) (Versions, fuse.Status) {
+	j.mu.Lock()
+	n.versions.Attr++
+	v := n.versions
+	j.mu.Unlock()
+	return v, j.notify(n, -1, 0)
+}
+
+// DataChanged records a change to length bytes of n at off, and
+// makes the kernel drop that range from its cache. A zero length
+// means everything from off on.
+func (j *Journal) DataChanged(n *Inode, off, length int64) (Versions, fuse.Status) {
+	j.mu.Lock()
+	n.versions.Attr++
+	n.versions.Data++
+	v := n.versions
+	j.mu.Unlock()
+	return v, j.notify(n, off, length)
+}
+
+// Update moves n to the versions reported by the backend, eg. from a
+// change feed, and sends the invalidation that the change calls for.
+// Versions older than the recorded ones are ignored, so reports may
+// arrive out of order.
+func (j *Journal) Update(n *Inode, v Versions) fuse.Status {
+	j.mu.Lock()
+	attr := v.Attr > n.versions.Attr
+	data := v.Data > n.versions.Data
+	if attr {
+		n.versions.Attr = v.Attr
+	}
+	if data {
+		n.versions.Data = v.Data
+	}
+	j.mu.Unlock()
+
+	switch {
+	case data:
+		return j.notify(n, 0, 0)
+	case attr:
+		return j.notify(n, -1, 0)
+	}
+	return fuse.OK
+}
+
+func (j *Journal) notify(n *Inode, off, length int64) fuse.Status {
+	if j.conn == nil {
+		return fuse.OK
+	}
+	return j.conn.FileNotify(n, off, length)
+}
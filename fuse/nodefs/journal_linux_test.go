@@ -0,0 +1,77 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+func TestJournal(t *testing.T) {
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+	j := NewJournal(conn)
+	n := root.Inode()
+
+	// offsets returns the Off of each INVAL_INODE notification.
+	offsets := func() []int64 {
+		// Notifications are ordered before the reply.
+		k.GetAttr(fuse.FUSE_ROOT_ID)
+		var r []int64
+		for _, note := range k.Notifications() {
+			if note.Code == fuse.NOTIFY_INVAL_INODE {
+				r = append(r, int64(binary.NativeEndian.Uint64(note.Data[8:])))
+			}
+		}
+		return r
+	}
+
+	seen := j.Versions(n)
+	v, code := j.AttrChanged(n)
+	if !code.Ok() || v != (Versions{1, 0}) {
+		t.Errorf("AttrChanged: got %v, %v", v, code)
+	}
+	if j.Current(n, seen) {
+		t.Errorf("Current after AttrChanged")
+	}
+	if got := offsets(); !reflect.DeepEqual(got, []int64{-1}) {
+		t.Errorf("AttrChanged: got offsets %v, want [-1]", got)
+	}
+
+	v, code = j.DataChanged(n, 4096, 100)
+	if !code.Ok() || v != (Versions{2, 1}) {
+		t.Errorf("DataChanged: got %v, %v", v, code)
+	}
+	if !j.Current(n, v) {
+		t.Errorf("Current(%v) after DataChanged", v)
+	}
+	if got := offsets(); !reflect.DeepEqual(got, []int64{4096}) {
+		t.Errorf("DataChanged: got offsets %v, want [4096]", got)
+	}
+
+	// Stale and repeated reports are ignored.
+	j.Update(n, Versions{1, 1})
+	j.Update(n, Versions{2, 1})
+	if got := offsets(); len(got) != 0 {
+		t.Errorf("stale Update: got offsets %v", got)
+	}
+	j.Update(n, Versions{3, 1})
+	j.Update(n, Versions{3, 2})
+	if got := offsets(); !reflect.DeepEqual(got, []int64{-1, 0}) {
+		t.Errorf("Update: got offsets %v, want [-1 0]", got)
+	}
+	if got := j.Versions(n); got != (Versions{3, 2}) {
+		t.Errorf("Versions: got %v, want {3 2}", got)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// ownerFile records the lock owners it is flushed for.
+type ownerFile struct {
+	File
+	owners []uint64
+}
+
+func (f *ownerFile) FlushLockOwner(owner uint64) fuse.Status {
+	f.owners = append(f.owners, owner)
+	return fuse.OK
+}
+
+type ownerNode struct {
+	Node
+	file *ownerFile
+}
+
+func (n *ownerNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return n.file, fuse.OK
+}
+
+func TestDefaultLocksReleasedOnFlush(t *testing.T) {
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	node := &ownerNode{NewDefaultNode(), &ownerFile{File: NewDefaultFile()}}
+	root.Inode().NewChild("file", false, node)
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	f, code := k.Open(out.NodeId, 0)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+
+	const a, b = 10, 20
+	lk := fuse.FileLock{Start: 0, End: 100, Typ: syscall.F_WRLCK}
+	if code := k.SetLk(out.NodeId, f.Fh, a, lk, false); !code.Ok() {
+		t.Fatalf("SetLk: %v", code)
+	}
+	if code := k.SetLk(out.NodeId, f.Fh, b, lk, false); code != fuse.EAGAIN {
+		t.Errorf("conflicting SetLk: got %v, want EAGAIN", code)
+	}
+	if got, code := k.GetLk(out.NodeId, f.Fh, b, lk); !code.Ok() || got.Typ != syscall.F_WRLCK {
+		t.Errorf("GetLk: got %v, %v, want a's write lock", &got, code)
+	}
+
+	// Closing a file descriptor in another process keeps a's locks.
+	k.FlushOwner(out.NodeId, f.Fh, b)
+	if code := k.SetLk(out.NodeId, f.Fh, b, lk, false); code != fuse.EAGAIN {
+		t.Errorf("SetLk after b's close: got %v, want EAGAIN", code)
+	}
+	k.FlushOwner(out.NodeId, f.Fh, a)
+	if code := k.SetLk(out.NodeId, f.Fh, b, lk, false); !code.Ok() {
+		t.Errorf("SetLk after a's close: %v", code)
+	}
+
+	if want := []uint64{b, a}; len(node.file.owners) != 2 || node.file.owners[0] != want[0] || node.file.owners[1] != want[1] {
+		t.Errorf("FlushLockOwner got owners %v, want %v", node.file.owners, want)
+	}
+	k.Release(out.NodeId, f.Fh)
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+func TestLookupCheckerBalanced(t *testing.T) {
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	root.Inode().NewChild("file", false, NewDefaultNode())
+
+	checker := fuse.NewLookupChecker(conn.RawFS())
+	var logged bytes.Buffer
+	// Deterministic processes requests in order, so a FORGET has
+	// been handled once the reply to the next request arrives.
+	k, err := fakekernel.New(checker, &fuse.MountOptions{
+		Deterministic: true,
+		Logger:        log.New(&logged, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+
+	var id uint64
+	for i := 0; i < 2; i++ {
+		out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+		if !code.Ok() {
+			t.Fatalf("Lookup: %v", code)
+		}
+		id = out.NodeId
+	}
+	if err := checker.Check(); err != nil {
+		t.Errorf("Check after lookups: %v", err)
+	}
+
+	k.Forget(id, 1)
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+	if err := checker.Check(); err != nil {
+		t.Errorf("Check after forget: %v", err)
+	}
+
+	k.Forget(id, 2)
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+	if err := checker.Check(); err == nil {
+		t.Errorf("Check did not report underflow")
+	}
+
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// The underflow is logged when it happens, and again when
+	// Serve returns.
+	if got := strings.Count(logged.String(), "forget 2, but only 1 lookups"); got != 2 {
+		t.Errorf("got log %q, want underflow logged twice", logged.String())
+	}
+}
@@ -138,7 +138,9 @@ func (n *memNode) Create(name string, flags uint32, mode uint32, context *fuse.C
 	ch := n.fs.newNode()
 	ch.info.Mode = mode | fuse.S_IFREG
 
-	f, err := os.Create(ch.filename())
+	// The backing file is new, so O_EXCL needs no checking, but
+	// O_APPEND and O_SYNC should stick to the handle.
+	f, err := os.OpenFile(ch.filename(), int(flags)|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return nil, nil, fuse.ToStatus(err)
 	}
@@ -10,22 +10,17 @@ import (
 	"testing"
 	"time"
 
-	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/internal/testutil"
 )
 
 const testTtl = 100 * time.Millisecond
 
-func setupMemNodeTest(t *testing.T) (wd string, root Node, clean func()) {
-	tmp, err := ioutil.TempDir("", "go-fuse-memnode_test")
-	if err != nil {
-		t.Fatalf("TempDir failed: %v", err)
-	}
+func setupMemNodeTest(t *testing.T) (wd string, root Node) {
+	tmp := testutil.TempDir()
+	t.Cleanup(func() { os.RemoveAll(tmp) })
 	back := tmp + "/backing"
 	os.Mkdir(back, 0700)
 	root = NewMemNodeFSRoot(back)
-	mnt := tmp + "/mnt"
-	os.Mkdir(mnt, 0700)
 
 	connector := NewFileSystemConnector(root,
 		&Options{
@@ -35,26 +30,12 @@ func setupMemNodeTest(t *testing.T) (wd string, root Node, clean func()) {
 			Debug:               testutil.VerboseTest(),
 			LookupKnownChildren: true,
 		})
-	state, err := fuse.NewServer(connector.RawFS(), mnt, &fuse.MountOptions{Debug: testutil.VerboseTest()})
-	if err != nil {
-		t.Fatal("NewServer", err)
-	}
-
-	// Unthreaded, but in background.
-	go state.Serve()
-
-	if err := state.WaitMount(); err != nil {
-		t.Fatal("WaitMount", err)
-	}
-	return mnt, root, func() {
-		state.Unmount()
-		os.RemoveAll(tmp)
-	}
+	mnt, _ := testutil.Mount(t, connector.RawFS(), nil)
+	return mnt, root
 }
 
 func TestMemNodeFsWrite(t *testing.T) {
-	wd, _, clean := setupMemNodeTest(t)
-	defer clean()
+	wd, _ := setupMemNodeTest(t)
 	want := "hello"
 
 	err := ioutil.WriteFile(wd+"/test", []byte(want), 0644)
@@ -69,8 +50,7 @@ func TestMemNodeFsWrite(t *testing.T) {
 }
 
 func TestMemNodeFsBasic(t *testing.T) {
-	wd, _, clean := setupMemNodeTest(t)
-	defer clean()
+	wd, _ := setupMemNodeTest(t)
 
 	err := ioutil.WriteFile(wd+"/test", []byte{42}, 0644)
 	if err != nil {
@@ -92,8 +72,7 @@ func TestMemNodeFsBasic(t *testing.T) {
 }
 
 func TestMemNodeSetattr(t *testing.T) {
-	wd, _, clean := setupMemNodeTest(t)
-	defer clean()
+	wd, _ := setupMemNodeTest(t)
 
 	f, err := os.OpenFile(wd+"/test", os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -0,0 +1,94 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// flagsNode records the flags of Open and Create calls.
+type flagsNode struct {
+	Node
+	flags []fuse.OpenFlags
+}
+
+func (n *flagsNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	n.flags = append(n.flags, fuse.OpenFlags(flags))
+	return NewDataFile(nil), fuse.OK
+}
+
+func (n *flagsNode) Create(name string, flags uint32, mode uint32, context *fuse.Context) (File, *Inode, fuse.Status) {
+	n.flags = append(n.flags, fuse.OpenFlags(flags))
+	ch := n.Inode().NewChild(name, false, &flagsNode{Node: NewDefaultNode()})
+	return NewDataFile(nil), ch, fuse.OK
+}
+
+func TestOpenFlagsPassThrough(t *testing.T) {
+	root := &flagsNode{Node: NewDefaultNode()}
+	conn := NewFileSystemConnector(root, nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	create := uint32(os.O_WRONLY | os.O_CREATE | os.O_EXCL | os.O_SYNC)
+	out, code := k.Create(fuse.FUSE_ROOT_ID, "file", create, 0644)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	k.Release(out.NodeId, out.Fh)
+	open := uint32(os.O_RDWR | os.O_APPEND | syscall.O_NOATIME)
+	opened, code := k.Open(out.NodeId, open)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	k.Release(out.NodeId, opened.Fh)
+
+	if len(root.flags) != 1 || root.flags[0] != fuse.OpenFlags(create) {
+		t.Errorf("Create got flags %v, want %v", root.flags, fuse.OpenFlags(create))
+	} else if f := root.flags[0]; !f.Exclusive() || !f.Sync() || !f.DataSync() || f.Readable() {
+		t.Errorf("Create flags %v: helpers disagree", f)
+	}
+
+	child := root.Inode().GetChild("file").Node().(*flagsNode)
+	if len(child.flags) != 1 || child.flags[0] != fuse.OpenFlags(open) {
+		t.Errorf("Open got flags %v, want %v", child.flags, fuse.OpenFlags(open))
+	} else if f := child.flags[0]; !f.Append() || !f.NoAtime() || !f.Readable() || !f.Writable() || f.Sync() {
+		t.Errorf("Open flags %v: helpers disagree", f)
+	}
+}
+
+func TestMemNodeCreateFlags(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	conn := NewFileSystemConnector(NewMemNodeFSRoot(dir+"/backing"), nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	out, code := k.Create(fuse.FUSE_ROOT_ID, "file", uint32(os.O_RDWR|os.O_CREATE|os.O_APPEND), 0644)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	for _, s := range []string{"hello", "world"} {
+		if _, code := k.Write(out.NodeId, out.Fh, 0, []byte(s)); !code.Ok() {
+			t.Fatalf("Write: %v", code)
+		}
+	}
+	if got, _ := k.Read(out.NodeId, out.Fh, 0, 100); string(got) != "helloworld" {
+		t.Errorf("got %q, want writes appended", got)
+	}
+	k.Release(out.NodeId, out.Fh)
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// modeNode reports a fixed mode.
+type modeNode struct {
+	Node
+	mode uint32
+}
+
+func (n *modeNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	out.Mode = n.mode
+	return fuse.OK
+}
+
+func TestOwnerAndModeOverride(t *testing.T) {
+	root := NewDefaultNode()
+	opts := NewOptions()
+	opts.Owner = &fuse.Owner{Uid: 42, Gid: 43}
+	opts.DirMode = 0500
+	opts.FileMode = 0400
+	conn := NewFileSystemConnector(root, opts)
+	root.Inode().NewChild("dir", true, NewDefaultNode())
+	root.Inode().NewChild("file", false, NewDefaultNode())
+	root.Inode().NewChild("setuid", false, &modeNode{Node: NewDefaultNode(), mode: fuse.S_IFREG | 04755})
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	for name, want := range map[string]uint32{
+		"dir":  fuse.S_IFDIR | 0500,
+		"file": fuse.S_IFREG | 0400,
+		// The forced mode replaces the setuid bit too.
+		"setuid": fuse.S_IFREG | 0400,
+	} {
+		entry, code := k.Lookup(fuse.FUSE_ROOT_ID, name)
+		if !code.Ok() {
+			t.Fatalf("Lookup(%q): %v", name, code)
+		}
+		if entry.Mode != want || entry.Uid != 42 || entry.Gid != 43 {
+			t.Errorf("Lookup(%q): got mode 0%o owner %d:%d, want 0%o 42:43", name, entry.Mode, entry.Uid, entry.Gid, want)
+		}
+		attr, code := k.GetAttr(entry.NodeId)
+		if !code.Ok() {
+			t.Fatalf("GetAttr(%q): %v", name, code)
+		}
+		if attr.Mode != want || attr.Uid != 42 {
+			t.Errorf("GetAttr(%q): got mode 0%o uid %d", name, attr.Mode, attr.Uid)
+		}
+	}
+}
+
+func TestModeMask(t *testing.T) {
+	root := NewDefaultNode()
+	opts := NewOptions()
+	opts.DirMask = 0022
+	opts.FileMask = 07777
+	conn := NewFileSystemConnector(root, opts)
+	root.Inode().NewChild("dir", true, &modeNode{Node: NewDefaultNode(), mode: fuse.S_IFDIR | 01777})
+	root.Inode().NewChild("file", false, &modeNode{Node: NewDefaultNode(), mode: fuse.S_IFREG | 04755})
+	root.Inode().NewChild("link", false, &modeNode{Node: NewDefaultNode(), mode: fuse.S_IFLNK | 0777})
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	for name, want := range map[string]uint32{
+		"dir": fuse.S_IFDIR | 01755,
+		// A mask can force a mode of 0.
+		"file": fuse.S_IFREG,
+		"link": fuse.S_IFLNK | 0777,
+	} {
+		entry, code := k.Lookup(fuse.FUSE_ROOT_ID, name)
+		if !code.Ok() {
+			t.Fatalf("Lookup(%q): %v", name, code)
+		}
+		if entry.Mode != want {
+			t.Errorf("Lookup(%q): got mode 0%o, want 0%o", name, entry.Mode, want)
+		}
+	}
+}
+
+func TestRootAttr(t *testing.T) {
+	root := NewDefaultNode()
+	opts := NewOptions()
+	opts.DirMode = 0500
+	opts.RootMode = 0711
+	opts.RootOwner = &fuse.Owner{Uid: 42, Gid: 43}
+	opts.RootAttr = func(out *fuse.Attr) {
+		out.Mtime = 1234
+	}
+	conn := NewFileSystemConnector(root, opts)
+	root.Inode().NewChild("dir", true, NewDefaultNode())
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	attr, code := k.GetAttr(fuse.FUSE_ROOT_ID)
+	if !code.Ok() {
+		t.Fatalf("GetAttr: %v", code)
+	}
+	if attr.Mode != fuse.S_IFDIR|0711 || attr.Uid != 42 || attr.Gid != 43 || attr.Mtime != 1234 {
+		t.Errorf("root: got mode 0%o owner %d:%d mtime %d", attr.Mode, attr.Uid, attr.Gid, attr.Mtime)
+	}
+
+	// Other directories are not affected.
+	entry, code := k.Lookup(fuse.FUSE_ROOT_ID, "dir")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if entry.Mode != fuse.S_IFDIR|0500 || entry.Uid == 42 || entry.Mtime == 1234 {
+		t.Errorf("dir: got mode 0%o uid %d mtime %d", entry.Mode, entry.Uid, entry.Mtime)
+	}
+}
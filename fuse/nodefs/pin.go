@@ -0,0 +1,70 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+// Pin keeps n in the tree when the kernel forgets it, so a later
+// lookup finds the node as it is rather than one rebuilt from
+// scratch. Use it for nodes that are expensive to construct and
+// looked up often, eg. the top-level directories of a remote store.
+// As directories with children are never dropped, the parents of n
+// stay too. Each Pin must be matched by an Unpin.
+func (n *Inode) Pin() {
+	n.mount.treeLock.Lock()
+	defer n.mount.treeLock.Unlock()
+	n.pins++
+}
+
+// Unpin undoes a Pin. Once the last pin is gone, n and the parents
+// that were only kept for it are dropped if the kernel has forgotten
+// them.
+func (n *Inode) Unpin() {
+	m := n.mount
+	m.treeLock.Lock()
+	defer m.treeLock.Unlock()
+	if n.pins <= 0 {
+		panic("Unpin without Pin")
+	}
+	n.pins--
+	if n.pins == 0 {
+		m.connector.dropForgotten(n)
+	}
+}
+
+// Pinned reports whether n has pins.
+func (n *Inode) Pinned() bool {
+	n.mount.treeLock.RLock()
+	defer n.mount.treeLock.RUnlock()
+	return n.pins > 0
+}
+
+// dropForgotten drops node if the kernel has forgotten it and nothing
+// else keeps it, and then tries its parents, which may have been kept
+// only for node. Must run with treeLock held.
+func (c *FileSystemConnector) dropForgotten(node *Inode) {
+	if !node.kept.Load() || !c.droppable(node) || c.inodeMap.Handle(&node.handled) != 0 {
+		return
+	}
+	if node.mount.options.ForgetGrace > 0 {
+		node.mount.queueForget(node)
+		return
+	}
+	c.dropWithParents(node)
+}
+
+// dropWithParents drops node, and then tries its parents in the same
+// mount. Must run with treeLock held.
+func (c *FileSystemConnector) dropWithParents(node *Inode) {
+	var parents []*Inode
+	for p := range node.parents {
+		parents = append(parents, p.parent)
+	}
+	node.kept.Store(false)
+	c.dropNode(node)
+	for _, p := range parents {
+		if p.mount == node.mount {
+			c.dropForgotten(p)
+		}
+	}
+}
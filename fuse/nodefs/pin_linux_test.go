@@ -0,0 +1,73 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+func TestPin(t *testing.T) {
+	root := &buildingNode{Node: NewDefaultNode()}
+	opts := NewOptions()
+	opts.LookupKnownChildren = true
+	conn := NewFileSystemConnector(root, opts)
+
+	// Deterministic processes requests in order, so a FORGET has
+	// been handled once the reply to the next request arrives.
+	k, err := fakekernel.New(conn.RawFS(), &fuse.MountOptions{Deterministic: true})
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	node := root.Inode().GetChild("file")
+	node.Pin()
+	if !node.Pinned() {
+		t.Errorf("Pinned: got false after Pin")
+	}
+
+	// A pinned node survives FORGET, and comes back on lookup.
+	k.Forget(out.NodeId, 1)
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+	if root.Inode().GetChild("file") != node || root.forgotten.Load() != 0 {
+		t.Fatalf("pinned node dropped")
+	}
+	if out, code = k.Lookup(fuse.FUSE_ROOT_ID, "file"); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if root.built != 1 {
+		t.Errorf("built %d nodes, want 1", root.built)
+	}
+
+	// Unpinning a node the kernel still knows keeps it.
+	node.Unpin()
+	if root.Inode().GetChild("file") == nil || node.Pinned() {
+		t.Fatalf("Unpin dropped a node in use")
+	}
+
+	// Once forgotten, the last Unpin drops it.
+	node.Pin()
+	node.Pin()
+	k.Forget(out.NodeId, 1)
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+	if root.Inode().GetChild("file") == nil {
+		t.Fatalf("pinned node dropped")
+	}
+	node.Unpin()
+	if root.Inode().GetChild("file") == nil {
+		t.Fatalf("node dropped with a pin left")
+	}
+	node.Unpin()
+	if root.Inode().GetChild("file") != nil || root.forgotten.Load() != 1 {
+		t.Errorf("node kept after Unpin, %d forgotten", root.forgotten.Load())
+	}
+}
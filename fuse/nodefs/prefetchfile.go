@@ -0,0 +1,295 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// PrefetchOptions tunes NewPrefetchFile.
+type PrefetchOptions struct {
+	// Trigger is the number of back-to-back sequential reads after
+	// which prefetching starts. Default 2.
+	Trigger int
+
+	// MinWindow is the size of the first read ahead, default
+	// 128k. Each further read ahead doubles in size, up to
+	// MaxWindow, default 1M.
+	MinWindow int
+	MaxWindow int
+
+	// If set, data read ahead is charged to this limit, as
+	// account "prefetch". Read aheads that do not fit are not
+	// started, and under memory pressure the ones furthest ahead
+	// are dropped.
+	Memory *fuse.MemoryLimit
+}
+
+// At most this many read aheads are kept per file, so a prefetchFile
+// holds at most 2*MaxWindow bytes.
+const maxPrefetches = 2
+
+// prefetch is a read ahead of the range [off, off+size).
+type prefetch struct {
+	off  int64
+	size int
+
+	// Closed once data and status are set.
+	done   chan struct{}
+	data   []byte
+	status fuse.Status
+}
+
+type prefetchFile struct {
+	File
+	opts PrefetchOptions
+
+	mu sync.Mutex
+
+	// next is where the next read starts if it is sequential.
+	next   int64
+	seq    int
+	window int
+
+	// Read aheads, in order of offset and without gaps.
+	prefetches []*prefetch
+
+	// Counts read aheads in progress, including dropped ones.
+	running sync.WaitGroup
+
+	// Set if opts.Memory is.
+	account *fuse.MemoryAccount
+}
+
+// NewPrefetchFile wraps a File to read ahead on sequential reads. Once
+// reads have been sequential for a while, data beyond the current read
+// is read from f in the background, so the following reads can be
+// served from memory. This helps backends with high latency, where
+// the kernel's own read ahead is too small to keep the pipe full.
+//
+// Writes, truncation and allocation through the returned File drop
+// data read ahead. Changes made to the backing data in other ways are
+// not noticed.
+func NewPrefetchFile(f File, opts *PrefetchOptions) File {
+	p := &prefetchFile{File: f}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if p.opts.Trigger <= 0 {
+		p.opts.Trigger = 2
+	}
+	if p.opts.MinWindow <= 0 {
+		p.opts.MinWindow = 128 << 10
+	}
+	if p.opts.MaxWindow < p.opts.MinWindow {
+		p.opts.MaxWindow = 1 << 20
+		if p.opts.MaxWindow < p.opts.MinWindow {
+			p.opts.MaxWindow = p.opts.MinWindow
+		}
+	}
+	p.window = p.opts.MinWindow
+	if p.opts.Memory != nil {
+		p.account = p.opts.Memory.Register("prefetch", p.evict)
+	}
+	return p
+}
+
+func (f *prefetchFile) InnerFile() File {
+	return f.File
+}
+
+func (f *prefetchFile) String() string {
+	return fmt.Sprintf("prefetchFile(%s)", f.File.String())
+}
+
+func (f *prefetchFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	end := off + int64(len(buf))
+
+	f.mu.Lock()
+	hit := f.find(off)
+	if off == f.next {
+		f.seq++
+	} else {
+		f.seq = 0
+		f.window = f.opts.MinWindow
+		if hit == nil {
+			f.discard(len(f.prefetches))
+		}
+	}
+	if end > f.next || hit == nil {
+		f.next = end
+	}
+	passed := 0
+	for passed < len(f.prefetches) && f.prefetches[passed].off+int64(f.prefetches[passed].size) <= off {
+		passed++
+	}
+	f.discard(passed)
+	if f.seq >= f.opts.Trigger {
+		f.readAhead(end)
+	}
+	f.mu.Unlock()
+
+	if hit != nil {
+		<-hit.done
+		if data, ok := hit.slice(off, len(buf)); ok {
+			return fuse.ReadResultData(data), fuse.OK
+		}
+	}
+	return f.File.Read(buf, off)
+}
+
+// find returns the read ahead containing off. The caller holds f.mu.
+func (f *prefetchFile) find(off int64) *prefetch {
+	for _, p := range f.prefetches {
+		if off >= p.off && off < p.off+int64(p.size) {
+			return p
+		}
+	}
+	return nil
+}
+
+// readAhead starts reading ahead so that the data up to a window
+// beyond end is read or being read. The caller holds f.mu.
+func (f *prefetchFile) readAhead(end int64) {
+	start := end
+	if n := len(f.prefetches); n > 0 {
+		last := f.prefetches[n-1]
+		if isDone(last) && last.status.Ok() && len(last.data) < last.size {
+			// Hit EOF.
+			return
+		}
+		start = last.off + int64(last.size)
+	}
+	for len(f.prefetches) < maxPrefetches && start < end+int64(f.window) {
+		if f.account != nil && !f.account.TryCharge(int64(f.window)) {
+			return
+		}
+		p := &prefetch{
+			off:  start,
+			size: f.window,
+			done: make(chan struct{}),
+		}
+		f.prefetches = append(f.prefetches, p)
+		f.running.Add(1)
+		go func() {
+			defer f.running.Done()
+			p.run(f.File)
+		}()
+
+		start += int64(p.size)
+		if f.window < f.opts.MaxWindow {
+			f.window = min(2*f.window, f.opts.MaxWindow)
+		}
+	}
+}
+
+func isDone(p *prefetch) bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *prefetch) run(f File) {
+	defer close(p.done)
+	buf := make([]byte, p.size)
+	res, code := f.Read(buf, p.off)
+	if !code.Ok() {
+		p.status = code
+		return
+	}
+	data, code := res.Bytes(buf)
+	p.data = buf[:copy(buf, data)]
+	p.status = code
+	res.Done()
+}
+
+// slice returns the n bytes at off, or less if the read ahead hit
+// EOF. It returns false if the data must be read from the file.
+func (p *prefetch) slice(off int64, n int) ([]byte, bool) {
+	if !p.status.Ok() {
+		return nil, false
+	}
+	start := int(off - p.off)
+	if start+n <= len(p.data) {
+		return p.data[start : start+n], true
+	}
+	if len(p.data) < p.size {
+		// EOF.
+		start = min(start, len(p.data))
+		return p.data[start:], true
+	}
+	// The read straddles the end of the read ahead.
+	return nil, false
+}
+
+// discard drops the first n read aheads. The caller holds f.mu.
+func (f *prefetchFile) discard(n int) {
+	var size int64
+	for _, p := range f.prefetches[:n] {
+		size += int64(p.size)
+	}
+	f.prefetches = f.prefetches[n:]
+	if f.account != nil {
+		f.account.Release(size)
+	}
+}
+
+// evict drops read aheads, furthest first, to free want bytes for
+// the memory limit.
+func (f *prefetchFile) evict(want int64) int64 {
+	if !f.mu.TryLock() {
+		return 0
+	}
+	defer f.mu.Unlock()
+	var freed int64
+	for n := len(f.prefetches); n > 0 && freed < want; n-- {
+		freed += int64(f.prefetches[n-1].size)
+		f.prefetches = f.prefetches[:n-1]
+	}
+	f.window = f.opts.MinWindow
+	f.account.Release(freed)
+	return freed
+}
+
+// drop discards data read ahead.
+func (f *prefetchFile) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.discard(len(f.prefetches))
+	f.seq = 0
+	f.window = f.opts.MinWindow
+}
+
+func (f *prefetchFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	f.drop()
+	return f.File.Write(data, off)
+}
+
+func (f *prefetchFile) Truncate(size uint64) fuse.Status {
+	f.drop()
+	return f.File.Truncate(size)
+}
+
+func (f *prefetchFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	f.drop()
+	return f.File.Allocate(off, size, mode)
+}
+
+func (f *prefetchFile) Release() {
+	// Reads in the background must be finished before the
+	// file is closed.
+	f.drop()
+	f.running.Wait()
+	if f.account != nil {
+		f.account.Close()
+	}
+	f.File.Release()
+}
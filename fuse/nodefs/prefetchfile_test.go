@@ -0,0 +1,116 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// recordingFile is an in-memory file that records the reads and
+// writes it gets.
+type recordingFile struct {
+	File
+
+	mu       sync.Mutex
+	data     []byte
+	reads    []int
+	writes   []int
+	released bool
+}
+
+func (f *recordingFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads = append(f.reads, len(buf))
+	if off > int64(len(f.data)) {
+		off = int64(len(f.data))
+	}
+	n := copy(buf, f.data[off:])
+	return fuse.ReadResultData(buf[:n]), fuse.OK
+}
+
+func (f *recordingFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes = append(f.writes, len(data))
+	if end := int(off) + len(data); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	copy(f.data[off:], data)
+	return uint32(len(data)), fuse.OK
+}
+
+func (f *recordingFile) Release() {
+	f.released = true
+}
+
+func readAll(t *testing.T, f File, size, chunk int) []byte {
+	var got []byte
+	buf := make([]byte, chunk)
+	for off := 0; off < size+chunk; off += chunk {
+		res, code := f.Read(buf, int64(off))
+		if !code.Ok() {
+			t.Fatalf("Read(%d): %v", off, code)
+		}
+		b, _ := res.Bytes(buf)
+		got = append(got, b...)
+		res.Done()
+	}
+	return got
+}
+
+func TestPrefetchFile(t *testing.T) {
+	content := make([]byte, 100<<10)
+	for i := range content {
+		content[i] = byte(i / 1000)
+	}
+	inner := &recordingFile{
+		File: NewDefaultFile(),
+		data: append([]byte{}, content...),
+	}
+	f := NewPrefetchFile(inner, &PrefetchOptions{
+		MinWindow: 8 << 10,
+		MaxWindow: 32 << 10,
+	})
+
+	if got := readAll(t, f, len(content), 4<<10); !bytes.Equal(got, content) {
+		t.Fatalf("sequential read: got %d bytes, want %d", len(got), len(content))
+	}
+	f.(*prefetchFile).running.Wait()
+	inner.mu.Lock()
+	reads := inner.reads
+	inner.reads = nil
+	inner.mu.Unlock()
+	if len(reads) >= len(content)/(4<<10) {
+		t.Errorf("got %d backend reads %v, want fewer than the %d reads issued", len(reads), reads, len(content)/(4<<10))
+	}
+	largest := 0
+	for _, n := range reads {
+		largest = max(largest, n)
+	}
+	if largest != 32<<10 {
+		t.Errorf("largest read ahead %d, want 32k: %v", largest, reads)
+	}
+
+	// Data read ahead is not served after a write.
+	readAll(t, f, 16<<10, 4<<10)
+	if _, code := f.Write([]byte("hello"), 20<<10); !code.Ok() {
+		t.Fatalf("Write: %v", code)
+	}
+	buf := make([]byte, 5)
+	res, _ := f.Read(buf, 20<<10)
+	if b, _ := res.Bytes(buf); string(b) != "hello" {
+		t.Errorf("read after write: got %q", b)
+	}
+
+	f.Release()
+	if !inner.released {
+		t.Error("inner file not released")
+	}
+}
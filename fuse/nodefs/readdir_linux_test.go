@@ -0,0 +1,198 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// listNode is a directory with the given entries.
+type listNode struct {
+	Node
+
+	mu    sync.Mutex
+	names []string
+}
+
+func (n *listNode) OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var r []fuse.DirEntry
+	for _, name := range n.names {
+		r = append(r, fuse.DirEntry{Name: name, Mode: fuse.S_IFREG})
+	}
+	return r, fuse.OK
+}
+
+func TestReadDirStableOffsets(t *testing.T) {
+	root := &listNode{Node: NewDefaultNode(), names: []string{"a", "b", "c", "d", "e"}}
+	conn := NewFileSystemConnector(root, nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	dir, code := k.OpenDir(fuse.FUSE_ROOT_ID)
+	if !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+	defer k.ReleaseDir(fuse.FUSE_ROOT_ID, dir.Fh)
+
+	// readFrom reads all entries after off, a few at a time.
+	readFrom := func(off uint64) (names []string, offs map[string]uint64) {
+		offs = map[string]uint64{}
+		for {
+			ents, code := k.ReadDir(fuse.FUSE_ROOT_ID, dir.Fh, off, 64)
+			if !code.Ok() {
+				t.Fatalf("ReadDir(%d): %v", off, code)
+			}
+			if len(ents) == 0 {
+				return names, offs
+			}
+			for _, e := range ents {
+				names = append(names, e.Name)
+				offs[e.Name] = e.Off
+				off = e.Off
+			}
+		}
+	}
+
+	names, offs := readFrom(0)
+	if want := []string{"a", "b", "c", "d", "e", ".", ".."}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+
+	// Remove an entry before the telldir position, and add one
+	// that the file system lists first.
+	root.mu.Lock()
+	root.names = []string{"0", "a", "c", "d", "e"}
+	root.mu.Unlock()
+
+	// Rewinding picks up the changes.
+	names, again := readFrom(0)
+	if want := []string{"a", "c", "d", "e", ".", "..", "0"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("after rewind: got %v, want %v", names, want)
+	}
+	for name, off := range again {
+		if old, ok := offs[name]; ok && old != off {
+			t.Errorf("%s: offset changed from %d to %d", name, old, off)
+		}
+	}
+
+	// Seeking to the offset of c, which came before the changes,
+	// continues right after c.
+	names, _ = readFrom(offs["c"])
+	if want := []string{"d", "e", ".", "..", "0"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("after seek: got %v, want %v", names, want)
+	}
+
+	// An offset past the end gives no entries.
+	if ents, code := k.ReadDir(fuse.FUSE_ROOT_ID, dir.Fh, 1000, 4096); !code.Ok() || len(ents) != 0 {
+		t.Errorf("ReadDir past end: got %v, %v", ents, code)
+	}
+}
+
+// streamNode is a directory of n files, listed through a DirStream.
+type streamNode struct {
+	Node
+
+	n            int
+	opens, nexts atomic.Int32
+}
+
+func (n *streamNode) OpenDirStream(context *fuse.Context) (DirStream, fuse.Status) {
+	n.opens.Add(1)
+	return &countStream{node: n}, fuse.OK
+}
+
+type countStream struct {
+	node *streamNode
+	i    int
+}
+
+func (s *countStream) HasNext() bool { return s.i < s.node.n }
+
+func (s *countStream) Next() (fuse.DirEntry, fuse.Status) {
+	s.node.nexts.Add(1)
+	s.i++
+	return fuse.DirEntry{Name: fmt.Sprintf("file%04d", s.i), Mode: fuse.S_IFREG}, fuse.OK
+}
+
+func (s *countStream) Close() {}
+
+func TestReadDirStream(t *testing.T) {
+	root := &streamNode{Node: NewDefaultNode(), n: 1000}
+	conn := NewFileSystemConnector(root, nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	dir, code := k.OpenDir(fuse.FUSE_ROOT_ID)
+	if !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+	defer k.ReleaseDir(fuse.FUSE_ROOT_ID, dir.Fh)
+
+	// Each entry takes 24 bytes plus 8 for the name, so 100
+	// bytes fit three.
+	const size = 100
+	readAll := func() []string {
+		var names []string
+		var off uint64
+		for {
+			ents, code := k.ReadDir(fuse.FUSE_ROOT_ID, dir.Fh, off, size)
+			if !code.Ok() {
+				t.Fatalf("ReadDir(%d): %v", off, code)
+			}
+			if len(ents) == 0 {
+				return names
+			}
+			if len(ents) > 3 {
+				t.Fatalf("ReadDir(%d): got %d entries in %d bytes", off, len(ents), size)
+			}
+			for _, e := range ents {
+				names = append(names, e.Name)
+				off = e.Off
+			}
+		}
+	}
+
+	names := readAll()
+	if len(names) != 1002 || names[0] != "file0001" || names[999] != "file1000" || names[1001] != ".." {
+		t.Fatalf("got %d names: %v ... %v", len(names), names[:3], names[len(names)-3:])
+	}
+	if opens, nexts := root.opens.Load(), root.nexts.Load(); opens != 1 || nexts != 1000 {
+		t.Errorf("got %d opens and %d entries read, want 1 and 1000", opens, nexts)
+	}
+
+	// Rewinding starts over.
+	if names := readAll(); len(names) != 1002 || root.opens.Load() != 2 {
+		t.Errorf("after rewind: got %d names, %d opens", len(names), root.opens.Load())
+	}
+
+	// Seeking back reopens the stream, and seeking forward skips
+	// entries.
+	ents, code := k.ReadDir(fuse.FUSE_ROOT_ID, dir.Fh, 10, size)
+	if !code.Ok() || len(ents) == 0 || ents[0].Name != "file0011" {
+		t.Errorf("seek back: got %v, %v", ents, code)
+	}
+	if opens := root.opens.Load(); opens != 3 {
+		t.Errorf("seek back: got %d opens, want 3", opens)
+	}
+	ents, code = k.ReadDir(fuse.FUSE_ROOT_ID, dir.Fh, 500, size)
+	if !code.Ok() || len(ents) == 0 || ents[0].Name != "file0501" || root.opens.Load() != 3 {
+		t.Errorf("seek forward: got %v, %v, %d opens", ents, code, root.opens.Load())
+	}
+}
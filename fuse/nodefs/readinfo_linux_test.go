@@ -0,0 +1,94 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// infoFile blocks reads at offset 0 until release is closed.
+type infoFile struct {
+	File
+	started chan struct{}
+	release chan struct{}
+	infos   chan ReadInfo
+}
+
+func (f *infoFile) ReadWithInfo(dest []byte, off int64, info ReadInfo) (fuse.ReadResult, fuse.Status) {
+	if off == 0 {
+		close(f.started)
+		<-f.release
+	}
+	f.infos <- info
+	return fuse.ReadResultData(nil), fuse.OK
+}
+
+type infoNode struct {
+	Node
+	file *infoFile
+}
+
+func (n *infoNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return n.file, fuse.OK
+}
+
+func TestReadInfoSpeculative(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		root := NewDefaultNode()
+		conn := NewFileSystemConnector(root, nil)
+		node := &infoNode{NewDefaultNode(), &infoFile{
+			File:    NewDefaultFile(),
+			started: make(chan struct{}),
+			release: make(chan struct{}),
+			infos:   make(chan ReadInfo, 2),
+		}}
+		root.Inode().NewChild("file", false, node)
+
+		k, err := fakekernel.New(conn.RawFS(), nil)
+		if err != nil {
+			t.Fatalf("fakekernel.New: %v", err)
+		}
+		out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+		if !code.Ok() {
+			t.Fatalf("Lookup: %v", code)
+		}
+		flags := uint32(syscall.O_RDONLY)
+		if direct {
+			flags |= syscall.O_DIRECT
+		}
+		f, code := k.Open(out.NodeId, flags)
+		if !code.Ok() {
+			t.Fatalf("Open: %v", code)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			k.Read(out.NodeId, f.Fh, 0, 4096)
+			close(done)
+		}()
+		<-node.file.started
+		k.Read(out.NodeId, f.Fh, 4096, 4096)
+		ahead := <-node.file.infos
+		close(node.file.release)
+		<-done
+		first := <-node.file.infos
+
+		if first.Speculative {
+			t.Errorf("direct=%v: first read is speculative", direct)
+		}
+		if ahead.Speculative == direct {
+			t.Errorf("direct=%v: concurrent read has Speculative=%v", direct, ahead.Speculative)
+		}
+		if ahead.OpenFlags.Direct() != direct {
+			t.Errorf("direct=%v: got OpenFlags %v", direct, ahead.OpenFlags)
+		}
+		k.Release(out.NodeId, f.Fh)
+		k.Close()
+	}
+}
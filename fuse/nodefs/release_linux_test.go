@@ -0,0 +1,89 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// releaseFile records the calls it gets.
+type releaseFile struct {
+	File
+	calls *[]string
+}
+
+func (f *releaseFile) Flush() fuse.Status {
+	*f.calls = append(*f.calls, "flush")
+	return fuse.OK
+}
+
+func (f *releaseFile) ReleaseFlags(flags uint32) {
+	*f.calls = append(*f.calls, fmt.Sprintf("release %d", flags))
+}
+
+type lastCloseNode struct {
+	Node
+	calls []string
+}
+
+func (n *lastCloseNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return &releaseFile{NewDefaultFile(), &n.calls}, fuse.OK
+}
+
+func (n *lastCloseNode) LastClose() {
+	n.calls = append(n.calls, "last")
+}
+
+func TestReleaseLastClose(t *testing.T) {
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	node := &lastCloseNode{Node: NewDefaultNode()}
+	root.Inode().NewChild("file", false, node)
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	var fhs []uint64
+	for i := 0; i < 2; i++ {
+		f, code := k.Open(out.NodeId, 0)
+		if !code.Ok() {
+			t.Fatalf("Open: %v", code)
+		}
+		fhs = append(fhs, f.Fh)
+	}
+
+	check := func(want ...string) {
+		t.Helper()
+		if len(node.calls) != len(want) {
+			t.Fatalf("got calls %q, want %q", node.calls, want)
+		}
+		for i := range want {
+			if node.calls[i] != want[i] {
+				t.Fatalf("got calls %q, want %q", node.calls, want)
+			}
+		}
+		node.calls = nil
+	}
+
+	k.ReleaseFlags(out.NodeId, fhs[0], 1, 0)
+	check("release 0")
+	k.ReleaseFlags(out.NodeId, fhs[1], 1, fuse.RELEASE_FLUSH)
+	check("flush", "release 1", "last")
+
+	// A stale handle does not trigger LastClose again.
+	k.ReleaseFlags(out.NodeId, fhs[1], 1, 0)
+	check()
+}
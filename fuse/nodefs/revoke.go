@@ -0,0 +1,117 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// OpenHandle describes an open file or directory handle; see
+// FileSystemConnector.OpenHandles.
+type OpenHandle struct {
+	NodeId uint64
+	Fh     uint64
+
+	// Path of the node, or "" if it is no longer reachable, eg.
+	// because it was unlinked.
+	Path string
+	Dir  bool
+
+	// The open(2) flags.
+	Flags uint32
+
+	// The process that opened the handle. Later users of the
+	// handle, eg. after fork, are not tracked.
+	Caller fuse.Context
+	Opened time.Time
+
+	Revoked bool
+}
+
+// OpenHandles returns the handles that the kernel has open, ordered
+// by NodeId and Fh. Use it to find out what keeps a file system busy,
+// eg. before unmounting.
+func (c *FileSystemConnector) OpenHandles() []OpenHandle {
+	var r []OpenHandle
+	for id := range c.inodeMap.LookupCounts() {
+		h := c.inodeMap.Decode(id)
+		if h == nil {
+			continue
+		}
+		n := (*Inode)(unsafe.Pointer(h))
+		n.openFilesMutex.Lock()
+		files := append([]*openedFile{}, n.openFiles...)
+		n.openFilesMutex.Unlock()
+		if len(files) == 0 {
+			continue
+		}
+		if n == c.rootNode {
+			id = fuse.FUSE_ROOT_ID
+		}
+		path, ok := n.Path()
+		if ok {
+			path = "/" + path
+		}
+		for _, f := range files {
+			r = append(r, OpenHandle{
+				NodeId:  id,
+				Fh:      f.fh,
+				Path:    path,
+				Dir:     f.dir != nil,
+				Flags:   f.OpenFlags,
+				Caller:  f.caller,
+				Opened:  f.opened,
+				Revoked: f.revoked.Load(),
+			})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].NodeId != r[j].NodeId {
+			return r[i].NodeId < r[j].NodeId
+		}
+		return r[i].Fh < r[j].Fh
+	})
+	return r
+}
+
+// Revoke cuts off the handle fh of node nodeID: its reads, writes
+// and other operations fail with EBADF from now on, and the kernel is
+// told to drop the cached data of the node, so nothing is served
+// from the page cache either. This lets an administrator stop a
+// process that hangs on to a file, eg. to unmount. The File is
+// released as usual when the kernel releases the handle, which
+// happens when the process closes it or exits.
+func (c *FileSystemConnector) Revoke(nodeID, fh uint64) fuse.Status {
+	n := c.rootNode
+	if nodeID != fuse.FUSE_ROOT_ID {
+		h := c.inodeMap.Decode(nodeID)
+		if h == nil {
+			return fuse.ENOENT
+		}
+		n = (*Inode)(unsafe.Pointer(h))
+	}
+	n.openFilesMutex.Lock()
+	var f *openedFile
+	for _, o := range n.openFiles {
+		if o.fh == fh {
+			f = o
+		}
+	}
+	n.openFilesMutex.Unlock()
+	if f == nil {
+		return fuse.EBADF
+	}
+	if f.revoked.Swap(true) || f.dir != nil {
+		return fuse.OK
+	}
+	if code := c.FileNotify(n, 0, 0); !code.Ok() && code != fuse.ENOSYS {
+		return code
+	}
+	return fuse.OK
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+type dataNode struct {
+	Node
+}
+
+func (n *dataNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return NewDataFile([]byte("hello")), fuse.OK
+}
+
+func TestRevoke(t *testing.T) {
+	root := NewDefaultNode()
+	conn := NewFileSystemConnector(root, nil)
+	root.Inode().NewChild("file", false, &dataNode{NewDefaultNode()})
+
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	out, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	open, code := k.Open(out.NodeId, uint32(os.O_RDONLY))
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	dir, code := k.OpenDir(fuse.FUSE_ROOT_ID)
+	if !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+
+	handles := conn.OpenHandles()
+	if len(handles) != 2 {
+		t.Fatalf("got handles %v, want 2", handles)
+	}
+	if h := handles[0]; h.NodeId != fuse.FUSE_ROOT_ID || h.Fh != dir.Fh || !h.Dir || h.Path != "/" {
+		t.Errorf("directory handle: got %+v", h)
+	}
+	h := handles[1]
+	if h.NodeId != out.NodeId || h.Fh != open.Fh || h.Dir || h.Path != "/file" || h.Caller.Pid != uint32(os.Getpid()) {
+		t.Errorf("file handle: got %+v", h)
+	}
+
+	if _, code := k.Read(out.NodeId, open.Fh, 0, 5); !code.Ok() {
+		t.Fatalf("Read: %v", code)
+	}
+	if code := conn.Revoke(out.NodeId, open.Fh); !code.Ok() {
+		t.Fatalf("Revoke: %v", code)
+	}
+	if _, code := k.Read(out.NodeId, open.Fh, 0, 5); code != fuse.EBADF {
+		t.Errorf("Read after Revoke: got %v, want EBADF", code)
+	}
+	if handles := conn.OpenHandles(); !handles[1].Revoked {
+		t.Errorf("handle not marked revoked: %+v", handles[1])
+	}
+	if code := conn.Revoke(out.NodeId, 12345); code != fuse.EBADF {
+		t.Errorf("Revoke of unknown handle: got %v, want EBADF", code)
+	}
+
+	k.Release(out.NodeId, open.Fh)
+	k.ReleaseDir(fuse.FUSE_ROOT_ID, dir.Fh)
+	if handles := conn.OpenHandles(); len(handles) != 0 {
+		t.Errorf("after release: got %v", handles)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"fmt"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+type sparseFile struct {
+	File
+	size    uint64
+	blksize uint32
+	holes   []fuse.Hole
+}
+
+// NewSparseFile wraps a read-only File of the given size that has
+// holes. Reads of holes return zeros without calling f, and GetAttr
+// reports the block count for the data outside the holes, so du and
+// sparse-aware tools see realistic usage.
+func NewSparseFile(f File, size uint64, blksize uint32, holes []fuse.Hole) File {
+	return &sparseFile{
+		File:    f,
+		size:    size,
+		blksize: blksize,
+		holes:   fuse.NormalizeHoles(size, holes),
+	}
+}
+
+func (f *sparseFile) InnerFile() File {
+	return f.File
+}
+
+func (f *sparseFile) String() string {
+	return fmt.Sprintf("sparseFile(%s, %d holes)", f.File.String(), len(f.holes))
+}
+
+func (f *sparseFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	return fuse.ReadSparse(buf, off, f.size, f.holes, func(dest []byte, off int64) (int, fuse.Status) {
+		res, code := f.File.Read(dest, off)
+		if !code.Ok() {
+			return 0, code
+		}
+		defer res.Done()
+		data, code := res.Bytes(dest)
+		return copy(dest, data), code
+	})
+}
+
+func (f *sparseFile) GetAttr(out *fuse.Attr) fuse.Status {
+	if code := f.File.GetAttr(out); !code.Ok() {
+		return code
+	}
+	out.SetSparseSize(f.size, f.blksize, f.holes)
+	return fuse.OK
+}
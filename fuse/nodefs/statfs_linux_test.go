@@ -0,0 +1,53 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+type statfsNode struct {
+	Node
+	calls uint64
+}
+
+func (n *statfsNode) StatFs() *fuse.StatfsOut {
+	n.calls++
+	return &fuse.StatfsOut{Bfree: n.calls}
+}
+
+func TestStatfsTimeout(t *testing.T) {
+	for _, ttl := range []time.Duration{0, time.Hour} {
+		root := &statfsNode{Node: NewDefaultNode()}
+		opts := NewOptions()
+		opts.StatfsTimeout = ttl
+		conn := NewFileSystemConnector(root, opts)
+		k, err := fakekernel.New(conn.RawFS(), nil)
+		if err != nil {
+			t.Fatalf("fakekernel.New: %v", err)
+		}
+
+		var last uint64
+		for i := 0; i < 3; i++ {
+			out, code := k.StatFs(fuse.FUSE_ROOT_ID)
+			if !code.Ok() {
+				t.Fatalf("StatFs: %v", code)
+			}
+			last = out.Bfree
+		}
+		want := uint64(3)
+		if ttl > 0 {
+			want = 1
+		}
+		if root.calls != want || last != want {
+			t.Errorf("ttl %v: got %d calls, Bfree %d, want %d", ttl, root.calls, last, want)
+		}
+		k.Close()
+	}
+}
@@ -18,3 +18,28 @@ func futimens(fd int, times *[2]syscall.Timespec) (err error) {
 	}
 	return
 }
+
+// pwritev writes bufs to fd at off with a single system call.
+func pwritev(fd int, bufs [][]byte, off int64) (int, error) {
+	iovs := make([]syscall.Iovec, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		iov := syscall.Iovec{Base: &b[0]}
+		iov.SetLen(len(b))
+		iovs = append(iovs, iov)
+	}
+	if len(iovs) == 0 {
+		return 0, nil
+	}
+	// The kernel takes the offset in two halves; on 64-bit
+	// systems, the low one holds all of it.
+	n, _, e1 := syscall.Syscall6(syscall.SYS_PWRITEV, uintptr(fd),
+		uintptr(unsafe.Pointer(&iovs[0])), uintptr(len(iovs)),
+		uintptr(off), uintptr(uint64(off)>>32), 0)
+	if e1 != 0 {
+		return int(n), syscall.Errno(e1)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"sort"
+	"sync"
+)
+
+// maxBatch bounds the buffers in one pwritev, below the kernel's
+// IOV_MAX of 1024.
+const maxBatch = 1024
+
+// writeBatcher coalesces writes to one file descriptor. A write that
+// arrives while another is in progress waits in a queue; the writer
+// in progress then issues the queued writes, joining those that are
+// adjacent into a single pwritev, and hands its role to a write that
+// queued up in the meantime, so no caller writes for others without
+// bound. Without contention, a write is a plain pwrite.
+type writeBatcher struct {
+	mu      sync.Mutex
+	busy    bool
+	pending []*pendingWrite
+}
+
+type pendingWrite struct {
+	data []byte
+	off  int64
+
+	// Set if the write takes over as the writer in progress,
+	// rather than being done.
+	lead bool
+
+	n    int
+	err  error
+	done chan struct{}
+}
+
+// write writes data at off, calling do for the actual I/O, which
+// must write the buffers consecutively starting at the offset.
+func (b *writeBatcher) write(data []byte, off int64, do func(bufs [][]byte, off int64) (int, error)) (int, error) {
+	b.mu.Lock()
+	if b.busy {
+		w := &pendingWrite{data: data, off: off, done: make(chan struct{})}
+		b.pending = append(b.pending, w)
+		b.mu.Unlock()
+		<-w.done
+		if !w.lead {
+			return w.n, w.err
+		}
+	} else {
+		b.busy = true
+		b.mu.Unlock()
+	}
+
+	n, err := do([][]byte{data}, off)
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	if len(batch) == 0 {
+		b.busy = false
+		b.mu.Unlock()
+		return n, err
+	}
+	b.mu.Unlock()
+	flushWrites(batch, do)
+
+	b.mu.Lock()
+	if len(b.pending) == 0 {
+		b.busy = false
+	} else {
+		next := b.pending[0]
+		b.pending = b.pending[1:]
+		next.lead = true
+		close(next.done)
+	}
+	b.mu.Unlock()
+	return n, err
+}
+
+// flushWrites issues the writes in batch, and completes them.
+func flushWrites(batch []*pendingWrite, do func(bufs [][]byte, off int64) (int, error)) {
+	sort.SliceStable(batch, func(i, j int) bool {
+		return batch[i].off < batch[j].off
+	})
+	for len(batch) > 0 {
+		run := 1
+		end := batch[0].off + int64(len(batch[0].data))
+		for run < len(batch) && run < maxBatch && batch[run].off == end {
+			end += int64(len(batch[run].data))
+			run++
+		}
+		bufs := make([][]byte, run)
+		for i, w := range batch[:run] {
+			bufs[i] = w.data
+		}
+		n, err := do(bufs, batch[0].off)
+
+		// Writes that were not completed are retried alone,
+		// so each gets its own result.
+		for _, w := range batch[:run] {
+			switch {
+			case n >= len(w.data):
+				w.n = len(w.data)
+				n -= len(w.data)
+			case err != nil && n == 0 && run == 1:
+				w.err = err
+			default:
+				w.n, w.err = do([][]byte{w.data[n:]}, w.off+int64(n))
+				w.n += n
+				n = 0
+			}
+			close(w.done)
+		}
+		batch = batch[run:]
+	}
+}
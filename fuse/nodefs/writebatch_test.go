@@ -0,0 +1,195 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestFlushWrites(t *testing.T) {
+	var calls []string
+	content := make([]byte, 20)
+	do := func(bufs [][]byte, off int64) (int, error) {
+		calls = append(calls, fmt.Sprintf("%d@%d", len(bufs), off))
+		n := 0
+		for _, b := range bufs {
+			n += copy(content[off+int64(n):], b)
+		}
+		return n, nil
+	}
+	var batch []*pendingWrite
+	for _, w := range []struct {
+		off  int64
+		data string
+	}{{4, "ef"}, {0, "ab"}, {10, "k"}, {2, "cd"}} {
+		batch = append(batch, &pendingWrite{data: []byte(w.data), off: w.off, done: make(chan struct{})})
+	}
+	flushWrites(batch, do)
+
+	if want := []string{"3@0", "1@10"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %v, want %v", calls, want)
+	}
+	if got := string(content[:6]) + string(content[10:11]); got != "abcdefk" {
+		t.Errorf("got content %q", got)
+	}
+	for _, w := range batch {
+		select {
+		case <-w.done:
+		default:
+			t.Fatalf("write at %d not completed", w.off)
+		}
+		if w.n != len(w.data) || w.err != nil {
+			t.Errorf("write at %d: got %d, %v", w.off, w.n, w.err)
+		}
+	}
+}
+
+func TestFlushWritesShort(t *testing.T) {
+	// The first call writes 3 of 4 bytes; the rest is retried.
+	var calls []string
+	do := func(bufs [][]byte, off int64) (int, error) {
+		calls = append(calls, fmt.Sprintf("%d@%d", len(bufs), off))
+		if len(calls) == 1 {
+			return 3, nil
+		}
+		return len(bufs[0]), nil
+	}
+	a := &pendingWrite{data: []byte("ab"), off: 0, done: make(chan struct{})}
+	b := &pendingWrite{data: []byte("cd"), off: 2, done: make(chan struct{})}
+	flushWrites([]*pendingWrite{a, b}, do)
+	if want := []string{"2@0", "1@3"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %v, want %v", calls, want)
+	}
+	if a.n != 2 || b.n != 2 {
+		t.Errorf("got %d, %d, want 2, 2", a.n, b.n)
+	}
+}
+
+// The writer in progress flushes one batch, and leaves writes that
+// queue up meanwhile to the next writer.
+func TestWriteBatcherHandOff(t *testing.T) {
+	var b writeBatcher
+	var mu sync.Mutex
+	var calls []string
+	queued := func(n int) {
+		for {
+			b.mu.Lock()
+			l := len(b.pending)
+			b.mu.Unlock()
+			if l >= n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	write := func(name string, off int64, hook func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do := func(bufs [][]byte, off int64) (int, error) {
+				if hook != nil {
+					hook()
+				}
+				mu.Lock()
+				calls = append(calls, fmt.Sprintf("%s:%d@%d", name, len(bufs), off))
+				mu.Unlock()
+				n := 0
+				for _, buf := range bufs {
+					n += len(buf)
+				}
+				return n, nil
+			}
+			if n, err := b.write([]byte("xx"), off, do); n != 2 || err != nil {
+				t.Errorf("write %s: got %d, %v", name, n, err)
+			}
+		}()
+	}
+
+	release := make(chan struct{})
+	nth := 0
+	write("w0", 0, func() {
+		nth++
+		switch nth {
+		case 1:
+			<-release
+		case 2:
+			// While the first batch is written, another
+			// write comes in.
+			write("w3", 20, nil)
+			queued(1)
+		}
+	})
+	for {
+		b.mu.Lock()
+		busy := b.busy
+		b.mu.Unlock()
+		if busy {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	write("w1", 10, nil)
+	write("w2", 12, nil)
+	queued(2)
+	close(release)
+	wg.Wait()
+
+	if want := []string{"w0:1@0", "w0:2@10", "w3:1@20"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %v, want %v", calls, want)
+	}
+	if b.busy || len(b.pending) != 0 {
+		t.Errorf("batcher not idle: busy %v, %d pending", b.busy, len(b.pending))
+	}
+}
+
+func TestLoopbackFileConcurrentWrites(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "file")
+	osf, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewLoopbackFile(osf)
+
+	const n, size = 64, 512
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte('a' + i%26)}, size)
+			if got, code := f.Write(data, int64(i*size)); !code.Ok() || got != size {
+				t.Errorf("Write %d: got %d, %v", i, got, code)
+			}
+		}(i)
+	}
+	wg.Wait()
+	f.Release()
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) != n*size {
+		t.Fatalf("got %d bytes, want %d", len(content), n*size)
+	}
+	for i := 0; i < n; i++ {
+		if want := bytes.Repeat([]byte{byte('a' + i%26)}, size); !bytes.Equal(content[i*size:(i+1)*size], want) {
+			t.Errorf("block %d differs", i)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// WriteBufferOptions tunes NewWriteBufferFile.
+type WriteBufferOptions struct {
+	// MaxBytes is the amount of buffered data that triggers a
+	// flush to the inner file. Default 1M.
+	MaxBytes int
+
+	// MaxExtents is the number of discontiguous ranges that
+	// triggers a flush. Default 64.
+	MaxExtents int
+
+	// If set, buffered data is charged to this limit, as account
+	// "writebuffer". Writes that do not fit go to the inner file
+	// directly, and under memory pressure the buffer is written
+	// back early.
+	Memory *fuse.MemoryLimit
+}
+
+// extent is a range of buffered data.
+type extent struct {
+	off  int64
+	data []byte
+}
+
+func (e *extent) end() int64 {
+	return e.off + int64(len(e.data))
+}
+
+type writeBufferFile struct {
+	File
+	opts WriteBufferOptions
+
+	mu sync.Mutex
+
+	// Buffered writes, sorted by offset, neither overlapping nor
+	// adjacent.
+	extents []extent
+	size    int
+
+	// The first error from writing back, reported by the next
+	// Flush or Fsync.
+	err fuse.Status
+
+	// Set if opts.Memory is.
+	account *fuse.MemoryAccount
+}
+
+// NewWriteBufferFile wraps a File to buffer writes in memory. Adjacent
+// and overlapping writes are merged, and the result is written to f
+// when the buffer fills up, and on Flush, Fsync and Release. Reads and
+// other operations that depend on the file contents write back first.
+//
+// Errors from writing back are returned from the next Flush or Fsync,
+// so close() reports them, as with NFS.
+func NewWriteBufferFile(f File, opts *WriteBufferOptions) File {
+	w := &writeBufferFile{File: f}
+	if opts != nil {
+		w.opts = *opts
+	}
+	if w.opts.MaxBytes <= 0 {
+		w.opts.MaxBytes = 1 << 20
+	}
+	if w.opts.MaxExtents <= 0 {
+		w.opts.MaxExtents = 64
+	}
+	if w.opts.Memory != nil {
+		w.account = w.opts.Memory.Register("writebuffer", w.evict)
+	}
+	return w
+}
+
+func (f *writeBufferFile) InnerFile() File {
+	return f.File
+}
+
+func (f *writeBufferFile) String() string {
+	return fmt.Sprintf("writeBufferFile(%s)", f.File.String())
+}
+
+func (f *writeBufferFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	// Charge before locking, as charging may make other files
+	// write back. Merging can only need less than data.
+	if f.account != nil && !f.account.TryCharge(int64(len(data))) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.writeBack()
+		return f.File.Write(data, off)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	before := f.size
+
+	// Extents [i, j) touch the new data.
+	end := off + int64(len(data))
+	i := sort.Search(len(f.extents), func(k int) bool { return f.extents[k].end() >= off })
+	j := i
+	for j < len(f.extents) && f.extents[j].off <= end {
+		j++
+	}
+
+	merged := extent{off: off}
+	if i < j {
+		merged.off = min(off, f.extents[i].off)
+		end = max(end, f.extents[j-1].end())
+	}
+	merged.data = make([]byte, end-merged.off)
+	for _, e := range f.extents[i:j] {
+		copy(merged.data[e.off-merged.off:], e.data)
+		f.size -= len(e.data)
+	}
+	copy(merged.data[off-merged.off:], data)
+	f.size += len(merged.data)
+	if f.account != nil {
+		f.account.Release(int64(len(data) - (f.size - before)))
+	}
+
+	f.extents = append(f.extents[:i], append([]extent{merged}, f.extents[j:]...)...)
+
+	if f.size >= f.opts.MaxBytes || len(f.extents) > f.opts.MaxExtents {
+		f.writeBack()
+	}
+	return uint32(len(data)), fuse.OK
+}
+
+// writeBack writes the buffered data to the inner file. The caller
+// holds f.mu.
+func (f *writeBufferFile) writeBack() fuse.Status {
+	for _, e := range f.extents {
+		n, code := f.File.Write(e.data, e.off)
+		if code.Ok() && int(n) < len(e.data) {
+			code = fuse.EIO
+		}
+		if !code.Ok() && f.err.Ok() {
+			f.err = code
+		}
+	}
+	if f.account != nil {
+		f.account.Release(int64(f.size))
+	}
+	f.extents = nil
+	f.size = 0
+	return f.err
+}
+
+// evict writes back to free memory for the memory limit. Errors are
+// kept for Flush and Fsync.
+func (f *writeBufferFile) evict(want int64) int64 {
+	if !f.mu.TryLock() {
+		return 0
+	}
+	defer f.mu.Unlock()
+	freed := int64(f.size)
+	f.writeBack()
+	return freed
+}
+
+// sync writes back, and returns the pending error, if any.
+func (f *writeBufferFile) sync() fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code := f.writeBack()
+	f.err = fuse.OK
+	return code
+}
+
+func (f *writeBufferFile) Flush() fuse.Status {
+	if code := f.sync(); !code.Ok() {
+		f.File.Flush()
+		return code
+	}
+	return f.File.Flush()
+}
+
+func (f *writeBufferFile) Fsync(flags int) fuse.Status {
+	if code := f.sync(); !code.Ok() {
+		return code
+	}
+	return f.File.Fsync(flags)
+}
+
+func (f *writeBufferFile) Release() {
+	f.mu.Lock()
+	f.writeBack()
+	f.mu.Unlock()
+	if f.account != nil {
+		f.account.Close()
+	}
+	f.File.Release()
+}
+
+// The operations below see the file contents or size, so they write
+// back first. Errors are kept for Flush and Fsync.
+
+func (f *writeBufferFile) drain() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeBack()
+}
+
+func (f *writeBufferFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.drain()
+	return f.File.Read(buf, off)
+}
+
+func (f *writeBufferFile) GetAttr(out *fuse.Attr) fuse.Status {
+	f.drain()
+	return f.File.GetAttr(out)
+}
+
+func (f *writeBufferFile) Truncate(size uint64) fuse.Status {
+	f.drain()
+	return f.File.Truncate(size)
+}
+
+func (f *writeBufferFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	f.drain()
+	return f.File.Allocate(off, size, mode)
+}
+
+func (f *writeBufferFile) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
+	// Writing back afterwards would clobber the mtime.
+	f.drain()
+	return f.File.Utimens(atime, mtime)
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestWriteBufferFile(t *testing.T) {
+	inner := &recordingFile{File: NewDefaultFile()}
+	f := NewWriteBufferFile(inner, &WriteBufferOptions{MaxBytes: 100})
+
+	for _, w := range []struct {
+		off  int64
+		data string
+	}{
+		{10, "world"},
+		{0, "hello"},
+		{5, "xxxxx"}, // fills the gap, merging everything.
+		{3, "LO"},    // overlaps.
+		{40, "far"},
+	} {
+		if n, code := f.Write([]byte(w.data), w.off); !code.Ok() || int(n) != len(w.data) {
+			t.Fatalf("Write(%q, %d): %d, %v", w.data, w.off, n, code)
+		}
+	}
+	if len(inner.writes) != 0 {
+		t.Fatalf("writes before flush: %v", inner.writes)
+	}
+	if code := f.Flush(); !code.Ok() {
+		t.Fatalf("Flush: %v", code)
+	}
+	if want := []int{15, 3}; !reflect.DeepEqual(inner.writes, want) {
+		t.Errorf("got writes %v, want %v", inner.writes, want)
+	}
+	if got, want := string(inner.data[:15]), "helLOxxxxxworld"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Reaching MaxBytes writes back.
+	inner.writes = nil
+	f.Write(make([]byte, 60), 0)
+	f.Write(make([]byte, 60), 200)
+	if want := []int{60, 60}; !reflect.DeepEqual(inner.writes, want) {
+		t.Errorf("got writes %v, want %v", inner.writes, want)
+	}
+
+	// Reads see buffered data.
+	f.Write([]byte("new"), 0)
+	buf := make([]byte, 3)
+	res, _ := f.Read(buf, 0)
+	if b, _ := res.Bytes(buf); string(b) != "new" {
+		t.Errorf("Read: got %q", b)
+	}
+}
+
+type failingFile struct {
+	File
+}
+
+func (f *failingFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	return 0, fuse.EIO
+}
+
+func (f *failingFile) Fsync(flags int) fuse.Status {
+	return fuse.OK
+}
+
+func TestWriteBufferFileError(t *testing.T) {
+	f := NewWriteBufferFile(&failingFile{NewDefaultFile()}, nil)
+	if _, code := f.Write([]byte("data"), 0); !code.Ok() {
+		t.Fatalf("Write: %v", code)
+	}
+	if code := f.Fsync(0); code != fuse.EIO {
+		t.Errorf("Fsync: got %v, want EIO", code)
+	}
+	if code := f.Fsync(0); !code.Ok() {
+		t.Errorf("second Fsync: got %v, want OK", code)
+	}
+}
+
+func TestWriteBufferFileMemory(t *testing.T) {
+	l := fuse.NewMemoryLimit(100)
+	inner := &recordingFile{File: NewDefaultFile()}
+	f := NewWriteBufferFile(inner, &WriteBufferOptions{Memory: l})
+
+	f.Write(make([]byte, 60), 0)
+	if got := l.Stats().Accounts["writebuffer"]; got != 60 {
+		t.Errorf("charged %d, want 60", got)
+	}
+	f.Write(make([]byte, 10), 55)
+	if got := l.Stats().Accounts["writebuffer"]; got != 65 {
+		t.Errorf("charged %d after overlapping write, want 65", got)
+	}
+
+	// Another user of the limit makes the buffer write back.
+	other := l.Register("other", nil)
+	if !other.TryCharge(80) {
+		t.Fatal("TryCharge refused")
+	}
+	if want := []int{65}; !reflect.DeepEqual(inner.writes, want) {
+		t.Errorf("got writes %v, want %v", inner.writes, want)
+	}
+
+	// Writes that do not fit go through.
+	f.Write(make([]byte, 30), 100)
+	if want := []int{65, 30}; !reflect.DeepEqual(inner.writes, want) {
+		t.Errorf("got writes %v, want %v", inner.writes, want)
+	}
+	other.Close()
+
+	f.Write(make([]byte, 30), 100)
+	f.Release()
+	if s := l.Stats(); s.Used != 0 || len(s.Accounts) != 0 {
+		t.Errorf("after Release: %+v", s)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// nodeSerializer runs requests for the same node one at a time, in
+// the order they were read, while requests for different nodes run
+// in parallel. See MountOptions.SerializeNodes.
+type nodeSerializer struct {
+	mu sync.Mutex
+
+	// tails holds, for each node, a channel that is closed when
+	// the last queued request for it is done.
+	tails map[uint64]chan struct{}
+}
+
+func newNodeSerializer() *nodeSerializer {
+	return &nodeSerializer{tails: map[uint64]chan struct{}{}}
+}
+
+// nodeTicket is a request's place in the queues of its nodes.
+type nodeTicket struct {
+	ids  []uint64
+	wait []chan struct{}
+	done chan struct{}
+}
+
+// serializedNodes returns the nodes a request must be serialized on,
+// reading them straight from the input.
+func serializedNodes(in []byte) []uint64 {
+	if len(in) < int(unsafe.Sizeof(InHeader{})) {
+		return nil
+	}
+	h := (*InHeader)(unsafe.Pointer(&in[0]))
+	switch h.Opcode {
+	case _OP_FORGET, _OP_BATCH_FORGET, _OP_INTERRUPT, _OP_INIT, _OP_DESTROY:
+		return nil
+	case _OP_SETLKW:
+		// Waits for other requests to release a lock.
+		return nil
+	case _OP_RENAME:
+		if len(in) >= int(unsafe.Sizeof(RenameIn{})) {
+			newDir := (*RenameIn)(unsafe.Pointer(&in[0])).Newdir
+			if newDir != h.NodeId {
+				return []uint64{h.NodeId, newDir}
+			}
+		}
+	case _OP_LINK:
+		if len(in) >= int(unsafe.Sizeof(LinkIn{})) {
+			return []uint64{h.NodeId, (*LinkIn)(unsafe.Pointer(&in[0])).Oldnodeid}
+		}
+	}
+	return []uint64{h.NodeId}
+}
+
+// enqueue puts the request with input in on the queues of its
+// nodes. Calls must be made in the order requests were read.
+func (s *nodeSerializer) enqueue(in []byte) *nodeTicket {
+	ids := serializedNodes(in)
+	if len(ids) == 0 {
+		return nil
+	}
+	t := &nodeTicket{ids: ids, done: make(chan struct{})}
+	s.mu.Lock()
+	for _, id := range ids {
+		if prev := s.tails[id]; prev != nil {
+			t.wait = append(t.wait, prev)
+		}
+		s.tails[id] = t.done
+	}
+	s.mu.Unlock()
+	return t
+}
+
+// acquire waits until the earlier requests for the nodes are done.
+func (s *nodeSerializer) acquire(t *nodeTicket) {
+	for _, c := range t.wait {
+		<-c
+	}
+}
+
+// release lets the next request for the nodes go ahead.
+func (s *nodeSerializer) release(t *nodeTicket) {
+	s.mu.Lock()
+	for _, id := range t.ids {
+		if s.tails[id] == t.done {
+			delete(s.tails, id)
+		}
+	}
+	s.mu.Unlock()
+	close(t.done)
+}
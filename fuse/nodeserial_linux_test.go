@@ -0,0 +1,59 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// concurrencyFS tracks how many GetAttr calls run at once.
+type concurrencyFS struct {
+	fuse.RawFileSystem
+	gate *gate
+}
+
+func (fs *concurrencyFS) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	fs.gate.enter()
+	defer fs.gate.leave()
+
+	// Give other requests on the node the chance to overlap.
+	time.Sleep(time.Millisecond)
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func TestSerializeNodes(t *testing.T) {
+	fs := &concurrencyFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		gate:          newGate(false),
+	}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{SerializeNodes: true, MaxReaders: 4})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, code := k.GetAttr(fuse.FUSE_ROOT_ID); !code.Ok() {
+				t.Errorf("GetAttr: %v", code)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := fs.gate.Max(); got != 1 {
+		t.Errorf("got %d concurrent GetAttr calls on one node, want 1", got)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func serialInput(op int32, node uint64) []byte {
+	in := make([]byte, unsafe.Sizeof(RenameIn{}))
+	h := (*InHeader)(unsafe.Pointer(&in[0]))
+	h.Opcode = op
+	h.NodeId = node
+	return in
+}
+
+func TestNodeSerializerOrder(t *testing.T) {
+	s := newNodeSerializer()
+	var tickets []*nodeTicket
+	for i := 0; i < 5; i++ {
+		tickets = append(tickets, s.enqueue(serialInput(_OP_WRITE, 5)))
+	}
+	other := s.enqueue(serialInput(_OP_WRITE, 6))
+	if s.enqueue(serialInput(_OP_SETLKW, 5)) != nil {
+		t.Errorf("SETLKW was queued")
+	}
+
+	// A different node does not wait.
+	s.acquire(other)
+	s.release(other)
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := len(tickets) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.acquire(tickets[i])
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			s.release(tickets[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("got order %v", order)
+		}
+	}
+	if len(s.tails) != 0 {
+		t.Errorf("queues not cleaned up: %v", s.tails)
+	}
+}
+
+func TestNodeSerializerRename(t *testing.T) {
+	in := serialInput(_OP_RENAME, 1)
+	(*RenameIn)(unsafe.Pointer(&in[0])).Newdir = 7
+	if ids := serializedNodes(in); len(ids) != 2 || ids[0] != 1 || ids[1] != 7 {
+		t.Errorf("got %v, want [1 7]", ids)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// statelessFS needs no handles, and counts the opens and releases
+// it sees.
+type statelessFS struct {
+	fuse.RawFileSystem
+
+	mu       sync.Mutex
+	opens    int
+	releases int
+}
+
+func (fs *statelessFS) count(n *int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	*n++
+}
+
+func (fs *statelessFS) Open(input *fuse.OpenIn, out *fuse.OpenOut) fuse.Status {
+	fs.count(&fs.opens)
+	return fuse.NO_OPEN
+}
+
+func (fs *statelessFS) OpenDir(input *fuse.OpenIn, out *fuse.OpenOut) fuse.Status {
+	fs.count(&fs.opens)
+	return fuse.NO_OPEN
+}
+
+func (fs *statelessFS) Release(input *fuse.ReleaseIn) {
+	fs.count(&fs.releases)
+}
+
+func (fs *statelessFS) ReleaseDir(input *fuse.ReleaseIn) {
+	fs.count(&fs.releases)
+}
+
+func (fs *statelessFS) Read(input *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
+	return fuse.ReadResultData([]byte("stateless")), fuse.OK
+}
+
+func TestNoOpen(t *testing.T) {
+	fs := &statelessFS{RawFileSystem: fuse.NewDefaultRawFileSystem()}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{Deterministic: true, EnableNoOpen: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	want := uint32(fuse.CAP_NO_OPEN_SUPPORT | fuse.CAP_NO_OPENDIR_SUPPORT)
+	if got := k.InitOut().Flags & want; got != want {
+		t.Errorf("got INIT flags %x, want %x set", k.InitOut().Flags, want)
+	}
+
+	for i := 0; i < 2; i++ {
+		out, code := k.Open(fuse.FUSE_ROOT_ID, 0)
+		if !code.Ok() {
+			t.Fatalf("Open: %v", code)
+		}
+		data, code := k.Read(fuse.FUSE_ROOT_ID, out.Fh, 0, 100)
+		if !code.Ok() || string(data) != "stateless" {
+			t.Errorf("Read: got %q, %v", data, code)
+		}
+		if code := k.Release(fuse.FUSE_ROOT_ID, out.Fh); !code.Ok() {
+			t.Errorf("Release: %v", code)
+		}
+
+		out, code = k.OpenDir(fuse.FUSE_ROOT_ID)
+		if !code.Ok() {
+			t.Fatalf("OpenDir: %v", code)
+		}
+		if code := k.ReleaseDir(fuse.FUSE_ROOT_ID, out.Fh); !code.Ok() {
+			t.Errorf("ReleaseDir: %v", code)
+		}
+	}
+
+	// Make sure the server is done with everything sent so far.
+	k.GetAttr(fuse.FUSE_ROOT_ID)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.opens != 2 || fs.releases != 0 {
+		t.Errorf("got %d opens, %d releases, want 2 and 0", fs.opens, fs.releases)
+	}
+}
+
+// Without EnableNoOpen, an unimplemented OpenDir stays an error, and
+// does not switch the kernel to opening without handles.
+func TestNoOpenOptIn(t *testing.T) {
+	k, err := fakekernel.New(fuse.NewDefaultRawFileSystem(), &fuse.MountOptions{Deterministic: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	if flags := k.InitOut().Flags; flags&(fuse.CAP_NO_OPEN_SUPPORT|fuse.CAP_NO_OPENDIR_SUPPORT) != 0 {
+		t.Errorf("got INIT flags %x, want no NO_OPEN caps", flags)
+	}
+	for i := 0; i < 2; i++ {
+		if _, code := k.OpenDir(fuse.FUSE_ROOT_ID); code != fuse.ENOSYS {
+			t.Errorf("OpenDir: got %v, want ENOSYS", code)
+		}
+	}
+}
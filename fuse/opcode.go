@@ -70,12 +70,12 @@ const (
 func doInit(server *Server, req *request) {
 	input := (*InitIn)(req.inData)
 	if input.Major != _FUSE_KERNEL_VERSION {
-		log.Printf("Major versions does not match. Given %d, want %d\n", input.Major, _FUSE_KERNEL_VERSION)
+		server.opts.Logger.Printf("Major versions does not match. Given %d, want %d\n", input.Major, _FUSE_KERNEL_VERSION)
 		req.status = EIO
 		return
 	}
 	if input.Minor < _MINIMUM_MINOR_VERSION {
-		log.Printf("Minor version is less than we support. Given %d, want at least %d\n", input.Minor, _MINIMUM_MINOR_VERSION)
+		server.opts.Logger.Printf("Minor version is less than we support. Given %d, want at least %d\n", input.Minor, _MINIMUM_MINOR_VERSION)
 		req.status = EIO
 		return
 	}
@@ -83,7 +83,10 @@ func doInit(server *Server, req *request) {
 	server.reqMu.Lock()
 	server.kernelSettings = *input
 	server.kernelSettings.Flags = input.Flags & (CAP_ASYNC_READ | CAP_BIG_WRITES | CAP_FILE_OPS |
-		CAP_AUTO_INVAL_DATA | CAP_READDIRPLUS | CAP_NO_OPEN_SUPPORT)
+		CAP_AUTO_INVAL_DATA | CAP_READDIRPLUS)
+	if server.opts.EnableNoOpen {
+		server.kernelSettings.Flags |= input.Flags & (CAP_NO_OPEN_SUPPORT | CAP_NO_OPENDIR_SUPPORT)
+	}
 
 	if server.opts.EnableLocks {
 		server.kernelSettings.Flags |= CAP_FLOCK_LOCKS | CAP_POSIX_LOCKS
@@ -112,6 +115,21 @@ func doInit(server *Server, req *request) {
 		out.Minor = input.Minor
 	}
 
+	server.reqMu.Lock()
+	server.connInfo = ConnectionInfo{
+		KernelMajor:         input.Major,
+		KernelMinor:         input.Minor,
+		Major:               out.Major,
+		Minor:               out.Minor,
+		KernelFlags:         input.Flags,
+		Flags:               out.Flags,
+		MaxWrite:            out.MaxWrite,
+		MaxReadAhead:        out.MaxReadAhead,
+		MaxBackground:       out.MaxBackground,
+		CongestionThreshold: out.CongestionThreshold,
+	}
+	server.reqMu.Unlock()
+
 	if out.Minor <= 22 {
 		tweaked := *req.handler
 
@@ -125,7 +143,7 @@ func doInit(server *Server, req *request) {
 
 func doOpen(server *Server, req *request) {
 	out := (*OpenOut)(req.outData())
-	status := server.fileSystem.Open((*OpenIn)(req.inData), out)
+	status := req.fs.Open((*OpenIn)(req.inData), out)
 	req.status = status
 	if status != OK {
 		return
@@ -134,43 +152,72 @@ func doOpen(server *Server, req *request) {
 
 func doCreate(server *Server, req *request) {
 	out := (*CreateOut)(req.outData())
-	status := server.fileSystem.Create((*CreateIn)(req.inData), req.filenames[0], out)
+	status := req.fs.Create((*CreateIn)(req.inData), req.filenames[0], out)
 	req.status = status
 }
 
 func doReadDir(server *Server, req *request) {
 	in := (*ReadIn)(req.inData)
+	if !checkReadSize(server, req, in.Size) {
+		return
+	}
 	buf := server.allocOut(req, in.Size)
 	out := NewDirEntryList(buf, uint64(in.Offset))
 
-	code := server.fileSystem.ReadDir(in, out)
+	code := req.fs.ReadDir(in, out)
 	req.flatData = out.bytes()
 	req.status = code
 }
 
 func doReadDirPlus(server *Server, req *request) {
 	in := (*ReadIn)(req.inData)
+	if !checkReadSize(server, req, in.Size) {
+		return
+	}
 	buf := server.allocOut(req, in.Size)
 	out := NewDirEntryList(buf, uint64(in.Offset))
 
-	code := server.fileSystem.ReadDirPlus(in, out)
+	code := req.fs.ReadDirPlus(in, out)
 	req.flatData = out.bytes()
 	req.status = code
 }
 
+// checkReadSize rejects sizes the kernel would never ask for, so
+// malformed requests don't make us allocate gigabytes.
+func checkReadSize(server *Server, req *request, size uint32) bool {
+	if size > MAX_KERNEL_WRITE {
+		server.opts.Logger.Printf("%v: size %d too large", operationName(req.inHeader.Opcode), size)
+		req.status = EINVAL
+		return false
+	}
+	return true
+}
+
 func doOpenDir(server *Server, req *request) {
 	out := (*OpenOut)(req.outData())
-	status := server.fileSystem.OpenDir((*OpenIn)(req.inData), out)
+	status := req.fs.OpenDir((*OpenIn)(req.inData), out)
 	req.status = status
 }
 
 func doSetattr(server *Server, req *request) {
 	out := (*AttrOut)(req.outData())
-	req.status = server.fileSystem.SetAttr((*SetAttrIn)(req.inData), out)
+	req.status = req.fs.SetAttr((*SetAttrIn)(req.inData), out)
 }
 
 func doWrite(server *Server, req *request) {
-	n, status := server.fileSystem.Write((*WriteIn)(req.inData), req.arg)
+	var n uint32
+	var status Status
+	in := (*WriteIn)(req.inData)
+	if w, ok := req.fs.(SegmentWriter); ok {
+		n, status = w.WriteSegments(in, req.writeData())
+	} else if len(req.payload) == 0 {
+		n, status = req.fs.Write(in, req.arg)
+	} else {
+		data := req.writeData()
+		buf := server.opts.Buffers.AllocBuffer(uint32(data.Len()))
+		n, status = req.fs.Write(in, data.Bytes(buf))
+		server.opts.Buffers.FreeBuffer(buf)
+	}
 	o := (*WriteOut)(req.outData())
 	o.Size = n
 	req.status = status
@@ -204,14 +251,14 @@ func doGetXAttr(server *Server, req *request) {
 			// TODO(hanwen): double check this. For getxattr, input.Size
 			// field refers to the size of the attribute, so it usually
 			// is not 0.
-			sz, code := server.fileSystem.GetXAttrSize(req.inHeader, req.filenames[0])
+			sz, code := req.fs.GetXAttrSize(req.inHeader, req.filenames[0])
 			if code.Ok() {
 				out.Size = uint32(sz)
 			}
 			req.status = code
 			return
 		case _OP_LISTXATTR:
-			data, code := server.fileSystem.ListXAttr(req.inHeader)
+			data, code := req.fs.ListXAttr(req.inHeader)
 			if code.Ok() {
 				out.Size = uint32(len(data))
 			}
@@ -222,9 +269,9 @@ func doGetXAttr(server *Server, req *request) {
 	var data []byte
 	switch req.inHeader.Opcode {
 	case _OP_GETXATTR:
-		data, req.status = server.fileSystem.GetXAttrData(req.inHeader, req.filenames[0])
+		data, req.status = req.fs.GetXAttrData(req.inHeader, req.filenames[0])
 	case _OP_LISTXATTR:
-		data, req.status = server.fileSystem.ListXAttr(req.inHeader)
+		data, req.status = req.fs.ListXAttr(req.inHeader)
 	default:
 		log.Panicf("xattr opcode %v", req.inHeader.Opcode)
 		req.status = ENOSYS
@@ -243,14 +290,14 @@ func doGetXAttr(server *Server, req *request) {
 
 func doGetAttr(server *Server, req *request) {
 	out := (*AttrOut)(req.outData())
-	s := server.fileSystem.GetAttr((*GetAttrIn)(req.inData), out)
+	s := req.fs.GetAttr((*GetAttrIn)(req.inData), out)
 	req.status = s
 }
 
 // doForget - forget one NodeId
 func doForget(server *Server, req *request) {
 	if !server.opts.RememberInodes {
-		server.fileSystem.Forget(req.inHeader.NodeId, (*ForgetIn)(req.inData).Nlookup)
+		req.fs.Forget(req.inHeader.NodeId, (*ForgetIn)(req.inData).Nlookup)
 	}
 }
 
@@ -258,122 +305,138 @@ func doForget(server *Server, req *request) {
 func doBatchForget(server *Server, req *request) {
 	in := (*_BatchForgetIn)(req.inData)
 	wantBytes := uintptr(in.Count) * unsafe.Sizeof(_ForgetOne{})
+	count := int(in.Count)
 	if uintptr(len(req.arg)) < wantBytes {
-		// We have no return value to complain, so log an error.
-		log.Printf("Too few bytes for batch forget. Got %d bytes, want %d (%d entries)",
+		// We have no return value to complain, so log an error,
+		// and only process the entries we have.
+		server.opts.Logger.Printf("Too few bytes for batch forget. Got %d bytes, want %d (%d entries)",
 			len(req.arg), wantBytes, in.Count)
+		count = len(req.arg) / int(unsafe.Sizeof(_ForgetOne{}))
+	}
+	if count == 0 {
+		return
 	}
 
 	h := &reflect.SliceHeader{
 		Data: uintptr(unsafe.Pointer(&req.arg[0])),
-		Len:  int(in.Count),
-		Cap:  int(in.Count),
+		Len:  count,
+		Cap:  count,
 	}
 
 	forgets := *(*[]_ForgetOne)(unsafe.Pointer(h))
 	for i, f := range forgets {
-		if server.opts.Debug {
-			log.Printf("doBatchForget: forgetting %d of %d: NodeId: %d, Nlookup: %d", i+1, len(forgets), f.NodeId, f.Nlookup)
+		if server.debug.Load() {
+			server.opts.Logger.Printf("doBatchForget: forgetting %d of %d: NodeId: %d, Nlookup: %d", i+1, len(forgets), f.NodeId, f.Nlookup)
 		}
 		if f.NodeId == pollHackInode {
 			continue
 		}
-		server.fileSystem.Forget(f.NodeId, f.Nlookup)
+		req.fs.Forget(f.NodeId, f.Nlookup)
 	}
 }
 
 func doReadlink(server *Server, req *request) {
-	req.flatData, req.status = server.fileSystem.Readlink(req.inHeader)
+	req.flatData, req.status = req.fs.Readlink(req.inHeader)
 }
 
 func doLookup(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
-	s := server.fileSystem.Lookup(req.inHeader, req.filenames[0], out)
+	s := req.fs.Lookup(req.inHeader, req.filenames[0], out)
 	req.status = s
 }
 
 func doMknod(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
 
-	req.status = server.fileSystem.Mknod((*MknodIn)(req.inData), req.filenames[0], out)
+	req.status = req.fs.Mknod((*MknodIn)(req.inData), req.filenames[0], out)
 }
 
 func doMkdir(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
-	req.status = server.fileSystem.Mkdir((*MkdirIn)(req.inData), req.filenames[0], out)
+	req.status = req.fs.Mkdir((*MkdirIn)(req.inData), req.filenames[0], out)
 }
 
 func doUnlink(server *Server, req *request) {
-	req.status = server.fileSystem.Unlink(req.inHeader, req.filenames[0])
+	req.status = req.fs.Unlink(req.inHeader, req.filenames[0])
 }
 
 func doRmdir(server *Server, req *request) {
-	req.status = server.fileSystem.Rmdir(req.inHeader, req.filenames[0])
+	req.status = req.fs.Rmdir(req.inHeader, req.filenames[0])
 }
 
 func doLink(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
-	req.status = server.fileSystem.Link((*LinkIn)(req.inData), req.filenames[0], out)
+	req.status = req.fs.Link((*LinkIn)(req.inData), req.filenames[0], out)
 }
 
 func doRead(server *Server, req *request) {
 	in := (*ReadIn)(req.inData)
+	if !checkReadSize(server, req, in.Size) {
+		return
+	}
 	buf := server.allocOut(req, in.Size)
 
-	req.readResult, req.status = server.fileSystem.Read(in, buf)
+	req.readResult, req.status = req.fs.Read(in, buf)
 	if fd, ok := req.readResult.(*readResultFd); ok {
+		if fd.Sz > int(in.Size) {
+			fd.Sz = int(in.Size)
+		}
 		req.fdData = fd
 		req.flatData = nil
 	} else if req.readResult != nil && req.status.Ok() {
 		req.flatData, req.status = req.readResult.Bytes(buf)
+		// The kernel has no room for more than it asked for.
+		if len(req.flatData) > int(in.Size) {
+			req.flatData = req.flatData[:in.Size]
+		}
 	}
 }
 
 func doFlush(server *Server, req *request) {
-	req.status = server.fileSystem.Flush((*FlushIn)(req.inData))
+	req.status = req.fs.Flush((*FlushIn)(req.inData))
 }
 
 func doRelease(server *Server, req *request) {
-	server.fileSystem.Release((*ReleaseIn)(req.inData))
+	req.fs.Release((*ReleaseIn)(req.inData))
 }
 
 func doFsync(server *Server, req *request) {
-	req.status = server.fileSystem.Fsync((*FsyncIn)(req.inData))
+	req.status = req.fs.Fsync((*FsyncIn)(req.inData))
 }
 
 func doReleaseDir(server *Server, req *request) {
-	server.fileSystem.ReleaseDir((*ReleaseIn)(req.inData))
+	req.fs.ReleaseDir((*ReleaseIn)(req.inData))
 }
 
 func doFsyncDir(server *Server, req *request) {
-	req.status = server.fileSystem.FsyncDir((*FsyncIn)(req.inData))
+	req.status = req.fs.FsyncDir((*FsyncIn)(req.inData))
 }
 
 func doSetXAttr(server *Server, req *request) {
 	splits := bytes.SplitN(req.arg, []byte{0}, 2)
-	req.status = server.fileSystem.SetXAttr((*SetXAttrIn)(req.inData), string(splits[0]), splits[1])
+	req.status = req.fs.SetXAttr((*SetXAttrIn)(req.inData), string(splits[0]), splits[1])
 }
 
 func doRemoveXAttr(server *Server, req *request) {
-	req.status = server.fileSystem.RemoveXAttr(req.inHeader, req.filenames[0])
+	req.status = req.fs.RemoveXAttr(req.inHeader, req.filenames[0])
 }
 
 func doAccess(server *Server, req *request) {
-	req.status = server.fileSystem.Access((*AccessIn)(req.inData))
+	req.status = req.fs.Access((*AccessIn)(req.inData))
 }
 
 func doSymlink(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
-	req.status = server.fileSystem.Symlink(req.inHeader, req.filenames[1], req.filenames[0], out)
+	req.status = req.fs.Symlink(req.inHeader, req.filenames[1], req.filenames[0], out)
 }
 
 func doRename(server *Server, req *request) {
-	req.status = server.fileSystem.Rename((*RenameIn)(req.inData), req.filenames[0], req.filenames[1])
+	req.status = req.fs.Rename((*RenameIn)(req.inData), req.filenames[0], req.filenames[1])
 }
 
 func doStatFs(server *Server, req *request) {
 	out := (*StatfsOut)(req.outData())
-	req.status = server.fileSystem.StatFs(req.inHeader, out)
+	req.status = req.fs.StatFs(req.inHeader, out)
 	if req.status == ENOSYS && runtime.GOOS == "darwin" {
 		// OSX FUSE requires Statfs to be implemented for the
 		// mount to succeed.
@@ -391,19 +454,23 @@ func doDestroy(server *Server, req *request) {
 }
 
 func doFallocate(server *Server, req *request) {
-	req.status = server.fileSystem.Fallocate((*FallocateIn)(req.inData))
+	req.status = req.fs.Fallocate((*FallocateIn)(req.inData))
+}
+
+func doBmap(server *Server, req *request) {
+	req.status = req.fs.Bmap((*BmapIn)(req.inData), (*BmapOut)(req.outData()))
 }
 
 func doGetLk(server *Server, req *request) {
-	req.status = server.fileSystem.GetLk((*LkIn)(req.inData), (*LkOut)(req.outData()))
+	req.status = req.fs.GetLk((*LkIn)(req.inData), (*LkOut)(req.outData()))
 }
 
 func doSetLk(server *Server, req *request) {
-	req.status = server.fileSystem.SetLk((*LkIn)(req.inData))
+	req.status = req.fs.SetLk((*LkIn)(req.inData))
 }
 
 func doSetLkw(server *Server, req *request) {
-	req.status = server.fileSystem.SetLkw((*LkIn)(req.inData))
+	req.status = req.fs.SetLkw((*LkIn)(req.inData))
 }
 
 ////////////////////////////////////////////////////////////////
@@ -432,8 +499,42 @@ func operationName(op int32) string {
 	return h.Name
 }
 
+// OpcodeLayout describes the wire format of a request type, as the
+// server decodes its requests and encodes its replies.
+type OpcodeLayout struct {
+	Name string
+
+	// Pointer types of the fixed size input and reply structs,
+	// eg. *ReadIn, or nil if there is none. Input structs start
+	// with the InHeader. GETXATTR and LISTXATTR reply with a
+	// GetXAttrOut or with data, depending on the input; Out is
+	// nil for them.
+	In, Out reflect.Type
+
+	// Number of NUL-terminated names after the input struct.
+	Names int
+}
+
+// LookupOpcode returns the layout of the requests with opcode op,
+// and false if the server does not handle op. This is the layout
+// table the server uses; the protocol package builds on it.
+func LookupOpcode(op int32) (OpcodeLayout, bool) {
+	h := getHandler(op)
+	if h == nil || h.Func == nil {
+		return OpcodeLayout{}, false
+	}
+	l := OpcodeLayout{Name: h.Name, Names: h.FileNames}
+	if h.DecodeIn != nil {
+		l.In = reflect.TypeOf(h.DecodeIn(nil))
+	}
+	if h.DecodeOut != nil {
+		l.Out = reflect.TypeOf(h.DecodeOut(nil))
+	}
+	return l, true
+}
+
 func getHandler(o int32) *operationHandler {
-	if o >= _OPCODE_COUNT {
+	if o < 0 || o >= _OPCODE_COUNT {
 		return nil
 	}
 	return operationHandlers[o]
@@ -479,7 +580,7 @@ func init() {
 		_OP_ACCESS:       unsafe.Sizeof(AccessIn{}),
 		_OP_CREATE:       unsafe.Sizeof(CreateIn{}),
 		_OP_INTERRUPT:    unsafe.Sizeof(InterruptIn{}),
-		_OP_BMAP:         unsafe.Sizeof(_BmapIn{}),
+		_OP_BMAP:         unsafe.Sizeof(BmapIn{}),
 		_OP_IOCTL:        unsafe.Sizeof(_IoctlIn{}),
 		_OP_POLL:         unsafe.Sizeof(_PollIn{}),
 		_OP_FALLOCATE:    unsafe.Sizeof(FallocateIn{}),
@@ -505,7 +606,7 @@ func init() {
 		_OP_OPENDIR:       unsafe.Sizeof(OpenOut{}),
 		_OP_GETLK:         unsafe.Sizeof(LkOut{}),
 		_OP_CREATE:        unsafe.Sizeof(CreateOut{}),
-		_OP_BMAP:          unsafe.Sizeof(_BmapOut{}),
+		_OP_BMAP:          unsafe.Sizeof(BmapOut{}),
 		_OP_IOCTL:         unsafe.Sizeof(_IoctlOut{}),
 		_OP_POLL:          unsafe.Sizeof(_PollOut{}),
 		_OP_NOTIFY_ENTRY:  unsafe.Sizeof(NotifyInvalEntryOut{}),
@@ -576,6 +677,7 @@ func init() {
 		_OP_GETATTR:      doGetAttr,
 		_OP_FORGET:       doForget,
 		_OP_BATCH_FORGET: doBatchForget,
+		_OP_INTERRUPT:    doInterrupt,
 		_OP_READLINK:     doReadlink,
 		_OP_INIT:         doInit,
 		_OP_LOOKUP:       doLookup,
@@ -602,6 +704,7 @@ func init() {
 		_OP_IOCTL:        doIoctl,
 		_OP_DESTROY:      doDestroy,
 		_OP_FALLOCATE:    doFallocate,
+		_OP_BMAP:         doBmap,
 		_OP_READDIRPLUS:  doReadDirPlus,
 	} {
 		operationHandlers[op].Func = v
@@ -618,12 +721,15 @@ func init() {
 		_OP_SETATTR:       func(ptr unsafe.Pointer) interface{} { return (*AttrOut)(ptr) },
 		_OP_INIT:          func(ptr unsafe.Pointer) interface{} { return (*InitOut)(ptr) },
 		_OP_MKDIR:         func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
+		_OP_MKNOD:         func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
 		_OP_NOTIFY_ENTRY:  func(ptr unsafe.Pointer) interface{} { return (*NotifyInvalEntryOut)(ptr) },
 		_OP_NOTIFY_INODE:  func(ptr unsafe.Pointer) interface{} { return (*NotifyInvalInodeOut)(ptr) },
 		_OP_NOTIFY_DELETE: func(ptr unsafe.Pointer) interface{} { return (*NotifyInvalDeleteOut)(ptr) },
 		_OP_STATFS:        func(ptr unsafe.Pointer) interface{} { return (*StatfsOut)(ptr) },
 		_OP_SYMLINK:       func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
 		_OP_GETLK:         func(ptr unsafe.Pointer) interface{} { return (*LkOut)(ptr) },
+		_OP_WRITE:         func(ptr unsafe.Pointer) interface{} { return (*WriteOut)(ptr) },
+		_OP_BMAP:          func(ptr unsafe.Pointer) interface{} { return (*BmapOut)(ptr) },
 	} {
 		operationHandlers[op].DecodeOut = f
 	}
@@ -651,11 +757,17 @@ func init() {
 		_OP_RELEASE:      func(ptr unsafe.Pointer) interface{} { return (*ReleaseIn)(ptr) },
 		_OP_RELEASEDIR:   func(ptr unsafe.Pointer) interface{} { return (*ReleaseIn)(ptr) },
 		_OP_FALLOCATE:    func(ptr unsafe.Pointer) interface{} { return (*FallocateIn)(ptr) },
+		_OP_BMAP:         func(ptr unsafe.Pointer) interface{} { return (*BmapIn)(ptr) },
 		_OP_READDIRPLUS:  func(ptr unsafe.Pointer) interface{} { return (*ReadIn)(ptr) },
 		_OP_RENAME:       func(ptr unsafe.Pointer) interface{} { return (*RenameIn)(ptr) },
 		_OP_GETLK:        func(ptr unsafe.Pointer) interface{} { return (*LkIn)(ptr) },
 		_OP_SETLK:        func(ptr unsafe.Pointer) interface{} { return (*LkIn)(ptr) },
 		_OP_SETLKW:       func(ptr unsafe.Pointer) interface{} { return (*LkIn)(ptr) },
+		_OP_OPENDIR:      func(ptr unsafe.Pointer) interface{} { return (*OpenIn)(ptr) },
+		_OP_WRITE:        func(ptr unsafe.Pointer) interface{} { return (*WriteIn)(ptr) },
+		_OP_FSYNC:        func(ptr unsafe.Pointer) interface{} { return (*FsyncIn)(ptr) },
+		_OP_FSYNCDIR:     func(ptr unsafe.Pointer) interface{} { return (*FsyncIn)(ptr) },
+		_OP_INTERRUPT:    func(ptr unsafe.Pointer) interface{} { return (*InterruptIn)(ptr) },
 	} {
 		operationHandlers[op].DecodeIn = f
 	}
@@ -684,5 +796,13 @@ func init() {
 		if h.OutputSize+sizeOfOutHeader > unsafe.Sizeof(r.outBuf) {
 			log.Panicf("request output buffer too small: code %v, sz %d + %d %v", code, h.OutputSize, sizeOfOutHeader, h)
 		}
+		// The types for decoding and the sizes must agree, as
+		// LookupOpcode reports the former.
+		if h.DecodeIn != nil && h.InputSize != reflect.TypeOf(h.DecodeIn(nil)).Elem().Size() {
+			log.Panicf("input type and size of %s disagree", h.Name)
+		}
+		if h.DecodeOut != nil && h.OutputSize != reflect.TypeOf(h.DecodeOut(nil)).Elem().Size() {
+			log.Panicf("output type and size of %s disagree", h.Name)
+		}
 	}
 }
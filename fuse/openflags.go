@@ -0,0 +1,75 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"os"
+	"syscall"
+)
+
+// OpenFlags is the flags word of an OPEN or CREATE request, which is
+// passed unchanged as the flags argument of Open and Create. It holds
+// the flags given to open(2), except for O_CREAT, O_EXCL and O_NOCTTY,
+// which the kernel handles for OPEN, and O_TRUNC, which the kernel
+// turns into a SETATTR. CREATE has O_EXCL if the caller asked for it.
+type OpenFlags uint32
+
+// AccessMode returns O_RDONLY, O_WRONLY or O_RDWR.
+func (f OpenFlags) AccessMode() uint32 {
+	return uint32(f) & syscall.O_ACCMODE
+}
+
+// Readable says whether the file is opened for reading.
+func (f OpenFlags) Readable() bool {
+	return f.AccessMode() != syscall.O_WRONLY
+}
+
+// Writable says whether the file is opened for writing.
+func (f OpenFlags) Writable() bool {
+	return f.AccessMode() != syscall.O_RDONLY
+}
+
+// Append says whether writes should go to the end of the file,
+// whatever offset they carry.
+func (f OpenFlags) Append() bool {
+	return f&OpenFlags(os.O_APPEND) != 0
+}
+
+// Exclusive says whether Create should fail with EEXIST if the file
+// exists.
+func (f OpenFlags) Exclusive() bool {
+	return f&OpenFlags(os.O_EXCL) != 0
+}
+
+// Truncate says whether the file should be truncated on open.
+func (f OpenFlags) Truncate() bool {
+	return f&OpenFlags(os.O_TRUNC) != 0
+}
+
+// NoAtime says whether reads should leave the access time alone.
+func (f OpenFlags) NoAtime() bool {
+	return syscall_O_NOATIME != 0 && f&syscall_O_NOATIME != 0
+}
+
+// Direct says whether I/O should bypass the page cache (O_DIRECT).
+func (f OpenFlags) Direct() bool {
+	return syscall_O_DIRECT != 0 && f&syscall_O_DIRECT != 0
+}
+
+// Sync says whether writes should reach stable storage, with metadata,
+// before they return.
+func (f OpenFlags) Sync() bool {
+	return f&OpenFlags(os.O_SYNC) == OpenFlags(os.O_SYNC)
+}
+
+// DataSync says whether the data of writes should reach stable
+// storage before they return. It is true for O_SYNC too.
+func (f OpenFlags) DataSync() bool {
+	return f&syscall.O_DSYNC != 0 || f.Sync()
+}
+
+func (f OpenFlags) String() string {
+	return FlagString(OpenFlagNames, int64(f), "O_RDONLY")
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"log"
+	"time"
+)
+
+// An Option sets a field of MountOptions. Options are applied in
+// order, so a later option overrides an earlier one.
+type Option func(*MountOptions)
+
+// NewServerWithOptions is like NewServer, but takes its settings as a
+// list of options, eg.
+//
+//	NewServerWithOptions(fs, dir, WithDebug(true), WithMaxReaders(4))
+//
+// Settings that have no Option can be set with MountOptions
+// directly.
+func NewServerWithOptions(fs RawFileSystem, mountPoint string, opts ...Option) (*Server, error) {
+	return NewServer(fs, mountPoint, applyOptions(opts))
+}
+
+func applyOptions(opts []Option) *MountOptions {
+	o := &MountOptions{
+		MaxBackground: _DEFAULT_BACKGROUND_TASKS,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithBuffers sets MountOptions.Buffers.
+func WithBuffers(p BufferPool) Option {
+	return func(o *MountOptions) { o.Buffers = p }
+}
+
+// WithMemory sets MountOptions.Memory.
+func WithMemory(l *MemoryLimit) Option {
+	return func(o *MountOptions) { o.Memory = l }
+}
+
+// WithMaxWrite sets MountOptions.MaxWrite, which also determines the
+// size of the read buffers.
+func WithMaxWrite(n int) Option {
+	return func(o *MountOptions) { o.MaxWrite = n }
+}
+
+// WithDebug sets MountOptions.Debug.
+func WithDebug(debug bool) Option {
+	return func(o *MountOptions) { o.Debug = debug }
+}
+
+// WithLogger sets MountOptions.Logger.
+func WithLogger(l *log.Logger) Option {
+	return func(o *MountOptions) { o.Logger = l }
+}
+
+// WithHealthCheck sets MountOptions.HealthCheck.
+func WithHealthCheck(h *HealthCheck) Option {
+	return func(o *MountOptions) { o.HealthCheck = h }
+}
+
+// WithProcessResolver sets MountOptions.ProcessResolver.
+func WithProcessResolver(r *ProcessResolver) Option {
+	return func(o *MountOptions) { o.ProcessResolver = r }
+}
+
+// WithLatencies sets MountOptions.Latencies, to record the latency of
+// each request from the start.
+func WithLatencies(l LatencyMap) Option {
+	return func(o *MountOptions) { o.Latencies = l }
+}
+
+// WithMaxReaders sets MountOptions.MaxReaders.
+func WithMaxReaders(n int) Option {
+	return func(o *MountOptions) { o.MaxReaders = n }
+}
+
+// WithMaxBackground sets MountOptions.MaxBackground.
+func WithMaxBackground(n int) Option {
+	return func(o *MountOptions) { o.MaxBackground = n }
+}
+
+// WithSingleThreaded sets MountOptions.SingleThreaded.
+func WithSingleThreaded(single bool) Option {
+	return func(o *MountOptions) { o.SingleThreaded = single }
+}
+
+// WithSerializeNodes sets MountOptions.SerializeNodes.
+func WithSerializeNodes(serialize bool) Option {
+	return func(o *MountOptions) { o.SerializeNodes = serialize }
+}
+
+// WithMaxInflightBytes sets MountOptions.MaxInflightBytes.
+func WithMaxInflightBytes(n int) Option {
+	return func(o *MountOptions) { o.MaxInflightBytes = n }
+}
+
+// WithQoS sets MountOptions.QoS.
+func WithQoS(q *QoS) Option {
+	return func(o *MountOptions) { o.QoS = q }
+}
+
+// WithClock sets MountOptions.Clock.
+func WithClock(c Clock) Option {
+	return func(o *MountOptions) { o.Clock = c }
+}
+
+// WithOrderForgets sets MountOptions.OrderForgets.
+func WithOrderForgets(order bool) Option {
+	return func(o *MountOptions) { o.OrderForgets = order }
+}
+
+// WithReplyQueue sets MountOptions.ReplyQueue.
+func WithReplyQueue(n int) Option {
+	return func(o *MountOptions) { o.ReplyQueue = n }
+}
+
+// WithRateLimit sets MountOptions.RateLimit.
+func WithRateLimit(l *RateLimit) Option {
+	return func(o *MountOptions) { o.RateLimit = l }
+}
+
+// WithErrorLockdown sets MountOptions.ErrorLockdown.
+func WithErrorLockdown(l *ErrorLockdown) Option {
+	return func(o *MountOptions) { o.ErrorLockdown = l }
+}
+
+// WithDisabledOps sets MountOptions.DisabledOps.
+func WithDisabledOps(ops map[string]Status) Option {
+	return func(o *MountOptions) { o.DisabledOps = ops }
+}
+
+// WithTrackRequests sets MountOptions.TrackRequests.
+func WithTrackRequests(track bool) Option {
+	return func(o *MountOptions) { o.TrackRequests = track }
+}
+
+// WithEnableInterrupts sets MountOptions.EnableInterrupts.
+func WithEnableInterrupts(enable bool) Option {
+	return func(o *MountOptions) { o.EnableInterrupts = enable }
+}
+
+// WithEnableNoOpen sets MountOptions.EnableNoOpen.
+func WithEnableNoOpen(enable bool) Option {
+	return func(o *MountOptions) { o.EnableNoOpen = enable }
+}
+
+// WithIdleTimeout sets MountOptions.IdleTimeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *MountOptions) { o.IdleTimeout = d }
+}
+
+// WithInterceptors appends to MountOptions.Interceptors.
+func WithInterceptors(ics ...Interceptor) Option {
+	return func(o *MountOptions) { o.Interceptors = append(o.Interceptors, ics...) }
+}
+
+// WithMountOptions appends to MountOptions.Options, the options passed
+// to fusermount verbatim.
+func WithMountOptions(opts ...string) Option {
+	return func(o *MountOptions) { o.Options = append(o.Options, opts...) }
+}
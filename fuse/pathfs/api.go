@@ -61,7 +61,9 @@ type FileSystem interface {
 	OnUnmount()
 
 	// File handling.  If opening for writing, the file's mtime
-	// should be updated too.
+	// should be updated too. flags is the open(2) flags word, so
+	// O_APPEND, O_EXCL (for Create), O_NOATIME and O_SYNC can be
+	// honored; see fuse.OpenFlags.
 	Open(name string, flags uint32, context *fuse.Context) (file nodefs.File, code fuse.Status)
 	Create(name string, flags uint32, mode uint32, context *fuse.Context) (file nodefs.File, code fuse.Status)
 
@@ -80,6 +82,29 @@ type PathNodeFsOptions struct {
 	// find hard-linked files.
 	ClientInodes bool
 
+	// Inodes decides the inode numbers that are reported. If nil,
+	// the FileSystem's Attr.Ino is passed through.
+	Inodes InodeNumbers
+
 	// Debug controls printing of debug information.
 	Debug bool
+
+	// If set, serve a frozen, read-only view of the file
+	// system; see NewSnapshotFileSystem.
+	Snapshot bool
+
+	// If set, remember symlink targets, so repeated Readlink
+	// calls for a link do not reach the FileSystem. A link
+	// replaced outside the mount is noticed when the kernel looks
+	// it up again, or through ApplyChange.
+	CacheReadlink bool
+
+	// If set, a rename waits for the operations in flight, and
+	// operations wait for the rename. Without it, an operation
+	// that starts while one of its directories is renamed can
+	// reach the FileSystem with the old path. Operations that
+	// start while a rename waits go ahead, so a rename can be
+	// delayed for as long as the FileSystem blocks, eg. in the
+	// open of a FIFO.
+	StablePaths bool
 }
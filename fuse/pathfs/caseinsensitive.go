@@ -0,0 +1,45 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"strings"
+)
+
+// CaseCollisionPolicy decides what happens if the inner file system
+// has several names in one directory that differ only in case.
+type CaseCollisionPolicy int
+
+const (
+	// CaseCollisionFirst exposes only the name that sorts first;
+	// the other names are hidden from listings and lookups.
+	CaseCollisionFirst CaseCollisionPolicy = iota
+
+	// CaseCollisionError lists all names, but returns EIO for a
+	// lookup that does not match exactly one of them.
+	CaseCollisionError
+)
+
+func identity(s string) string { return s }
+
+// NewCaseInsensitiveFileSystem returns a wrapper that presents a
+// case-insensitive but case-preserving view of fs: a name given by
+// the caller matches an existing entry with different case, and new
+// entries are created with the case the caller specified.
+//
+// Paths that were resolved once are cached, so later operations on
+// them do not look at each directory on the way. Changes made behind
+// the wrapper's back are noticed when fs returns ENOENT for a cached
+// path, after which the path is resolved again.
+func NewCaseInsensitiveFileSystem(fs FileSystem, policy CaseCollisionPolicy) FileSystem {
+	return &foldingFileSystem{
+		FileSystem: fs,
+		name:       "caseInsensitiveFileSystem",
+		key:        strings.ToLower,
+		display:    identity,
+		create:     identity,
+		strict:     policy == CaseCollisionError,
+	}
+}
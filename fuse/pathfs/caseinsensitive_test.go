@@ -0,0 +1,242 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestCaseInsensitiveLookup(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(dir+"/Sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/Sub/File.TXT", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := NewCaseInsensitiveFileSystem(NewLoopbackFileSystem(dir), CaseCollisionFirst)
+	a, code := fs.GetAttr("sub/file.txt", nil)
+	if !code.Ok() {
+		t.Fatalf("GetAttr: %v", code)
+	}
+	if a.Size != 5 {
+		t.Errorf("got size %d, want 5", a.Size)
+	}
+
+	// Creating a new entry preserves case.
+	if code := fs.Mkdir("SUB/NewDir", 0755, nil); !code.Ok() {
+		t.Fatalf("Mkdir: %v", code)
+	}
+	if _, err := os.Stat(dir + "/Sub/NewDir"); err != nil {
+		t.Errorf("Stat: %v", err)
+	}
+
+	// Mkdir of an existing name in other case collides.
+	if code := fs.Mkdir("sub/newdir", 0755, nil); code != fuse.Status(syscall.EEXIST) {
+		t.Errorf("Mkdir existing: got %v, want EEXIST", code)
+	}
+
+	// Symlinks are found by their name in other case.
+	if err := os.Symlink("File.TXT", dir+"/Sub/Link"); err != nil {
+		t.Fatal(err)
+	}
+	if target, code := fs.Readlink("sub/LINK", nil); !code.Ok() || target != "File.TXT" {
+		t.Errorf("Readlink: got %q, %v, want File.TXT", target, code)
+	}
+
+	// Case-only rename.
+	if code := fs.Rename("sub/file.txt", "sub/file.txt", nil); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	if _, err := os.Stat(dir + "/Sub/file.txt"); err != nil {
+		t.Errorf("Stat after rename: %v", err)
+	}
+}
+
+func TestCaseInsensitiveCollision(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	for _, n := range []string{"b", "B", "a"} {
+		if err := ioutil.WriteFile(dir+"/"+n, []byte(n), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := NewCaseInsensitiveFileSystem(NewLoopbackFileSystem(dir), CaseCollisionFirst)
+	stream, code := first.OpenDir("", nil)
+	if !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+	if len(stream) != 2 || stream[0].Name != "B" || stream[1].Name != "a" {
+		t.Errorf("got %v, want [B a]", stream)
+	}
+
+	strict := NewCaseInsensitiveFileSystem(NewLoopbackFileSystem(dir), CaseCollisionError)
+	if _, code := strict.GetAttr("b", nil); !code.Ok() {
+		t.Errorf("exact match: %v", code)
+	}
+	if _, code := strict.GetAttr("A", nil); !code.Ok() {
+		t.Errorf("unique match: %v", code)
+	}
+	stream, _ = strict.OpenDir("", nil)
+	if len(stream) != 3 {
+		t.Errorf("got %v, want 3 entries", stream)
+	}
+}
+
+// Entries created behind the wrapper's back are found, although
+// directory listings are cached.
+func TestCaseInsensitiveExternalChange(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	fs := NewCaseInsensitiveFileSystem(NewLoopbackFileSystem(dir), CaseCollisionFirst)
+	if _, code := fs.GetAttr("NEW", nil); code != fuse.ENOENT {
+		t.Fatalf("GetAttr before creating: got %v, want ENOENT", code)
+	}
+	if err := ioutil.WriteFile(dir+"/new", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, code := fs.GetAttr("NEW", nil); !code.Ok() {
+		t.Errorf("GetAttr after creating: %v", code)
+	}
+	if err := os.Remove(dir + "/new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, code := fs.GetAttr("NEW", nil); code != fuse.ENOENT {
+		t.Errorf("GetAttr after removing: got %v, want ENOENT", code)
+	}
+}
+
+// coarseTimesFileSystem reports the same timestamps for everything,
+// as a file system with a coarse clock does for changes in quick
+// succession.
+type coarseTimesFileSystem struct {
+	FileSystem
+}
+
+func (fs *coarseTimesFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	a, code := fs.FileSystem.GetAttr(name, context)
+	if a != nil {
+		a.SetTimes(&time.Time{}, &time.Time{}, &time.Time{})
+	}
+	return a, code
+}
+
+// Changes made through the wrapper are seen right away, even if the
+// directory timestamps do not move.
+func TestCaseInsensitiveOwnChange(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	fs := NewCaseInsensitiveFileSystem(&coarseTimesFileSystem{NewLoopbackFileSystem(dir)}, CaseCollisionFirst)
+	if _, code := fs.GetAttr("NEW", nil); code != fuse.ENOENT {
+		t.Fatalf("GetAttr before creating: got %v, want ENOENT", code)
+	}
+	if code := fs.Mkdir("new", 0755, nil); !code.Ok() {
+		t.Fatalf("Mkdir: %v", code)
+	}
+	if _, code := fs.GetAttr("NEW", nil); !code.Ok() {
+		t.Errorf("GetAttr after Mkdir: %v", code)
+	}
+
+	f, code := fs.Create("file", uint32(os.O_WRONLY), 0644, nil)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	f.Release()
+	if _, code := fs.GetAttr("FILE", nil); !code.Ok() {
+		t.Errorf("GetAttr after Create: %v", code)
+	}
+
+	// Rename changes both directories.
+	if _, code := fs.GetAttr("NEW/MOVED", nil); code != fuse.ENOENT {
+		t.Fatalf("GetAttr before Rename: got %v, want ENOENT", code)
+	}
+	if code := fs.Rename("FILE", "NEW/moved", nil); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	if _, code := fs.GetAttr("new/MOVED", nil); !code.Ok() {
+		t.Errorf("GetAttr after Rename: %v", code)
+	}
+	if code := fs.Mkdir("File", 0755, nil); !code.Ok() {
+		t.Errorf("Mkdir over the old name: %v", code)
+	}
+}
+
+// countingFileSystem counts GetAttr and OpenDir calls.
+type countingFileSystem struct {
+	FileSystem
+	getAttrs, openDirs int
+}
+
+func (fs *countingFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	fs.getAttrs++
+	return fs.FileSystem.GetAttr(name, context)
+}
+
+func (fs *countingFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	fs.openDirs++
+	return fs.FileSystem.OpenDir(name, context)
+}
+
+func TestCaseInsensitiveNameCache(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(dir+"/A/B", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/A/B/File", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inner := &countingFileSystem{FileSystem: NewLoopbackFileSystem(dir)}
+	fs := NewCaseInsensitiveFileSystem(inner, CaseCollisionFirst)
+	if _, code := fs.GetAttr("a/b/file", nil); !code.Ok() {
+		t.Fatalf("GetAttr: %v", code)
+	}
+
+	// Once resolved, a path costs only the operation itself.
+	inner.getAttrs, inner.openDirs = 0, 0
+	for i := 0; i < 3; i++ {
+		if _, code := fs.GetAttr("A/b/FILE", nil); !code.Ok() {
+			t.Fatalf("GetAttr: %v", code)
+		}
+	}
+	if inner.getAttrs != 3 || inner.openDirs != 0 {
+		t.Errorf("got %d GetAttr and %d OpenDir calls, want 3 and 0", inner.getAttrs, inner.openDirs)
+	}
+
+	// Renames through the wrapper drop the paths below.
+	if code := fs.Rename("a/b", "a/c", nil); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	if _, code := fs.GetAttr("a/b/file", nil); code != fuse.ENOENT {
+		t.Errorf("GetAttr of old name: got %v, want ENOENT", code)
+	}
+	if _, code := fs.GetAttr("a/c/file", nil); !code.Ok() {
+		t.Errorf("GetAttr of new name: %v", code)
+	}
+
+	// Renames behind our back are noticed through ENOENT.
+	if err := os.Rename(dir+"/A/c/File", dir+"/A/c/fILE"); err != nil {
+		t.Fatal(err)
+	}
+	if a, code := fs.GetAttr("a/c/file", nil); !code.Ok() || a.Size != 5 {
+		t.Errorf("GetAttr after external rename: %v, %v", a, code)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// ChangeKind says what happened to the path in a Change.
+type ChangeKind int
+
+const (
+	// The contents or attributes of the file changed.
+	PathChanged = ChangeKind(iota)
+
+	// The file was created.
+	PathCreated
+
+	// The file was deleted.
+	PathDeleted
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case PathChanged:
+		return "changed"
+	case PathCreated:
+		return "created"
+	case PathDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// Change describes a modification to the file system that was not
+// made through the mount, eg. by another client of a network file
+// system.
+type Change struct {
+	Kind ChangeKind
+
+	// Path is relative to the root of the PathNodeFs.
+	Path string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %q", c.Kind, c.Path)
+}
+
+// ApplyChange makes the kernel drop what it caches for the path in
+// c: attributes and data of the file, the entry in its directory, and
+// for creation and deletion, the listing and attributes of the
+// directory. Paths the kernel does not know about are skipped, so
+// the file system does not need to track which inodes it handed out.
+//
+// As with the other notify calls, no file system locks should be
+// held when calling this.
+func (fs *PathNodeFs) ApplyChange(c Change) fuse.Status {
+	p := strings.Trim(filepath.Clean(c.Path), "/")
+	if p == "." || p == "" {
+		return ignoreUnknown(fs.connector.FileNotify(fs.root.Inode(), 0, 0))
+	}
+
+	dir, name := filepath.Split(p)
+	parent, rest := fs.connector.Node(fs.root.Inode(), dir)
+	if len(rest) > 0 {
+		// The kernel can't have cached anything below
+		// the part it knows.
+		return fuse.OK
+	}
+	child := parent.GetChild(name)
+	if child != nil {
+		if n, ok := child.Node().(*pathInode); ok {
+			n.dropReadlink()
+		}
+	}
+
+	var code fuse.Status
+	switch c.Kind {
+	case PathChanged:
+		if child != nil {
+			code = fs.connector.FileNotify(child, 0, 0)
+		} else {
+			// Drop a negative entry, if any.
+			code = fs.connector.EntryNotify(parent, name)
+		}
+		return ignoreUnknown(code)
+	case PathCreated:
+		code = fs.connector.EntryNotify(parent, name)
+	case PathDeleted:
+		if child != nil {
+			code = fs.connector.DeleteNotify(parent, child, name)
+		} else {
+			code = fs.connector.EntryNotify(parent, name)
+		}
+	default:
+		return fuse.EINVAL
+	}
+	if code = ignoreUnknown(code); !code.Ok() {
+		return code
+	}
+	return ignoreUnknown(fs.connector.FileNotify(parent, 0, 0))
+}
+
+// Subscribe applies the changes received from ch, until ch is
+// closed. It returns immediately. File systems typically call this
+// from OnMount, with a channel fed by their change feed.
+func (fs *PathNodeFs) Subscribe(ch <-chan Change) {
+	go func() {
+		for c := range ch {
+			if code := fs.ApplyChange(c); !code.Ok() && fs.debug {
+				log.Printf("ApplyChange(%v): %v", c, code)
+			}
+		}
+	}()
+}
+
+// ignoreUnknown maps the kernel not knowing an inode or entry, which
+// means it has nothing to drop, to success.
+func ignoreUnknown(code fuse.Status) fuse.Status {
+	if code == fuse.ENOENT {
+		return fuse.OK
+	}
+	return code
+}
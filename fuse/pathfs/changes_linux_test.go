@@ -0,0 +1,73 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestApplyChange(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dir", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pfs := NewPathNodeFs(NewLoopbackFileSystem(dir), nil)
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	d, code := k.Lookup(fuse.FUSE_ROOT_ID, "dir")
+	if !code.Ok() {
+		t.Fatalf("Lookup dir: %v", code)
+	}
+	f, code := k.Lookup(d.NodeId, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup file: %v", code)
+	}
+
+	type note struct {
+		code int32
+		ino  uint64
+	}
+	for _, tc := range []struct {
+		change Change
+		want   []note
+	}{
+		{Change{PathChanged, "dir/file"}, []note{{fuse.NOTIFY_INVAL_INODE, f.NodeId}}},
+		{Change{PathCreated, "dir/new"}, []note{{fuse.NOTIFY_INVAL_ENTRY, d.NodeId}, {fuse.NOTIFY_INVAL_INODE, d.NodeId}}},
+		{Change{PathDeleted, "/dir/file"}, []note{{fuse.NOTIFY_INVAL_DELETE, d.NodeId}, {fuse.NOTIFY_INVAL_INODE, d.NodeId}}},
+		{Change{PathChanged, "other/file"}, nil},
+	} {
+		if code := pfs.ApplyChange(tc.change); !code.Ok() {
+			t.Errorf("ApplyChange(%v): %v", tc.change, code)
+		}
+		// Notifications are ordered before the reply.
+		k.GetAttr(fuse.FUSE_ROOT_ID)
+		var got []note
+		for _, n := range k.Notifications() {
+			got = append(got, note{n.Code, binary.NativeEndian.Uint64(n.Data)})
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ApplyChange(%v): got %v, want %v", tc.change, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// NewCoalescingFileSystem returns a wrapper that runs concurrent
+// GetAttr calls for the same name once: callers that arrive while a
+// call is in flight wait for it and share its result. GetAttr serves
+// both LOOKUP and GETATTR, so a stat storm on a popular file costs
+// the backend one call at a time, rather than one per request.
+//
+// Changes made through the wrapper, including writes to files opened
+// through it, make later calls for the names they affect go to fs
+// again, so they see the change. The result is shared between callers, so fs must answer
+// GetAttr the same for all of them; put NewUserViewFileSystem and
+// other wrappers that look at the caller on top of this one.
+func NewCoalescingFileSystem(fs FileSystem) FileSystem {
+	return &coalescingFileSystem{
+		FileSystem: fs,
+		calls:      map[string]*getAttrCall{},
+	}
+}
+
+// getAttrCall is a GetAttr in flight.
+type getAttrCall struct {
+	// Closed once attr and code are set.
+	done chan struct{}
+	attr *fuse.Attr
+	code fuse.Status
+}
+
+type coalescingFileSystem struct {
+	FileSystem
+
+	mu    sync.Mutex
+	calls map[string]*getAttrCall
+
+	// Number of GetAttr calls, and how many of them were served
+	// by a call in flight.
+	total, shared atomic.Uint64
+
+	// Number of renames, so open files know whether the name
+	// they were opened with may be out of date.
+	renames atomic.Uint64
+}
+
+func (fs *coalescingFileSystem) String() string {
+	return fmt.Sprintf("coalescingFileSystem(%s, %d/%d shared)",
+		fs.FileSystem.String(), fs.shared.Load(), fs.total.Load())
+}
+
+func (fs *coalescingFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	fs.total.Add(1)
+	fs.mu.Lock()
+	c := fs.calls[name]
+	if c != nil {
+		fs.mu.Unlock()
+		fs.shared.Add(1)
+		<-c.done
+	} else {
+		c = &getAttrCall{done: make(chan struct{})}
+		fs.calls[name] = c
+		fs.mu.Unlock()
+		fs.run(name, c, context)
+	}
+	if c.attr == nil {
+		return nil, c.code
+	}
+	// Callers may modify the result.
+	a := *c.attr
+	return &a, c.code
+}
+
+func (fs *coalescingFileSystem) run(name string, c *getAttrCall, context *fuse.Context) {
+	defer func() {
+		fs.mu.Lock()
+		if fs.calls[name] == c {
+			delete(fs.calls, name)
+		}
+		fs.mu.Unlock()
+		close(c.done)
+	}()
+	c.attr, c.code = fs.FileSystem.GetAttr(name, context)
+}
+
+// changed makes GetAttr calls for names that start from now on go to
+// the backend, rather than join a call that may predate a change.
+func (fs *coalescingFileSystem) changed(names ...string) {
+	fs.mu.Lock()
+	for _, n := range names {
+		delete(fs.calls, n)
+	}
+	fs.mu.Unlock()
+}
+
+// changedEntry is changed for an entry that was added or removed,
+// which also changes its directory.
+func (fs *coalescingFileSystem) changedEntry(name string) {
+	fs.changed(name, parentName(name))
+}
+
+// changedTree is changedEntry for everything below the names too, as
+// renames change the names of whole trees.
+func (fs *coalescingFileSystem) changedTree(names ...string) {
+	fs.mu.Lock()
+	for _, n := range names {
+		delete(fs.calls, parentName(n))
+		for k := range fs.calls {
+			if n == "" || k == n || strings.HasPrefix(k, n+"/") {
+				delete(fs.calls, k)
+			}
+		}
+	}
+	fs.mu.Unlock()
+}
+
+func parentName(name string) string {
+	dir := filepath.Dir(name)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+func (fs *coalescingFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	defer fs.changed(name)
+	return fs.FileSystem.Chmod(name, mode, context)
+}
+
+func (fs *coalescingFileSystem) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	defer fs.changed(name)
+	return fs.FileSystem.Chown(name, uid, gid, context)
+}
+
+func (fs *coalescingFileSystem) Utimens(name string, Atime *time.Time, Mtime *time.Time, context *fuse.Context) fuse.Status {
+	defer fs.changed(name)
+	return fs.FileSystem.Utimens(name, Atime, Mtime, context)
+}
+
+func (fs *coalescingFileSystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	defer fs.changed(name)
+	return fs.FileSystem.Truncate(name, size, context)
+}
+
+func (fs *coalescingFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	// The link count of oldName goes up.
+	defer fs.changed(oldName, newName, parentName(newName))
+	return fs.FileSystem.Link(oldName, newName, context)
+}
+
+func (fs *coalescingFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	defer fs.changedEntry(name)
+	return fs.FileSystem.Mkdir(name, mode, context)
+}
+
+func (fs *coalescingFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	defer fs.changedEntry(name)
+	return fs.FileSystem.Mknod(name, mode, dev, context)
+}
+
+func (fs *coalescingFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	defer fs.changedTree(oldName, newName)
+	defer fs.renames.Add(1)
+	return fs.FileSystem.Rename(oldName, newName, context)
+}
+
+func (fs *coalescingFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	defer fs.changedEntry(name)
+	return fs.FileSystem.Rmdir(name, context)
+}
+
+func (fs *coalescingFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	defer fs.changedEntry(name)
+	return fs.FileSystem.Unlink(name, context)
+}
+
+func (fs *coalescingFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	defer fs.changedEntry(linkName)
+	return fs.FileSystem.Symlink(value, linkName, context)
+}
+
+func (fs *coalescingFileSystem) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	defer fs.changed(name)
+	return fs.FileSystem.SetXAttr(name, attr, data, flags, context)
+}
+
+func (fs *coalescingFileSystem) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	defer fs.changed(name)
+	return fs.FileSystem.RemoveXAttr(name, attr, context)
+}
+
+func (fs *coalescingFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if flags&syscall.O_TRUNC != 0 {
+		defer fs.changed(name)
+	}
+	f, code := fs.FileSystem.Open(name, flags, context)
+	return fs.wrapFile(name, f, code)
+}
+
+func (fs *coalescingFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	defer fs.changedEntry(name)
+	f, code := fs.FileSystem.Create(name, flags, mode, context)
+	return fs.wrapFile(name, f, code)
+}
+
+func (fs *coalescingFileSystem) wrapFile(name string, f nodefs.File, code fuse.Status) (nodefs.File, fuse.Status) {
+	if f == nil {
+		return nil, code
+	}
+	return &coalescingFile{File: f, fs: fs, name: name, renames: fs.renames.Load()}, code
+}
+
+// coalescingFile reports the changes made through an open file.
+type coalescingFile struct {
+	nodefs.File
+	fs *coalescingFileSystem
+
+	// The name the file was opened with, which is current as
+	// long as the wrapper saw no renames since.
+	name    string
+	renames uint64
+}
+
+// changed is coalescingFileSystem.changed for the file. After a
+// rename, the file may have another name, so all calls are dropped.
+func (f *coalescingFile) changed() {
+	if f.fs.renames.Load() != f.renames {
+		f.fs.changedTree("")
+		return
+	}
+	f.fs.changed(f.name)
+}
+
+func (f *coalescingFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *coalescingFile) String() string {
+	return fmt.Sprintf("coalescingFile(%s)", f.File.String())
+}
+
+func (f *coalescingFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	defer f.changed()
+	return f.File.Write(data, off)
+}
+
+func (f *coalescingFile) Flush() fuse.Status {
+	// Files may write back on close.
+	defer f.changed()
+	return f.File.Flush()
+}
+
+func (f *coalescingFile) Truncate(size uint64) fuse.Status {
+	defer f.changed()
+	return f.File.Truncate(size)
+}
+
+func (f *coalescingFile) Chown(uid uint32, gid uint32) fuse.Status {
+	defer f.changed()
+	return f.File.Chown(uid, gid)
+}
+
+func (f *coalescingFile) Chmod(perms uint32) fuse.Status {
+	defer f.changed()
+	return f.File.Chmod(perms)
+}
+
+func (f *coalescingFile) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
+	defer f.changed()
+	return f.File.Utimens(atime, mtime)
+}
+
+func (f *coalescingFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	defer f.changed()
+	return f.File.Allocate(off, size, mode)
+}
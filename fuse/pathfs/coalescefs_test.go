@@ -0,0 +1,133 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// slowStatFileSystem counts GetAttr calls, which block until
+// unblock is closed.
+type slowStatFileSystem struct {
+	FileSystem
+	calls   atomic.Int32
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (fs *slowStatFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	if fs.calls.Add(1) == 1 {
+		close(fs.started)
+	}
+	<-fs.unblock
+	return &fuse.Attr{Mode: fuse.S_IFREG | 0644, Size: 42}, fuse.OK
+}
+
+func (fs *slowStatFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fuse.OK
+}
+
+func TestCoalescingFileSystem(t *testing.T) {
+	inner := &slowStatFileSystem{
+		FileSystem: NewDefaultFileSystem(),
+		started:    make(chan struct{}),
+		unblock:    make(chan struct{}),
+	}
+	fs := NewCoalescingFileSystem(inner)
+
+	const n = 10
+	var wg sync.WaitGroup
+	attrs := make([]*fuse.Attr, n)
+	stat := func(i int) {
+		defer wg.Done()
+		a, code := fs.GetAttr("file", &fuse.Context{})
+		if !code.Ok() {
+			t.Errorf("GetAttr: %v", code)
+		}
+		attrs[i] = a
+	}
+	wg.Add(1)
+	go stat(0)
+	<-inner.started
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go stat(i)
+	}
+	// Give the others time to join the call in flight.
+	time.Sleep(10 * time.Millisecond)
+	close(inner.unblock)
+	wg.Wait()
+
+	if got := inner.calls.Load(); got != 1 {
+		t.Errorf("got %d backend calls, want 1", got)
+	}
+	for i, a := range attrs {
+		if a == nil || a.Size != 42 {
+			t.Fatalf("%d: got %v", i, a)
+		}
+		if i > 0 && a == attrs[0] {
+			t.Errorf("%d: result not copied", i)
+		}
+	}
+
+	// A call after a change goes to the backend.
+	fs.Chmod("file", 0600, &fuse.Context{})
+	fs.GetAttr("file", &fuse.Context{})
+	if got := inner.calls.Load(); got != 2 {
+		t.Errorf("got %d backend calls, want 2", got)
+	}
+}
+
+// Changes only stop sharing for the names they affect.
+func TestCoalescingFileSystemChangedName(t *testing.T) {
+	inner := &slowStatFileSystem{
+		FileSystem: NewDefaultFileSystem(),
+		started:    make(chan struct{}),
+		unblock:    make(chan struct{}),
+	}
+	fs := NewCoalescingFileSystem(inner)
+
+	var wg sync.WaitGroup
+	stat := func(name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, code := fs.GetAttr(name, &fuse.Context{}); !code.Ok() {
+				t.Errorf("GetAttr %s: %v", name, code)
+			}
+		}()
+	}
+	stat("dir/a")
+	<-inner.started
+
+	fs.Chmod("dir/b", 0600, &fuse.Context{})
+	fs.Open("dir/a", uint32(os.O_RDONLY), &fuse.Context{})
+	stat("dir/a")
+	time.Sleep(10 * time.Millisecond)
+	if got := inner.calls.Load(); got != 1 {
+		t.Errorf("got %d backend calls, want 1", got)
+	}
+
+	// Removing an entry changes its directory, and truncating
+	// changes the file.
+	stat("dir")
+	time.Sleep(10 * time.Millisecond)
+	fs.Unlink("dir/c", &fuse.Context{})
+	fs.Open("dir/a", uint32(os.O_WRONLY|os.O_TRUNC), &fuse.Context{})
+	stat("dir")
+	stat("dir/a")
+	time.Sleep(10 * time.Millisecond)
+	close(inner.unblock)
+	wg.Wait()
+	if got := inner.calls.Load(); got != 4 {
+		t.Errorf("got %d backend calls, want 4", got)
+	}
+}
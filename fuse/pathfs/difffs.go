@@ -0,0 +1,330 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// Divergence and DiffOptions are shared with
+// fuse.NewDiffRawFileSystem, which does the same for the node API.
+type (
+	Divergence  = fuse.Divergence
+	DiffOptions = fuse.DiffOptions
+)
+
+// NewDiffFileSystem returns a wrapper that applies each operation
+// to both reference (eg. a loopback file system) and test, and
+// reports where their results differ. Statuses, attributes, file
+// data, directory listings, symlinks and extended attributes are
+// compared. The results of the reference are returned, so the
+// mount keeps working if test misbehaves.
+//
+// Both file systems should start out with the same content. This is
+// useful for checking a port of a file system against the original.
+// To check a port to the node API, see fuse.NewDiffRawFileSystem.
+func NewDiffFileSystem(reference, test FileSystem, opts *DiffOptions) FileSystem {
+	fs := &diffFileSystem{
+		ref:  reference,
+		test: test,
+	}
+	if opts != nil {
+		fs.opts = *opts
+	}
+	if fs.opts.Report == nil {
+		fs.opts.Report = func(d *Divergence) {
+			log.Printf("divergence: %v", d)
+		}
+	}
+	return fs
+}
+
+type diffFileSystem struct {
+	ref  FileSystem
+	test FileSystem
+	opts DiffOptions
+}
+
+func (fs *diffFileSystem) report(op, name string, want, got string) {
+	if want != got {
+		fs.opts.Report(&Divergence{Op: op, Name: name, Want: want, Got: got})
+	}
+}
+
+func (fs *diffFileSystem) status(op, name string, want, got fuse.Status) fuse.Status {
+	fs.report(op, name, want.String(), got.String())
+	return want
+}
+
+func (fs *diffFileSystem) String() string {
+	return fmt.Sprintf("DiffFileSystem(%v, %v)", fs.ref, fs.test)
+}
+
+func (fs *diffFileSystem) SetDebug(debug bool) {
+	fs.ref.SetDebug(debug)
+	fs.test.SetDebug(debug)
+}
+
+func (fs *diffFileSystem) OnMount(nodeFs *PathNodeFs) {
+	fs.ref.OnMount(nodeFs)
+	fs.test.OnMount(nodeFs)
+}
+
+func (fs *diffFileSystem) OnUnmount() {
+	fs.ref.OnUnmount()
+	fs.test.OnUnmount()
+}
+
+func (fs *diffFileSystem) StatFs(name string) *fuse.StatfsOut {
+	// Capacity depends on the backing store, so don't compare.
+	fs.test.StatFs(name)
+	return fs.ref.StatFs(name)
+}
+
+func (fs *diffFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	a, code := fs.ref.GetAttr(name, context)
+	b, testCode := fs.test.GetAttr(name, context)
+	fs.report("GetAttr", name, fs.opts.FormatAttr(a, code), fs.opts.FormatAttr(b, testCode))
+	return a, code
+}
+
+func (fs *diffFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fs.status("Chmod", name, fs.ref.Chmod(name, mode, context), fs.test.Chmod(name, mode, context))
+}
+
+func (fs *diffFileSystem) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	return fs.status("Chown", name, fs.ref.Chown(name, uid, gid, context), fs.test.Chown(name, uid, gid, context))
+}
+
+func (fs *diffFileSystem) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	return fs.status("Utimens", name, fs.ref.Utimens(name, atime, mtime, context), fs.test.Utimens(name, atime, mtime, context))
+}
+
+func (fs *diffFileSystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	return fs.status("Truncate", name, fs.ref.Truncate(name, size, context), fs.test.Truncate(name, size, context))
+}
+
+func (fs *diffFileSystem) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fs.status("Access", name, fs.ref.Access(name, mode, context), fs.test.Access(name, mode, context))
+}
+
+func (fs *diffFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	return fs.status("Link", newName, fs.ref.Link(oldName, newName, context), fs.test.Link(oldName, newName, context))
+}
+
+func (fs *diffFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fs.status("Mkdir", name, fs.ref.Mkdir(name, mode, context), fs.test.Mkdir(name, mode, context))
+}
+
+func (fs *diffFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	return fs.status("Mknod", name, fs.ref.Mknod(name, mode, dev, context), fs.test.Mknod(name, mode, dev, context))
+}
+
+func (fs *diffFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	return fs.status("Rename", oldName, fs.ref.Rename(oldName, newName, context), fs.test.Rename(oldName, newName, context))
+}
+
+func (fs *diffFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	return fs.status("Rmdir", name, fs.ref.Rmdir(name, context), fs.test.Rmdir(name, context))
+}
+
+func (fs *diffFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	return fs.status("Unlink", name, fs.ref.Unlink(name, context), fs.test.Unlink(name, context))
+}
+
+func (fs *diffFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	return fs.status("Symlink", linkName, fs.ref.Symlink(value, linkName, context), fs.test.Symlink(value, linkName, context))
+}
+
+func (fs *diffFileSystem) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	val, code := fs.ref.Readlink(name, context)
+	testVal, testCode := fs.test.Readlink(name, context)
+	fs.report("Readlink", name, fmt.Sprintf("%q %v", val, code), fmt.Sprintf("%q %v", testVal, testCode))
+	return val, code
+}
+
+func (fs *diffFileSystem) GetXAttr(name string, attr string, context *fuse.Context) ([]byte, fuse.Status) {
+	data, code := fs.ref.GetXAttr(name, attr, context)
+	testData, testCode := fs.test.GetXAttr(name, attr, context)
+	fs.report("GetXAttr", name, fmt.Sprintf("%q %v", data, code), fmt.Sprintf("%q %v", testData, testCode))
+	return data, code
+}
+
+func (fs *diffFileSystem) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	attrs, code := fs.ref.ListXAttr(name, context)
+	testAttrs, testCode := fs.test.ListXAttr(name, context)
+	fs.report("ListXAttr", name, sortedString(attrs, code), sortedString(testAttrs, testCode))
+	return attrs, code
+}
+
+func (fs *diffFileSystem) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	return fs.status("RemoveXAttr", name, fs.ref.RemoveXAttr(name, attr, context), fs.test.RemoveXAttr(name, attr, context))
+}
+
+func (fs *diffFileSystem) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	return fs.status("SetXAttr", name, fs.ref.SetXAttr(name, attr, data, flags, context), fs.test.SetXAttr(name, attr, data, flags, context))
+}
+
+func sortedString(list []string, code fuse.Status) string {
+	if !code.Ok() {
+		return code.String()
+	}
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+	return fmt.Sprintf("%q", sorted)
+}
+
+func (fs *diffFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	stream, code := fs.ref.OpenDir(name, context)
+	testStream, testCode := fs.test.OpenDir(name, context)
+	fs.report("OpenDir", name, dirString(stream, code), dirString(testStream, testCode))
+	return stream, code
+}
+
+// dirString formats a listing as sorted names with their file type.
+func dirString(stream []fuse.DirEntry, code fuse.Status) string {
+	if !code.Ok() {
+		return code.String()
+	}
+	var entries []string
+	for _, e := range stream {
+		entries = append(entries, fmt.Sprintf("%s:%o", e.Name, e.Mode&syscall.S_IFMT))
+	}
+	sort.Strings(entries)
+	return fmt.Sprintf("%v", entries)
+}
+
+func (fs *diffFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	f, code := fs.ref.Open(name, flags, context)
+	testF, testCode := fs.test.Open(name, flags, context)
+	return fs.newFile("Open", name, f, code, testF, testCode)
+}
+
+func (fs *diffFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	f, code := fs.ref.Create(name, flags, mode, context)
+	testF, testCode := fs.test.Create(name, flags, mode, context)
+	return fs.newFile("Create", name, f, code, testF, testCode)
+}
+
+func (fs *diffFileSystem) newFile(op, name string, f nodefs.File, code fuse.Status, testF nodefs.File, testCode fuse.Status) (nodefs.File, fuse.Status) {
+	fs.status(op, name, code, testCode)
+	if !code.Ok() {
+		if testCode.Ok() && testF != nil {
+			testF.Release()
+		}
+		return nil, code
+	}
+	if !testCode.Ok() || testF == nil {
+		// Nothing to compare against.
+		return f, code
+	}
+	return &diffFile{File: f, test: testF, fs: fs, name: name}, code
+}
+
+// diffFile compares the operations on an open file.
+type diffFile struct {
+	nodefs.File
+	test nodefs.File
+	fs   *diffFileSystem
+	name string
+}
+
+func (f *diffFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *diffFile) String() string {
+	return fmt.Sprintf("diffFile(%v, %v)", f.File, f.test)
+}
+
+func (f *diffFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	data, code := readBytes(f.File, dest, off)
+	testData, testCode := readBytes(f.test, make([]byte, len(dest)), off)
+
+	want, got := code.String(), testCode.String()
+	if code.Ok() && testCode.Ok() && !bytes.Equal(data, testData) {
+		want, got = dataString(data), dataString(testData)
+	}
+	f.fs.report("Read", f.name, want, got)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fuse.ReadResultData(data), code
+}
+
+// dataString summarizes data for a divergence report.
+func dataString(data []byte) string {
+	const max = 32
+	if len(data) > max {
+		return fmt.Sprintf("%d bytes %q...", len(data), data[:max])
+	}
+	return fmt.Sprintf("%d bytes %q", len(data), data)
+}
+
+func readBytes(f nodefs.File, dest []byte, off int64) ([]byte, fuse.Status) {
+	res, code := f.Read(dest, off)
+	if !code.Ok() {
+		return nil, code
+	}
+	data, code := res.Bytes(dest)
+	res.Done()
+	return data, code
+}
+
+func (f *diffFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	n, code := f.File.Write(data, off)
+	testN, testCode := f.test.Write(data, off)
+	f.fs.report("Write", f.name, fmt.Sprintf("%d %v", n, code), fmt.Sprintf("%d %v", testN, testCode))
+	return n, code
+}
+
+func (f *diffFile) Flush() fuse.Status {
+	return f.fs.status("Flush", f.name, f.File.Flush(), f.test.Flush())
+}
+
+func (f *diffFile) Release() {
+	f.File.Release()
+	f.test.Release()
+}
+
+func (f *diffFile) Fsync(flags int) fuse.Status {
+	return f.fs.status("Fsync", f.name, f.File.Fsync(flags), f.test.Fsync(flags))
+}
+
+func (f *diffFile) Truncate(size uint64) fuse.Status {
+	return f.fs.status("File.Truncate", f.name, f.File.Truncate(size), f.test.Truncate(size))
+}
+
+func (f *diffFile) GetAttr(out *fuse.Attr) fuse.Status {
+	code := f.File.GetAttr(out)
+	var testOut fuse.Attr
+	testCode := f.test.GetAttr(&testOut)
+	f.fs.report("File.GetAttr", f.name, f.fs.opts.FormatAttr(out, code), f.fs.opts.FormatAttr(&testOut, testCode))
+	return code
+}
+
+func (f *diffFile) Chown(uid uint32, gid uint32) fuse.Status {
+	return f.fs.status("File.Chown", f.name, f.File.Chown(uid, gid), f.test.Chown(uid, gid))
+}
+
+func (f *diffFile) Chmod(perms uint32) fuse.Status {
+	return f.fs.status("File.Chmod", f.name, f.File.Chmod(perms), f.test.Chmod(perms))
+}
+
+func (f *diffFile) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
+	return f.fs.status("File.Utimens", f.name, f.File.Utimens(atime, mtime), f.test.Utimens(atime, mtime))
+}
+
+func (f *diffFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	return f.fs.status("Allocate", f.name, f.File.Allocate(off, size, mode), f.test.Allocate(off, size, mode))
+}
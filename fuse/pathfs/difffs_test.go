@@ -0,0 +1,86 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestDiffFileSystem(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	refDir := filepath.Join(dir, "ref")
+	testDir := filepath.Join(dir, "test")
+	os.Mkdir(refDir, 0755)
+	os.Mkdir(testDir, 0755)
+
+	var mu sync.Mutex
+	var divergences []*Divergence
+	fs := NewDiffFileSystem(NewLoopbackFileSystem(refDir), NewLoopbackFileSystem(testDir), &DiffOptions{
+		Report: func(d *Divergence) {
+			mu.Lock()
+			defer mu.Unlock()
+			divergences = append(divergences, d)
+		},
+	})
+	ctx := &fuse.Context{}
+
+	// Operations through the wrapper keep both sides identical.
+	f, code := fs.Create("file", uint32(os.O_RDWR), 0644, ctx)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	if _, code := f.Write([]byte("hello"), 0); !code.Ok() {
+		t.Fatalf("Write: %v", code)
+	}
+	if _, code := f.Read(make([]byte, 10), 0); !code.Ok() {
+		t.Fatalf("Read: %v", code)
+	}
+	f.Release()
+	fs.Mkdir("dir", 0755, ctx)
+	fs.GetAttr("file", ctx)
+	fs.OpenDir("", ctx)
+	if len(divergences) > 0 {
+		t.Fatalf("got divergences %v for identical file systems", divergences)
+	}
+
+	// Change the test side behind the wrapper's back.
+	if err := ioutil.WriteFile(filepath.Join(testDir, "file"), []byte("HELLO"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	os.Remove(filepath.Join(testDir, "dir"))
+
+	f, code = fs.Open("file", uint32(os.O_RDONLY), ctx)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	res, code := f.Read(make([]byte, 10), 0)
+	if !code.Ok() {
+		t.Fatalf("Read: %v", code)
+	}
+	if data, _ := res.Bytes(make([]byte, 10)); string(data) != "hello" {
+		t.Errorf("got %q, want the reference content", data)
+	}
+	f.Release()
+	fs.GetAttr("dir", ctx)
+	fs.OpenDir("", ctx)
+
+	want := map[string]bool{"Read": true, "GetAttr": true, "OpenDir": true}
+	if len(divergences) != len(want) {
+		t.Fatalf("got divergences %v, want ops %v", divergences, want)
+	}
+	for _, d := range divergences {
+		if !want[d.Op] {
+			t.Errorf("unexpected divergence %v", d)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// FallbackOptions configures NewFallbackFileSystem.
+type FallbackOptions struct {
+	// Content fills the reads that fail, repeated from the start
+	// of the file, so the same offset always reads the same
+	// byte. If empty, failed reads return zeros.
+	Content []byte
+
+	// Replace says whether a failed read is filled in. If nil,
+	// all failures are.
+	Replace func(code fuse.Status) bool
+}
+
+// NewFallbackFileSystem returns a wrapper that answers reads of fs
+// that fail with placeholder content instead, and logs a warning.
+// This suits serving media, where a player copes better with a
+// glitch than with EIO. Only reads are covered; the placeholder is
+// never written back, and other operations fail as before.
+//
+// Reads are cut at the size of the file, if the file can tell it.
+// Results are read out in the wrapper, so reads that splice from a
+// file descriptor fall back too, but are copied.
+func NewFallbackFileSystem(fs FileSystem, opts FallbackOptions) FileSystem {
+	if opts.Replace == nil {
+		opts.Replace = func(fuse.Status) bool { return true }
+	}
+	return &fallbackFileSystem{fs, opts}
+}
+
+type fallbackFileSystem struct {
+	FileSystem
+	opts FallbackOptions
+}
+
+func (fs *fallbackFileSystem) String() string {
+	return fmt.Sprintf("fallbackFileSystem(%v)", fs.FileSystem)
+}
+
+func (fs *fallbackFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	f, code := fs.FileSystem.Open(name, flags, context)
+	return fs.wrapFile(f, name), code
+}
+
+func (fs *fallbackFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	f, code := fs.FileSystem.Create(name, flags, mode, context)
+	return fs.wrapFile(f, name), code
+}
+
+func (fs *fallbackFileSystem) wrapFile(f nodefs.File, name string) nodefs.File {
+	if f == nil {
+		return nil
+	}
+	return &fallbackFile{File: f, opts: &fs.opts, name: name}
+}
+
+// fallbackFile fills in the reads of an open file that fail.
+type fallbackFile struct {
+	nodefs.File
+	opts *FallbackOptions
+	name string
+}
+
+func (f *fallbackFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *fallbackFile) String() string {
+	return fmt.Sprintf("fallbackFile(%s)", f.File.String())
+}
+
+func (f *fallbackFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	res, code := f.File.Read(dest, off)
+	if code.Ok() && res != nil {
+		var data []byte
+		data, code = res.Bytes(dest)
+		res.Done()
+		if code.Ok() {
+			return fuse.ReadResultData(data), fuse.OK
+		}
+	}
+	if code.Ok() || !f.opts.Replace(code) {
+		return res, code
+	}
+
+	n := len(dest)
+	var attr fuse.Attr
+	if f.File.GetAttr(&attr).Ok() {
+		if end := int64(attr.Size); off >= end {
+			n = 0
+		} else if end-off < int64(n) {
+			n = int(end - off)
+		}
+	}
+	log.Printf("%s: read of %d bytes at %d failed with %v; returning placeholder data", f.name, len(dest), off, code)
+	f.fill(dest[:n], off)
+	return fuse.ReadResultData(dest[:n]), fuse.OK
+}
+
+// fill puts the placeholder for off into buf.
+func (f *fallbackFile) fill(buf []byte, off int64) {
+	c := f.opts.Content
+	if len(c) == 0 {
+		clear(buf)
+		return
+	}
+	for i := range buf {
+		buf[i] = c[(off+int64(i))%int64(len(c))]
+	}
+}
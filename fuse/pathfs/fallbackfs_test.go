@@ -0,0 +1,95 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// badSectorFile has size bytes, and fails reads with code.
+type badSectorFile struct {
+	nodefs.File
+	size uint64
+	code fuse.Status
+}
+
+func (f *badSectorFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	return nil, f.code
+}
+
+func (f *badSectorFile) GetAttr(out *fuse.Attr) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = f.size
+	return fuse.OK
+}
+
+type badSectorFileSystem struct {
+	FileSystem
+	file *badSectorFile
+}
+
+func (fs *badSectorFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return fs.file, fuse.OK
+}
+
+func readFallback(t *testing.T, f nodefs.File, size int, off int64) (string, fuse.Status) {
+	t.Helper()
+	res, code := f.Read(make([]byte, size), off)
+	if !code.Ok() {
+		return "", code
+	}
+	data, _ := res.Bytes(nil)
+	return string(data), code
+}
+
+func TestFallbackFileSystem(t *testing.T) {
+	inner := &badSectorFileSystem{
+		FileSystem: NewDefaultFileSystem(),
+		file:       &badSectorFile{File: nodefs.NewDefaultFile(), size: 10, code: fuse.EIO},
+	}
+	fs := NewFallbackFileSystem(inner, FallbackOptions{
+		Content: []byte("abc"),
+		Replace: func(code fuse.Status) bool { return code == fuse.EIO },
+	})
+	f, code := fs.Open("movie", 0, nil)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+
+	for _, c := range []struct {
+		size int
+		off  int64
+		want string
+	}{
+		{4, 0, "abca"},
+		// The content lines up with the offset.
+		{4, 4, "bcab"},
+		// Reads stop at the end of the file.
+		{8, 7, "bca"},
+		{4, 10, ""},
+	} {
+		got, code := readFallback(t, f, c.size, c.off)
+		if !code.Ok() || got != c.want {
+			t.Errorf("Read(%d, %d): got %q, %v, want %q", c.size, c.off, got, code, c.want)
+		}
+	}
+
+	// Other failures are passed on.
+	inner.file.code = fuse.EACCES
+	if _, code := readFallback(t, f, 4, 0); code != fuse.EACCES {
+		t.Errorf("Read: got %v, want EACCES", code)
+	}
+
+	// Without content, zeros fill in.
+	inner.file.code = fuse.EIO
+	fs = NewFallbackFileSystem(inner, FallbackOptions{})
+	f, _ = fs.Open("movie", 0, nil)
+	if got, code := readFallback(t, f, 2, 0); !code.Ok() || got != "\x00\x00" {
+		t.Errorf("Read: got %q, %v, want zeros", got, code)
+	}
+}
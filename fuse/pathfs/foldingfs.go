@@ -0,0 +1,445 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// foldingFileSystem is a wrapper in which names are looked up by
+// an equivalence key rather than by exact match. It is the basis for
+// the case-insensitive and the normalizing file systems.
+type foldingFileSystem struct {
+	FileSystem
+
+	// name is used for String().
+	name string
+
+	// key maps a name onto its equivalence class.
+	key func(string) string
+
+	// display maps an inner name onto the name shown to the
+	// kernel.
+	display func(string) string
+
+	// create maps a name from the kernel onto the name used for
+	// creating new entries in the inner file system.
+	create func(string) string
+
+	// If set, ambiguous lookups return EIO, and listings are not
+	// deduplicated.
+	strict bool
+
+	// names caches the results of resolve.
+	names nameCache
+
+	mu   sync.Mutex
+	dirs map[string]*dirIndex
+}
+
+// dirIndex maps the names in a directory by their key, for a
+// version of the directory identified by its mtime and ctime.
+type dirIndex struct {
+	mtime, ctime         uint64
+	mtimensec, ctimensec uint32
+
+	// names holds the entries for each key, sorted.
+	names map[string][]string
+}
+
+func (idx *dirIndex) current(a *fuse.Attr) bool {
+	return idx.mtime == a.Mtime && idx.mtimensec == a.Mtimensec &&
+		idx.ctime == a.Ctime && idx.ctimensec == a.Ctimensec
+}
+
+// maxDirIndexes bounds the number of cached directory indexes.
+const maxDirIndexes = 1024
+
+// index returns the index for dir. It is rebuilt from a listing only
+// if the directory changed, so looking up all entries of a directory
+// does not list it for each entry.
+func (fs *foldingFileSystem) index(dir string, context *fuse.Context) (*dirIndex, fuse.Status) {
+	a, code := fs.FileSystem.GetAttr(dir, context)
+	if !code.Ok() {
+		return nil, code
+	}
+
+	fs.mu.Lock()
+	idx := fs.dirs[dir]
+	fs.mu.Unlock()
+	if idx != nil && idx.current(a) {
+		return idx, fuse.OK
+	}
+
+	stream, code := fs.FileSystem.OpenDir(dir, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	idx = &dirIndex{
+		mtime:     a.Mtime,
+		mtimensec: a.Mtimensec,
+		ctime:     a.Ctime,
+		ctimensec: a.Ctimensec,
+		names:     make(map[string][]string, len(stream)),
+	}
+	for _, e := range stream {
+		k := fs.key(e.Name)
+		idx.names[k] = append(idx.names[k], e.Name)
+	}
+	for _, c := range idx.names {
+		sort.Strings(c)
+	}
+
+	fs.mu.Lock()
+	if fs.dirs == nil || len(fs.dirs) >= maxDirIndexes {
+		fs.dirs = map[string]*dirIndex{}
+	}
+	fs.dirs[dir] = idx
+	fs.mu.Unlock()
+	return idx, fuse.OK
+}
+
+// changed drops the index of the directory holding the inner name p,
+// after an entry was added or removed through the wrapper. Its
+// timestamps alone miss changes made within their granularity.
+func (fs *foldingFileSystem) changed(p string) {
+	dir := filepath.Dir(p)
+	if dir == "." {
+		dir = ""
+	}
+	fs.mu.Lock()
+	delete(fs.dirs, dir)
+	fs.mu.Unlock()
+}
+
+// match returns the entry of dir that name refers to, or "" if there
+// is none. unique is set if no other entry is equivalent to name.
+func (fs *foldingFileSystem) match(dir string, name string, context *fuse.Context) (m string, unique bool, code fuse.Status) {
+	idx, code := fs.index(dir, context)
+	if !code.Ok() {
+		return "", false, code
+	}
+
+	candidates := idx.names[fs.key(name)]
+	switch {
+	case len(candidates) == 0:
+		return "", false, fuse.OK
+	case len(candidates) == 1:
+		return candidates[0], true, fuse.OK
+	case fs.strict:
+		for _, c := range candidates {
+			if c == name {
+				return c, false, fuse.OK
+			}
+		}
+		return "", false, fuse.EIO
+	}
+	return candidates[0], false, fuse.OK
+}
+
+// resolve translates name into the name of an existing entry in the
+// inner file system. If the last component does not exist, it is
+// converted with the create function, so it can be used for creating
+// entries. cached is set if the result came from fs.names.
+func (fs *foldingFileSystem) resolve(name string, context *fuse.Context) (p string, cached bool, code fuse.Status) {
+	if name == "" {
+		return "", false, fuse.OK
+	}
+	k := fs.key(name)
+	p, gen, ok := fs.names.get(k)
+	if ok {
+		return p, true, fuse.OK
+	}
+
+	comps := strings.Split(name, "/")
+	dir := ""
+	cacheable := true
+	for i, c := range comps {
+		m, unique, code := fs.match(dir, c, context)
+		if !code.Ok() {
+			return "", false, code
+		}
+		if m == "" {
+			if i < len(comps)-1 {
+				return "", false, fuse.ENOENT
+			}
+			m = fs.create(c)
+			cacheable = false
+		}
+		cacheable = cacheable && unique
+		dir = filepath.Join(dir, m)
+	}
+	if cacheable {
+		fs.names.put(k, dir, gen)
+	}
+	return dir, false, fuse.OK
+}
+
+// foldCall runs op on the inner name of name. If that came from the
+// cache and op returns ENOENT, the cache is stale, eg. because the
+// inner file system was changed behind our back, so name is
+// resolved again, and op retried.
+func foldCall[T any](fs *foldingFileSystem, name string, context *fuse.Context, op func(p string) (T, fuse.Status)) (T, fuse.Status) {
+	var zero T
+	p, cached, code := fs.resolve(name, context)
+	if !code.Ok() {
+		return zero, code
+	}
+	r, code := op(p)
+	if code == fuse.ENOENT && cached {
+		fs.names.drop(fs.key(name), true)
+		if p, _, code = fs.resolve(name, context); !code.Ok() {
+			return zero, code
+		}
+		r, code = op(p)
+	}
+	return r, code
+}
+
+// call is foldCall for operations that only return a status.
+func (fs *foldingFileSystem) call(name string, context *fuse.Context, op func(p string) fuse.Status) fuse.Status {
+	_, code := foldCall(fs, name, context, func(p string) (struct{}, fuse.Status) {
+		return struct{}{}, op(p)
+	})
+	return code
+}
+
+// call2 is call for operations on two names.
+func (fs *foldingFileSystem) call2(name1 string, name2 string, context *fuse.Context, op func(p1, p2 string) fuse.Status) fuse.Status {
+	for try := 0; ; try++ {
+		p1, cached1, code := fs.resolve(name1, context)
+		if !code.Ok() {
+			return code
+		}
+		p2, cached2, code := fs.resolve(name2, context)
+		if !code.Ok() {
+			return code
+		}
+		code = op(p1, p2)
+		if code != fuse.ENOENT || !(cached1 || cached2) || try > 0 {
+			return code
+		}
+		fs.names.drop(fs.key(name1), true)
+		fs.names.drop(fs.key(name2), true)
+	}
+}
+
+func (fs *foldingFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	return foldCall(fs, name, context, func(p string) (*fuse.Attr, fuse.Status) {
+		return fs.FileSystem.GetAttr(p, context)
+	})
+}
+
+func (fs *foldingFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fs.call(name, context, func(p string) fuse.Status {
+		return fs.FileSystem.Chmod(p, mode, context)
+	})
+}
+
+func (fs *foldingFileSystem) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	return fs.call(name, context, func(p string) fuse.Status {
+		return fs.FileSystem.Chown(p, uid, gid, context)
+	})
+}
+
+func (fs *foldingFileSystem) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	return fs.call(name, context, func(p string) fuse.Status {
+		return fs.FileSystem.Utimens(p, atime, mtime, context)
+	})
+}
+
+func (fs *foldingFileSystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	return fs.call(name, context, func(p string) fuse.Status {
+		return fs.FileSystem.Truncate(p, size, context)
+	})
+}
+
+func (fs *foldingFileSystem) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fs.call(name, context, func(p string) fuse.Status {
+		return fs.FileSystem.Access(p, mode, context)
+	})
+}
+
+func (fs *foldingFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	return fs.call2(oldName, newName, context, func(o, n string) fuse.Status {
+		code := fs.FileSystem.Link(o, n, context)
+		if code.Ok() {
+			fs.changed(n)
+		}
+		return code
+	})
+}
+
+func (fs *foldingFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fs.call(name, context, func(p string) fuse.Status {
+		code := fs.FileSystem.Mkdir(p, mode, context)
+		if code.Ok() {
+			fs.changed(p)
+		}
+		return code
+	})
+}
+
+func (fs *foldingFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	return fs.call(name, context, func(p string) fuse.Status {
+		code := fs.FileSystem.Mknod(p, mode, dev, context)
+		if code.Ok() {
+			fs.changed(p)
+		}
+		return code
+	})
+}
+
+func (fs *foldingFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	code := fs.call2(oldName, newName, context, func(o, n string) fuse.Status {
+		if n == o {
+			// A rename within an equivalence class, eg. "foo" => "FOO".
+			n = filepath.Join(filepath.Dir(o), fs.create(filepath.Base(newName)))
+		}
+		code := fs.FileSystem.Rename(o, n, context)
+		if code.Ok() {
+			fs.changed(o)
+			fs.changed(n)
+		}
+		return code
+	})
+	if code.Ok() {
+		fs.names.drop(fs.key(oldName), true)
+		fs.names.drop(fs.key(newName), true)
+	}
+	return code
+}
+
+func (fs *foldingFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	code := fs.call(name, context, func(p string) fuse.Status {
+		code := fs.FileSystem.Rmdir(p, context)
+		if code.Ok() {
+			fs.changed(p)
+		}
+		return code
+	})
+	if code.Ok() {
+		fs.names.drop(fs.key(name), true)
+	}
+	return code
+}
+
+func (fs *foldingFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	code := fs.call(name, context, func(p string) fuse.Status {
+		code := fs.FileSystem.Unlink(p, context)
+		if code.Ok() {
+			fs.changed(p)
+		}
+		return code
+	})
+	if code.Ok() {
+		fs.names.drop(fs.key(name), false)
+	}
+	return code
+}
+
+func (fs *foldingFileSystem) GetXAttr(name string, attr string, context *fuse.Context) ([]byte, fuse.Status) {
+	return foldCall(fs, name, context, func(p string) ([]byte, fuse.Status) {
+		return fs.FileSystem.GetXAttr(p, attr, context)
+	})
+}
+
+func (fs *foldingFileSystem) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	return foldCall(fs, name, context, func(p string) ([]string, fuse.Status) {
+		return fs.FileSystem.ListXAttr(p, context)
+	})
+}
+
+func (fs *foldingFileSystem) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	return fs.call(name, context, func(p string) fuse.Status {
+		return fs.FileSystem.RemoveXAttr(p, attr, context)
+	})
+}
+
+func (fs *foldingFileSystem) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	return fs.call(name, context, func(p string) fuse.Status {
+		return fs.FileSystem.SetXAttr(p, attr, data, flags, context)
+	})
+}
+
+func (fs *foldingFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return foldCall(fs, name, context, func(p string) (nodefs.File, fuse.Status) {
+		return fs.FileSystem.Open(p, flags, context)
+	})
+}
+
+func (fs *foldingFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return foldCall(fs, name, context, func(p string) (nodefs.File, fuse.Status) {
+		f, code := fs.FileSystem.Create(p, flags, mode, context)
+		if code.Ok() {
+			fs.changed(p)
+		}
+		return f, code
+	})
+}
+
+func (fs *foldingFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	stream, code := foldCall(fs, name, context, func(p string) ([]fuse.DirEntry, fuse.Status) {
+		return fs.FileSystem.OpenDir(p, context)
+	})
+	if !code.Ok() {
+		return nil, code
+	}
+	if !fs.strict {
+		// Hide all but the first of each set of equivalent names.
+		sort.Slice(stream, func(i, j int) bool { return stream[i].Name < stream[j].Name })
+		seen := make(map[string]struct{}, len(stream))
+		out := stream[:0]
+		for _, e := range stream {
+			k := fs.key(e.Name)
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			out = append(out, e)
+		}
+		stream = out
+	}
+	for i := range stream {
+		stream[i].Name = fs.display(stream[i].Name)
+	}
+	return stream, fuse.OK
+}
+
+func (fs *foldingFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	return fs.call(linkName, context, func(p string) fuse.Status {
+		code := fs.FileSystem.Symlink(value, p, context)
+		if code.Ok() {
+			fs.changed(p)
+		}
+		return code
+	})
+}
+
+func (fs *foldingFileSystem) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	return foldCall(fs, name, context, func(p string) (string, fuse.Status) {
+		return fs.FileSystem.Readlink(p, context)
+	})
+}
+
+func (fs *foldingFileSystem) StatFs(name string) *fuse.StatfsOut {
+	p, _, code := fs.resolve(name, nil)
+	if !code.Ok() {
+		return nil
+	}
+	return fs.FileSystem.StatFs(p)
+}
+
+func (fs *foldingFileSystem) String() string {
+	return fmt.Sprintf("%s(%s)", fs.name, fs.FileSystem.String())
+}
@@ -0,0 +1,184 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// InodeNumbers decides the inode numbers (st_ino) that the kernel
+// sees for the files of a PathNodeFs. Tools like backup programs and
+// git expect these to stay the same across remounts, which the node
+// IDs the nodefs layer falls back to do not.
+//
+// Set it in PathNodeFsOptions.Inodes. If it also has a method
+// Rename(oldPath, newPath string), that is called after successful
+// renames.
+type InodeNumbers interface {
+	// Ino returns the inode number for the file at path, whose
+	// attributes from FileSystem.GetAttr are given. 0 means the
+	// node ID is used.
+	Ino(path string, attr *fuse.Attr) uint64
+}
+
+type passthroughInodes struct{}
+
+// PassthroughInodes returns the inode numbers that the FileSystem
+// puts in fuse.Attr.Ino, eg. the st_ino of the backing files for the
+// loopback file system. This is the default.
+func PassthroughInodes() InodeNumbers {
+	return passthroughInodes{}
+}
+
+func (passthroughInodes) Ino(path string, attr *fuse.Attr) uint64 {
+	return attr.Ino
+}
+
+type hashInodes struct{}
+
+// HashInodes derives inode numbers from a 64-bit hash of the path,
+// which needs no state, but gives a renamed file a new number, and
+// makes hard links look like different files. Collisions are
+// unlikely, but possible.
+func HashInodes() InodeNumbers {
+	return hashInodes{}
+}
+
+func (hashInodes) Ino(path string, attr *fuse.Attr) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	ino := h.Sum64()
+	if ino <= fuse.FUSE_ROOT_ID {
+		// Don't collide with "unset" and the root.
+		ino += 2
+	}
+	return ino
+}
+
+// PersistentInodes hands out inode numbers sequentially, and records
+// them in a file, so they survive remounts. Renames keep the number.
+// Numbers of deleted files are not reclaimed, so a file created
+// later under the same path gets the old number back.
+type PersistentInodes struct {
+	mu   sync.Mutex
+	f    *os.File
+	inos map[string]uint64
+	next uint64
+}
+
+// NewPersistentInodes loads the allocations from file, creating it
+// if needed. The file is appended to as files are discovered; call
+// Close when unmounted.
+func NewPersistentInodes(file string) (*PersistentInodes, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	p := &PersistentInodes{
+		f:    f,
+		inos: map[string]uint64{},
+		next: fuse.FUSE_ROOT_ID + 1,
+	}
+	if err := p.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return p, nil
+}
+
+// The file has a line per event: "<ino> <quoted path>" assigns a
+// number, "- <quoted path>" drops the path.
+func (p *PersistentInodes) load() error {
+	scanner := bufio.NewScanner(p.f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: malformed", n)
+		}
+		path, err := strconv.Unquote(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		if fields[0] == "-" {
+			delete(p.inos, path)
+			continue
+		}
+		ino, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		p.inos[path] = ino
+		if ino >= p.next {
+			p.next = ino + 1
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *PersistentInodes) record(format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(p.f, format, args...); err != nil {
+		// The number is still good for this mount.
+		p.f.Close()
+		p.f = nil
+	}
+}
+
+// Ino implements InodeNumbers.
+func (p *PersistentInodes) Ino(path string, attr *fuse.Attr) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ino, ok := p.inos[path]; ok {
+		return ino
+	}
+	ino := p.next
+	p.next++
+	p.inos[path] = ino
+	if p.f != nil {
+		p.record("%d %q\n", ino, path)
+	}
+	return ino
+}
+
+// Rename moves the number of oldPath, and of the files below it, to
+// newPath.
+func (p *PersistentInodes) Rename(oldPath, newPath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for path, ino := range p.inos {
+		var to string
+		if path == oldPath {
+			to = newPath
+		} else if strings.HasPrefix(path, oldPath+"/") {
+			to = newPath + path[len(oldPath):]
+		} else {
+			continue
+		}
+		delete(p.inos, path)
+		p.inos[to] = ino
+		if p.f != nil {
+			p.record("- %q\n%d %q\n", path, ino, to)
+		}
+	}
+}
+
+// Close closes the allocation file.
+func (p *PersistentInodes) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f == nil {
+		return nil
+	}
+	err := p.f.Close()
+	p.f = nil
+	return err
+}
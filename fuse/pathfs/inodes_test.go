@@ -0,0 +1,63 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestHashInodes(t *testing.T) {
+	h := HashInodes()
+	a := h.Ino("dir/file", &fuse.Attr{Ino: 5})
+	if a != h.Ino("dir/file", &fuse.Attr{Ino: 6}) {
+		t.Errorf("number depends on the backing inode")
+	}
+	if a == h.Ino("dir/other", &fuse.Attr{}) {
+		t.Errorf("different paths got the same number")
+	}
+}
+
+func TestPersistentInodes(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "inodes")
+
+	p, err := NewPersistentInodes(file)
+	if err != nil {
+		t.Fatalf("NewPersistentInodes: %v", err)
+	}
+	a := p.Ino("a", &fuse.Attr{})
+	b := p.Ino("d/b", &fuse.Attr{})
+	if a == b || a <= fuse.FUSE_ROOT_ID || b <= fuse.FUSE_ROOT_ID {
+		t.Fatalf("got numbers %d, %d", a, b)
+	}
+	if got := p.Ino("a", &fuse.Attr{}); got != a {
+		t.Errorf("second call: got %d, want %d", got, a)
+	}
+	p.Rename("d", "e")
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p, err = NewPersistentInodes(file)
+	if err != nil {
+		t.Fatalf("NewPersistentInodes again: %v", err)
+	}
+	defer p.Close()
+	if got := p.Ino("a", &fuse.Attr{}); got != a {
+		t.Errorf("after reload: got %d, want %d", got, a)
+	}
+	if got := p.Ino("e/b", &fuse.Attr{}); got != b {
+		t.Errorf("renamed file: got %d, want %d", got, b)
+	}
+	if got := p.Ino("d/b", &fuse.Attr{}); got == a || got == b {
+		t.Errorf("new file at the old path reused number %d", got)
+	}
+}
@@ -0,0 +1,654 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// JournalSync says when NewJournalFileSystem flushes its journal to
+// disk.
+type JournalSync int
+
+const (
+	// Flush each change before it is passed on. Nothing is lost,
+	// but each change waits for the disk.
+	JournalSyncAlways = JournalSync(iota)
+
+	// Flush every JournalOptions.Interval. A crash loses the
+	// changes of the last interval.
+	JournalSyncPeriodic
+
+	// Leave flushing to the kernel. A crash of the daemon loses
+	// nothing, a crash of the machine may.
+	JournalSyncNever
+)
+
+// JournalOptions configures NewJournalFileSystem.
+type JournalOptions struct {
+	Sync JournalSync
+
+	// For JournalSyncPeriodic; 0 means a second.
+	Interval time.Duration
+}
+
+// JournalRecord is a change in the journal of NewJournalFileSystem.
+// The fields used depend on Op, which names the FileSystem or File
+// method, eg. "Mkdir" or "Write". Records with Op "Failed" say that
+// the change with Seq Failed was refused by the file system, so it
+// is not replayed.
+type JournalRecord struct {
+	Seq uint64
+	Op  string
+
+	// The caller of the change.
+	Owner fuse.Owner
+
+	Name    string `json:",omitempty"`
+	NewName string `json:",omitempty"`
+	Value   string `json:",omitempty"`
+	Attr    string `json:",omitempty"`
+
+	Mode  uint32 `json:",omitempty"`
+	Uid   uint32 `json:",omitempty"`
+	Gid   uint32 `json:",omitempty"`
+	Dev   uint32 `json:",omitempty"`
+	Flags uint32 `json:",omitempty"`
+	Size  uint64 `json:",omitempty"`
+	Off   int64  `json:",omitempty"`
+	Data  []byte `json:",omitempty"`
+
+	Atime *time.Time `json:",omitempty"`
+	Mtime *time.Time `json:",omitempty"`
+
+	Failed uint64 `json:",omitempty"`
+}
+
+func (r *JournalRecord) String() string {
+	return fmt.Sprintf("%d %s %q", r.Seq, r.Op, r.Name)
+}
+
+// Apply makes the change of r to fs. Writes and other changes to
+// open files are made by opening the file for the call.
+func (r *JournalRecord) Apply(fs FileSystem) fuse.Status {
+	context := &fuse.Context{Owner: r.Owner}
+	switch r.Op {
+	case "Chmod":
+		return fs.Chmod(r.Name, r.Mode, context)
+	case "Chown":
+		return fs.Chown(r.Name, r.Uid, r.Gid, context)
+	case "Utimens":
+		return fs.Utimens(r.Name, r.Atime, r.Mtime, context)
+	case "Truncate":
+		return fs.Truncate(r.Name, r.Size, context)
+	case "Link":
+		return fs.Link(r.Name, r.NewName, context)
+	case "Mkdir":
+		return fs.Mkdir(r.Name, r.Mode, context)
+	case "Mknod":
+		return fs.Mknod(r.Name, r.Mode, r.Dev, context)
+	case "Rename":
+		return fs.Rename(r.Name, r.NewName, context)
+	case "Rmdir":
+		return fs.Rmdir(r.Name, context)
+	case "Unlink":
+		return fs.Unlink(r.Name, context)
+	case "Symlink":
+		return fs.Symlink(r.Value, r.Name, context)
+	case "SetXAttr":
+		return fs.SetXAttr(r.Name, r.Attr, r.Data, int(r.Flags), context)
+	case "RemoveXAttr":
+		return fs.RemoveXAttr(r.Name, r.Attr, context)
+	case "Create":
+		f, code := fs.Create(r.Name, r.Flags, r.Mode, context)
+		if f != nil {
+			f.Release()
+		}
+		return code
+	case "Write", "Allocate":
+		f, code := fs.Open(r.Name, r.Flags, context)
+		if !code.Ok() {
+			return code
+		}
+		defer f.Release()
+		if r.Op == "Allocate" {
+			return f.Allocate(uint64(r.Off), r.Size, r.Mode)
+		}
+		if _, code = f.Write(r.Data, r.Off); !code.Ok() {
+			return code
+		}
+		return f.Flush()
+	}
+	return fuse.EINVAL
+}
+
+// ReadJournal returns the records of the journal at path that are
+// to be replayed, in order. A record cut short by a crash at the end
+// of the journal is dropped.
+func ReadJournal(path string) ([]JournalRecord, error) {
+	records, _, err := readJournal(path)
+	return records, err
+}
+
+// readJournal is ReadJournal, and also returns the highest Seq in
+// the journal, of all records.
+func readJournal(path string) ([]JournalRecord, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var last uint64
+
+	var records []JournalRecord
+	failed := map[uint64]bool{}
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<30)
+	for s.Scan() {
+		var r JournalRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			// Only the last record can be torn.
+			if s.Scan() {
+				return nil, 0, fmt.Errorf("%s: record after %d: %v", path, len(records), err)
+			}
+			break
+		}
+		last = max(last, r.Seq)
+		if r.Op == "Failed" {
+			failed[r.Failed] = true
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(failed) == 0 {
+		return records, last, nil
+	}
+	kept := records[:0]
+	for _, r := range records {
+		if !failed[r.Seq] {
+			kept = append(kept, r)
+		}
+	}
+	return kept, last, nil
+}
+
+// ReplayJournal applies the records of the journal at path to fs, eg.
+// on startup after a crash, before mounting. If a change fails,
+// onError decides what happens: it returns nil to go on, or an error
+// to stop with. If onError is nil, the first failure stops the
+// replay. Changes that the backend applied before the crash may fail
+// again, eg. with EEXIST for Mkdir, and appending writes are applied
+// again; onError can skip the former.
+func ReplayJournal(path string, fs FileSystem, onError func(r *JournalRecord, code fuse.Status) error) error {
+	records, err := ReadJournal(path)
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		r := &records[i]
+		code := r.Apply(fs)
+		if code.Ok() {
+			continue
+		}
+		if onError == nil {
+			return fmt.Errorf("replay %v: %v", r, code)
+		}
+		if err := onError(r, code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JournalFileSystem records the changes made through it in a
+// journal before passing them on, so that a file system whose
+// backend applies changes asynchronously can replay the ones it lost
+// in a crash; see ReplayJournal. The backend calls Checkpoint once
+// all changes passed to it so far are safe.
+//
+// Writes and other changes through open files are recorded with the
+// name the file was opened with; a file renamed while open is
+// replayed under its old name.
+//
+// Changes to a name are passed on one at a time, each after its
+// record, so they replay in the order the backend saw them; this
+// also holds for concurrent writes to one file. Changes to different
+// names run in parallel, so a change below a directory that is
+// renamed at the same time may replay on the other side of the
+// rename.
+type JournalFileSystem struct {
+	FileSystem
+	opts JournalOptions
+
+	// names serializes the changes to each name.
+	names nameLocks
+
+	mu   sync.Mutex
+	f    *os.File
+	seq  uint64
+	err  error
+	done chan struct{}
+}
+
+// NewJournalFileSystem wraps fs, appending its changes to the
+// journal at path, which is created if needed. Existing records are
+// kept, except for one torn by a crash, so replay the journal and
+// Checkpoint before mounting, if it may hold changes from a crash.
+func NewJournalFileSystem(fs FileSystem, path string, opts JournalOptions) (*JournalFileSystem, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := dropTornRecord(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j := &JournalFileSystem{FileSystem: fs, opts: opts, f: f, done: make(chan struct{})}
+	// Failed records name a Seq, so numbers go on from the
+	// records kept.
+	if _, j.seq, err = readJournal(path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if opts.Sync == JournalSyncPeriodic {
+		if j.opts.Interval <= 0 {
+			j.opts.Interval = time.Second
+		}
+		go j.syncLoop()
+	}
+	return j, nil
+}
+
+// dropTornRecord cuts f after its last complete record, so records
+// appended later do not follow a torn one.
+func dropTornRecord(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := fi.Size()
+	buf := make([]byte, 4096)
+	for end > 0 {
+		off := end - int64(len(buf))
+		if off < 0 {
+			off = 0
+		}
+		n, err := f.ReadAt(buf[:end-off], off)
+		if err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = off + int64(i) + 1
+			break
+		}
+		end = off
+	}
+	if end == fi.Size() {
+		return nil
+	}
+	return f.Truncate(end)
+}
+
+func (fs *JournalFileSystem) String() string {
+	return fmt.Sprintf("JournalFileSystem(%v)", fs.FileSystem)
+}
+
+func (fs *JournalFileSystem) syncLoop() {
+	t := time.NewTicker(fs.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-fs.done:
+			return
+		case <-t.C:
+			fs.mu.Lock()
+			if fs.f != nil {
+				fs.noteErr(fs.f.Sync())
+			}
+			fs.mu.Unlock()
+		}
+	}
+}
+
+// noteErr keeps the first error writing the journal. The caller
+// holds mu.
+func (fs *JournalFileSystem) noteErr(err error) {
+	if err != nil && fs.err == nil {
+		log.Printf("journal %s: %v", fs.f.Name(), err)
+		fs.err = err
+	}
+}
+
+// Checkpoint empties the journal. Call it when the backend has made
+// all changes passed to it so far durable, and none are in flight.
+func (fs *JournalFileSystem) Checkpoint() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return os.ErrClosed
+	}
+	if err := fs.f.Truncate(0); err != nil {
+		return err
+	}
+	return fs.f.Sync()
+}
+
+// Close flushes and closes the journal. Later changes fail with EIO.
+func (fs *JournalFileSystem) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return os.ErrClosed
+	}
+	close(fs.done)
+	err := fs.f.Sync()
+	if cerr := fs.f.Close(); err == nil {
+		err = cerr
+	}
+	fs.f = nil
+	return err
+}
+
+// record appends r to the journal, and returns its Seq. Once the
+// journal cannot be written, changes fail with EIO, rather than be
+// made without a record.
+func (fs *JournalFileSystem) record(r *JournalRecord, context *fuse.Context) (uint64, fuse.Status) {
+	if context != nil {
+		r.Owner = context.Owner
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil || fs.err != nil {
+		return 0, fuse.EIO
+	}
+	fs.seq++
+	r.Seq = fs.seq
+	line, err := json.Marshal(r)
+	if err != nil {
+		return 0, fuse.EIO
+	}
+	_, err = fs.f.Write(append(line, '\n'))
+	if err == nil && fs.opts.Sync == JournalSyncAlways {
+		err = fs.f.Sync()
+	}
+	if err != nil {
+		fs.noteErr(err)
+		return 0, fuse.EIO
+	}
+	return r.Seq, fuse.OK
+}
+
+// change records r, and then runs f, noting if it fails. Other
+// changes to the names of r wait until it is done.
+func (fs *JournalFileSystem) change(r *JournalRecord, context *fuse.Context, f func() fuse.Status) fuse.Status {
+	defer fs.names.lock(r.Name, r.NewName)()
+	seq, code := fs.record(r, context)
+	if !code.Ok() {
+		return code
+	}
+	code = f()
+	if !code.Ok() {
+		fs.record(&JournalRecord{Op: "Failed", Failed: seq}, context)
+	}
+	return code
+}
+
+func (fs *JournalFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Chmod", Name: name, Mode: mode}, context, func() fuse.Status {
+		return fs.FileSystem.Chmod(name, mode, context)
+	})
+}
+
+func (fs *JournalFileSystem) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Chown", Name: name, Uid: uid, Gid: gid}, context, func() fuse.Status {
+		return fs.FileSystem.Chown(name, uid, gid, context)
+	})
+}
+
+func (fs *JournalFileSystem) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Utimens", Name: name, Atime: atime, Mtime: mtime}, context, func() fuse.Status {
+		return fs.FileSystem.Utimens(name, atime, mtime, context)
+	})
+}
+
+func (fs *JournalFileSystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Truncate", Name: name, Size: size}, context, func() fuse.Status {
+		return fs.FileSystem.Truncate(name, size, context)
+	})
+}
+
+func (fs *JournalFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Link", Name: oldName, NewName: newName}, context, func() fuse.Status {
+		return fs.FileSystem.Link(oldName, newName, context)
+	})
+}
+
+func (fs *JournalFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Mkdir", Name: name, Mode: mode}, context, func() fuse.Status {
+		return fs.FileSystem.Mkdir(name, mode, context)
+	})
+}
+
+func (fs *JournalFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Mknod", Name: name, Mode: mode, Dev: dev}, context, func() fuse.Status {
+		return fs.FileSystem.Mknod(name, mode, dev, context)
+	})
+}
+
+func (fs *JournalFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Rename", Name: oldName, NewName: newName}, context, func() fuse.Status {
+		return fs.FileSystem.Rename(oldName, newName, context)
+	})
+}
+
+func (fs *JournalFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Rmdir", Name: name}, context, func() fuse.Status {
+		return fs.FileSystem.Rmdir(name, context)
+	})
+}
+
+func (fs *JournalFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Unlink", Name: name}, context, func() fuse.Status {
+		return fs.FileSystem.Unlink(name, context)
+	})
+}
+
+func (fs *JournalFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "Symlink", Name: linkName, Value: value}, context, func() fuse.Status {
+		return fs.FileSystem.Symlink(value, linkName, context)
+	})
+}
+
+func (fs *JournalFileSystem) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "SetXAttr", Name: name, Attr: attr, Data: data, Flags: uint32(flags)}, context, func() fuse.Status {
+		return fs.FileSystem.SetXAttr(name, attr, data, flags, context)
+	})
+}
+
+func (fs *JournalFileSystem) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	return fs.change(&JournalRecord{Op: "RemoveXAttr", Name: name, Attr: attr}, context, func() fuse.Status {
+		return fs.FileSystem.RemoveXAttr(name, attr, context)
+	})
+}
+
+func (fs *JournalFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if flags&fuse.O_ANYWRITE == 0 {
+		return fs.FileSystem.Open(name, flags, context)
+	}
+	var f nodefs.File
+	open := func() fuse.Status {
+		var code fuse.Status
+		f, code = fs.FileSystem.Open(name, flags, context)
+		return code
+	}
+	var code fuse.Status
+	if flags&syscall.O_TRUNC != 0 {
+		code = fs.change(&JournalRecord{Op: "Truncate", Name: name}, context, open)
+	} else {
+		code = open()
+	}
+	return fs.wrapFile(f, name, flags, context), code
+}
+
+func (fs *JournalFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	var f nodefs.File
+	// The file may exist by the time of a replay.
+	r := &JournalRecord{Op: "Create", Name: name, Flags: flags &^ syscall.O_EXCL, Mode: mode}
+	code := fs.change(r, context, func() fuse.Status {
+		var code fuse.Status
+		f, code = fs.FileSystem.Create(name, flags, mode, context)
+		return code
+	})
+	return fs.wrapFile(f, name, flags, context), code
+}
+
+func (fs *JournalFileSystem) wrapFile(f nodefs.File, name string, flags uint32, context *fuse.Context) nodefs.File {
+	if f == nil {
+		return nil
+	}
+	jf := &journalFile{File: f, fs: fs, name: name}
+	if flags&syscall.O_APPEND != 0 {
+		jf.flags = syscall.O_APPEND
+	}
+	if context != nil {
+		jf.owner = context.Owner
+	}
+	return jf
+}
+
+// nameLocks hands out a mutex per name, for as long as it is used.
+type nameLocks struct {
+	mu    sync.Mutex
+	locks map[string]*nameLock
+}
+
+type nameLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the names that are not empty, in sorted order so
+// changes to two names cannot deadlock, and returns the function
+// that unlocks them.
+func (l *nameLocks) lock(names ...string) (unlock func()) {
+	sort.Strings(names)
+	var held []string
+	for _, n := range names {
+		if n != "" && (len(held) == 0 || held[len(held)-1] != n) {
+			held = append(held, n)
+		}
+	}
+
+	locks := make([]*nameLock, len(held))
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*nameLock{}
+	}
+	for i, n := range held {
+		nl := l.locks[n]
+		if nl == nil {
+			nl = &nameLock{}
+			l.locks[n] = nl
+		}
+		nl.refs++
+		locks[i] = nl
+	}
+	l.mu.Unlock()
+
+	for _, nl := range locks {
+		nl.Lock()
+	}
+	return func() {
+		for _, nl := range locks {
+			nl.Unlock()
+		}
+		l.mu.Lock()
+		for i, n := range held {
+			if locks[i].refs--; locks[i].refs == 0 {
+				delete(l.locks, n)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// journalFile records the changes made through an open file.
+type journalFile struct {
+	nodefs.File
+	fs    *JournalFileSystem
+	name  string
+	owner fuse.Owner
+	// Open flags for replaying writes.
+	flags uint32
+}
+
+func (f *journalFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *journalFile) String() string {
+	return fmt.Sprintf("journalFile(%s)", f.File.String())
+}
+
+func (f *journalFile) change(r *JournalRecord, do func() fuse.Status) fuse.Status {
+	r.Name = f.name
+	return f.fs.change(r, &fuse.Context{Owner: f.owner}, do)
+}
+
+func (f *journalFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	var n uint32
+	code := f.change(&JournalRecord{Op: "Write", Off: off, Data: data, Flags: syscall.O_WRONLY | f.flags}, func() fuse.Status {
+		var code fuse.Status
+		n, code = f.File.Write(data, off)
+		return code
+	})
+	return n, code
+}
+
+func (f *journalFile) Truncate(size uint64) fuse.Status {
+	return f.change(&JournalRecord{Op: "Truncate", Size: size}, func() fuse.Status {
+		return f.File.Truncate(size)
+	})
+}
+
+func (f *journalFile) Chown(uid uint32, gid uint32) fuse.Status {
+	return f.change(&JournalRecord{Op: "Chown", Uid: uid, Gid: gid}, func() fuse.Status {
+		return f.File.Chown(uid, gid)
+	})
+}
+
+func (f *journalFile) Chmod(perms uint32) fuse.Status {
+	return f.change(&JournalRecord{Op: "Chmod", Mode: perms}, func() fuse.Status {
+		return f.File.Chmod(perms)
+	})
+}
+
+func (f *journalFile) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
+	return f.change(&JournalRecord{Op: "Utimens", Atime: atime, Mtime: mtime}, func() fuse.Status {
+		return f.File.Utimens(atime, mtime)
+	})
+}
+
+func (f *journalFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	return f.change(&JournalRecord{Op: "Allocate", Off: int64(off), Size: size, Mode: mode, Flags: syscall.O_WRONLY}, func() fuse.Status {
+		return f.File.Allocate(off, size, mode)
+	})
+}
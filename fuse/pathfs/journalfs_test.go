@@ -0,0 +1,169 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestJournalFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	orig := filepath.Join(dir, "orig")
+	replica := filepath.Join(dir, "replica")
+	for _, d := range []string{orig, replica} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal := filepath.Join(dir, "journal")
+
+	fs, err := NewJournalFileSystem(NewLoopbackFileSystem(orig), journal, JournalOptions{})
+	if err != nil {
+		t.Fatalf("NewJournalFileSystem: %v", err)
+	}
+	ctx := &fuse.Context{}
+	if code := fs.Mkdir("sub", 0755, ctx); !code.Ok() {
+		t.Fatalf("Mkdir: %v", code)
+	}
+	f, code := fs.Create("sub/file", syscall.O_WRONLY|syscall.O_EXCL, 0644, ctx)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	if _, code := f.Write([]byte("hello"), 0); !code.Ok() {
+		t.Fatalf("Write: %v", code)
+	}
+	f.Release()
+	if code := fs.Rename("sub/file", "sub/renamed", ctx); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	// Failed changes are not replayed.
+	if code := fs.Rmdir("missing", ctx); code != fuse.ENOENT {
+		t.Fatalf("Rmdir: got %v, want ENOENT", code)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A record torn by a crash is dropped.
+	jf, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jf.WriteString(`{"Seq":99,"Op":"Mkd`)
+	jf.Close()
+
+	records, err := ReadJournal(journal)
+	if err != nil {
+		t.Fatalf("ReadJournal: %v", err)
+	}
+	var ops []string
+	for _, r := range records {
+		ops = append(ops, r.Op)
+	}
+	if got, want := len(ops), 4; got != want {
+		t.Fatalf("got records %v, want Mkdir Create Write Rename", ops)
+	}
+
+	if err := ReplayJournal(journal, NewLoopbackFileSystem(replica), nil); err != nil {
+		t.Fatalf("ReplayJournal: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(replica, "sub/renamed"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("replica: got %q, %v", data, err)
+	}
+
+	// Replaying onto the original fails on the Mkdir.
+	if err := ReplayJournal(journal, NewLoopbackFileSystem(orig), nil); err == nil {
+		t.Errorf("ReplayJournal on applied changes succeeded")
+	}
+
+	// Reopening drops the torn record, so new records can follow.
+	fs, err = NewJournalFileSystem(NewLoopbackFileSystem(orig), journal, JournalOptions{Sync: JournalSyncPeriodic})
+	if err != nil {
+		t.Fatalf("NewJournalFileSystem: %v", err)
+	}
+	fs.Mkdir("sub2", 0755, ctx)
+	if records, err := ReadJournal(journal); err != nil || len(records) != 5 {
+		t.Errorf("after reopening: got %d records, %v", len(records), err)
+	}
+
+	// After a checkpoint, there is nothing to replay.
+	if err := fs.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	fs.Close()
+	if records, err := ReadJournal(journal); err != nil || len(records) != 0 {
+		t.Errorf("after Checkpoint: got %v, %v", records, err)
+	}
+}
+
+// jitterFileSystem delays Chmod, so concurrent calls finish in
+// another order than they started.
+type jitterFileSystem struct {
+	FileSystem
+}
+
+func (fs *jitterFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	return fs.FileSystem.Chmod(name, mode, context)
+}
+
+// Concurrent changes to one name replay in the order they were made.
+func TestJournalFileSystemOrder(t *testing.T) {
+	dir := t.TempDir()
+	orig := filepath.Join(dir, "orig")
+	replica := filepath.Join(dir, "replica")
+	for _, d := range []string{orig, replica} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(d, "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal := filepath.Join(dir, "journal")
+	fs, err := NewJournalFileSystem(&jitterFileSystem{NewLoopbackFileSystem(orig)}, journal, JournalOptions{Sync: JournalSyncNever})
+	if err != nil {
+		t.Fatalf("NewJournalFileSystem: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(mode uint32) {
+			defer wg.Done()
+			if code := fs.Chmod("file", mode, &fuse.Context{}); !code.Ok() {
+				t.Errorf("Chmod: %v", code)
+			}
+		}(0600 + uint32(i%64))
+	}
+	wg.Wait()
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if err := ReplayJournal(journal, NewLoopbackFileSystem(replica), nil); err != nil {
+		t.Fatalf("ReplayJournal: %v", err)
+	}
+	want, err := os.Stat(filepath.Join(orig, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.Stat(filepath.Join(replica, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode() != want.Mode() {
+		t.Errorf("replica has mode %v, original %v", got.Mode(), want.Mode())
+	}
+}
@@ -5,11 +5,12 @@
 package pathfs
 
 import (
-	"io"
-	"log"
+	"bytes"
+	"encoding/binary"
 	"os"
 	"path/filepath"
 	"syscall"
+	"unsafe"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
@@ -84,38 +85,78 @@ func (fs *loopbackFileSystem) OpenDir(name string, context *fuse.Context) (strea
 	if err != nil {
 		return nil, fuse.ToStatus(err)
 	}
-	want := 500
-	output := make([]fuse.DirEntry, 0, want)
+	defer f.Close()
+
+	output, err := readDirents(f)
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	return output, fuse.OK
+}
+
+// Offsets into the records returned by ReadDirent.
+var (
+	direntIno    = unsafe.Offsetof(syscall.Dirent{}.Ino)
+	direntReclen = unsafe.Offsetof(syscall.Dirent{}.Reclen)
+	direntType   = unsafe.Offsetof(syscall.Dirent{}.Type)
+	direntName   = unsafe.Offsetof(syscall.Dirent{}.Name)
+)
+
+// readDirents lists the directory f with getdents, taking the inode
+// number and file type from the directory entries, so the files
+// need not be stat'ed. Only entries for which the underlying file
+// system does not report a type are stat'ed.
+func readDirents(f *os.File) ([]fuse.DirEntry, error) {
+	var output []fuse.DirEntry
+	buf := make([]byte, 32<<10)
 	for {
-		infos, err := f.Readdir(want)
-		for i := range infos {
-			// workaround for https://code.google.com/p/go/issues/detail?id=5960
-			if infos[i] == nil {
+		n, err := syscall.ReadDirent(int(f.Fd()), buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			return output, nil
+		}
+
+		for b := buf[:n]; len(b) > int(direntName); {
+			reclen := int(binary.NativeEndian.Uint16(b[direntReclen:]))
+			if reclen <= int(direntName) || reclen > len(b) {
+				break
+			}
+			rec := b[:reclen]
+			b = b[reclen:]
+
+			ino := binary.NativeEndian.Uint64(rec[direntIno:])
+			if ino == 0 {
+				// Deleted entry.
+				continue
+			}
+			name := rec[direntName:]
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			if string(name) == "." || string(name) == ".." {
 				continue
 			}
-			n := infos[i].Name()
 			d := fuse.DirEntry{
-				Name: n,
+				Name: string(name),
+				Ino:  ino,
+				Mode: fuse.DirentMode(uint32(rec[direntType])),
 			}
-			if s := fuse.ToStatT(infos[i]); s != nil {
-				d.Mode = uint32(s.Mode)
-				d.Ino = s.Ino
-			} else {
-				log.Printf("ReadDir entry %q for %q has no stat info", n, name)
+			if d.Mode == 0 {
+				var st syscall.Stat_t
+				if err := syscall.Lstat(filepath.Join(f.Name(), d.Name), &st); err != nil {
+					// Deleted since we read the entry.
+					continue
+				}
+				d.Mode = uint32(st.Mode) & syscall.S_IFMT
 			}
 			output = append(output, d)
 		}
-		if len(infos) < want || err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Println("Readdir() returned err:", err)
-			break
-		}
 	}
-	f.Close()
-
-	return output, fuse.OK
 }
 
 func (fs *loopbackFileSystem) Open(name string, flags uint32, context *fuse.Context) (fuseFile nodefs.File, status fuse.Status) {
@@ -5,6 +5,7 @@
 package pathfs
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -33,3 +34,67 @@ func TestLoopbackFileSystemUtimens(t *testing.T) {
 	}
 	testutil.TestLoopbackUtimens(t, path, utimensFn)
 }
+
+func TestLoopbackOpenDirTypes(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(dir+"/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/file", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", dir+"/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(dir+"/fifo", 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Enough entries to need several getdents calls.
+	for i := 0; i < 1000; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("f%04d", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := NewLoopbackFileSystem(dir)
+	entries, code := fs.OpenDir("", nil)
+	if !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+	if len(entries) != 1004 {
+		t.Errorf("got %d entries, want 1004", len(entries))
+	}
+
+	want := map[string]uint32{
+		"dir":   syscall.S_IFDIR,
+		"file":  syscall.S_IFREG,
+		"link":  syscall.S_IFLNK,
+		"fifo":  syscall.S_IFIFO,
+		"f0999": syscall.S_IFREG,
+	}
+	for _, e := range entries {
+		m, ok := want[e.Name]
+		if !ok {
+			continue
+		}
+		delete(want, e.Name)
+		if e.Mode != m {
+			t.Errorf("%s: got mode %o, want %o", e.Name, e.Mode, m)
+		}
+		var st syscall.Stat_t
+		if err := syscall.Lstat(filepath.Join(dir, e.Name), &st); err != nil {
+			t.Fatal(err)
+		}
+		if e.Ino != st.Ino {
+			t.Errorf("%s: got ino %d, want %d", e.Name, e.Ino, st.Ino)
+		}
+		if got := fuse.DirentType(e.Mode); fuse.DirentMode(got) != m {
+			t.Errorf("%s: DirentType %d does not map back to %o", e.Name, got, m)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("missing entries %v", want)
+	}
+}
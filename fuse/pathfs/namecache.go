@@ -0,0 +1,72 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"strings"
+	"sync"
+)
+
+// maxCachedNames bounds the number of paths in a nameCache.
+const maxCachedNames = 4096
+
+// nameCache maps paths from the kernel, by their equivalence key,
+// onto the paths of existing entries in the inner file system, so
+// resolving a path that was seen before does not look at each
+// directory on the way. Renames and removals through the wrapper
+// drop the entries they affect; other changes are noticed when the
+// inner file system returns ENOENT for a cached path.
+type nameCache struct {
+	mu sync.Mutex
+
+	// gen is incremented by each drop, so a path resolved before
+	// a change is not added after it.
+	gen   uint64
+	paths map[string]string
+}
+
+// get returns the cached path for key k, or the generation to pass
+// to put.
+func (c *nameCache) get(k string) (path string, gen uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path, ok = c.paths[k]
+	return path, c.gen, ok
+}
+
+// put caches path for key k, unless something was dropped since gen
+// was returned from get.
+func (c *nameCache) put(k string, path string, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	if c.paths == nil || len(c.paths) >= maxCachedNames {
+		c.paths = map[string]string{}
+	}
+	c.paths[k] = path
+}
+
+// drop removes key k and, if tree is set, the keys below it.
+func (c *nameCache) drop(k string, tree bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	delete(c.paths, k)
+	if !tree {
+		return
+	}
+	if k == "" {
+		c.paths = nil
+		return
+	}
+	prefix := k + "/"
+	for p := range c.paths {
+		if strings.HasPrefix(p, prefix) {
+			delete(c.paths, p)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// NamePolicy says which names new entries may have. The zero value
+// allows all names.
+type NamePolicy struct {
+	// MaxNameLength is the maximum length of a name, in bytes.
+	// 0 means no limit.
+	MaxNameLength int
+
+	// Allowed reports whether a name may contain r. If nil, all
+	// characters are allowed.
+	Allowed func(r rune) bool
+
+	// If set, reject the names Windows reserves for devices, such
+	// as CON, NUL and COM1, with or without extension, and the
+	// characters Windows does not allow: <>:"\|?* and control
+	// characters.
+	Windows bool
+
+	// If set, reject names that end in a dot or a space, which
+	// Windows strips.
+	NoTrailingDotSpace bool
+}
+
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Check returns EINVAL if the last component of path violates the
+// policy, or ENAMETOOLONG if it is too long, as for names longer than
+// NAME_MAX.
+func (p *NamePolicy) Check(path string) fuse.Status {
+	name := filepath.Base(path)
+	if p.MaxNameLength > 0 && len(name) > p.MaxNameLength {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+	if p.NoTrailingDotSpace && (strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ")) {
+		return fuse.EINVAL
+	}
+	for _, r := range name {
+		if p.Allowed != nil && !p.Allowed(r) {
+			return fuse.EINVAL
+		}
+		if p.Windows && (r < 0x20 || strings.ContainsRune(`<>:"\|?*`, r)) {
+			return fuse.EINVAL
+		}
+	}
+	if p.Windows {
+		stem, _, _ := strings.Cut(name, ".")
+		if windowsReserved[strings.ToUpper(strings.TrimRight(stem, " "))] {
+			return fuse.EINVAL
+		}
+	}
+	return fuse.OK
+}
+
+// NewNamePolicyFileSystem returns a wrapper that refuses to create
+// entries whose names violate policy, for file systems that are
+// synchronized to a backend with stricter rules than POSIX. Creating
+// such an entry fails early, instead of when it is uploaded. Existing
+// entries are served as they are.
+func NewNamePolicyFileSystem(fs FileSystem, policy NamePolicy) FileSystem {
+	return &namePolicyFileSystem{fs, policy}
+}
+
+type namePolicyFileSystem struct {
+	FileSystem
+	policy NamePolicy
+}
+
+func (fs *namePolicyFileSystem) String() string {
+	return "namePolicyFileSystem(" + fs.FileSystem.String() + ")"
+}
+
+func (fs *namePolicyFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	if code := fs.policy.Check(name); !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Mknod(name, mode, dev, context)
+}
+
+func (fs *namePolicyFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if code := fs.policy.Check(name); !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Mkdir(name, mode, context)
+}
+
+func (fs *namePolicyFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	if code := fs.policy.Check(linkName); !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Symlink(value, linkName, context)
+}
+
+func (fs *namePolicyFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	if code := fs.policy.Check(newName); !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Rename(oldName, newName, context)
+}
+
+func (fs *namePolicyFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	if code := fs.policy.Check(newName); !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Link(oldName, newName, context)
+}
+
+func (fs *namePolicyFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if code := fs.policy.Check(name); !code.Ok() {
+		return nil, code
+	}
+	return fs.FileSystem.Create(name, flags, mode, context)
+}
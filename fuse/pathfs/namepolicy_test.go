@@ -0,0 +1,75 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"unicode"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestNamePolicyCheck(t *testing.T) {
+	p := NamePolicy{
+		MaxNameLength:      8,
+		Allowed:            func(r rune) bool { return r < unicode.MaxASCII },
+		Windows:            true,
+		NoTrailingDotSpace: true,
+	}
+	for name, want := range map[string]fuse.Status{
+		"dir/ok.txt":  fuse.OK,
+		"con2":        fuse.OK,
+		"toolong.txt": fuse.Status(syscall.ENAMETOOLONG),
+		"café":        fuse.EINVAL,
+		"a:b":         fuse.EINVAL,
+		"tab\t":       fuse.EINVAL,
+		"CON":         fuse.EINVAL,
+		"dir/nul.txt": fuse.EINVAL,
+		"lpt1 .c":     fuse.EINVAL,
+		"dot.":        fuse.EINVAL,
+		"space ":      fuse.EINVAL,
+	} {
+		if got := p.Check(name); got != want {
+			t.Errorf("Check(%q): got %v, want %v", name, got, want)
+		}
+	}
+	var zero NamePolicy
+	if got := zero.Check("CON."); !got.Ok() {
+		t.Errorf("zero policy: got %v", got)
+	}
+}
+
+func TestNamePolicyFileSystem(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(dir+"/aux", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewNamePolicyFileSystem(NewLoopbackFileSystem(dir), NamePolicy{Windows: true})
+
+	if code := fs.Mkdir("a|b", 0755, nil); code != fuse.EINVAL {
+		t.Errorf("Mkdir: got %v, want EINVAL", code)
+	}
+	if _, code := fs.Create("prn.txt", uint32(os.O_WRONLY), 0644, nil); code != fuse.EINVAL {
+		t.Errorf("Create: got %v, want EINVAL", code)
+	}
+	if code := fs.Rename("aux", "x?", nil); code != fuse.EINVAL {
+		t.Errorf("Rename: got %v, want EINVAL", code)
+	}
+	if _, err := os.Stat(dir + "/a|b"); !os.IsNotExist(err) {
+		t.Errorf("Mkdir reached the backend: %v", err)
+	}
+
+	// Existing names are served, and can be renamed to valid ones.
+	if _, code := fs.GetAttr("aux", nil); !code.Ok() {
+		t.Errorf("GetAttr: %v", code)
+	}
+	if code := fs.Rename("aux", "aux_", nil); !code.Ok() {
+		t.Errorf("Rename to a valid name: %v", code)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"golang.org/x/text/unicode/norm"
+)
+
+// NewNormalizingFileSystem returns a wrapper that normalizes the
+// Unicode file names between the kernel and fs. Names are shown to
+// the kernel in kernelForm, new entries are created in fs in
+// backendForm, and a name from the kernel matches any existing entry
+// that is equal in kernelForm. Entries of fs that are equal after
+// normalization are listed only once.
+//
+// For a tree shared with macOS clients, which write NFD names, use
+//
+//	fs = pathfs.NewNormalizingFileSystem(fs, norm.NFC, norm.NFD)
+func NewNormalizingFileSystem(fs FileSystem, kernelForm, backendForm norm.Form) FileSystem {
+	return &foldingFileSystem{
+		FileSystem: fs,
+		name:       "normalizingFileSystem",
+		key:        kernelForm.String,
+		display:    kernelForm.String,
+		create:     backendForm.String,
+	}
+}
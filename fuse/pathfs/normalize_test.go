@@ -0,0 +1,58 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/internal/testutil"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	nfcE = "\u00e9"
+	nfdE = "e\u0301"
+
+	// Hangul syllable, decomposed into jamo in NFD.
+	nfcHan = "\ud55c"
+	nfdHan = "\u1112\u1161\u11ab"
+)
+
+func TestNormalizingFileSystem(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	// Two visually identical names, as left by a Linux and a macOS client.
+	for _, n := range []string{"caf" + nfcE, "caf" + nfdE} {
+		if err := ioutil.WriteFile(dir+"/"+n, []byte(n), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := NewNormalizingFileSystem(NewLoopbackFileSystem(dir), norm.NFC, norm.NFD)
+	stream, code := fs.OpenDir("", nil)
+	if !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+	if len(stream) != 1 || stream[0].Name != "caf"+nfcE {
+		t.Errorf("got %v, want single NFC entry", stream)
+	}
+
+	if _, code := fs.GetAttr("caf"+nfdE, nil); !code.Ok() {
+		t.Errorf("GetAttr NFD: %v", code)
+	}
+
+	if code := fs.Mkdir("d"+nfcE+"j"+nfcE+nfcHan, 0755, nil); !code.Ok() {
+		t.Fatalf("Mkdir: %v", code)
+	}
+	if _, err := os.Stat(dir + "/d" + nfdE + "j" + nfdE + nfdHan); err != nil {
+		t.Errorf("new entry not created in backend form: %v", err)
+	}
+	if _, code := fs.GetAttr("d"+nfdE+"j"+nfcE+nfdHan, nil); !code.Ok() {
+		t.Errorf("GetAttr in mixed form: %v", code)
+	}
+}
@@ -42,6 +42,13 @@ type PathNodeFs struct {
 	// This map lists all the parent links known for a given inode number.
 	clientInodeMap map[uint64]*refCountedInode
 
+	// protects the link and linkStamp fields of pathInodes.
+	linkLock sync.Mutex
+
+	// Held by operations and renames, if
+	// PathNodeFsOptions.StablePaths is set.
+	renameLock pathPins
+
 	options *PathNodeFsOptions
 }
 
@@ -191,12 +198,15 @@ func (fs *PathNodeFs) AllFiles(name string, mask uint32) []nodefs.WithFlags {
 // NewPathNodeFs returns a file system that translates from inodes to
 // path names.
 func NewPathNodeFs(fs FileSystem, opts *PathNodeFsOptions) *PathNodeFs {
-	root := &pathInode{}
-	root.fs = fs
-
 	if opts == nil {
 		opts = &PathNodeFsOptions{}
 	}
+	if opts.Snapshot {
+		fs = NewSnapshotFileSystem(fs, nil)
+	}
+
+	root := &pathInode{}
+	root.fs = fs
 
 	pfs := &PathNodeFs{
 		fs:             fs,
@@ -204,10 +214,18 @@ func NewPathNodeFs(fs FileSystem, opts *PathNodeFsOptions) *PathNodeFs {
 		clientInodeMap: map[uint64]*refCountedInode{},
 		options:        opts,
 	}
+	pfs.renameLock.cond.L = &pfs.renameLock.mu
 	root.pathFs = pfs
 	return pfs
 }
 
+// setIno applies PathNodeFsOptions.Inodes to the attributes of path.
+func (fs *PathNodeFs) setIno(path string, attr *fuse.Attr) {
+	if fs.options.Inodes != nil {
+		attr.Ino = fs.options.Inodes.Ino(path, attr)
+	}
+}
+
 // Root returns the root node for the path filesystem.
 func (fs *PathNodeFs) Root() nodefs.Node {
 	return fs.root
@@ -224,6 +242,10 @@ type pathInode struct {
 	// real filesystem.
 	clientInode uint64
 	inode       *nodefs.Inode
+
+	// The cached symlink target; see readlinkcache.go.
+	link      *string
+	linkStamp linkStamp
 }
 
 func (n *pathInode) OnMount(conn *nodefs.FileSystemConnector) {
@@ -262,49 +284,81 @@ func (n *pathInode) updateClientInodes() {
 	}
 }
 
-// GetPath returns the path relative to the mount governing this
-// inode.  It returns nil for mount if the file was deleted or the
-// filesystem unmounted.
-func (n *pathInode) GetPath() string {
-	if n == n.pathFs.root {
-		return ""
-	}
+func noUnpin() {}
 
-	pathLen := 1
+// pinPaths keeps the paths of all nodes from changing until the
+// returned function is called, if PathNodeFsOptions.StablePaths is
+// set. Operations must not nest.
+func (fs *PathNodeFs) pinPaths() (unpin func()) {
+	if !fs.options.StablePaths {
+		return noUnpin
+	}
+	fs.renameLock.pin()
+	return fs.renameLock.unpin
+}
 
-	// The simple solution is to collect names, and reverse join
-	// them, them, but since this is a hot path, we take some
-	// effort to avoid allocations.
+// pathPins lets any number of operations pin the paths, or one rename
+// change them. Unlike with a sync.RWMutex, a rename that waits does
+// not hold up operations that start after it: an operation may block
+// in the FileSystem until another one comes in, eg. the open of a
+// FIFO waits for a writer, and that one must not queue up behind the
+// rename, which waits for the first. Renames wait until no operation
+// is in flight, and may be delayed for long under constant load.
+type pathPins struct {
+	mu       sync.Mutex
+	cond     sync.Cond
+	pins     int
+	renaming bool
+}
 
-	walkUp := n.Inode()
+func (p *pathPins) pin() {
+	p.mu.Lock()
+	for p.renaming {
+		p.cond.Wait()
+	}
+	p.pins++
+	p.mu.Unlock()
+}
 
-	// TODO - guess depth?
-	segments := make([]string, 0, 10)
-	for {
-		parent, name := walkUp.Parent()
-		if parent == nil {
-			break
-		}
-		segments = append(segments, name)
-		pathLen += len(name) + 1
-		walkUp = parent
+func (p *pathPins) unpin() {
+	p.mu.Lock()
+	p.pins--
+	if p.pins == 0 {
+		p.cond.Broadcast()
 	}
-	pathLen--
+	p.mu.Unlock()
+}
 
-	pathBytes := make([]byte, 0, pathLen)
-	for i := len(segments) - 1; i >= 0; i-- {
-		pathBytes = append(pathBytes, segments[i]...)
-		if i > 0 {
-			pathBytes = append(pathBytes, '/')
-		}
+// lock waits for the operations in flight, and keeps new ones from
+// starting until unlock.
+func (p *pathPins) lock() {
+	p.mu.Lock()
+	for p.renaming || p.pins > 0 {
+		p.cond.Wait()
 	}
+	p.renaming = true
+	p.mu.Unlock()
+}
+
+func (p *pathPins) unlock() {
+	p.mu.Lock()
+	p.renaming = false
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
 
-	path := string(pathBytes)
+// GetPath returns the path relative to the mount governing this
+// inode. If the file was deleted, but is still open, it returns
+// ".deleted".
+func (n *pathInode) GetPath() string {
+	if n == n.pathFs.root {
+		return ""
+	}
+	path, ok := n.Inode().Path()
 	if n.pathFs.debug {
 		log.Printf("Inode = %q (%s)", path, n.fs.String())
 	}
-
-	if walkUp != n.pathFs.root.Inode() {
+	if !ok {
 		// This might happen if the node has been removed from
 		// the tree using unlink, but we are forced to run
 		// some file system operation, because the file is
@@ -313,7 +367,6 @@ func (n *pathInode) GetPath() string {
 		// TODO - add a deterministic disambiguating suffix.
 		return ".deleted"
 	}
-
 	return path
 }
 
@@ -372,35 +425,52 @@ func (n *pathInode) OnForget() {
 // FS operations
 
 func (n *pathInode) StatFs() *fuse.StatfsOut {
+	defer n.pathFs.pinPaths()()
 	return n.fs.StatFs(n.GetPath())
 }
 
 func (n *pathInode) Readlink(c *fuse.Context) ([]byte, fuse.Status) {
-	path := n.GetPath()
+	defer n.pathFs.pinPaths()()
+	if !n.pathFs.options.CacheReadlink {
+		val, err := n.fs.Readlink(n.GetPath(), c)
+		return []byte(val), err
+	}
 
-	val, err := n.fs.Readlink(path, c)
-	return []byte(val), err
+	val, stamp, ok := n.cachedReadlink()
+	if ok {
+		return []byte(val), fuse.OK
+	}
+	val, code := n.fs.Readlink(n.GetPath(), c)
+	if code.Ok() {
+		n.setReadlink(val, stamp)
+	}
+	return []byte(val), code
 }
 
 func (n *pathInode) Access(mode uint32, context *fuse.Context) (code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	p := n.GetPath()
 	return n.fs.Access(p, mode, context)
 }
 
 func (n *pathInode) GetXAttr(attribute string, context *fuse.Context) (data []byte, code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	return n.fs.GetXAttr(n.GetPath(), attribute, context)
 }
 
 func (n *pathInode) RemoveXAttr(attr string, context *fuse.Context) fuse.Status {
+	defer n.pathFs.pinPaths()()
 	p := n.GetPath()
 	return n.fs.RemoveXAttr(p, attr, context)
 }
 
 func (n *pathInode) SetXAttr(attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	defer n.pathFs.pinPaths()()
 	return n.fs.SetXAttr(n.GetPath(), attr, data, flags, context)
 }
 
 func (n *pathInode) ListXAttr(context *fuse.Context) (attrs []string, code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	return n.fs.ListXAttr(n.GetPath(), context)
 }
 
@@ -409,10 +479,20 @@ func (n *pathInode) Flush(file nodefs.File, openFlags uint32, context *fuse.Cont
 }
 
 func (n *pathInode) OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
-	return n.fs.OpenDir(n.GetPath(), context)
+	defer n.pathFs.pinPaths()()
+	dir := n.GetPath()
+	entries, code := n.fs.OpenDir(dir, context)
+	if inodes := n.pathFs.options.Inodes; code.Ok() && inodes != nil {
+		for i := range entries {
+			attr := fuse.Attr{Ino: entries[i].Ino, Mode: entries[i].Mode}
+			entries[i].Ino = inodes.Ino(filepath.Join(dir, entries[i].Name), &attr)
+		}
+	}
+	return entries, code
 }
 
 func (n *pathInode) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	fullPath := filepath.Join(n.GetPath(), name)
 	code := n.fs.Mknod(fullPath, mode, dev, context)
 	var child *nodefs.Inode
@@ -424,6 +504,7 @@ func (n *pathInode) Mknod(name string, mode uint32, dev uint32, context *fuse.Co
 }
 
 func (n *pathInode) Mkdir(name string, mode uint32, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	fullPath := filepath.Join(n.GetPath(), name)
 	code := n.fs.Mkdir(fullPath, mode, context)
 	var child *nodefs.Inode
@@ -435,6 +516,7 @@ func (n *pathInode) Mkdir(name string, mode uint32, context *fuse.Context) (*nod
 }
 
 func (n *pathInode) Unlink(name string, context *fuse.Context) (code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	code = n.fs.Unlink(filepath.Join(n.GetPath(), name), context)
 	if code.Ok() {
 		n.Inode().RmChild(name)
@@ -443,6 +525,7 @@ func (n *pathInode) Unlink(name string, context *fuse.Context) (code fuse.Status
 }
 
 func (n *pathInode) Rmdir(name string, context *fuse.Context) (code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	code = n.fs.Rmdir(filepath.Join(n.GetPath(), name), context)
 	if code.Ok() {
 		n.Inode().RmChild(name)
@@ -451,6 +534,7 @@ func (n *pathInode) Rmdir(name string, context *fuse.Context) (code fuse.Status)
 }
 
 func (n *pathInode) Symlink(name string, content string, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	fullPath := filepath.Join(n.GetPath(), name)
 	code := n.fs.Symlink(content, fullPath, context)
 	var child *nodefs.Inode
@@ -462,6 +546,10 @@ func (n *pathInode) Symlink(name string, content string, context *fuse.Context)
 }
 
 func (n *pathInode) Rename(oldName string, newParent nodefs.Node, newName string, context *fuse.Context) (code fuse.Status) {
+	if n.pathFs.options.StablePaths {
+		n.pathFs.renameLock.lock()
+		defer n.pathFs.renameLock.unlock()
+	}
 	p := newParent.(*pathInode)
 	oldPath := filepath.Join(n.GetPath(), oldName)
 	newPath := filepath.Join(p.GetPath(), newName)
@@ -474,11 +562,15 @@ func (n *pathInode) Rename(oldName string, newParent nodefs.Node, newName string
 			// oldName may have been forgotten in the meantime.
 			p.Inode().AddChild(newName, ch)
 		}
+		if r, ok := n.pathFs.options.Inodes.(interface{ Rename(oldPath, newPath string) }); ok {
+			r.Rename(oldPath, newPath)
+		}
 	}
 	return code
 }
 
 func (n *pathInode) Link(name string, existingFsnode nodefs.Node, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	if !n.pathFs.options.ClientInodes {
 		return nil, fuse.ENOSYS
 	}
@@ -508,6 +600,7 @@ func (n *pathInode) Link(name string, existingFsnode nodefs.Node, context *fuse.
 }
 
 func (n *pathInode) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, *nodefs.Inode, fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	var child *nodefs.Inode
 	fullPath := filepath.Join(n.GetPath(), name)
 	file, code := n.fs.Create(fullPath, flags, mode, context)
@@ -530,6 +623,7 @@ func (n *pathInode) createChild(name string, isDir bool) *pathInode {
 }
 
 func (n *pathInode) Open(flags uint32, context *fuse.Context) (file nodefs.File, code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	p := n.GetPath()
 	file, code = n.fs.Open(p, flags, context)
 	if n.pathFs.debug {
@@ -542,6 +636,7 @@ func (n *pathInode) Open(flags uint32, context *fuse.Context) (file nodefs.File,
 }
 
 func (n *pathInode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	fullPath := filepath.Join(n.GetPath(), name)
 	fi, code := n.fs.GetAttr(fullPath, context)
 	node := n.Inode().GetChild(name)
@@ -550,10 +645,18 @@ func (n *pathInode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (
 		n.Inode().RmChild(name)
 		node = nil
 	}
+	if node != nil {
+		if ch, ok := node.Node().(*pathInode); ok {
+			ch.noteAttr(fi)
+		}
+	}
 
 	if code.Ok() && node == nil {
-		node = n.findChild(fi, name, fullPath).Inode()
+		ch := n.findChild(fi, name, fullPath)
+		ch.noteAttr(fi)
+		node = ch.Inode()
 		*out = *fi
+		n.pathFs.setIno(fullPath, out)
 	}
 
 	return node, code
@@ -583,6 +686,7 @@ func (n *pathInode) findChild(fi *fuse.Attr, name string, fullPath string) (out
 }
 
 func (n *pathInode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) (code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	var fi *fuse.Attr
 	if file == nil {
 		// Linux currently (tested on v4.4) does not pass a file descriptor for
@@ -594,6 +698,7 @@ func (n *pathInode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Cont
 	if file != nil {
 		code = file.GetAttr(out)
 		if code.Ok() {
+			n.pathFs.setIno(n.GetPath(), out)
 			return code
 		}
 	}
@@ -613,15 +718,18 @@ func (n *pathInode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Cont
 	}
 	// Set inode number (unless already set or disabled).
 	n.setClientInode(fi.Ino)
+	n.noteAttr(fi)
 	// Help filesystems that forget to set Nlink.
 	if !fi.IsDir() && fi.Nlink == 0 {
 		fi.Nlink = 1
 	}
 	*out = *fi
+	n.pathFs.setIno(n.GetPath(), out)
 	return code
 }
 
 func (n *pathInode) Chmod(file nodefs.File, perms uint32, context *fuse.Context) (code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	// Note that Linux currently (Linux 4.4) DOES NOT pass a file descriptor
 	// to FUSE for fchmod. We still check because that may change in the future.
 	if file != nil {
@@ -647,6 +755,7 @@ func (n *pathInode) Chmod(file nodefs.File, perms uint32, context *fuse.Context)
 }
 
 func (n *pathInode) Chown(file nodefs.File, uid uint32, gid uint32, context *fuse.Context) (code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	// Note that Linux currently (Linux 4.4) DOES NOT pass a file descriptor
 	// to FUSE for fchown. We still check because it may change in the future.
 	if file != nil {
@@ -672,6 +781,7 @@ func (n *pathInode) Chown(file nodefs.File, uid uint32, gid uint32, context *fus
 }
 
 func (n *pathInode) Truncate(file nodefs.File, size uint64, context *fuse.Context) (code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	// A file descriptor was passed in AND the filesystem implements the
 	// operation on the file handle. This the common case for ftruncate.
 	if file != nil {
@@ -696,6 +806,7 @@ func (n *pathInode) Truncate(file nodefs.File, size uint64, context *fuse.Contex
 }
 
 func (n *pathInode) Utimens(file nodefs.File, atime *time.Time, mtime *time.Time, context *fuse.Context) (code fuse.Status) {
+	defer n.pathFs.pinPaths()()
 	// Note that Linux currently (Linux 4.4) DOES NOT pass a file descriptor
 	// to FUSE for futimens. We still check because it may change in the future.
 	if file != nil {
@@ -719,6 +830,10 @@ func (n *pathInode) Utimens(file nodefs.File, atime *time.Time, mtime *time.Time
 	return code
 }
 
+func (n *pathInode) Bmap(block uint64, blocksize uint32, context *fuse.Context) (uint64, fuse.Status) {
+	return 0, fuse.ENOSYS
+}
+
 func (n *pathInode) Fallocate(file nodefs.File, off uint64, size uint64, mode uint32, context *fuse.Context) (code fuse.Status) {
 	if file != nil {
 		code = file.Allocate(off, size, mode)
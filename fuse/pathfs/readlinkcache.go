@@ -0,0 +1,69 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// linkStamp identifies a version of a symlink: a link that is
+// replaced, even by one of the same name, gets another inode number
+// or ctime.
+type linkStamp struct {
+	ino       uint64
+	ctime     uint64
+	ctimensec uint32
+}
+
+// The readlink cache, enabled with PathNodeFsOptions.CacheReadlink,
+// keeps the target of a symlink on its pathInode. Changes made
+// through the mount need no invalidation: a new link gets a new
+// pathInode, and a renamed one keeps its target. Changes made
+// elsewhere are noticed by Lookup and GetAttr through the link's
+// stamp, and by ApplyChange.
+
+// cachedReadlink returns the cached target of n, and the stamp to
+// pass to setReadlink.
+func (n *pathInode) cachedReadlink() (target string, stamp linkStamp, ok bool) {
+	n.pathFs.linkLock.Lock()
+	defer n.pathFs.linkLock.Unlock()
+	if n.link != nil {
+		return *n.link, n.linkStamp, true
+	}
+	return "", n.linkStamp, false
+}
+
+// setReadlink caches target, unless the link changed since stamp was
+// returned from cachedReadlink.
+func (n *pathInode) setReadlink(target string, stamp linkStamp) {
+	n.pathFs.linkLock.Lock()
+	defer n.pathFs.linkLock.Unlock()
+	if n.linkStamp == stamp {
+		n.link = &target
+	}
+}
+
+// noteAttr drops the cached target if a is for another version of
+// the link.
+func (n *pathInode) noteAttr(a *fuse.Attr) {
+	if !n.pathFs.options.CacheReadlink || !a.IsSymlink() {
+		return
+	}
+	stamp := linkStamp{a.Ino, a.Ctime, a.Ctimensec}
+	n.pathFs.linkLock.Lock()
+	defer n.pathFs.linkLock.Unlock()
+	if n.linkStamp != stamp {
+		n.link = nil
+		n.linkStamp = stamp
+	}
+}
+
+// dropReadlink drops the cached target, if any.
+func (n *pathInode) dropReadlink() {
+	n.pathFs.linkLock.Lock()
+	defer n.pathFs.linkLock.Unlock()
+	n.link = nil
+	n.linkStamp = linkStamp{}
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+type countingReadlinkFS struct {
+	FileSystem
+	calls atomic.Int32
+}
+
+func (fs *countingReadlinkFS) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	fs.calls.Add(1)
+	return fs.FileSystem.Readlink(name, context)
+}
+
+func TestReadlinkCache(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("one", link); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &countingReadlinkFS{FileSystem: NewLoopbackFileSystem(dir)}
+	pfs := NewPathNodeFs(fs, &PathNodeFsOptions{CacheReadlink: true})
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	readlink := func(want string, wantCalls int32) {
+		t.Helper()
+		out, code := k.Lookup(fuse.FUSE_ROOT_ID, "link")
+		if !code.Ok() {
+			t.Fatalf("Lookup: %v", code)
+		}
+		for i := 0; i < 3; i++ {
+			if got, code := k.Readlink(out.NodeId); !code.Ok() || got != want {
+				t.Fatalf("Readlink: got %q, %v, want %q", got, code, want)
+			}
+		}
+		if got := fs.calls.Load(); got != wantCalls {
+			t.Errorf("got %d FileSystem.Readlink calls, want %d", got, wantCalls)
+		}
+	}
+	readlink("one", 1)
+
+	// Replaced outside the mount; noticed on the next lookup.
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("two", link); err != nil {
+		t.Fatal(err)
+	}
+	readlink("two", 2)
+
+	// ApplyChange drops the cached target.
+	if code := pfs.ApplyChange(Change{PathChanged, "link"}); !code.Ok() {
+		t.Fatalf("ApplyChange: %v", code)
+	}
+	readlink("two", 3)
+}
@@ -0,0 +1,290 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// RetryOptions configures NewRetryFileSystem.
+type RetryOptions struct {
+	// Attempts is the number of tries of each operation. 0
+	// means 3.
+	Attempts int
+
+	// Backoff is the wait before the first retry. It doubles
+	// for each further retry. 0 means 10ms.
+	Backoff time.Duration
+
+	// Transient says whether a failure may go away by itself. If
+	// nil, EIO, EAGAIN, EINTR, EBUSY and ETIMEDOUT are transient.
+	Transient func(code fuse.Status) bool
+
+	// RetryMutation says whether the operation op (eg. "Mkdir")
+	// on name, which failed with a transient error, may be
+	// retried. Such operations are not idempotent: the failed
+	// attempt may have taken effect, so a retry can fail with
+	// EEXIST or ENOENT, or in the case of Link and Symlink,
+	// succeed twice. If nil, they are not retried.
+	RetryMutation func(op string, name string, code fuse.Status) bool
+}
+
+func defaultTransient(code fuse.Status) bool {
+	switch syscall.Errno(code) {
+	case syscall.EIO, syscall.EAGAIN, syscall.EINTR, syscall.EBUSY, syscall.ETIMEDOUT:
+		return true
+	}
+	return false
+}
+
+// NewRetryFileSystem returns a wrapper that retries operations of fs
+// that fail with transient errors, for backends on flaky networks.
+// Operations that can safely be repeated, such as GetAttr, Read,
+// Chmod, and writes at an offset, are retried freely; those that
+// create, remove or rename entries only if opts.RetryMutation agrees.
+func NewRetryFileSystem(fs FileSystem, opts RetryOptions) FileSystem {
+	if opts.Attempts < 1 {
+		opts.Attempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 10 * time.Millisecond
+	}
+	if opts.Transient == nil {
+		opts.Transient = defaultTransient
+	}
+	return &retryFileSystem{fs, opts}
+}
+
+type retryFileSystem struct {
+	FileSystem
+	opts RetryOptions
+}
+
+// retry calls f until it succeeds, fails permanently, or the attempts
+// are used up. If op is not empty, the operation is a mutation named
+// op, and is only retried if RetryMutation agrees.
+func retry[T any](opts *RetryOptions, op, name string, f func() (T, fuse.Status)) (T, fuse.Status) {
+	wait := opts.Backoff
+	for i := 1; ; i++ {
+		v, code := f()
+		if code.Ok() || i == opts.Attempts || !opts.Transient(code) {
+			return v, code
+		}
+		if op != "" && (opts.RetryMutation == nil || !opts.RetryMutation(op, name, code)) {
+			return v, code
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func retryStatus(opts *RetryOptions, op, name string, f func() fuse.Status) fuse.Status {
+	_, code := retry(opts, op, name, func() (struct{}, fuse.Status) {
+		return struct{}{}, f()
+	})
+	return code
+}
+
+func (fs *retryFileSystem) String() string {
+	return fmt.Sprintf("retryFileSystem(%v)", fs.FileSystem)
+}
+
+func (fs *retryFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	return retry(&fs.opts, "", name, func() (*fuse.Attr, fuse.Status) {
+		return fs.FileSystem.GetAttr(name, context)
+	})
+}
+
+func (fs *retryFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "", name, func() fuse.Status {
+		return fs.FileSystem.Chmod(name, mode, context)
+	})
+}
+
+func (fs *retryFileSystem) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "", name, func() fuse.Status {
+		return fs.FileSystem.Chown(name, uid, gid, context)
+	})
+}
+
+func (fs *retryFileSystem) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "", name, func() fuse.Status {
+		return fs.FileSystem.Utimens(name, atime, mtime, context)
+	})
+}
+
+func (fs *retryFileSystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "", name, func() fuse.Status {
+		return fs.FileSystem.Truncate(name, size, context)
+	})
+}
+
+func (fs *retryFileSystem) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "", name, func() fuse.Status {
+		return fs.FileSystem.Access(name, mode, context)
+	})
+}
+
+func (fs *retryFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "Link", newName, func() fuse.Status {
+		return fs.FileSystem.Link(oldName, newName, context)
+	})
+}
+
+func (fs *retryFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "Mkdir", name, func() fuse.Status {
+		return fs.FileSystem.Mkdir(name, mode, context)
+	})
+}
+
+func (fs *retryFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "Mknod", name, func() fuse.Status {
+		return fs.FileSystem.Mknod(name, mode, dev, context)
+	})
+}
+
+func (fs *retryFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "Rename", oldName, func() fuse.Status {
+		return fs.FileSystem.Rename(oldName, newName, context)
+	})
+}
+
+func (fs *retryFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "Rmdir", name, func() fuse.Status {
+		return fs.FileSystem.Rmdir(name, context)
+	})
+}
+
+func (fs *retryFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "Unlink", name, func() fuse.Status {
+		return fs.FileSystem.Unlink(name, context)
+	})
+}
+
+func (fs *retryFileSystem) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	return retry(&fs.opts, "", name, func() ([]byte, fuse.Status) {
+		return fs.FileSystem.GetXAttr(name, attribute, context)
+	})
+}
+
+func (fs *retryFileSystem) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	return retry(&fs.opts, "", name, func() ([]string, fuse.Status) {
+		return fs.FileSystem.ListXAttr(name, context)
+	})
+}
+
+func (fs *retryFileSystem) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "RemoveXAttr", name, func() fuse.Status {
+		return fs.FileSystem.RemoveXAttr(name, attr, context)
+	})
+}
+
+func (fs *retryFileSystem) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	// With XATTR_CREATE or XATTR_REPLACE, a repeat may fail.
+	op := ""
+	if flags != 0 {
+		op = "SetXAttr"
+	}
+	return retryStatus(&fs.opts, op, name, func() fuse.Status {
+		return fs.FileSystem.SetXAttr(name, attr, data, flags, context)
+	})
+}
+
+func (fs *retryFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	f, code := retry(&fs.opts, "", name, func() (nodefs.File, fuse.Status) {
+		return fs.FileSystem.Open(name, flags, context)
+	})
+	return fs.wrapFile(f, flags), code
+}
+
+func (fs *retryFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	f, code := retry(&fs.opts, "Create", name, func() (nodefs.File, fuse.Status) {
+		return fs.FileSystem.Create(name, flags, mode, context)
+	})
+	return fs.wrapFile(f, flags), code
+}
+
+func (fs *retryFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	return retry(&fs.opts, "", name, func() ([]fuse.DirEntry, fuse.Status) {
+		return fs.FileSystem.OpenDir(name, context)
+	})
+}
+
+func (fs *retryFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	return retryStatus(&fs.opts, "Symlink", linkName, func() fuse.Status {
+		return fs.FileSystem.Symlink(value, linkName, context)
+	})
+}
+
+func (fs *retryFileSystem) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	return retry(&fs.opts, "", name, func() (string, fuse.Status) {
+		return fs.FileSystem.Readlink(name, context)
+	})
+}
+
+func (fs *retryFileSystem) wrapFile(f nodefs.File, flags uint32) nodefs.File {
+	if f == nil {
+		return nil
+	}
+	return &retryFile{f, &fs.opts, flags&uint32(os.O_APPEND) != 0}
+}
+
+// retryFile retries the data operations of an open file.
+type retryFile struct {
+	nodefs.File
+	opts *RetryOptions
+
+	// Appending writes go to the end of the file, wherever that
+	// is, so they are not idempotent.
+	append bool
+}
+
+func (f *retryFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *retryFile) String() string {
+	return fmt.Sprintf("retryFile(%s)", f.File.String())
+}
+
+func (f *retryFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	return retry(f.opts, "", "", func() (fuse.ReadResult, fuse.Status) {
+		return f.File.Read(dest, off)
+	})
+}
+
+func (f *retryFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	op := ""
+	if f.append {
+		op = "Write"
+	}
+	return retry(f.opts, op, "", func() (uint32, fuse.Status) {
+		return f.File.Write(data, off)
+	})
+}
+
+func (f *retryFile) Fsync(flags int) fuse.Status {
+	return retryStatus(f.opts, "", "", func() fuse.Status {
+		return f.File.Fsync(flags)
+	})
+}
+
+func (f *retryFile) Truncate(size uint64) fuse.Status {
+	return retryStatus(f.opts, "", "", func() fuse.Status {
+		return f.File.Truncate(size)
+	})
+}
+
+func (f *retryFile) GetAttr(out *fuse.Attr) fuse.Status {
+	return retryStatus(f.opts, "", "", func() fuse.Status {
+		return f.File.GetAttr(out)
+	})
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// flakyFileSystem fails every operation with EIO the first failures
+// times it is called.
+type flakyFileSystem struct {
+	FileSystem
+	failures int
+	calls    int
+}
+
+func (fs *flakyFileSystem) fail() bool {
+	fs.calls++
+	return fs.calls <= fs.failures
+}
+
+func (fs *flakyFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	if fs.fail() {
+		return nil, fuse.EIO
+	}
+	return &fuse.Attr{Mode: fuse.S_IFREG | 0644}, fuse.OK
+}
+
+func (fs *flakyFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if fs.fail() {
+		return fuse.EIO
+	}
+	return fuse.OK
+}
+
+func (fs *flakyFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	fs.calls++
+	return fuse.ENOENT
+}
+
+func TestRetryFileSystem(t *testing.T) {
+	inner := &flakyFileSystem{FileSystem: NewDefaultFileSystem(), failures: 2}
+	opts := RetryOptions{Backoff: time.Microsecond}
+	fs := NewRetryFileSystem(inner, opts)
+
+	if _, code := fs.GetAttr("file", nil); !code.Ok() || inner.calls != 3 {
+		t.Errorf("GetAttr: got %v after %d calls, want OK after 3", code, inner.calls)
+	}
+
+	// Transient errors beyond the attempts are returned.
+	inner.calls, inner.failures = 0, 5
+	if _, code := fs.GetAttr("file", nil); code != fuse.EIO || inner.calls != 3 {
+		t.Errorf("GetAttr: got %v after %d calls, want EIO after 3", code, inner.calls)
+	}
+
+	// Permanent errors are not retried.
+	inner.calls = 0
+	if code := fs.Unlink("file", nil); code != fuse.ENOENT || inner.calls != 1 {
+		t.Errorf("Unlink: got %v after %d calls, want ENOENT after 1", code, inner.calls)
+	}
+
+	// Mutations need the policy's consent.
+	inner.calls, inner.failures = 0, 1
+	if code := fs.Mkdir("dir", 0755, nil); code != fuse.EIO || inner.calls != 1 {
+		t.Errorf("Mkdir: got %v after %d calls, want EIO after 1", code, inner.calls)
+	}
+	var asked []string
+	opts.RetryMutation = func(op, name string, code fuse.Status) bool {
+		asked = append(asked, op+" "+name)
+		return true
+	}
+	fs = NewRetryFileSystem(inner, opts)
+	inner.calls, inner.failures = 0, 1
+	if code := fs.Mkdir("dir", 0755, nil); !code.Ok() || inner.calls != 2 {
+		t.Errorf("Mkdir: got %v after %d calls, want OK after 2", code, inner.calls)
+	}
+	if len(asked) != 1 || asked[0] != "Mkdir dir" {
+		t.Errorf("RetryMutation got %q", asked)
+	}
+}
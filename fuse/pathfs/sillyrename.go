@@ -0,0 +1,370 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// SillyRenameOptions configures NewSillyRenameFileSystem.
+type SillyRenameOptions struct {
+	// Prefix starts the names under which unlinked files are kept
+	// while they are open. Names with the prefix are hidden from
+	// the mount. If empty, ".gofuse_hidden" is used.
+	Prefix string
+
+	// If set, the data of a file that is unlinked while open is
+	// copied into memory, and the file is removed from the
+	// backend right away, rather than renamed. Use this for
+	// backends that cannot rename, or whose open files stop
+	// working when their path goes away.
+	Retain bool
+}
+
+// NewSillyRenameFileSystem returns a wrapper that keeps files of fs
+// usable after they are unlinked while open, as POSIX requires and
+// the create-unlink-use pattern for temporary files depends on. Like
+// NFS clients, it renames such files to a hidden name, and removes
+// them when the last handle is released. With opts.Retain, it keeps
+// their data in memory instead.
+//
+// Open files must not depend on their path for the rename to work;
+// files from NewLoopbackFileSystem, which use a file descriptor, are
+// fine. Hidden files still count as directory entries in the
+// backend, so removing their directory fails with ENOTEMPTY until
+// they are closed.
+func NewSillyRenameFileSystem(fs FileSystem, opts SillyRenameOptions) FileSystem {
+	if opts.Prefix == "" {
+		opts.Prefix = ".gofuse_hidden"
+	}
+	return &sillyRenameFileSystem{
+		FileSystem: fs,
+		opts:       opts,
+		open:       map[string]*sillyEntry{},
+	}
+}
+
+type sillyRenameFileSystem struct {
+	FileSystem
+	opts SillyRenameOptions
+
+	mu   sync.Mutex
+	seq  int
+	open map[string]*sillyEntry
+}
+
+// sillyEntry is a file with open handles. Once unlinked, it is kept
+// in sillyRenameFileSystem.open under its hidden name, if any.
+type sillyEntry struct {
+	mu    sync.Mutex
+	count int
+
+	// The name in the backend while hidden, if renamed.
+	hidden string
+
+	// The data and attributes, if retained.
+	retained bool
+	data     []byte
+	attr     fuse.Attr
+}
+
+func (fs *sillyRenameFileSystem) String() string {
+	return fmt.Sprintf("sillyRenameFileSystem(%v)", fs.FileSystem)
+}
+
+func (fs *sillyRenameFileSystem) isHidden(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), fs.opts.Prefix)
+}
+
+func (fs *sillyRenameFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	if fs.isHidden(name) {
+		return nil, fuse.ENOENT
+	}
+	return fs.FileSystem.GetAttr(name, context)
+}
+
+func (fs *sillyRenameFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	entries, code := fs.FileSystem.OpenDir(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	r := entries[:0]
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name, fs.opts.Prefix) {
+			r = append(r, e)
+		}
+	}
+	return r, code
+}
+
+func (fs *sillyRenameFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if fs.isHidden(name) {
+		return nil, fuse.ENOENT
+	}
+	f, code := fs.FileSystem.Open(name, flags, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.track(name, f), code
+}
+
+func (fs *sillyRenameFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if fs.isHidden(name) {
+		return nil, fuse.EPERM
+	}
+	f, code := fs.FileSystem.Create(name, flags, mode, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.track(name, f), code
+}
+
+func (fs *sillyRenameFileSystem) track(name string, f nodefs.File) nodefs.File {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	e := fs.open[name]
+	if e == nil {
+		e = &sillyEntry{}
+		fs.open[name] = e
+	}
+	e.mu.Lock()
+	e.count++
+	e.mu.Unlock()
+	return &sillyFile{File: f, fs: fs, entry: e}
+}
+
+func (fs *sillyRenameFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	if fs.isHidden(name) {
+		return fuse.ENOENT
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if e := fs.open[name]; e != nil {
+		return fs.hide(name, e, context)
+	}
+	return fs.FileSystem.Unlink(name, context)
+}
+
+// hide takes the open file name out of the tree. The caller holds
+// fs.mu.
+func (fs *sillyRenameFileSystem) hide(name string, e *sillyEntry, context *fuse.Context) fuse.Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if fs.opts.Retain {
+		data, attr, code := fs.readAll(name, context)
+		if !code.Ok() {
+			return code
+		}
+		if code := fs.FileSystem.Unlink(name, context); !code.Ok() {
+			return code
+		}
+		e.retained = true
+		e.data = data
+		e.attr = *attr
+	} else {
+		fs.seq++
+		hidden := filepath.Join(filepath.Dir(name), fmt.Sprintf("%s%d", fs.opts.Prefix, fs.seq))
+		if code := fs.FileSystem.Rename(name, hidden, context); !code.Ok() {
+			return code
+		}
+		e.hidden = hidden
+		fs.open[hidden] = e
+	}
+	delete(fs.open, name)
+	return fuse.OK
+}
+
+// readAll reads the file name from the backend.
+func (fs *sillyRenameFileSystem) readAll(name string, context *fuse.Context) ([]byte, *fuse.Attr, fuse.Status) {
+	attr, code := fs.FileSystem.GetAttr(name, context)
+	if !code.Ok() {
+		return nil, nil, code
+	}
+	f, code := fs.FileSystem.Open(name, uint32(os.O_RDONLY), context)
+	if !code.Ok() {
+		return nil, nil, code
+	}
+	defer f.Release()
+
+	data := make([]byte, attr.Size)
+	for off := 0; off < len(data); {
+		res, code := f.Read(data[off:], int64(off))
+		if !code.Ok() {
+			return nil, nil, code
+		}
+		b, code := res.Bytes(data[off:])
+		res.Done()
+		if !code.Ok() {
+			return nil, nil, code
+		}
+		if len(b) == 0 {
+			data = data[:off]
+			break
+		}
+		off += copy(data[off:], b)
+	}
+	return data, attr, fuse.OK
+}
+
+func (fs *sillyRenameFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	if fs.isHidden(oldName) {
+		return fuse.ENOENT
+	}
+	if fs.isHidden(newName) {
+		return fuse.EPERM
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// A file renamed over an open one unlinks it.
+	if e := fs.open[newName]; e != nil && newName != oldName {
+		if code := fs.hide(newName, e, context); !code.Ok() {
+			return code
+		}
+	}
+	code := fs.FileSystem.Rename(oldName, newName, context)
+	if !code.Ok() {
+		return code
+	}
+	if e := fs.open[oldName]; e != nil {
+		delete(fs.open, oldName)
+		fs.open[newName] = e
+	}
+	// Open files below a renamed directory move too.
+	prefix := oldName + "/"
+	for name, e := range fs.open {
+		if strings.HasPrefix(name, prefix) {
+			delete(fs.open, name)
+			moved := newName + "/" + name[len(prefix):]
+			fs.open[moved] = e
+			if e.hidden == name {
+				e.hidden = moved
+			}
+		}
+	}
+	return code
+}
+
+// release drops a handle of e, and removes its hidden file after the
+// last one.
+func (fs *sillyRenameFileSystem) release(e *sillyEntry) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.count--
+	if e.count > 0 {
+		return
+	}
+	if e.hidden != "" {
+		fs.FileSystem.Unlink(e.hidden, nil)
+	}
+	for name, o := range fs.open {
+		if o == e {
+			delete(fs.open, name)
+		}
+	}
+	e.data = nil
+}
+
+// sillyFile is a handle of a tracked file. Once the file is unlinked
+// with Retain set, its data operations go to the retained copy.
+type sillyFile struct {
+	nodefs.File
+	fs    *sillyRenameFileSystem
+	entry *sillyEntry
+}
+
+func (f *sillyFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *sillyFile) String() string {
+	return fmt.Sprintf("sillyFile(%s)", f.File.String())
+}
+
+func (f *sillyFile) Release() {
+	f.File.Release()
+	f.fs.release(f.entry)
+}
+
+func (f *sillyFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	e := f.entry
+	e.mu.Lock()
+	if !e.retained {
+		e.mu.Unlock()
+		return f.File.Read(dest, off)
+	}
+	defer e.mu.Unlock()
+	n := 0
+	if off < int64(len(e.data)) {
+		n = copy(dest, e.data[off:])
+	}
+	return fuse.ReadResultData(dest[:n]), fuse.OK
+}
+
+func (f *sillyFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	e := f.entry
+	e.mu.Lock()
+	if !e.retained {
+		e.mu.Unlock()
+		return f.File.Write(data, off)
+	}
+	defer e.mu.Unlock()
+	if end := off + int64(len(data)); end > int64(len(e.data)) {
+		e.data = append(e.data, make([]byte, end-int64(len(e.data)))...)
+	}
+	copy(e.data[off:], data)
+	return uint32(len(data)), fuse.OK
+}
+
+func (f *sillyFile) Truncate(size uint64) fuse.Status {
+	e := f.entry
+	e.mu.Lock()
+	if !e.retained {
+		e.mu.Unlock()
+		return f.File.Truncate(size)
+	}
+	defer e.mu.Unlock()
+	if size <= uint64(len(e.data)) {
+		e.data = e.data[:size]
+	} else {
+		e.data = append(e.data, make([]byte, size-uint64(len(e.data)))...)
+	}
+	return fuse.OK
+}
+
+func (f *sillyFile) GetAttr(out *fuse.Attr) fuse.Status {
+	e := f.entry
+	e.mu.Lock()
+	if !e.retained {
+		e.mu.Unlock()
+		return f.File.GetAttr(out)
+	}
+	defer e.mu.Unlock()
+	*out = e.attr
+	out.Size = uint64(len(e.data))
+	out.Nlink = 0
+	return fuse.OK
+}
+
+func (f *sillyFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	e := f.entry
+	e.mu.Lock()
+	retained := e.retained
+	e.mu.Unlock()
+	if retained {
+		return fuse.Status(syscall.EOPNOTSUPP)
+	}
+	return f.File.Allocate(off, size, mode)
+}
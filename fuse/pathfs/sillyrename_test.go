@@ -0,0 +1,107 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestSillyRename(t *testing.T) {
+	for _, retain := range []bool{false, true} {
+		dir := testutil.TempDir()
+		defer os.RemoveAll(dir)
+		fs := NewSillyRenameFileSystem(NewLoopbackFileSystem(dir), SillyRenameOptions{Retain: retain})
+
+		f, code := fs.Create("tmp", uint32(os.O_RDWR), 0644, nil)
+		if !code.Ok() {
+			t.Fatalf("Create: %v", code)
+		}
+		if _, code := f.Write([]byte("hello"), 0); !code.Ok() {
+			t.Fatalf("Write: %v", code)
+		}
+		if code := fs.Unlink("tmp", nil); !code.Ok() {
+			t.Fatalf("retain %v: Unlink: %v", retain, code)
+		}
+		if _, code := fs.GetAttr("tmp", nil); code != fuse.ENOENT {
+			t.Errorf("retain %v: GetAttr after Unlink: got %v, want ENOENT", retain, code)
+		}
+		if entries, _ := fs.OpenDir("", nil); len(entries) != 0 {
+			t.Errorf("retain %v: OpenDir: got %v, want nothing", retain, entries)
+		}
+
+		// The handle still works.
+		if _, code := f.Write([]byte(" world"), 5); !code.Ok() {
+			t.Errorf("retain %v: Write after Unlink: %v", retain, code)
+		}
+		buf := make([]byte, 100)
+		res, code := f.Read(buf, 0)
+		if !code.Ok() {
+			t.Fatalf("retain %v: Read after Unlink: %v", retain, code)
+		}
+		if b, _ := res.Bytes(buf); string(b) != "hello world" {
+			t.Errorf("retain %v: got %q, want %q", retain, b, "hello world")
+		}
+		var a fuse.Attr
+		if code := f.GetAttr(&a); !code.Ok() || a.Size != 11 {
+			t.Errorf("retain %v: GetAttr: got %v, %v, want size 11", retain, &a, code)
+		}
+
+		// A new file with the same name is independent.
+		g, code := fs.Create("tmp", uint32(os.O_RDWR), 0644, nil)
+		if !code.Ok() {
+			t.Fatalf("Create again: %v", code)
+		}
+		g.Release()
+
+		// Renamed files stay in the backend until released.
+		want := 2
+		if retain {
+			want = 1
+		}
+		names, _ := ioutil.ReadDir(dir)
+		if len(names) != want {
+			t.Errorf("retain %v: backend has %d files, want %d", retain, len(names), want)
+		}
+
+		f.Release()
+		names, _ = ioutil.ReadDir(dir)
+		if len(names) != 1 || names[0].Name() != "tmp" {
+			t.Errorf("retain %v: after Release, backend has %v, want [tmp]", retain, names)
+		}
+	}
+}
+
+func TestSillyRenameOverOpenFile(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/old", []byte("old"), 0644)
+	ioutil.WriteFile(dir+"/new", []byte("new"), 0644)
+	fs := NewSillyRenameFileSystem(NewLoopbackFileSystem(dir), SillyRenameOptions{})
+
+	f, code := fs.Open("old", uint32(os.O_RDONLY), nil)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	if code := fs.Rename("new", "old", nil); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	if got := readFile(t, fs, "old"); got != "new" {
+		t.Errorf("old: got %q, want new", got)
+	}
+	buf := make([]byte, 10)
+	res, _ := f.Read(buf, 0)
+	if b, _ := res.Bytes(buf); string(b) != "old" {
+		t.Errorf("open handle: got %q, want old", b)
+	}
+	f.Release()
+	if names, _ := ioutil.ReadDir(dir); len(names) != 1 {
+		t.Errorf("backend has %d files, want 1", len(names))
+	}
+}
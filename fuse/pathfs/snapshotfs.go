@@ -0,0 +1,188 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// SnapshotOptions tunes NewSnapshotFileSystem.
+type SnapshotOptions struct {
+	// Files up to this size are copied into memory on first
+	// open. Larger ones are read from the backend, so their
+	// contents may change. Default 64M.
+	MaxFileSize int64
+}
+
+// result is a captured return value and status.
+type result[T any] struct {
+	val  T
+	code fuse.Status
+}
+
+type snapshotFileSystem struct {
+	FileSystem
+	opts SnapshotOptions
+
+	mu     sync.Mutex
+	attrs  map[string]result[*fuse.Attr]
+	dirs   map[string]result[[]fuse.DirEntry]
+	links  map[string]result[string]
+	data   map[string]result[[]byte]
+	xattrs map[string]result[[]byte]
+	lists  map[string]result[[]string]
+}
+
+// NewSnapshotFileSystem returns a read-only view of fs that does not
+// change. Attributes, directory listings, symlinks, extended
+// attributes and file contents are captured the first time they are
+// looked at, and served from memory afterwards; negative results are
+// kept too. Mutations are rejected with EPERM.
+//
+// This gives a stable view of a live backend for inspection. It is
+// not a consistent point-in-time copy: paths that are first looked at
+// later show the backend as it was then.
+func NewSnapshotFileSystem(fs FileSystem, opts *SnapshotOptions) FileSystem {
+	s := &snapshotFileSystem{
+		FileSystem: NewReadonlyFileSystem(fs),
+		attrs:      map[string]result[*fuse.Attr]{},
+		dirs:       map[string]result[[]fuse.DirEntry]{},
+		links:      map[string]result[string]{},
+		data:       map[string]result[[]byte]{},
+		xattrs:     map[string]result[[]byte]{},
+		lists:      map[string]result[[]string]{},
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.MaxFileSize <= 0 {
+		s.opts.MaxFileSize = 64 << 20
+	}
+	return s
+}
+
+func (fs *snapshotFileSystem) String() string {
+	return fmt.Sprintf("snapshotFileSystem(%v)", fs.FileSystem)
+}
+
+// capture returns m[key], calling fetch to fill it in first if
+// needed. fetch runs without holding mu, so two calls may race to
+// capture the same key; the first result stored wins.
+func capture[T any](mu *sync.Mutex, m map[string]result[T], key string, fetch func() (T, fuse.Status)) (T, fuse.Status) {
+	mu.Lock()
+	r, ok := m[key]
+	mu.Unlock()
+	if ok {
+		return r.val, r.code
+	}
+
+	r.val, r.code = fetch()
+	mu.Lock()
+	defer mu.Unlock()
+	if prev, ok := m[key]; ok {
+		r = prev
+	} else {
+		m[key] = r
+	}
+	return r.val, r.code
+}
+
+func (fs *snapshotFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	a, code := capture(&fs.mu, fs.attrs, name, func() (*fuse.Attr, fuse.Status) {
+		a, code := fs.FileSystem.GetAttr(name, context)
+		if a != nil {
+			c := *a
+			a = &c
+		}
+		return a, code
+	})
+	if a == nil {
+		return nil, code
+	}
+	// Callers may modify the result.
+	c := *a
+	return &c, code
+}
+
+func (fs *snapshotFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	entries, code := capture(&fs.mu, fs.dirs, name, func() ([]fuse.DirEntry, fuse.Status) {
+		return fs.FileSystem.OpenDir(name, context)
+	})
+	return append([]fuse.DirEntry(nil), entries...), code
+}
+
+func (fs *snapshotFileSystem) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	return capture(&fs.mu, fs.links, name, func() (string, fuse.Status) {
+		return fs.FileSystem.Readlink(name, context)
+	})
+}
+
+func (fs *snapshotFileSystem) GetXAttr(name string, attr string, context *fuse.Context) ([]byte, fuse.Status) {
+	return capture(&fs.mu, fs.xattrs, name+"\x00"+attr, func() ([]byte, fuse.Status) {
+		return fs.FileSystem.GetXAttr(name, attr, context)
+	})
+}
+
+func (fs *snapshotFileSystem) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	attrs, code := capture(&fs.mu, fs.lists, name, func() ([]string, fuse.Status) {
+		return fs.FileSystem.ListXAttr(name, context)
+	})
+	return append([]string(nil), attrs...), code
+}
+
+func (fs *snapshotFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if flags&fuse.O_ANYWRITE != 0 {
+		return nil, fuse.EPERM
+	}
+	attr, code := fs.GetAttr(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	if int64(attr.Size) > fs.opts.MaxFileSize {
+		return fs.FileSystem.Open(name, flags, context)
+	}
+
+	data, code := capture(&fs.mu, fs.data, name, func() ([]byte, fuse.Status) {
+		return fs.readAll(name, flags, int64(attr.Size), context)
+	})
+	if !code.Ok() {
+		return nil, code
+	}
+	return nodefs.NewReadOnlyFile(nodefs.NewDataFile(data)), fuse.OK
+}
+
+// readAll reads the file, up to the size it has in the snapshot.
+func (fs *snapshotFileSystem) readAll(name string, flags uint32, size int64, context *fuse.Context) ([]byte, fuse.Status) {
+	f, code := fs.FileSystem.Open(name, flags, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	defer f.Release()
+
+	data := make([]byte, size)
+	var off int64
+	for off < size {
+		res, code := f.Read(data[off:], off)
+		if !code.Ok() {
+			return nil, code
+		}
+		b, code := res.Bytes(data[off:])
+		n := copy(data[off:], b)
+		res.Done()
+		if !code.Ok() {
+			return nil, code
+		}
+		if n == 0 {
+			// The file shrank.
+			break
+		}
+		off += int64(n)
+	}
+	return data[:off], fuse.OK
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func readFile(t *testing.T, fs FileSystem, name string) string {
+	f, code := fs.Open(name, uint32(os.O_RDONLY), nil)
+	if !code.Ok() {
+		t.Fatalf("Open(%q): %v", name, code)
+	}
+	defer f.Release()
+	buf := make([]byte, 100)
+	res, code := f.Read(buf, 0)
+	if !code.Ok() {
+		t.Fatalf("Read(%q): %v", name, code)
+	}
+	b, _ := res.Bytes(buf)
+	return string(b)
+}
+
+func TestSnapshotFileSystem(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(dir+"/file", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewSnapshotFileSystem(NewLoopbackFileSystem(dir), nil)
+
+	a, code := fs.GetAttr("file", nil)
+	if !code.Ok() {
+		t.Fatalf("GetAttr: %v", code)
+	}
+	// Callers may modify the result without affecting the snapshot.
+	a.Size = 42
+	if _, code := fs.OpenDir("", nil); !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+	if got := readFile(t, fs, "file"); got != "hello" {
+		t.Fatalf("got %q, want hello", got)
+	}
+	if _, code := fs.GetAttr("new", nil); code != fuse.ENOENT {
+		t.Fatalf("GetAttr(new): got %v, want ENOENT", code)
+	}
+
+	// Change the backend.
+	if err := ioutil.WriteFile(dir+"/file", []byte("goodbye!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/new", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, code := fs.GetAttr("file", nil); !code.Ok() || a.Size != 5 {
+		t.Errorf("GetAttr after change: got %v, %v, want size 5", a, code)
+	}
+	if got := readFile(t, fs, "file"); got != "hello" {
+		t.Errorf("read after change: got %q, want hello", got)
+	}
+	entries, _ := fs.OpenDir("", nil)
+	if len(entries) != 1 || entries[0].Name != "file" {
+		t.Errorf("OpenDir after change: got %v, want [file]", entries)
+	}
+	if _, code := fs.GetAttr("new", nil); code != fuse.ENOENT {
+		t.Errorf("GetAttr(new) after change: got %v, want ENOENT", code)
+	}
+
+	// Mutations are rejected.
+	if _, code := fs.Open("file", uint32(os.O_WRONLY), nil); code != fuse.EPERM {
+		t.Errorf("Open(O_WRONLY): got %v, want EPERM", code)
+	}
+	if code := fs.Mkdir("dir", 0755, nil); code != fuse.EPERM {
+		t.Errorf("Mkdir: got %v, want EPERM", code)
+	}
+	if code := fs.Unlink("file", nil); code != fuse.EPERM {
+		t.Errorf("Unlink: got %v, want EPERM", code)
+	}
+	if _, err := os.Stat(dir + "/file"); err != nil {
+		t.Errorf("Stat: %v", err)
+	}
+	if code := fs.Chmod("file", 0600, nil); code != fuse.EPERM {
+		t.Errorf("Chmod: got %v, want EPERM", code)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// slowGetAttrFS holds GetAttr of name until release is closed.
+type slowGetAttrFS struct {
+	FileSystem
+	name     string
+	entered  chan string
+	release  chan struct{}
+	blocking atomic.Bool
+}
+
+func (fs *slowGetAttrFS) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	if fs.blocking.Load() && name == fs.name {
+		fs.entered <- name
+		<-fs.release
+	}
+	return fs.FileSystem.GetAttr(name, context)
+}
+
+func TestStablePaths(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dir", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &slowGetAttrFS{
+		FileSystem: NewLoopbackFileSystem(dir),
+		name:       "dir/file",
+		entered:    make(chan string),
+		release:    make(chan struct{}),
+	}
+	pfs := NewPathNodeFs(fs, &PathNodeFsOptions{StablePaths: true})
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	d, code := k.Lookup(fuse.FUSE_ROOT_ID, "dir")
+	if !code.Ok() {
+		t.Fatalf("Lookup dir: %v", code)
+	}
+	f, code := k.Lookup(d.NodeId, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup file: %v", code)
+	}
+	node := pfs.Node("dir/file")
+	if p, ok := node.Path(); !ok || p != "dir/file" {
+		t.Fatalf("Path: got %q, %v", p, ok)
+	}
+
+	fs.blocking.Store(true)
+	getattr := make(chan fuse.Status)
+	go func() {
+		_, code := k.GetAttr(f.NodeId)
+		getattr <- code
+	}()
+	<-fs.entered
+
+	renamed := make(chan fuse.Status)
+	go func() {
+		renamed <- k.Rename(fuse.FUSE_ROOT_ID, "dir", fuse.FUSE_ROOT_ID, "moved")
+	}()
+	select {
+	case <-renamed:
+		t.Fatalf("Rename did not wait for GetAttr")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(fs.release)
+	if code := <-getattr; !code.Ok() {
+		t.Errorf("GetAttr: %v", code)
+	}
+	if code := <-renamed; !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	if got := pfs.Path(node); got != "moved/file" {
+		t.Errorf("Path after rename: got %q, want moved/file", got)
+	}
+}
+
+// fifoFS holds Open of "fifo" until "writer" is opened, as opening
+// a FIFO for reading waits for a writer.
+type fifoFS struct {
+	FileSystem
+	entered chan struct{}
+	writer  chan struct{}
+}
+
+func (fs *fifoFS) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	switch name {
+	case "fifo":
+		close(fs.entered)
+		<-fs.writer
+	case "writer":
+		close(fs.writer)
+	}
+	return fs.FileSystem.Open(name, flags, context)
+}
+
+// A rename that waits for a blocked operation does not hold up the
+// operation that unblocks it.
+func TestStablePathsBlockedOpen(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	for _, n := range []string{"fifo", "writer", "old"} {
+		if err := os.WriteFile(filepath.Join(dir, n), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := &fifoFS{
+		FileSystem: NewLoopbackFileSystem(dir),
+		entered:    make(chan struct{}),
+		writer:     make(chan struct{}),
+	}
+	pfs := NewPathNodeFs(fs, &PathNodeFsOptions{StablePaths: true})
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("fakekernel.New: %v", err)
+	}
+	defer k.Close()
+
+	ids := map[string]uint64{}
+	for _, n := range []string{"fifo", "writer", "old"} {
+		e, code := k.Lookup(fuse.FUSE_ROOT_ID, n)
+		if !code.Ok() {
+			t.Fatalf("Lookup %s: %v", n, code)
+		}
+		ids[n] = e.NodeId
+	}
+
+	reader := make(chan fuse.Status)
+	go func() {
+		_, code := k.Open(ids["fifo"], uint32(os.O_RDONLY))
+		reader <- code
+	}()
+	<-fs.entered
+
+	renamed := make(chan fuse.Status)
+	go func() {
+		renamed <- k.Rename(fuse.FUSE_ROOT_ID, "old", fuse.FUSE_ROOT_ID, "new")
+	}()
+	// Give the rename time to queue up.
+	time.Sleep(50 * time.Millisecond)
+
+	writer := make(chan fuse.Status)
+	go func() {
+		_, code := k.Open(ids["writer"], uint32(os.O_WRONLY))
+		writer <- code
+	}()
+	for name, ch := range map[string]chan fuse.Status{"writer": writer, "reader": reader, "rename": renamed} {
+		select {
+		case code := <-ch:
+			if !code.Ok() {
+				t.Errorf("%s: %v", name, code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: deadlocked", name)
+		}
+	}
+}
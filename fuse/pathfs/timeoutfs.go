@@ -0,0 +1,331 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// TimeoutOptions configures NewTimeoutFileSystem.
+type TimeoutOptions struct {
+	// Timeout bounds each operation, including those on open
+	// files.
+	Timeout time.Duration
+
+	// Status is returned for operations that take too long. If
+	// OK, ETIMEDOUT is used; EIO is another common choice, as
+	// some programs do not expect ETIMEDOUT from file I/O.
+	Status fuse.Status
+}
+
+// NewTimeoutFileSystem returns a wrapper that gives up on operations
+// of fs that take longer than opts.Timeout, so a hung backend does
+// not hang the processes using the mount. An operation that times
+// out keeps running in the background, and its result is discarded;
+// in particular, files it opens are released when it completes. Reads
+// go through a buffer of their own, and written data is copied, so
+// late operations do not touch memory that has been reused.
+func NewTimeoutFileSystem(fs FileSystem, opts TimeoutOptions) FileSystem {
+	if opts.Status == fuse.OK {
+		opts.Status = fuse.Status(syscall.ETIMEDOUT)
+	}
+	return &timeoutFileSystem{fs, opts}
+}
+
+type timeoutFileSystem struct {
+	FileSystem
+	opts TimeoutOptions
+}
+
+// bounded runs f, and returns its result, or the timeout status if f
+// takes too long. If f completes after the timeout, late is called
+// with its result.
+func bounded[T any](opts *TimeoutOptions, f func() (T, fuse.Status), late func(T)) (T, fuse.Status) {
+	type result struct {
+		v    T
+		code fuse.Status
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, code := f()
+		done <- result{v, code}
+	}()
+
+	timer := time.NewTimer(opts.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.v, r.code
+	case <-timer.C:
+		if late != nil {
+			go func() {
+				if r := <-done; r.code.Ok() {
+					late(r.v)
+				}
+			}()
+		}
+		var zero T
+		return zero, opts.Status
+	}
+}
+
+// boundedStatus is bounded for operations that only return a status.
+func boundedStatus(opts *TimeoutOptions, f func() fuse.Status) fuse.Status {
+	_, code := bounded(opts, func() (struct{}, fuse.Status) {
+		return struct{}{}, f()
+	}, nil)
+	return code
+}
+
+// copyContext returns a copy of context that stays valid after the
+// request is done.
+func copyContext(context *fuse.Context) *fuse.Context {
+	if context == nil {
+		return nil
+	}
+	c := *context
+	return &c
+}
+
+func (fs *timeoutFileSystem) String() string {
+	return fmt.Sprintf("timeoutFileSystem(%v, %v)", fs.FileSystem, fs.opts.Timeout)
+}
+
+func (fs *timeoutFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	context = copyContext(context)
+	return bounded(&fs.opts, func() (*fuse.Attr, fuse.Status) {
+		return fs.FileSystem.GetAttr(name, context)
+	}, nil)
+}
+
+func (fs *timeoutFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Chmod(name, mode, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Chown(name, uid, gid, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Utimens(name, atime, mtime, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Truncate(name, size, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Access(name, mode, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Link(oldName, newName, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Mkdir(name, mode, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Mknod(name, mode, dev, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Rename(oldName, newName, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Rmdir(name, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Unlink(name, context)
+	})
+}
+
+func (fs *timeoutFileSystem) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	context = copyContext(context)
+	return bounded(&fs.opts, func() ([]byte, fuse.Status) {
+		return fs.FileSystem.GetXAttr(name, attribute, context)
+	}, nil)
+}
+
+func (fs *timeoutFileSystem) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	context = copyContext(context)
+	return bounded(&fs.opts, func() ([]string, fuse.Status) {
+		return fs.FileSystem.ListXAttr(name, context)
+	}, nil)
+}
+
+func (fs *timeoutFileSystem) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.RemoveXAttr(name, attr, context)
+	})
+}
+
+func (fs *timeoutFileSystem) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	data = append([]byte(nil), data...)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.SetXAttr(name, attr, data, flags, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	context = copyContext(context)
+	f, code := bounded(&fs.opts, func() (nodefs.File, fuse.Status) {
+		return fs.FileSystem.Open(name, flags, context)
+	}, releaseFile)
+	return fs.wrapFile(f), code
+}
+
+func (fs *timeoutFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	context = copyContext(context)
+	f, code := bounded(&fs.opts, func() (nodefs.File, fuse.Status) {
+		return fs.FileSystem.Create(name, flags, mode, context)
+	}, releaseFile)
+	return fs.wrapFile(f), code
+}
+
+func releaseFile(f nodefs.File) {
+	if f != nil {
+		f.Release()
+	}
+}
+
+func (fs *timeoutFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	context = copyContext(context)
+	return bounded(&fs.opts, func() ([]fuse.DirEntry, fuse.Status) {
+		return fs.FileSystem.OpenDir(name, context)
+	}, nil)
+}
+
+func (fs *timeoutFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	context = copyContext(context)
+	return boundedStatus(&fs.opts, func() fuse.Status {
+		return fs.FileSystem.Symlink(value, linkName, context)
+	})
+}
+
+func (fs *timeoutFileSystem) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	context = copyContext(context)
+	return bounded(&fs.opts, func() (string, fuse.Status) {
+		return fs.FileSystem.Readlink(name, context)
+	}, nil)
+}
+
+func (fs *timeoutFileSystem) StatFs(name string) *fuse.StatfsOut {
+	out, _ := bounded(&fs.opts, func() (*fuse.StatfsOut, fuse.Status) {
+		return fs.FileSystem.StatFs(name), fuse.OK
+	}, nil)
+	return out
+}
+
+func (fs *timeoutFileSystem) wrapFile(f nodefs.File) nodefs.File {
+	if f == nil {
+		return nil
+	}
+	return &timeoutFile{f, &fs.opts}
+}
+
+// timeoutFile bounds the data operations of an open file.
+type timeoutFile struct {
+	nodefs.File
+	opts *TimeoutOptions
+}
+
+func (f *timeoutFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *timeoutFile) String() string {
+	return fmt.Sprintf("timeoutFile(%s)", f.File.String())
+}
+
+func (f *timeoutFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	buf := make([]byte, len(dest))
+	return bounded(f.opts, func() (fuse.ReadResult, fuse.Status) {
+		return f.File.Read(buf, off)
+	}, nil)
+}
+
+func (f *timeoutFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	data = append([]byte(nil), data...)
+	return bounded(f.opts, func() (uint32, fuse.Status) {
+		return f.File.Write(data, off)
+	}, nil)
+}
+
+func (f *timeoutFile) Flush() fuse.Status {
+	return boundedStatus(f.opts, f.File.Flush)
+}
+
+func (f *timeoutFile) Fsync(flags int) fuse.Status {
+	return boundedStatus(f.opts, func() fuse.Status {
+		return f.File.Fsync(flags)
+	})
+}
+
+func (f *timeoutFile) Truncate(size uint64) fuse.Status {
+	return boundedStatus(f.opts, func() fuse.Status {
+		return f.File.Truncate(size)
+	})
+}
+
+func (f *timeoutFile) GetAttr(out *fuse.Attr) fuse.Status {
+	a, code := bounded(f.opts, func() (fuse.Attr, fuse.Status) {
+		var a fuse.Attr
+		code := f.File.GetAttr(&a)
+		return a, code
+	}, nil)
+	if code.Ok() {
+		*out = a
+	}
+	return code
+}
+
+func (f *timeoutFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	return boundedStatus(f.opts, func() fuse.Status {
+		return f.File.Allocate(off, size, mode)
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// hungFileSystem blocks operations on "hung" until unblock is closed.
+type hungFileSystem struct {
+	FileSystem
+	unblock  chan struct{}
+	released chan struct{}
+}
+
+func (fs *hungFileSystem) wait(name string) {
+	if name == "hung" {
+		<-fs.unblock
+	}
+}
+
+func (fs *hungFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	fs.wait(name)
+	return &fuse.Attr{Mode: fuse.S_IFREG | 0644}, fuse.OK
+}
+
+func (fs *hungFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	fs.wait(name)
+	return &hungFile{nodefs.NewDataFile([]byte("data")), fs}, fuse.OK
+}
+
+type hungFile struct {
+	nodefs.File
+	fs *hungFileSystem
+}
+
+func (f *hungFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	<-f.fs.unblock
+	return f.File.Read(dest, off)
+}
+
+func (f *hungFile) Release() {
+	close(f.fs.released)
+}
+
+func TestTimeoutFileSystem(t *testing.T) {
+	inner := &hungFileSystem{
+		FileSystem: NewDefaultFileSystem(),
+		unblock:    make(chan struct{}),
+		released:   make(chan struct{}),
+	}
+	fs := NewTimeoutFileSystem(inner, TimeoutOptions{Timeout: 10 * time.Millisecond})
+
+	if _, code := fs.GetAttr("file", nil); !code.Ok() {
+		t.Errorf("GetAttr: %v", code)
+	}
+	if _, code := fs.GetAttr("hung", nil); code != fuse.Status(syscall.ETIMEDOUT) {
+		t.Errorf("GetAttr(hung): got %v, want ETIMEDOUT", code)
+	}
+	if _, code := fs.Open("hung", 0, nil); code != fuse.Status(syscall.ETIMEDOUT) {
+		t.Errorf("Open(hung): got %v, want ETIMEDOUT", code)
+	}
+
+	f, code := fs.Open("file", 0, nil)
+	if !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	buf := make([]byte, 10)
+	if _, code := f.Read(buf, 0); code != fuse.Status(syscall.ETIMEDOUT) {
+		t.Errorf("Read: got %v, want ETIMEDOUT", code)
+	}
+
+	// Once the backend recovers, the file that was opened too
+	// late is released.
+	close(inner.unblock)
+	select {
+	case <-inner.released:
+	case <-time.After(time.Second):
+		t.Errorf("late file not released")
+	}
+	res, code := f.Read(buf, 0)
+	if !code.Ok() {
+		t.Fatalf("Read: %v", code)
+	}
+	if data, _ := res.Bytes(buf); string(data) != "data" {
+		t.Errorf("Read: got %q", data)
+	}
+}
+
+func TestTimeoutFileSystemStatus(t *testing.T) {
+	inner := &hungFileSystem{FileSystem: NewDefaultFileSystem(), unblock: make(chan struct{})}
+	defer close(inner.unblock)
+	fs := NewTimeoutFileSystem(inner, TimeoutOptions{Timeout: time.Millisecond, Status: fuse.EIO})
+	if _, code := fs.GetAttr("hung", nil); code != fuse.EIO {
+		t.Errorf("GetAttr(hung): got %v, want EIO", code)
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// UserViewOptions configures NewUserViewFileSystem.
+type UserViewOptions struct {
+	// Dir returns the directory of the inner file system that
+	// uid sees as the root of the mount. If nil, it is the uid
+	// in decimal, eg. "1000".
+	Dir func(uid uint32) string
+
+	// If set, the directory of a uid is created on its first
+	// request, with mode 0700 and owned by the caller.
+	// Otherwise, users without a directory get ENOENT.
+	Create bool
+}
+
+// NewUserViewFileSystem returns a wrapper that gives each caller a
+// sub-tree of fs of its own, chosen by the uid of the request, so a
+// mount with allow_other can serve isolated per-user homes.
+//
+// The kernel does not know that the same path differs by caller, so
+// it must not cache: mount with zero entry and attribute timeouts
+// (see nodefs.Options), and the wrapper opens files with direct I/O
+// so one user's data does not land in the page cache of another.
+// Calls without a context, like StatFs, go to the directory holding
+// the per-user trees.
+func NewUserViewFileSystem(fs FileSystem, opts UserViewOptions) FileSystem {
+	if opts.Dir == nil {
+		opts.Dir = func(uid uint32) string {
+			return strconv.FormatUint(uint64(uid), 10)
+		}
+	}
+	return &userViewFileSystem{
+		FileSystem: fs,
+		opts:       opts,
+		created:    map[uint32]bool{},
+	}
+}
+
+type userViewFileSystem struct {
+	FileSystem FileSystem
+	opts       UserViewOptions
+
+	mu      sync.Mutex
+	created map[uint32]bool
+}
+
+// view returns the name in fs of name as seen by the caller.
+func (fs *userViewFileSystem) view(name string, context *fuse.Context) (string, fuse.Status) {
+	if context == nil {
+		return "", fuse.EPERM
+	}
+	dir := filepath.Clean(fs.opts.Dir(context.Uid))
+	if dir == "." || dir == ".." || filepath.IsAbs(dir) || strings.HasPrefix(dir, "../") {
+		return "", fuse.EPERM
+	}
+	if fs.opts.Create {
+		if code := fs.create(dir, context); !code.Ok() {
+			return "", code
+		}
+	}
+	return filepath.Join(dir, name), fuse.OK
+}
+
+// create makes the directory dir of the caller, if it does not exist.
+func (fs *userViewFileSystem) create(dir string, context *fuse.Context) fuse.Status {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.created[context.Uid] {
+		return fuse.OK
+	}
+	_, code := fs.FileSystem.GetAttr(dir, context)
+	if code == fuse.ENOENT {
+		if code = fs.FileSystem.Mkdir(dir, 0700, context); !code.Ok() {
+			return code
+		}
+		code = fs.FileSystem.Chown(dir, context.Uid, context.Gid, context)
+	}
+	if !code.Ok() {
+		return code
+	}
+	fs.created[context.Uid] = true
+	return fuse.OK
+}
+
+// directIO keeps the page cache out of files opened through the
+// view.
+func directIO(f nodefs.File, code fuse.Status) (nodefs.File, fuse.Status) {
+	if !code.Ok() || f == nil {
+		return f, code
+	}
+	return &nodefs.WithFlags{
+		File:        f,
+		FuseFlags:   fuse.FOPEN_DIRECT_IO,
+		Description: "userView",
+	}, code
+}
+
+func (fs *userViewFileSystem) String() string {
+	return fmt.Sprintf("userViewFileSystem(%s)", fs.FileSystem.String())
+}
+
+func (fs *userViewFileSystem) SetDebug(debug bool) {
+	fs.FileSystem.SetDebug(debug)
+}
+
+func (fs *userViewFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.FileSystem.GetAttr(n, context)
+}
+
+func (fs *userViewFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Chmod(n, mode, context)
+}
+
+func (fs *userViewFileSystem) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Chown(n, uid, gid, context)
+}
+
+func (fs *userViewFileSystem) Utimens(name string, Atime *time.Time, Mtime *time.Time, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Utimens(n, Atime, Mtime, context)
+}
+
+func (fs *userViewFileSystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Truncate(n, size, context)
+}
+
+func (fs *userViewFileSystem) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Access(n, mode, context)
+}
+
+func (fs *userViewFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	o, code := fs.view(oldName, context)
+	if !code.Ok() {
+		return code
+	}
+	n, _ := fs.view(newName, context)
+	return fs.FileSystem.Link(o, n, context)
+}
+
+func (fs *userViewFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Mkdir(n, mode, context)
+}
+
+func (fs *userViewFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Mknod(n, mode, dev, context)
+}
+
+func (fs *userViewFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	o, code := fs.view(oldName, context)
+	if !code.Ok() {
+		return code
+	}
+	n, _ := fs.view(newName, context)
+	return fs.FileSystem.Rename(o, n, context)
+}
+
+func (fs *userViewFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Rmdir(n, context)
+}
+
+func (fs *userViewFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Unlink(n, context)
+}
+
+func (fs *userViewFileSystem) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.FileSystem.GetXAttr(n, attribute, context)
+}
+
+func (fs *userViewFileSystem) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.FileSystem.ListXAttr(n, context)
+}
+
+func (fs *userViewFileSystem) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.RemoveXAttr(n, attr, context)
+}
+
+func (fs *userViewFileSystem) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.SetXAttr(n, attr, data, flags, context)
+}
+
+func (fs *userViewFileSystem) OnMount(nodeFs *PathNodeFs) {
+	fs.FileSystem.OnMount(nodeFs)
+}
+
+func (fs *userViewFileSystem) OnUnmount() {
+	fs.FileSystem.OnUnmount()
+}
+
+func (fs *userViewFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return directIO(fs.FileSystem.Open(n, flags, context))
+}
+
+func (fs *userViewFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return directIO(fs.FileSystem.Create(n, flags, mode, context))
+}
+
+func (fs *userViewFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.FileSystem.OpenDir(n, context)
+}
+
+func (fs *userViewFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	n, code := fs.view(linkName, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.FileSystem.Symlink(value, n, context)
+}
+
+func (fs *userViewFileSystem) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	n, code := fs.view(name, context)
+	if !code.Ok() {
+		return "", code
+	}
+	return fs.FileSystem.Readlink(n, context)
+}
+
+func (fs *userViewFileSystem) StatFs(name string) *fuse.StatfsOut {
+	return fs.FileSystem.StatFs("")
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestUserViewFileSystem(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	uid := uint32(os.Getuid())
+	gid := uint32(os.Getgid())
+	fs := NewUserViewFileSystem(NewLoopbackFileSystem(dir), UserViewOptions{
+		Dir: func(u uint32) string {
+			if u == uid {
+				return "home/me"
+			}
+			return "home/other"
+		},
+		Create: true,
+	})
+	me := &fuse.Context{Owner: fuse.Owner{Uid: uid, Gid: gid}}
+	other := &fuse.Context{Owner: fuse.Owner{Uid: uid + 1, Gid: gid}}
+	if err := os.Mkdir(filepath.Join(dir, "home"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	f, code := fs.Create("file", uint32(os.O_WRONLY), 0644, me)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	f.Release()
+	if _, err := os.Stat(filepath.Join(dir, "home/me/file")); err != nil {
+		t.Errorf("file not in the home of its creator: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "home/me")); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("home: got %v, %v, want mode 0700", fi, err)
+	}
+
+	if _, code := fs.GetAttr("file", other); code != fuse.ENOENT {
+		t.Errorf("GetAttr by other user: got %v, want ENOENT", code)
+	}
+	if ents, code := fs.OpenDir("", other); !code.Ok() || len(ents) != 0 {
+		t.Errorf("OpenDir by other user: got %v, %v", ents, code)
+	}
+	if ents, code := fs.OpenDir("", me); !code.Ok() || len(ents) != 1 || ents[0].Name != "file" {
+		t.Errorf("OpenDir: got %v, %v", ents, code)
+	}
+
+	if _, code := fs.GetAttr("file", nil); code != fuse.EPERM {
+		t.Errorf("GetAttr without context: got %v, want EPERM", code)
+	}
+}
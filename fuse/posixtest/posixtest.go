@@ -0,0 +1,118 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package posixtest is a conformance suite that checks a mounted
+// file system against the POSIX semantics that programs commonly
+// rely on. It can be run against any FUSE file system:
+//
+//	func TestPosix(t *testing.T) {
+//		posixtest.RunPathFs(t, NewMyFileSystem(), nil)
+//	}
+//
+// Each test gets a fresh, empty directory in the mount.
+package posixtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// All lists the conformance tests by name. Each takes a directory
+// on the file system under test, which is empty on entry.
+var All = map[string]func(t *testing.T, dir string){
+	"RenameOverwrite":     RenameOverwrite,
+	"RenameOverwriteDir":  RenameOverwriteDir,
+	"RenameSameFile":      RenameSameFile,
+	"UnlinkWhileOpen":     UnlinkWhileOpen,
+	"OpenExclusive":       OpenExclusive,
+	"MkdirExisting":       MkdirExisting,
+	"RmdirNotEmpty":       RmdirNotEmpty,
+	"Permissions":         Permissions,
+	"TimestampsSet":       TimestampsSet,
+	"TimestampsWrite":     TimestampsWrite,
+	"SymlinkReadlink":     SymlinkReadlink,
+	"SymlinkDangling":     SymlinkDangling,
+	"SymlinkExisting":     SymlinkExisting,
+	"TruncateExtends":     TruncateExtends,
+	"AppendWrite":         AppendWrite,
+	"ReaddirAfterCreate":  ReaddirAfterCreate,
+	"ReaddirAfterUnlink":  ReaddirAfterUnlink,
+	"StatAfterRenameDir":  StatAfterRenameDir,
+	"HardlinkSharesInode": HardlinkSharesInode,
+}
+
+// Run runs all tests in All as subtests, each in a new
+// subdirectory of mnt. Tests listed in skip are not run; use this
+// for features the file system deliberately does not support.
+func Run(t *testing.T, mnt string, skip ...string) {
+	skipped := map[string]bool{}
+	for _, s := range skip {
+		skipped[s] = true
+	}
+
+	var names []string
+	for n := range All {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		fn := All[n]
+		t.Run(n, func(t *testing.T) {
+			if skipped[n] {
+				t.Skip("skipped by caller")
+			}
+			dir := filepath.Join(mnt, n)
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatalf("Mkdir: %v", err)
+			}
+			fn(t, dir)
+		})
+	}
+}
+
+// RunNode mounts the node file system root on a temporary directory,
+// and runs the suite against it.
+func RunNode(t *testing.T, root nodefs.Node, opts *nodefs.Options, skip ...string) {
+	conn := nodefs.NewFileSystemConnector(root, opts)
+	mountOpts := &fuse.MountOptions{}
+	if opts != nil {
+		mountOpts.Debug = opts.Debug
+	}
+	mnt, _ := testutil.Mount(t, conn.RawFS(), mountOpts)
+	Run(t, mnt, skip...)
+}
+
+// RunPathFs runs the suite against a path file system.
+func RunPathFs(t *testing.T, fs pathfs.FileSystem, opts *pathfs.PathNodeFsOptions, skip ...string) {
+	nfs := pathfs.NewPathNodeFs(fs, opts)
+	RunNode(t, nfs.Root(), nil, skip...)
+}
+
+// errno extracts the errno from an error returned by the os package.
+func errno(err error) fuse.Status {
+	return fuse.ToStatus(err)
+}
+
+func writeFile(t *testing.T, name string, content string) {
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile(%q): %v", name, err)
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	c, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile(%q): %v", name, err)
+	}
+	return string(c)
+}
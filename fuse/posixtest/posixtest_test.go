@@ -0,0 +1,45 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package posixtest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// The real file system underneath is the reference.
+func TestNative(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	Run(t, dir)
+}
+
+func TestLoopback(t *testing.T) {
+	// Cleanups run after the unmount registered by RunPathFs.
+	dir := testutil.TempDir()
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	RunPathFs(t, pathfs.NewLoopbackFileSystem(dir), nil)
+}
+
+func TestStressNative(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	Stress(t, dir, nil)
+}
+
+func TestStressLoopback(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	dir := testutil.TempDir()
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	nfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(dir), nil)
+	mnt, _ := testutil.Mount(t, nodefs.NewFileSystemConnector(nfs.Root(), nil).RawFS(), nil)
+	Stress(t, mnt, nil)
+}
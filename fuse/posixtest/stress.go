@@ -0,0 +1,315 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package posixtest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// StressOptions configures Stress.
+type StressOptions struct {
+	// Number of concurrent workers. Defaults to 8.
+	Workers int
+
+	// Operations per worker. Defaults to 500.
+	Ops int
+
+	// Number of distinct names each directory cycles through.
+	// Few names mean many collisions. Defaults to 10.
+	Names int
+
+	// Seed for the random generator. If zero, the current time is
+	// used. The seed is logged, so failures can be reproduced.
+	Seed int64
+}
+
+// errors that racing operations on shared names may legitimately
+// return.
+var raceErrors = map[syscall.Errno]bool{
+	syscall.ENOENT:    true,
+	syscall.EEXIST:    true,
+	syscall.ENOTEMPTY: true,
+	syscall.EISDIR:    true,
+	syscall.ENOTDIR:   true,
+
+	// unlink of a directory, on darwin and other POSIX systems.
+	syscall.EPERM: true,
+}
+
+type stressWorker struct {
+	t      *testing.T
+	rnd    *rand.Rand
+	id     int
+	opts   *StressOptions
+	shared string
+	own    string
+
+	// Expected content of the files in own.
+	model map[string][]byte
+}
+
+// Stress runs randomized create, rename, write, truncate, unlink and
+// readdir operations from concurrent workers against dir. Each worker
+// has a private directory whose content is checked against a model,
+// and all workers race on a shared directory, where only errors that
+// a race can explain are accepted. After the workers finish, the
+// listing and the content of all directories are checked for
+// consistency.
+func Stress(t *testing.T, dir string, opts *StressOptions) {
+	o := StressOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Workers == 0 {
+		o.Workers = 8
+	}
+	if o.Ops == 0 {
+		o.Ops = 500
+	}
+	if o.Names == 0 {
+		o.Names = 10
+	}
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+	t.Logf("stress seed %d", o.Seed)
+
+	shared := filepath.Join(dir, "shared")
+	if err := os.Mkdir(shared, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	var workers []*stressWorker
+	for i := 0; i < o.Workers; i++ {
+		w := &stressWorker{
+			t:      t,
+			rnd:    rand.New(rand.NewSource(o.Seed + int64(i))),
+			id:     i,
+			opts:   &o,
+			shared: shared,
+			own:    filepath.Join(dir, fmt.Sprintf("worker%d", i)),
+			model:  map[string][]byte{},
+		}
+		if err := os.Mkdir(w.own, 0755); err != nil {
+			t.Fatalf("Mkdir: %v", err)
+		}
+		workers = append(workers, w)
+	}
+
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *stressWorker) {
+			defer wg.Done()
+			for i := 0; i < o.Ops; i++ {
+				w.privateOp()
+				w.sharedOp()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, w := range workers {
+		w.verifyModel()
+	}
+	verifyConsistent(t, shared)
+}
+
+func (w *stressWorker) name() string {
+	return fmt.Sprintf("n%d", w.rnd.Intn(w.opts.Names))
+}
+
+// payload returns data that identifies the writer.
+func (w *stressWorker) payload() []byte {
+	return bytes.Repeat([]byte{byte('a' + w.id%26)}, 1+w.rnd.Intn(8192))
+}
+
+func (w *stressWorker) fail(op string, err error) {
+	w.t.Errorf("worker %d: %s: %v", w.id, op, err)
+}
+
+func (w *stressWorker) privateOp() {
+	n := w.name()
+	p := filepath.Join(w.own, n)
+	_, exists := w.model[n]
+
+	switch w.rnd.Intn(5) {
+	case 0:
+		data := w.payload()
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			w.fail("WriteFile", err)
+			return
+		}
+		w.model[n] = data
+	case 1:
+		if !exists {
+			return
+		}
+		dst := w.name()
+		if err := os.Rename(p, filepath.Join(w.own, dst)); err != nil {
+			w.fail("Rename", err)
+			return
+		}
+		data := w.model[n]
+		delete(w.model, n)
+		w.model[dst] = data
+	case 2:
+		if !exists {
+			return
+		}
+		size := w.rnd.Intn(2 * (len(w.model[n]) + 1))
+		if err := os.Truncate(p, int64(size)); err != nil {
+			w.fail("Truncate", err)
+			return
+		}
+		data := make([]byte, size)
+		copy(data, w.model[n])
+		w.model[n] = data
+	case 3:
+		err := os.Remove(p)
+		if exists && err != nil {
+			w.fail("Remove", err)
+		} else if !exists && !os.IsNotExist(err) {
+			w.fail("Remove nonexistent", err)
+		}
+		delete(w.model, n)
+	case 4:
+		data, err := ioutil.ReadFile(p)
+		if !exists {
+			if !os.IsNotExist(err) {
+				w.fail("ReadFile nonexistent", err)
+			}
+			return
+		}
+		if err != nil {
+			w.fail("ReadFile", err)
+		} else if !bytes.Equal(data, w.model[n]) {
+			w.t.Errorf("worker %d: %s: got %d bytes, want %d", w.id, n, len(data), len(w.model[n]))
+		}
+	}
+}
+
+func (w *stressWorker) check(op string, err error) {
+	if err == nil {
+		return
+	}
+	var errno syscall.Errno
+	switch e := err.(type) {
+	case *os.PathError:
+		errno, _ = e.Err.(syscall.Errno)
+	case *os.LinkError:
+		errno, _ = e.Err.(syscall.Errno)
+	case syscall.Errno:
+		errno = e
+	}
+	if !raceErrors[errno] {
+		w.fail(op, err)
+	}
+}
+
+func (w *stressWorker) sharedOp() {
+	p := filepath.Join(w.shared, w.name())
+	switch w.rnd.Intn(7) {
+	case 0:
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE, 0644)
+		w.check("Create", err)
+		if err == nil {
+			_, err = f.WriteAt(w.payload(), int64(w.rnd.Intn(4096)))
+			w.check("WriteAt", err)
+			w.check("Close", f.Close())
+		}
+	case 1:
+		w.check("Rename", syscall.Rename(p, filepath.Join(w.shared, w.name())))
+	case 2:
+		w.check("Truncate", os.Truncate(p, int64(w.rnd.Intn(8192))))
+	case 3:
+		w.check("Remove", syscall.Unlink(p))
+	case 4:
+		w.check("Mkdir", os.Mkdir(p, 0755))
+	case 5:
+		w.check("Rmdir", syscall.Rmdir(p))
+	case 6:
+		names, err := readdirnames(w.shared)
+		w.check("Readdir", err)
+		seen := map[string]bool{}
+		for _, n := range names {
+			if seen[n] {
+				w.t.Errorf("worker %d: duplicate entry %q in %v", w.id, n, names)
+			}
+			seen[n] = true
+		}
+	}
+}
+
+func readdirnames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+func (w *stressWorker) verifyModel() {
+	names, err := readdirnames(w.own)
+	if err != nil {
+		w.fail("Readdir", err)
+		return
+	}
+	sort.Strings(names)
+	var want []string
+	for n := range w.model {
+		want = append(want, n)
+	}
+	sort.Strings(want)
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		w.t.Errorf("worker %d: got entries %v, want %v", w.id, names, want)
+	}
+	for n, content := range w.model {
+		data, err := ioutil.ReadFile(filepath.Join(w.own, n))
+		if err != nil {
+			w.fail("ReadFile", err)
+		} else if !bytes.Equal(data, content) {
+			w.t.Errorf("worker %d: final %s: got %d bytes, want %d", w.id, n, len(data), len(content))
+		}
+	}
+}
+
+// verifyConsistent checks that, once quiescent, every listed entry
+// exists, and that the size of each file matches its content.
+func verifyConsistent(t *testing.T, dir string) {
+	names, err := readdirnames(dir)
+	if err != nil {
+		t.Errorf("Readdir: %v", err)
+		return
+	}
+	for _, n := range names {
+		p := filepath.Join(dir, n)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			t.Errorf("listed entry %q: %v", n, err)
+			continue
+		}
+		if fi.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Errorf("ReadFile(%q): %v", n, err)
+		} else if int64(len(data)) != fi.Size() {
+			t.Errorf("%q: stat size %d, read %d bytes", n, fi.Size(), len(data))
+		}
+	}
+}
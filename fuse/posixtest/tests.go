@@ -0,0 +1,496 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package posixtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// RenameOverwrite checks that rename replaces an existing file
+// atomically.
+func RenameOverwrite(t *testing.T, dir string) {
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	writeFile(t, src, "new")
+	writeFile(t, dst, "old")
+
+	if err := os.Rename(src, dst); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if got := readFile(t, dst); got != "new" {
+		t.Errorf("got %q, want %q", got, "new")
+	}
+	if _, err := os.Lstat(src); errno(err) != fuse.ENOENT {
+		t.Errorf("Lstat source: got %v, want ENOENT", err)
+	}
+}
+
+// RenameOverwriteDir checks that a directory may replace an empty
+// directory, but not a non-empty one or a file.
+func RenameOverwriteDir(t *testing.T, dir string) {
+	src := filepath.Join(dir, "src")
+	empty := filepath.Join(dir, "empty")
+	full := filepath.Join(dir, "full")
+	file := filepath.Join(dir, "file")
+	for _, d := range []string{src, empty, full} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("Mkdir: %v", err)
+		}
+	}
+	writeFile(t, filepath.Join(full, "x"), "")
+	writeFile(t, file, "")
+
+	// os.Rename refuses to replace directories, so use the
+	// system call.
+	if err := syscall.Rename(src, full); err == nil {
+		t.Errorf("Rename onto non-empty directory succeeded")
+	} else if err != syscall.ENOTEMPTY && err != syscall.EEXIST {
+		t.Errorf("Rename onto non-empty directory: got %v, want ENOTEMPTY", err)
+	}
+	if err := syscall.Rename(src, file); err != syscall.ENOTDIR {
+		t.Errorf("Rename directory onto file: got %v, want ENOTDIR", err)
+	}
+	if err := syscall.Rename(file, empty); err != syscall.EISDIR {
+		t.Errorf("Rename file onto directory: got %v, want EISDIR", err)
+	}
+	if err := syscall.Rename(src, empty); err != nil {
+		t.Fatalf("Rename onto empty directory: %v", err)
+	}
+	if fi, err := os.Lstat(empty); err != nil || !fi.IsDir() {
+		t.Errorf("Lstat after rename: %v %v", fi, err)
+	}
+}
+
+// RenameSameFile checks that renaming a file onto a hard link of
+// itself succeeds and leaves both names.
+func RenameSameFile(t *testing.T, dir string) {
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeFile(t, a, "data")
+	if err := os.Link(a, b); err != nil {
+		t.Skipf("Link: %v", err)
+	}
+	if err := os.Rename(a, b); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	for _, n := range []string{a, b} {
+		if got := readFile(t, n); got != "data" {
+			t.Errorf("%s: got %q", n, got)
+		}
+	}
+}
+
+// UnlinkWhileOpen checks that an unlinked file stays usable through
+// open descriptors.
+func UnlinkWhileOpen(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("hello"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if err := os.Remove(name); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Lstat(name); errno(err) != fuse.ENOENT {
+		t.Errorf("Lstat after Remove: got %v, want ENOENT", err)
+	}
+
+	if _, err := f.WriteString(" world"); err != nil {
+		t.Errorf("Write after Remove: %v", err)
+	}
+	buf := make([]byte, 20)
+	n, err := f.ReadAt(buf, 0)
+	if got := string(buf[:n]); got != "hello world" {
+		t.Errorf("ReadAt after Remove: got %q, %v", got, err)
+	}
+	if fi, err := f.Stat(); err != nil {
+		t.Errorf("Fstat after Remove: %v", err)
+	} else if fi.Size() != 11 {
+		t.Errorf("Fstat after Remove: got size %d, want 11", fi.Size())
+	}
+}
+
+// OpenExclusive checks O_CREAT|O_EXCL.
+func OpenExclusive(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("first O_EXCL open: %v", err)
+	}
+	f.Close()
+
+	_, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errno(err) != fuse.Status(syscall.EEXIST) {
+		t.Errorf("second O_EXCL open: got %v, want EEXIST", err)
+	}
+
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("dangling", link); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	_, err = os.OpenFile(link, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errno(err) != fuse.Status(syscall.EEXIST) {
+		t.Errorf("O_EXCL open of symlink: got %v, want EEXIST", err)
+	}
+}
+
+// MkdirExisting checks that mkdir does not replace anything.
+func MkdirExisting(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, name, "")
+	if err := os.Mkdir(name, 0755); errno(err) != fuse.Status(syscall.EEXIST) {
+		t.Errorf("Mkdir over file: got %v, want EEXIST", err)
+	}
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if err := os.Mkdir(sub, 0755); errno(err) != fuse.Status(syscall.EEXIST) {
+		t.Errorf("Mkdir twice: got %v, want EEXIST", err)
+	}
+}
+
+// RmdirNotEmpty checks that only empty directories can be removed,
+// and that rmdir refuses files.
+func RmdirNotEmpty(t *testing.T, dir string) {
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	file := filepath.Join(sub, "file")
+	writeFile(t, file, "")
+
+	if err := syscall.Rmdir(sub); err == nil {
+		t.Errorf("Rmdir of non-empty directory succeeded")
+	} else if err != syscall.ENOTEMPTY && err != syscall.EEXIST {
+		t.Errorf("Rmdir of non-empty directory: got %v, want ENOTEMPTY", err)
+	}
+	if err := syscall.Rmdir(file); err != syscall.ENOTDIR {
+		t.Errorf("Rmdir of file: got %v, want ENOTDIR", err)
+	}
+	if err := syscall.Unlink(sub); err != syscall.EISDIR && err != syscall.EPERM {
+		t.Errorf("Unlink of directory: got %v, want EISDIR", err)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := syscall.Rmdir(sub); err != nil {
+		t.Errorf("Rmdir of empty directory: %v", err)
+	}
+}
+
+// Permissions checks that mode bits are stored, and enforced for
+// unprivileged users.
+func Permissions(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, name, "secret")
+	for _, mode := range []os.FileMode{0600, 0444, 0751, 0} {
+		if err := os.Chmod(name, mode); err != nil {
+			t.Fatalf("Chmod: %v", err)
+		}
+		fi, err := os.Lstat(name)
+		if err != nil {
+			t.Fatalf("Lstat: %v", err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("got mode %o, want %o", fi.Mode().Perm(), mode)
+		}
+	}
+
+	if os.Geteuid() == 0 {
+		// root bypasses permission checks.
+		return
+	}
+	if _, err := os.Open(name); errno(err) != fuse.Status(syscall.EACCES) {
+		t.Errorf("Open of mode 0 file: got %v, want EACCES", err)
+	}
+	if err := os.Chmod(name, 0444); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if _, err := os.OpenFile(name, os.O_WRONLY, 0); errno(err) != fuse.Status(syscall.EACCES) {
+		t.Errorf("Open for write of mode 0444 file: got %v, want EACCES", err)
+	}
+}
+
+// TimestampsSet checks that utimes sets atime and mtime.
+func TimestampsSet(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, name, "")
+
+	atime := time.Unix(1500000000, 0)
+	mtime := time.Unix(1400000000, 0)
+	if err := os.Chtimes(name, atime, mtime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Lstat(name, &st); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+	var a fuse.Attr
+	a.FromStat(&st)
+	if a.Atime != uint64(atime.Unix()) {
+		t.Errorf("got atime %d, want %d", a.Atime, atime.Unix())
+	}
+	if a.Mtime != uint64(mtime.Unix()) {
+		t.Errorf("got mtime %d, want %d", a.Mtime, mtime.Unix())
+	}
+}
+
+// TimestampsWrite checks that writing a file updates its mtime, and
+// creating an entry updates the directory's mtime.
+func TimestampsWrite(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, name, "")
+	past := time.Unix(1400000000, 0)
+	if err := os.Chtimes(name, past, past); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	if err := os.Chtimes(dir, past, past); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := f.WriteString("data"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	f.Close()
+	writeFile(t, filepath.Join(dir, "other"), "")
+
+	for _, n := range []string{name, dir} {
+		fi, err := os.Lstat(n)
+		if err != nil {
+			t.Fatalf("Lstat: %v", err)
+		}
+		if !fi.ModTime().After(past) {
+			t.Errorf("%s: mtime %v not updated", n, fi.ModTime())
+		}
+	}
+}
+
+// SymlinkReadlink checks that symlink targets are stored verbatim.
+func SymlinkReadlink(t *testing.T, dir string) {
+	targets := []string{"plain", "../up", "/abs/path", "a//b/", "with space"}
+	for i, target := range targets {
+		link := filepath.Join(dir, "link"+string(rune('0'+i)))
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatalf("Symlink(%q): %v", target, err)
+		}
+		got, err := os.Readlink(link)
+		if err != nil || got != target {
+			t.Errorf("Readlink: got %q %v, want %q", got, err, target)
+		}
+		fi, err := os.Lstat(link)
+		if err != nil {
+			t.Fatalf("Lstat: %v", err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Lstat: got mode %v, want symlink", fi.Mode())
+		}
+		if fi.Size() != int64(len(target)) {
+			t.Errorf("Lstat: got size %d, want %d", fi.Size(), len(target))
+		}
+	}
+}
+
+// SymlinkDangling checks that dangling links can be created and
+// stat'ed, and that following them fails.
+func SymlinkDangling(t *testing.T, dir string) {
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("nonexistent", link); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if _, err := os.Stat(link); errno(err) != fuse.ENOENT {
+		t.Errorf("Stat: got %v, want ENOENT", err)
+	}
+
+	// Creating through the link creates the target.
+	writeFile(t, link, "via link")
+	if got := readFile(t, filepath.Join(dir, "nonexistent")); got != "via link" {
+		t.Errorf("target: got %q", got)
+	}
+
+	// Following a link to a directory.
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	writeFile(t, filepath.Join(sub, "file"), "in sub")
+	if err := os.Symlink("sub", filepath.Join(dir, "sublink")); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dir, "sublink", "file")); got != "in sub" {
+		t.Errorf("through directory link: got %q", got)
+	}
+}
+
+// SymlinkExisting checks that symlink does not replace existing
+// entries, and that unlinking a link leaves its target.
+func SymlinkExisting(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, name, "data")
+	if err := os.Symlink("x", name); errno(err) != fuse.Status(syscall.EEXIST) {
+		t.Errorf("Symlink over file: got %v, want EEXIST", err)
+	}
+
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("file", link); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if err := os.Remove(link); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if got := readFile(t, name); got != "data" {
+		t.Errorf("target after unlinking link: got %q", got)
+	}
+}
+
+// TruncateExtends checks that truncate can grow a file with zeros.
+func TruncateExtends(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, name, "abcdef")
+	if err := os.Truncate(name, 3); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if got := readFile(t, name); got != "abc" {
+		t.Errorf("after shrink: got %q", got)
+	}
+	if err := os.Truncate(name, 5); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if got := readFile(t, name); got != "abc\x00\x00" {
+		t.Errorf("after grow: got %q", got)
+	}
+}
+
+// AppendWrite checks that O_APPEND writes go to the end of the file.
+func AppendWrite(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, name, "abc")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if _, err := f.WriteString("def"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	f.Close()
+	if got := readFile(t, name); got != "abcdef" {
+		t.Errorf("got %q, want abcdef", got)
+	}
+}
+
+func readdirNames(t *testing.T, dir string) map[string]bool {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.Name()] = true
+	}
+	return names
+}
+
+// ReaddirAfterCreate checks that new entries show up in listings.
+func ReaddirAfterCreate(t *testing.T, dir string) {
+	readdirNames(t, dir)
+	writeFile(t, filepath.Join(dir, "file"), "")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	got := readdirNames(t, dir)
+	if !got["file"] || !got["sub"] || len(got) != 2 {
+		t.Errorf("got %v, want [file sub]", got)
+	}
+}
+
+// ReaddirAfterUnlink checks that removed entries vanish from
+// listings.
+func ReaddirAfterUnlink(t *testing.T, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, name, "")
+	readdirNames(t, dir)
+	if err := os.Remove(name); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if got := readdirNames(t, dir); len(got) != 0 {
+		t.Errorf("got %v, want empty", got)
+	}
+}
+
+// StatAfterRenameDir checks that entries below a renamed directory
+// are reachable under the new name only.
+func StatAfterRenameDir(t *testing.T, dir string) {
+	old := filepath.Join(dir, "old")
+	if err := os.MkdirAll(filepath.Join(old, "a", "b"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	writeFile(t, filepath.Join(old, "a", "b", "file"), "deep")
+
+	// Populate caches.
+	readFile(t, filepath.Join(old, "a", "b", "file"))
+
+	renamed := filepath.Join(dir, "new")
+	if err := os.Rename(old, renamed); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if got := readFile(t, filepath.Join(renamed, "a", "b", "file")); got != "deep" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := os.Lstat(filepath.Join(old, "a")); errno(err) != fuse.ENOENT {
+		t.Errorf("Lstat old name: got %v, want ENOENT", err)
+	}
+}
+
+// HardlinkSharesInode checks that hard links share content and
+// report the link count.
+func HardlinkSharesInode(t *testing.T, dir string) {
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeFile(t, a, "one")
+	if err := os.Link(a, b); err != nil {
+		if errno(err) == fuse.ENOSYS || errno(err) == fuse.EPERM {
+			t.Skipf("Link not supported: %v", err)
+		}
+		t.Fatalf("Link: %v", err)
+	}
+	writeFile(t, b, "two")
+	if got := readFile(t, a); got != "two" {
+		t.Errorf("content through other link: got %q", got)
+	}
+
+	var sa, sb syscall.Stat_t
+	if err := syscall.Lstat(a, &sa); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+	if err := syscall.Lstat(b, &sb); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+	if sa.Ino != sb.Ino {
+		t.Errorf("inode numbers differ: %d != %d", sa.Ino, sb.Ino)
+	}
+	if sa.Nlink != 2 {
+		t.Errorf("got nlink %d, want 2", sa.Nlink)
+	}
+}
@@ -13,11 +13,14 @@ import (
 
 var initFlagNames map[int64]string
 var releaseFlagNames map[int64]string
+var fsyncFlagNames map[int64]string
 var OpenFlagNames map[int64]string
 var FuseOpenFlagNames map[int64]string
 var accessFlagName map[int64]string
 var writeFlagNames map[int64]string
 var readFlagNames map[int64]string
+var setAttrFlagNames map[int64]string
+var lockTypeNames map[uint32]string
 
 func init() {
 	writeFlagNames = map[int64]string{
@@ -46,12 +49,18 @@ func init() {
 		CAP_ASYNC_DIO:        "ASYNC_DIO",
 		CAP_WRITEBACK_CACHE:  "WRITEBACK_CACHE",
 		CAP_NO_OPEN_SUPPORT:  "NO_OPEN_SUPPORT",
-		CAP_PARALLEL_DIROPS:  "CAP_PARALLEL_DIROPS",
-		CAP_HANDLE_KILLPRIV:  "CAP_PARALLEL_DIROPS",
-		CAP_POSIX_ACL:        "CAP_POSIX_ACL",
+		CAP_PARALLEL_DIROPS:  "PARALLEL_DIROPS",
+		CAP_HANDLE_KILLPRIV:  "HANDLE_KILLPRIV",
+		CAP_POSIX_ACL:        "POSIX_ACL",
+
+		CAP_NO_OPENDIR_SUPPORT: "NO_OPENDIR_SUPPORT",
 	}
 	releaseFlagNames = map[int64]string{
-		RELEASE_FLUSH: "FLUSH",
+		RELEASE_FLUSH:        "FLUSH",
+		RELEASE_FLOCK_UNLOCK: "FLOCK_UNLOCK",
+	}
+	fsyncFlagNames = map[int64]string{
+		FSYNC_FDATASYNC: "FDATASYNC",
 	}
 	OpenFlagNames = map[int64]string{
 		int64(os.O_WRONLY):        "WRONLY",
@@ -73,6 +82,15 @@ func init() {
 		FOPEN_KEEP_CACHE:  "CACHE",
 		FOPEN_NONSEEKABLE: "NONSEEK",
 	}
+	setAttrFlagNames = map[int64]string{
+		FATTR_ATIME_NOW: "atime now",
+		FATTR_MTIME_NOW: "mtime now",
+	}
+	lockTypeNames = map[uint32]string{
+		syscall.F_RDLCK: "RDLCK",
+		syscall.F_WRLCK: "WRLCK",
+		syscall.F_UNLCK: "UNLCK",
+	}
 	accessFlagName = map[int64]string{
 		X_OK: "x",
 		W_OK: "w",
@@ -99,23 +117,23 @@ func FlagString(names map[int64]string, fl int64, def string) string {
 	return strings.Join(s, ",")
 }
 
-func (me *ForgetIn) string() string {
+func (me *ForgetIn) String() string {
 	return fmt.Sprintf("{Nlookup=%d}", me.Nlookup)
 }
 
-func (me *_BatchForgetIn) string() string {
+func (me *_BatchForgetIn) String() string {
 	return fmt.Sprintf("{Count=%d}", me.Count)
 }
 
-func (me *MkdirIn) string() string {
+func (me *MkdirIn) String() string {
 	return fmt.Sprintf("{0%o (0%o)}", me.Mode, me.Umask)
 }
 
-func (me *RenameIn) string() string {
+func (me *RenameIn) String() string {
 	return fmt.Sprintf("{%d}", me.Newdir)
 }
 
-func (me *SetAttrIn) string() string {
+func (me *SetAttrInCommon) String() string {
 	s := []string{}
 	if me.Valid&FATTR_MODE != 0 {
 		s = append(s, fmt.Sprintf("mode 0%o", me.Mode))
@@ -124,7 +142,7 @@ func (me *SetAttrIn) string() string {
 		s = append(s, fmt.Sprintf("uid %d", me.Uid))
 	}
 	if me.Valid&FATTR_GID != 0 {
-		s = append(s, fmt.Sprintf("uid %d", me.Gid))
+		s = append(s, fmt.Sprintf("gid %d", me.Gid))
 	}
 	if me.Valid&FATTR_SIZE != 0 {
 		s = append(s, fmt.Sprintf("size %d", me.Size))
@@ -135,36 +153,44 @@ func (me *SetAttrIn) string() string {
 	if me.Valid&FATTR_MTIME != 0 {
 		s = append(s, fmt.Sprintf("mtime %d.%09d", me.Mtime, me.Mtimensec))
 	}
+	if me.Valid&FATTR_CTIME != 0 {
+		s = append(s, fmt.Sprintf("ctime %d.%09d", me.Ctime, me.Ctimensec))
+	}
 	if me.Valid&FATTR_FH != 0 {
 		s = append(s, fmt.Sprintf("fh %d", me.Fh))
 	}
-	// TODO - FATTR_ATIME_NOW = (1 << 7), FATTR_MTIME_NOW = (1 << 8), FATTR_LOCKOWNER = (1 << 9)
+	if me.Valid&FATTR_LOCKOWNER != 0 {
+		s = append(s, fmt.Sprintf("lockowner %d", me.LockOwner))
+	}
+	if f := FlagString(setAttrFlagNames, int64(me.Valid&(FATTR_ATIME_NOW|FATTR_MTIME_NOW)), ""); f != "" {
+		s = append(s, f)
+	}
 	return fmt.Sprintf("{%s}", strings.Join(s, ", "))
 }
 
-func (me *ReleaseIn) string() string {
+func (me *ReleaseIn) String() string {
 	return fmt.Sprintf("{Fh %d %s %s L%d}",
 		me.Fh, FlagString(OpenFlagNames, int64(me.Flags), ""),
 		FlagString(releaseFlagNames, int64(me.ReleaseFlags), ""),
 		me.LockOwner)
 }
 
-func (me *OpenIn) string() string {
+func (me *OpenIn) String() string {
 	return fmt.Sprintf("{%s}", FlagString(OpenFlagNames, int64(me.Flags), "O_RDONLY"))
 }
 
-func (me *OpenOut) string() string {
+func (me *OpenOut) String() string {
 	return fmt.Sprintf("{Fh %d %s}", me.Fh,
 		FlagString(FuseOpenFlagNames, int64(me.OpenFlags), ""))
 }
 
-func (me *InitIn) string() string {
+func (me *InitIn) String() string {
 	return fmt.Sprintf("{%d.%d Ra 0x%x %s}",
 		me.Major, me.Minor, me.MaxReadAhead,
 		FlagString(initFlagNames, int64(me.Flags), ""))
 }
 
-func (me *InitOut) string() string {
+func (me *InitOut) String() string {
 	return fmt.Sprintf("{%d.%d Ra 0x%x %s %d/%d Wr 0x%x Tg 0x%x}",
 		me.Major, me.Minor, me.MaxReadAhead,
 		FlagString(initFlagNames, int64(me.Flags), ""),
@@ -172,82 +198,132 @@ func (me *InitOut) string() string {
 		me.TimeGran)
 }
 
-func (s *FsyncIn) string() string {
-	return fmt.Sprintf("{Fh %d Flags %x}", s.Fh, s.FsyncFlags)
+func (s *FsyncIn) String() string {
+	return fmt.Sprintf("{Fh %d Flags %s}", s.Fh, FlagString(fsyncFlagNames, int64(s.FsyncFlags), ""))
 }
 
-func (me *SetXAttrIn) string() string {
+func (me *SetXAttrIn) String() string {
 	return fmt.Sprintf("{sz %d f%o}", me.Size, me.Flags)
 }
 
-func (me *GetXAttrIn) string() string {
+func (me *GetXAttrIn) String() string {
 	return fmt.Sprintf("{sz %d}", me.Size)
 }
 
-func (me *GetXAttrOut) string() string {
+func (me *GetXAttrOut) String() string {
 	return fmt.Sprintf("{sz %d}", me.Size)
 }
 
-func (me *AccessIn) string() string {
+func (me *AccessIn) String() string {
 	return fmt.Sprintf("{%s}", FlagString(accessFlagName, int64(me.Mask), ""))
 }
 
-func (me *FlushIn) string() string {
-	return fmt.Sprintf("{Fh %d}", me.Fh)
+func (me *FlushIn) String() string {
+	return fmt.Sprintf("{Fh %d L%d}", me.Fh, me.LockOwner)
 }
 
-func (me *AttrOut) string() string {
+func (me *AttrOut) String() string {
 	return fmt.Sprintf(
 		"{A%d.%09d %v}",
 		me.AttrValid, me.AttrValidNsec, &me.Attr)
 }
 
 // Returned by LOOKUP
-func (me *EntryOut) string() string {
+func (me *EntryOut) String() string {
 	return fmt.Sprintf("{NodeId: %d Generation=%d EntryValid=%d.%03d AttrValid=%d.%03d Attr=%v}",
 		me.NodeId, me.Generation, me.EntryValid, me.EntryValidNsec/1000000,
 		me.AttrValid, me.AttrValidNsec/1000000, &me.Attr)
 }
 
-func (me *CreateOut) string() string {
+func (me *CreateOut) String() string {
 	return fmt.Sprintf("{NodeId: %d Generation=%d %v %v}", me.NodeId, me.Generation, &me.EntryOut, &me.OpenOut)
 }
 
-func (me *StatfsOut) string() string {
+func (me *StatfsOut) String() string {
 	return fmt.Sprintf(
 		"{blocks (%d,%d)/%d files %d/%d bs%d nl%d frs%d}",
 		me.Bfree, me.Bavail, me.Blocks, me.Ffree, me.Files,
 		me.Bsize, me.NameLen, me.Frsize)
 }
 
-func (o *NotifyInvalEntryOut) string() string {
+func (o *NotifyInvalEntryOut) String() string {
 	return fmt.Sprintf("{parent %d sz %d}", o.Parent, o.NameLen)
 }
 
-func (o *NotifyInvalInodeOut) string() string {
+func (o *NotifyInvalInodeOut) String() string {
 	return fmt.Sprintf("{ino %d off %d sz %d}", o.Ino, o.Off, o.Length)
 }
 
-func (o *NotifyInvalDeleteOut) string() string {
+func (o *NotifyInvalDeleteOut) String() string {
 	return fmt.Sprintf("{parent %d ch %d sz %d}", o.Parent, o.Child, o.NameLen)
 }
 
-func (f *FallocateIn) string() string {
+func (f *FallocateIn) String() string {
 	return fmt.Sprintf("{Fh %d off %d sz %d mod 0%o}",
 		f.Fh, f.Offset, f.Length, f.Mode)
 }
 
-func (f *LinkIn) string() string {
+func (in *BmapIn) String() string {
+	return fmt.Sprintf("{block %d bs %d}", in.Block, in.Blocksize)
+}
+
+func (o *BmapOut) String() string {
+	return fmt.Sprintf("{block %d}", o.Block)
+}
+
+func (f *LinkIn) String() string {
 	return fmt.Sprintf("{Oldnodeid: %d}", f.Oldnodeid)
 }
 
+func (h *InHeader) String() string {
+	return fmt.Sprintf("{%s #%d NodeId %d %v}",
+		operationName(h.Opcode), h.Unique, h.NodeId, &h.Context)
+}
+
+func (h *OutHeader) String() string {
+	return fmt.Sprintf("{#%d %v len %d}", h.Unique, Status(-h.Status), h.Length)
+}
+
+func (o *Owner) String() string {
+	return fmt.Sprintf("{uid %d gid %d}", o.Uid, o.Gid)
+}
+
+func (c *Context) String() string {
+	return fmt.Sprintf("{uid %d gid %d pid %d}", c.Uid, c.Gid, c.Pid)
+}
+
+func (l *FileLock) String() string {
+	typ, ok := lockTypeNames[l.Typ]
+	if !ok {
+		typ = fmt.Sprintf("type %d", l.Typ)
+	}
+	return fmt.Sprintf("{%s %d-%d pid %d}", typ, l.Start, l.End, l.Pid)
+}
+
+func (l *LkIn) String() string {
+	flock := ""
+	if l.LkFlags&FUSE_LK_FLOCK != 0 {
+		flock = " FLOCK"
+	}
+	return fmt.Sprintf("{Fh %d L%d %v%s}", l.Fh, l.Owner, &l.Lk, flock)
+}
+
+func (l *LkOut) String() string {
+	return fmt.Sprintf("{%v}", &l.Lk)
+}
+
+func (i *InterruptIn) String() string {
+	return fmt.Sprintf("{#%d}", i.Unique)
+}
+
+func (o *WriteOut) String() string {
+	return fmt.Sprintf("{%d}", o.Size)
+}
+
 // Print pretty prints FUSE data types for kernel communication
 func Print(obj interface{}) string {
-	t, ok := obj.(interface {
-		string() string
-	})
-	if ok {
-		return t.string()
+	if t, ok := obj.(fmt.Stringer); ok {
+		return t.String()
 	}
 	return fmt.Sprintf("%T: %v", obj, obj)
 }
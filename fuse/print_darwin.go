@@ -14,7 +14,7 @@ func init() {
 	initFlagNames[CAP_CASE_INSENSITIVE] = "CASE_INSENSITIVE"
 }
 
-func (a *Attr) string() string {
+func (a *Attr) String() string {
 	return fmt.Sprintf(
 		"{M0%o SZ=%d L=%d "+
 			"%d:%d "+
@@ -29,26 +29,30 @@ func (a *Attr) string() string {
 		a.Ctime, a.Ctimensec)
 }
 
-func (me *CreateIn) string() string {
+func (me *CreateIn) String() string {
 	return fmt.Sprintf(
 		"{0%o [%s]}", me.Mode,
 		FlagString(OpenFlagNames, int64(me.Flags), "O_RDONLY"))
 }
 
-func (me *GetAttrIn) string() string { return "" }
+func (me *GetAttrIn) String() string { return "" }
 
-func (me *MknodIn) string() string {
+func (me *MknodIn) String() string {
 	return fmt.Sprintf("{0%o, %d}", me.Mode, me.Rdev)
 }
 
-func (me *ReadIn) string() string {
+func (me *ReadIn) String() string {
 	return fmt.Sprintf("{Fh %d off %d sz %d %s}",
 		me.Fh, me.Offset, me.Size,
 		FlagString(readFlagNames, int64(me.ReadFlags), ""))
 }
 
-func (me *WriteIn) string() string {
+func (me *WriteIn) String() string {
 	return fmt.Sprintf("{Fh %d off %d sz %d %s}",
 		me.Fh, me.Offset, me.Size,
 		FlagString(writeFlagNames, int64(me.WriteFlags), ""))
 }
+
+func (me *ExchangeIn) String() string {
+	return fmt.Sprintf("{olddir %d newdir %d opt %d}", me.Olddir, me.Newdir, me.Options)
+}
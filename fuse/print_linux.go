@@ -16,7 +16,7 @@ func init() {
 
 }
 
-func (a *Attr) string() string {
+func (a *Attr) String() string {
 	return fmt.Sprintf(
 		"{M0%o SZ=%d L=%d "+
 			"%d:%d "+
@@ -31,21 +31,21 @@ func (a *Attr) string() string {
 		a.Ctime, a.Ctimensec)
 }
 
-func (me *CreateIn) string() string {
+func (me *CreateIn) String() string {
 	return fmt.Sprintf(
 		"{0%o [%s] (0%o)}", me.Mode,
 		FlagString(OpenFlagNames, int64(me.Flags), "O_RDONLY"), me.Umask)
 }
 
-func (me *GetAttrIn) string() string {
+func (me *GetAttrIn) String() string {
 	return fmt.Sprintf("{Fh %d}", me.Fh_)
 }
 
-func (me *MknodIn) string() string {
+func (me *MknodIn) String() string {
 	return fmt.Sprintf("{0%o (0%o), %d}", me.Mode, me.Umask, me.Rdev)
 }
 
-func (me *ReadIn) string() string {
+func (me *ReadIn) String() string {
 	return fmt.Sprintf("{Fh %d off %d sz %d %s L %d %s}",
 		me.Fh, me.Offset, me.Size,
 		FlagString(readFlagNames, int64(me.ReadFlags), ""),
@@ -53,7 +53,7 @@ func (me *ReadIn) string() string {
 		FlagString(OpenFlagNames, int64(me.Flags), "RDONLY"))
 }
 
-func (me *WriteIn) string() string {
+func (me *WriteIn) String() string {
 	return fmt.Sprintf("{Fh %d off %d sz %d %s L %d %s}",
 		me.Fh, me.Offset, me.Size,
 		FlagString(writeFlagNames, int64(me.WriteFlags), ""),
@@ -0,0 +1,37 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func TestPrint(t *testing.T) {
+	for _, tc := range []struct {
+		obj  interface{}
+		want string
+	}{
+		{&InHeader{Opcode: _OP_LOOKUP, Unique: 7, NodeId: 1, Context: Context{Owner{1, 2}, 3}},
+			"{LOOKUP #7 NodeId 1 {uid 1 gid 2 pid 3}}"},
+		{&OutHeader{Unique: 7, Status: -int32(ENOENT), Length: 16},
+			"{#7 2=no such file or directory len 16}"},
+		// Must not print as the embedded InHeader.
+		{&LkIn{Fh: 3, Owner: 9, Lk: FileLock{Start: 0, End: 10, Typ: syscall.F_WRLCK, Pid: 42}},
+			"{Fh 3 L9 {WRLCK 0-10 pid 42}}"},
+		{&SetAttrIn{SetAttrInCommon: SetAttrInCommon{Valid: FATTR_GID | FATTR_MTIME_NOW, Owner: Owner{Gid: 5}}},
+			"{gid 5, mtime now}"},
+		{&InitIn{Major: 7, Minor: 23, Flags: CAP_POSIX_ACL},
+			"{7.23 Ra 0x0 POSIX_ACL}"},
+	} {
+		if got := fmt.Sprintf("%v", tc.obj); got != tc.want {
+			t.Errorf("got %s, want %s", got, tc.want)
+		}
+		if got := Print(tc.obj); got != tc.want {
+			t.Errorf("Print: got %s, want %s", got, tc.want)
+		}
+	}
+}
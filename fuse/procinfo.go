@@ -0,0 +1,121 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ProcessInfo describes the process that sent a request.
+type ProcessInfo struct {
+	Pid uint32
+
+	// Name is the command name, eg. "bash", or "" if it could not
+	// be found.
+	Name string
+
+	// Cmdline holds the command line arguments, if they could be
+	// read.
+	Cmdline []string
+}
+
+func (p ProcessInfo) String() string {
+	if p.Name == "" {
+		return fmt.Sprintf("pid %d", p.Pid)
+	}
+	return fmt.Sprintf("pid %d (%s)", p.Pid, p.Name)
+}
+
+// ProcessResolver maps the Pid of requests to process names, for
+// debug output and interceptors. Results are cached, and the number
+// of lookups in the process table is limited, so a busy mount does
+// not spend its time reading /proc. It is only implemented on Linux;
+// elsewhere, names are always empty.
+type ProcessResolver struct {
+	ttl  time.Duration
+	rate int
+
+	// read looks up a process; replaced in tests.
+	read func(pid uint32) (ProcessInfo, error)
+
+	mu    sync.Mutex
+	cache map[uint32]cachedProcess
+
+	// Start of the current one second window, and lookups done
+	// in it.
+	window  time.Time
+	lookups int
+}
+
+type cachedProcess struct {
+	info    ProcessInfo
+	expires time.Time
+}
+
+// maxCachedProcesses bounds the cache; beyond it, expired entries are
+// dropped.
+const maxCachedProcesses = 1024
+
+// NewProcessResolver returns a resolver that keeps names for ttl,
+// and looks up at most rate processes per second. Pids that cannot
+// be looked up because of the rate limit are reported without a
+// name. Zero values select 10 seconds and 100 lookups.
+func NewProcessResolver(ttl time.Duration, rate int) *ProcessResolver {
+	if ttl <= 0 {
+		ttl = 10 * time.Second
+	}
+	if rate <= 0 {
+		rate = 100
+	}
+	return &ProcessResolver{
+		ttl:   ttl,
+		rate:  rate,
+		read:  readProcessInfo,
+		cache: map[uint32]cachedProcess{},
+	}
+}
+
+// Lookup returns what is known about process pid. Pid 0, which the
+// kernel uses for requests that no process made, has no name.
+func (r *ProcessResolver) Lookup(pid uint32) ProcessInfo {
+	if pid == 0 {
+		return ProcessInfo{}
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.cache[pid]; ok && now.Before(c.expires) {
+		return c.info
+	}
+
+	if now.Sub(r.window) >= time.Second {
+		r.window = now
+		r.lookups = 0
+	}
+	if r.lookups >= r.rate {
+		return ProcessInfo{Pid: pid}
+	}
+	r.lookups++
+
+	info, err := r.read(pid)
+	if err != nil {
+		// The process may have exited already; remember that
+		// too, so its requests do not cost a lookup each.
+		info = ProcessInfo{Pid: pid}
+	}
+	if len(r.cache) >= maxCachedProcesses {
+		for k, c := range r.cache {
+			if !now.Before(c.expires) {
+				delete(r.cache, k)
+			}
+		}
+	}
+	if len(r.cache) < maxCachedProcesses {
+		r.cache[pid] = cachedProcess{info, now.Add(r.ttl)}
+	}
+	return info
+}
@@ -4,6 +4,6 @@
 
 package fuse
 
-func (a *Attr) String() string {
-	return Print((*Attr)(a))
+func readProcessInfo(pid uint32) (ProcessInfo, error) {
+	return ProcessInfo{Pid: pid}, nil
 }
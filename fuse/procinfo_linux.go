@@ -0,0 +1,27 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func readProcessInfo(pid uint32) (ProcessInfo, error) {
+	dir := fmt.Sprintf("/proc/%d/", pid)
+	comm, err := os.ReadFile(dir + "comm")
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	info := ProcessInfo{
+		Pid:  pid,
+		Name: strings.TrimSuffix(string(comm), "\n"),
+	}
+	if cmdline, err := os.ReadFile(dir + "cmdline"); err == nil && len(cmdline) > 0 {
+		info.Cmdline = strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	}
+	return info, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestProcessResolverCache(t *testing.T) {
+	r := NewProcessResolver(time.Hour, 2)
+	reads := 0
+	r.read = func(pid uint32) (ProcessInfo, error) {
+		reads++
+		return ProcessInfo{Pid: pid, Name: fmt.Sprintf("p%d", pid)}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := r.Lookup(1).String(); got != "pid 1 (p1)" {
+			t.Errorf("Lookup(1): got %q", got)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("got %d reads, want 1", reads)
+	}
+	r.Lookup(2)
+
+	// Over the rate limit, names are unknown, and not cached.
+	if got := r.Lookup(3); got.Name != "" || got.Pid != 3 {
+		t.Errorf("Lookup(3) over the limit: got %v", got)
+	}
+	r.window = r.window.Add(-time.Second)
+	if got := r.Lookup(3); got.Name != "p3" {
+		t.Errorf("Lookup(3) in a new window: got %v", got)
+	}
+	if got := r.Lookup(0); got.String() != "pid 0" {
+		t.Errorf("Lookup(0): got %v", got)
+	}
+}
+
+func TestProcessResolverSelf(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs /proc")
+	}
+	info := NewProcessResolver(0, 0).Lookup(uint32(os.Getpid()))
+	if info.Name == "" || len(info.Cmdline) == 0 || info.Cmdline[0] != os.Args[0] {
+		t.Errorf("got %+v, want this test", info)
+	}
+}
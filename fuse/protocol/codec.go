@@ -0,0 +1,314 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// The kernel uses host byte order.
+var byteOrder = binary.NativeEndian
+
+var (
+	inHeaderSize  = binary.Size(fuse.InHeader{})
+	outHeaderSize = binary.Size(fuse.OutHeader{})
+)
+
+// Request is a decoded request, as sent by the kernel.
+type Request struct {
+	Header fuse.InHeader
+
+	// In points to the opcode's input struct, eg. *fuse.ReadIn,
+	// or is nil for opcodes that only have a header. Its
+	// embedded InHeader is ignored on marshaling, and equals
+	// Header after unmarshaling.
+	In interface{}
+
+	// File name arguments.
+	Names []string
+
+	// Binary payload, for WRITE and SETXATTR.
+	Data []byte
+}
+
+// Reply is a decoded reply, as sent by the server.
+type Reply struct {
+	// Header.Status holds a negated errno. Header.Length is
+	// computed on marshaling.
+	Header fuse.OutHeader
+
+	// Out points to the opcode's output struct, eg.
+	// *fuse.EntryOut, or is nil if the reply has none.
+	Out interface{}
+
+	// Flat data, eg. for READ, READDIR and READLINK.
+	Data []byte
+}
+
+func lookup(op int32, minor uint32) (*opInfo, error) {
+	if minor < MINIMUM_MINOR_VERSION || minor > MAXIMUM_MINOR_VERSION {
+		return nil, fmt.Errorf("unsupported protocol version %d.%d", KERNEL_VERSION, minor)
+	}
+	info := opInfos[op]
+	if info == nil {
+		return nil, fmt.Errorf("unknown opcode %d", op)
+	}
+	if minor < info.minMinor {
+		return nil, fmt.Errorf("%s needs protocol version %d.%d, have %d.%d",
+			info.name, KERNEL_VERSION, info.minMinor, KERNEL_VERSION, minor)
+	}
+	return info, nil
+}
+
+// checkType returns an error unless v has the type that newFunc
+// returns.
+func checkType(what string, v interface{}, newFunc func() interface{}) error {
+	if newFunc == nil {
+		if v != nil {
+			return fmt.Errorf("%s: got %T, want nil", what, v)
+		}
+		return nil
+	}
+	want := newFunc()
+	if reflect.TypeOf(v) != reflect.TypeOf(want) {
+		return fmt.Errorf("%s: got %T, want %T", what, v, want)
+	}
+	return nil
+}
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	return binary.Write(buf, byteOrder, v)
+}
+
+func decode(data []byte, v interface{}) error {
+	return binary.Read(bytes.NewReader(data), byteOrder, v)
+}
+
+// MarshalRequest encodes req as the kernel would for protocol
+// version 7.minor. The length, and the header embedded in req.In
+// are filled in.
+func MarshalRequest(req *Request, minor uint32) ([]byte, error) {
+	info, err := lookup(req.Header.Opcode, minor)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkType(info.name, req.In, info.in); err != nil {
+		return nil, err
+	}
+	if len(req.Names) != info.names {
+		return nil, fmt.Errorf("%s: got %d names, want %d", info.name, len(req.Names), info.names)
+	}
+	if !info.data && len(req.Data) > 0 {
+		return nil, fmt.Errorf("%s: unexpected data", info.name)
+	}
+
+	var tail bytes.Buffer
+	for _, n := range req.Names {
+		if bytes.IndexByte([]byte(n), 0) >= 0 {
+			return nil, fmt.Errorf("%s: name %q contains NUL", info.name, n)
+		}
+		tail.WriteString(n)
+		tail.WriteByte(0)
+	}
+	tail.Write(req.Data)
+
+	header := req.Header
+	var in interface{} = &header
+	if req.In != nil {
+		// Copy, so the caller's struct is left alone.
+		v := reflect.New(reflect.TypeOf(req.In).Elem())
+		v.Elem().Set(reflect.ValueOf(req.In).Elem())
+		in = v.Interface()
+	}
+	header.Length = uint32(binary.Size(in) + tail.Len())
+	if req.In != nil {
+		reflect.ValueOf(in).Elem().FieldByName("InHeader").Set(reflect.ValueOf(header))
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, in); err != nil {
+		return nil, err
+	}
+	buf.Write(tail.Bytes())
+	return buf.Bytes(), nil
+}
+
+// UnmarshalRequest decodes a request for protocol version 7.minor.
+func UnmarshalRequest(msg []byte, minor uint32) (*Request, error) {
+	if len(msg) < inHeaderSize {
+		return nil, fmt.Errorf("short request: %d bytes", len(msg))
+	}
+	req := &Request{}
+	if err := decode(msg, &req.Header); err != nil {
+		return nil, err
+	}
+	if int(req.Header.Length) != len(msg) {
+		return nil, fmt.Errorf("length %d does not match message size %d", req.Header.Length, len(msg))
+	}
+	info, err := lookup(req.Header.Opcode, minor)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := msg[inHeaderSize:]
+	if info.in != nil {
+		req.In = info.in()
+		size := binary.Size(req.In)
+		if len(msg) < size {
+			return nil, fmt.Errorf("%s: short request: %d bytes, want %d", info.name, len(msg), size)
+		}
+		if err := decode(msg, req.In); err != nil {
+			return nil, err
+		}
+		rest = msg[size:]
+	}
+
+	for i := 0; i < info.names; i++ {
+		idx := bytes.IndexByte(rest, 0)
+		if idx < 0 {
+			return nil, fmt.Errorf("%s: unterminated name", info.name)
+		}
+		req.Names = append(req.Names, string(rest[:idx]))
+		rest = rest[idx+1:]
+	}
+
+	if info.data {
+		if len(rest) > 0 {
+			req.Data = rest
+		}
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("%s: %d trailing bytes", info.name, len(rest))
+	}
+	return req, nil
+}
+
+// replyLayout returns the struct constructor and whether flat data
+// is allowed for the reply to req.
+func replyLayout(info *opInfo, req *Request) (out func() interface{}, flat bool) {
+	switch req.Header.Opcode {
+	case OP_GETXATTR, OP_LISTXATTR:
+		// A zero size asks for the size of the data
+		// (GetXAttrOut), otherwise the data itself is
+		// returned.
+		in, ok := req.In.(*fuse.GetXAttrIn)
+		if ok && in.Size != 0 {
+			return nil, true
+		}
+		return func() interface{} { return &fuse.GetXAttrOut{} }, false
+	}
+	return info.out, info.flatOut
+}
+
+// outSize returns the size of the reply struct for the given
+// version, which may be shorter than the Go struct.
+func outSize(op int32, out interface{}, minor uint32) int {
+	if op == OP_INIT && minor <= 22 {
+		// v8-v22 don't have TimeGran and further fields.
+		return 24
+	}
+	return binary.Size(out)
+}
+
+// MarshalReply encodes the server's reply to req for protocol
+// version 7.minor. The length is filled in. Replies with a non-zero
+// status only consist of the header.
+func MarshalReply(req *Request, reply *Reply, minor uint32) ([]byte, error) {
+	info, err := lookup(req.Header.Opcode, minor)
+	if err != nil {
+		return nil, err
+	}
+	if info.noReply {
+		return nil, fmt.Errorf("%s has no reply", info.name)
+	}
+
+	var body bytes.Buffer
+	if reply.Header.Status == 0 {
+		newOut, flat := replyLayout(info, req)
+		if err := checkType(info.name, reply.Out, newOut); err != nil {
+			return nil, err
+		}
+		if !flat && len(reply.Data) > 0 {
+			return nil, fmt.Errorf("%s: unexpected data", info.name)
+		}
+		if reply.Out != nil {
+			if err := encode(&body, reply.Out); err != nil {
+				return nil, err
+			}
+			body.Truncate(outSize(req.Header.Opcode, reply.Out, minor))
+		}
+		body.Write(reply.Data)
+	} else if reply.Out != nil || len(reply.Data) > 0 {
+		return nil, fmt.Errorf("%s: error reply with content", info.name)
+	}
+
+	header := reply.Header
+	header.Length = uint32(outHeaderSize + body.Len())
+
+	var buf bytes.Buffer
+	if err := encode(&buf, &header); err != nil {
+		return nil, err
+	}
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// UnmarshalReply decodes the server's reply to req for protocol
+// version 7.minor.
+func UnmarshalReply(req *Request, msg []byte, minor uint32) (*Reply, error) {
+	info, err := lookup(req.Header.Opcode, minor)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) < outHeaderSize {
+		return nil, fmt.Errorf("short reply: %d bytes", len(msg))
+	}
+
+	reply := &Reply{}
+	if err := decode(msg, &reply.Header); err != nil {
+		return nil, err
+	}
+	if int(reply.Header.Length) != len(msg) {
+		return nil, fmt.Errorf("length %d does not match message size %d", reply.Header.Length, len(msg))
+	}
+
+	rest := msg[outHeaderSize:]
+	if reply.Header.Status != 0 {
+		if len(rest) > 0 {
+			return nil, fmt.Errorf("%s: error reply with %d bytes", info.name, len(rest))
+		}
+		return reply, nil
+	}
+
+	newOut, flat := replyLayout(info, req)
+	if newOut != nil {
+		reply.Out = newOut()
+		size := outSize(req.Header.Opcode, reply.Out, minor)
+		if len(rest) < size {
+			return nil, fmt.Errorf("%s: short reply: %d bytes, want %d", info.name, len(rest), size)
+		}
+		// Older versions may send a prefix of the struct;
+		// the remaining fields stay zero.
+		padded := make([]byte, binary.Size(reply.Out))
+		copy(padded, rest[:size])
+		if err := decode(padded, reply.Out); err != nil {
+			return nil, err
+		}
+		rest = rest[size:]
+	}
+
+	if flat {
+		if len(rest) > 0 {
+			reply.Data = rest
+		}
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("%s: %d trailing bytes", info.name, len(rest))
+	}
+	return reply, nil
+}
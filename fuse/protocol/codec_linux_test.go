@@ -0,0 +1,387 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/")
+
+// Minor versions that have golden files.
+var goldenMinors = []uint32{12, 22, 23}
+
+type codecCase struct {
+	name  string
+	req   *Request
+	reply *Reply
+}
+
+func header(op int32) fuse.InHeader {
+	return fuse.InHeader{
+		Opcode: op,
+		Unique: 17,
+		NodeId: 5,
+		Context: fuse.Context{
+			Owner: fuse.Owner{Uid: 1000, Gid: 100},
+			Pid:   4242,
+		},
+	}
+}
+
+func ok(out interface{}, data []byte) *Reply {
+	return &Reply{Header: fuse.OutHeader{Unique: 17}, Out: out, Data: data}
+}
+
+func testAttr() fuse.Attr {
+	return fuse.Attr{
+		Ino:       5,
+		Size:      1234,
+		Blocks:    8,
+		Atime:     1500000000,
+		Mtime:     1500000001,
+		Ctime:     1500000002,
+		Atimensec: 3,
+		Mtimensec: 4,
+		Ctimensec: 5,
+		Mode:      fuse.S_IFREG | 0644,
+		Nlink:     1,
+		Owner:     fuse.Owner{Uid: 1000, Gid: 100},
+		Blksize:   4096,
+	}
+}
+
+func testEntry() *fuse.EntryOut {
+	return &fuse.EntryOut{
+		NodeId:         6,
+		Generation:     1,
+		EntryValid:     1,
+		AttrValid:      2,
+		EntryValidNsec: 500,
+		AttrValidNsec:  600,
+		Attr:           testAttr(),
+	}
+}
+
+func codecCases() []codecCase {
+	return []codecCase{
+		{"init",
+			&Request{Header: header(OP_INIT), In: &fuse.InitIn{
+				Major: 7, Minor: 23, MaxReadAhead: 131072, Flags: fuse.CAP_ASYNC_READ | fuse.CAP_BIG_WRITES}},
+			ok(&fuse.InitOut{Major: 7, Minor: 23, MaxReadAhead: 131072, Flags: fuse.CAP_BIG_WRITES,
+				MaxBackground: 12, CongestionThreshold: 9, MaxWrite: 65536, TimeGran: 1}, nil)},
+		{"lookup",
+			&Request{Header: header(OP_LOOKUP), Names: []string{"file.txt"}},
+			ok(testEntry(), nil)},
+		{"lookup-enoent",
+			&Request{Header: header(OP_LOOKUP), Names: []string{"missing"}},
+			&Reply{Header: fuse.OutHeader{Unique: 17, Status: -int32(fuse.ENOENT)}}},
+		{"forget",
+			&Request{Header: header(OP_FORGET), In: &fuse.ForgetIn{Nlookup: 3}},
+			nil},
+		{"getattr",
+			&Request{Header: header(OP_GETATTR), In: &fuse.GetAttrIn{}},
+			ok(&fuse.AttrOut{AttrValid: 1, AttrValidNsec: 2, Attr: testAttr()}, nil)},
+		{"symlink",
+			&Request{Header: header(OP_SYMLINK), Names: []string{"link", "target"}},
+			ok(testEntry(), nil)},
+		{"readlink",
+			&Request{Header: header(OP_READLINK)},
+			ok(nil, []byte("target"))},
+		{"rename",
+			&Request{Header: header(OP_RENAME), In: &fuse.RenameIn{Newdir: 7}, Names: []string{"a", "b"}},
+			ok(nil, nil)},
+		{"create",
+			&Request{Header: header(OP_CREATE), In: &fuse.CreateIn{Flags: 0101, Mode: 0644, Umask: 022},
+				Names: []string{"new"}},
+			ok(&fuse.CreateOut{EntryOut: *testEntry(), OpenOut: fuse.OpenOut{Fh: 9, OpenFlags: fuse.FOPEN_KEEP_CACHE}}, nil)},
+		{"read",
+			&Request{Header: header(OP_READ), In: &fuse.ReadIn{Fh: 9, Offset: 4096, Size: 5}},
+			ok(nil, []byte("hello"))},
+		{"write",
+			&Request{Header: header(OP_WRITE), In: &fuse.WriteIn{Fh: 9, Offset: 10, Size: 3}, Data: []byte("abc")},
+			ok(&fuse.WriteOut{Size: 3}, nil)},
+		{"setxattr",
+			&Request{Header: header(OP_SETXATTR), In: &fuse.SetXAttrIn{Size: 3}, Names: []string{"user.a"},
+				Data: []byte{1, 0, 2}},
+			ok(nil, nil)},
+		{"getxattr-size",
+			&Request{Header: header(OP_GETXATTR), In: &fuse.GetXAttrIn{}, Names: []string{"user.a"}},
+			ok(&fuse.GetXAttrOut{Size: 3}, nil)},
+		{"getxattr-data",
+			&Request{Header: header(OP_GETXATTR), In: &fuse.GetXAttrIn{Size: 64}, Names: []string{"user.a"}},
+			ok(nil, []byte{1, 0, 2})},
+		{"readdir",
+			&Request{Header: header(OP_READDIR), In: &fuse.ReadIn{Fh: 2, Size: 4096}},
+			ok(nil, MarshalDirents([]Dirent{
+				{Ino: 1, Off: 1, Type: fuse.S_IFDIR >> 12, Name: "."},
+				{Ino: 6, Off: 2, Type: fuse.S_IFREG >> 12, Name: "file.txt"},
+			}))},
+		{"statfs",
+			&Request{Header: header(OP_STATFS)},
+			ok(&fuse.StatfsOut{Blocks: 100, Bfree: 50, Bavail: 40, Files: 10, Ffree: 5, Bsize: 4096, NameLen: 255}, nil)},
+		{"fallocate",
+			&Request{Header: header(OP_FALLOCATE), In: &fuse.FallocateIn{Fh: 9, Length: 100}},
+			ok(nil, nil)},
+	}
+}
+
+func goldenFile(minor uint32) string {
+	return filepath.Join("testdata", fmt.Sprintf("golden-7.%d.txt", minor))
+}
+
+// readGolden reads lines of "<name> <hex bytes>".
+func readGolden(t *testing.T, minor uint32) map[string][]byte {
+	f, err := os.Open(goldenFile(minor))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	result := map[string][]byte{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("malformed golden line %q", line)
+		}
+		b, err := hex.DecodeString(fields[1])
+		if err != nil {
+			t.Fatalf("DecodeString(%q): %v", fields[0], err)
+		}
+		result[fields[0]] = b
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	return result
+}
+
+func writeGolden(t *testing.T, minor uint32, golden map[string][]byte) {
+	var names []string
+	for n := range golden {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	content := fmt.Sprintf("# FUSE protocol 7.%d messages, little-endian. Regenerate with\n# go test -run TestGolden -update\n", minor)
+	for _, n := range names {
+		content += fmt.Sprintf("%s %x\n", n, golden[n])
+	}
+	if err := os.WriteFile(goldenFile(minor), []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func littleEndian() bool {
+	return binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+}
+
+// fillHeader returns req with the embedded header set, as decoding
+// produces it.
+func fillHeader(req *Request, size int) *Request {
+	r := *req
+	r.Header.Length = uint32(size)
+	if r.In != nil {
+		v := reflect.New(reflect.TypeOf(r.In).Elem())
+		v.Elem().Set(reflect.ValueOf(r.In).Elem())
+		v.Elem().FieldByName("InHeader").Set(reflect.ValueOf(r.Header))
+		r.In = v.Interface()
+	}
+	return &r
+}
+
+func TestGolden(t *testing.T) {
+	if !littleEndian() {
+		t.Skip("golden files are little-endian")
+	}
+	for _, minor := range goldenMinors {
+		golden := map[string][]byte{}
+		if !*update {
+			golden = readGolden(t, minor)
+		}
+
+		for _, c := range codecCases() {
+			if info := opInfos[c.req.Header.Opcode]; minor < info.minMinor {
+				if _, err := MarshalRequest(c.req, minor); err == nil {
+					t.Errorf("7.%d %s: MarshalRequest succeeded for unsupported opcode", minor, c.name)
+				}
+				continue
+			}
+
+			reqBytes, err := MarshalRequest(c.req, minor)
+			if err != nil {
+				t.Fatalf("7.%d %s: MarshalRequest: %v", minor, c.name, err)
+			}
+			msgs := map[string][]byte{c.name + ".request": reqBytes}
+
+			if c.reply != nil {
+				replyBytes, err := MarshalReply(c.req, c.reply, minor)
+				if err != nil {
+					t.Fatalf("7.%d %s: MarshalReply: %v", minor, c.name, err)
+				}
+				msgs[c.name+".reply"] = replyBytes
+			}
+
+			for name, got := range msgs {
+				if *update {
+					golden[name] = got
+					continue
+				}
+				want, ok := golden[name]
+				if !ok {
+					t.Errorf("7.%d: no golden bytes for %s", minor, name)
+				} else if string(got) != string(want) {
+					t.Errorf("7.%d %s: got\n%x\nwant\n%x", minor, name, got, want)
+				}
+			}
+
+			// Decoding the golden bytes must give back the input.
+			decReq, err := UnmarshalRequest(reqBytes, minor)
+			if err != nil {
+				t.Fatalf("7.%d %s: UnmarshalRequest: %v", minor, c.name, err)
+			}
+			if want := fillHeader(c.req, len(reqBytes)); !reflect.DeepEqual(decReq, want) {
+				t.Errorf("7.%d %s: request roundtrip: got %v, want %v", minor, c.name, decReq, want)
+			}
+
+			if c.reply == nil {
+				continue
+			}
+			replyBytes := msgs[c.name+".reply"]
+			decReply, err := UnmarshalReply(c.req, replyBytes, minor)
+			if err != nil {
+				t.Fatalf("7.%d %s: UnmarshalReply: %v", minor, c.name, err)
+			}
+			want := *c.reply
+			want.Header.Length = uint32(len(replyBytes))
+			if init, ok := want.Out.(*fuse.InitOut); ok && minor <= 22 {
+				short := *init
+				short.TimeGran = 0
+				want.Out = &short
+			}
+			if !reflect.DeepEqual(decReply, &want) {
+				t.Errorf("7.%d %s: reply roundtrip: got %v, want %v", minor, c.name, decReply, &want)
+			}
+		}
+
+		if *update {
+			writeGolden(t, minor, golden)
+		}
+	}
+}
+
+// The codec uses encoding/binary, which does not know about
+// alignment padding, so the structs must not have any.
+func TestSizes(t *testing.T) {
+	for op, info := range opInfos {
+		for _, f := range []func() interface{}{info.in, info.out} {
+			if f == nil {
+				continue
+			}
+			v := f()
+			if got, want := binary.Size(v), int(reflect.TypeOf(v).Elem().Size()); got != want {
+				t.Errorf("%s: %T: binary size %d, memory size %d", OpcodeName(op), v, got, want)
+			}
+		}
+	}
+	if got, want := direntSize, int(unsafe.Sizeof(rawDirent{})); got != want {
+		t.Errorf("dirent: binary size %d, memory size %d", got, want)
+	}
+}
+
+func TestUnmarshalRequestErrors(t *testing.T) {
+	lookup, _ := MarshalRequest(&Request{Header: header(OP_LOOKUP), Names: []string{"a"}}, 23)
+	read, _ := MarshalRequest(&Request{Header: header(OP_READ), In: &fuse.ReadIn{}}, 23)
+	fallocate, _ := MarshalRequest(&Request{Header: header(OP_FALLOCATE), In: &fuse.FallocateIn{}}, 23)
+
+	unterminated := append([]byte{}, lookup...)
+	unterminated[len(unterminated)-1] = 'x'
+
+	badLength := append([]byte{}, read...)
+	badLength[0]++
+
+	// The length is consistent, so only the extra byte is at fault.
+	trailing := append(append([]byte{}, read...), 0)
+	binary.NativeEndian.PutUint32(trailing, uint32(len(trailing)))
+
+	for name, c := range map[string]struct {
+		msg   []byte
+		minor uint32
+	}{
+		"empty":          {nil, 23},
+		"truncated":      {read[:len(read)-1], 23},
+		"bad length":     {badLength, 23},
+		"unterminated":   {unterminated, 23},
+		"trailing":       {trailing, 23},
+		"too old":        {fallocate, 18},
+		"bad minor":      {read, 11},
+		"unknown opcode": {withOpcode(read, 99), 23},
+	} {
+		if _, err := UnmarshalRequest(c.msg, c.minor); err == nil {
+			t.Errorf("%s: UnmarshalRequest succeeded", name)
+		}
+	}
+}
+
+func withOpcode(msg []byte, op int32) []byte {
+	msg = append([]byte{}, msg...)
+	binary.NativeEndian.PutUint32(msg[4:], uint32(op))
+	return msg
+}
+
+func TestMarshalRequestErrors(t *testing.T) {
+	for name, req := range map[string]*Request{
+		"wrong struct": {Header: header(OP_READ), In: &fuse.WriteIn{}},
+		"missing name": {Header: header(OP_LOOKUP)},
+		"nul in name":  {Header: header(OP_LOOKUP), Names: []string{"a\x00b"}},
+		"stray data":   {Header: header(OP_READ), In: &fuse.ReadIn{}, Data: []byte{1}},
+		"unknown":      {Header: header(99)},
+	} {
+		if _, err := MarshalRequest(req, 23); err == nil {
+			t.Errorf("%s: MarshalRequest succeeded", name)
+		}
+	}
+}
+
+func TestDirents(t *testing.T) {
+	in := []Dirent{
+		{Ino: 1, Off: 1, Type: 4, Name: "."},
+		{Ino: 2, Off: 2, Type: 8, Name: "exactly8"},
+		{Ino: 3, Off: 3, Type: 8, Name: "a somewhat longer name"},
+	}
+	data := MarshalDirents(in)
+	if len(data)%8 != 0 {
+		t.Errorf("dirents not padded: %d bytes", len(data))
+	}
+	out, err := UnmarshalDirents(data)
+	if err != nil {
+		t.Fatalf("UnmarshalDirents: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %v, want %v", out, in)
+	}
+
+	if _, err := UnmarshalDirents(data[:len(data)-30]); err == nil {
+		t.Errorf("UnmarshalDirents succeeded on truncated input")
+	}
+}
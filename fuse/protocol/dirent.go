@@ -0,0 +1,114 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Dirent is one entry of a READDIR reply. READDIRPLUS entries are
+// prefixed with a fuse.EntryOut.
+type Dirent struct {
+	Ino  uint64
+	Off  uint64
+	Type uint32
+	Name string
+}
+
+type rawDirent struct {
+	Ino     uint64
+	Off     uint64
+	NameLen uint32
+	Typ     uint32
+}
+
+var direntSize = binary.Size(rawDirent{})
+
+// MarshalDirents encodes entries as the flat data of a READDIR reply.
+func MarshalDirents(entries []Dirent) []byte {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		binary.Write(&buf, byteOrder, &rawDirent{
+			Ino:     e.Ino,
+			Off:     e.Off,
+			NameLen: uint32(len(e.Name)),
+			Typ:     e.Type,
+		})
+		buf.WriteString(e.Name)
+		buf.Write(make([]byte, (8-len(e.Name)&7)&7))
+	}
+	return buf.Bytes()
+}
+
+// UnmarshalDirents decodes the flat data of a READDIR reply.
+func UnmarshalDirents(data []byte) ([]Dirent, error) {
+	var result []Dirent
+	for len(data) > 0 {
+		var raw rawDirent
+		if len(data) < direntSize {
+			return nil, fmt.Errorf("short dirent: %d bytes", len(data))
+		}
+		if err := decode(data, &raw); err != nil {
+			return nil, err
+		}
+		end := direntSize + int(raw.NameLen)
+		if end > len(data) {
+			return nil, fmt.Errorf("dirent name overflows: %d bytes, have %d", end, len(data))
+		}
+		result = append(result, Dirent{
+			Ino:  raw.Ino,
+			Off:  raw.Off,
+			Type: raw.Typ,
+			Name: string(data[direntSize:end]),
+		})
+
+		padded := (end + 7) &^ 7
+		if padded > len(data) {
+			padded = len(data)
+		}
+		data = data[padded:]
+	}
+	return result, nil
+}
+
+// UnmarshalDirentsPlus decodes the flat data of a READDIRPLUS reply.
+func UnmarshalDirentsPlus(data []byte) ([]fuse.EntryOut, []Dirent, error) {
+	entrySize := binary.Size(fuse.EntryOut{})
+	var entries []fuse.EntryOut
+	var dirents []Dirent
+	for len(data) > 0 {
+		var e fuse.EntryOut
+		if len(data) < entrySize {
+			return nil, nil, fmt.Errorf("short entry: %d bytes", len(data))
+		}
+		if err := decode(data, &e); err != nil {
+			return nil, nil, err
+		}
+		data = data[entrySize:]
+
+		// Decode a single dirent by bounding the input.
+		var raw rawDirent
+		if len(data) < direntSize {
+			return nil, nil, fmt.Errorf("short dirent: %d bytes", len(data))
+		}
+		decode(data, &raw)
+		end := (direntSize + int(raw.NameLen) + 7) &^ 7
+		if end > len(data) {
+			end = len(data)
+		}
+		ds, err := UnmarshalDirents(data[:end])
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, e)
+		dirents = append(dirents, ds...)
+		data = data[end:]
+	}
+	return entries, dirents, nil
+}
@@ -0,0 +1,20 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package protocol is a reference codec for FUSE messages, independent
+// of a mounted file system: Marshal and Unmarshal functions encode
+// and decode each opcode with its version, and golden byte fixtures for each
+// supported protocol minor version (see testdata/) pin it down. It is
+// meant for tests, tracing tools and alternative transports.
+//
+// The server in the fuse package decodes requests in place, without
+// copying, so it does not call these functions. Both share one
+// definition of the wire format, though: the structs and names per
+// opcode come from the server's table, through fuse.LookupOpcode,
+// and the fuse tests check that the server accepts the golden
+// requests from testdata/.
+//
+// Messages use host byte order, as the kernel does. The fixtures have
+// the linux layout of the structs.
+package protocol
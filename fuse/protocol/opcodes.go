@@ -0,0 +1,172 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Opcodes, as defined in the kernel's fuse.h.
+const (
+	OP_LOOKUP      = int32(1)
+	OP_FORGET      = int32(2)
+	OP_GETATTR     = int32(3)
+	OP_SETATTR     = int32(4)
+	OP_READLINK    = int32(5)
+	OP_SYMLINK     = int32(6)
+	OP_MKNOD       = int32(8)
+	OP_MKDIR       = int32(9)
+	OP_UNLINK      = int32(10)
+	OP_RMDIR       = int32(11)
+	OP_RENAME      = int32(12)
+	OP_LINK        = int32(13)
+	OP_OPEN        = int32(14)
+	OP_READ        = int32(15)
+	OP_WRITE       = int32(16)
+	OP_STATFS      = int32(17)
+	OP_RELEASE     = int32(18)
+	OP_FSYNC       = int32(20)
+	OP_SETXATTR    = int32(21)
+	OP_GETXATTR    = int32(22)
+	OP_LISTXATTR   = int32(23)
+	OP_REMOVEXATTR = int32(24)
+	OP_FLUSH       = int32(25)
+	OP_INIT        = int32(26)
+	OP_OPENDIR     = int32(27)
+	OP_READDIR     = int32(28)
+	OP_RELEASEDIR  = int32(29)
+	OP_FSYNCDIR    = int32(30)
+	OP_GETLK       = int32(31)
+	OP_SETLK       = int32(32)
+	OP_SETLKW      = int32(33)
+	OP_ACCESS      = int32(34)
+	OP_CREATE      = int32(35)
+	OP_INTERRUPT   = int32(36)
+	OP_BMAP        = int32(37)
+	OP_DESTROY     = int32(38)
+	OP_FALLOCATE   = int32(43) // protocol version 19.
+	OP_READDIRPLUS = int32(44) // protocol version 21.
+)
+
+// Protocol versions understood by this package.
+const (
+	KERNEL_VERSION        = 7
+	MINIMUM_MINOR_VERSION = 12
+	MAXIMUM_MINOR_VERSION = 23
+)
+
+// opInfo describes the wire layout of one opcode.
+type opInfo struct {
+	name string
+
+	// Oldest minor version that has this opcode.
+	minMinor uint32
+
+	// Returns a pointer to the fixed size input struct, which
+	// starts with a fuse.InHeader. If nil, the request is only
+	// a header.
+	in func() interface{}
+
+	// Number of NUL-terminated names after the input struct.
+	names int
+
+	// If set, binary data follows the names.
+	data bool
+
+	// Returns a pointer to the fixed size reply struct. If nil,
+	// the reply has no struct.
+	out func() interface{}
+
+	// If set, the reply carries flat data after the struct.
+	flatOut bool
+
+	// If set, the server does not reply.
+	noReply bool
+}
+
+// opExtras lists the opcodes this package covers, with what
+// fuse.LookupOpcode does not describe, as the server handles it in
+// code: the version an opcode appeared in, and whether data follows
+// the request or reply.
+var opExtras = map[int32]opInfo{
+	OP_LOOKUP:      {},
+	OP_FORGET:      {noReply: true},
+	OP_GETATTR:     {},
+	OP_SETATTR:     {},
+	OP_READLINK:    {flatOut: true},
+	OP_SYMLINK:     {},
+	OP_MKNOD:       {},
+	OP_MKDIR:       {},
+	OP_UNLINK:      {},
+	OP_RMDIR:       {},
+	OP_RENAME:      {},
+	OP_LINK:        {},
+	OP_OPEN:        {},
+	OP_READ:        {flatOut: true},
+	OP_WRITE:       {data: true},
+	OP_STATFS:      {},
+	OP_RELEASE:     {},
+	OP_FSYNC:       {},
+	OP_SETXATTR:    {data: true},
+	OP_GETXATTR:    {flatOut: true},
+	OP_LISTXATTR:   {flatOut: true},
+	OP_REMOVEXATTR: {},
+	OP_FLUSH:       {},
+	OP_INIT:        {},
+	OP_OPENDIR:     {},
+	OP_READDIR:     {flatOut: true},
+	OP_RELEASEDIR:  {},
+	OP_FSYNCDIR:    {},
+	OP_GETLK:       {},
+	OP_SETLK:       {},
+	OP_SETLKW:      {},
+	OP_ACCESS:      {},
+	OP_CREATE:      {},
+	OP_INTERRUPT:   {noReply: true},
+	OP_BMAP:        {},
+	OP_DESTROY:     {},
+	OP_FALLOCATE:   {minMinor: 19},
+	OP_READDIRPLUS: {minMinor: 21, flatOut: true},
+}
+
+// opInfos has the layouts of the opcodes in opExtras. The structs
+// and names come from the server's own table, so the two do not
+// drift.
+var opInfos = map[int32]*opInfo{}
+
+func init() {
+	for op, extra := range opExtras {
+		info := extra
+		l, ok := fuse.LookupOpcode(op)
+		if !ok {
+			panic(fmt.Sprintf("opcode %d is not handled by the server", op))
+		}
+		info.name = l.Name
+		info.names = l.Names
+		info.in = newFunc(l.In)
+		info.out = newFunc(l.Out)
+		opInfos[op] = &info
+	}
+}
+
+// newFunc returns a constructor for values of the pointer type t,
+// or nil if t is.
+func newFunc(t reflect.Type) func() interface{} {
+	if t == nil {
+		return nil
+	}
+	return func() interface{} { return reflect.New(t.Elem()).Interface() }
+}
+
+// OpcodeName returns the kernel name of an opcode, eg. "LOOKUP".
+func OpcodeName(op int32) string {
+	if info := opInfos[op]; info != nil {
+		return info.name
+	}
+	return "UNKNOWN"
+}
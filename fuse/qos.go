@@ -0,0 +1,98 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+)
+
+// RequestClass is the scheduling class of a request; see QoS.
+type RequestClass int
+
+const (
+	// ClassInteractive is for metadata operations that a user
+	// typically waits on, eg. LOOKUP, GETATTR and READDIR.
+	ClassInteractive RequestClass = iota
+
+	// ClassBulk is for data transfer, eg. READ and WRITE.
+	ClassBulk
+
+	// ClassBackground is for housekeeping that nobody waits for,
+	// eg. FORGET and RELEASE.
+	ClassBackground
+
+	numRequestClasses
+)
+
+func (c RequestClass) String() string {
+	switch c {
+	case ClassInteractive:
+		return "interactive"
+	case ClassBulk:
+		return "bulk"
+	case ClassBackground:
+		return "background"
+	}
+	return fmt.Sprintf("RequestClass(%d)", int(c))
+}
+
+// ClassifyRequest is the default classification for QoS.
+func ClassifyRequest(h *InHeader) RequestClass {
+	switch h.Opcode {
+	case _OP_READ, _OP_WRITE, _OP_FSYNC, _OP_FSYNCDIR, _OP_FALLOCATE:
+		return ClassBulk
+	case _OP_FORGET, _OP_BATCH_FORGET, _OP_RELEASE, _OP_RELEASEDIR:
+		return ClassBackground
+	}
+	return ClassInteractive
+}
+
+// QoS limits how many requests of each class the file system
+// handles at once, so that eg. a large copy cannot take all the
+// goroutines of a slow backend while someone waits for "ls". A
+// request over the limit waits before it is dispatched; requests of
+// the other classes go ahead. The kernel does not mark readahead
+// on the wire, so it is bulk, like other reads.
+type QoS struct {
+	// Maximum concurrent requests per class. 0 means no limit.
+	MaxInteractive int
+	MaxBulk        int
+	MaxBackground  int
+
+	// Classify assigns a class to each request. If nil,
+	// ClassifyRequest is used.
+	Classify func(*InHeader) RequestClass
+}
+
+// qosScheduler implements QoS with a semaphore per class.
+type qosScheduler struct {
+	classify func(*InHeader) RequestClass
+	slots    [numRequestClasses]chan struct{}
+}
+
+func newQoSScheduler(q *QoS) *qosScheduler {
+	s := &qosScheduler{classify: q.Classify}
+	if s.classify == nil {
+		s.classify = ClassifyRequest
+	}
+	for c, max := range []int{q.MaxInteractive, q.MaxBulk, q.MaxBackground} {
+		if max > 0 {
+			s.slots[c] = make(chan struct{}, max)
+		}
+	}
+	return s
+}
+
+// acquire waits for a slot in the class of h, and returns the
+// function that gives it back.
+func (s *qosScheduler) acquire(h *InHeader) func() {
+	c := s.classify(h)
+	if c < 0 || c >= numRequestClasses || s.slots[c] == nil {
+		return func() {}
+	}
+	slots := s.slots[c]
+	slots <- struct{}{}
+	return func() { <-slots }
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+// blockingReadFS holds READ in a gate.
+type blockingReadFS struct {
+	fuse.RawFileSystem
+	gate *gate
+}
+
+func (fs *blockingReadFS) Read(input *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
+	fs.gate.enter()
+	defer fs.gate.leave()
+	return fuse.ReadResultData(nil), fuse.OK
+}
+
+func (fs *blockingReadFS) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func TestQoS(t *testing.T) {
+	fs := &blockingReadFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		gate:          newGate(true),
+	}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{QoS: &fuse.QoS{MaxBulk: 2}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, code := k.Read(fuse.FUSE_ROOT_ID, 0, 0, 10); !code.Ok() {
+				t.Errorf("Read: %v", code)
+			}
+		}()
+	}
+
+	// Wait until the reads fill the bulk class.
+	fs.gate.waitRunning(t, 2)
+
+	// Metadata goes ahead of the queued reads.
+	if _, code := k.GetAttr(fuse.FUSE_ROOT_ID); !code.Ok() {
+		t.Errorf("GetAttr: %v", code)
+	}
+
+	fs.gate.open()
+	wg.Wait()
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := fs.gate.Max(); got != 2 {
+		t.Errorf("got %d concurrent reads, want 2", got)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyRequest(t *testing.T) {
+	for op, want := range map[int32]RequestClass{
+		_OP_LOOKUP:    ClassInteractive,
+		_OP_GETATTR:   ClassInteractive,
+		_OP_READDIR:   ClassInteractive,
+		_OP_READ:      ClassBulk,
+		_OP_WRITE:     ClassBulk,
+		_OP_FORGET:    ClassBackground,
+		_OP_RELEASE:   ClassBackground,
+		_OP_INTERRUPT: ClassInteractive,
+	} {
+		if got := ClassifyRequest(&InHeader{Opcode: op}); got != want {
+			t.Errorf("%s: got %v, want %v", operationName(op), got, want)
+		}
+	}
+}
+
+func TestQoSSchedulerLimits(t *testing.T) {
+	s := newQoSScheduler(&QoS{MaxBulk: 1})
+	read := &InHeader{Opcode: _OP_READ}
+
+	release := s.acquire(read)
+	acquired := make(chan struct{})
+	go func() {
+		s.acquire(read)()
+		close(acquired)
+	}()
+
+	// Other classes are not limited.
+	for i := 0; i < 10; i++ {
+		s.acquire(&InHeader{Opcode: _OP_GETATTR})
+	}
+
+	select {
+	case <-acquired:
+		t.Fatalf("second READ went over the limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+	release()
+	<-acquired
+}
+
+func TestQoSSchedulerClassify(t *testing.T) {
+	s := newQoSScheduler(&QoS{
+		MaxBackground: 1,
+		Classify:      func(*InHeader) RequestClass { return ClassBackground },
+	})
+	s.acquire(&InHeader{Opcode: _OP_GETATTR})
+	if len(s.slots[ClassBackground]) != 1 {
+		t.Errorf("custom Classify was not used")
+	}
+
+	// Out of range classes are not limited.
+	s = newQoSScheduler(&QoS{Classify: func(*InHeader) RequestClass { return 17 }})
+	s.acquire(&InHeader{})()
+}
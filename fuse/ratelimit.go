@@ -0,0 +1,185 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// RateLimit limits how many requests per second are dispatched, for
+// all callers together and for each uid, with token buckets. It
+// protects backends that cannot take much load from runaway clients,
+// eg. a misconfigured file indexer. Requests over the limit wait
+// before they are dispatched, or fail with EBUSY if Fail is set.
+type RateLimit struct {
+	// Requests per second, and how many may come in a burst,
+	// over all callers. A zero Rate means no global limit.
+	Rate  float64
+	Burst int
+
+	// The same, for each uid.
+	PerUidRate  float64
+	PerUidBurst int
+
+	// If set, requests over the limit fail with EBUSY instead of
+	// waiting.
+	Fail bool
+
+	// Exempt reports whether a request is never limited. If nil,
+	// requests of ClassBackground, like FORGET and RELEASE, are
+	// exempt, as they free resources and FORGET has no reply to
+	// carry an error. INIT, DESTROY and INTERRUPT always are.
+	Exempt func(*InHeader) bool
+}
+
+// tokenBucket holds up to burst tokens, and gains rate per second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// delay returns how long until a token is available.
+func (b *tokenBucket) delay() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimiter implements RateLimit.
+type rateLimiter struct {
+	opts  RateLimit
+	clock Clock
+
+	mu     sync.Mutex
+	global *tokenBucket
+	users  map[uint32]*tokenBucket
+}
+
+// Above this many per-uid buckets, full ones are dropped.
+const maxIdleBuckets = 1024
+
+func newRateLimiter(opts *RateLimit, clock Clock) *rateLimiter {
+	l := &rateLimiter{
+		opts:  *opts,
+		clock: clock,
+		users: map[uint32]*tokenBucket{},
+	}
+	if l.opts.Rate > 0 {
+		l.global = newTokenBucket(l.opts.Rate, l.opts.Burst, clock.Now())
+	}
+	return l
+}
+
+func (l *rateLimiter) exempt(h *InHeader) bool {
+	switch h.Opcode {
+	case _OP_INIT, _OP_DESTROY, _OP_INTERRUPT:
+		return true
+	}
+	if l.opts.Exempt != nil {
+		return l.opts.Exempt(h)
+	}
+	return ClassifyRequest(h) == ClassBackground
+}
+
+// admit takes a token for h from the global bucket and that of its
+// uid. If there is none, it returns EBUSY with Fail set, or waits
+// until the tokens it reserved are due.
+func (l *rateLimiter) admit(h *InHeader) Status {
+	if l.exempt(h) {
+		return OK
+	}
+	now := l.clock.Now()
+	l.mu.Lock()
+	buckets := make([]*tokenBucket, 0, 2)
+	if l.global != nil {
+		buckets = append(buckets, l.global)
+	}
+	if l.opts.PerUidRate > 0 {
+		buckets = append(buckets, l.user(h.Uid, now))
+	}
+	var wait time.Duration
+	for _, b := range buckets {
+		b.refill(now)
+		if d := b.delay(); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 && l.opts.Fail {
+		l.mu.Unlock()
+		return EBUSY
+	}
+	// Waiting requests take their token ahead of time, so the
+	// ones after them queue up behind.
+	for _, b := range buckets {
+		b.tokens--
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		<-clockAfter(l.clock, wait)
+	}
+	return OK
+}
+
+// admit applies the rate limit to req. It reads the header straight
+// from the input, as it runs before req takes its turn under
+// SerializeNodes and OrderForgets: a request waiting for a token
+// must not hold up the requests ordered after it. Requests for
+// disabled opcodes fail anyway, and are not limited.
+func (ms *Server) admit(req *request) Status {
+	if ms.limiter == nil || len(req.inputBuf) < int(unsafe.Sizeof(InHeader{})) {
+		return OK
+	}
+	h := (*InHeader)(unsafe.Pointer(&req.inputBuf[0]))
+	if _, ok := ms.disabled[h.Opcode]; ok {
+		return OK
+	}
+	return ms.limiter.admit(h)
+}
+
+// user returns the bucket of uid. Called with mu held.
+func (l *rateLimiter) user(uid uint32, now time.Time) *tokenBucket {
+	b := l.users[uid]
+	if b != nil {
+		return b
+	}
+	if len(l.users) >= maxIdleBuckets {
+		for u, o := range l.users {
+			if o.refill(now); o.tokens >= o.burst {
+				delete(l.users, u)
+			}
+		}
+	}
+	b = newTokenBucket(l.opts.PerUidRate, l.opts.PerUidBurst, now)
+	l.users[uid] = b
+	return b
+}
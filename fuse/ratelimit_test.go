@@ -0,0 +1,100 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitFail(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	l := newRateLimiter(&RateLimit{
+		Rate:        10,
+		Burst:       3,
+		PerUidRate:  1,
+		PerUidBurst: 2,
+		Fail:        true,
+	}, clock)
+
+	req := func(op int32, uid uint32) Status {
+		h := &InHeader{Opcode: op}
+		h.Uid = uid
+		return l.admit(h)
+	}
+
+	// uid 1 has a burst of 2.
+	for i, want := range []Status{OK, OK, EBUSY} {
+		if got := req(_OP_GETATTR, 1); got != want {
+			t.Errorf("uid 1, request %d: got %v, want %v", i, got, want)
+		}
+	}
+	// The global burst of 3 has one token left.
+	if got := req(_OP_GETATTR, 2); got != OK {
+		t.Errorf("uid 2: got %v", got)
+	}
+	if got := req(_OP_GETATTR, 3); got != EBUSY {
+		t.Errorf("uid 3: got %v, want EBUSY", got)
+	}
+	// Background requests are exempt.
+	if got := req(_OP_FORGET, 1); got != OK {
+		t.Errorf("FORGET: got %v", got)
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	if got := req(_OP_GETATTR, 1); got != OK {
+		t.Errorf("uid 1 after refill: got %v", got)
+	}
+	if got := req(_OP_GETATTR, 1); got != EBUSY {
+		t.Errorf("uid 1 after refill, twice: got %v, want EBUSY", got)
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	l := newRateLimiter(&RateLimit{Rate: 100, Burst: 1}, systemClock{})
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if got := l.admit(&InHeader{Opcode: _OP_LOOKUP}); got != OK {
+			t.Fatalf("admit: %v", got)
+		}
+	}
+	// The first is free, the others wait 10ms each.
+	if dt := time.Since(start); dt < 25*time.Millisecond {
+		t.Errorf("4 requests at 100/s took %v", dt)
+	}
+}
+
+// timerClock records the waits asked of it, and ends them at once.
+type timerClock struct {
+	fakeClock
+	waits []time.Duration
+}
+
+func (c *timerClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now.Add(d)
+	return ch
+}
+
+func TestRateLimitWaitClock(t *testing.T) {
+	clock := &timerClock{fakeClock: fakeClock{now: time.Unix(1000, 0)}}
+	l := newRateLimiter(&RateLimit{Rate: 10, Burst: 1}, clock)
+	for i := 0; i < 3; i++ {
+		if got := l.admit(&InHeader{Opcode: _OP_LOOKUP}); got != OK {
+			t.Fatalf("admit: %v", got)
+		}
+	}
+	// The second waits for one token, the third for two.
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+	if len(clock.waits) != len(want) {
+		t.Fatalf("got waits %v, want %v", clock.waits, want)
+	}
+	for i, w := range want {
+		if d := clock.waits[i] - w; d < -time.Millisecond || d > time.Millisecond {
+			t.Errorf("wait %d: got %v, want %v", i, clock.waits[i], w)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// RawHandler takes over an opcode from the library, so a file system
+// can support kernel opcodes that the library does not know yet, or
+// handle known ones in ways the RawFileSystem interface does not
+// allow. The request is passed on undecoded, and the reply is written
+// back as given.
+//
+// DisabledOps, RateLimit and QoS apply to taken over opcodes, and
+// replies go out like any other, eg. through the ReplyQueue.
+// Interceptors, SetReadOnly, ErrorLockdown, TrackRequests and
+// EnableInterrupts do not apply: they need the decoded request, or
+// know what an opcode changes.
+type RawHandler struct {
+	// Handle gets the request header and the bytes that follow
+	// it. It returns the reply, which is sent after the OutHeader.
+	// For an error status, the reply data is dropped.
+	Handle func(header *InHeader, input []byte) (out []byte, code Status)
+
+	// If set, no reply is sent, as for FORGET.
+	NoReply bool
+}
+
+// SetRawHandler routes requests for opcode to h, instead of the
+// library's own handling, or restores the latter if h is nil. It is
+// usually called from RawFileSystem.Init, but may be called while
+// the server is running; requests that were already read may still
+// be handled the old way.
+func (ms *Server) SetRawHandler(opcode int32, h *RawHandler) {
+	if opcode == _OP_INIT {
+		panic("SetRawHandler: cannot take over INIT")
+	}
+
+	// Copy on write, so readers need no lock.
+	ms.rawMu.Lock()
+	defer ms.rawMu.Unlock()
+	handlers := map[int32]*RawHandler{}
+	if old := ms.rawHandlers.Load(); old != nil {
+		for op, oh := range *old {
+			handlers[op] = oh
+		}
+	}
+	if h == nil {
+		delete(handlers, opcode)
+	} else {
+		handlers[opcode] = h
+	}
+	ms.rawHandlers.Store(&handlers)
+}
+
+// rawHandler returns the RawHandler for req, if there is one.
+func (ms *Server) rawHandler(req *request) *RawHandler {
+	handlers := ms.rawHandlers.Load()
+	if handlers == nil || len(*handlers) == 0 || len(req.inputBuf) < int(unsafe.Sizeof(InHeader{})) {
+		return nil
+	}
+	return (*handlers)[(*InHeader)(unsafe.Pointer(&req.inputBuf[0])).Opcode]
+}
+
+// handleRaw runs the RawHandler h for req, and sends the reply
+// through finish. limited is the verdict of the rate limit. See
+// RawHandler for the options that apply.
+func (ms *Server) handleRaw(req *request, h *RawHandler, limited Status) Status {
+	req.inHeader = (*InHeader)(unsafe.Pointer(&req.inputBuf[0]))
+	req.raw = h
+	input := req.inputBuf[unsafe.Sizeof(InHeader{}):]
+	if len(req.payload) > 0 {
+		input = WriteData{segs: append([][]byte{input}, req.payload...)}.Bytes(nil)
+	}
+	if ms.debug.Load() {
+		ms.opts.Logger.Printf("Dispatch %d: raw opcode %d, NodeId: %v, %d bytes",
+			req.inHeader.Unique, req.inHeader.Opcode, req.inHeader.NodeId, len(input))
+	}
+
+	if code, ok := ms.disabled[req.inHeader.Opcode]; ok {
+		req.status = code
+		return ms.finish(req)
+	}
+	req.status = limited
+	if req.status.Ok() {
+		var release func()
+		if ms.qos != nil {
+			release = ms.qos.acquire(req.inHeader)
+		}
+		ms.handlers.Add(1)
+		dispatching.add(req)
+		out, code := h.Handle(req.inHeader, input)
+		dispatching.remove(req)
+		ms.handlers.Add(-1)
+		if release != nil {
+			release()
+		}
+		req.status = code
+		if code.Ok() {
+			req.flatData = out
+		}
+	}
+	ms.logFailure(req)
+	return ms.finish(req)
+}
+
+func rawOutputDebug(req *request) string {
+	return fmt.Sprintf("Serialize %d: raw opcode %d code: %v %d bytes",
+		req.inHeader.Unique, req.inHeader.Opcode, req.status, len(req.flatData))
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/protocol"
+)
+
+// rawFS handles an opcode the library does not know, and overrides
+// STATFS.
+type rawFS struct {
+	fuse.RawFileSystem
+}
+
+const experimentalOpcode = 4000
+
+func (fs *rawFS) Init(s *fuse.Server) {
+	s.SetRawHandler(experimentalOpcode, &fuse.RawHandler{
+		Handle: func(h *fuse.InHeader, input []byte) ([]byte, fuse.Status) {
+			return append([]byte("echo:"), input...), fuse.OK
+		},
+	})
+	s.SetRawHandler(protocol.OP_STATFS, &fuse.RawHandler{
+		Handle: func(h *fuse.InHeader, input []byte) ([]byte, fuse.Status) {
+			return []byte("not a StatfsOut"), fuse.EROFS
+		},
+	})
+}
+
+func TestRawHandler(t *testing.T) {
+	k, err := fakekernel.New(&rawFS{fuse.NewDefaultRawFileSystem()}, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	data, code := k.Call(experimentalOpcode, fuse.FUSE_ROOT_ID, nil, 0, []byte("hi"))
+	if !code.Ok() || string(data) != "echo:hi" {
+		t.Errorf("experimental opcode: got %q, %v", data, code)
+	}
+	if _, code := k.StatFs(fuse.FUSE_ROOT_ID); code != fuse.EROFS {
+		t.Errorf("StatFs: got %v, want EROFS", code)
+	}
+	if _, code := k.GetAttr(fuse.FUSE_ROOT_ID); code != fuse.ENOSYS {
+		t.Errorf("GetAttr: got %v, want ENOSYS from the default file system", code)
+	}
+}
+
+func TestRawHandlerPolicies(t *testing.T) {
+	k, err := fakekernel.New(&rawFS{fuse.NewDefaultRawFileSystem()}, &fuse.MountOptions{
+		DisabledOps: map[string]fuse.Status{"STATFS": fuse.EPERM},
+		ReplyQueue:  4,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	// The reply goes through the queue.
+	data, code := k.Call(experimentalOpcode, fuse.FUSE_ROOT_ID, nil, 0, []byte("hi"))
+	if !code.Ok() || string(data) != "echo:hi" {
+		t.Errorf("experimental opcode: got %q, %v", data, code)
+	}
+	// DisabledOps wins over the raw handler.
+	if _, code := k.StatFs(fuse.FUSE_ROOT_ID); code != fuse.EPERM {
+		t.Errorf("StatFs: got %v, want EPERM", code)
+	}
+}
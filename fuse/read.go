@@ -30,6 +30,22 @@ func ReadResultData(b []byte) ReadResult {
 	return &readResultData{b}
 }
 
+// ReadAt fills buf with the data of r at off. It is for Read
+// methods backed by an io.ReaderAt, such as an *os.File or a
+// bytes.Reader: the data goes into the buffer passed to Read, so
+// nothing is allocated, and no more is read than the kernel asked
+// for. Reaching the end of r is not an error.
+func ReadAt(r io.ReaderAt, buf []byte, off int64) (ReadResult, Status) {
+	n, err := r.ReadAt(buf, off)
+	if err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return ReadResultData(buf[:n]), OK
+}
+
 func ReadResultFd(fd uintptr, off int64, sz int) ReadResult {
 	return &readResultFd{fd, off, sz}
 }
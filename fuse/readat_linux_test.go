@@ -0,0 +1,77 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+const readAtContent = "0123456789"
+
+// readAtFS serves readAtContent with fuse.ReadAt, and remembers the
+// size of the buffer it was last given. Node 2 returns all of its
+// content, whatever was asked for.
+type readAtFS struct {
+	fuse.RawFileSystem
+
+	mu     sync.Mutex
+	bufLen int
+}
+
+func (fs *readAtFS) lastBufLen() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.bufLen
+}
+
+func (fs *readAtFS) Read(input *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
+	fs.mu.Lock()
+	fs.bufLen = len(buf)
+	fs.mu.Unlock()
+	if input.NodeId == 2 {
+		return fuse.ReadResultData([]byte(readAtContent)), fuse.OK
+	}
+	return fuse.ReadAt(strings.NewReader(readAtContent), buf, int64(input.Offset))
+}
+
+func TestReadAt(t *testing.T) {
+	fs := &readAtFS{RawFileSystem: fuse.NewDefaultRawFileSystem()}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{Deterministic: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	for _, c := range []struct {
+		node      uint64
+		off       uint64
+		size      uint32
+		want      string
+		wantBufSz int
+	}{
+		{1, 2, 4, "2345", 4},
+		// A short read at the end is not an error.
+		{1, 8, 4, "89", 4},
+		{1, 20, 4, "", 4},
+		// Data past the size asked for is cut.
+		{2, 0, 3, "012", 3},
+	} {
+		data, code := k.Read(c.node, 0, c.off, c.size)
+		if !code.Ok() {
+			t.Fatalf("Read(%d, %d, %d): %v", c.node, c.off, c.size, code)
+		}
+		if string(data) != c.want {
+			t.Errorf("Read(%d, %d, %d): got %q, want %q", c.node, c.off, c.size, data, c.want)
+		}
+		if got := fs.lastBufLen(); got != c.wantBufSz {
+			t.Errorf("Read(%d, %d, %d): got buffer of %d bytes", c.node, c.off, c.size, got)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// pendingReplies holds the Unique of each request that is being
+// handled and not answered yet. A reply for any other Unique, eg. a
+// second reply to a request because of a bug in a file system or a
+// RawHandler, is refused: the kernel would take it for the reply to
+// a later request that reuses the id, or fail the connection.
+//
+// The ids are spread over shards, so requests that are handled in
+// parallel rarely wait for each other.
+type pendingReplies struct {
+	shards [pendingShards]pendingShard
+}
+
+const pendingShards = 32
+
+type pendingShard struct {
+	mu sync.Mutex
+	m  map[uint64]struct{}
+}
+
+func newPendingReplies() *pendingReplies {
+	p := &pendingReplies{}
+	for i := range p.shards {
+		p.shards[i].m = map[uint64]struct{}{}
+	}
+	return p
+}
+
+// shard returns the shard for unique. The kernel counts ids in
+// steps of 2, as the lowest bit marks interrupts.
+func (p *pendingReplies) shard(unique uint64) *pendingShard {
+	return &p.shards[(unique>>1)%pendingShards]
+}
+
+// inputUnique reads the Unique of a request straight from its input.
+func inputUnique(in []byte) uint64 {
+	if len(in) < int(unsafe.Sizeof(InHeader{})) {
+		return 0
+	}
+	return (*InHeader)(unsafe.Pointer(&in[0])).Unique
+}
+
+// add marks req as waiting for its reply.
+func (p *pendingReplies) add(req *request) {
+	u := inputUnique(req.inputBuf)
+	if u == 0 {
+		return
+	}
+	sh := p.shard(u)
+	sh.mu.Lock()
+	sh.m[u] = struct{}{}
+	sh.mu.Unlock()
+	req.pending = true
+}
+
+// take removes unique, and reports whether it was waiting for a reply.
+func (p *pendingReplies) take(unique uint64) bool {
+	sh := p.shard(unique)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	_, ok := sh.m[unique]
+	delete(sh.m, unique)
+	return ok
+}
+
+// done forgets req if it was not answered, eg. for FORGET.
+func (p *pendingReplies) done(req *request) {
+	if !req.pending {
+		return
+	}
+	req.pending = false
+	p.take(inputUnique(req.inputBuf))
+}
+
+// claimReply reports whether the reply to req may be written, and
+// marks it as answered. Notifications carry Unique 0, and are always
+// written.
+func (ms *Server) claimReply(req *request) bool {
+	u := req.inHeader.Unique
+	if u == 0 {
+		return true
+	}
+	req.pending = false
+	if ms.pending.take(u) {
+		return true
+	}
+	ms.opts.Logger.Printf("BUG: %v unique %d was answered already; dropping the second reply",
+		operationName(req.inHeader.Opcode), u)
+	return false
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// recordingTransport counts the replies written.
+type recordingTransport struct {
+	writes int
+}
+
+func (t *recordingTransport) ReadRequest(buf []byte) (int, error) { return 0, nil }
+func (t *recordingTransport) WriteReply(data [][]byte) error {
+	t.writes++
+	return nil
+}
+func (t *recordingTransport) Close() error { return nil }
+
+func guardRequest(ms *Server, op int32, unique uint64) *request {
+	in := make([]byte, unsafe.Sizeof(ForgetIn{}))
+	h := (*InHeader)(unsafe.Pointer(&in[0]))
+	h.Length = uint32(len(in))
+	h.Opcode = op
+	h.Unique = unique
+	h.NodeId = FUSE_ROOT_ID
+	req := ms.reqPool.Get().(*request)
+	req.setInput(in)
+	return req
+}
+
+func TestDuplicateReply(t *testing.T) {
+	var logs bytes.Buffer
+	ms, err := newServer(NewDefaultRawFileSystem(), &MountOptions{
+		Logger: log.New(&logs, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	tr := &recordingTransport{}
+	ms.transport = tr
+
+	ms.handleRequest(guardRequest(ms, _OP_STATFS, 7))
+	if tr.writes != 1 {
+		t.Fatalf("got %d replies, want 1", tr.writes)
+	}
+
+	// A second reply for the same Unique is dropped.
+	req := guardRequest(ms, _OP_STATFS, 7)
+	req.parse(ms.opts.Logger)
+	if code := ms.write(req); code != ENOENT {
+		t.Errorf("second reply: got %v, want ENOENT", code)
+	}
+	ms.returnRequest(req)
+	if tr.writes != 1 {
+		t.Errorf("got %d replies, want 1", tr.writes)
+	}
+	if !strings.Contains(logs.String(), "answered already") {
+		t.Errorf("no log for the second reply: %q", logs.String())
+	}
+
+	// Requests without a reply are not kept.
+	ms.handleRequest(guardRequest(ms, _OP_FORGET, 8))
+	for i := range ms.pending.shards {
+		if m := ms.pending.shards[i].m; len(m) != 0 {
+			t.Errorf("pending not cleaned up: %v", m)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+func TestReplyQueue(t *testing.T) {
+	fs := &blockingReadFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		gate:          newGate(false),
+	}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{
+		ReplyQueue:     1,
+		SerializeNodes: true,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if a, code := k.GetAttr(fuse.FUSE_ROOT_ID); !code.Ok() || a.Mode != fuse.S_IFDIR|0755 {
+				t.Errorf("GetAttr: %v, %v", a, code)
+			}
+			if _, code := k.Read(fuse.FUSE_ROOT_ID, 0, 0, 10); !code.Ok() {
+				t.Errorf("Read: %v", code)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := k.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+// Deferred replies that come in while the server stops go out
+// directly, rather than into a queue nobody reads.
+func TestReplyQueueStop(t *testing.T) {
+	fs := &asyncFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		queue:         make(chan func(), 20),
+	}
+	k, err := fakekernel.New(fs, &fuse.MountOptions{ReplyQueue: 1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < cap(fs.queue); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k.GetAttr(fuse.FUSE_ROOT_ID)
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fs.queue) < cap(fs.queue) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	replied := make(chan struct{})
+	go func() {
+		defer close(replied)
+		fs.loop()
+	}()
+	k.Close()
+	close(fs.queue)
+	select {
+	case <-replied:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("deferred replies blocked after the server stopped")
+	}
+	wg.Wait()
+}
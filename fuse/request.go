@@ -6,6 +6,7 @@ package fuse
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -24,10 +25,15 @@ type request struct {
 	inData   unsafe.Pointer // per op data
 	arg      []byte         // flat data.
 
+	// The rest of the flat data, if the transport handed it over
+	// separately; see SegmentReader.
+	payload [][]byte
+
 	filenames []string // filename arguments
 
 	// Output data.
 	status   Status
+	failure  string // from Fail
 	flatData []byte
 	fdData   *readResultFd
 
@@ -38,9 +44,18 @@ type request struct {
 	// Start timestamp for timing info.
 	startTime time.Time
 
+	// How the request enters MountOptions.Latencies.
+	stats requestStats
+
 	// All information pertaining to opcode of this request.
 	handler *operationHandler
 
+	// Set if a RawHandler took over the request.
+	raw *RawHandler
+
+	// Set by interceptors; see Intercepted.SetContext.
+	ctx context.Context
+
 	// Request storage. For large inputs and outputs, use data
 	// obtained through bufferpool.
 	bufferPoolInputBuf  []byte
@@ -56,20 +71,61 @@ type request struct {
 	smallInputBuf [128]byte
 
 	context Context
+
+	// Place in the per-node queues, if MountOptions.SerializeNodes
+	// is set.
+	nodeTicket *nodeTicket
+
+	// Place in the FORGET ordering, if MountOptions.OrderForgets
+	// is set.
+	forgetBatch *forgetBatch
+
+	// Set if the reply was deferred; see Server.Defer.
+	completion *Completion
+
+	// Set while the Unique of the request is in
+	// Server.pending.
+	pending bool
+
+	// The file system handling the request; see
+	// Server.ReplaceFileSystem.
+	fs      RawFileSystem
+	mounted *mountedFS
+
+	// Bytes counted against MountOptions.MaxInflightBytes.
+	inflight int64
 }
 
+type requestStats int
+
+const (
+	statsNone = requestStats(iota)
+	statsTimed
+	statsCounted
+)
+
 func (r *request) clear() {
 	r.inputBuf = nil
 	r.inHeader = nil
 	r.inData = nil
 	r.arg = nil
+	r.payload = nil
 	r.filenames = nil
 	r.status = OK
+	r.failure = ""
 	r.flatData = nil
 	r.fdData = nil
 	r.startTime = time.Time{}
+	r.stats = statsNone
 	r.handler = nil
+	r.raw = nil
+	r.ctx = nil
 	r.readResult = nil
+	r.nodeTicket = nil
+	r.forgetBatch = nil
+	r.completion = nil
+	r.fs = nil
+	r.mounted = nil
 }
 
 func (r *request) InputDebug() string {
@@ -83,8 +139,8 @@ func (r *request) InputDebug() string {
 		names = fmt.Sprintf("names: %v", r.filenames)
 	}
 
-	if len(r.arg) > 0 {
-		names += fmt.Sprintf(" %d bytes", len(r.arg))
+	if n := len(r.arg) + r.payloadLen(); n > 0 {
+		names += fmt.Sprintf(" %d bytes", n)
 	}
 
 	return fmt.Sprintf("Dispatch %d: %s, NodeId: %v.%v%v",
@@ -94,7 +150,7 @@ func (r *request) InputDebug() string {
 
 func (r *request) OutputDebug() string {
 	var dataStr string
-	if r.handler.DecodeOut != nil && r.handler.OutputSize > 0 {
+	if r.handler != nil && r.handler.DecodeOut != nil && r.handler.OutputSize > 0 {
 		dataStr = Print(r.handler.DecodeOut(r.outData()))
 	}
 
@@ -105,7 +161,7 @@ func (r *request) OutputDebug() string {
 
 	flatStr := ""
 	if r.flatDataSize() > 0 {
-		if r.handler.FileNameOut {
+		if r.handler != nil && r.handler.FileNameOut {
 			s := strings.TrimRight(string(r.flatData), "\x00")
 			flatStr = fmt.Sprintf(" %q", s)
 		} else {
@@ -135,10 +191,10 @@ func (r *request) setInput(input []byte) bool {
 	return true
 }
 
-func (r *request) parse() {
+func (r *request) parse(logger *log.Logger) {
 	inHSize := int(unsafe.Sizeof(InHeader{}))
 	if len(r.inputBuf) < inHSize {
-		log.Printf("Short read for input header: %v", r.inputBuf)
+		logger.Printf("Short read for input header: %v", r.inputBuf)
 		return
 	}
 
@@ -147,13 +203,13 @@ func (r *request) parse() {
 
 	r.handler = getHandler(r.inHeader.Opcode)
 	if r.handler == nil {
-		log.Printf("Unknown opcode %d", r.inHeader.Opcode)
+		logger.Printf("Unknown opcode %d", r.inHeader.Opcode)
 		r.status = ENOSYS
 		return
 	}
 
 	if len(r.arg) < int(r.handler.InputSize) {
-		log.Printf("Short read for %v: %v", operationName(r.inHeader.Opcode), r.arg)
+		logger.Printf("Short read for %v: %v", operationName(r.inHeader.Opcode), r.arg)
 		r.status = EIO
 		return
 	}
@@ -171,7 +227,16 @@ func (r *request) parse() {
 			// SETXATTR is special: the only opcode with a file name AND a
 			// binary argument.
 			splits := bytes.SplitN(r.arg, []byte{0}, 2)
+			if len(splits) != 2 {
+				logger.Printf("Unterminated name for SETXATTR: %q", r.arg)
+				r.status = EIO
+				return
+			}
 			r.filenames = []string{string(splits[0])}
+		} else if len(r.arg) == 0 || r.arg[len(r.arg)-1] != 0 {
+			logger.Printf("Unterminated name for %v: %q", operationName(r.inHeader.Opcode), r.arg)
+			r.status = EIO
+			return
 		} else if count == 1 {
 			r.filenames = []string{string(r.arg[:len(r.arg)-1])}
 		} else {
@@ -181,7 +246,7 @@ func (r *request) parse() {
 				r.filenames[i] = string(n)
 			}
 			if len(names) != count {
-				log.Println("filename argument mismatch", names, count)
+				logger.Println("filename argument mismatch", names, count)
 				r.status = EIO
 			}
 		}
@@ -198,15 +263,15 @@ func (r *request) outData() unsafe.Pointer {
 // serializeHeader serializes the response header. The header points
 // to an internal buffer of the receiver.
 func (r *request) serializeHeader(flatDataSize int) (header []byte) {
-	dataLength := r.handler.OutputSize
-	if r.status > OK {
-		dataLength = 0
+	var dataLength uintptr
+	if r.handler != nil && r.status <= OK {
+		dataLength = r.handler.OutputSize
 	}
 
 	// [GET|LIST]XATTR is two opcodes in one: get/list xattr size (return
 	// structured GetXAttrOut, no flat data) and get/list xattr data
 	// (return no structured data, but only flat data)
-	if r.inHeader.Opcode == _OP_GETXATTR || r.inHeader.Opcode == _OP_LISTXATTR {
+	if dataLength > 0 && (r.inHeader.Opcode == _OP_GETXATTR || r.inHeader.Opcode == _OP_LISTXATTR) {
 		if (*GetXAttrIn)(r.inData).Size != 0 {
 			dataLength = 0
 		}
@@ -0,0 +1,54 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The protocol package has the reference encoding. Check that our
+// in-place decoding accepts its golden requests.
+func TestParseGoldenRequests(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("golden files are little-endian")
+	}
+	f, err := os.Open("protocol/testdata/golden-7.23.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || !strings.HasSuffix(fields[0], ".request") {
+			continue
+		}
+		msg, err := hex.DecodeString(fields[1])
+		if err != nil {
+			t.Fatalf("DecodeString(%q): %v", fields[0], err)
+		}
+
+		req := &request{}
+		req.setInput(msg)
+		req.parse(log.Default())
+		if !req.status.Ok() || req.handler == nil {
+			t.Errorf("%s: parse: %v", fields[0], req.status)
+			continue
+		}
+		if req.handler.FileNames > 0 && len(req.filenames) != req.handler.FileNames {
+			t.Errorf("%s: got names %q, want %d", fields[0], req.filenames, req.handler.FileNames)
+		}
+		if int(req.inHeader.Length) != len(msg) {
+			t.Errorf("%s: length %d, message %d bytes", fields[0], req.inHeader.Length, len(msg))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"context"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// RequestInfo identifies the kernel request that a file system
+// method is serving, so backends can tag their own RPCs and log lines
+// with it.
+type RequestInfo struct {
+	// Unique is the kernel's ID for the request. It appears in
+	// the debug output, and in INTERRUPT requests.
+	Unique uint64
+	Opcode int32
+	NodeId uint64
+
+	// Start is when the server read the request from the kernel.
+	Start time.Time
+}
+
+// requestTable maps the address of the Context in the header of each
+// request being dispatched to the request.
+type requestTable struct {
+	mu   sync.Mutex
+	reqs map[uintptr]*request
+}
+
+func contextKey(ctx *Context) uintptr {
+	return uintptr(unsafe.Pointer(ctx))
+}
+
+func (t *requestTable) add(req *request) {
+	t.mu.Lock()
+	t.reqs[contextKey(&req.inHeader.Context)] = req
+	t.mu.Unlock()
+}
+
+func (t *requestTable) remove(req *request) {
+	t.mu.Lock()
+	delete(t.reqs, contextKey(&req.inHeader.Context))
+	t.mu.Unlock()
+}
+
+// RequestInfo returns the request that ctx belongs to. ctx must be
+// the Context passed to a file system method, ie. point into the
+// header of the request; copies are not recognized. It returns false
+// if ctx is not part of a request that is being dispatched, or if
+// MountOptions.TrackRequests is not set.
+func (ms *Server) RequestInfo(ctx *Context) (RequestInfo, bool) {
+	if ms.requests == nil || ctx == nil {
+		return RequestInfo{}, false
+	}
+	ms.requests.mu.Lock()
+	defer ms.requests.mu.Unlock()
+	req := ms.requests.reqs[contextKey(ctx)]
+	if req == nil {
+		return RequestInfo{}, false
+	}
+	return RequestInfo{
+		Unique: req.inHeader.Unique,
+		Opcode: req.inHeader.Opcode,
+		NodeId: req.inHeader.NodeId,
+		Start:  req.startTime,
+	}, true
+}
+
+// RequestContext returns the Go context that interceptors attached to
+// the request of ctx with Intercepted.SetContext, so it can be passed
+// on to backend calls. It returns context.Background() if there is
+// none, and as RequestInfo, needs MountOptions.TrackRequests.
+func (ms *Server) RequestContext(ctx *Context) context.Context {
+	if ms.requests == nil || ctx == nil {
+		return context.Background()
+	}
+	ms.requests.mu.Lock()
+	defer ms.requests.mu.Unlock()
+	req := ms.requests.reqs[contextKey(ctx)]
+	if req == nil || req.ctx == nil {
+		return context.Background()
+	}
+	return req.ctx
+}
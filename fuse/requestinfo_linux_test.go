@@ -0,0 +1,75 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/protocol"
+)
+
+// infoFS records the RequestInfo of GETATTR requests.
+type infoFS struct {
+	fuse.RawFileSystem
+	srv *fuse.Server
+
+	infos []fuse.RequestInfo
+	found []bool
+}
+
+func (fs *infoFS) Init(srv *fuse.Server) {
+	fs.srv = srv
+}
+
+func (fs *infoFS) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	info, ok := fs.srv.RequestInfo(&input.Context)
+	fs.infos = append(fs.infos, info)
+	fs.found = append(fs.found, ok)
+
+	copied := input.Context
+	if _, ok := fs.srv.RequestInfo(&copied); ok {
+		fs.found = append(fs.found, ok)
+	}
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func TestRequestInfo(t *testing.T) {
+	for _, track := range []bool{false, true} {
+		fs := &infoFS{RawFileSystem: fuse.NewDefaultRawFileSystem()}
+		k, err := fakekernel.New(fs, &fuse.MountOptions{
+			Deterministic: true,
+			TrackRequests: track,
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		k.GetAttr(fuse.FUSE_ROOT_ID)
+		k.GetAttr(fuse.FUSE_ROOT_ID)
+		k.Close()
+
+		if len(fs.found) != 2 {
+			t.Fatalf("track=%v: got found %v, want 2 lookups, copies not found", track, fs.found)
+		}
+		if !track {
+			if fs.found[0] || fs.found[1] {
+				t.Errorf("found requests without TrackRequests")
+			}
+			continue
+		}
+		a, b := fs.infos[0], fs.infos[1]
+		if !fs.found[0] || !fs.found[1] {
+			t.Fatalf("requests not found: %v", fs.found)
+		}
+		if a.Opcode != protocol.OP_GETATTR || a.NodeId != fuse.FUSE_ROOT_ID || a.Start.IsZero() {
+			t.Errorf("got %+v", a)
+		}
+		if a.Unique == 0 || a.Unique == b.Unique {
+			t.Errorf("got uniques %d and %d, want distinct", a.Unique, b.Unique)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+)
+
+type samplingMap struct {
+	mu            sync.Mutex
+	added, counts map[string]int
+}
+
+func (m *samplingMap) Add(name string, dt time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.added[name]++
+}
+
+func (m *samplingMap) Count(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[name]++
+}
+
+func (m *samplingMap) SampleRate() int {
+	return 4
+}
+
+func TestLatencySampling(t *testing.T) {
+	m := &samplingMap{added: map[string]int{}, counts: map[string]int{}}
+	k, err := fakekernel.New(fuse.NewDefaultRawFileSystem(),
+		&fuse.MountOptions{Deterministic: true, Latencies: m})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		k.GetAttr(fuse.FUSE_ROOT_ID)
+	}
+	k.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if got := m.added["GETATTR"] + m.counts["GETATTR"]; got != 40 {
+		t.Errorf("got %d GETATTRs, want 40", got)
+	}
+	var added, total int
+	for _, n := range m.added {
+		added += n
+	}
+	for _, n := range m.counts {
+		total += n
+	}
+	total += added
+	if added != total/4 {
+		t.Errorf("timed %d of %d requests, want 1 in 4", added, total)
+	}
+}
@@ -7,11 +7,10 @@ package fuse
 import (
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -26,27 +25,126 @@ const (
 type Server struct {
 	// Empty if unmounted.
 	mountPoint string
-	fileSystem RawFileSystem
+	// mounted is the file system requests go to; see
+	// ReplaceFileSystem. swapMu serializes replacing and
+	// initializing file systems.
+	mounted       atomic.Pointer[mountedFS]
+	swapMu        sync.Mutex
+	fsInitialized bool
 
 	// writeMu serializes close and notify writes
 	writeMu sync.Mutex
 
-	// I/O with kernel and daemon.
-	mountFd int
+	// I/O with kernel and daemon. mountFd is -1 for servers
+	// from NewServerTransport; splicing needs it.
+	mountFd   int
+	transport Transport
 
 	latencies LatencyMap
 
+	// Counts requests for SamplingLatencyMap. Protected by reqMu.
+	latencySeq uint64
+
+	// Payload of READ and WRITE requests; see ByteCounts.
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+
+	// Request counts for Gauges.
+	requestsRead atomic.Uint64
+	requestsLive atomic.Int64
+	handlers     atomic.Int64
+
+	// When the last request was done, in Clock nanoseconds; see
+	// IdleTime.
+	lastActive atomic.Int64
+
+	// Requests that can be interrupted, if
+	// MountOptions.EnableInterrupts is set.
+	interrupts *interruptTable
+
+	// Device number of the mount, once known. Protected by reqMu.
+	dev uint64
+
+	// Requests being dispatched, if MountOptions.TrackRequests
+	// is set.
+	requests *requestTable
+
 	opts *MountOptions
 
+	// debug is MountOptions.Debug, which SetDebug may change
+	// while serving.
+	debug atomic.Bool
+
 	// Pool for request structs.
 	reqPool sync.Pool
 
-	// Pool for raw requests data
-	readPool       sync.Pool
 	reqMu          sync.Mutex
 	reqReaders     int
+	maxReaders     int
 	kernelSettings InitIn
 
+	// Set by INIT. Protected by reqMu.
+	connInfo ConnectionInfo
+
+	// Bytes held in WRITE requests being handled, and the
+	// ceiling from MountOptions.MaxInflightBytes. Readers wait on
+	// inflightCond, which uses reqMu, while over the ceiling;
+	// inflightWaiters counts them.
+	inflightBytes   int64
+	maxInflight     int64
+	inflightCond    sync.Cond
+	inflightWaiters int
+
+	// nodes serializes requests per node, if
+	// MountOptions.SerializeNodes is set.
+	nodes *nodeSerializer
+
+	// forgets orders FORGET against entry replies, if
+	// MountOptions.OrderForgets is set.
+	forgets *forgetOrder
+
+	// qos limits concurrency per request class, if
+	// MountOptions.QoS is set.
+	qos *qosScheduler
+
+	// limiter is set if MountOptions.RateLimit is.
+	limiter *rateLimiter
+
+	// disabled has MountOptions.DisabledOps by opcode.
+	disabled map[int32]Status
+
+	// readOnly is set if changes are refused; see SetReadOnly.
+	// lockdown is set if MountOptions.ErrorLockdown is.
+	readOnly atomic.Bool
+	lockdown *lockdown
+
+	// deferrals has the Completions from Defer.
+	deferrals deferralTable
+
+	// pending has the requests that wait for their reply.
+	pending *pendingReplies
+
+	// replies is the queue for MountOptions.ReplyQueue, served by
+	// writeLoop. writerMu is held for reading to queue a reply,
+	// and for writing to stop the loop, so no reply is queued
+	// after the last one it writes.
+	replies       chan *request
+	writerOnce    sync.Once
+	writerDone    chan struct{}
+	writerMu      sync.RWMutex
+	writerStopped bool
+
+	// writeFailures counts the replies in a row that could not be
+	// written; writeBroken is set once the server gives up on
+	// the connection. See noteWrite.
+	writeFailures atomic.Int32
+	writeBroken   atomic.Bool
+
+	// rawHandlers take over opcodes; see SetRawHandler. Writers
+	// hold rawMu and replace the whole map.
+	rawMu       sync.Mutex
+	rawHandlers atomic.Pointer[map[int32]*RawHandler]
+
 	singleReader bool
 	canSplice    bool
 	loops        sync.WaitGroup
@@ -54,10 +152,38 @@ type Server struct {
 	ready chan error
 }
 
-// SetDebug is deprecated. Use MountOptions.Debug instead.
+// SetDebug turns debug output on or off, also while serving.
 func (ms *Server) SetDebug(dbg bool) {
-	// This will typically trigger the race detector.
-	ms.opts.Debug = dbg
+	ms.debug.Store(dbg)
+}
+
+// Debug reports whether debug output is on.
+func (ms *Server) Debug() bool {
+	return ms.debug.Load()
+}
+
+// BufferPool returns the buffer pool from the mount options.
+func (ms *Server) BufferPool() BufferPool {
+	return ms.opts.Buffers
+}
+
+// Clock returns the clock from the mount options, or the system
+// clock.
+func (ms *Server) Clock() Clock {
+	return ms.opts.Clock
+}
+
+// After returns a channel that receives once d has passed on the
+// server's Clock.
+func (ms *Server) After(d time.Duration) <-chan time.Time {
+	return clockAfter(ms.opts.Clock, d)
+}
+
+func clockAfter(c Clock, d time.Duration) <-chan time.Time {
+	if t, ok := c.(Timer); ok {
+		return t.After(d)
+	}
+	return time.After(d)
 }
 
 // KernelSettings returns the Init message from the kernel, so
@@ -79,10 +205,22 @@ type LatencyMap interface {
 	Add(name string, dt time.Duration)
 }
 
+// SamplingLatencyMap is a LatencyMap that only wants the latency of
+// some requests. The server then reads the clock for 1 in
+// SampleRate() requests, which are passed to Add, and passes the
+// others to Count, so exact counts can be kept.
+type SamplingLatencyMap interface {
+	LatencyMap
+	SampleRate() int
+	Count(name string)
+}
+
 // RecordLatencies switches on collection of timing for each request
 // coming from the kernel.P assing a nil argument switches off the
 func (ms *Server) RecordLatencies(l LatencyMap) {
+	ms.reqMu.Lock()
 	ms.latencies = l
+	ms.reqMu.Unlock()
 }
 
 // Unmount calls fusermount -u on the mount. This has the effect of
@@ -116,6 +254,52 @@ func (ms *Server) Unmount() (err error) {
 
 // NewServer creates a server and attaches it to the given directory.
 func NewServer(fs RawFileSystem, mountPoint string, opts *MountOptions) (*Server, error) {
+	ms, err := newServer(fs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	mountPoint, err = checkMountPoint(mountPoint, ms.opts.CreateMountPoint)
+	if err != nil {
+		return nil, err
+	}
+	if h := ms.opts.HealthCheck; h != nil {
+		if err := h.run(fs); err != nil {
+			return nil, err
+		}
+	}
+	fd, err := mount(mountPoint, ms.opts, ms.ready)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mountPoint = mountPoint
+	ms.mountFd = fd
+	ms.transport = fdTransport(fd)
+
+	if code := ms.handleInit(); !code.Ok() {
+		syscall.Close(fd)
+		// TODO - unmount as well?
+		return nil, fmt.Errorf("init: %s", code)
+	}
+	return ms, nil
+}
+
+// NewServerFd creates a server that speaks the FUSE protocol over
+// an already open file descriptor rather than a mount. The
+// descriptor must preserve message boundaries, like a
+// SOCK_SEQPACKET socket, and the INIT request must be available for
+// reading. The server stops when the other end hangs up. This is
+// mostly useful for tests; see the fakekernel package.
+func NewServerFd(fs RawFileSystem, fd int, opts *MountOptions) (*Server, error) {
+	// Splicing into a socket may split up replies, so fd is used
+	// as a plain Transport.
+	return NewServerTransport(fs, fdTransport(fd), opts)
+}
+
+// newServer applies defaults to opts and sets up a Server that is
+// not yet connected.
+func newServer(fs RawFileSystem, opts *MountOptions) (*Server, error) {
 	if opts == nil {
 		opts = &MountOptions{
 			MaxBackground: _DEFAULT_BACKGROUND_TASKS,
@@ -129,6 +313,21 @@ func NewServer(fs RawFileSystem, mountPoint string, opts *MountOptions) (*Server
 	if o.Buffers == nil {
 		o.Buffers = defaultBufferPool
 	}
+	if o.Memory != nil {
+		o.Buffers = &accountedBufferPool{
+			BufferPool: o.Buffers,
+			account:    o.Memory.Register("buffers", nil),
+		}
+	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+	if o.MaxReaders <= 0 {
+		o.MaxReaders = _MAX_READERS
+	}
+	if o.Logger == nil {
+		o.Logger = log.Default()
+	}
 	if o.MaxWrite < 0 {
 		o.MaxWrite = 0
 	}
@@ -154,41 +353,67 @@ func NewServer(fs RawFileSystem, mountPoint string, opts *MountOptions) (*Server
 	}
 
 	ms := &Server{
-		fileSystem: fs,
-		opts:       &o,
+		opts: &o,
 		// OSX has races when multiple routines read from the
 		// FUSE device: on unmount, sometime some reads do not
 		// error-out, meaning that unmount will hang.
-		singleReader: runtime.GOOS == "darwin",
+		singleReader: runtime.GOOS == "darwin" || o.Deterministic,
 		ready:        make(chan error, 1),
+		maxReaders:   o.MaxReaders,
+		maxInflight:  int64(o.MaxInflightBytes),
+		latencies:    o.Latencies,
 	}
-	ms.reqPool.New = func() interface{} { return new(request) }
-	ms.readPool.New = func() interface{} { return make([]byte, o.MaxWrite+pageSize) }
-
-	mountPoint = filepath.Clean(mountPoint)
-	if !filepath.IsAbs(mountPoint) {
-		cwd, err := os.Getwd()
+	ms.debug.Store(o.Debug)
+	ms.inflightCond.L = &ms.reqMu
+	ms.pending = newPendingReplies()
+	ms.mounted.Store(newMountedFS(fs))
+	if o.SerializeNodes {
+		ms.nodes = newNodeSerializer()
+	}
+	if o.OrderForgets {
+		ms.forgets = newForgetOrder()
+	}
+	if o.QoS != nil {
+		ms.qos = newQoSScheduler(o.QoS)
+	}
+	if o.RateLimit != nil {
+		ms.limiter = newRateLimiter(o.RateLimit, o.Clock)
+	}
+	if o.ReplyQueue > 0 {
+		ms.replies = make(chan *request, o.ReplyQueue)
+		ms.writerDone = make(chan struct{})
+	}
+	if o.ErrorLockdown != nil {
+		ms.lockdown = newLockdown(o.ErrorLockdown, o.Clock)
+	}
+	if len(o.DisabledOps) > 0 {
+		disabled, err := newDisabledOps(o.DisabledOps)
 		if err != nil {
 			return nil, err
 		}
-		mountPoint = filepath.Clean(filepath.Join(cwd, mountPoint))
+		ms.disabled = disabled
 	}
-	fd, err := mount(mountPoint, &o, ms.ready)
-	if err != nil {
-		return nil, err
+	if o.TrackRequests {
+		ms.requests = &requestTable{reqs: map[uintptr]*request{}}
 	}
-
-	ms.mountPoint = mountPoint
-	ms.mountFd = fd
-
-	if code := ms.handleInit(); !code.Ok() {
-		syscall.Close(fd)
-		// TODO - unmount as well?
-		return nil, fmt.Errorf("init: %s", code)
+	if o.EnableInterrupts {
+		ms.interrupts = newInterruptTable()
 	}
+	ms.lastActive.Store(o.Clock.Now().UnixNano())
+	ms.reqPool.New = func() interface{} { return new(request) }
 	return ms, nil
 }
 
+// hasOption reports whether Options has an entry for key.
+func (o *MountOptions) hasOption(key string) bool {
+	for _, opt := range o.Options {
+		if k, _, _ := strings.Cut(opt, "="); k == key {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *MountOptions) optionsStrings() []string {
 	var r []string
 	r = append(r, o.Options...)
@@ -197,10 +422,10 @@ func (o *MountOptions) optionsStrings() []string {
 		r = append(r, "allow_other")
 	}
 
-	if o.FsName != "" {
+	if o.FsName != "" && !o.hasOption("fsname") {
 		r = append(r, "fsname="+o.FsName)
 	}
-	if o.Name != "" {
+	if o.Name != "" && !o.hasOption("subtype") {
 		r = append(r, "subtype="+o.Name)
 	}
 
@@ -215,11 +440,34 @@ func (ms *Server) DebugData() string {
 	r = ms.reqReaders
 	ms.reqMu.Unlock()
 
-	return fmt.Sprintf("readers: %d", r)
+	return fmt.Sprintf("readers: %d, buffers: %v", r, ms.opts.Buffers)
 }
 
-// What is a good number?  Maybe the number of CPUs?
-const _MAX_READERS = 2
+// What is a good number?  Maybe the number of CPUs?  Before the
+// limit was configurable, up to 3 readers were started, so keep that.
+const _MAX_READERS = 3
+
+// SetMaxReaders changes the number of goroutines reading requests
+// in parallel; see MountOptions.MaxReaders. Lowering it takes effect
+// as readers finish their current request. Raising it does not
+// start readers by itself: a new one is only spawned when a reader
+// picks up a request, so the extra parallelism arrives with the
+// next request.
+func (ms *Server) SetMaxReaders(n int) {
+	if n <= 0 {
+		n = _MAX_READERS
+	}
+	ms.reqMu.Lock()
+	ms.maxReaders = n
+	ms.reqMu.Unlock()
+}
+
+// MaxReaders returns the current limit on parallel readers.
+func (ms *Server) MaxReaders() int {
+	ms.reqMu.Lock()
+	defer ms.reqMu.Unlock()
+	return ms.maxReaders
+}
 
 // handleEINTR retries the given function until it doesn't return syscall.EINTR.
 // This is similar to the HANDLE_EINTR() macro from Chromium ( see
@@ -244,23 +492,27 @@ func handleEINTR(fn func() error) (err error) {
 // nil, OK if we have too many readers already.
 func (ms *Server) readRequest(exitIdle bool) (req *request, code Status) {
 	ms.reqMu.Lock()
-	if ms.reqReaders > _MAX_READERS {
+	ms.waitInflight()
+	if ms.reqReaders >= ms.maxReaders {
 		ms.reqMu.Unlock()
 		return nil, OK
 	}
 	req = ms.reqPool.Get().(*request)
-	dest := ms.readPool.Get().([]byte)
+	dest := ms.opts.Buffers.AllocBuffer(uint32(ms.opts.MaxWrite + pageSize))
 	ms.reqReaders++
 	ms.reqMu.Unlock()
 
 	var n int
-	err := handleEINTR(func() error {
-		var err error
-		n, err = syscall.Read(ms.mountFd, dest)
-		return err
-	})
+	var payload [][]byte
+	var err error
+	if sr, ok := ms.transport.(SegmentReader); ok {
+		n, payload, err = sr.ReadRequestSegments(dest)
+	} else {
+		n, err = ms.transport.ReadRequest(dest)
+	}
 	if err != nil {
 		code = ToStatus(err)
+		ms.opts.Buffers.FreeBuffer(dest)
 		ms.reqPool.Put(req)
 		ms.reqMu.Lock()
 		ms.reqReaders--
@@ -268,16 +520,31 @@ func (ms *Server) readRequest(exitIdle bool) (req *request, code Status) {
 		return nil, code
 	}
 
-	if ms.latencies != nil {
-		req.startTime = time.Now()
-	}
 	gobbled := req.setInput(dest[:n])
+	req.payload = payload
 
 	ms.reqMu.Lock()
+	if ms.latencies != nil {
+		req.stats = ms.sampleStats()
+	}
+	if req.stats == statsTimed || ms.requests != nil {
+		req.startTime = ms.opts.Clock.Now()
+	}
 	if !gobbled {
-		ms.readPool.Put(dest)
+		ms.opts.Buffers.FreeBuffer(dest)
 		dest = nil
 	}
+	ms.addInflight(req)
+	ms.requestsRead.Add(1)
+	ms.requestsLive.Add(1)
+	if ms.nodes != nil {
+		// Still under reqMu, so the queues follow the order
+		// of reading.
+		req.nodeTicket = ms.nodes.enqueue(req.inputBuf)
+	}
+	if ms.forgets != nil {
+		req.forgetBatch = ms.forgets.enqueue(req.inputBuf)
+	}
 	ms.reqReaders--
 	if !ms.singleReader && ms.reqReaders <= 0 {
 		ms.loops.Add(1)
@@ -291,27 +558,66 @@ func (ms *Server) readRequest(exitIdle bool) (req *request, code Status) {
 // returnRequest returns a request to the pool of unused requests.
 func (ms *Server) returnRequest(req *request) {
 	ms.recordStats(req)
+	ms.releaseInflight(req)
+	ms.touch(req)
+	ms.requestsLive.Add(-1)
+	if req.mounted != nil {
+		// Only now, as the reply may have read from the
+		// file system's ReadResult.
+		ms.releaseFS(req.mounted)
+	}
 
 	if req.bufferPoolOutputBuf != nil {
 		ms.opts.Buffers.FreeBuffer(req.bufferPoolOutputBuf)
 		req.bufferPoolOutputBuf = nil
 	}
 
+	ms.pending.done(req)
 	req.clear()
 
 	if p := req.bufferPoolInputBuf; p != nil {
 		req.bufferPoolInputBuf = nil
-		ms.readPool.Put(p)
+		ms.opts.Buffers.FreeBuffer(p)
 	}
 	ms.reqPool.Put(req)
 }
 
+// sampleStats decides how a request enters the latency map. The
+// caller holds reqMu.
+func (ms *Server) sampleStats() requestStats {
+	s, ok := ms.latencies.(SamplingLatencyMap)
+	if !ok {
+		return statsTimed
+	}
+	ms.latencySeq++
+	if n := s.SampleRate(); n > 1 && ms.latencySeq%uint64(n) != 0 {
+		return statsCounted
+	}
+	return statsTimed
+}
+
 func (ms *Server) recordStats(req *request) {
-	if ms.latencies != nil {
-		dt := time.Now().Sub(req.startTime)
-		opname := operationName(req.inHeader.Opcode)
-		ms.latencies.Add(opname, dt)
+	if req.inHeader == nil {
+		return
 	}
+	var l LatencyMap
+	if req.stats != statsNone {
+		ms.reqMu.Lock()
+		l = ms.latencies
+		ms.reqMu.Unlock()
+	}
+	ms.countBytes(req, l)
+	if l == nil {
+		return
+	}
+	name := operationName(req.inHeader.Opcode)
+	if req.stats == statsCounted {
+		if s, ok := l.(SamplingLatencyMap); ok {
+			s.Count(name)
+		}
+		return
+	}
+	l.Add(name, ms.opts.Clock.Now().Sub(req.startTime))
 }
 
 // Serve initiates the FUSE loop. Normally, callers should run Serve()
@@ -319,14 +625,33 @@ func (ms *Server) recordStats(req *request) {
 // goroutine.
 //
 // Each filesystem operation executes in a separate goroutine.
+// Serve returns when the mount goes away, or once replies to the
+// kernel keep failing, in which case the connection is aborted.
 func (ms *Server) Serve() {
+	if t := ms.opts.IdleTimeout; t > 0 && ms.mountPoint != "" {
+		done := make(chan struct{})
+		defer close(done)
+		go ms.watchIdle(t, ms.Unmount, done)
+	}
+
 	ms.loops.Add(1)
 	ms.loop(false)
 	ms.loops.Wait()
+	ms.stopWriter()
 
 	ms.writeMu.Lock()
-	syscall.Close(ms.mountFd)
+	ms.transport.Close()
 	ms.writeMu.Unlock()
+
+	if p, ok := ms.opts.Buffers.(*accountedBufferPool); ok {
+		p.account.Close()
+	}
+
+	if c, ok := ms.FileSystem().(ExitChecker); ok {
+		if err := c.CheckExit(); err != nil {
+			ms.opts.Logger.Printf("%v: %v", c, err)
+		}
+	}
 }
 
 func (ms *Server) handleInit() Status {
@@ -346,14 +671,14 @@ func (ms *Server) handleInit() Status {
 
 	// INIT is handled. Init the file system, but don't accept
 	// incoming requests, so the file system can setup itself.
-	ms.fileSystem.Init(ms)
+	ms.initFileSystem()
 	return OK
 }
 
 func (ms *Server) loop(exitIdle bool) {
 	defer ms.loops.Done()
 exit:
-	for {
+	for !ms.writeBroken.Load() {
 		req, errNo := ms.readRequest(exitIdle)
 		switch errNo {
 		case OK:
@@ -366,11 +691,11 @@ exit:
 			// unmount
 			break exit
 		default: // some other error?
-			log.Printf("Failed to read from fuse conn: %v", errNo)
+			ms.opts.Logger.Printf("Failed to read from fuse conn: %v", errNo)
 			break exit
 		}
 
-		if ms.singleReader {
+		if ms.singleReader && !ms.opts.Deterministic {
 			go ms.handleRequest(req)
 		} else {
 			ms.handleRequest(req)
@@ -379,13 +704,49 @@ exit:
 }
 
 func (ms *Server) handleRequest(req *request) Status {
-	req.parse()
+	ms.pending.add(req)
+	limited := ms.admit(req)
+	if b := req.forgetBatch; b != nil {
+		ms.forgets.acquire(b)
+		defer ms.forgets.release(b)
+	}
+	if t := req.nodeTicket; t != nil {
+		ms.nodes.acquire(t)
+		defer ms.nodes.release(t)
+	}
+
+	if h := ms.rawHandler(req); h != nil {
+		return ms.handleRaw(req, h, limited)
+	}
+
+	req.parse(ms.opts.Logger)
+	if req.inHeader == nil {
+		// Without a header, we can't reply.
+		ms.returnRequest(req)
+		return EIO
+	}
 	if req.handler == nil {
 		req.status = ENOSYS
 	}
 
-	if req.status.Ok() && ms.opts.Debug {
-		log.Println(req.InputDebug())
+	if req.status.Ok() && ms.debug.Load() {
+		msg := req.InputDebug()
+		if r := ms.opts.ProcessResolver; r != nil {
+			msg += " " + r.Lookup(req.inHeader.Pid).String()
+		}
+		ms.opts.Logger.Println(msg)
+	}
+
+	if code, ok := ms.disabled[req.inHeader.Opcode]; ok && req.status.Ok() {
+		req.status = code
+	}
+
+	if req.status.Ok() && ms.readOnly.Load() && isChange(req) {
+		req.status = EROFS
+	}
+
+	if req.status.Ok() {
+		req.status = limited
 	}
 
 	if req.inHeader.NodeId == pollHackInode {
@@ -398,21 +759,140 @@ func (ms *Server) handleRequest(req *request) Status {
 	} else if req.inHeader.NodeId == FUSE_ROOT_ID && len(req.filenames) > 0 && req.filenames[0] == pollHackName {
 		doPollHackLookup(ms, req)
 	} else if req.status.Ok() && req.handler.Func == nil {
-		log.Printf("Unimplemented opcode %v", operationName(req.inHeader.Opcode))
+		ms.opts.Logger.Printf("Unimplemented opcode %v", operationName(req.inHeader.Opcode))
 		req.status = ENOSYS
+	} else if req.status.Ok() && ms.qos != nil && req.inHeader.Opcode != _OP_INTERRUPT {
+		release := ms.qos.acquire(req.inHeader)
+		ms.dispatch(req)
+		release()
 	} else if req.status.Ok() {
-		req.handler.Func(ms, req)
+		ms.dispatch(req)
 	}
 
-	errNo := ms.write(req)
-	if errNo != 0 {
-		log.Printf("writer: Write/Writev failed, err: %v. opcode: %v",
-			errNo, operationName(req.inHeader.Opcode))
+	if c := req.completion; c != nil && c.bind(req) {
+		// The Completion finishes req.
+		return OK
+	}
+	return ms.finish(req)
+}
+
+// finish sends the reply to req, and recycles it, or queues it for
+// writeLoop. The reply to INIT is always written right away, so
+// mounting sees errors.
+func (ms *Server) finish(req *request) Status {
+	if ms.replies != nil && req.inHeader.Opcode != _OP_INIT {
+		ms.writerMu.RLock()
+		if !ms.writerStopped {
+			ms.writerOnce.Do(func() { go ms.writeLoop() })
+			ms.replies <- req
+			ms.writerMu.RUnlock()
+			return OK
+		}
+		ms.writerMu.RUnlock()
 	}
+	return ms.writeReply(req)
+}
+
+// writeLoop writes the replies queued by finish, until it gets nil.
+func (ms *Server) writeLoop() {
+	defer close(ms.writerDone)
+	for req := range ms.replies {
+		if req == nil {
+			return
+		}
+		ms.writeReply(req)
+	}
+}
+
+// stopWriter writes the queued replies, and stops writeLoop.
+func (ms *Server) stopWriter() {
+	if ms.replies == nil {
+		return
+	}
+	ms.writerMu.Lock()
+	ms.writerStopped = true
+	ms.writerOnce.Do(func() { go ms.writeLoop() })
+	ms.replies <- nil
+	ms.writerMu.Unlock()
+	<-ms.writerDone
+}
+
+// writeReply writes the reply to req, and recycles it.
+func (ms *Server) writeReply(req *request) Status {
+	errNo := ms.write(req)
+	ms.noteWrite(req, errNo)
 	ms.returnRequest(req)
 	return Status(errNo)
 }
 
+// maxWriteFailures is the number of replies in a row that may fail
+// before the server stops.
+const maxWriteFailures = 10
+
+// noteWrite counts the failures to write replies. If the kernel
+// cannot get replies, its requests wait forever, so once writing
+// keeps failing, or the connection is gone, the connection is
+// aborted and Serve returns.
+func (ms *Server) noteWrite(req *request, code Status) {
+	switch code {
+	case OK:
+		ms.writeFailures.Store(0)
+		return
+	case ENOENT:
+		// The kernel has given up on the request, eg. after an
+		// interrupt. The connection is fine.
+		if ms.debug.Load() {
+			ms.opts.Logger.Printf("writer: reply to %v unique %d not wanted",
+				operationName(req.inHeader.Opcode), req.inHeader.Unique)
+		}
+		return
+	}
+	ms.opts.Logger.Printf("writer: Write/Writev failed, err: %v. opcode: %v",
+		code, operationName(req.inHeader.Opcode))
+
+	n := ms.writeFailures.Add(1)
+	if code != ENODEV && code != EBADF && n < maxWriteFailures {
+		return
+	}
+	if ms.writeBroken.Swap(true) {
+		return
+	}
+	if code != ENODEV {
+		ms.opts.Logger.Printf("writer: %d replies failed, last with %v; stopping", n, code)
+		ms.abortConnection()
+	}
+}
+
+// dispatch runs the handler for req, through the interceptors if
+// there are any.
+func (ms *Server) dispatch(req *request) {
+	ms.handlers.Add(1)
+	defer ms.handlers.Add(-1)
+	req.mounted = ms.acquireFS()
+	req.fs = req.mounted.fs
+
+	if ms.requests != nil {
+		ms.requests.add(req)
+		defer ms.requests.remove(req)
+	}
+	if ms.interrupts != nil && req.inHeader.Opcode != _OP_INTERRUPT {
+		ms.interrupts.add(req)
+		defer ms.interrupts.remove(req)
+	}
+
+	dispatching.add(req)
+	if len(ms.opts.Interceptors) > 0 {
+		ms.intercept(req)
+	} else {
+		req.handler.Func(ms, req)
+	}
+	dispatching.remove(req)
+	ms.logFailure(req)
+	if !ms.takeDeferral(req) {
+		ms.noteLockdown(req)
+	}
+}
+
 func (ms *Server) allocOut(req *request, size uint32) []byte {
 	if cap(req.bufferPoolOutputBuf) >= int(size) {
 		req.bufferPoolOutputBuf = req.bufferPoolOutputBuf[:size]
@@ -430,10 +910,26 @@ func (ms *Server) write(req *request) Status {
 	if req.inHeader.Opcode == _OP_FORGET || req.inHeader.Opcode == _OP_BATCH_FORGET {
 		return OK
 	}
+	// Neither does a successful INTERRUPT.
+	if req.inHeader.Opcode == _OP_INTERRUPT && req.status.Ok() {
+		return OK
+	}
+	if req.raw != nil && req.raw.NoReply {
+		return OK
+	}
+
+	if !ms.claimReply(req) {
+		// As the kernel says for replies it does not wait for.
+		return ENOENT
+	}
 
 	header := req.serializeHeader(req.flatDataSize())
-	if ms.opts.Debug {
-		log.Println(req.OutputDebug())
+	if ms.debug.Load() {
+		if req.raw != nil {
+			ms.opts.Logger.Println(rawOutputDebug(req))
+		} else {
+			ms.opts.Logger.Println(req.OutputDebug())
+		}
 	}
 
 	if header == nil {
@@ -469,8 +965,8 @@ func (ms *Server) InodeNotify(node uint64, off int64, length int64) Status {
 	result := ms.write(&req)
 	ms.writeMu.Unlock()
 
-	if ms.opts.Debug {
-		log.Println("Response: INODE_NOTIFY", result)
+	if ms.debug.Load() {
+		ms.opts.Logger.Println("Response: INODE_NOTIFY", result)
 	}
 	return result
 }
@@ -510,8 +1006,8 @@ func (ms *Server) DeleteNotify(parent uint64, child uint64, name string) Status
 	result := ms.write(&req)
 	ms.writeMu.Unlock()
 
-	if ms.opts.Debug {
-		log.Printf("Response: DELETE_NOTIFY: %v", result)
+	if ms.debug.Load() {
+		ms.opts.Logger.Printf("Response: DELETE_NOTIFY: %v", result)
 	}
 	return result
 }
@@ -546,8 +1042,8 @@ func (ms *Server) EntryNotify(parent uint64, name string) Status {
 	result := ms.write(&req)
 	ms.writeMu.Unlock()
 
-	if ms.opts.Debug {
-		log.Printf("Response: ENTRY_NOTIFY: %v", result)
+	if ms.debug.Load() {
+		ms.opts.Logger.Printf("Response: ENTRY_NOTIFY: %v", result)
 	}
 	return result
 }
@@ -586,5 +1082,18 @@ func (ms *Server) WaitMount() error {
 	if err != nil {
 		return err
 	}
-	return pollHack(ms.mountPoint)
+	if ms.mountPoint == "" {
+		// Not mounted, see NewServerFd.
+		return nil
+	}
+	if err := pollHack(ms.mountPoint); err != nil {
+		return err
+	}
+	if err := ms.findDevice(); err != nil {
+		ms.opts.Logger.Printf("cannot find device of %s: %v", ms.mountPoint, err)
+	}
+	if err := ms.raiseReadAhead(); err != nil {
+		ms.opts.Logger.Printf("cannot raise read ahead to %d: %v", ms.opts.MaxReadAhead, err)
+	}
+	return nil
 }
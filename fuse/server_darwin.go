@@ -4,17 +4,9 @@
 
 package fuse
 
-import (
-	"syscall"
-)
-
 func (ms *Server) systemWrite(req *request, header []byte) Status {
 	if req.flatDataSize() == 0 {
-		err := handleEINTR(func() error {
-			_, err := syscall.Write(ms.mountFd, header)
-			return err
-		})
-		return ToStatus(err)
+		return ToStatus(ms.transport.WriteReply([][]byte{header}))
 	}
 
 	if req.fdData != nil {
@@ -24,9 +16,26 @@ func (ms *Server) systemWrite(req *request, header []byte) Status {
 		header = req.serializeHeader(len(req.flatData))
 	}
 
-	_, err := writev(int(ms.mountFd), [][]byte{header, req.flatData})
+	err := ms.transport.WriteReply([][]byte{header, req.flatData})
 	if req.readResult != nil {
 		req.readResult.Done()
 	}
 	return ToStatus(err)
 }
+
+// raiseReadAhead is a no-op: OSXFUSE has no read ahead setting beyond
+// INIT.
+func (ms *Server) raiseReadAhead() error {
+	return nil
+}
+
+// findDevice is a no-op: OSXFUSE does not export per-mount state.
+func (ms *Server) findDevice() error {
+	return nil
+}
+
+func (ms *Server) kernelWaiting() (int, bool) {
+	return 0, false
+}
+
+func (ms *Server) abortConnection() {}
@@ -5,17 +5,16 @@
 package fuse
 
 import (
-	"log"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
 func (ms *Server) systemWrite(req *request, header []byte) Status {
 	if req.flatDataSize() == 0 {
-		err := handleEINTR(func() error {
-			_, err := syscall.Write(ms.mountFd, header)
-			return err
-		})
-		return ToStatus(err)
+		return ToStatus(ms.transport.WriteReply([][]byte{header}))
 	}
 
 	if req.fdData != nil {
@@ -25,7 +24,7 @@ func (ms *Server) systemWrite(req *request, header []byte) Status {
 				req.readResult.Done()
 				return OK
 			}
-			log.Println("trySplice:", err)
+			ms.opts.Logger.Println("trySplice:", err)
 		}
 
 		sz := req.flatDataSize()
@@ -34,9 +33,95 @@ func (ms *Server) systemWrite(req *request, header []byte) Status {
 		header = req.serializeHeader(len(req.flatData))
 	}
 
-	_, err := writev(ms.mountFd, [][]byte{header, req.flatData})
+	err := ms.transport.WriteReply([][]byte{header, req.flatData})
 	if req.readResult != nil {
 		req.readResult.Done()
 	}
 	return ToStatus(err)
 }
+
+// findDevice remembers the device number of the mount, which names
+// it in sysfs. It must not be called from a file system method, as
+// the stat is served by this server.
+func (ms *Server) findDevice() error {
+	var st syscall.Stat_t
+	if err := syscall.Stat(ms.mountPoint, &st); err != nil {
+		return err
+	}
+	ms.reqMu.Lock()
+	ms.dev = uint64(st.Dev)
+	ms.reqMu.Unlock()
+	return nil
+}
+
+// device returns the major and minor device number of the mount. The
+// minor number is also the connection number in
+// /sys/fs/fuse/connections.
+func (ms *Server) device() (major, minor uint64, ok bool) {
+	ms.reqMu.Lock()
+	dev := ms.dev
+	ms.reqMu.Unlock()
+	if dev == 0 {
+		return 0, 0, false
+	}
+	major = (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor = dev&0xff | (dev>>12)&^0xff
+	return major, minor, true
+}
+
+// kernelWaiting returns the number of requests that the kernel has
+// queued for the server or is waiting on a reply for.
+func (ms *Server) kernelWaiting() (int, bool) {
+	_, minor, ok := ms.device()
+	if !ok {
+		return 0, false
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/sys/fs/fuse/connections/%d/waiting", minor))
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// abortConnection aborts the kernel connection of the mount, so
+// requests that the server will not read fail with ENOTCONN.
+func (ms *Server) abortConnection() {
+	_, minor, ok := ms.device()
+	if !ok {
+		return
+	}
+	os.WriteFile(fmt.Sprintf("/sys/fs/fuse/connections/%d/abort", minor), []byte("1"), 0)
+}
+
+// raiseReadAhead makes the kernel read ahead MaxReadAhead bytes, if
+// that is more than it offered in INIT. INIT can only lower the read
+// ahead; the ceiling is the read_ahead_kb setting of the backing
+// device info of the mount, which only root can change.
+func (ms *Server) raiseReadAhead() error {
+	want := uint32(ms.opts.MaxReadAhead)
+	ms.reqMu.Lock()
+	have := ms.connInfo.MaxReadAhead
+	ms.reqMu.Unlock()
+	if want <= have {
+		return nil
+	}
+
+	major, minor, ok := ms.device()
+	if !ok {
+		return fmt.Errorf("device of %s unknown", ms.mountPoint)
+	}
+	path := fmt.Sprintf("/sys/class/bdi/%d:%d/read_ahead_kb", major, minor)
+	kb := (want + 1023) / 1024
+	if err := os.WriteFile(path, []byte(strconv.Itoa(int(kb))), 0); err != nil {
+		return err
+	}
+
+	ms.reqMu.Lock()
+	ms.connInfo.MaxReadAhead = kb * 1024
+	ms.reqMu.Unlock()
+	return nil
+}
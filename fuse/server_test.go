@@ -0,0 +1,106 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxReaders(t *testing.T) {
+	ms, err := newServer(NewDefaultRawFileSystem(), &MountOptions{MaxReaders: 3})
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	if got := ms.MaxReaders(); got != 3 {
+		t.Errorf("MaxReaders: got %d, want 3", got)
+	}
+
+	// With all reader slots taken, an extra reader leaves
+	// without touching the (unset) mount fd.
+	ms.reqReaders = 3
+	if req, code := ms.readRequest(true); req != nil || !code.Ok() {
+		t.Errorf("readRequest: got %v, %v, want nil, OK", req, code)
+	}
+
+	ms.SetMaxReaders(0)
+	if got := ms.MaxReaders(); got != _MAX_READERS {
+		t.Errorf("MaxReaders after reset: got %d, want %d", got, _MAX_READERS)
+	}
+}
+
+type countingLatencies map[string]int
+
+func (c countingLatencies) Add(name string, dt time.Duration) {
+	c[name]++
+}
+
+func TestServerOptions(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+	lat := countingLatencies{}
+	ms, err := newServer(NewDefaultRawFileSystem(), applyOptions([]Option{
+		WithMaxReaders(1),
+		WithMaxReaders(5),
+		WithLogger(logger),
+		WithLatencies(lat),
+		WithMaxWrite(1 << 20),
+		WithRateLimit(&RateLimit{Rate: 10}),
+		WithDisabledOps(map[string]Status{"SETXATTR": EPERM}),
+		WithReplyQueue(8),
+		WithIdleTimeout(time.Minute),
+	}))
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	if got := ms.MaxReaders(); got != 5 {
+		t.Errorf("MaxReaders: got %d, want 5", got)
+	}
+	if ms.opts.Logger != logger {
+		t.Errorf("Logger not set")
+	}
+	if ms.latencies == nil {
+		t.Errorf("Latencies not set")
+	}
+	if ms.opts.MaxWrite != MAX_KERNEL_WRITE {
+		t.Errorf("MaxWrite: got %d, want the kernel maximum %d", ms.opts.MaxWrite, MAX_KERNEL_WRITE)
+	}
+	if ms.limiter == nil {
+		t.Errorf("RateLimit not set")
+	}
+	if ms.disabled[_OP_SETXATTR] != EPERM {
+		t.Errorf("DisabledOps not set: %v", ms.disabled)
+	}
+	if ms.opts.ReplyQueue != 8 || ms.opts.IdleTimeout != time.Minute {
+		t.Errorf("got ReplyQueue %d, IdleTimeout %v", ms.opts.ReplyQueue, ms.opts.IdleTimeout)
+	}
+	if ms.opts.MaxBackground != _DEFAULT_BACKGROUND_TASKS {
+		t.Errorf("MaxBackground: got %d, want default %d", ms.opts.MaxBackground, _DEFAULT_BACKGROUND_TASKS)
+	}
+}
+
+func TestMountOptionsPassThrough(t *testing.T) {
+	ms, err := newServer(NewDefaultRawFileSystem(), applyOptions([]Option{
+		WithMountOptions("default_permissions", "max_read=131072"),
+		WithMountOptions("subtype=custom"),
+	}))
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	ms.opts.FsName = "myfs"
+	got := strings.Join(ms.opts.optionsStrings(), ",")
+	if want := "default_permissions,max_read=131072,subtype=custom,fsname=myfs"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := newServer(NewDefaultRawFileSystem(), &MountOptions{
+		Options: []string{"a=b,c"},
+	}); err == nil {
+		t.Error("option with ',' accepted")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"time"
+)
+
+// SetAttrTarget receives the parts of a SETATTR request; see
+// SetAttrInCommon.Apply. The methods match those of nodefs.File.
+type SetAttrTarget interface {
+	Chmod(perms uint32) Status
+
+	// Chown gets ^uint32(0) for an ID that should not change,
+	// like chown(2).
+	Chown(uid uint32, gid uint32) Status
+	Truncate(size uint64) Status
+	Utimens(atime *time.Time, mtime *time.Time) Status
+}
+
+// Apply splits up the request into calls on t: Chmod, Chown,
+// Truncate and Utimens, in that order. Only the calls for the
+// attributes in Valid are made, and Apply stops at the first error.
+// Times to be set to the current time use now.
+func (s *SetAttrInCommon) Apply(t SetAttrTarget, now time.Time) Status {
+	if perms, ok := s.GetMode(); ok {
+		if code := t.Chmod(perms); !code.Ok() {
+			return code
+		}
+	}
+
+	uid, uidOK := s.GetUID()
+	gid, gidOK := s.GetGID()
+	if uidOK || gidOK {
+		if code := t.Chown(uid, gid); !code.Ok() {
+			return code
+		}
+	}
+
+	if size, ok := s.GetSize(); ok {
+		if code := t.Truncate(size); !code.Ok() {
+			return code
+		}
+	}
+
+	if atime, mtime := s.Times(now); atime != nil || mtime != nil {
+		return t.Utimens(atime, mtime)
+	}
+	return OK
+}
+
+// GetMode returns the permission bits to set, if any.
+func (s *SetAttrInCommon) GetMode() (uint32, bool) {
+	return s.Mode & 07777, s.Valid&FATTR_MODE != 0
+}
+
+// GetUID returns the owner to set, if any. Otherwise, it returns
+// ^uint32(0), which chown(2) ignores.
+func (s *SetAttrInCommon) GetUID() (uint32, bool) {
+	if s.Valid&FATTR_UID == 0 {
+		return ^uint32(0), false
+	}
+	return s.Uid, true
+}
+
+// GetGID returns the group to set, if any. Otherwise, it returns
+// ^uint32(0), which chown(2) ignores.
+func (s *SetAttrInCommon) GetGID() (uint32, bool) {
+	if s.Valid&FATTR_GID == 0 {
+		return ^uint32(0), false
+	}
+	return s.Gid, true
+}
+
+// GetSize returns the size to truncate to, if any.
+func (s *SetAttrInCommon) GetSize() (uint64, bool) {
+	return s.Size, s.Valid&FATTR_SIZE != 0
+}
+
+// GetFh returns the file handle the request was made through, if
+// any, eg. for ftruncate(2).
+func (s *SetAttrInCommon) GetFh() (uint64, bool) {
+	return s.Fh, s.Valid&FATTR_FH != 0
+}
+
+// Times returns the access and modification times that SETATTR
+// asks for, with nanosecond precision. A nil time should be left
+// alone. Times that should be set to the current time, signaled by
+// FATTR_ATIME_NOW or a UTIME_NOW nanosecond value, are returned as
+// now; a UTIME_OMIT nanosecond value leaves the time alone.
+func (s *SetAttrInCommon) Times(now time.Time) (atime *time.Time, mtime *time.Time) {
+	atime = setAttrTime(s.Valid&FATTR_ATIME != 0, s.Valid&FATTR_ATIME_NOW != 0, s.Atime, s.Atimensec, &now)
+	mtime = setAttrTime(s.Valid&FATTR_MTIME != 0, s.Valid&FATTR_MTIME_NOW != 0, s.Mtime, s.Mtimensec, &now)
+	return atime, mtime
+}
+
+func setAttrTime(set, setNow bool, sec uint64, nsec uint32, now *time.Time) *time.Time {
+	switch {
+	case setNow:
+		return now
+	case !set || nsec == UTIME_OMIT:
+		return nil
+	case nsec == UTIME_NOW:
+		return now
+	}
+	t := time.Unix(int64(sec), int64(nsec))
+	return &t
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fakekernel"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestSetAttrTimesLoopback(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	nfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(dir), nil)
+	conn := nodefs.NewFileSystemConnector(nfs.Root(), nil)
+	k, err := fakekernel.New(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer k.Close()
+
+	p := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	atime := time.Unix(100, 123456789)
+	if err := os.Chtimes(p, atime, atime); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, code := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+
+	// Set mtime with nanoseconds, leaving atime alone.
+	in := &fuse.SetAttrIn{}
+	in.Valid = fuse.FATTR_ATIME | fuse.FATTR_MTIME
+	in.Atimensec = fuse.UTIME_OMIT
+	in.Mtime = 200
+	in.Mtimensec = 987654321
+	out, code := k.SetAttr(entry.NodeId, in)
+	if !code.Ok() {
+		t.Fatalf("SetAttr: %v", code)
+	}
+	if out.Mtime != 200 || out.Mtimensec != 987654321 {
+		t.Errorf("got mtime %d.%09d, want 200.987654321", out.Mtime, out.Mtimensec)
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Lstat(p, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Atim.Sec != 100 || st.Atim.Nsec != 123456789 {
+		t.Errorf("atime changed to %v", st.Atim)
+	}
+	if st.Mtim.Sec != 200 || st.Mtim.Nsec != 987654321 {
+		t.Errorf("got mtime %v on disk", st.Mtim)
+	}
+}
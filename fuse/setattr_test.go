@@ -0,0 +1,120 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestSetAttrTimes(t *testing.T) {
+	now := time.Unix(1000, 5)
+	for _, tc := range []struct {
+		in           SetAttrInCommon
+		atime, mtime *time.Time
+	}{
+		{SetAttrInCommon{Valid: FATTR_SIZE}, nil, nil},
+		{SetAttrInCommon{Valid: FATTR_ATIME | FATTR_MTIME, Atime: 1, Atimensec: 2, Mtime: 3, Mtimensec: 999999999},
+			timePtr(time.Unix(1, 2)), timePtr(time.Unix(3, 999999999))},
+		{SetAttrInCommon{Valid: FATTR_ATIME | FATTR_ATIME_NOW | FATTR_MTIME | FATTR_MTIME_NOW},
+			&now, &now},
+		{SetAttrInCommon{Valid: FATTR_ATIME | FATTR_MTIME, Atimensec: UTIME_OMIT, Mtimensec: UTIME_NOW},
+			nil, &now},
+	} {
+		atime, mtime := tc.in.Times(now)
+		if !timeEq(atime, tc.atime) || !timeEq(mtime, tc.mtime) {
+			t.Errorf("%v: got %v, %v, want %v, %v", &tc.in, atime, mtime, tc.atime, tc.mtime)
+		}
+	}
+}
+
+func TestUtimeToTimespec(t *testing.T) {
+	if ts := UtimeToTimespec(nil); ts.Nsec != UTIME_OMIT {
+		t.Errorf("nil: got %v, want UTIME_OMIT", ts)
+	}
+	for _, tm := range []time.Time{
+		time.Unix(1, 999999999),
+		time.Unix(-1, 5),
+		time.Date(2500, 1, 1, 0, 0, 0, 7, time.UTC),
+	} {
+		ts := UtimeToTimespec(&tm)
+		want := tm
+		if unsafe.Sizeof(ts.Sec) == 4 && tm.Unix() > math.MaxInt32 {
+			want = time.Unix(math.MaxInt32, int64(tm.Nanosecond()))
+		}
+		if got := time.Unix(int64(ts.Sec), int64(ts.Nsec)); !got.Equal(want) {
+			t.Errorf("%v: got %v, want %v", tm, got, want)
+		}
+	}
+}
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+func timeEq(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+type setAttrRecorder struct {
+	calls []string
+	fail  string
+}
+
+func (r *setAttrRecorder) record(call string) Status {
+	r.calls = append(r.calls, call)
+	if call == r.fail {
+		return EPERM
+	}
+	return OK
+}
+
+func (r *setAttrRecorder) Chmod(perms uint32) Status {
+	return r.record(fmt.Sprintf("chmod 0%o", perms))
+}
+
+func (r *setAttrRecorder) Chown(uid uint32, gid uint32) Status {
+	return r.record(fmt.Sprintf("chown %d %d", int32(uid), int32(gid)))
+}
+
+func (r *setAttrRecorder) Truncate(size uint64) Status {
+	return r.record(fmt.Sprintf("truncate %d", size))
+}
+
+func (r *setAttrRecorder) Utimens(atime *time.Time, mtime *time.Time) Status {
+	return r.record(fmt.Sprintf("utimens %v %v", atime != nil, mtime != nil))
+}
+
+func TestSetAttrApply(t *testing.T) {
+	in := SetAttrInCommon{
+		Valid: FATTR_MODE | FATTR_GID | FATTR_SIZE | FATTR_MTIME,
+		Mode:  syscall.S_IFREG | 0640,
+		Owner: Owner{Gid: 5},
+		Size:  10,
+	}
+
+	r := &setAttrRecorder{}
+	if code := in.Apply(r, time.Now()); !code.Ok() {
+		t.Fatalf("Apply: %v", code)
+	}
+	want := "chmod 0640, chown -1 5, truncate 10, utimens false true"
+	if got := strings.Join(r.calls, ", "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	r = &setAttrRecorder{fail: "chown -1 5"}
+	if code := in.Apply(r, time.Now()); code != EPERM {
+		t.Errorf("Apply: got %v, want EPERM", code)
+	}
+	if len(r.calls) != 2 {
+		t.Errorf("Apply did not stop at the error: %v", r.calls)
+	}
+}
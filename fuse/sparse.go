@@ -0,0 +1,133 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"sort"
+)
+
+// Hole is a range of a sparse file that reads as zeros and takes no
+// space on disk.
+type Hole struct {
+	Off int64
+	Len int64
+}
+
+// NormalizeHoles returns holes sorted by offset, with overlapping and
+// adjacent holes merged, and clipped to a file of the given size.
+func NormalizeHoles(size uint64, holes []Hole) []Hole {
+	sorted := make([]Hole, 0, len(holes))
+	for _, h := range holes {
+		end := min(h.Off+h.Len, int64(size))
+		if h.Off < 0 || h.Len <= 0 || h.Off >= end {
+			continue
+		}
+		sorted = append(sorted, Hole{h.Off, end - h.Off})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Off < sorted[j].Off })
+
+	var r []Hole
+	for _, h := range sorted {
+		if n := len(r); n > 0 && r[n-1].Off+r[n-1].Len >= h.Off {
+			r[n-1].Len = max(r[n-1].Len, h.Off+h.Len-r[n-1].Off)
+			continue
+		}
+		r = append(r, h)
+	}
+	return r
+}
+
+// AllocatedBlocks returns the number of 512-byte blocks, as in
+// Attr.Blocks, taken by a file of the given size that has data
+// everywhere except in holes, on a file system that allocates space
+// in units of blksize bytes.
+func AllocatedBlocks(size uint64, blksize uint32, holes []Hole) uint64 {
+	bs := int64(blksize)
+	if bs <= 0 {
+		bs = 512
+	}
+
+	var count int64
+	// The last block counted, so blocks shared by two data
+	// ranges are counted once.
+	last := int64(-1)
+	addData := func(start, end int64) {
+		if start >= end {
+			return
+		}
+		first := start / bs
+		if first <= last {
+			first = last + 1
+		}
+		if l := (end - 1) / bs; l >= first {
+			count += l - first + 1
+			last = l
+		}
+	}
+
+	pos := int64(0)
+	for _, h := range NormalizeHoles(size, holes) {
+		addData(pos, h.Off)
+		pos = h.Off + h.Len
+	}
+	addData(pos, int64(size))
+	return uint64(count * bs / 512)
+}
+
+// SetSparseSize sets the size and block count of a, for a file of the
+// given size with the given holes. On platforms that report it,
+// Blksize is set too.
+func (a *Attr) SetSparseSize(size uint64, blksize uint32, holes []Hole) {
+	a.Size = size
+	a.Blocks = AllocatedBlocks(size, blksize, holes)
+	a.setBlksize(blksize)
+}
+
+// ReadSparse serves a read of dest at off from a file of the given
+// size with the given holes. Holes are filled with zeros, and only
+// the data ranges are read with readData; a short read from readData
+// is padded with zeros too, as the size is authoritative.
+func ReadSparse(dest []byte, off int64, size uint64, holes []Hole, readData func(dest []byte, off int64) (int, Status)) (ReadResult, Status) {
+	if off >= int64(size) {
+		return ReadResultData(nil), OK
+	}
+	end := min(off+int64(len(dest)), int64(size))
+	dest = dest[:end-off]
+
+	fill := func(start, stop int64, data bool) Status {
+		start, stop = max(start, off), min(stop, end)
+		if start >= stop {
+			return OK
+		}
+		b := dest[start-off : stop-off]
+		n := 0
+		if data {
+			var code Status
+			if n, code = readData(b, start); !code.Ok() {
+				return code
+			}
+		}
+		clear(b[n:])
+		return OK
+	}
+
+	pos := int64(0)
+	for _, h := range NormalizeHoles(size, holes) {
+		if pos >= end {
+			break
+		}
+		if code := fill(pos, h.Off, true); !code.Ok() {
+			return nil, code
+		}
+		if code := fill(h.Off, h.Off+h.Len, false); !code.Ok() {
+			return nil, code
+		}
+		pos = h.Off + h.Len
+	}
+	if code := fill(pos, end, true); !code.Ok() {
+		return nil, code
+	}
+	return ReadResultData(dest), OK
+}
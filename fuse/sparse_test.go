@@ -0,0 +1,75 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeHoles(t *testing.T) {
+	got := NormalizeHoles(100, []Hole{{50, 10}, {0, 5}, {5, 5}, {55, 10}, {90, 50}, {200, 1}, {3, 0}})
+	want := []Hole{{0, 10}, {50, 15}, {90, 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAllocatedBlocks(t *testing.T) {
+	for _, tc := range []struct {
+		size  uint64
+		holes []Hole
+		want  uint64
+	}{
+		{0, nil, 0},
+		{1, nil, 8},
+		{4096, nil, 8},
+		{4097, nil, 16},
+		{1 << 20, []Hole{{0, 1 << 20}}, 0},
+		// Data at both ends of a 1M file.
+		{1 << 20, []Hole{{4096, 1<<20 - 8192}}, 16},
+		// Holes not aligned to blocks: the partially
+		// filled blocks count.
+		{3 * 4096, []Hole{{100, 8192}}, 16},
+		// Two data ranges sharing a block count it once.
+		{4096, []Hole{{10, 10}}, 8},
+	} {
+		if got := AllocatedBlocks(tc.size, 4096, tc.holes); got != tc.want {
+			t.Errorf("AllocatedBlocks(%d, %v): got %d, want %d", tc.size, tc.holes, got, tc.want)
+		}
+	}
+}
+
+func TestReadSparse(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 30)
+	holes := []Hole{{10, 10}}
+	var reads [][2]int64
+	readData := func(dest []byte, off int64) (int, Status) {
+		reads = append(reads, [2]int64{off, int64(len(dest))})
+		return copy(dest, content[off:]), OK
+	}
+
+	buf := make([]byte, 100)
+	for i := range buf {
+		buf[i] = '?'
+	}
+	res, code := ReadSparse(buf, 5, 25, holes, readData)
+	if !code.Ok() {
+		t.Fatalf("ReadSparse: %v", code)
+	}
+	got, _ := res.Bytes(nil)
+	want := "xxxxx\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00xxxxx"
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if want := [][2]int64{{5, 5}, {20, 5}}; !reflect.DeepEqual(reads, want) {
+		t.Errorf("got data reads %v, want %v", reads, want)
+	}
+
+	if res, _ := ReadSparse(buf, 25, 25, holes, readData); res.Size() != 0 {
+		t.Errorf("read at EOF returned %d bytes", res.Size())
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Supervisor keeps a file system mounted: if the connection to the
+// kernel is aborted, or, with StallTimeout, the server stops
+// answering, it detaches the dead mount and mounts a fresh server at
+// the same place. Processes that had files open on the old mount get errors
+// for them, but new opens work once the remount is done.
+//
+// The state of the file system dies with the connection, as the
+// kernel forgets all node IDs and file handles, so Setup is called
+// for every mount to build the file system anew.
+type Supervisor struct {
+	// MountPoint and Options are passed to NewServer.
+	MountPoint string
+	Options    *MountOptions
+
+	// Setup returns the file system to mount. restarts is 0 for
+	// the first mount, and counts the remounts after that.
+	Setup func(restarts int) (RawFileSystem, error)
+
+	// If set, OnMount is called once the server is mounted, eg.
+	// to prime caches or start notifications.
+	OnMount func(server *Server, restarts int)
+
+	// MaxRestarts bounds the number of remounts. If 0, there is
+	// no bound.
+	MaxRestarts int
+
+	// Backoff is the wait before retrying a failed remount. It
+	// doubles for each further failure, up to a minute. If 0, 100ms
+	// is used.
+	Backoff time.Duration
+
+	// If set, Run checks that the server answers by calling
+	// statfs on the mount point every StallTimeout. If the answer
+	// takes longer than StallTimeout, eg. because the server
+	// stopped reading requests, or all its readers hang in the
+	// file system, the connection is aborted, and the file system
+	// remounted. If 0, only a connection that is gone is noticed.
+	// Set it well above the time the file system may take for
+	// STATFS under load.
+	StallTimeout time.Duration
+
+	mu      sync.Mutex
+	server  *Server
+	stopped bool
+}
+
+const maxSupervisorBackoff = time.Minute
+
+// Server returns the current server, or nil between mounts.
+func (s *Supervisor) Server() *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.server
+}
+
+// Stop unmounts the file system. Run then returns nil.
+func (s *Supervisor) Stop() error {
+	s.mu.Lock()
+	s.stopped = true
+	server := s.server
+	s.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Unmount()
+}
+
+func (s *Supervisor) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// Run mounts and serves the file system until Stop is called, or it
+// is unmounted from outside, in which case it returns nil. It returns
+// an error if the first mount fails, or after MaxRestarts remounts.
+func (s *Supervisor) Run() error {
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	wait := backoff
+	for restarts := 0; ; {
+		server, served, err := s.mount(restarts)
+		if err != nil {
+			if restarts == 0 {
+				return err
+			}
+			if s.isStopped() {
+				return nil
+			}
+			s.logger().Printf("remount %s: %v; retrying in %v", s.MountPoint, err, wait)
+			time.Sleep(wait)
+			if wait *= 2; wait > maxSupervisorBackoff {
+				wait = maxSupervisorBackoff
+			}
+			continue
+		}
+		wait = backoff
+		if s.OnMount != nil {
+			s.OnMount(server, restarts)
+		}
+		stop := make(chan struct{})
+		if s.StallTimeout > 0 {
+			go s.watch(server, stop)
+		}
+		<-served
+		close(stop)
+
+		s.mu.Lock()
+		s.server = nil
+		stopped := s.stopped
+		s.mu.Unlock()
+		if stopped {
+			return nil
+		}
+
+		// A server that stopped reading leaves a live
+		// connection, on which stat would hang.
+		server.abortConnection()
+		if !isStaleMount(s.MountPoint) {
+			// Unmounted from outside.
+			return nil
+		}
+		if err := unmountLazy(s.MountPoint); err != nil {
+			return fmt.Errorf("detach %s: %v", s.MountPoint, err)
+		}
+		restarts++
+		if s.MaxRestarts > 0 && restarts > s.MaxRestarts {
+			return fmt.Errorf("%s: connection lost after %d remounts", s.MountPoint, s.MaxRestarts)
+		}
+		s.logger().Printf("connection for %s lost; remounting", s.MountPoint)
+	}
+}
+
+// watch aborts the connection of server once it does not answer
+// statfs within StallTimeout, until stop is closed.
+func (s *Supervisor) watch(server *Server, stop chan struct{}) {
+	t := time.NewTicker(s.StallTimeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		answered := make(chan struct{})
+		go func() {
+			var st syscall.Statfs_t
+			syscall.Statfs(s.MountPoint, &st)
+			close(answered)
+		}()
+		select {
+		case <-stop:
+			return
+		case <-answered:
+		case <-time.After(s.StallTimeout):
+			s.logger().Printf("%s: no answer to statfs in %v; aborting the connection", s.MountPoint, s.StallTimeout)
+			server.abortConnection()
+			return
+		}
+	}
+}
+
+// mount sets up a server and serves it. served is closed when Serve
+// returns.
+func (s *Supervisor) mount(restarts int) (server *Server, served chan struct{}, err error) {
+	if s.isStopped() {
+		return nil, nil, syscall.ENODEV
+	}
+	fs, err := s.Setup(restarts)
+	if err != nil {
+		return nil, nil, err
+	}
+	server, err = NewServer(fs, s.MountPoint, s.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	served = make(chan struct{})
+	go func() {
+		server.Serve()
+		close(served)
+	}()
+	if err := server.WaitMount(); err != nil {
+		server.Unmount()
+		<-served
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	stopped := s.stopped
+	if !stopped {
+		s.server = server
+	}
+	s.mu.Unlock()
+	if stopped {
+		server.Unmount()
+		<-served
+		return nil, nil, syscall.ENODEV
+	}
+	return server, served, nil
+}
+
+func (s *Supervisor) logger() *log.Logger {
+	if s.Options != nil && s.Options.Logger != nil {
+		return s.Options.Logger
+	}
+	return log.Default()
+}
+
+// isStaleMount returns whether dir is a mount whose connection is
+// gone.
+func isStaleMount(dir string) bool {
+	var st syscall.Stat_t
+	return syscall.Stat(dir, &st) == syscall.ENOTCONN
+}
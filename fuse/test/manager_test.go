@@ -0,0 +1,70 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestManager(t *testing.T) {
+	m := fuse.NewManager(&fuse.MountOptions{Debug: testutil.VerboseTest()})
+
+	var mnts []string
+	for _, name := range []string{"a", "b"} {
+		orig := testutil.TempDir()
+		defer os.RemoveAll(orig)
+		mnt := testutil.TempDir()
+		defer os.RemoveAll(mnt)
+		if err := ioutil.WriteFile(filepath.Join(orig, "file"), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		nfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(orig), nil)
+		conn := nodefs.NewFileSystemConnector(nfs.Root(), nil)
+		if _, err := m.Mount(mnt, conn.RawFS()); err != nil {
+			m.Shutdown()
+			t.Fatalf("Mount: %v", err)
+		}
+		mnts = append(mnts, mnt)
+	}
+	defer m.Shutdown()
+
+	if _, err := m.Mount(mnts[0], fuse.NewDefaultRawFileSystem()); err == nil {
+		t.Errorf("mounting twice succeeded")
+	}
+	for i, mnt := range mnts {
+		if content, err := ioutil.ReadFile(filepath.Join(mnt, "file")); err != nil || string(content) != []string{"a", "b"}[i] {
+			t.Errorf("ReadFile(%d): %q, %v", i, content, err)
+		}
+	}
+	if s := m.Stats(); s.Mounts != 2 || s.Buffers.Allocs == 0 {
+		t.Errorf("got stats %+v, want 2 mounts sharing a used pool", s)
+	}
+
+	if err := m.Unmount(mnts[0]); err != nil {
+		t.Fatalf("Unmount: %v", err)
+	}
+	if got := m.MountPoints(); len(got) != 1 || got[0] != filepath.Clean(mnts[1]) {
+		t.Errorf("got mount points %v, want %q", got, mnts[1])
+	}
+
+	if err := m.Shutdown(); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if len(m.MountPoints()) != 0 {
+		t.Errorf("mounts left after Shutdown: %v", m.MountPoints())
+	}
+	if _, err := m.Mount(mnts[0], fuse.NewDefaultRawFileSystem()); err != fuse.ErrManagerShutdown {
+		t.Errorf("Mount after Shutdown: got %v, want ErrManagerShutdown", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestSupervisorRemount(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	orig := filepath.Join(dir, "orig")
+	mnt := filepath.Join(dir, "mnt")
+	os.Mkdir(orig, 0755)
+	os.Mkdir(mnt, 0755)
+	if err := ioutil.WriteFile(filepath.Join(orig, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mounted := make(chan int, 2)
+	s := &fuse.Supervisor{
+		MountPoint: mnt,
+		Options:    &fuse.MountOptions{Debug: testutil.VerboseTest()},
+		Setup: func(restarts int) (fuse.RawFileSystem, error) {
+			pfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(orig), nil)
+			conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+			return conn.RawFS(), nil
+		},
+		OnMount: func(server *fuse.Server, restarts int) {
+			mounted <- restarts
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Run() }()
+	select {
+	case r := <-mounted:
+		if r != 0 {
+			t.Fatalf("first mount: got restarts %d", r)
+		}
+	case err := <-done:
+		t.Fatalf("Run: %v", err)
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(mnt, &st); err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	minor := st.Dev&0xff | (st.Dev>>12)&^0xff
+	abort := fmt.Sprintf("/sys/fs/fuse/connections/%d/abort", minor)
+	if err := ioutil.WriteFile(abort, []byte("1"), 0); err != nil {
+		s.Stop()
+		t.Skipf("cannot abort connection: %v", err)
+	}
+
+	if r := <-mounted; r != 1 {
+		t.Fatalf("remount: got restarts %d", r)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(mnt, "file")); err != nil || string(data) != "hello" {
+		t.Errorf("after remount: got %q, %v", data, err)
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run: %v", err)
+	}
+}
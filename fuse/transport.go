@@ -0,0 +1,124 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Transport carries FUSE messages between a Server and its client,
+// for servers that are not attached to /dev/fuse, eg. a virtio-fs
+// device that exports the file system into a virtual machine. See
+// NewServerTransport.
+//
+// Errors should be syscall.Errno values; ENODEV means that the
+// client is gone, and makes Serve return.
+type Transport interface {
+	// ReadRequest reads the next request into buf, and returns
+	// its size. It is called from several goroutines at once.
+	ReadRequest(buf []byte) (int, error)
+
+	// WriteReply writes one reply or notification, which is
+	// the concatenation of data. Replies carry the Unique of
+	// their request in the header; notifications have Unique
+	// 0.
+	WriteReply(data [][]byte) error
+
+	// Close is called once Serve is done.
+	Close() error
+}
+
+// fdTransport is the Transport for a /dev/fuse or socket file
+// descriptor.
+type fdTransport int
+
+func (fd fdTransport) ReadRequest(buf []byte) (int, error) {
+	var n int
+	err := handleEINTR(func() error {
+		var err error
+		n, err = syscall.Read(int(fd), buf)
+		return err
+	})
+	if err == nil && n == 0 {
+		// EOF: the other end of a NewServerFd connection hung up.
+		err = syscall.ENODEV
+	}
+	return n, err
+}
+
+// WriteReply writes all of data. /dev/fuse takes a reply whole or
+// not at all, but a socket passed to NewServerFd may take part of
+// it, so the rest is written after a short write.
+func (fd fdTransport) WriteReply(data [][]byte) error {
+	for {
+		data = skipWritten(data, 0)
+		if len(data) == 0 {
+			return nil
+		}
+		var n int
+		var err error
+		if len(data) == 1 {
+			err = handleEINTR(func() error {
+				var err error
+				n, err = syscall.Write(int(fd), data[0])
+				return err
+			})
+		} else {
+			n, err = writev(int(fd), data)
+		}
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			// Retrying would spin.
+			return syscall.EIO
+		}
+		data = skipWritten(data, n)
+	}
+}
+
+// skipWritten returns what is left of data after writing n bytes of
+// it, without the empty slices in front. It does not change data.
+func skipWritten(data [][]byte, n int) [][]byte {
+	for len(data) > 0 && n >= len(data[0]) {
+		n -= len(data[0])
+		data = data[1:]
+	}
+	if n > 0 {
+		rest := make([][]byte, len(data))
+		copy(rest, data)
+		rest[0] = rest[0][n:]
+		data = rest
+	}
+	return data
+}
+
+func (fd fdTransport) Close() error {
+	return syscall.Close(int(fd))
+}
+
+// NewServerTransport creates a server that speaks the FUSE protocol
+// over t. It blocks until the client has sent INIT, which for a
+// virtual machine happens when the guest mounts the file system.
+// The server stops when t reports ENODEV.
+func NewServerTransport(fs RawFileSystem, t Transport, opts *MountOptions) (*Server, error) {
+	ms, err := newServer(fs, opts)
+	if err != nil {
+		return nil, err
+	}
+	ms.transport = t
+	ms.mountFd = -1
+	ms.ready <- nil
+	close(ms.ready)
+
+	if code := ms.handleInit(); !code.Ok() {
+		return nil, fmt.Errorf("init: %s", code)
+	}
+
+	// Splicing writes to mountFd directly.
+	ms.canSplice = false
+	return ms, nil
+}
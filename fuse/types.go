@@ -28,6 +28,9 @@ const (
 	// EAGAIN Resource temporarily unavailable
 	EAGAIN = Status(syscall.EAGAIN)
 
+	// EINTR Interrupted system call
+	EINTR = Status(syscall.EINTR)
+
 	// EINVAL Invalid argument
 	EINVAL = Status(syscall.EINVAL)
 
@@ -65,6 +68,15 @@ const (
 	EROFS = Status(syscall.EROFS)
 )
 
+// NO_OPEN can be returned from RawFileSystem.Open and OpenDir by file
+// systems that keep no per-handle state, if MountOptions.EnableNoOpen
+// is set. If the kernel announced CAP_NO_OPEN_SUPPORT (resp.
+// CAP_NO_OPENDIR_SUPPORT), it then treats all further opens as
+// successful with file handle 0, without sending OPEN and RELEASE
+// (resp. OPENDIR and RELEASEDIR). Older kernels, and servers without
+// EnableNoOpen, fail the open with ENOSYS, which NO_OPEN is.
+const NO_OPEN = ENOSYS
+
 type ForgetIn struct {
 	InHeader
 
@@ -146,7 +158,13 @@ type SetAttrInCommon struct {
 	Unused5 uint32
 }
 
-const RELEASE_FLUSH = (1 << 0)
+const (
+	RELEASE_FLUSH = (1 << 0)
+
+	// RELEASE_FLOCK_UNLOCK asks to release the flock(2) locks
+	// taken through the file handle.
+	RELEASE_FLOCK_UNLOCK = (1 << 1)
+)
 
 type ReleaseIn struct {
 	InHeader
@@ -198,6 +216,8 @@ const (
 	CAP_PARALLEL_DIROPS  = (1 << 18)
 	CAP_HANDLE_KILLPRIV  = (1 << 19)
 	CAP_POSIX_ACL        = (1 << 20)
+
+	CAP_NO_OPENDIR_SUPPORT = (1 << 24)
 )
 
 type InitIn struct {
@@ -246,14 +266,17 @@ type InterruptIn struct {
 	Unique uint64
 }
 
-type _BmapIn struct {
+// BmapIn asks for the device block that holds a file block. Block
+// is given in units of Blocksize.
+type BmapIn struct {
 	InHeader
 	Block     uint64
 	Blocksize uint32
 	Padding   uint32
 }
 
-type _BmapOut struct {
+// BmapOut holds the device block, in the same units as the request.
+type BmapOut struct {
 	Block uint64
 }
 
@@ -348,6 +371,10 @@ type FsyncIn struct {
 	Padding    uint32
 }
 
+// FSYNC_FDATASYNC in FsyncIn.FsyncFlags asks to flush only the data,
+// and the metadata needed to read it back, like fdatasync(2).
+const FSYNC_FDATASYNC = (1 << 0)
+
 type OutHeader struct {
 	Length uint32
 	Status int32
@@ -74,6 +74,9 @@ func (g *GetAttrIn) Fh() uint64 {
 	return 0
 }
 
+func (g *GetAttrIn) setFh(fh uint64) {
+}
+
 // Uses OpenIn struct for create.
 type CreateIn struct {
 	InHeader
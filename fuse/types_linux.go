@@ -119,3 +119,7 @@ func (s *StatfsOut) FromStatfsT(statfs *syscall.Statfs_t) {
 	s.Frsize = uint32(statfs.Frsize)
 	s.NameLen = uint32(statfs.Namelen)
 }
+
+func (g *GetAttrIn) setFh(fh uint64) {
+	g.Fh_ = fh
+}
@@ -349,3 +349,12 @@ func (fs *wrappingFS) Fallocate(in *FallocateIn) (code Status) {
 	}
 	return ENOSYS
 }
+
+func (fs *wrappingFS) Bmap(in *BmapIn, out *BmapOut) (code Status) {
+	if s, ok := fs.fs.(interface {
+		Bmap(in *BmapIn, out *BmapOut) (code Status)
+	}); ok {
+		return s.Bmap(in, out)
+	}
+	return ENOSYS
+}
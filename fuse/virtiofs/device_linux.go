@@ -0,0 +1,564 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package virtiofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Options configures a Device.
+type Options struct {
+	// RequestQueues is the number of request queues, which must
+	// match the num-request-queues property of the
+	// vhost-user-fs device. The default is 1.
+	RequestQueues int
+
+	// Logger receives errors of the front-end connection. It
+	// defaults to log.Default().
+	Logger *log.Logger
+
+	// Debug logs the vhost-user messages.
+	Debug bool
+}
+
+// Device is a virtio-fs device, backed by a vhost-user connection.
+// It implements fuse.Transport.
+type Device struct {
+	opts Options
+
+	listener *net.UnixListener
+
+	// connMu guards conn, which is set once the front-end has
+	// connected.
+	connMu sync.Mutex
+	conn   *net.UnixConn
+	closed bool
+
+	// memMu guards mem. The queues hold it for reading while
+	// they access guest memory, and so do WRITE requests whose
+	// data is handed out by ReadRequestSegments.
+	memMu sync.RWMutex
+	mem   memory
+
+	// Only used by the message loop.
+	features         uint64
+	protocolFeatures uint64
+
+	queues []*queue
+
+	// reqs has the descriptor chains that ReadRequest has not
+	// picked up yet. done is closed when the front-end is gone.
+	reqs     chan *element
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// pending has the requests waiting for WriteReply, by
+	// Unique.
+	pendingMu sync.Mutex
+	pending   map[uint64]*element
+}
+
+// Listen creates a Device that waits for the front-end to connect
+// on the unix socket socketPath. Only one front-end can connect.
+func Listen(socketPath string, opts *Options) (*Device, error) {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	d := newDevice(opts)
+	d.listener = l
+	go d.accept()
+	return d, nil
+}
+
+func newDevice(opts *Options) *Device {
+	d := &Device{
+		reqs:    make(chan *element),
+		done:    make(chan struct{}),
+		pending: map[uint64]*element{},
+	}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.RequestQueues <= 0 {
+		d.opts.RequestQueues = 1
+	}
+	if d.opts.Logger == nil {
+		d.opts.Logger = log.Default()
+	}
+	// The high priority queue, for FORGET and INTERRUPT, comes
+	// first.
+	for i := 0; i < 1+d.opts.RequestQueues; i++ {
+		d.queues = append(d.queues, &queue{dev: d, index: i})
+	}
+	return d
+}
+
+func (d *Device) accept() {
+	conn, err := d.listener.AcceptUnix()
+	d.listener.Close()
+	if err != nil {
+		d.shutdown()
+		return
+	}
+
+	d.connMu.Lock()
+	if d.closed {
+		d.connMu.Unlock()
+		conn.Close()
+		d.shutdown()
+		return
+	}
+	d.conn = conn
+	d.connMu.Unlock()
+
+	d.serve(conn)
+}
+
+// serve handles vhost-user messages until the front-end hangs up.
+func (d *Device) serve(conn *net.UnixConn) {
+	defer d.shutdown()
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			if err != io.EOF && !d.isClosed() {
+				d.opts.Logger.Printf("virtiofs: %v", err)
+			}
+			return
+		}
+		if d.opts.Debug {
+			d.opts.Logger.Printf("virtiofs: %s flags %#x payload %x fds %v", msgName(msg.request), msg.flags, msg.payload, msg.fds)
+		}
+
+		reply, err := d.handle(msg)
+		msg.closeFds()
+		if err != nil {
+			d.opts.Logger.Printf("virtiofs: %s: %v", msgName(msg.request), err)
+		}
+		if reply == nil && msg.flags&vhostUserNeedReply != 0 && d.protocolFeatures&protocolFeatureReplyAck != 0 {
+			var code uint64
+			if err != nil {
+				code = 1
+			}
+			reply = u64Payload(code)
+		}
+		if reply != nil {
+			if err := writeReply(conn, msg, reply); err != nil {
+				d.opts.Logger.Printf("virtiofs: %s reply: %v", msgName(msg.request), err)
+				return
+			}
+		}
+	}
+}
+
+func (d *Device) isClosed() bool {
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+	return d.closed
+}
+
+// takeFd returns the file descriptor passed with msg, which the
+// caller then owns.
+func takeFd(msg *message) (*os.File, error) {
+	if len(msg.fds) != 1 {
+		return nil, fmt.Errorf("got %d file descriptors, want 1", len(msg.fds))
+	}
+	fd := msg.fds[0]
+	msg.fds = nil
+	// Nonblocking, so os.File uses the poller, and Close
+	// interrupts a blocked Read.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "eventfd"), nil
+}
+
+// handle executes msg, and returns the reply payload for messages
+// that have one.
+func (d *Device) handle(msg *message) ([]byte, error) {
+	switch msg.request {
+	case msgGetFeatures:
+		return u64Payload(virtioFVersion1 | vhostUserFProtocolFeats), nil
+	case msgSetFeatures:
+		f, err := msg.u64()
+		d.features = f
+		return nil, err
+	case msgGetProtocolFeatures:
+		return u64Payload(protocolFeatureMQ | protocolFeatureReplyAck), nil
+	case msgSetProtocolFeatures:
+		f, err := msg.u64()
+		d.protocolFeatures = f
+		return nil, err
+	case msgGetQueueNum:
+		return u64Payload(uint64(len(d.queues))), nil
+	case msgSetOwner:
+		return nil, nil
+	case msgResetOwner:
+		d.stopQueues()
+		return nil, nil
+	case msgSetMemTable:
+		return nil, d.setMemTable(msg)
+	case msgGetVringBase:
+		index, _, err := msg.vringState()
+		if err != nil {
+			return nil, err
+		}
+		q, err := d.queue(index)
+		if err != nil {
+			return nil, err
+		}
+		q.mu.Lock()
+		q.stop()
+		// Until SET_VRING_ENABLE, the ring is disabled again.
+		q.enabled = false
+		last := q.lastAvail
+		q.mu.Unlock()
+		return vringStatePayload(index, uint32(last)), nil
+	}
+
+	// The rest configures a queue.
+	var index uint32
+	var err error
+	switch msg.request {
+	case msgSetVringNum, msgSetVringBase, msgSetVringEnable, msgSetVringAddr:
+		index, _, err = msg.vringState()
+	case msgSetVringKick, msgSetVringCall, msgSetVringErr:
+		var v uint64
+		v, err = msg.u64()
+		index = uint32(v & vringIndexMask)
+		if v&vringNoFd != 0 {
+			err = fmt.Errorf("polling queue %d is not supported", index)
+		}
+	default:
+		return nil, fmt.Errorf("not supported")
+	}
+	if err != nil {
+		return nil, err
+	}
+	q, err := d.queue(index)
+	if err != nil {
+		return nil, err
+	}
+	if msg.request == msgSetVringKick {
+		// Restart with the new kick.
+		q.mu.Lock()
+		q.stop()
+		q.mu.Unlock()
+	}
+
+	d.memMu.RLock()
+	defer d.memMu.RUnlock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch msg.request {
+	case msgSetVringNum:
+		_, num, _ := msg.vringState()
+		if num == 0 || num > 32768 || num&(num-1) != 0 {
+			return nil, fmt.Errorf("queue %d: bad size %d", index, num)
+		}
+		q.num = uint16(num)
+	case msgSetVringBase:
+		_, base, _ := msg.vringState()
+		q.lastAvail = uint16(base)
+	case msgSetVringAddr:
+		if len(msg.payload) < 40 {
+			return nil, fmt.Errorf("short payload")
+		}
+		q.descAddr = byteOrder.Uint64(msg.payload[8:])
+		q.usedAddr = byteOrder.Uint64(msg.payload[16:])
+		q.availAddr = byteOrder.Uint64(msg.payload[24:])
+	case msgSetVringEnable:
+		_, enable, _ := msg.vringState()
+		q.enabled = enable != 0
+	case msgSetVringKick:
+		f, err := takeFd(msg)
+		if err != nil {
+			return nil, err
+		}
+		q.kick = f
+		// Without protocol features, rings start enabled.
+		if d.features&vhostUserFProtocolFeats == 0 {
+			q.enabled = true
+		}
+	case msgSetVringCall:
+		f, err := takeFd(msg)
+		if err != nil {
+			return nil, err
+		}
+		if q.call != nil {
+			q.call.Close()
+		}
+		q.call = f
+	case msgSetVringErr:
+		// We don't report queue errors.
+		return nil, nil
+	}
+	return nil, q.start(d.mem)
+}
+
+func (d *Device) queue(index uint32) (*queue, error) {
+	if int(index) >= len(d.queues) {
+		return nil, fmt.Errorf("queue %d out of range; increase Options.RequestQueues", index)
+	}
+	return d.queues[index], nil
+}
+
+func (d *Device) setMemTable(msg *message) error {
+	if len(msg.payload) < 8 {
+		return fmt.Errorf("short payload")
+	}
+	n := int(byteOrder.Uint32(msg.payload))
+	if n > maxRegions || len(msg.payload) < 8+32*n || len(msg.fds) != n {
+		return fmt.Errorf("bad table of %d regions, %d file descriptors", n, len(msg.fds))
+	}
+
+	var mem memory
+	for i := 0; i < n; i++ {
+		p := msg.payload[8+32*i:]
+		r := region{
+			guestAddr: byteOrder.Uint64(p[0:]),
+			size:      byteOrder.Uint64(p[8:]),
+			userAddr:  byteOrder.Uint64(p[16:]),
+			offset:    byteOrder.Uint64(p[24:]),
+		}
+		var err error
+		r.mapping, err = syscall.Mmap(msg.fds[i], 0, int(r.offset+r.size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+		if err != nil {
+			mem.unmap()
+			return fmt.Errorf("mmap region %d: %v", i, err)
+		}
+		mem = append(mem, r)
+	}
+
+	d.memMu.Lock()
+	defer d.memMu.Unlock()
+	old := d.mem
+	d.mem = mem
+	for _, q := range d.queues {
+		q.mu.Lock()
+		if q.stopped != nil {
+			if err := q.mapRings(mem); err != nil {
+				d.opts.Logger.Printf("virtiofs: queue %d: %v", q.index, err)
+			}
+		}
+		q.mu.Unlock()
+	}
+	old.unmap()
+	return nil
+}
+
+func (d *Device) stopQueues() {
+	for _, q := range d.queues {
+		q.mu.Lock()
+		q.stop()
+		if q.call != nil {
+			q.call.Close()
+			q.call = nil
+		}
+		q.mu.Unlock()
+	}
+}
+
+// shutdown releases everything once the front-end is gone.
+func (d *Device) shutdown() {
+	d.doneOnce.Do(func() {
+		close(d.done)
+		d.stopQueues()
+		d.memMu.Lock()
+		d.mem.unmap()
+		d.mem = nil
+		d.memMu.Unlock()
+	})
+}
+
+// ReadRequest implements fuse.Transport.
+func (d *Device) ReadRequest(buf []byte) (int, error) {
+	n, _, err := d.readRequest(buf, false)
+	return n, err
+}
+
+// ReadRequestSegments implements fuse.SegmentReader. The data of
+// WRITE requests is not copied, but returned as slices of guest
+// memory. Until their reply is written, the device holds on to the
+// memory table, so a front-end that changes it waits for the writes
+// to finish.
+func (d *Device) ReadRequestSegments(buf []byte) (int, [][]byte, error) {
+	return d.readRequest(buf, true)
+}
+
+func (d *Device) readRequest(buf []byte, split bool) (int, [][]byte, error) {
+	for {
+		var e *element
+		select {
+		case e = <-d.reqs:
+		case <-d.done:
+			return 0, nil, syscall.ENODEV
+		}
+
+		d.memMu.RLock()
+		n, payload, err := d.copyIn(e, buf, split)
+		if len(payload) > 0 {
+			e.memHeld = true
+		} else {
+			d.memMu.RUnlock()
+		}
+		if err != nil {
+			d.opts.Logger.Printf("virtiofs: queue %d: %v", e.q.index, err)
+			e.q.push(e.head, 0)
+			continue
+		}
+
+		// See struct fuse_in_header.
+		opcode := binary.NativeEndian.Uint32(buf[4:])
+		unique := binary.NativeEndian.Uint64(buf[8:])
+		if opcode == opForget || opcode == opBatchForget {
+			// The server does not reply to these.
+			e.q.push(e.head, 0)
+		} else {
+			d.pendingMu.Lock()
+			d.pending[unique] = e
+			d.pendingMu.Unlock()
+		}
+		return n, payload, nil
+	}
+}
+
+// The opcodes that have no reply.
+const (
+	opForget      = 2
+	opBatchForget = 42
+
+	inHeaderSize = 40
+)
+
+// WRITE requests are split after their fixed size input, see struct
+// fuse_write_in.
+const (
+	opWrite     = 16
+	writeInSize = inHeaderSize + 40
+)
+
+// copyIn copies the request of e into buf. With split, it stops after
+// the fixed size input of WRITE requests, and returns their data in
+// guest memory. The caller holds memMu for reading.
+func (d *Device) copyIn(e *element, buf []byte, split bool) (int, [][]byte, error) {
+	limit := len(buf)
+	if split && limit > writeInSize {
+		limit = writeInSize
+	}
+	n := 0
+	in := e.in
+	var src []byte
+	for len(src) > 0 || len(in) > 0 {
+		if len(src) == 0 {
+			var err error
+			if src, err = d.mem.guest(in[0].addr, uint64(in[0].len)); err != nil {
+				return 0, nil, err
+			}
+			in = in[1:]
+			continue
+		}
+		if n == limit {
+			if !split || n < inHeaderSize {
+				return 0, nil, fmt.Errorf("request larger than %d bytes", len(buf))
+			}
+			split = false
+			if binary.NativeEndian.Uint32(buf[4:]) == opWrite {
+				payload := [][]byte{src}
+				for _, b := range in {
+					p, err := d.mem.guest(b.addr, uint64(b.len))
+					if err != nil {
+						return 0, nil, err
+					}
+					payload = append(payload, p)
+				}
+				return n, payload, nil
+			}
+			limit = len(buf)
+			continue
+		}
+		c := copy(buf[n:limit], src)
+		n += c
+		src = src[c:]
+	}
+	if n < inHeaderSize {
+		return 0, nil, fmt.Errorf("short request of %d bytes", n)
+	}
+	return n, nil, nil
+}
+
+// WriteReply implements fuse.Transport.
+func (d *Device) WriteReply(data [][]byte) error {
+	// See struct fuse_out_header.
+	unique := binary.NativeEndian.Uint64(data[0][8:])
+	if unique == 0 {
+		// Notifications need the notification queue.
+		return syscall.ENOSYS
+	}
+	d.pendingMu.Lock()
+	e := d.pending[unique]
+	delete(d.pending, unique)
+	d.pendingMu.Unlock()
+	if e == nil {
+		return syscall.ENOENT
+	}
+
+	if !e.memHeld {
+		d.memMu.RLock()
+	}
+	n, err := d.copyOut(e, data)
+	d.memMu.RUnlock()
+	e.q.push(e.head, n)
+	return err
+}
+
+// copyOut copies the reply into the writable buffers of e. The
+// caller holds memMu for reading.
+func (d *Device) copyOut(e *element, data [][]byte) (uint32, error) {
+	var n uint32
+	out := e.out
+	var dst []byte
+	for _, src := range data {
+		for len(src) > 0 {
+			if len(dst) == 0 {
+				if len(out) == 0 {
+					return n, syscall.EINVAL
+				}
+				var err error
+				if dst, err = d.mem.guest(out[0].addr, uint64(out[0].len)); err != nil {
+					return n, syscall.EFAULT
+				}
+				out = out[1:]
+				continue
+			}
+			c := copy(dst, src)
+			dst, src = dst[c:], src[c:]
+			n += uint32(c)
+		}
+	}
+	return n, nil
+}
+
+// Close disconnects the front-end, or stops waiting for it.
+func (d *Device) Close() error {
+	d.connMu.Lock()
+	d.closed = true
+	conn := d.conn
+	d.connMu.Unlock()
+
+	d.listener.Close()
+	if conn != nil {
+		conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package virtiofs
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/protocol"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// frontEnd plays QEMU and the guest kernel for one request queue.
+type frontEnd struct {
+	t    *testing.T
+	conn *net.UnixConn
+
+	// Guest memory, at guest address 0 and front-end address
+	// userBase. The rings are at the start, and each descriptor
+	// chain gets a request and reply buffer after that.
+	mem []byte
+
+	kick, call *os.File
+	avail      uint16
+	unique     uint64
+}
+
+const (
+	userBase  = 0x7f0000000000
+	memSize   = 1 << 20
+	queueSize = 8
+
+	descOff  = 0
+	availOff = 0x100
+	usedOff  = 0x200
+	bufOff   = 0x1000
+	bufSize  = 0x1000
+)
+
+func (f *frontEnd) send(req uint32, payload []byte, fds ...int) {
+	f.t.Helper()
+	buf := make([]byte, headerSize+len(payload))
+	byteOrder.PutUint32(buf[0:], req)
+	byteOrder.PutUint32(buf[4:], vhostUserVersion)
+	byteOrder.PutUint32(buf[8:], uint32(len(payload)))
+	copy(buf[headerSize:], payload)
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	if _, _, err := f.conn.WriteMsgUnix(buf, oob, nil); err != nil {
+		f.t.Fatalf("send %s: %v", msgName(req), err)
+	}
+}
+
+func (f *frontEnd) get(req uint32, payload []byte) []byte {
+	f.t.Helper()
+	f.send(req, payload)
+	msg, err := readMessage(f.conn)
+	if err != nil {
+		f.t.Fatalf("reply to %s: %v", msgName(req), err)
+	}
+	if msg.request != req || msg.flags&vhostUserReply == 0 {
+		f.t.Fatalf("reply to %s: got %s, flags %x", msgName(req), msgName(msg.request), msg.flags)
+	}
+	return msg.payload
+}
+
+// setup configures queue 1 like QEMU does.
+func (f *frontEnd) setup(memFile *os.File) {
+	f.t.Helper()
+	features := byteOrder.Uint64(f.get(msgGetFeatures, nil))
+	if features&virtioFVersion1 == 0 {
+		f.t.Fatalf("got features %x, want VERSION_1", features)
+	}
+	f.send(msgSetFeatures, u64Payload(virtioFVersion1))
+	f.send(msgSetOwner, nil)
+
+	table := make([]byte, 8+32)
+	byteOrder.PutUint32(table, 1)
+	byteOrder.PutUint64(table[8:], 0)
+	byteOrder.PutUint64(table[16:], memSize)
+	byteOrder.PutUint64(table[24:], userBase)
+	f.send(msgSetMemTable, table, int(memFile.Fd()))
+
+	f.send(msgSetVringNum, vringStatePayload(1, queueSize))
+	addr := make([]byte, 40)
+	byteOrder.PutUint32(addr, 1)
+	byteOrder.PutUint64(addr[8:], userBase+descOff)
+	byteOrder.PutUint64(addr[16:], userBase+usedOff)
+	byteOrder.PutUint64(addr[24:], userBase+availOff)
+	f.send(msgSetVringAddr, addr)
+	f.send(msgSetVringBase, vringStatePayload(1, 0))
+
+	var kick, call [2]*os.File
+	for _, p := range []*[2]*os.File{&kick, &call} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			f.t.Fatalf("Pipe: %v", err)
+		}
+		p[0], p[1] = r, w
+	}
+	f.send(msgSetVringCall, u64Payload(1), int(call[1].Fd()))
+	f.send(msgSetVringKick, u64Payload(1), int(kick[0].Fd()))
+	call[1].Close()
+	kick[0].Close()
+	f.kick, f.call = kick[1], call[0]
+}
+
+// roundTrip queues a request, and returns the reply.
+func (f *frontEnd) roundTrip(req *protocol.Request) []byte {
+	f.t.Helper()
+	f.unique++
+	req.Header.Unique = f.unique
+	msg, err := protocol.MarshalRequest(req, protocol.MAXIMUM_MINOR_VERSION)
+	if err != nil {
+		f.t.Fatalf("MarshalRequest: %v", err)
+	}
+
+	slot := uint64(f.avail % queueSize)
+	in := bufOff + 2*bufSize*slot
+	out := in + bufSize
+	copy(f.mem[in:], msg)
+	desc := f.mem[descOff+2*slot*descSize:]
+	binary.LittleEndian.PutUint64(desc[0:], in)
+	binary.LittleEndian.PutUint32(desc[8:], uint32(len(msg)))
+	binary.LittleEndian.PutUint16(desc[12:], descFNext)
+	binary.LittleEndian.PutUint16(desc[14:], uint16(2*slot+1))
+	binary.LittleEndian.PutUint64(desc[16:], out)
+	binary.LittleEndian.PutUint32(desc[24:], bufSize)
+	binary.LittleEndian.PutUint16(desc[28:], descFWrite)
+
+	binary.LittleEndian.PutUint16(f.mem[availOff+4+2*slot:], uint16(2*slot))
+	f.avail++
+	storeRingHeader(f.mem[availOff:], 0, f.avail)
+	var one [8]byte
+	binary.NativeEndian.PutUint64(one[:], 1)
+	if _, err := f.kick.Write(one[:]); err != nil {
+		f.t.Fatalf("kick: %v", err)
+	}
+
+	f.call.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, used := loadRingHeader(f.mem[usedOff:]); used == f.avail {
+			break
+		}
+		if _, err := f.call.Read(one[:]); err != nil {
+			f.t.Fatalf("waiting for %v: %v", protocol.OpcodeName(req.Header.Opcode), err)
+		}
+	}
+	elem := f.mem[usedOff+4+8*slot:]
+	if id := binary.LittleEndian.Uint32(elem); id != uint32(2*slot) {
+		f.t.Fatalf("used id %d, want %d", id, 2*slot)
+	}
+	n := binary.LittleEndian.Uint32(elem[4:])
+	return f.mem[out : out+uint64(n)]
+}
+
+type attrFS struct {
+	fuse.RawFileSystem
+
+	written []byte
+}
+
+func (fs *attrFS) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	out.Mode = fuse.S_IFDIR | 0755
+	out.Ino = input.NodeId
+	return fuse.OK
+}
+
+func (fs *attrFS) WriteSegments(input *fuse.WriteIn, data fuse.WriteData) (uint32, fuse.Status) {
+	fs.written = data.Bytes(nil)
+	return uint32(data.Len()), fuse.OK
+}
+
+func TestDevice(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "vhost.sock")
+	dev, err := Listen(sock, nil)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	memFile, err := os.Create(filepath.Join(dir, "guest-memory"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer memFile.Close()
+	if err := memFile.Truncate(memSize); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	mem, err := syscall.Mmap(int(memFile.Fd()), 0, memSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		t.Fatalf("Mmap: %v", err)
+	}
+	defer syscall.Munmap(mem)
+
+	f := &frontEnd{t: t, conn: conn, mem: mem}
+	f.setup(memFile)
+	defer f.kick.Close()
+	defer f.call.Close()
+
+	fs := &attrFS{RawFileSystem: fuse.NewDefaultRawFileSystem()}
+	served := make(chan error, 1)
+	go func() {
+		srv, err := fuse.NewServerTransport(fs, dev, nil)
+		if err != nil {
+			served <- err
+			return
+		}
+		srv.Serve()
+		served <- nil
+	}()
+
+	init := &protocol.Request{
+		Header: fuse.InHeader{Opcode: protocol.OP_INIT},
+		In: &fuse.InitIn{
+			Major:        protocol.KERNEL_VERSION,
+			Minor:        protocol.MAXIMUM_MINOR_VERSION,
+			MaxReadAhead: 128 << 10,
+		},
+	}
+	reply, err := protocol.UnmarshalReply(init, f.roundTrip(init), protocol.MAXIMUM_MINOR_VERSION)
+	if err != nil || reply.Header.Status != 0 {
+		t.Fatalf("INIT: %v, %v", reply, err)
+	}
+
+	getattr := &protocol.Request{
+		Header: fuse.InHeader{Opcode: protocol.OP_GETATTR, NodeId: fuse.FUSE_ROOT_ID},
+		In:     &fuse.GetAttrIn{},
+	}
+	reply, err = protocol.UnmarshalReply(getattr, f.roundTrip(getattr), protocol.MAXIMUM_MINOR_VERSION)
+	if err != nil || reply.Header.Status != 0 {
+		t.Fatalf("GETATTR: %v, %v", reply, err)
+	}
+	if out := reply.Out.(*fuse.AttrOut); out.Mode != fuse.S_IFDIR|0755 || out.Ino != fuse.FUSE_ROOT_ID {
+		t.Errorf("GETATTR: got %v", out)
+	}
+
+	// WRITE data comes straight from guest memory.
+	write := &protocol.Request{
+		Header: fuse.InHeader{Opcode: protocol.OP_WRITE, NodeId: 2},
+		In:     &fuse.WriteIn{Size: 5},
+		Data:   []byte("hello"),
+	}
+	reply, err = protocol.UnmarshalReply(write, f.roundTrip(write), protocol.MAXIMUM_MINOR_VERSION)
+	if err != nil || reply.Header.Status != 0 {
+		t.Fatalf("WRITE: %v, %v", reply, err)
+	}
+	if out := reply.Out.(*fuse.WriteOut); out.Size != 5 || string(fs.written) != "hello" {
+		t.Errorf("WRITE: got %v, data %q", out, fs.written)
+	}
+
+	// FORGET has no reply, but the chain comes back.
+	forget := &protocol.Request{
+		Header: fuse.InHeader{Opcode: protocol.OP_FORGET, NodeId: 2},
+		In:     &fuse.ForgetIn{Nlookup: 1},
+	}
+	if got := f.roundTrip(forget); len(got) != 0 {
+		t.Errorf("FORGET: got reply %x", got)
+	}
+
+	// The VM going away stops the server.
+	conn.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("NewServerTransport: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package virtiofs exports a file system into virtual machines as a
+// virtio-fs device, rather than mounting it on the host. It
+// implements the back-end of the vhost-user protocol: the virtual
+// machine monitor, eg. QEMU, connects to a unix socket, shares the
+// guest memory, and the guest kernel's FUSE requests are read from
+// the device's virtqueues directly.
+//
+// A Device is a fuse.Transport, so any RawFileSystem can be served
+// with it, eg.
+//
+//	dev, err := virtiofs.Listen("/tmp/vhost-fs.sock", nil)
+//	// Start the VM, and mount the file system in the guest.
+//	server, err := fuse.NewServerTransport(fs, dev, nil)
+//	server.Serve()
+//
+// with QEMU started as
+//
+//	qemu-system-x86_64 \
+//	  -chardev socket,id=char0,path=/tmp/vhost-fs.sock \
+//	  -device vhost-user-fs-pci,chardev=char0,tag=myfs \
+//	  -object memory-backend-memfd,id=mem,size=4G,share=on \
+//	  -numa node,memdev=mem ...
+//
+// and mounted in the guest with "mount -t virtiofs myfs /mnt".
+//
+// Not supported are DAX windows, the notification queue (so
+// Server.InodeNotify and friends fail with ENOSYS), live migration,
+// and indirect or packed virtqueues.
+package virtiofs
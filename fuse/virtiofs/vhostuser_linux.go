@@ -0,0 +1,187 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package virtiofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+// The vhost-user protocol, as described in QEMU's
+// docs/interop/vhost-user.rst. Messages are in host byte order.
+
+const (
+	vhostUserVersion   = 0x1
+	vhostUserReply     = 0x4
+	vhostUserNeedReply = 0x8
+
+	msgGetFeatures         = 1
+	msgSetFeatures         = 2
+	msgSetOwner            = 3
+	msgResetOwner          = 4
+	msgSetMemTable         = 5
+	msgSetVringNum         = 8
+	msgSetVringAddr        = 9
+	msgSetVringBase        = 10
+	msgGetVringBase        = 11
+	msgSetVringKick        = 12
+	msgSetVringCall        = 13
+	msgSetVringErr         = 14
+	msgGetProtocolFeatures = 15
+	msgSetProtocolFeatures = 16
+	msgGetQueueNum         = 17
+	msgSetVringEnable      = 18
+
+	// In the payload of SET_VRING_KICK, CALL and ERR.
+	vringIndexMask = 0xff
+	vringNoFd      = 0x100
+
+	// Feature bits.
+	virtioFVersion1         = 1 << 32
+	vhostUserFProtocolFeats = 1 << 30
+
+	// Protocol feature bits.
+	protocolFeatureMQ       = 1 << 0
+	protocolFeatureReplyAck = 1 << 3
+
+	headerSize = 12
+
+	// VHOST_MEMORY_BASELINE_NREGIONS
+	maxRegions = 8
+)
+
+var byteOrder = binary.NativeEndian
+
+var msgNames = map[uint32]string{
+	msgGetFeatures:         "GET_FEATURES",
+	msgSetFeatures:         "SET_FEATURES",
+	msgSetOwner:            "SET_OWNER",
+	msgResetOwner:          "RESET_OWNER",
+	msgSetMemTable:         "SET_MEM_TABLE",
+	msgSetVringNum:         "SET_VRING_NUM",
+	msgSetVringAddr:        "SET_VRING_ADDR",
+	msgSetVringBase:        "SET_VRING_BASE",
+	msgGetVringBase:        "GET_VRING_BASE",
+	msgSetVringKick:        "SET_VRING_KICK",
+	msgSetVringCall:        "SET_VRING_CALL",
+	msgSetVringErr:         "SET_VRING_ERR",
+	msgGetProtocolFeatures: "GET_PROTOCOL_FEATURES",
+	msgSetProtocolFeatures: "SET_PROTOCOL_FEATURES",
+	msgGetQueueNum:         "GET_QUEUE_NUM",
+	msgSetVringEnable:      "SET_VRING_ENABLE",
+}
+
+func msgName(req uint32) string {
+	if n, ok := msgNames[req]; ok {
+		return n
+	}
+	return fmt.Sprintf("request %d", req)
+}
+
+type message struct {
+	request uint32
+	flags   uint32
+	payload []byte
+	fds     []int
+}
+
+// readMessage reads a message, and the file descriptors passed
+// along with it.
+func readMessage(conn *net.UnixConn) (*message, error) {
+	var hdr [headerSize]byte
+	oob := make([]byte, syscall.CmsgSpace(maxRegions*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(hdr[:], oob)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, io.EOF
+	}
+	msg := &message{}
+	if oobn > 0 {
+		cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, err
+		}
+		for i := range cmsgs {
+			fds, err := syscall.ParseUnixRights(&cmsgs[i])
+			if err != nil {
+				return nil, err
+			}
+			msg.fds = append(msg.fds, fds...)
+		}
+	}
+	if n < headerSize {
+		if _, err := io.ReadFull(conn, hdr[n:]); err != nil {
+			msg.closeFds()
+			return nil, err
+		}
+	}
+
+	msg.request = byteOrder.Uint32(hdr[0:])
+	msg.flags = byteOrder.Uint32(hdr[4:])
+	size := byteOrder.Uint32(hdr[8:])
+	if size > 4096 {
+		msg.closeFds()
+		return nil, fmt.Errorf("%s: payload of %d bytes too large", msgName(msg.request), size)
+	}
+	msg.payload = make([]byte, size)
+	if _, err := io.ReadFull(conn, msg.payload); err != nil {
+		msg.closeFds()
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *message) closeFds() {
+	for _, fd := range m.fds {
+		syscall.Close(fd)
+	}
+	m.fds = nil
+}
+
+// u64 returns the payload of messages that carry a single number.
+func (m *message) u64() (uint64, error) {
+	if len(m.payload) < 8 {
+		return 0, fmt.Errorf("%s: short payload", msgName(m.request))
+	}
+	return byteOrder.Uint64(m.payload), nil
+}
+
+// vringState returns the payload of SET_VRING_NUM, SET_VRING_BASE,
+// GET_VRING_BASE and SET_VRING_ENABLE.
+func (m *message) vringState() (index, num uint32, err error) {
+	if len(m.payload) < 8 {
+		return 0, 0, fmt.Errorf("%s: short payload", msgName(m.request))
+	}
+	return byteOrder.Uint32(m.payload), byteOrder.Uint32(m.payload[4:]), nil
+}
+
+// writeReply sends the reply to m.
+func writeReply(conn *net.UnixConn, m *message, payload []byte) error {
+	buf := make([]byte, headerSize+len(payload))
+	byteOrder.PutUint32(buf[0:], m.request)
+	byteOrder.PutUint32(buf[4:], vhostUserVersion|vhostUserReply)
+	byteOrder.PutUint32(buf[8:], uint32(len(payload)))
+	copy(buf[headerSize:], payload)
+	_, err := conn.Write(buf)
+	return err
+}
+
+func u64Payload(v uint64) []byte {
+	b := make([]byte, 8)
+	byteOrder.PutUint64(b, v)
+	return b
+}
+
+func vringStatePayload(index, num uint32) []byte {
+	b := make([]byte, 8)
+	byteOrder.PutUint32(b, index)
+	byteOrder.PutUint32(b[4:], num)
+	return b
+}
@@ -0,0 +1,293 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package virtiofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// Split virtqueues, as described in the virtio 1.x specification.
+// Rings are little-endian.
+
+const (
+	descFNext  = 1
+	descFWrite = 2
+
+	availFNoInterrupt = 1
+
+	descSize = 16
+)
+
+// region is a piece of guest memory, shared by the front-end.
+type region struct {
+	guestAddr uint64
+	userAddr  uint64
+	size      uint64
+
+	// The mapping, of which the region starts at offset.
+	mapping []byte
+	offset  uint64
+}
+
+func (r *region) bytes(off, n uint64) []byte {
+	start := r.offset + off
+	return r.mapping[start : start+n : start+n]
+}
+
+// memory is the guest memory table.
+type memory []region
+
+func (m memory) unmap() {
+	for _, r := range m {
+		syscall.Munmap(r.mapping)
+	}
+}
+
+// guest returns n bytes at guest physical address addr.
+func (m memory) guest(addr, n uint64) ([]byte, error) {
+	for i := range m {
+		r := &m[i]
+		if addr >= r.guestAddr && addr-r.guestAddr <= r.size && n <= r.size-(addr-r.guestAddr) {
+			return r.bytes(addr-r.guestAddr, n), nil
+		}
+	}
+	return nil, fmt.Errorf("guest address %#x+%d not mapped", addr, n)
+}
+
+// user returns n bytes at addr in the front-end's address space,
+// which is how vring addresses are given.
+func (m memory) user(addr, n uint64) ([]byte, error) {
+	for i := range m {
+		r := &m[i]
+		if addr >= r.userAddr && addr-r.userAddr <= r.size && n <= r.size-(addr-r.userAddr) {
+			return r.bytes(addr-r.userAddr, n), nil
+		}
+	}
+	return nil, fmt.Errorf("front-end address %#x+%d not mapped", addr, n)
+}
+
+// The ring headers hold a flags and an index field. Both are
+// accessed with one atomic 32-bit operation, which also orders the
+// accesses to the ring entries.
+func loadRingHeader(ring []byte) (flags, idx uint16) {
+	v := atomic.LoadUint32((*uint32)(unsafe.Pointer(&ring[0])))
+	var b [4]byte
+	binary.NativeEndian.PutUint32(b[:], v)
+	return binary.LittleEndian.Uint16(b[0:]), binary.LittleEndian.Uint16(b[2:])
+}
+
+func storeRingHeader(ring []byte, flags, idx uint16) {
+	var b [4]byte
+	binary.LittleEndian.PutUint16(b[0:], flags)
+	binary.LittleEndian.PutUint16(b[2:], idx)
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&ring[0])), binary.NativeEndian.Uint32(b[:]))
+}
+
+// buffer is a guest buffer from a descriptor.
+type buffer struct {
+	addr uint64
+	len  uint32
+}
+
+// element is a descriptor chain popped from a queue: in holds the
+// request, out has room for the reply.
+type element struct {
+	q    *queue
+	head uint16
+	in   []buffer
+	out  []buffer
+
+	// Set if ReadRequestSegments returned guest memory for the
+	// request, and holds memMu until its reply.
+	memHeld bool
+}
+
+// queue is a virtqueue. The front-end configures it with
+// SET_VRING_*; it runs from SET_VRING_KICK until GET_VRING_BASE.
+type queue struct {
+	dev   *Device
+	index int
+
+	mu                            sync.Mutex
+	num                           uint16
+	descAddr, availAddr, usedAddr uint64
+	enabled                       bool
+
+	// The rings, translated from the addresses above.
+	desc, avail, used []byte
+
+	lastAvail uint16
+	usedIdx   uint16
+
+	kick, call *os.File
+
+	// Non-nil while running. quit asks the goroutine to exit, and
+	// stopped is closed when it has.
+	quit, stopped chan struct{}
+}
+
+// mapRings translates the ring addresses. The caller holds q.mu and
+// the Device's memory lock.
+func (q *queue) mapRings(mem memory) error {
+	n := uint64(q.num)
+	var err error
+	if q.desc, err = mem.user(q.descAddr, descSize*n); err != nil {
+		return err
+	}
+	if q.avail, err = mem.user(q.availAddr, 6+2*n); err != nil {
+		return err
+	}
+	if q.used, err = mem.user(q.usedAddr, 6+8*n); err != nil {
+		return err
+	}
+	if q.availAddr%4 != 0 || q.usedAddr%4 != 0 {
+		return fmt.Errorf("rings must be 4-byte aligned")
+	}
+	return nil
+}
+
+// start starts processing, if the queue is fully configured. The
+// caller holds q.mu and the Device's memory lock.
+func (q *queue) start(mem memory) error {
+	if q.stopped != nil || q.kick == nil || !q.enabled || q.num == 0 {
+		return nil
+	}
+	if err := q.mapRings(mem); err != nil {
+		return fmt.Errorf("queue %d: %v", q.index, err)
+	}
+	// The front-end may have used the queue before, eg. when
+	// restarted after GET_VRING_BASE.
+	_, q.usedIdx = loadRingHeader(q.used)
+	q.quit = make(chan struct{})
+	q.stopped = make(chan struct{})
+	go q.run(q.kick, q.quit, q.stopped)
+	return nil
+}
+
+// stop stops processing, and waits for it. The caller holds q.mu,
+// but not the Device's memory lock.
+func (q *queue) stop() {
+	if q.kick != nil {
+		q.kick.Close()
+		q.kick = nil
+	}
+	if q.stopped != nil {
+		close(q.quit)
+		stopped := q.stopped
+		q.quit, q.stopped = nil, nil
+		q.mu.Unlock()
+		<-stopped
+		q.mu.Lock()
+	}
+	q.desc, q.avail, q.used = nil, nil, nil
+}
+
+// run waits for kicks, and hands the new descriptor chains to the
+// Device.
+func (q *queue) run(kick *os.File, quit, stopped chan struct{}) {
+	defer close(stopped)
+	var buf [8]byte
+	for {
+		for _, e := range q.pop() {
+			select {
+			case q.dev.reqs <- e:
+			case <-quit:
+				return
+			}
+		}
+		if _, err := kick.Read(buf[:]); err != nil {
+			return
+		}
+	}
+}
+
+// pop takes the available descriptor chains off the queue.
+func (q *queue) pop() []*element {
+	q.dev.memMu.RLock()
+	defer q.dev.memMu.RUnlock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.avail == nil {
+		return nil
+	}
+
+	_, availIdx := loadRingHeader(q.avail)
+	var r []*element
+	for ; q.lastAvail != availIdx; q.lastAvail++ {
+		slot := 4 + 2*uint64(q.lastAvail%q.num)
+		head := binary.LittleEndian.Uint16(q.avail[slot:])
+		e, err := q.chain(head)
+		if err != nil {
+			q.dev.opts.Logger.Printf("virtiofs: queue %d: %v", q.index, err)
+			q.pushLocked(head, 0)
+			continue
+		}
+		r = append(r, e)
+	}
+	return r
+}
+
+// chain reads the descriptor chain starting at head.
+func (q *queue) chain(head uint16) (*element, error) {
+	e := &element{q: q, head: head}
+	idx := head
+	for i := 0; ; i++ {
+		if idx >= q.num || i >= int(q.num) {
+			return nil, fmt.Errorf("bad descriptor chain at %d", head)
+		}
+		d := q.desc[descSize*uint64(idx):]
+		b := buffer{
+			addr: binary.LittleEndian.Uint64(d[0:]),
+			len:  binary.LittleEndian.Uint32(d[8:]),
+		}
+		flags := binary.LittleEndian.Uint16(d[12:])
+		if flags&descFWrite != 0 {
+			e.out = append(e.out, b)
+		} else if len(e.out) > 0 {
+			return nil, fmt.Errorf("readable descriptor after writable one in chain at %d", head)
+		} else {
+			e.in = append(e.in, b)
+		}
+		if flags&descFNext == 0 {
+			return e, nil
+		}
+		idx = binary.LittleEndian.Uint16(d[14:])
+	}
+}
+
+// push returns a chain to the front-end, with n bytes written, and
+// signals it.
+func (q *queue) push(head uint16, n uint32) {
+	q.dev.memMu.RLock()
+	defer q.dev.memMu.RUnlock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pushLocked(head, n)
+}
+
+func (q *queue) pushLocked(head uint16, n uint32) {
+	if q.used == nil {
+		// Stopped meanwhile.
+		return
+	}
+	slot := 4 + 8*uint64(q.usedIdx%q.num)
+	binary.LittleEndian.PutUint32(q.used[slot:], uint32(head))
+	binary.LittleEndian.PutUint32(q.used[slot+4:], n)
+	q.usedIdx++
+	storeRingHeader(q.used, 0, q.usedIdx)
+
+	if flags, _ := loadRingHeader(q.avail); flags&availFNoInterrupt != 0 || q.call == nil {
+		return
+	}
+	var one [8]byte
+	binary.NativeEndian.PutUint64(one[:], 1)
+	q.call.Write(one[:])
+}
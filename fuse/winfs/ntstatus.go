@@ -0,0 +1,102 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package winfs
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// NTStatus is the result code of a Windows file system operation.
+type NTStatus uint32
+
+const (
+	STATUS_SUCCESS               = NTStatus(0x00000000)
+	STATUS_NOT_IMPLEMENTED       = NTStatus(0xC0000002)
+	STATUS_INVALID_HANDLE        = NTStatus(0xC0000008)
+	STATUS_INVALID_PARAMETER     = NTStatus(0xC000000D)
+	STATUS_END_OF_FILE           = NTStatus(0xC0000011)
+	STATUS_ACCESS_DENIED         = NTStatus(0xC0000022)
+	STATUS_OBJECT_NAME_INVALID   = NTStatus(0xC0000033)
+	STATUS_OBJECT_NAME_NOT_FOUND = NTStatus(0xC0000034)
+	STATUS_OBJECT_NAME_COLLISION = NTStatus(0xC0000035)
+	STATUS_OBJECT_PATH_NOT_FOUND = NTStatus(0xC000003A)
+	STATUS_SHARING_VIOLATION     = NTStatus(0xC0000043)
+	STATUS_DISK_FULL             = NTStatus(0xC000007F)
+	STATUS_MEDIA_WRITE_PROTECTED = NTStatus(0xC00000A2)
+	STATUS_FILE_IS_A_DIRECTORY   = NTStatus(0xC00000BA)
+	STATUS_NOT_SUPPORTED         = NTStatus(0xC00000BB)
+	STATUS_NOT_SAME_DEVICE       = NTStatus(0xC00000D4)
+	STATUS_DIRECTORY_NOT_EMPTY   = NTStatus(0xC0000101)
+	STATUS_NOT_A_DIRECTORY       = NTStatus(0xC0000103)
+	STATUS_IO_DEVICE_ERROR       = NTStatus(0xC0000185)
+	STATUS_CANNOT_DELETE         = NTStatus(0xC0000121)
+)
+
+var ntStatusNames = map[NTStatus]string{
+	STATUS_SUCCESS:               "SUCCESS",
+	STATUS_NOT_IMPLEMENTED:       "NOT_IMPLEMENTED",
+	STATUS_INVALID_HANDLE:        "INVALID_HANDLE",
+	STATUS_INVALID_PARAMETER:     "INVALID_PARAMETER",
+	STATUS_END_OF_FILE:           "END_OF_FILE",
+	STATUS_ACCESS_DENIED:         "ACCESS_DENIED",
+	STATUS_OBJECT_NAME_INVALID:   "OBJECT_NAME_INVALID",
+	STATUS_OBJECT_NAME_NOT_FOUND: "OBJECT_NAME_NOT_FOUND",
+	STATUS_OBJECT_NAME_COLLISION: "OBJECT_NAME_COLLISION",
+	STATUS_OBJECT_PATH_NOT_FOUND: "OBJECT_PATH_NOT_FOUND",
+	STATUS_SHARING_VIOLATION:     "SHARING_VIOLATION",
+	STATUS_DISK_FULL:             "DISK_FULL",
+	STATUS_MEDIA_WRITE_PROTECTED: "MEDIA_WRITE_PROTECTED",
+	STATUS_FILE_IS_A_DIRECTORY:   "FILE_IS_A_DIRECTORY",
+	STATUS_NOT_SUPPORTED:         "NOT_SUPPORTED",
+	STATUS_NOT_SAME_DEVICE:       "NOT_SAME_DEVICE",
+	STATUS_DIRECTORY_NOT_EMPTY:   "DIRECTORY_NOT_EMPTY",
+	STATUS_NOT_A_DIRECTORY:       "NOT_A_DIRECTORY",
+	STATUS_IO_DEVICE_ERROR:       "IO_DEVICE_ERROR",
+	STATUS_CANNOT_DELETE:         "CANNOT_DELETE",
+}
+
+func (s NTStatus) String() string {
+	if n, ok := ntStatusNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("NTSTATUS(%#x)", uint32(s))
+}
+
+// Ok reports whether s is a success code.
+func (s NTStatus) Ok() bool {
+	return s == STATUS_SUCCESS
+}
+
+var statusMap = map[fuse.Status]NTStatus{
+	fuse.OK:                           STATUS_SUCCESS,
+	fuse.ENOENT:                       STATUS_OBJECT_NAME_NOT_FOUND,
+	fuse.ENOTDIR:                      STATUS_NOT_A_DIRECTORY,
+	fuse.Status(syscall.EEXIST):       STATUS_OBJECT_NAME_COLLISION,
+	fuse.EACCES:                       STATUS_ACCESS_DENIED,
+	fuse.EPERM:                        STATUS_ACCESS_DENIED,
+	fuse.Status(syscall.ENOTEMPTY):    STATUS_DIRECTORY_NOT_EMPTY,
+	fuse.Status(syscall.EISDIR):       STATUS_FILE_IS_A_DIRECTORY,
+	fuse.EINVAL:                       STATUS_INVALID_PARAMETER,
+	fuse.ENOSYS:                       STATUS_NOT_IMPLEMENTED,
+	fuse.Status(syscall.ENOTSUP):      STATUS_NOT_SUPPORTED,
+	fuse.EROFS:                        STATUS_MEDIA_WRITE_PROTECTED,
+	fuse.Status(syscall.ENOSPC):       STATUS_DISK_FULL,
+	fuse.EBADF:                        STATUS_INVALID_HANDLE,
+	fuse.EXDEV:                        STATUS_NOT_SAME_DEVICE,
+	fuse.Status(syscall.ENAMETOOLONG): STATUS_OBJECT_NAME_INVALID,
+	fuse.EBUSY:                        STATUS_SHARING_VIOLATION,
+}
+
+// ToNTStatus translates a FUSE status into the closest NTSTATUS.
+// Unknown errors become STATUS_IO_DEVICE_ERROR.
+func ToNTStatus(code fuse.Status) NTStatus {
+	if s, ok := statusMap[code]; ok {
+		return s
+	}
+	return STATUS_IO_DEVICE_ERROR
+}
@@ -0,0 +1,528 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package winfs maps the pathfs.FileSystem interface onto the
+// operations of Windows user-mode file system drivers, so a file
+// system written for this package can also be offered as a Windows
+// drive.
+//
+// A Volume has a method for each callback of WinFsp's
+// FSP_FILE_SYSTEM_INTERFACE (Dokan's DOKAN_OPERATIONS are similar),
+// taking and returning Go values instead of driver structs: Windows
+// paths, attribute bits, FILETIMEs and NTSTATUS codes. A driver
+// binding only has to marshal these.
+//
+// The binding to the WinFsp DLL itself is not part of this package:
+// package fuse does not build for Windows yet. Not supported are
+// security descriptors (files are reported with the access of
+// Options.Owner), reparse points, streams and extended attributes.
+package winfs
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+// File attribute bits, as in FILE_ATTRIBUTE_*.
+const (
+	FILE_ATTRIBUTE_READONLY  = 0x1
+	FILE_ATTRIBUTE_HIDDEN    = 0x2
+	FILE_ATTRIBUTE_DIRECTORY = 0x10
+	FILE_ATTRIBUTE_ARCHIVE   = 0x20
+	FILE_ATTRIBUTE_NORMAL    = 0x80
+)
+
+// Create options, as in FILE_*.
+const (
+	FILE_DIRECTORY_FILE     = 0x1
+	FILE_NON_DIRECTORY_FILE = 0x40
+)
+
+// Access rights, as in FILE_*.
+const (
+	FILE_READ_DATA   = 0x1
+	FILE_WRITE_DATA  = 0x2
+	FILE_APPEND_DATA = 0x4
+)
+
+// CleanupDelete is the Cleanup flag for deleting the file, as in
+// FspCleanupDelete.
+const CleanupDelete = 0x1
+
+// FileInfo describes a file, like FSP_FSCTL_FILE_INFO. Times are
+// FILETIMEs; see FileTime.
+type FileInfo struct {
+	FileAttributes uint32
+	AllocationSize uint64
+	FileSize       uint64
+	CreationTime   uint64
+	LastAccessTime uint64
+	LastWriteTime  uint64
+	ChangeTime     uint64
+	IndexNumber    uint64
+	HardLinks      uint32
+}
+
+// DirInfo is a directory entry.
+type DirInfo struct {
+	Name string
+	FileInfo
+}
+
+// VolumeInfo describes the volume, like FSP_FSCTL_VOLUME_INFO.
+type VolumeInfo struct {
+	TotalSize   uint64
+	FreeSize    uint64
+	VolumeLabel string
+}
+
+// Options configures a Volume.
+type Options struct {
+	// Owner is passed to the FileSystem for all calls, as
+	// Windows callers have SIDs rather than uids.
+	Owner fuse.Owner
+
+	// HideDotFiles sets FILE_ATTRIBUTE_HIDDEN on names starting
+	// with a dot.
+	HideDotFiles bool
+
+	// VolumeLabel is reported by GetVolumeInfo.
+	VolumeLabel string
+}
+
+// Handle is an open file or directory, ie. WinFsp's FileContext.
+type Handle struct {
+	path  string
+	isDir bool
+
+	// file is nil for directories.
+	file nodefs.File
+}
+
+// Volume translates Windows file system calls into calls on a
+// pathfs.FileSystem. It is safe for concurrent use.
+type Volume struct {
+	fs   pathfs.FileSystem
+	opts Options
+
+	// renameMu serializes renames with the opens that depend
+	// on the path of the handle.
+	renameMu sync.RWMutex
+}
+
+// NewVolume returns a Volume for fs.
+func NewVolume(fs pathfs.FileSystem, opts *Options) *Volume {
+	v := &Volume{fs: fs}
+	if opts != nil {
+		v.opts = *opts
+	}
+	return v
+}
+
+func (v *Volume) context() *fuse.Context {
+	return &fuse.Context{Owner: v.opts.Owner}
+}
+
+// ToPath converts a Windows path, like `\dir\file.txt`, into a path
+// relative to the root of the FileSystem.
+func ToPath(name string) string {
+	return strings.Trim(strings.Replace(name, `\`, "/", -1), "/")
+}
+
+// FileTime converts t into a FILETIME: 100-nanosecond intervals
+// since 1601.
+func FileTime(t time.Time) uint64 {
+	const epochDiff = 116444736000000000
+	return uint64(t.UnixNano()/100 + epochDiff)
+}
+
+// fromFileTime is the inverse of FileTime. 0 means "unchanged" in
+// calls like SetBasicInfo, and gives nil.
+func fromFileTime(ft uint64) *time.Time {
+	if ft == 0 {
+		return nil
+	}
+	const epochDiff = 116444736000000000
+	t := time.Unix(0, (int64(ft)-epochDiff)*100)
+	return &t
+}
+
+func (v *Volume) fileInfo(path string, a *fuse.Attr) FileInfo {
+	fi := FileInfo{
+		FileSize:       a.Size,
+		AllocationSize: a.Blocks * 512,
+		// There is no creation time; ctime comes closest.
+		CreationTime:   FileTime(a.ChangeTime()),
+		LastAccessTime: FileTime(a.AccessTime()),
+		LastWriteTime:  FileTime(a.ModTime()),
+		ChangeTime:     FileTime(a.ChangeTime()),
+		IndexNumber:    a.Ino,
+		HardLinks:      a.Nlink,
+	}
+	if a.IsDir() {
+		fi.FileAttributes |= FILE_ATTRIBUTE_DIRECTORY
+	}
+	if a.Mode&0222 == 0 {
+		fi.FileAttributes |= FILE_ATTRIBUTE_READONLY
+	}
+	base := path[strings.LastIndex(path, "/")+1:]
+	if v.opts.HideDotFiles && strings.HasPrefix(base, ".") {
+		fi.FileAttributes |= FILE_ATTRIBUTE_HIDDEN
+	}
+	if fi.FileAttributes == 0 {
+		fi.FileAttributes = FILE_ATTRIBUTE_NORMAL
+	}
+	return fi
+}
+
+func (v *Volume) stat(h *Handle) (FileInfo, NTStatus) {
+	var a *fuse.Attr
+	code := fuse.ENOSYS
+	if h.file != nil {
+		a = &fuse.Attr{}
+		code = h.file.GetAttr(a)
+	}
+	if code == fuse.ENOSYS {
+		a, code = v.fs.GetAttr(h.path, v.context())
+	}
+	if !code.Ok() {
+		return FileInfo{}, ToNTStatus(code)
+	}
+	return v.fileInfo(h.path, a), STATUS_SUCCESS
+}
+
+// GetVolumeInfo returns the size of the volume.
+func (v *Volume) GetVolumeInfo() (VolumeInfo, NTStatus) {
+	info := VolumeInfo{VolumeLabel: v.opts.VolumeLabel}
+	if s := v.fs.StatFs(""); s != nil {
+		info.TotalSize = s.Blocks * uint64(s.Bsize)
+		info.FreeSize = s.Bavail * uint64(s.Bsize)
+	}
+	return info, STATUS_SUCCESS
+}
+
+// openFlags translates access rights into open(2) flags.
+func openFlags(grantedAccess uint32) uint32 {
+	write := grantedAccess&(FILE_WRITE_DATA|FILE_APPEND_DATA) != 0
+	read := grantedAccess&FILE_READ_DATA != 0
+	switch {
+	case write && read:
+		return syscall.O_RDWR
+	case write:
+		return syscall.O_WRONLY
+	}
+	return syscall.O_RDONLY
+}
+
+// Create creates a file, or a directory if createOptions has
+// FILE_DIRECTORY_FILE, and opens it.
+func (v *Volume) Create(name string, createOptions, grantedAccess, fileAttributes uint32) (*Handle, FileInfo, NTStatus) {
+	v.renameMu.RLock()
+	defer v.renameMu.RUnlock()
+	path := ToPath(name)
+	mode := uint32(0644)
+	if fileAttributes&FILE_ATTRIBUTE_READONLY != 0 {
+		mode = 0444
+	}
+
+	h := &Handle{path: path}
+	if createOptions&FILE_DIRECTORY_FILE != 0 {
+		if code := v.fs.Mkdir(path, mode|0111, v.context()); !code.Ok() {
+			return nil, FileInfo{}, ToNTStatus(code)
+		}
+		h.isDir = true
+	} else {
+		f, code := v.fs.Create(path, openFlags(grantedAccess)|syscall.O_EXCL, mode, v.context())
+		if !code.Ok() {
+			return nil, FileInfo{}, ToNTStatus(code)
+		}
+		h.file = f
+	}
+	fi, st := v.stat(h)
+	return h, fi, st
+}
+
+// Open opens an existing file or directory.
+func (v *Volume) Open(name string, createOptions, grantedAccess uint32) (*Handle, FileInfo, NTStatus) {
+	v.renameMu.RLock()
+	defer v.renameMu.RUnlock()
+	path := ToPath(name)
+	a, code := v.fs.GetAttr(path, v.context())
+	if !code.Ok() {
+		return nil, FileInfo{}, ToNTStatus(code)
+	}
+	h := &Handle{path: path, isDir: a.IsDir()}
+	switch {
+	case h.isDir && createOptions&FILE_NON_DIRECTORY_FILE != 0:
+		return nil, FileInfo{}, STATUS_FILE_IS_A_DIRECTORY
+	case !h.isDir && createOptions&FILE_DIRECTORY_FILE != 0:
+		return nil, FileInfo{}, STATUS_NOT_A_DIRECTORY
+	case !h.isDir:
+		f, code := v.fs.Open(path, openFlags(grantedAccess), v.context())
+		if !code.Ok() {
+			return nil, FileInfo{}, ToNTStatus(code)
+		}
+		h.file = f
+	}
+	return h, v.fileInfo(path, a), STATUS_SUCCESS
+}
+
+// Overwrite truncates an open file, for CREATE_ALWAYS and
+// TRUNCATE_EXISTING.
+func (v *Volume) Overwrite(h *Handle, fileAttributes uint32) (FileInfo, NTStatus) {
+	if h.isDir {
+		return FileInfo{}, STATUS_FILE_IS_A_DIRECTORY
+	}
+	code := h.file.Truncate(0)
+	if code == fuse.ENOSYS {
+		code = v.fs.Truncate(h.path, 0, v.context())
+	}
+	if !code.Ok() {
+		return FileInfo{}, ToNTStatus(code)
+	}
+	return v.stat(h)
+}
+
+// Cleanup is called when the last handle of the file is closed;
+// with CleanupDelete in flags, the file is deleted.
+func (v *Volume) Cleanup(h *Handle, flags uint32) {
+	if flags&CleanupDelete == 0 {
+		return
+	}
+	v.renameMu.RLock()
+	defer v.renameMu.RUnlock()
+	if h.isDir {
+		v.fs.Rmdir(h.path, v.context())
+	} else {
+		v.fs.Unlink(h.path, v.context())
+	}
+}
+
+// Close releases the handle.
+func (v *Volume) Close(h *Handle) {
+	if h.file != nil {
+		h.file.Flush()
+		h.file.Release()
+	}
+}
+
+// Read reads into buf from off. At or beyond the end of the file,
+// it returns STATUS_END_OF_FILE.
+func (v *Volume) Read(h *Handle, buf []byte, off uint64) (int, NTStatus) {
+	if h.isDir {
+		return 0, STATUS_FILE_IS_A_DIRECTORY
+	}
+	res, code := h.file.Read(buf, int64(off))
+	if !code.Ok() {
+		return 0, ToNTStatus(code)
+	}
+	data, code := res.Bytes(buf)
+	res.Done()
+	if !code.Ok() {
+		return 0, ToNTStatus(code)
+	}
+	if len(data) == 0 && len(buf) > 0 {
+		return 0, STATUS_END_OF_FILE
+	}
+	return copy(buf, data), STATUS_SUCCESS
+}
+
+// Write writes data at off, or at the end of the file if
+// writeToEnd is set. With constrainedIo, the file is not extended.
+func (v *Volume) Write(h *Handle, data []byte, off uint64, writeToEnd, constrainedIo bool) (int, FileInfo, NTStatus) {
+	if h.isDir {
+		return 0, FileInfo{}, STATUS_FILE_IS_A_DIRECTORY
+	}
+	if writeToEnd || constrainedIo {
+		fi, st := v.stat(h)
+		if !st.Ok() {
+			return 0, FileInfo{}, st
+		}
+		if writeToEnd {
+			off = fi.FileSize
+		}
+		if constrainedIo {
+			if off >= fi.FileSize {
+				return 0, fi, STATUS_SUCCESS
+			}
+			if end := off + uint64(len(data)); end > fi.FileSize {
+				data = data[:fi.FileSize-off]
+			}
+		}
+	}
+	n, code := h.file.Write(data, int64(off))
+	if !code.Ok() {
+		return 0, FileInfo{}, ToNTStatus(code)
+	}
+	fi, st := v.stat(h)
+	return int(n), fi, st
+}
+
+// Flush flushes a file. h is nil when the whole volume is to be
+// flushed, which is a no-op.
+func (v *Volume) Flush(h *Handle) (FileInfo, NTStatus) {
+	if h == nil || h.isDir {
+		return FileInfo{}, STATUS_SUCCESS
+	}
+	if code := h.file.Fsync(0); !code.Ok() && code != fuse.ENOSYS {
+		return FileInfo{}, ToNTStatus(code)
+	}
+	return v.stat(h)
+}
+
+// GetFileInfo returns the attributes of an open file.
+func (v *Volume) GetFileInfo(h *Handle) (FileInfo, NTStatus) {
+	return v.stat(h)
+}
+
+// SetBasicInfo changes the attributes and times of a file. An
+// attribute value of ^uint32(0) and times of 0 mean "unchanged".
+// Of the attributes, only FILE_ATTRIBUTE_READONLY is stored, as the
+// absence of write permission.
+func (v *Volume) SetBasicInfo(h *Handle, fileAttributes uint32, creationTime, lastAccessTime, lastWriteTime, changeTime uint64) (FileInfo, NTStatus) {
+	ctx := v.context()
+	if fileAttributes != ^uint32(0) {
+		a, code := v.fs.GetAttr(h.path, ctx)
+		if !code.Ok() {
+			return FileInfo{}, ToNTStatus(code)
+		}
+		mode := a.Mode & 07777
+		if fileAttributes&FILE_ATTRIBUTE_READONLY != 0 {
+			mode &^= 0222
+		} else if mode&0222 == 0 {
+			mode |= 0200
+		}
+		if mode != a.Mode&07777 {
+			if code := v.fs.Chmod(h.path, mode, ctx); !code.Ok() {
+				return FileInfo{}, ToNTStatus(code)
+			}
+		}
+	}
+	atime, mtime := fromFileTime(lastAccessTime), fromFileTime(lastWriteTime)
+	if atime != nil || mtime != nil {
+		code := fuse.ENOSYS
+		if h.file != nil {
+			code = h.file.Utimens(atime, mtime)
+		}
+		if code == fuse.ENOSYS {
+			code = v.fs.Utimens(h.path, atime, mtime, ctx)
+		}
+		if !code.Ok() {
+			return FileInfo{}, ToNTStatus(code)
+		}
+	}
+	return v.stat(h)
+}
+
+// SetFileSize truncates or extends the file. Setting the allocation
+// size only shrinks the file if it is smaller than the file size.
+func (v *Volume) SetFileSize(h *Handle, size uint64, setAllocationSize bool) (FileInfo, NTStatus) {
+	if h.isDir {
+		return FileInfo{}, STATUS_FILE_IS_A_DIRECTORY
+	}
+	if setAllocationSize {
+		fi, st := v.stat(h)
+		if !st.Ok() || size >= fi.FileSize {
+			return fi, st
+		}
+	}
+	code := h.file.Truncate(size)
+	if code == fuse.ENOSYS {
+		code = v.fs.Truncate(h.path, size, v.context())
+	}
+	if !code.Ok() {
+		return FileInfo{}, ToNTStatus(code)
+	}
+	return v.stat(h)
+}
+
+// CanDelete reports whether the file can be deleted on Cleanup;
+// directories must be empty.
+func (v *Volume) CanDelete(h *Handle) NTStatus {
+	if !h.isDir {
+		return STATUS_SUCCESS
+	}
+	entries, code := v.fs.OpenDir(h.path, v.context())
+	if !code.Ok() {
+		return ToNTStatus(code)
+	}
+	for _, e := range entries {
+		if e.Name != "." && e.Name != ".." {
+			return STATUS_DIRECTORY_NOT_EMPTY
+		}
+	}
+	return STATUS_SUCCESS
+}
+
+// Rename moves the open file to newName. Unless replaceIfExists is
+// set, an existing target makes it fail.
+func (v *Volume) Rename(h *Handle, newName string, replaceIfExists bool) NTStatus {
+	v.renameMu.Lock()
+	defer v.renameMu.Unlock()
+	ctx := v.context()
+	newPath := ToPath(newName)
+	if !replaceIfExists {
+		if _, code := v.fs.GetAttr(newPath, ctx); code.Ok() {
+			return STATUS_OBJECT_NAME_COLLISION
+		}
+	}
+	if code := v.fs.Rename(h.path, newPath, ctx); !code.Ok() {
+		return ToNTStatus(code)
+	}
+	h.path = newPath
+	return STATUS_SUCCESS
+}
+
+// ReadDirectory lists a directory, sorted by name, starting after
+// marker; an empty marker starts at the beginning. Matching against
+// the search pattern is left to the driver.
+func (v *Volume) ReadDirectory(h *Handle, marker string) ([]DirInfo, NTStatus) {
+	if !h.isDir {
+		return nil, STATUS_NOT_A_DIRECTORY
+	}
+	ctx := v.context()
+	entries, code := v.fs.OpenDir(h.path, ctx)
+	if !code.Ok() {
+		return nil, ToNTStatus(code)
+	}
+	names := make([]string, 0, len(entries)+2)
+	if h.path != "" {
+		names = append(names, ".", "..")
+	}
+	for _, e := range entries {
+		if e.Name != "." && e.Name != ".." {
+			names = append(names, e.Name)
+		}
+	}
+	sort.Strings(names)
+
+	var r []DirInfo
+	for _, n := range names {
+		if marker != "" && n <= marker {
+			continue
+		}
+		p := h.path
+		switch {
+		case n == "..":
+			p = p[:strings.LastIndex(p, "/")+1]
+			p = strings.TrimSuffix(p, "/")
+		case n != ".":
+			p = strings.TrimPrefix(p+"/"+n, "/")
+		}
+		a, code := v.fs.GetAttr(p, ctx)
+		if !code.Ok() {
+			// Deleted meanwhile.
+			continue
+		}
+		r = append(r, DirInfo{Name: n, FileInfo: v.fileInfo(p, a)})
+	}
+	return r, STATUS_SUCCESS
+}
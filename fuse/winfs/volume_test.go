@@ -0,0 +1,112 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package winfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestVolume(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	v := NewVolume(pathfs.NewLoopbackFileSystem(dir), &Options{HideDotFiles: true})
+
+	d, fi, st := v.Create(`\sub`, FILE_DIRECTORY_FILE, 0, 0)
+	if !st.Ok() || fi.FileAttributes != FILE_ATTRIBUTE_DIRECTORY {
+		t.Fatalf("Create dir: %v, %+v", st, fi)
+	}
+	if _, _, st := v.Create(`\sub`, FILE_DIRECTORY_FILE, 0, 0); st != STATUS_OBJECT_NAME_COLLISION {
+		t.Errorf("Create existing: got %v", st)
+	}
+
+	h, _, st := v.Create(`\sub\.file.txt`, 0, FILE_READ_DATA|FILE_WRITE_DATA, FILE_ATTRIBUTE_NORMAL)
+	if !st.Ok() {
+		t.Fatalf("Create: %v", st)
+	}
+	if n, fi, st := v.Write(h, []byte("hello"), 0, false, false); !st.Ok() || n != 5 || fi.FileSize != 5 {
+		t.Errorf("Write: %d, %+v, %v", n, fi, st)
+	}
+	if n, fi, st := v.Write(h, []byte(" world"), 0, true, false); !st.Ok() || n != 6 || fi.FileSize != 11 {
+		t.Errorf("Write to end: %d, %+v, %v", n, fi, st)
+	}
+	if n, _, st := v.Write(h, []byte("XXXXXX"), 8, false, true); !st.Ok() || n != 3 {
+		t.Errorf("constrained Write: %d, %v", n, st)
+	}
+	buf := make([]byte, 100)
+	if n, st := v.Read(h, buf, 0); !st.Ok() || string(buf[:n]) != "hello woXXX" {
+		t.Errorf("Read: %q, %v", buf[:n], st)
+	}
+	if _, st := v.Read(h, buf, 11); st != STATUS_END_OF_FILE {
+		t.Errorf("Read at EOF: %v", st)
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fi, st = v.SetBasicInfo(h, FILE_ATTRIBUTE_READONLY, 0, 0, FileTime(mtime), 0)
+	if !st.Ok() || fi.LastWriteTime != FileTime(mtime) {
+		t.Errorf("SetBasicInfo: %+v, %v", fi, st)
+	}
+	if want := uint32(FILE_ATTRIBUTE_READONLY | FILE_ATTRIBUTE_HIDDEN); fi.FileAttributes != want {
+		t.Errorf("got attributes %x, want %x", fi.FileAttributes, want)
+	}
+	if fi, st := v.SetFileSize(h, 2, false); !st.Ok() || fi.FileSize != 2 {
+		t.Errorf("SetFileSize: %+v, %v", fi, st)
+	}
+	if st := v.Rename(h, `\sub\renamed`, false); !st.Ok() {
+		t.Errorf("Rename: %v", st)
+	}
+	v.Close(h)
+
+	if _, err := os.Stat(filepath.Join(dir, "sub/renamed")); err != nil {
+		t.Errorf("Stat: %v", err)
+	}
+	if _, _, st := v.Open(`\sub\.file.txt`, 0, FILE_READ_DATA); st != STATUS_OBJECT_NAME_NOT_FOUND {
+		t.Errorf("Open old name: %v", st)
+	}
+
+	entries, st := v.ReadDirectory(d, "")
+	if !st.Ok() || len(entries) != 3 || entries[0].Name != "." || entries[1].Name != ".." || entries[2].Name != "renamed" {
+		t.Errorf("ReadDirectory: %+v, %v", entries, st)
+	}
+	if entries, st := v.ReadDirectory(d, ".."); !st.Ok() || len(entries) != 1 {
+		t.Errorf("ReadDirectory after marker: %+v, %v", entries, st)
+	}
+
+	if st := v.CanDelete(d); st != STATUS_DIRECTORY_NOT_EMPTY {
+		t.Errorf("CanDelete: %v", st)
+	}
+	h, _, st = v.Open(`\sub\renamed`, FILE_NON_DIRECTORY_FILE, FILE_READ_DATA)
+	if !st.Ok() {
+		t.Fatalf("Open: %v", st)
+	}
+	v.Cleanup(h, CleanupDelete)
+	v.Close(h)
+	if st := v.CanDelete(d); !st.Ok() {
+		t.Errorf("CanDelete after delete: %v", st)
+	}
+	v.Cleanup(d, CleanupDelete)
+	v.Close(d)
+	if _, err := os.Stat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Errorf("directory not deleted: %v", err)
+	}
+}
+
+func TestToNTStatus(t *testing.T) {
+	for code, want := range map[fuse.Status]NTStatus{
+		fuse.OK:     STATUS_SUCCESS,
+		fuse.ENOENT: STATUS_OBJECT_NAME_NOT_FOUND,
+		fuse.EIO:    STATUS_IO_DEVICE_ERROR,
+	} {
+		if got := ToNTStatus(code); got != want {
+			t.Errorf("ToNTStatus(%v): got %v, want %v", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"io"
+)
+
+// WriteData is the payload of a WRITE request, as one or more
+// segments that are not necessarily contiguous in memory, eg. the
+// rest of the request buffer followed by the guest pages of a
+// virtio-fs request. It is only valid until the method it was passed
+// to returns.
+type WriteData struct {
+	segs [][]byte
+}
+
+// Len returns the number of bytes in d.
+func (d WriteData) Len() int {
+	n := 0
+	for _, s := range d.segs {
+		n += len(s)
+	}
+	return n
+}
+
+// Segments returns the segments of d. They must not be modified.
+func (d WriteData) Segments() [][]byte {
+	return d.segs
+}
+
+// Bytes returns d as one slice. If d has a single segment, that is
+// returned; otherwise the data is copied into buf if it fits, or a
+// new slice.
+func (d WriteData) Bytes(buf []byte) []byte {
+	switch len(d.segs) {
+	case 0:
+		return nil
+	case 1:
+		return d.segs[0]
+	}
+	n := d.Len()
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:0]
+	for _, s := range d.segs {
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+// Reader returns a reader for the data of d.
+func (d WriteData) Reader() io.Reader {
+	return &writeDataReader{segs: d.segs}
+}
+
+// WriteTo writes the data of d to w. It implements io.WriterTo.
+func (d WriteData) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, s := range d.segs {
+		m, err := w.Write(s)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+type writeDataReader struct {
+	segs [][]byte
+	off  int // in segs[0]
+}
+
+func (r *writeDataReader) Read(p []byte) (int, error) {
+	n := 0
+	for len(r.segs) > 0 && n < len(p) {
+		c := copy(p[n:], r.segs[0][r.off:])
+		n += c
+		r.off += c
+		if r.off == len(r.segs[0]) {
+			r.segs = r.segs[1:]
+			r.off = 0
+		}
+	}
+	if n == 0 && len(r.segs) == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// SegmentWriter is implemented by file systems that take the data of
+// WRITE requests as it came in from the transport, without copying
+// it into one buffer first. The server calls WriteSegments instead
+// of Write if the file system implements it.
+type SegmentWriter interface {
+	WriteSegments(input *WriteIn, data WriteData) (written uint32, code Status)
+}
+
+// SegmentReader is implemented by Transports that can hand over
+// the payload of a request without copying it into the request
+// buffer. ReadRequestSegments is like ReadRequest, except that, for
+// requests with a payload, it may stop after the fixed size input,
+// and return the rest as payload. The payload must stay valid until
+// the reply for the request has been written.
+type SegmentReader interface {
+	ReadRequestSegments(buf []byte) (n int, payload [][]byte, err error)
+}
+
+// writeData returns the payload of the WRITE request req.
+func (r *request) writeData() WriteData {
+	if len(r.payload) == 0 {
+		return WriteData{segs: [][]byte{r.arg}}
+	}
+	segs := make([][]byte, 0, 1+len(r.payload))
+	if len(r.arg) > 0 {
+		segs = append(segs, r.arg)
+	}
+	for _, p := range r.payload {
+		if len(p) > 0 {
+			segs = append(segs, p)
+		}
+	}
+	return WriteData{segs: segs}
+}
+
+// payloadLen returns the number of bytes in r.payload.
+func (r *request) payloadLen() int {
+	n := 0
+	for _, p := range r.payload {
+		n += len(p)
+	}
+	return n
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"bytes"
+	"io/ioutil"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestWriteData(t *testing.T) {
+	d := WriteData{segs: [][]byte{[]byte("hel"), []byte("lo "), []byte("world")}}
+	if d.Len() != 11 {
+		t.Errorf("Len: got %d", d.Len())
+	}
+	if got := d.Bytes(nil); string(got) != "hello world" {
+		t.Errorf("Bytes: got %q", got)
+	}
+	got, err := ioutil.ReadAll(d.Reader())
+	if err != nil || string(got) != "hello world" {
+		t.Errorf("Reader: got %q, %v", got, err)
+	}
+	var buf bytes.Buffer
+	if n, err := d.WriteTo(&buf); n != 11 || err != nil || buf.String() != "hello world" {
+		t.Errorf("WriteTo: got %d, %v, %q", n, err, buf.String())
+	}
+
+	one := WriteData{segs: [][]byte{[]byte("x")}}
+	if got := one.Bytes(nil); &got[0] != &one.segs[0][0] {
+		t.Errorf("Bytes copied a single segment")
+	}
+}
+
+// segmentTransport hands out one WRITE request, with its data in
+// two segments.
+type segmentTransport struct {
+	header []byte
+	data   [][]byte
+}
+
+func (t *segmentTransport) ReadRequest(buf []byte) (int, error) {
+	return 0, syscall.ENOSYS
+}
+
+func (t *segmentTransport) ReadRequestSegments(buf []byte) (int, [][]byte, error) {
+	return copy(buf, t.header), t.data, nil
+}
+
+func (t *segmentTransport) WriteReply(data [][]byte) error { return nil }
+func (t *segmentTransport) Close() error                   { return nil }
+
+type recordingWriteFS struct {
+	RawFileSystem
+	data []byte
+}
+
+func (fs *recordingWriteFS) Write(in *WriteIn, data []byte) (uint32, Status) {
+	fs.data = append([]byte{}, data...)
+	return uint32(len(data)), OK
+}
+
+type segmentWriteFS struct {
+	RawFileSystem
+	segs int
+	data []byte
+}
+
+func (fs *segmentWriteFS) WriteSegments(in *WriteIn, data WriteData) (uint32, Status) {
+	fs.segs = len(data.Segments())
+	fs.data = data.Bytes(nil)
+	return uint32(data.Len()), OK
+}
+
+func TestWriteSegments(t *testing.T) {
+	var in WriteIn
+	in.Opcode = _OP_WRITE
+	in.Unique = 1
+	in.Size = 11
+	header := (*[unsafe.Sizeof(WriteIn{})]byte)(unsafe.Pointer(&in))[:]
+	header = append(header, "hel"...)
+	data := [][]byte{[]byte("lo "), []byte("world")}
+
+	for _, fs := range []RawFileSystem{
+		&recordingWriteFS{RawFileSystem: NewDefaultRawFileSystem()},
+		&segmentWriteFS{RawFileSystem: NewDefaultRawFileSystem()},
+	} {
+		ms, err := newServer(fs, nil)
+		if err != nil {
+			t.Fatalf("newServer: %v", err)
+		}
+		ms.singleReader = true
+		ms.transport = &segmentTransport{header: header, data: data}
+		req, code := ms.readRequest(false)
+		if !code.Ok() {
+			t.Fatalf("readRequest: %v", code)
+		}
+		req.parse(ms.opts.Logger)
+		req.fs = fs
+		doWrite(ms, req)
+		if n := (*WriteOut)(req.outData()).Size; !req.status.Ok() || n != 11 {
+			t.Errorf("%T: got %d, %v", fs, n, req.status)
+		}
+		switch fs := fs.(type) {
+		case *recordingWriteFS:
+			if string(fs.data) != "hello world" {
+				t.Errorf("Write: got %q", fs.data)
+			}
+		case *segmentWriteFS:
+			if fs.segs != 3 || string(fs.data) != "hello world" {
+				t.Errorf("WriteSegments: got %d segments, %q", fs.segs, fs.data)
+			}
+		}
+		ms.returnRequest(req)
+	}
+}
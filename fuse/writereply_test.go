@@ -0,0 +1,119 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"io/ioutil"
+	"log"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestSkipWritten(t *testing.T) {
+	data := [][]byte{nil, []byte("abc"), []byte("de")}
+	for n, want := range []string{"abc|de", "bc|de", "c|de", "de", "e", ""} {
+		got := ""
+		for i, b := range skipWritten(data, n) {
+			if i > 0 {
+				got += "|"
+			}
+			got += string(b)
+		}
+		if got != want {
+			t.Errorf("%d: got %q, want %q", n, got, want)
+		}
+	}
+	if string(data[1]) != "abc" {
+		t.Errorf("data changed: %q", data)
+	}
+}
+
+// failingTransport hands out STATFS requests, and fails to write
+// the replies with err.
+type failingTransport struct {
+	err    error
+	reads  int
+	writes int
+}
+
+func (t *failingTransport) ReadRequest(buf []byte) (int, error) {
+	t.reads++
+	var in InHeader
+	in.Length = uint32(unsafe.Sizeof(in))
+	in.Opcode = _OP_STATFS
+	in.Unique = uint64(t.reads)
+	in.NodeId = FUSE_ROOT_ID
+	return copy(buf, (*[unsafe.Sizeof(InHeader{})]byte)(unsafe.Pointer(&in))[:]), nil
+}
+
+func (t *failingTransport) WriteReply(data [][]byte) error {
+	t.writes++
+	return t.err
+}
+
+func (t *failingTransport) Close() error { return nil }
+
+func TestWriteFailuresStopServer(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want int
+	}{
+		{syscall.EIO, maxWriteFailures},
+		{syscall.ENODEV, 1},
+	} {
+		ms, err := newServer(NewDefaultRawFileSystem(), &MountOptions{
+			Deterministic: true,
+			Logger:        log.New(ioutil.Discard, "", 0),
+		})
+		if err != nil {
+			t.Fatalf("newServer: %v", err)
+		}
+		ms.singleReader = true
+		tr := &failingTransport{err: c.err}
+		ms.transport = tr
+
+		done := make(chan struct{})
+		ms.loops.Add(1)
+		go func() {
+			ms.loop(false)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: loop did not stop", c.err)
+		}
+		if tr.writes != c.want {
+			t.Errorf("%v: got %d replies, want %d", c.err, tr.writes, c.want)
+		}
+	}
+}
+
+func TestWriteENOENTKeepsServing(t *testing.T) {
+	ms, err := newServer(NewDefaultRawFileSystem(), &MountOptions{
+		Logger: log.New(ioutil.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	req := &request{inHeader: &InHeader{Opcode: _OP_READ}}
+	for i := 0; i < 2*maxWriteFailures; i++ {
+		ms.noteWrite(req, ENOENT)
+	}
+	if ms.writeBroken.Load() {
+		t.Errorf("ENOENT stopped the server")
+	}
+
+	for i := 0; i < maxWriteFailures-1; i++ {
+		ms.noteWrite(req, EIO)
+	}
+	ms.noteWrite(req, OK)
+	ms.noteWrite(req, EIO)
+	if ms.writeBroken.Load() {
+		t.Errorf("failures not in a row stopped the server")
+	}
+}
@@ -1,3 +1,8 @@
 module github.com/hanwen/go-fuse
 
-require golang.org/x/sys v0.0.0-20180830151530-49385e6e1522
+go 1.21
+
+require (
+	golang.org/x/sys v0.0.0-20180830151530-49385e6e1522
+	golang.org/x/text v0.22.0
+)
@@ -0,0 +1,118 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testutil
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// unmountMargin is how long before the test deadline a mount is
+// forcibly unmounted, so a hung file system does not wedge the
+// machine after the test binary is killed.
+const unmountMargin = 5 * time.Second
+
+// deadline returns the test deadline. Benchmarks don't have one.
+func deadline(t testing.TB) (time.Time, bool) {
+	if dt, ok := t.(interface{ Deadline() (time.Time, bool) }); ok {
+		return dt.Deadline()
+	}
+	return time.Time{}, false
+}
+
+// unmountDelay returns when to unmount, given the time left until
+// the deadline. If there is less than twice unmountMargin left, the
+// margin is cut to half the time left.
+func unmountDelay(left time.Duration) time.Duration {
+	if left <= 0 {
+		return 0
+	}
+	margin := unmountMargin
+	if left < 2*margin {
+		margin = left / 2
+	}
+	return left - margin
+}
+
+// Mount mounts fs on a new temporary directory, starts serving it,
+// and waits until the mount is ready. Unmounting and removing the
+// directory are registered with t.Cleanup, so they happen however
+// the test ends, including t.Fatal and panics. If the test has a
+// deadline, the file system is also unmounted shortly before it
+// expires.
+//
+// For nodefs and pathfs file systems, pass
+// nodefs.NewFileSystemConnector(root, opts).RawFS().
+func Mount(t testing.TB, fs fuse.RawFileSystem, opts *fuse.MountOptions) (mnt string, server *fuse.Server) {
+	t.Helper()
+	if opts == nil {
+		opts = &fuse.MountOptions{}
+	}
+	if !opts.Debug {
+		o := *opts
+		o.Debug = VerboseTest()
+		opts = &o
+	}
+
+	mnt = TempDir()
+	server, err := fuse.NewServer(fs, mnt, opts)
+	if err != nil {
+		os.RemoveAll(mnt)
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	served := make(chan struct{})
+	go func() {
+		server.Serve()
+		close(served)
+	}()
+
+	// The deadline timer and the cleanup both unmount; only the
+	// first one does.
+	var mu sync.Mutex
+	unmounted := false
+	unmount := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if unmounted {
+			return nil
+		}
+		if err := server.Unmount(); err != nil {
+			return err
+		}
+		unmounted = true
+		return nil
+	}
+
+	var timer *time.Timer
+	if deadline, ok := deadline(t); ok {
+		timer = time.AfterFunc(unmountDelay(time.Until(deadline)), func() {
+			unmount()
+		})
+	}
+
+	t.Cleanup(func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := unmount(); err != nil {
+			// Serve is still running; leave the
+			// directory alone.
+			t.Errorf("Unmount: %v", err)
+			return
+		}
+		<-served
+		os.RemoveAll(mnt)
+	})
+
+	if err := server.WaitMount(); err != nil {
+		t.Fatalf("WaitMount: %v", err)
+	}
+	return mnt, server
+}
@@ -0,0 +1,397 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package joinfs pools several directories into one tree, like
+// mergerfs. Unlike unionfs, all branches are equal: there is no
+// writable top branch, no copy-up and no whiteouts. An entry lives in
+// one branch, or, for directories, possibly in several. Policies
+// decide which branch serves an existing entry and which branch gets
+// a new one, eg. the one with the most free space.
+//
+// For a read-only pool, wrap the result with
+// pathfs.NewReadonlyFileSystem.
+package joinfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+// Options configures a JoinFs.
+type Options struct {
+	// Search chooses the branch that serves an existing entry
+	// among the branches that have it. If nil, the first branch
+	// that has it is used, which saves looking at the others.
+	Search Policy
+
+	// Create chooses the branch for a new entry among the
+	// branches that have its parent directory. If nil,
+	// MostFreeSpace is used.
+	Create Policy
+}
+
+type joinFS struct {
+	pathfs.FileSystem
+	branches []pathfs.FileSystem
+	opts     Options
+}
+
+// NewJoinFs returns a file system that joins branches. Changes to
+// attributes of an entry, and its removal, apply to all branches that
+// have it. A rename is done in each branch that has the source; if
+// one of them lacks the directory of the destination, it fails with
+// EXDEV, so mv(1) falls back to copying.
+func NewJoinFs(branches []pathfs.FileSystem, opts *Options) pathfs.FileSystem {
+	fs := &joinFS{
+		FileSystem: pathfs.NewDefaultFileSystem(),
+		branches:   branches,
+	}
+	if opts != nil {
+		fs.opts = *opts
+	}
+	if fs.opts.Create == nil {
+		fs.opts.Create = MostFreeSpace
+	}
+	return fs
+}
+
+func (fs *joinFS) String() string {
+	var names []string
+	for _, b := range fs.branches {
+		names = append(names, b.String())
+	}
+	return fmt.Sprintf("joinFS(%s)", strings.Join(names, ", "))
+}
+
+func (fs *joinFS) SetDebug(debug bool) {
+	for _, b := range fs.branches {
+		b.SetDebug(debug)
+	}
+}
+
+func (fs *joinFS) OnMount(nodeFs *pathfs.PathNodeFs) {
+	for _, b := range fs.branches {
+		b.OnMount(nodeFs)
+	}
+}
+
+func (fs *joinFS) OnUnmount() {
+	for _, b := range fs.branches {
+		b.OnUnmount()
+	}
+}
+
+// having returns the branches that have name.
+func (fs *joinFS) having(name string, context *fuse.Context) []int {
+	var r []int
+	for i, b := range fs.branches {
+		if _, code := b.GetAttr(name, context); code.Ok() {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+// find returns the branch that serves name, and its attributes.
+func (fs *joinFS) find(name string, context *fuse.Context) (int, *fuse.Attr, fuse.Status) {
+	if fs.opts.Search == nil {
+		for i, b := range fs.branches {
+			if a, code := b.GetAttr(name, context); code.Ok() {
+				return i, a, code
+			}
+		}
+		return -1, nil, fuse.ENOENT
+	}
+	candidates := fs.having(name, context)
+	if len(candidates) == 0 {
+		return -1, nil, fuse.ENOENT
+	}
+	i := fs.opts.Search(fs.branches, candidates, name)
+	a, code := fs.branches[i].GetAttr(name, context)
+	return i, a, code
+}
+
+// createBranch chooses the branch for the new entry name.
+func (fs *joinFS) createBranch(name string, context *fuse.Context) (int, fuse.Status) {
+	if _, _, code := fs.find(name, context); code.Ok() {
+		return -1, fuse.Status(syscall.EEXIST)
+	}
+	candidates := fs.having(parent(name), context)
+	if len(candidates) == 0 {
+		return -1, fuse.ENOENT
+	}
+	return fs.opts.Create(fs.branches, candidates, name), fuse.OK
+}
+
+func parent(name string) string {
+	dir := filepath.Dir(name)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// all applies op to each branch that has name. It succeeds if op
+// succeeds anywhere.
+func (fs *joinFS) all(name string, context *fuse.Context, op func(b pathfs.FileSystem) fuse.Status) fuse.Status {
+	candidates := fs.having(name, context)
+	if len(candidates) == 0 {
+		return fuse.ENOENT
+	}
+	result := fuse.OK
+	ok := false
+	for _, i := range candidates {
+		if code := op(fs.branches[i]); code.Ok() {
+			ok = true
+		} else if result.Ok() {
+			result = code
+		}
+	}
+	if ok {
+		return fuse.OK
+	}
+	return result
+}
+
+func (fs *joinFS) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	_, a, code := fs.find(name, context)
+	return a, code
+}
+
+func (fs *joinFS) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	i, _, code := fs.find(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.branches[i].Access(name, mode, context)
+}
+
+func (fs *joinFS) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	i, _, code := fs.find(name, context)
+	if !code.Ok() {
+		return "", code
+	}
+	return fs.branches[i].Readlink(name, context)
+}
+
+func (fs *joinFS) GetXAttr(name string, attr string, context *fuse.Context) ([]byte, fuse.Status) {
+	i, _, code := fs.find(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.branches[i].GetXAttr(name, attr, context)
+}
+
+func (fs *joinFS) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	i, _, code := fs.find(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.branches[i].ListXAttr(name, context)
+}
+
+func (fs *joinFS) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	i, _, code := fs.find(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.branches[i].Open(name, flags, context)
+}
+
+func (fs *joinFS) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fs.all(name, context, func(b pathfs.FileSystem) fuse.Status {
+		return b.Chmod(name, mode, context)
+	})
+}
+
+func (fs *joinFS) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	return fs.all(name, context, func(b pathfs.FileSystem) fuse.Status {
+		return b.Chown(name, uid, gid, context)
+	})
+}
+
+func (fs *joinFS) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	return fs.all(name, context, func(b pathfs.FileSystem) fuse.Status {
+		return b.Utimens(name, atime, mtime, context)
+	})
+}
+
+func (fs *joinFS) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	return fs.all(name, context, func(b pathfs.FileSystem) fuse.Status {
+		return b.Truncate(name, size, context)
+	})
+}
+
+func (fs *joinFS) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	return fs.all(name, context, func(b pathfs.FileSystem) fuse.Status {
+		return b.SetXAttr(name, attr, data, flags, context)
+	})
+}
+
+func (fs *joinFS) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	return fs.all(name, context, func(b pathfs.FileSystem) fuse.Status {
+		return b.RemoveXAttr(name, attr, context)
+	})
+}
+
+func (fs *joinFS) Unlink(name string, context *fuse.Context) fuse.Status {
+	return fs.all(name, context, func(b pathfs.FileSystem) fuse.Status {
+		return b.Unlink(name, context)
+	})
+}
+
+func (fs *joinFS) Rmdir(name string, context *fuse.Context) fuse.Status {
+	// The directory is only empty if it is empty everywhere.
+	if entries, code := fs.OpenDir(name, context); code.Ok() && len(entries) > 0 {
+		return fuse.Status(syscall.ENOTEMPTY)
+	}
+	return fs.all(name, context, func(b pathfs.FileSystem) fuse.Status {
+		return b.Rmdir(name, context)
+	})
+}
+
+func (fs *joinFS) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	i, code := fs.createBranch(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.branches[i].Mkdir(name, mode, context)
+}
+
+func (fs *joinFS) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	i, code := fs.createBranch(name, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.branches[i].Mknod(name, mode, dev, context)
+}
+
+func (fs *joinFS) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	i, code := fs.createBranch(linkName, context)
+	if !code.Ok() {
+		return code
+	}
+	return fs.branches[i].Symlink(value, linkName, context)
+}
+
+func (fs *joinFS) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if i, _, code := fs.find(name, context); code.Ok() {
+		if flags&uint32(os.O_EXCL) != 0 {
+			return nil, fuse.Status(syscall.EEXIST)
+		}
+		return fs.branches[i].Open(name, flags, context)
+	}
+	i, code := fs.createBranch(name, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return fs.branches[i].Create(name, flags, mode, context)
+}
+
+func (fs *joinFS) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	if _, _, code := fs.find(newName, context); code.Ok() {
+		return fuse.Status(syscall.EEXIST)
+	}
+	// A hard link must be in the branch of its target.
+	i, _, code := fs.find(oldName, context)
+	if !code.Ok() {
+		return code
+	}
+	if _, code := fs.branches[i].GetAttr(parent(newName), context); !code.Ok() {
+		return fuse.Status(syscall.EXDEV)
+	}
+	return fs.branches[i].Link(oldName, newName, context)
+}
+
+func (fs *joinFS) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	from := fs.having(oldName, context)
+	if len(from) == 0 {
+		return fuse.ENOENT
+	}
+	newDir := parent(newName)
+	for _, i := range from {
+		if _, code := fs.branches[i].GetAttr(newDir, context); !code.Ok() {
+			return fuse.Status(syscall.EXDEV)
+		}
+	}
+	for _, i := range from {
+		if code := fs.branches[i].Rename(oldName, newName, context); !code.Ok() {
+			return code
+		}
+	}
+
+	// The destination in other branches would shadow or
+	// reappear next to the renamed entry.
+	isFrom := map[int]bool{}
+	for _, i := range from {
+		isFrom[i] = true
+	}
+	for i, b := range fs.branches {
+		if isFrom[i] {
+			continue
+		}
+		if a, code := b.GetAttr(newName, context); code.Ok() && !a.IsDir() {
+			b.Unlink(newName, context)
+		}
+	}
+	return fuse.OK
+}
+
+func (fs *joinFS) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	var result []fuse.DirEntry
+	seen := map[string]bool{}
+	found := false
+	for _, b := range fs.branches {
+		entries, code := b.OpenDir(name, context)
+		if !code.Ok() {
+			continue
+		}
+		found = true
+		for _, e := range entries {
+			if seen[e.Name] {
+				continue
+			}
+			seen[e.Name] = true
+			result = append(result, e)
+		}
+	}
+	if !found {
+		return nil, fuse.ENOENT
+	}
+	return result, fuse.OK
+}
+
+// StatFs adds up the space of all branches, in units of the first
+// branch's block size.
+func (fs *joinFS) StatFs(name string) *fuse.StatfsOut {
+	var out *fuse.StatfsOut
+	for _, b := range fs.branches {
+		st := b.StatFs(name)
+		if st == nil || st.Bsize == 0 {
+			continue
+		}
+		if out == nil {
+			c := *st
+			out = &c
+			continue
+		}
+		scale := func(n uint64) uint64 {
+			return n * uint64(st.Bsize) / uint64(out.Bsize)
+		}
+		out.Blocks += scale(st.Blocks)
+		out.Bfree += scale(st.Bfree)
+		out.Bavail += scale(st.Bavail)
+		out.Files += st.Files
+		out.Ffree += st.Ffree
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package joinfs
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func setup(t *testing.T, n int, opts *Options) ([]string, pathfs.FileSystem) {
+	var dirs []string
+	var branches []pathfs.FileSystem
+	for i := 0; i < n; i++ {
+		dir := testutil.TempDir()
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		dirs = append(dirs, dir)
+		branches = append(branches, pathfs.NewLoopbackFileSystem(dir))
+	}
+	return dirs, NewJoinFs(branches, opts)
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+func TestJoinFsSearch(t *testing.T) {
+	dirs, fs := setup(t, 2, nil)
+	os.Mkdir(dirs[0]+"/dir", 0755)
+	os.Mkdir(dirs[1]+"/dir", 0755)
+	ioutil.WriteFile(dirs[0]+"/dir/a", []byte("a0"), 0644)
+	ioutil.WriteFile(dirs[1]+"/dir/a", []byte("a1"), 0644)
+	ioutil.WriteFile(dirs[1]+"/dir/b", []byte("b1"), 0644)
+
+	entries, code := fs.OpenDir("dir", nil)
+	if !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("OpenDir: got %v, want [a b]", names)
+	}
+
+	for name, want := range map[string]string{"dir/a": "a0", "dir/b": "b1"} {
+		f, code := fs.Open(name, uint32(os.O_RDONLY), nil)
+		if !code.Ok() {
+			t.Fatalf("Open(%q): %v", name, code)
+		}
+		buf := make([]byte, 10)
+		res, _ := f.Read(buf, 0)
+		got, _ := res.Bytes(buf)
+		f.Release()
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	if _, code := fs.GetAttr("dir/c", nil); code != fuse.ENOENT {
+		t.Errorf("GetAttr(dir/c): got %v, want ENOENT", code)
+	}
+	if _, code := fs.OpenDir("missing", nil); code != fuse.ENOENT {
+		t.Errorf("OpenDir(missing): got %v, want ENOENT", code)
+	}
+}
+
+func TestJoinFsCreate(t *testing.T) {
+	dirs, fs := setup(t, 3, &Options{Create: RoundRobin()})
+	// The third branch lacks dir, so it gets no files in it.
+	os.Mkdir(dirs[0]+"/dir", 0755)
+	os.Mkdir(dirs[1]+"/dir", 0755)
+
+	for _, name := range []string{"dir/a", "dir/b", "dir/c", "dir/d"} {
+		f, code := fs.Create(name, uint32(os.O_WRONLY), 0644, nil)
+		if !code.Ok() {
+			t.Fatalf("Create(%q): %v", name, code)
+		}
+		f.Release()
+	}
+	for _, want := range []string{"0/dir/a", "1/dir/b", "0/dir/c", "1/dir/d"} {
+		name := dirs[want[0]-'0'] + want[1:]
+		if !exists(name) {
+			t.Errorf("%s: missing", name)
+		}
+	}
+
+	if code := fs.Mkdir("dir/a", 0755, nil); code != fuse.Status(syscall.EEXIST) {
+		t.Errorf("Mkdir(dir/a): got %v, want EEXIST", code)
+	}
+	if code := fs.Mkdir("nodir/x", 0755, nil); code != fuse.ENOENT {
+		t.Errorf("Mkdir(nodir/x): got %v, want ENOENT", code)
+	}
+	if _, code := fs.Create("dir/a", uint32(os.O_WRONLY|os.O_EXCL), 0644, nil); code != fuse.Status(syscall.EEXIST) {
+		t.Errorf("Create(O_EXCL): got %v, want EEXIST", code)
+	}
+}
+
+func TestJoinFsRemove(t *testing.T) {
+	dirs, fs := setup(t, 2, nil)
+	os.Mkdir(dirs[0]+"/dir", 0755)
+	os.Mkdir(dirs[1]+"/dir", 0755)
+	ioutil.WriteFile(dirs[1]+"/dir/file", nil, 0644)
+
+	if code := fs.Rmdir("dir", nil); code != fuse.Status(syscall.ENOTEMPTY) {
+		t.Errorf("Rmdir: got %v, want ENOTEMPTY", code)
+	}
+	if code := fs.Chmod("dir", 0700, nil); !code.Ok() {
+		t.Errorf("Chmod: %v", code)
+	}
+	for _, d := range dirs {
+		if fi, err := os.Stat(d + "/dir"); err != nil || fi.Mode().Perm() != 0700 {
+			t.Errorf("%s/dir: got %v, %v, want mode 0700", d, fi.Mode(), err)
+		}
+	}
+	if code := fs.Unlink("dir/file", nil); !code.Ok() {
+		t.Fatalf("Unlink: %v", code)
+	}
+	if code := fs.Rmdir("dir", nil); !code.Ok() {
+		t.Fatalf("Rmdir: %v", code)
+	}
+	for _, d := range dirs {
+		if exists(d + "/dir") {
+			t.Errorf("%s/dir: still there", d)
+		}
+	}
+}
+
+func TestJoinFsRename(t *testing.T) {
+	dirs, fs := setup(t, 2, nil)
+	os.Mkdir(dirs[1]+"/only1", 0755)
+	ioutil.WriteFile(dirs[0]+"/file", []byte("0"), 0644)
+	ioutil.WriteFile(dirs[1]+"/other", []byte("1"), 0644)
+
+	if code := fs.Rename("file", "only1/file", nil); code != fuse.Status(syscall.EXDEV) {
+		t.Errorf("Rename across branches: got %v, want EXDEV", code)
+	}
+
+	// The old destination in the other branch must not show
+	// through.
+	if code := fs.Rename("file", "other", nil); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	if exists(dirs[1] + "/other") {
+		t.Errorf("stale destination left in branch 1")
+	}
+	if !exists(dirs[0] + "/other") {
+		t.Errorf("renamed file missing")
+	}
+}
+
+func TestJoinFsMostFreeSpace(t *testing.T) {
+	_, fs := setup(t, 1, nil)
+	// With a single filesystem underneath, any branch is fine;
+	// check that the policy tolerates StatFs failures.
+	branches := []pathfs.FileSystem{pathfs.NewDefaultFileSystem(), fs}
+	if got := MostFreeSpace(branches, []int{0, 1}, "x"); got != 1 {
+		t.Errorf("MostFreeSpace: got %d, want 1", got)
+	}
+	if got := MostFreeSpace(branches, []int{0}, "x"); got != 0 {
+		t.Errorf("MostFreeSpace with one candidate: got %d, want 0", got)
+	}
+}
+
+func TestJoinFsStatFs(t *testing.T) {
+	dirs, fs := setup(t, 2, nil)
+	one := pathfs.NewLoopbackFileSystem(dirs[0]).StatFs("")
+	got := fs.StatFs("")
+	if one == nil || got == nil {
+		t.Fatalf("StatFs: got %v, %v", one, got)
+	}
+	if got.Blocks != 2*one.Blocks {
+		t.Errorf("StatFs: got %d blocks, want %d", got.Blocks, 2*one.Blocks)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package joinfs
+
+import (
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+// A Policy chooses the branch for an operation on name. candidates
+// holds the indices into branches of the branches that qualify; it is
+// never empty.
+type Policy func(branches []pathfs.FileSystem, candidates []int, name string) int
+
+// FirstFound chooses the first candidate, in the order the branches
+// were given.
+func FirstFound(branches []pathfs.FileSystem, candidates []int, name string) int {
+	return candidates[0]
+}
+
+// MostFreeSpace chooses the candidate with the most space available
+// to unprivileged users, as reported by StatFs for the directory of
+// name. Candidates that do not implement StatFs come last.
+func MostFreeSpace(branches []pathfs.FileSystem, candidates []int, name string) int {
+	dir := filepath.Dir(name)
+	if dir == "." {
+		dir = ""
+	}
+	best := candidates[0]
+	var bestFree uint64
+	for _, c := range candidates {
+		st := branches[c].StatFs(dir)
+		if st == nil {
+			continue
+		}
+		if free := st.Bavail * uint64(st.Bsize); free > bestFree {
+			best, bestFree = c, free
+		}
+	}
+	return best
+}
+
+// RoundRobin returns a policy that cycles through the candidates, to
+// spread new files evenly.
+func RoundRobin() Policy {
+	var n atomic.Uint64
+	return func(branches []pathfs.FileSystem, candidates []int, name string) int {
+		return candidates[(n.Add(1)-1)%uint64(len(candidates))]
+	}
+}
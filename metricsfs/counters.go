@@ -0,0 +1,131 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metricsfs
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Counters counts the requests of a mount, per operation. It is a
+// fuse.Interceptor; install it through MountOptions.Interceptors, and
+// publish it with MetricsFs.AddCounters.
+type Counters struct {
+	mu  sync.Mutex
+	ops map[string]*opCounter
+}
+
+type opCounter struct {
+	requests int64
+	errors   int64
+
+	// Bytes received with WRITE, and returned by READ and
+	// READLINK. Reads served from a file descriptor are not
+	// counted.
+	bytesIn  int64
+	bytesOut int64
+}
+
+// OpCounts are the counters of one operation.
+type OpCounts struct {
+	Requests int64
+	Errors   int64
+	BytesIn  int64
+	BytesOut int64
+}
+
+// CountersSnapshot is a copy of Counters at one point in time.
+// Comparing two snapshots gives rates.
+type CountersSnapshot struct {
+	Time time.Time
+
+	// Ops is keyed by operation name, eg. "LOOKUP".
+	Ops map[string]OpCounts
+}
+
+// NewCounters returns an empty set of counters.
+func NewCounters() *Counters {
+	return &Counters{ops: map[string]*opCounter{}}
+}
+
+// Snapshot returns a copy of the counters, which later requests do
+// not change.
+func (c *Counters) Snapshot() CountersSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snapshot()
+}
+
+// Reset sets all counters to zero, and returns their values from
+// just before.
+func (c *Counters) Reset() CountersSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.snapshot()
+	c.ops = map[string]*opCounter{}
+	return s
+}
+
+func (c *Counters) snapshot() CountersSnapshot {
+	s := CountersSnapshot{
+		Time: time.Now(),
+		Ops:  make(map[string]OpCounts, len(c.ops)),
+	}
+	for k, op := range c.ops {
+		s.Ops[k] = OpCounts{op.requests, op.errors, op.bytesIn, op.bytesOut}
+	}
+	return s
+}
+
+// Before implements fuse.Interceptor.
+func (c *Counters) Before(r *fuse.Intercepted) bool {
+	return true
+}
+
+// After implements fuse.Interceptor.
+func (c *Counters) After(r *fuse.Intercepted) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := r.OpName()
+	op := c.ops[name]
+	if op == nil {
+		op = &opCounter{}
+		c.ops[name] = op
+	}
+	op.requests++
+	if !r.Status.Ok() {
+		op.errors++
+		return
+	}
+	if w, ok := r.In.(*fuse.WriteIn); ok {
+		op.bytesIn += int64(w.Size)
+	}
+	op.bytesOut += int64(len(r.Data))
+}
+
+// AddCounters adds a file listing, for each operation, the number
+// of requests and errors, and the bytes written and read.
+func (fs *MetricsFs) AddCounters(name string, c *Counters) {
+	fs.Add(name, func() []byte {
+		s := c.Snapshot()
+		names := make([]string, 0, len(s.Ops))
+		for k := range s.Ops {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		var buf bytes.Buffer
+		for _, k := range names {
+			op := s.Ops[k]
+			fmt.Fprintf(&buf, "%s %d %d %d %d\n", k, op.Requests, op.Errors, op.BytesIn, op.BytesOut)
+		}
+		return buf.Bytes()
+	})
+}
@@ -0,0 +1,267 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metricsfs provides a small file system that exposes the
+// state of running file systems (latencies, buffer pool usage,
+// kernel settings) as files, and a few control knobs as writable
+// files. It can be mounted on its own, or as a submount next to the
+// file system it describes, e.g.
+//
+//	m := metricsfs.NewMetricsFs()
+//	server, conn, _ := nodefs.MountRoot(dir, root, nil)
+//	m.AddServer("server", server)
+//	conn.Mount(root.Inode(), ".metrics", m.Root(), nil)
+//
+// after which "cat dir/.metrics/server/debugdata" and
+// "echo 1 > dir/.metrics/server/debug" work from scripts.
+// Request counts per operation come from Counters, which is
+// installed in the server as an interceptor.
+package metricsfs
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// ReadFunc produces the current content of a file. It is called
+// for every open and stat, so it should be cheap.
+type ReadFunc func() []byte
+
+// WriteFunc is called with the data of each write to a control
+// file.
+type WriteFunc func(data []byte) fuse.Status
+
+// LatencySource is the part of benchmark.LatencyMap that is needed
+// to print latencies.
+type LatencySource interface {
+	Counts() map[string]int
+	Get(name string) (count int, dt time.Duration)
+}
+
+// MetricsFs is a tree of synthetic files. Files may be added before
+// or after mounting.
+type MetricsFs struct {
+	root *rootNode
+
+	mu      sync.Mutex
+	mounted bool
+	pending map[string]*metricsNode
+}
+
+type metricsNode struct {
+	nodefs.Node
+
+	read  ReadFunc
+	write WriteFunc
+}
+
+// NewMetricsFs returns an empty metrics file system.
+func NewMetricsFs() *MetricsFs {
+	fs := &MetricsFs{
+		pending: map[string]*metricsNode{},
+	}
+	fs.root = &rootNode{Node: nodefs.NewDefaultNode(), fs: fs}
+	return fs
+}
+
+func (fs *MetricsFs) String() string {
+	return "metricsfs"
+}
+
+// Root returns the root node, to be passed to nodefs.MountRoot or
+// FileSystemConnector.Mount.
+func (fs *MetricsFs) Root() nodefs.Node {
+	return fs.root
+}
+
+type rootNode struct {
+	nodefs.Node
+	fs *MetricsFs
+}
+
+func (n *rootNode) OnMount(c *nodefs.FileSystemConnector) {
+	n.fs.mu.Lock()
+	defer n.fs.mu.Unlock()
+	n.fs.mounted = true
+	for name, node := range n.fs.pending {
+		n.fs.addNode(name, node)
+	}
+	n.fs.pending = nil
+}
+
+// Add adds a read-only file at the given slash-separated path.
+func (fs *MetricsFs) Add(name string, read ReadFunc) {
+	fs.add(name, &metricsNode{Node: nodefs.NewDefaultNode(), read: read})
+}
+
+// AddControl adds a writable file at the given path. Reading the
+// file returns the output of read, which may be nil.
+func (fs *MetricsFs) AddControl(name string, read ReadFunc, write WriteFunc) {
+	fs.add(name, &metricsNode{Node: nodefs.NewDefaultNode(), read: read, write: write})
+}
+
+// AddToggle adds a control file holding "0" or "1", as returned by
+// get. Writing to it calls set with the new value.
+func (fs *MetricsFs) AddToggle(name string, get func() bool, set func(bool)) {
+	fs.AddControl(name, func() []byte {
+		if get() {
+			return []byte("1\n")
+		}
+		return []byte("0\n")
+	}, func(data []byte) fuse.Status {
+		var v bool
+		switch strings.TrimSpace(string(data)) {
+		case "1", "true", "on":
+			v = true
+		case "0", "false", "off":
+			v = false
+		default:
+			return fuse.EINVAL
+		}
+		set(v)
+		return fuse.OK
+	})
+}
+
+// AddServer adds files describing a Server below dir:
+//
+//	debugdata: output of Server.DebugData
+//	kernel:    the INIT parameters negotiated with the kernel
+//	buffers:   buffer pool allocations, if the pool counts them
+//	debug:     toggle for debug output
+func (fs *MetricsFs) AddServer(dir string, s *fuse.Server) {
+	fs.Add(dir+"/debugdata", func() []byte {
+		return []byte(s.DebugData() + "\n")
+	})
+	fs.Add(dir+"/kernel", func() []byte {
+		k := s.KernelSettings()
+		return []byte(fmt.Sprintf("protocol %d.%d\nmax_readahead %d\nflags 0x%x\n",
+			k.Major, k.Minor, k.MaxReadAhead, k.Flags))
+	})
+	if p, ok := s.BufferPool().(bufferPoolStats); ok {
+		fs.Add(dir+"/buffers", func() []byte {
+			st := p.Stats()
+			return []byte(fmt.Sprintf("allocs %d\nfrees %d\noutstanding %d\n",
+				st.Allocs, st.Frees, st.Allocs-st.Frees))
+		})
+	}
+	fs.AddToggle(dir+"/debug", s.Debug, s.SetDebug)
+}
+
+// bufferPoolStats is implemented by the buffer pool of
+// fuse.NewBufferPool.
+type bufferPoolStats interface {
+	Stats() fuse.BufferPoolStats
+}
+
+// AddLatencies adds a file listing, for each operation, the number
+// of calls, and total and average latency.
+func (fs *MetricsFs) AddLatencies(name string, l LatencySource) {
+	fs.Add(name, func() []byte {
+		counts := l.Counts()
+		names := make([]string, 0, len(counts))
+		for k := range counts {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		var buf bytes.Buffer
+		for _, k := range names {
+			count, dt := l.Get(k)
+			var avg time.Duration
+			if count > 0 {
+				avg = dt / time.Duration(count)
+			}
+			fmt.Fprintf(&buf, "%s %d %v %v\n", k, count, dt, avg)
+		}
+		return buf.Bytes()
+	})
+}
+
+func (fs *MetricsFs) add(name string, node *metricsNode) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.mounted {
+		fs.pending[name] = node
+		return
+	}
+	fs.addNode(name, node)
+}
+
+func (fs *MetricsFs) addNode(name string, node *metricsNode) {
+	comps := strings.Split(strings.Trim(name, "/"), "/")
+	parent := fs.root.Inode()
+	for _, c := range comps[:len(comps)-1] {
+		child := parent.GetChild(c)
+		if child == nil {
+			child = parent.NewChild(c, true, nodefs.NewDefaultNode())
+		}
+		parent = child
+	}
+
+	last := comps[len(comps)-1]
+	parent.RmChild(last)
+	parent.NewChild(last, false, node)
+}
+
+func (n *metricsNode) Deletable() bool {
+	return false
+}
+
+func (n *metricsNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0444
+	if n.write != nil {
+		out.Mode |= 0200
+	}
+	if n.read != nil {
+		out.Size = uint64(len(n.read()))
+	}
+	return fuse.OK
+}
+
+func (n *metricsNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if flags&fuse.O_ANYWRITE != 0 {
+		if n.write == nil {
+			return nil, fuse.EPERM
+		}
+		// Writes go to metricsNode.Write.
+		return nil, fuse.OK
+	}
+
+	var data []byte
+	if n.read != nil {
+		data = n.read()
+	}
+	// Content changes all the time, so don't let the kernel
+	// cache it.
+	return &nodefs.WithFlags{
+		File:      nodefs.NewDataFile(data),
+		FuseFlags: fuse.FOPEN_DIRECT_IO,
+	}, fuse.OK
+}
+
+func (n *metricsNode) Truncate(file nodefs.File, size uint64, context *fuse.Context) fuse.Status {
+	if n.write == nil {
+		return fuse.EPERM
+	}
+	// Shell redirection opens with O_TRUNC; nothing to do.
+	return fuse.OK
+}
+
+func (n *metricsNode) Write(file nodefs.File, data []byte, off int64, context *fuse.Context) (uint32, fuse.Status) {
+	if n.write == nil {
+		return 0, fuse.EPERM
+	}
+	if code := n.write(data); !code.Ok() {
+		return 0, code
+	}
+	return uint32(len(data)), fuse.OK
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metricsfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+type fakeLatencies map[string]int
+
+func (l fakeLatencies) Counts() map[string]int {
+	return l
+}
+
+func (l fakeLatencies) Get(name string) (int, time.Duration) {
+	return l[name], time.Duration(l[name]) * time.Millisecond
+}
+
+func readNode(t *testing.T, fs *MetricsFs, conn *nodefs.FileSystemConnector, name string) string {
+	n, rest := conn.Node(fs.root.Inode(), name)
+	if len(rest) > 0 {
+		t.Fatalf("%s: not found, remaining %v", name, rest)
+	}
+	f, code := n.Node().Open(0, nil)
+	if !code.Ok() {
+		t.Fatalf("Open(%s): %v", name, code)
+	}
+	buf := make([]byte, 1024)
+	res, code := f.Read(buf, 0)
+	if !code.Ok() {
+		t.Fatalf("Read(%s): %v", name, code)
+	}
+	data, _ := res.Bytes(buf)
+	return string(data)
+}
+
+func TestMetricsFs(t *testing.T) {
+	fs := NewMetricsFs()
+	fs.AddLatencies("latencies", fakeLatencies{"LOOKUP": 2, "GETATTR": 1})
+
+	var debug bool
+	fs.AddToggle("ctl/debug", func() bool { return debug }, func(b bool) { debug = b })
+
+	conn := nodefs.NewFileSystemConnector(fs.Root(), nil)
+	fs.Root().OnMount(conn)
+
+	// Files added after mounting show up too.
+	fs.Add("ctl/version", func() []byte { return []byte("1\n") })
+
+	if got, want := readNode(t, fs, conn, "latencies"), "GETATTR 1 1ms 1ms\nLOOKUP 2 2ms 1ms\n"; got != want {
+		t.Errorf("latencies: got %q, want %q", got, want)
+	}
+	if got := readNode(t, fs, conn, "ctl/version"); got != "1\n" {
+		t.Errorf("version: got %q", got)
+	}
+
+	n, _ := conn.Node(fs.root.Inode(), "ctl/debug")
+	var a fuse.Attr
+	if code := n.Node().GetAttr(&a, nil, nil); !code.Ok() || a.Mode != fuse.S_IFREG|0644 || a.Size != 2 {
+		t.Errorf("GetAttr: %v %v", code, &a)
+	}
+	if _, code := n.Node().Write(nil, []byte("1\n"), 0, nil); !code.Ok() {
+		t.Fatalf("Write: %v", code)
+	}
+	if !debug {
+		t.Errorf("toggle did not call setter")
+	}
+	if got := readNode(t, fs, conn, "ctl/debug"); got != "1\n" {
+		t.Errorf("debug: got %q", got)
+	}
+
+	// The toggle shows the current value, however it was set.
+	debug = false
+	if got := readNode(t, fs, conn, "ctl/debug"); got != "0\n" {
+		t.Errorf("debug: got %q", got)
+	}
+	if _, code := n.Node().Write(nil, []byte("maybe"), 0, nil); code != fuse.EINVAL {
+		t.Errorf("Write garbage: got %v, want EINVAL", code)
+	}
+
+	ro, _ := conn.Node(fs.root.Inode(), "latencies")
+	if _, code := ro.Node().Open(fuse.O_ANYWRITE, nil); code != fuse.EPERM {
+		t.Errorf("Open for write: got %v, want EPERM", code)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	fs := NewMetricsFs()
+	c := NewCounters()
+	fs.AddCounters("counters", c)
+	conn := nodefs.NewFileSystemConnector(fs.Root(), nil)
+	fs.Root().OnMount(conn)
+
+	for _, r := range []*fuse.Intercepted{
+		{Header: &fuse.InHeader{Opcode: 1}, Status: fuse.ENOENT},
+		{Header: &fuse.InHeader{Opcode: 1}},
+		{Header: &fuse.InHeader{Opcode: 16}, In: &fuse.WriteIn{Size: 10}},
+		{Header: &fuse.InHeader{Opcode: 15}, Data: make([]byte, 7)},
+	} {
+		c.Before(r)
+		c.After(r)
+	}
+	want := "LOOKUP 2 1 0 0\nREAD 1 0 0 7\nWRITE 1 0 10 0\n"
+	if got := readNode(t, fs, conn, "counters"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCountersSnapshot(t *testing.T) {
+	c := NewCounters()
+	lookup := &fuse.Intercepted{Header: &fuse.InHeader{Opcode: 1}}
+	c.After(lookup)
+
+	s := c.Snapshot()
+	c.After(lookup)
+	if got := s.Ops["LOOKUP"]; got != (OpCounts{Requests: 1}) {
+		t.Errorf("Snapshot: got %+v", got)
+	}
+
+	s = c.Reset()
+	if got := s.Ops["LOOKUP"]; got != (OpCounts{Requests: 2}) {
+		t.Errorf("Reset: got %+v", got)
+	}
+	c.After(lookup)
+	if got := c.Snapshot().Ops["LOOKUP"]; got != (OpCounts{Requests: 1}) {
+		t.Errorf("after Reset: got %+v", got)
+	}
+}
@@ -27,29 +27,21 @@ func testZipFile() string {
 	return filepath.Join(dir, "test.zip")
 }
 
-func setupZipfs(t *testing.T) (mountPoint string, cleanup func()) {
+func setupZipfs(t *testing.T) (mountPoint string) {
 	root, err := NewArchiveFileSystem(testZipFile())
 	if err != nil {
 		t.Fatalf("NewArchiveFileSystem failed: %v", err)
 	}
 
-	mountPoint = testutil.TempDir()
-	state, _, err := nodefs.MountRoot(mountPoint, root, &nodefs.Options{
+	conn := nodefs.NewFileSystemConnector(root, &nodefs.Options{
 		Debug: testutil.VerboseTest(),
 	})
-
-	go state.Serve()
-	state.WaitMount()
-
-	return mountPoint, func() {
-		state.Unmount()
-		os.RemoveAll(mountPoint)
-	}
+	mountPoint, _ = testutil.Mount(t, conn.RawFS(), nil)
+	return mountPoint
 }
 
 func TestZipFs(t *testing.T) {
-	mountPoint, clean := setupZipfs(t)
-	defer clean()
+	mountPoint := setupZipfs(t)
 	entries, err := ioutil.ReadDir(mountPoint)
 	if err != nil {
 		t.Fatalf("ReadDir failed: %v", err)
@@ -105,8 +97,7 @@ func TestZipFs(t *testing.T) {
 }
 
 func TestLinkCount(t *testing.T) {
-	mp, clean := setupZipfs(t)
-	defer clean()
+	mp := setupZipfs(t)
 
 	fi, err := os.Stat(mp + "/file.txt")
 	if err != nil {